@@ -0,0 +1,103 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Kinds of user activity.
+const (
+	activityVideoCreated         = "video.created"
+	activityVideoDeleted         = "video.deleted"
+	activityVideoTrashed         = "video.trashed"
+	activityVideoRestored        = "video.restored"
+	activityUploadDone           = "upload.done"
+	activityUploadFailed         = "upload.failed"
+	activityModerationHold       = "video.moderation_hold"
+	activityLegalHold            = "video.legal_hold"
+	activityTenantChanged        = "account.tenant_changed"
+	activityAgeVerifiedChanged   = "account.age_verification_changed"
+	activityImpersonationStarted = "account.impersonation_started"
+	activityImpersonatedChange   = "account.impersonated_change"
+	activitySuspended            = "account.suspended"
+	activityUnsuspended          = "account.unsuspended"
+	activityQuotaChanged         = "account.storage_quota_changed"
+)
+
+// recordActivity adds an entry to userID's activity timeline. videoID and
+// actorID may be nil. The timeline is for support, so a failure to record
+// is only logged.
+func (cfg *apiConfig) recordActivity(userID uuid.UUID, kind string, videoID, actorID *uuid.UUID, detail string) {
+	err := cfg.db.RecordUserActivity(database.UserActivity{
+		UserID:     userID,
+		Kind:       kind,
+		VideoID:    videoID,
+		ActorID:    actorID,
+		Detail:     detail,
+		OccurredAt: cfg.clock.Now().UTC(),
+	})
+	if err != nil {
+		log.Printf("Couldn't record %s activity for user %s: %v", kind, userID, err)
+	}
+}
+
+// handlerAdminUserActivity pages through a user's activity, newest first.
+// ?limit= sets the page size and ?before= the next_before of the previous
+// page.
+func (cfg *apiConfig) handlerAdminUserActivity(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+	limit := 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > 500 {
+			respondWithError(w, http.StatusBadRequest, "limit must be between 1 and 500", err)
+			return
+		}
+	}
+	var before int64
+	if v := r.URL.Query().Get("before"); v != "" {
+		before, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || before < 1 {
+			respondWithError(w, http.StatusBadRequest, "Invalid pagination cursor", err)
+			return
+		}
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+
+	activity, err := cfg.db.GetUserActivity(userID, before, limit)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user activity", err)
+		return
+	}
+
+	type response struct {
+		Activity   []database.UserActivity `json:"activity"`
+		NextBefore int64                   `json:"next_before,omitempty"`
+	}
+	resp := response{Activity: activity}
+	if len(activity) == limit {
+		resp.NextBefore = activity[len(activity)-1].ID
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func parseAdminEmails(s string) map[string]bool {
+	emails := map[string]bool{}
+	for _, email := range strings.Split(s, ",") {
+		email = strings.ToLower(strings.TrimSpace(email))
+		if email != "" {
+			emails[email] = true
+		}
+	}
+	return emails
+}
+
+// isAdmin reports whether user is one of the configured admin accounts.
+func (cfg *apiConfig) isAdmin(user *database.User) bool {
+	return user != nil && cfg.adminEmails[strings.ToLower(user.Email)]
+}
+
+// requireAdmin validates the request's JWT and checks that it belongs to one
+// of the configured admin accounts. It writes an error response and returns
+// false if not. Requests oidcAdminMiddleware has let through are from an
+// admin already.
+func (cfg *apiConfig) requireAdmin(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	if adminID, ok := r.Context().Value(oidcAdminKey{}).(uuid.UUID); ok {
+		return adminID, true
+	}
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return uuid.Nil, false
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return uuid.Nil, false
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return uuid.Nil, false
+	}
+	if !cfg.isAdmin(user) {
+		respondWithError(w, http.StatusForbidden, "Admin access required", nil)
+		return uuid.Nil, false
+	}
+	return userID, true
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+var apiKeyNameLimit = textLimit{field: "name", maxRunes: 100, maxBytes: 400, required: true}
+
+// apiKeyPrefixLength is how much of a key is kept in the clear: the
+// "tubely_" prefix and the first 8 hex characters.
+const apiKeyPrefixLength = len(auth.APIKeyPrefix) + 8
+
+var errInvalidAPIKey = errors.New("invalid or revoked API key")
+
+// apiKeyHash is the hash an API key is stored and looked up by. With field
+// encryption on, it's peppered with the index key, so a copy of the
+// database alone isn't enough to check a guessed key.
+func (cfg *apiConfig) apiKeyHash(key string) string {
+	if hash := cfg.fields.Index("api_keys.key_hash", key); hash != "" {
+		return hash
+	}
+	return auth.HashAPIKey(key)
+}
+
+// apiKeyUser returns the user whose API key the request carries. It returns
+// errInvalidAPIKey if the key is unknown, revoked or its user is gone.
+func (cfg *apiConfig) apiKeyUser(r *http.Request) (*database.User, error) {
+	key, err := auth.GetAPIKey(r.Header)
+	if err != nil {
+		return nil, errInvalidAPIKey
+	}
+	apiKey, err := cfg.db.GetAPIKeyByHash(cfg.apiKeyHash(key))
+	if err != nil {
+		return nil, err
+	}
+	if apiKey == nil && cfg.fields != nil {
+		// Keys created before the pepper was set are stored by their plain
+		// hash until they're next used.
+		apiKey, err = cfg.db.GetAPIKeyByHash(auth.HashAPIKey(key))
+		if err != nil {
+			return nil, err
+		}
+		if apiKey != nil {
+			if err := cfg.db.SetAPIKeyHash(apiKey.ID, cfg.apiKeyHash(key)); err != nil {
+				log.Printf("Couldn't rehash API key %s: %v", apiKey.ID, err)
+			}
+		}
+	}
+	if apiKey == nil || apiKey.RevokedAt != nil {
+		return nil, errInvalidAPIKey
+	}
+	user, err := cfg.db.GetUser(apiKey.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, errInvalidAPIKey
+	}
+	if err := cfg.db.TouchAPIKey(apiKey.ID, cfg.clock.Now().UTC()); err != nil {
+		log.Printf("Couldn't record use of API key %s: %v", apiKey.ID, err)
+	}
+	return user, nil
+}
+
+// requireUploader authenticates an upload by access JWT or, for scripts
+// and bots, by an API key. Either way it acts as the owning user. If ok is
+// false, an error response has been written.
+func (cfg *apiConfig) requireUploader(w http.ResponseWriter, r *http.Request) (userID uuid.UUID, tenantID string, ok bool) {
+	if auth.HasAPIKey(r.Header) {
+		user, err := cfg.apiKeyUser(r)
+		if errors.Is(err, errInvalidAPIKey) {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't validate API key", err)
+			return uuid.Nil, "", false
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get API key", err)
+			return uuid.Nil, "", false
+		}
+		return user.ID, user.TenantID, true
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return uuid.Nil, "", false
+	}
+	userID, tenantID, err = auth.ValidateTenantJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return uuid.Nil, "", false
+	}
+	return userID, tenantID, true
+}
+
+// handlerAPIKeyCreate creates an API key for the user. The key is only
+// ever in this response; afterwards just its prefix is shown. Impersonation
+// tokens can't create keys.
+func (cfg *apiConfig) handlerAPIKeyCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name string `json:"name"`
+	}
+	type response struct {
+		database.APIKey
+		Key string `json:"key"`
+	}
+
+	userID, ok := cfg.requireOwnSession(w, r)
+	if !ok {
+		return
+	}
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	name, err := apiKeyNameLimit.apply(params.Name)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	key, err := auth.MakeAPIKey()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create API key", err)
+		return
+	}
+	apiKey := database.APIKey{
+		ID:        uuid.New(),
+		UserID:    userID,
+		Name:      name,
+		KeyHash:   cfg.apiKeyHash(key),
+		Prefix:    key[:apiKeyPrefixLength],
+		CreatedAt: cfg.clock.Now().UTC(),
+	}
+	if err := cfg.db.CreateAPIKey(apiKey); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save API key", err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, response{APIKey: apiKey, Key: key})
+}
+
+// handlerAPIKeysGet lists the user's API keys, revoked ones included.
+func (cfg *apiConfig) handlerAPIKeysGet(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.requireUser(w, r)
+	if !ok {
+		return
+	}
+	keys, err := cfg.db.GetAPIKeys(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get API keys", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, keys)
+}
+
+// handlerAPIKeyRevoke revokes one of the user's API keys. Uploads with it
+// fail from then on.
+func (cfg *apiConfig) handlerAPIKeyRevoke(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.requireOwnSession(w, r)
+	if !ok {
+		return
+	}
+	keyID, err := uuid.Parse(r.PathValue("keyID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid API key ID", err)
+		return
+	}
+	apiKey, err := cfg.db.GetAPIKey(keyID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get API key", err)
+		return
+	}
+	if apiKey == nil || apiKey.UserID != userID {
+		respondWithError(w, http.StatusNotFound, "API key not found", nil)
+		return
+	}
+	if err := cfg.db.RevokeAPIKey(apiKey.ID, cfg.clock.Now().UTC()); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke API key", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
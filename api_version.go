@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// apiVersion is a version of the JSON API. Version 0 is the unversioned API
+// current clients use, which responds with bare payloads and
+// {"error","code"} errors. From version 1 every JSON response is wrapped in
+// an apiEnvelope. Handlers are shared across versions; one whose behavior
+// has to change in a later version can branch on requestAPIVersion.
+type apiVersion int
+
+const (
+	apiVersionLegacy apiVersion = 0
+	apiVersionLatest apiVersion = 1
+)
+
+func parseAPIVersion(s string) (apiVersion, error) {
+	switch s {
+	case "legacy":
+		return apiVersionLegacy, nil
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(s, "v"))
+	if err != nil || n < 1 || apiVersion(n) > apiVersionLatest {
+		return 0, fmt.Errorf("unknown API version %q, expected legacy or v1", s)
+	}
+	return apiVersion(n), nil
+}
+
+type apiVersionKey struct{}
+
+type requestIDKey struct{}
+
+// requestAPIVersion is the API version r was made against.
+func requestAPIVersion(r *http.Request) apiVersion {
+	v, _ := r.Context().Value(apiVersionKey{}).(apiVersion)
+	return v
+}
+
+// requestID is the ID requestIDMiddleware gave r.
+func requestID(r *http.Request) string {
+	return contextRequestID(r.Context())
+}
+
+// contextRequestID is the request ID ctx carries, or "" if it has none.
+func contextRequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// withRequestID returns ctx carrying the request ID id, for background work
+// to log under the ID of the request that started it.
+func withRequestID(ctx context.Context, id string) context.Context {
+	if id == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// requestIDMiddleware gives every request an ID, returned in the
+// X-Request-ID header so a client can quote it in a bug report. An ID sent
+// by a proxy in front of the server is kept.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID.MatchString(id) {
+			id = uuid.NewString()
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// apiVersionMiddleware serves /api/vN/... with the handlers for /api/...,
+// recording the version on the request. Unversioned /api/... requests are
+// served as defaultVersion, which stays legacy until current clients have
+// moved to a versioned path.
+func apiVersionMiddleware(defaultVersion apiVersion, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rest, ok := strings.CutPrefix(r.URL.Path, "/api/")
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		version := defaultVersion
+		if segment, path, found := strings.Cut(rest, "/"); found && len(segment) > 1 && segment[0] == 'v' {
+			if n, err := strconv.Atoi(segment[1:]); err == nil {
+				if n < 1 || apiVersion(n) > apiVersionLatest {
+					ew := &envelopeWriter{ResponseWriter: w}
+					respondWithError(ew, http.StatusNotFound, "Unknown API version", nil)
+					ew.finish(r)
+					return
+				}
+				version = apiVersion(n)
+				r = r.Clone(r.Context())
+				r.URL.Path = "/api/" + path
+				r.URL.RawPath = ""
+			}
+		}
+
+		r = r.WithContext(context.WithValue(r.Context(), apiVersionKey{}, version))
+		if version == apiVersionLegacy {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ew := &envelopeWriter{ResponseWriter: w}
+		next.ServeHTTP(ew, r)
+		ew.finish(r)
+	})
+}
+
+// apiEnvelope wraps every JSON response from version 1 on. Exactly one of
+// Data and Error is set.
+type apiEnvelope struct {
+	Data       json.RawMessage `json:"data"`
+	Error      *apiError       `json:"error"`
+	RequestID  string          `json:"request_id"`
+	Pagination *apiPagination  `json:"pagination,omitempty"`
+}
+
+type apiError struct {
+	Message string `json:"message"`
+	Code    string `json:"code"`
+	// Details holds any other fields of the error, such as
+	// retry_after_seconds.
+	Details map[string]json.RawMessage `json:"details,omitempty"`
+}
+
+// apiPagination describes the page of a list response. Lists are paged
+// with the page and per_page query parameters.
+type apiPagination struct {
+	Page       int `json:"page"`
+	PerPage    int `json:"per_page"`
+	Total      int `json:"total"`
+	TotalPages int `json:"total_pages"`
+}
+
+const (
+	defaultPerPage = 100
+	maxPerPage     = 1000
+)
+
+// envelopeWriter holds back JSON responses so they can be wrapped once the
+// handler is done. Anything else, such as redirects, media and event
+// streams, is passed through as written.
+type envelopeWriter struct {
+	http.ResponseWriter
+	status    int
+	buffering bool
+	body      bytes.Buffer
+}
+
+func (ew *envelopeWriter) WriteHeader(status int) {
+	if ew.status != 0 {
+		return
+	}
+	ew.status = status
+	ew.buffering = strings.HasPrefix(ew.Header().Get("Content-Type"), "application/json")
+	if !ew.buffering {
+		ew.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (ew *envelopeWriter) Write(p []byte) (int, error) {
+	if ew.status == 0 {
+		ew.WriteHeader(http.StatusOK)
+	}
+	if ew.buffering {
+		return ew.body.Write(p)
+	}
+	return ew.ResponseWriter.Write(p)
+}
+
+func (ew *envelopeWriter) Flush() {
+	if f, ok := ew.ResponseWriter.(http.Flusher); ok && !ew.buffering {
+		f.Flush()
+	}
+}
+
+func (ew *envelopeWriter) Unwrap() http.ResponseWriter {
+	return ew.ResponseWriter
+}
+
+func (ew *envelopeWriter) finish(r *http.Request) {
+	if !ew.buffering {
+		return
+	}
+	envelope := apiEnvelope{RequestID: requestID(r)}
+	body := bytes.TrimSpace(ew.body.Bytes())
+	if ew.status >= 400 {
+		envelope.Error = parseAPIError(body)
+	} else {
+		envelope.Data = json.RawMessage(body)
+		if len(body) > 0 && body[0] == '[' {
+			envelope.Data, envelope.Pagination = paginate(r, body)
+		}
+	}
+	if len(envelope.Data) == 0 {
+		envelope.Data = json.RawMessage("null")
+	}
+
+	dat, err := json.Marshal(envelope)
+	if err != nil {
+		ew.ResponseWriter.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	ew.Header().Del("Content-Length")
+	ew.ResponseWriter.WriteHeader(ew.status)
+	ew.ResponseWriter.Write(dat)
+}
+
+// parseAPIError converts the body written by respondWithError, or by a
+// handler that adds fields to it, into the envelope's error.
+func parseAPIError(body []byte) *apiError {
+	fields := map[string]json.RawMessage{}
+	apiErr := &apiError{}
+	if err := json.Unmarshal(body, &fields); err != nil {
+		apiErr.Message = string(body)
+		return apiErr
+	}
+	json.Unmarshal(fields["error"], &apiErr.Message)
+	json.Unmarshal(fields["code"], &apiErr.Code)
+	delete(fields, "error")
+	delete(fields, "code")
+	if len(fields) > 0 {
+		apiErr.Details = fields
+	}
+	return apiErr
+}
+
+// paginate returns the requested page of a JSON array.
+func paginate(r *http.Request, body []byte) (json.RawMessage, *apiPagination) {
+	var items []json.RawMessage
+	if err := json.Unmarshal(body, &items); err != nil {
+		return body, nil
+	}
+	p := &apiPagination{Page: 1, PerPage: defaultPerPage, Total: len(items)}
+	if n, err := strconv.Atoi(r.URL.Query().Get("page")); err == nil && n > 0 {
+		p.Page = n
+	}
+	if n, err := strconv.Atoi(r.URL.Query().Get("per_page")); err == nil && n > 0 {
+		p.PerPage = min(n, maxPerPage)
+	}
+	p.TotalPages = (p.Total + p.PerPage - 1) / p.PerPage
+
+	start := p.Total
+	if p.Page <= p.TotalPages {
+		start = (p.Page - 1) * p.PerPage
+	}
+	end := min(start+p.PerPage, p.Total)
+	page, err := json.Marshal(items[start:end])
+	if err != nil {
+		return body, nil
+	}
+	return page, p
+}
@@ -179,7 +179,8 @@ async function uploadVideoFile(videoID) {
       throw new Error(`Failed to upload video file. Error: ${data.error}`);
     }
 
-    console.log("Video uploaded!");
+    console.log("Video uploaded, processing...");
+    await waitForProcessing(videoID);
     await getVideo(videoID);
   } catch (error) {
     alert(`Error: ${error.message}`);
@@ -188,6 +189,28 @@ async function uploadVideoFile(videoID) {
   setUploadButtonState(false, uploadBtnSelector);
 }
 
+async function waitForProcessing(videoID) {
+  for (;;) {
+    const res = await fetch(`/api/videos/${videoID}/status`, {
+      headers: {
+        Authorization: `Bearer ${localStorage.getItem("token")}`,
+      },
+    });
+    if (!res.ok) {
+      const data = await res.json();
+      throw new Error(`Failed to get processing status. Error: ${data.error}`);
+    }
+    const status = await res.json();
+    if (status.processing_status === "ready") {
+      return;
+    }
+    if (status.processing_status === "failed") {
+      throw new Error(`Failed to process video. Error: ${status.processing_error}`);
+    }
+    await new Promise((resolve) => setTimeout(resolve, 2000));
+  }
+}
+
 const videoStateHandler = createVideoStateHandler();
 
 async function getVideos() {
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ffmpeg"
+)
+
+// autoThumbnail says which frame of an uploaded video becomes its thumbnail
+// when the video doesn't have one yet.
+type autoThumbnail struct {
+	// Off disables extraction.
+	Off bool
+	// Scene lets ffmpeg pick the most representative frame near the start
+	// of the video. Otherwise the frame at Seconds is used.
+	Scene   bool
+	Seconds float64
+}
+
+// parseAutoThumbnail parses the AUTO_THUMBNAIL setting: "scene", "off", or a
+// timestamp in seconds.
+func parseAutoThumbnail(s string) (autoThumbnail, error) {
+	switch s = strings.ToLower(strings.TrimSpace(s)); s {
+	case "", "scene":
+		return autoThumbnail{Scene: true}, nil
+	case "off":
+		return autoThumbnail{Off: true}, nil
+	}
+	seconds, err := strconv.ParseFloat(s, 64)
+	if err != nil || seconds < 0 || math.IsInf(seconds, 0) || math.IsNaN(seconds) {
+		return autoThumbnail{}, fmt.Errorf("%q must be scene, off, or a non-negative timestamp in seconds", s)
+	}
+	return autoThumbnail{Seconds: seconds}, nil
+}
+
+// command returns the ffmpeg command that writes the thumbnail frame of the
+// video at input, which is duration seconds long, to output. Scene
+// detection skips the first tenth of the video, where intros and fades to
+// black tend to be; timestamps past the end take the middle frame instead.
+func (a autoThumbnail) command(input, output string, duration float64) *ffmpeg.Cmd {
+	if a.Scene {
+		return ffmpeg.SceneFrameCommand(input, output, duration/10)
+	}
+	seconds := a.Seconds
+	if seconds >= duration {
+		seconds = duration / 2
+	}
+	return ffmpeg.FrameCommand(input, output, seconds)
+}
+
+// extractThumbnail saves a frame of the video at path as a thumbnail, the
+// same way an uploaded one is saved. Like an uploaded thumbnail, the file is
+// removed again if cleanup runs without being committed.
+func (cfg *apiConfig) extractThumbnail(r *http.Request, cleanup *cleanupStack, path string, duration float64) (savedThumbnail, error) {
+	ctx := r.Context()
+	start := time.Now()
+	tempFile, err := os.CreateTemp("", "tubely-thumbnail-*.jpg")
+	if err != nil {
+		return savedThumbnail{}, err
+	}
+	tempFile.Close()
+	defer os.Remove(tempFile.Name())
+
+	_, err = cfg.autoThumbnail.command(path, tempFile.Name(), duration).Run(ctx)
+	logStep(ctx, "thumbnail", "extract thumbnail", start, err)
+	if err != nil {
+		return savedThumbnail{}, err
+	}
+
+	frame, err := os.Open(tempFile.Name())
+	if err != nil {
+		return savedThumbnail{}, err
+	}
+	defer frame.Close()
+	rec := &errorRecorder{ResponseWriter: &discardResponseWriter{}}
+	thumbnail, ok := cfg.saveThumbnail(rec, r, frame, "image/jpeg", thumbnailChecksums{}, cleanup)
+	if !ok {
+		return savedThumbnail{}, errors.New(rec.message())
+	}
+	return thumbnail, nil
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// Backups of the metadata database are stored in the default bucket under
+// backupPrefix, named by when they were taken so they sort by age.
+const (
+	backupPrefix     = "backups/db/"
+	backupTimeFormat = "20060102T150405Z"
+	backupSuffix     = ".db.enc"
+)
+
+type databaseBackup struct {
+	Key       string    `json:"key"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// encryptBackup seals a snapshot with AES-256-GCM under key. The nonce is
+// prepended to the result.
+func encryptBackup(key, plaintext []byte) ([]byte, error) {
+	gcm, err := backupCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func decryptBackup(key, sealed []byte) ([]byte, error) {
+	gcm, err := backupCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("backup is truncated")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, errors.New("backup can't be decrypted with DB_BACKUP_KEY")
+	}
+	return plaintext, nil
+}
+
+func backupCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// backupDatabase snapshots the database, uploads it encrypted and then
+// deletes all but the newest cfg.backupRetention backups.
+func (cfg *apiConfig) backupDatabase(ctx context.Context) (databaseBackup, error) {
+	dir, err := os.MkdirTemp("", "tubely-backup-*")
+	if err != nil {
+		return databaseBackup{}, err
+	}
+	defer os.RemoveAll(dir)
+
+	snapshot := filepath.Join(dir, "tubely.db")
+	if err := cfg.db.Backup(snapshot); err != nil {
+		return databaseBackup{}, fmt.Errorf("couldn't snapshot database: %w", err)
+	}
+	plaintext, err := os.ReadFile(snapshot)
+	if err != nil {
+		return databaseBackup{}, err
+	}
+	sealed, err := encryptBackup(cfg.backupKey, plaintext)
+	if err != nil {
+		return databaseBackup{}, err
+	}
+
+	target, err := cfg.tenants.Target(ctx, "")
+	if err != nil {
+		return databaseBackup{}, err
+	}
+	now := cfg.clock.Now().UTC()
+	backup := databaseBackup{
+		Key:       backupPrefix + now.Format(backupTimeFormat) + backupSuffix,
+		Size:      int64(len(sealed)),
+		CreatedAt: now.Truncate(time.Second),
+	}
+	// Backups are encrypted at rest even where the bucket isn't configured
+	// to be.
+	err = putObject(ctx, target, backup.Key, bytes.NewReader(sealed), "application/octet-stream", func(input *s3.PutObjectInput) {
+		if input.ServerSideEncryption == "" {
+			input.ServerSideEncryption = types.ServerSideEncryptionAes256
+		}
+	})
+	if err != nil {
+		return databaseBackup{}, fmt.Errorf("couldn't upload backup: %w", err)
+	}
+
+	if err := cfg.pruneDatabaseBackups(ctx); err != nil {
+		log.Printf("Couldn't prune database backups: %v", err)
+	}
+	return backup, nil
+}
+
+// listDatabaseBackups returns the stored backups, newest first.
+func (cfg *apiConfig) listDatabaseBackups(ctx context.Context) ([]databaseBackup, error) {
+	target, err := cfg.tenants.Target(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	backups := []databaseBackup{}
+	paginator := s3.NewListObjectsV2Paginator(target.Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(target.Bucket),
+		Prefix: aws.String(backupPrefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range page.Contents {
+			key := aws.ToString(obj.Key)
+			name := strings.TrimSuffix(strings.TrimPrefix(key, backupPrefix), backupSuffix)
+			createdAt, err := time.Parse(backupTimeFormat, name)
+			if err != nil {
+				continue
+			}
+			backups = append(backups, databaseBackup{Key: key, Size: aws.ToInt64(obj.Size), CreatedAt: createdAt})
+		}
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].CreatedAt.After(backups[j].CreatedAt) })
+	return backups, nil
+}
+
+func (cfg *apiConfig) pruneDatabaseBackups(ctx context.Context) error {
+	backups, err := cfg.listDatabaseBackups(ctx)
+	if err != nil {
+		return err
+	}
+	if len(backups) <= cfg.backupRetention {
+		return nil
+	}
+	target, err := cfg.tenants.Target(ctx, "")
+	if err != nil {
+		return err
+	}
+	for _, backup := range backups[cfg.backupRetention:] {
+		_, err := target.Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String(target.Bucket),
+			Key:    aws.String(backup.Key),
+		})
+		if err != nil {
+			return fmt.Errorf("couldn't delete %s: %w", backup.Key, err)
+		}
+	}
+	return nil
+}
+
+func (cfg *apiConfig) handlerAdminBackupsList(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+	if cfg.backupKey == nil {
+		respondWithError(w, http.StatusNotFound, "Database backups are not configured", nil)
+		return
+	}
+	backups, err := cfg.listDatabaseBackups(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Couldn't list database backups", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, backups)
+}
+
+// handlerAdminBackupCreate takes a backup now, e.g. before a risky
+// migration, in addition to the scheduled ones.
+func (cfg *apiConfig) handlerAdminBackupCreate(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+	if cfg.backupKey == nil {
+		respondWithError(w, http.StatusNotFound, "Database backups are not configured", nil)
+		return
+	}
+	backup, err := cfg.backupDatabase(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Database backup failed", err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, backup)
+}
+
+// runRestoreCommand restores the database at dbPath from a backup:
+//
+//	tubely restore-db [-key backups/db/...] [-force]
+//
+// The server must be stopped first. The newest backup is used unless -key
+// picks another. Before anything is replaced, the dead link sweep checks the
+// backup against the buckets and records what it finds in the restored
+// database, so its dead link report is accurate from the start; if any
+// video file it points at is gone the restore stops, unless -force is
+// given. The current database is kept next to the restored one.
+func (cfg *apiConfig) runRestoreCommand(ctx context.Context, dbPath string, args []string) error {
+	fs := flag.NewFlagSet("restore-db", flag.ContinueOnError)
+	key := fs.String("key", "", "backup to restore; defaults to the newest")
+	force := fs.Bool("force", false, "restore even if video files the backup points at are missing")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if cfg.backupKey == nil {
+		return errors.New("DB_BACKUP_KEY must be set to restore a backup")
+	}
+
+	if *key == "" {
+		backups, err := cfg.listDatabaseBackups(ctx)
+		if err != nil {
+			return fmt.Errorf("couldn't list backups: %w", err)
+		}
+		if len(backups) == 0 {
+			return errors.New("no backups found")
+		}
+		*key = backups[0].Key
+	}
+
+	target, err := cfg.tenants.Target(ctx, "")
+	if err != nil {
+		return err
+	}
+	obj, err := target.Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(target.Bucket),
+		Key:    aws.String(*key),
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't download %s: %w", *key, err)
+	}
+	sealed, err := io.ReadAll(obj.Body)
+	obj.Body.Close()
+	if err != nil {
+		return fmt.Errorf("couldn't download %s: %w", *key, err)
+	}
+	plaintext, err := decryptBackup(cfg.backupKey, sealed)
+	if err != nil {
+		return err
+	}
+
+	// Written next to the database so putting it in place is a rename.
+	restoredPath := dbPath + ".restoring"
+	if err := os.WriteFile(restoredPath, plaintext, 0o600); err != nil {
+		return err
+	}
+	keep := false
+	defer func() {
+		if !keep {
+			os.Remove(restoredPath)
+		}
+	}()
+	restored, err := database.NewClient(restoredPath)
+	if err != nil {
+		return fmt.Errorf("backup isn't a usable database: %w", err)
+	}
+
+	check := *cfg
+	check.db = restored
+	result, err := check.sweepDeadLinks(ctx)
+	if err != nil {
+		restored.Close()
+		return fmt.Errorf("couldn't check backup against storage: %w", err)
+	}
+	broken, err := restored.GetBrokenLinks()
+	restored.Close()
+	if err != nil {
+		return err
+	}
+	missing := 0
+	for _, link := range broken {
+		if link.Kind == "video" {
+			missing++
+			log.Printf("Video %s: %s: %s", link.VideoID, link.URL, link.Detail)
+		}
+	}
+	log.Printf("Checked %d links in %s, %d broken, %d of them video files", result.Checked, *key, result.Broken, missing)
+	if missing > 0 && !*force {
+		return fmt.Errorf("%d video files the backup points at are missing; rerun with -force to restore anyway", missing)
+	}
+
+	previous, err := cfg.swapDatabase(dbPath, restoredPath, "restore")
+	if err != nil {
+		return err
+	}
+	keep = true
+	log.Printf("Restored %s to %s; the previous database is at %s", *key, dbPath, previous)
+	return nil
+}
+
+// swapDatabase closes the database and puts the one at newPath in its
+// place, keeping the old one next to it. It returns where the old one is.
+func (cfg *apiConfig) swapDatabase(dbPath, newPath, reason string) (string, error) {
+	if err := cfg.db.Close(); err != nil {
+		return "", err
+	}
+	previous := dbPath + ".before-" + reason + "-" + cfg.clock.Now().UTC().Format(backupTimeFormat)
+	if err := os.Rename(dbPath, previous); err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	if err := os.Rename(newPath, dbPath); err != nil {
+		return "", err
+	}
+	return previous, nil
+}
@@ -0,0 +1,480 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tenants"
+	"github.com/google/uuid"
+)
+
+// Tenants can brand the pages and messages the server generates for their
+// videos: the embed player, series feeds and notifications. The logo is an
+// asset, uploaded and stored like a thumbnail, and handed out through a
+// stable URL that redirects to a fresh one.
+
+var colorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// watermarkPositions are the corners of the embed player the logo can be
+// shown in.
+var watermarkPositions = map[string]bool{
+	"top-left":     true,
+	"top-right":    true,
+	"bottom-left":  true,
+	"bottom-right": true,
+}
+
+const defaultWatermarkOpacity = 0.5
+
+// feedTitleSeries is replaced with the series' title in a tenant's feed
+// title.
+const feedTitleSeries = "{series}"
+
+var feedTitleLimit = textLimit{field: "feed_title", maxRunes: 200, maxBytes: 800}
+
+// userBranding returns the branding of the user's tenant, or nil if they
+// have no tenant or it has no branding.
+func (cfg *apiConfig) userBranding(userID uuid.UUID) (*database.TenantBranding, error) {
+	user, err := cfg.db.GetUser(userID)
+	if err != nil || user == nil || user.TenantID == "" {
+		return nil, err
+	}
+	return cfg.db.GetTenantBranding(user.TenantID)
+}
+
+// brandingLogoURL is the stable URL of a tenant's logo, or "" if it has
+// none.
+func (cfg *apiConfig) brandingLogoURL(branding *database.TenantBranding) string {
+	if branding == nil || branding.LogoURL == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s/api/tenants/%s/branding/logo?v=%d", cfg.siteURL, url.PathEscape(branding.TenantID), branding.UpdatedAt.Unix())
+}
+
+// feedTitle is the title of the feed of a series, as the tenant's branding
+// words it.
+func feedTitle(branding *database.TenantBranding, seriesTitle string) string {
+	if branding == nil || branding.FeedTitle == "" {
+		return seriesTitle
+	}
+	return strings.ReplaceAll(branding.FeedTitle, feedTitleSeries, seriesTitle)
+}
+
+type brandingResponse struct {
+	database.TenantBranding
+	LogoURL string `json:"logo_url,omitempty"`
+}
+
+func (cfg *apiConfig) respondWithBranding(w http.ResponseWriter, branding database.TenantBranding) {
+	respondWithJSON(w, http.StatusOK, brandingResponse{TenantBranding: branding, LogoURL: cfg.brandingLogoURL(&branding)})
+}
+
+// tenantBranding returns the tenant's branding, or an empty one if it has
+// none yet.
+func (cfg *apiConfig) tenantBranding(tenantID string) (database.TenantBranding, error) {
+	branding, err := cfg.db.GetTenantBranding(tenantID)
+	if err != nil || branding == nil {
+		return database.TenantBranding{TenantID: tenantID}, err
+	}
+	return *branding, nil
+}
+
+func (cfg *apiConfig) handlerAdminTenantBrandingGet(w http.ResponseWriter, r *http.Request) {
+	tenantID, ok := cfg.requireTenantInPath(w, r)
+	if !ok {
+		return
+	}
+	branding, err := cfg.db.GetTenantBranding(tenantID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get branding", err)
+		return
+	}
+	if branding == nil {
+		respondWithError(w, http.StatusNotFound, "Tenant has no branding", nil)
+		return
+	}
+	cfg.respondWithBranding(w, *branding)
+}
+
+// handlerAdminTenantBrandingSet replaces a tenant's colors, watermark and
+// feed title. The logo is kept; it's uploaded on its own.
+func (cfg *apiConfig) handlerAdminTenantBrandingSet(w http.ResponseWriter, r *http.Request) {
+	tenantID, ok := cfg.requireTenantInPath(w, r)
+	if !ok {
+		return
+	}
+
+	type parameters struct {
+		PrimaryColor      string   `json:"primary_color"`
+		BackgroundColor   string   `json:"background_color"`
+		WatermarkPosition string   `json:"watermark_position"`
+		WatermarkOpacity  *float64 `json:"watermark_opacity"`
+		FeedTitle         string   `json:"feed_title"`
+	}
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	for _, color := range []string{params.PrimaryColor, params.BackgroundColor} {
+		if color != "" && !colorPattern.MatchString(color) {
+			respondWithError(w, http.StatusBadRequest, "Colors must be hex colors such as #1a73e8", fmt.Errorf("invalid color %q", color))
+			return
+		}
+	}
+	if params.WatermarkPosition != "" && !watermarkPositions[params.WatermarkPosition] {
+		respondWithError(w, http.StatusBadRequest, "watermark_position must be top-left, top-right, bottom-left or bottom-right", fmt.Errorf("unknown watermark position %q", params.WatermarkPosition))
+		return
+	}
+	opacity := 0.0
+	if params.WatermarkPosition != "" {
+		opacity = defaultWatermarkOpacity
+		if params.WatermarkOpacity != nil {
+			opacity = *params.WatermarkOpacity
+		}
+		if opacity <= 0 || opacity > 1 {
+			respondWithError(w, http.StatusBadRequest, "watermark_opacity must be above 0 and at most 1", nil)
+			return
+		}
+	}
+	title, err := feedTitleLimit.apply(params.FeedTitle)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	branding, err := cfg.tenantBranding(tenantID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get branding", err)
+		return
+	}
+	branding.PrimaryColor = strings.ToLower(params.PrimaryColor)
+	branding.BackgroundColor = strings.ToLower(params.BackgroundColor)
+	branding.WatermarkPosition = params.WatermarkPosition
+	branding.WatermarkOpacity = opacity
+	branding.FeedTitle = title
+	branding.UpdatedAt = cfg.clock.Now().UTC()
+	if err := cfg.db.SaveTenantBranding(branding); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save branding", err)
+		return
+	}
+	cfg.respondWithBranding(w, branding)
+}
+
+// handlerAdminTenantBrandingDelete removes a tenant's branding and its
+// logo, so its pages and messages look like everyone else's again.
+func (cfg *apiConfig) handlerAdminTenantBrandingDelete(w http.ResponseWriter, r *http.Request) {
+	tenantID, ok := cfg.requireTenantInPath(w, r)
+	if !ok {
+		return
+	}
+	branding, err := cfg.db.GetTenantBranding(tenantID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get branding", err)
+		return
+	}
+	if branding == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err := cfg.db.DeleteTenantBranding(tenantID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete branding", err)
+		return
+	}
+	cfg.deleteBrandingLogo(*branding)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerAdminTenantBrandingLogoSet uploads a tenant's logo, sent as the
+// "logo" form field, a JPEG, PNG or HEIC image checked and stored like a
+// thumbnail. It replaces the previous logo.
+func (cfg *apiConfig) handlerAdminTenantBrandingLogoSet(w http.ResponseWriter, r *http.Request) {
+	tenantID, ok := cfg.requireTenantInPath(w, r)
+	if !ok {
+		return
+	}
+
+	const maxMemory = 10 << 20
+	if err := r.ParseMultipartForm(maxMemory); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Error parsing form data", err)
+		return
+	}
+	file, header, err := r.FormFile("logo")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Unable to parse form file", err)
+		return
+	}
+	defer file.Close()
+
+	cleanup := &cleanupStack{}
+	defer cleanup.run()
+	logo, ok := cfg.saveThumbnail(w, r, file, header.Header.Get("Content-Type"), thumbnailChecksums{}, cleanup)
+	if !ok {
+		return
+	}
+
+	branding, err := cfg.tenantBranding(tenantID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get branding", err)
+		return
+	}
+	previous := branding
+	branding.LogoURL = logo.URL
+	branding.UpdatedAt = cfg.clock.Now().UTC()
+	if err := cfg.db.SaveTenantBranding(branding); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save branding", err)
+		return
+	}
+	cleanup.commit()
+	cfg.deleteBrandingLogo(previous)
+	cfg.respondWithBranding(w, branding)
+}
+
+// handlerAdminTenantBrandingLogoDelete removes a tenant's logo, and with it
+// the player watermark.
+func (cfg *apiConfig) handlerAdminTenantBrandingLogoDelete(w http.ResponseWriter, r *http.Request) {
+	tenantID, ok := cfg.requireTenantInPath(w, r)
+	if !ok {
+		return
+	}
+	branding, err := cfg.tenantBranding(tenantID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get branding", err)
+		return
+	}
+	if branding.LogoURL == "" {
+		respondWithError(w, http.StatusNotFound, "Tenant has no logo", nil)
+		return
+	}
+	previous := branding
+	branding.LogoURL = ""
+	branding.UpdatedAt = cfg.clock.Now().UTC()
+	if err := cfg.db.SaveTenantBranding(branding); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save branding", err)
+		return
+	}
+	cfg.deleteBrandingLogo(previous)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteBrandingLogo removes the logo asset of branding as it was before a
+// change. A failure only leaves the old file behind, so it's logged.
+func (cfg *apiConfig) deleteBrandingLogo(branding database.TenantBranding) {
+	name, ok := cfg.thumbnailAssetName(branding.LogoURL)
+	if !ok {
+		return
+	}
+	if err := cfg.deleteAsset(context.Background(), name); err != nil {
+		log.Printf("Couldn't delete previous logo of tenant %s: %v", branding.TenantID, err)
+	}
+}
+
+// handlerTenantBrandingLogo gives a tenant's logo a stable URL, for feeds,
+// notifications and the embed player. It redirects to a fresh one.
+func (cfg *apiConfig) handlerTenantBrandingLogo(w http.ResponseWriter, r *http.Request) {
+	tenantID := r.PathValue("tenantID")
+	if !cfg.tenants.Exists(tenantID) {
+		respondWithError(w, http.StatusNotFound, "Unknown tenant", nil)
+		return
+	}
+	branding, err := cfg.db.GetTenantBranding(tenantID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get branding", err)
+		return
+	}
+	if branding == nil || branding.LogoURL == "" {
+		respondWithError(w, http.StatusNotFound, "Tenant has no logo", nil)
+		return
+	}
+	logoURL := cfg.cdnAssetURL(branding.LogoURL)
+	if strings.HasPrefix(branding.LogoURL, thumbnailPrefix) {
+		logoURL, err = cfg.expiringDeliveryURL(r.Context(), cfg.tenants.Defaults(), branding.LogoURL, cfg.publicURLExpiry)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't sign logo URL", err)
+			return
+		}
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, logoURL, http.StatusFound)
+}
+
+var embedTemplate = template.Must(template.New("embed").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+html, body { margin: 0; height: 100%; background: {{.BackgroundColor}}; }
+.player { position: relative; width: 100%; height: 100%; }
+video { display: block; width: 100%; height: 100%; accent-color: {{.PrimaryColor}}; }
+.watermark { position: absolute; max-width: 15%; max-height: 15%; opacity: {{.WatermarkOpacity}}; pointer-events: none; }
+.top-left { top: 4%; left: 3%; }
+.top-right { top: 4%; right: 3%; }
+.bottom-left { bottom: 12%; left: 3%; }
+.bottom-right { bottom: 12%; right: 3%; }
+.countdown { display: flex; flex-direction: column; align-items: center; justify-content: center; height: 100%; color: #ffffff; font-family: sans-serif; }
+.countdown p { margin: 0; opacity: 0.8; }
+.countdown time { font-size: 3em; font-variant-numeric: tabular-nums; color: {{.PrimaryColor}}; }
+</style>
+</head>
+<body>
+<div class="player">
+{{- if .Countdown}}
+<div class="countdown">
+<p>Premieres in</p>
+<time id="countdown" datetime="{{.StartsAt}}"></time>
+</div>
+<script>
+(function () {
+  var end = Date.now() + {{.StartsInMS}};
+  var el = document.getElementById("countdown");
+  function pad(n) { return n < 10 ? "0" + n : n; }
+  function tick() {
+    var left = Math.max(0, Math.ceil((end - Date.now()) / 1000));
+    var d = Math.floor(left / 86400), h = Math.floor(left / 3600) % 24, m = Math.floor(left / 60) % 60, s = left % 60;
+    el.textContent = (d > 0 ? d + "d " : "") + pad(h) + ":" + pad(m) + ":" + pad(s);
+    if (left === 0) {
+      location.reload();
+      return;
+    }
+    setTimeout(tick, Math.min(1000, end - Date.now()));
+  }
+  tick();
+})();
+</script>
+{{- else}}
+<video src="{{.PlaybackURL}}"{{with .PosterURL}} poster="{{.}}"{{end}} controls playsinline preload="metadata"></video>
+{{- end}}
+{{- if .WatermarkPosition}}
+<img class="watermark {{.WatermarkPosition}}" src="{{.LogoURL}}" alt="">
+{{- end}}
+</div>
+</body>
+</html>
+`))
+
+type embedPage struct {
+	Title             string
+	PlaybackURL       string
+	PosterURL         string
+	LogoURL           string
+	PrimaryColor      string
+	BackgroundColor   string
+	WatermarkPosition string
+	WatermarkOpacity  string
+	// Countdown replaces the player until a premiere starts, in
+	// StartsInMS by the server's clock.
+	Countdown  bool
+	StartsAt   string
+	StartsInMS int64
+}
+
+// handlerEmbed serves the player other sites embed in an iframe, in the
+// colors of the owner's tenant and with its logo as a watermark. Embedding
+// follows the tenant's hotlink protection: the page is only served to
+// allowed referrers, which are also the only sites allowed to frame it,
+// and hands the player a playback URL with the token it needs.
+func (cfg *apiConfig) handlerEmbed(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	countdown := video.ID != uuid.Nil && cfg.premiereCountdown(r, video)
+	if video.ID == uuid.Nil || video.VideoURL == nil || !countdown && !cfg.canView(r, video) {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	target, err := cfg.videoTarget(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't resolve storage for tenant", err)
+		return
+	}
+	if target.Hotlink != nil && !cfg.allowReferrer(w, r, target.Hotlink) {
+		return
+	}
+	page := embedPage{
+		Title:           video.Title,
+		PrimaryColor:    "auto",
+		BackgroundColor: "#000000",
+	}
+	if countdown {
+		// The countdown runs from the server's clock, so every viewer's
+		// ends at the same moment, whatever their own clock says.
+		page.Countdown = true
+		page.StartsAt = video.PublishAt.Format(time.RFC3339)
+		page.StartsInMS = video.PublishAt.Sub(cfg.clock.Now()).Milliseconds()
+		w.Header().Set("Cache-Control", "no-store")
+	} else {
+		// Sites embedding a passphrase-protected video pass the unlock
+		// token on in the iframe's URL.
+		unlockToken, ok := cfg.unlocked(r, video)
+		if !ok {
+			msg, _ := cfg.passesPassphrase(r, video)
+			respondWithError(w, http.StatusUnauthorized, msg, nil)
+			return
+		}
+		q := url.Values{}
+		if unlockToken != "" {
+			q.Set(unlockParam, unlockToken)
+		}
+		page.PlaybackURL = cfg.playbackPath(target, video, q)
+	}
+	branding, err := cfg.userBranding(video.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get branding", err)
+		return
+	}
+	if video.ThumbnailURL != nil && !countdown {
+		page.PosterURL = fmt.Sprintf("/api/videos/%s/thumbnail", video.ID)
+		if video.Visibility == database.VisibilityUnlisted {
+			page.PosterURL += "?" + url.Values{shareKeyParam: {video.ShareKey}}.Encode()
+		}
+	}
+	if branding != nil {
+		if branding.PrimaryColor != "" {
+			page.PrimaryColor = branding.PrimaryColor
+		}
+		if branding.BackgroundColor != "" {
+			page.BackgroundColor = branding.BackgroundColor
+		}
+		if page.LogoURL = cfg.brandingLogoURL(branding); page.LogoURL != "" {
+			page.WatermarkPosition = branding.WatermarkPosition
+			page.WatermarkOpacity = strconv.FormatFloat(branding.WatermarkOpacity, 'f', -1, 64)
+		}
+	}
+
+	cfg.setNoIndex(w, target, video)
+	setFrameAncestors(w, target)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := embedTemplate.Execute(w, page); err != nil {
+		log.Printf("Couldn't render embed page of video %s: %v", video.ID, err)
+	}
+}
+
+// setFrameAncestors limits the sites that may frame a page to the site
+// itself and the referrers target's hotlink protection allows, if it
+// restricts them.
+func setFrameAncestors(w http.ResponseWriter, target tenants.Target) {
+	if target.Hotlink == nil || len(target.Hotlink.AllowedReferrers) == 0 {
+		return
+	}
+	sources := append([]string{"'self'"}, target.Hotlink.AllowedReferrers...)
+	w.Header().Set("Content-Security-Policy", "frame-ancestors "+strings.Join(sources, " "))
+}
@@ -0,0 +1,445 @@
+package main
+
+import (
+	"archive/zip"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tenants"
+	"github.com/google/uuid"
+)
+
+// Limits on what a bundle may contain. Sizes are the uncompressed sizes the
+// archive declares; archive/zip fails the read if an entry's data doesn't
+// match its declared size, so the limits hold for the actual bytes too.
+const (
+	maxBundleUploadSize   = 1<<30 + 64<<20 // the video limit plus room for sidecars
+	maxBundleEntries      = 64
+	maxBundleVideoSize    = 1 << 30
+	maxBundleThumbnail    = 10 << 20
+	maxCaptionSize        = 2 << 20
+	maxBundleMetadataSize = 64 << 10
+	bundleMetadataName    = "metadata.json"
+)
+
+var (
+	bundleThumbnailTypes = map[string]string{
+		".jpg":  "image/jpeg",
+		".jpeg": "image/jpeg",
+		".png":  "image/png",
+		".heic": "image/heic",
+		".heif": "image/heif",
+	}
+	captionTypes = map[string]string{
+		".vtt": "text/vtt",
+		".srt": "application/x-subrip",
+	}
+)
+
+// bundleMetadata is the metadata.json sidecar. Every field is optional.
+type bundleMetadata struct {
+	Title       *string   `json:"title"`
+	Description *string   `json:"description"`
+	Tags        *[]string `json:"tags"`
+	scheduleParams
+}
+
+type bundleCaption struct {
+	file     *zip.File
+	language string
+	ext      string
+}
+
+// videoBundle is the classified contents of an uploaded zip.
+type videoBundle struct {
+	video     *zip.File
+	thumbnail *zip.File
+	metadata  *zip.File
+	captions  []bundleCaption
+}
+
+// readBundle classifies the entries of a bundle by file name and checks
+// them against the bundle limits. Entry names are only ever used to
+// classify; nothing is extracted to a path taken from the archive, but
+// names that would escape the extraction directory are still rejected
+// outright. __MACOSX resource forks and hidden files such as .DS_Store,
+// which archivers add on their own, are ignored.
+func readBundle(zr *zip.Reader) (videoBundle, error) {
+	b := videoBundle{}
+	if len(zr.File) > maxBundleEntries {
+		return b, fmt.Errorf("bundle can't contain more than %d files", maxBundleEntries)
+	}
+
+	languages := map[string]bool{}
+	for _, f := range zr.File {
+		name := strings.ReplaceAll(f.Name, `\`, "/")
+		if !filepath.IsLocal(name) || strings.ContainsRune(name, 0) {
+			return b, fmt.Errorf("bundle entry %q has an unsafe path", f.Name)
+		}
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		if !f.Mode().IsRegular() {
+			return b, fmt.Errorf("bundle entry %q is not a regular file", f.Name)
+		}
+		if f.Flags&0x1 != 0 {
+			return b, fmt.Errorf("bundle entry %q is encrypted", f.Name)
+		}
+		base := path.Base(name)
+		if strings.HasPrefix(name, "__MACOSX/") || strings.HasPrefix(base, ".") {
+			continue
+		}
+
+		ext := strings.ToLower(path.Ext(base))
+		size := f.UncompressedSize64
+		switch {
+		case strings.EqualFold(base, bundleMetadataName):
+			if b.metadata != nil {
+				return b, errors.New("bundle can only contain one metadata.json")
+			}
+			if size > maxBundleMetadataSize {
+				return b, fmt.Errorf("metadata.json can't be larger than %d KB", maxBundleMetadataSize>>10)
+			}
+			b.metadata = f
+		case videoContentType(base) != "":
+			if b.video != nil {
+				return b, errors.New("bundle can only contain one video")
+			}
+			if size > maxBundleVideoSize {
+				return b, fmt.Errorf("video %q can't be larger than %d MB", base, maxBundleVideoSize>>20)
+			}
+			b.video = f
+		case bundleThumbnailTypes[ext] != "":
+			if b.thumbnail != nil {
+				return b, errors.New("bundle can only contain one thumbnail")
+			}
+			if size > maxBundleThumbnail {
+				return b, fmt.Errorf("thumbnail %q can't be larger than %d MB", base, maxBundleThumbnail>>20)
+			}
+			b.thumbnail = f
+		case captionTypes[ext] != "":
+			stem := strings.TrimSuffix(base, path.Ext(base))
+			language := stem[strings.LastIndexByte(stem, '.')+1:]
+			if !languagePattern.MatchString(language) {
+				return b, fmt.Errorf("caption file %q must be named with its language code, e.g. captions.en.vtt", base)
+			}
+			if languages[language] {
+				return b, fmt.Errorf("bundle contains more than one caption file for %q", language)
+			}
+			if size > maxCaptionSize {
+				return b, fmt.Errorf("caption file %q can't be larger than %d MB", base, maxCaptionSize>>20)
+			}
+			languages[language] = true
+			b.captions = append(b.captions, bundleCaption{file: f, language: language, ext: ext})
+		default:
+			return b, fmt.Errorf("bundle entry %q is not a video, thumbnail, caption file or metadata.json", f.Name)
+		}
+	}
+	if b.video == nil {
+		return b, errors.New("bundle must contain an .mp4, .mov, .webm or .mkv video")
+	}
+	return b, nil
+}
+
+func readBundleMetadata(f *zip.File) (bundleMetadata, error) {
+	meta := bundleMetadata{}
+	rc, err := f.Open()
+	if err != nil {
+		return meta, err
+	}
+	defer rc.Close()
+	decoder := json.NewDecoder(rc)
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&meta)
+	return meta, err
+}
+
+// handlerUploadBundle accepts a zip with a video and optional sidecars: a
+// thumbnail, caption files named by language (captions.en.vtt) and a
+// metadata.json with title, description and publish schedule. Each part
+// goes through the same pipeline as its standalone upload, and the bundle is
+// applied all or nothing.
+func (cfg *apiConfig) handlerUploadBundle(w http.ResponseWriter, r *http.Request) {
+	limit := bodyLimit{bytes: maxBundleUploadSize, message: "Upload is too large"}
+	if !limitUploadBody(w, r, limit) {
+		return
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, tenantID, err := auth.ValidateTenantJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	target, err := cfg.tenants.Target(r.Context(), tenantID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't resolve storage for tenant", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't find video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusUnauthorized, "Not authorized to upload for this video", nil)
+		return
+	}
+	if !requireNoLegalHold(w, video) || !requireNotProcessing(w, video) {
+		return
+	}
+	if !cfg.requireUnlockedVideoFile(w, r, video) {
+		return
+	}
+	release, ok := cfg.admitUpload(w)
+	if !ok {
+		return
+	}
+
+	plog := newProcessingLog(videoID, "bundle")
+	plog.release = release
+	rec := &errorRecorder{ResponseWriter: w}
+	w = rec
+	r = r.WithContext(plog.context(r.Context()))
+	ctx := r.Context()
+	defer func() { cfg.saveProcessingLog(plog, rec.failure()) }()
+
+	cleanup := &cleanupStack{}
+	defer cleanup.run()
+
+	zipFile, err := os.CreateTemp("", "tubely-bundle-*.zip")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to create temporary file", err)
+		return
+	}
+	cleanup.removeFile(zipFile.Name())
+	defer zipFile.Close()
+
+	stopRateWatch := cfg.watchUploadRate(w, r)
+	part, form, err := streamMultipartForm(r, "bundle", cfg.chaos.SlowWriter(zipFile))
+	stopRateWatch()
+	var writeErr *formWriteError
+	switch {
+	case err == nil:
+	case respondIfTooLarge(w, err, limit), respondIfTooSlow(w, err):
+		return
+	case errors.As(err, &writeErr):
+		respondWithError(w, http.StatusInternalServerError, "Failed to save file to disk", err)
+		return
+	default:
+		respondWithError(w, http.StatusBadRequest, "Unable to parse form file", err)
+		return
+	}
+	zr, err := zip.NewReader(zipFile, part.size)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Bundle is not a valid zip archive", err)
+		return
+	}
+	bundle, err := readBundle(zr)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	// Validate the metadata before any of the expensive work.
+	params := database.CreateVideoParams{Title: video.Title, Description: video.Description, Tags: video.Tags, UserID: userID}
+	schedule := video.Schedule
+	rescheduled := false
+	if bundle.metadata != nil {
+		meta, err := readBundleMetadata(bundle.metadata)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Couldn't decode metadata.json", err)
+			return
+		}
+		if meta.Title != nil {
+			params.Title = *meta.Title
+		}
+		if meta.Description != nil {
+			params.Description = *meta.Description
+		}
+		if meta.Tags != nil {
+			params.Tags = *meta.Tags
+		}
+		if meta.PublishAt != nil {
+			if schedule, err = meta.schedule(); err != nil {
+				respondWithError(w, http.StatusBadRequest, err.Error(), err)
+				return
+			}
+			rescheduled = true
+		}
+	}
+	if err := normalizeVideoParams(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	var thumbnail savedThumbnail
+	if bundle.thumbnail != nil {
+		rc, err := bundle.thumbnail.Open()
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Bundle is not a valid zip archive", err)
+			return
+		}
+		ext := strings.ToLower(path.Ext(bundle.thumbnail.Name))
+		var ok bool
+		thumbnail, ok = cfg.saveThumbnail(w, r, rc, bundleThumbnailTypes[ext], thumbnailChecksums{}, cleanup)
+		rc.Close()
+		if !ok {
+			return
+		}
+	}
+
+	rc, err := bundle.video.Open()
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Bundle is not a valid zip archive", err)
+		return
+	}
+	defer rc.Close()
+	videoName := path.Base(strings.ReplaceAll(bundle.video.Name, `\`, "/"))
+	watermark, err := parseWatermarkOverride(form.Get("watermark"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid watermark value", err)
+		return
+	}
+	profileName, ok := cfg.uploadProfile(w, userID, form.Get("profile"), form.Get("preset_id"))
+	if !ok {
+		return
+	}
+	video, matches, ok := cfg.ingestVideo(w, r, cleanup, video, target, videoSource{
+		file:         rc,
+		filename:     videoName,
+		contentType:  videoContentType(videoName),
+		profileName:  profileName,
+		hasThumbnail: bundle.thumbnail != nil,
+		watermark:    watermark,
+	})
+	if !ok {
+		return
+	}
+
+	if len(bundle.captions) > 0 {
+		previous, err := cfg.db.GetCaptionTracks(videoID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get caption tracks", err)
+			return
+		}
+		randomBytes := make([]byte, 8)
+		if _, err := rand.Read(randomBytes); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to generate random key", err)
+			return
+		}
+		for _, caption := range bundle.captions {
+			if !cfg.saveBundleCaption(w, r, cleanup, target, video, caption, previous, randomBytes) {
+				return
+			}
+		}
+	}
+
+	video.Title = params.Title
+	video.Description = params.Description
+	video.Tags = params.Tags
+	video.Schedule = schedule
+	if thumbnail.URL != "" {
+		if !cfg.requireStorageQuota(w, video.UserID, videoID, database.StorageKindThumbnail, thumbnail.Size) {
+			return
+		}
+		if !cfg.recordStorageUsage(w, cleanup, video.UserID, videoID, database.StorageKindThumbnail, thumbnail.Size) {
+			return
+		}
+		telemetry.uploadBytes.Add(float64(thumbnail.Size), "thumbnail", "bundle")
+		cfg.invalidateOnCommit(cleanup, videoID, "thumbnail", cfg.replacedThumbnailPaths(video))
+		cfg.deleteThumbnailOnCommit(cleanup, video)
+		video.ThumbnailURL = &thumbnail.URL
+		video.ThumbnailSHA256 = thumbnail.SHA256
+	}
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to update video metadata", err)
+		return
+	}
+	if rescheduled {
+		if err := cfg.db.ResetPremiere(videoID); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to update video metadata", err)
+			return
+		}
+		cfg.premieres.notify()
+	}
+
+	cleanup.commit()
+	if len(matches) > 0 {
+		cfg.flagFingerprintMatches(video.ID, matches)
+	}
+	if thumbnail.Name != "" && cfg.thumbnails.enabled() {
+		if _, err := cfg.generateThumbnailVariants(ctx, videoID, thumbnail.Name); err != nil {
+			log.Printf("Couldn't generate thumbnail variants for video %s: %v", videoID, err)
+		}
+	}
+
+	captions, err := cfg.db.GetCaptionTracks(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get caption tracks", err)
+		return
+	}
+	type response struct {
+		Video    database.Video          `json:"video"`
+		Captions []database.CaptionTrack `json:"captions"`
+	}
+	video, err = cfg.signVideo(ctx, target, video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URLs", err)
+		return
+	}
+	for i := range captions {
+		captions[i].URL, err = cfg.signStoredURL(ctx, target, video, captions[i].URL)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URLs", err)
+			return
+		}
+	}
+	respondWithJSON(w, http.StatusOK, response{Video: video, Captions: captions})
+}
+
+// saveBundleCaption saves a caption file of the bundle as the video's
+// track in its language, see saveCaptionTrack. If it returns false, an
+// error response has been written.
+func (cfg *apiConfig) saveBundleCaption(w http.ResponseWriter, r *http.Request, cleanup *cleanupStack, target tenants.Target, video database.Video, caption bundleCaption, previous []database.CaptionTrack, randomBytes []byte) bool {
+	rc, err := caption.file.Open()
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Bundle is not a valid zip archive", err)
+		return false
+	}
+	dat, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Bundle is not a valid zip archive", err)
+		return false
+	}
+	_, ok := cfg.saveCaptionTrack(w, r, cleanup, target, video, caption.language, caption.ext, dat, previous, randomBytes)
+	return ok
+}
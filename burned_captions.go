@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ffmpeg"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tenants"
+)
+
+// jobKindBurnedCaptions is the processing queue's kind for burning
+// captions into a video.
+const jobKindBurnedCaptions = "burned-captions"
+
+// burnedCaptionsName is the name of the rendition with a language's
+// captions burned in.
+func burnedCaptionsName(language string) string {
+	return "captions-" + language
+}
+
+// handlerBurnedCaptionsCreate adds a rendition of the video with one of its
+// caption tracks burned in, for platforms and embeds that can't show
+// sidecar captions. The SDR copy is used when there is one. A new video file
+// drops the rendition along with the others, so asking again for a
+// language that already has one returns it as is.
+func (cfg *apiConfig) handlerBurnedCaptionsCreate(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.requireVideoOwner(w, r)
+	if !ok {
+		return
+	}
+	language := r.PathValue("language")
+	if !languagePattern.MatchString(language) {
+		respondWithError(w, http.StatusBadRequest, "Language must be an ISO 639 code", nil)
+		return
+	}
+	if video.VideoURL == nil {
+		respondWithError(w, http.StatusNotFound, "Video has no file", nil)
+		return
+	}
+	if !requireNotProcessing(w, video) {
+		return
+	}
+
+	tracks, err := cfg.db.GetCaptionTracks(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get caption tracks", err)
+		return
+	}
+	var track *database.CaptionTrack
+	for i := range tracks {
+		if tracks[i].Language == language {
+			track = &tracks[i]
+		}
+	}
+	if track == nil {
+		respondWithError(w, http.StatusNotFound, "Caption track not found", nil)
+		return
+	}
+
+	renditions, err := cfg.db.GetRenditions(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get renditions", err)
+		return
+	}
+	target, sourceKey, err := cfg.videoObject(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't locate video file", err)
+		return
+	}
+	for _, rendition := range renditions {
+		if rendition.Name == burnedCaptionsName(language) {
+			cfg.respondWithRendition(w, r, http.StatusOK, target, video, rendition)
+			return
+		}
+	}
+
+	if video.SDRVideoURL != nil {
+		if key, ok := storedObjectKey(target, *video.SDRVideoURL); ok {
+			sourceKey = key
+		}
+	}
+	captionsKey, ok := storedObjectKey(target, track.URL)
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't locate caption file", fmt.Errorf("caption URL %q is not in bucket %s", track.URL, target.Bucket))
+		return
+	}
+
+	randomBytes := make([]byte, 16)
+	if _, err := rand.Read(randomBytes); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to generate random key", err)
+		return
+	}
+	key := videoObjectKey(video.UserID, video.ID, fmt.Sprintf("%s-%x.mp4", burnedCaptionsName(language), randomBytes))
+
+	cleanup := &cleanupStack{}
+	defer cleanup.run()
+
+	var outputPath string
+	err = cfg.jobs.RunKind(jobKindBurnedCaptions, video.ID, mediaDuration(video), func() error {
+		var err error
+		outputPath, err = burnCaptions(r.Context(), target, sourceKey, captionsKey, track.Format)
+		return err
+	})
+	if outputPath != "" {
+		cleanup.removeFile(outputPath)
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't burn in captions", err)
+		return
+	}
+
+	probeOutput, err := cfg.probeVideo(r.Context(), outputPath)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't burn in captions", err)
+		return
+	}
+	stream, _ := probeOutput.firstStream("video")
+	size, err := storedSize(outputPath)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update storage usage", err)
+		return
+	}
+	used, err := cfg.db.GetStorageUsage(video.ID, database.StorageKindVideo)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get storage usage", err)
+		return
+	}
+	// The rendition adds to the video's files rather than replacing them.
+	if !cfg.requireStorageQuota(w, video.UserID, video.ID, database.StorageKindVideo, used+size) {
+		return
+	}
+
+	if err := cfg.uploadVideoFile(r.Context(), target, key, outputPath, "video/mp4"); err != nil {
+		respondWithStorageError(w, http.StatusInternalServerError, "Failed to upload rendition to S3", err)
+		return
+	}
+	cleanup.deleteObject(target, key)
+
+	rendition := database.Rendition{
+		Name:     burnedCaptionsName(language),
+		Width:    stream.Width,
+		Height:   stream.Height,
+		URL:      key,
+		Captions: language,
+	}
+	if err := cfg.db.AddRendition(video.ID, rendition); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to save renditions", err)
+		return
+	}
+	cleanup.onError("remove "+rendition.Name+" rendition", func() error {
+		return cfg.db.ReplaceRenditions(video.ID, renditions)
+	})
+	if !cfg.recordStorageUsage(w, cleanup, video.UserID, video.ID, database.StorageKindVideo, used+size) {
+		return
+	}
+	cleanup.commit()
+
+	cfg.respondWithRendition(w, r, http.StatusCreated, target, video, rendition)
+}
+
+// respondWithRendition writes rendition with its URL signed.
+func (cfg *apiConfig) respondWithRendition(w http.ResponseWriter, r *http.Request, code int, target tenants.Target, video database.Video, rendition database.Rendition) {
+	var err error
+	rendition.URL, err = cfg.signStoredURL(r.Context(), target, video, rendition.URL)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URLs", err)
+		return
+	}
+	respondWithJSON(w, code, rendition)
+}
+
+// burnCaptions renders the captions at captionsKey into the video at
+// sourceKey and returns the path of the result. The caller removes it.
+func burnCaptions(ctx context.Context, target tenants.Target, sourceKey, captionsKey, format string) (string, error) {
+	sourcePath, err := downloadToTemp(ctx, target, sourceKey, "tubely-captions-src-*.mp4")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(sourcePath)
+	// ffmpeg picks the subtitles decoder by extension.
+	captionsPath, err := downloadToTemp(ctx, target, captionsKey, "tubely-captions-*."+format)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(captionsPath)
+
+	outputPath := sourcePath + ".captions"
+	if _, err := ffmpeg.BurnCaptionsCommand(sourcePath, outputPath, captionsPath).Run(ctx); err != nil {
+		os.Remove(outputPath)
+		return "", err
+	}
+	return outputPath, nil
+}
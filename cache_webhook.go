@@ -0,0 +1,140 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// cacheWebhookMaxAge is how far a webhook's timestamp can be from the
+	// server's clock, which stops a captured request from being replayed.
+	cacheWebhookMaxAge  = 5 * time.Minute
+	cacheWebhookMaxBody = 1 << 20
+	// cacheWebhookMaxVideos caps the videos a single webhook can name.
+	cacheWebhookMaxVideos = 1000
+)
+
+// cacheWebhookSignature signs a cache webhook's timestamp and body.
+func cacheWebhookSignature(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%s.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// handlerCacheWebhook lets an external system, such as a CMS that edits
+// videos behind the API's back, drop what the server has cached for some
+// videos. Requests are signed with CACHE_WEBHOOK_SECRET rather than a JWT:
+// X-Tubely-Timestamp is the Unix time of the request, and
+// X-Tubely-Signature is cacheWebhookSignature of it and the body.
+//
+// With scope "urls", the default, the videos' presigned URLs are dropped,
+// so their next responses are signed afresh. Scope "all" also purges the
+// videos' files and thumbnails from the CDN.
+func (cfg *apiConfig) handlerCacheWebhook(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		VideoIDs []string `json:"video_ids"`
+		Scope    string   `json:"scope"`
+	}
+	type response struct {
+		Invalidated    int      `json:"invalidated"`
+		Unknown        []string `json:"unknown"`
+		InvalidationID string   `json:"invalidation_id,omitempty"`
+	}
+
+	if len(cfg.cacheWebhookSecret) == 0 {
+		respondWithError(w, http.StatusNotFound, "Cache webhook is not configured", nil)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, cacheWebhookMaxBody))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	timestamp := r.Header.Get("X-Tubely-Timestamp")
+	sent, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || cfg.clock.Now().Sub(time.Unix(sent, 0)).Abs() > cacheWebhookMaxAge {
+		respondWithError(w, http.StatusUnauthorized, "Invalid webhook timestamp", err)
+		return
+	}
+	if !hmac.Equal([]byte(r.Header.Get("X-Tubely-Signature")), []byte(cacheWebhookSignature(cfg.cacheWebhookSecret, timestamp, body))) {
+		respondWithError(w, http.StatusUnauthorized, "Invalid webhook signature", nil)
+		return
+	}
+
+	params := parameters{}
+	if err := json.Unmarshal(body, &params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Scope == "" {
+		params.Scope = "urls"
+	}
+	if params.Scope != "urls" && params.Scope != "all" {
+		respondWithError(w, http.StatusBadRequest, "Scope must be urls or all", nil)
+		return
+	}
+	if len(params.VideoIDs) == 0 {
+		respondWithError(w, http.StatusBadRequest, "Video IDs are required", nil)
+		return
+	}
+	if len(params.VideoIDs) > cacheWebhookMaxVideos {
+		respondWithError(w, http.StatusBadRequest, "Too many video IDs", nil)
+		return
+	}
+
+	resp := response{Unknown: []string{}}
+	paths := []string{}
+	for _, s := range params.VideoIDs {
+		videoID, err := uuid.Parse(s)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+			return
+		}
+		video, err := cfg.db.GetVideo(videoID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+			return
+		}
+		if video.ID == uuid.Nil {
+			resp.Unknown = append(resp.Unknown, s)
+			continue
+		}
+		cfg.signedURLs.invalidate(videoID)
+		resp.Invalidated++
+
+		if params.Scope == "all" && cfg.cdn != nil {
+			target, err := cfg.videoTarget(r.Context(), video)
+			if err != nil {
+				respondWithError(w, http.StatusInternalServerError, "Couldn't resolve storage for tenant", err)
+				return
+			}
+			renditions, err := cfg.db.GetRenditions(videoID)
+			if err != nil {
+				respondWithError(w, http.StatusInternalServerError, "Couldn't get renditions", err)
+				return
+			}
+			paths = append(paths, cfg.replacedVideoPaths(target, video, renditions)...)
+			paths = append(paths, cfg.replacedThumbnailPaths(video)...)
+		}
+	}
+
+	if len(paths) > 0 {
+		id, err := cfg.cdn.Invalidate(r.Context(), paths)
+		if err != nil {
+			respondWithError(w, http.StatusBadGateway, "Couldn't invalidate CDN cache", err)
+			return
+		}
+		resp.InvalidationID = id
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
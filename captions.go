@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tenants"
+)
+
+// srtTiming matches the timing line of an SRT cue, e.g.
+// "00:00:01,000 --> 00:00:04,250". Anything after the end time, such as the
+// X1:/Y1: coordinates some tools add, is dropped.
+var srtTiming = regexp.MustCompile(`^(\d+):(\d{2}):(\d{2})[,.](\d{3})\s*-->\s*(\d+):(\d{2}):(\d{2})[,.](\d{3})`)
+
+// handlerCaptionsUpload adds a caption track to the video from the
+// multipart "captions" file, a .vtt or .srt, in the language of the
+// "language" field. SRT files are converted to WebVTT, which is what
+// browsers play, so every track is stored as WebVTT. Uploading a track in a
+// language the video already has replaces it.
+func (cfg *apiConfig) handlerCaptionsUpload(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.requireVideoOwner(w, r)
+	if !ok {
+		return
+	}
+	if !requireNoLegalHold(w, video) {
+		return
+	}
+	limit := bodyLimit{bytes: maxCaptionSize + uploadFormOverhead, message: "Caption file is too large"}
+	if !limitUploadBody(w, r, limit) {
+		return
+	}
+
+	file, header, err := r.FormFile("captions")
+	if err != nil {
+		if respondIfTooLarge(w, err, limit) {
+			return
+		}
+		respondWithError(w, http.StatusBadRequest, "Unable to parse form file", err)
+		return
+	}
+	defer file.Close()
+	language := r.FormValue("language")
+	if !languagePattern.MatchString(language) {
+		respondWithError(w, http.StatusBadRequest, "Language must be an ISO 639 code", nil)
+		return
+	}
+	ext := strings.ToLower(filepath.Ext(header.Filename))
+	if captionTypes[ext] == "" {
+		respondWithError(w, http.StatusBadRequest, "Captions must be a .vtt or .srt file", nil)
+		return
+	}
+	if header.Size > maxCaptionSize {
+		respondUploadTooLarge(w, bodyLimit{bytes: maxCaptionSize, message: "Caption file is too large"})
+		return
+	}
+	dat, err := io.ReadAll(file)
+	if err != nil {
+		if respondIfTooLarge(w, err, limit) {
+			return
+		}
+		respondWithError(w, http.StatusBadRequest, "Unable to parse form file", err)
+		return
+	}
+
+	previous, err := cfg.db.GetCaptionTracks(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get caption tracks", err)
+		return
+	}
+	target, err := cfg.videoTarget(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't locate video file", err)
+		return
+	}
+	randomBytes := make([]byte, 8)
+	if _, err := rand.Read(randomBytes); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to generate random key", err)
+		return
+	}
+
+	cleanup := &cleanupStack{}
+	defer cleanup.run()
+	track, ok := cfg.saveCaptionTrack(w, r, cleanup, target, video, language, ext, dat, previous, randomBytes)
+	if !ok {
+		return
+	}
+	cleanup.commit()
+	cfg.emitEvent(eventVideoUpdated, video.ID, nil)
+
+	track.URL, err = cfg.signStoredURL(r.Context(), target, video, track.URL)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URLs", err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, track)
+}
+
+// handlerCaptionsDelete removes the video's caption track in the language
+// in the path, and its file.
+func (cfg *apiConfig) handlerCaptionsDelete(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.requireVideoOwner(w, r)
+	if !ok {
+		return
+	}
+	if !requireNoLegalHold(w, video) {
+		return
+	}
+	language := r.PathValue("language")
+
+	tracks, err := cfg.db.GetCaptionTracks(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get caption tracks", err)
+		return
+	}
+	var track *database.CaptionTrack
+	for i := range tracks {
+		if tracks[i].Language == language {
+			track = &tracks[i]
+		}
+	}
+	if track == nil {
+		respondWithError(w, http.StatusNotFound, "Caption track not found", nil)
+		return
+	}
+	target, err := cfg.videoTarget(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't locate video file", err)
+		return
+	}
+
+	cleanup := &cleanupStack{}
+	defer cleanup.run()
+	if err := cfg.db.DeleteCaptionTrack(video.ID, language); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete caption track", err)
+		return
+	}
+	deleteCaptionFilesOnCommit(cleanup, target, *track)
+	cleanup.commit()
+	cfg.emitEvent(eventVideoUpdated, video.ID, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// saveCaptionTrack stores a caption file, in WebVTT, next to the video and
+// records it as the video's track in language. The language's previous
+// track is restored if the pipeline fails later, and its file is deleted
+// once it succeeds. If ok is false, an error response has been written.
+func (cfg *apiConfig) saveCaptionTrack(w http.ResponseWriter, r *http.Request, cleanup *cleanupStack, target tenants.Target, video database.Video, language, ext string, dat []byte, previous []database.CaptionTrack, randomBytes []byte) (database.CaptionTrack, bool) {
+	vtt, err := webVTT(ext, dat)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Captions must be valid WebVTT or SRT", err)
+		return database.CaptionTrack{}, false
+	}
+
+	key := videoObjectKey(video.UserID, video.ID, fmt.Sprintf("captions-%s-%x.vtt", language, randomBytes))
+	if err := putObject(r.Context(), target, key, bytes.NewReader(vtt), captionTypes[".vtt"]); err != nil {
+		respondWithStorageError(w, http.StatusInternalServerError, "Failed to upload captions to S3", err)
+		return database.CaptionTrack{}, false
+	}
+	cleanup.deleteObject(target, key)
+
+	track := database.CaptionTrack{
+		Language:  language,
+		Format:    "vtt",
+		URL:       key,
+		CreatedAt: cfg.clock.Now().UTC(),
+	}
+	if err := cfg.db.SaveCaptionTrack(video.ID, track); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save caption track", err)
+		return database.CaptionTrack{}, false
+	}
+	for _, old := range previous {
+		if old.Language == language {
+			cleanup.onError("restore "+language+" captions", func() error {
+				return cfg.db.SaveCaptionTrack(video.ID, old)
+			})
+			deleteCaptionFilesOnCommit(cleanup, target, old)
+			return track, true
+		}
+	}
+	cleanup.onError("remove "+language+" captions", func() error {
+		return cfg.db.DeleteCaptionTrack(video.ID, language)
+	})
+	return track, true
+}
+
+// deleteCaptionFilesOnCommit registers the deletion of the files of caption
+// tracks for once the pipeline replacing or removing them succeeds.
+func deleteCaptionFilesOnCommit(cleanup *cleanupStack, target tenants.Target, tracks ...database.CaptionTrack) {
+	for _, track := range tracks {
+		key, ok := storedObjectKey(target, track.URL)
+		if !ok {
+			continue
+		}
+		cleanup.onCommit(fmt.Sprintf("delete s3://%s/%s", target.Bucket, key), func() error {
+			return target.Storage().Delete(context.Background(), key)
+		})
+	}
+}
+
+// signCaptions fills in the video's caption tracks, with the URLs to fetch
+// them from.
+func (cfg *apiConfig) signCaptions(ctx context.Context, target tenants.Target, video database.Video) (database.Video, error) {
+	tracks, err := cfg.db.GetCaptionTracks(video.ID)
+	if err != nil {
+		return video, err
+	}
+	for i := range tracks {
+		tracks[i].URL, err = cfg.signStoredURL(ctx, target, video, tracks[i].URL)
+		if err != nil {
+			return video, err
+		}
+	}
+	if len(tracks) > 0 {
+		video.Captions = tracks
+	}
+	return video, nil
+}
+
+// webVTT returns caption file dat, of the type ext names, as WebVTT.
+func webVTT(ext string, dat []byte) ([]byte, error) {
+	dat = bytes.TrimPrefix(dat, []byte("\ufeff"))
+	if !utf8.Valid(dat) {
+		return nil, errors.New("captions must be UTF-8")
+	}
+	if ext == ".srt" {
+		return srtToWebVTT(dat)
+	}
+	if !bytes.HasPrefix(dat, []byte("WEBVTT")) || (len(dat) > 6 && !strings.ContainsRune(" \t\r\n", rune(dat[6]))) {
+		return nil, errors.New("missing WEBVTT header")
+	}
+	return dat, nil
+}
+
+// srtToWebVTT converts SRT captions to WebVTT: under a WEBVTT header, with
+// the cues' numbers kept as their identifiers and their times written with
+// a decimal point. Styling tags common to both, like <i>, pass through.
+func srtToWebVTT(dat []byte) ([]byte, error) {
+	text := strings.ReplaceAll(string(dat), "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+
+	var out strings.Builder
+	out.WriteString("WEBVTT\n")
+	cues := 0
+	for _, block := range strings.Split(text, "\n\n") {
+		lines := strings.Split(strings.Trim(block, "\n"), "\n")
+		if len(lines) == 1 && strings.TrimSpace(lines[0]) == "" {
+			continue
+		}
+		timing := 0
+		if !srtTiming.MatchString(lines[0]) {
+			timing = 1
+		}
+		if timing >= len(lines) {
+			return nil, fmt.Errorf("cue %d has no timing line", cues+1)
+		}
+		m := srtTiming.FindStringSubmatch(strings.TrimSpace(lines[timing]))
+		if m == nil {
+			return nil, fmt.Errorf("cue %d has an invalid timing line %q", cues+1, lines[timing])
+		}
+
+		out.WriteString("\n")
+		if timing == 1 {
+			out.WriteString(strings.TrimSpace(lines[0]) + "\n")
+		}
+		fmt.Fprintf(&out, "%s:%s:%s.%s --> %s:%s:%s.%s\n", vttHours(m[1]), m[2], m[3], m[4], vttHours(m[5]), m[6], m[7], m[8])
+		for _, line := range lines[timing+1:] {
+			// "-->" would end a WebVTT cue's text early.
+			out.WriteString(strings.ReplaceAll(line, "-->", "--&gt;") + "\n")
+		}
+		cues++
+	}
+	if cues == 0 {
+		return nil, errors.New("no cues")
+	}
+	return []byte(out.String()), nil
+}
+
+// vttHours pads the hours of an SRT time to the two digits WebVTT requires.
+func vttHours(h string) string {
+	if len(h) < 2 {
+		return "0" + h
+	}
+	return h
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// With CDN_PREFETCH=true, a video that finishes processing has the start
+// of its files fetched through the distribution, so they're already in
+// the edge cache when the first viewer arrives, as they all do at once for
+// a scheduled premiere. That's the thumbnail, the first
+// CDN_PREFETCH_SEGMENTS segments of each HLS rendition with their init
+// segments, and the first cdnPrefetchMP4Bytes of the MP4. Requests go
+// wherever DNS sends the server, or to each of the edge addresses in
+// CDN_PREFETCH_POPS, to warm the PoPs viewers are near rather than the
+// server. Private videos never go through the distribution, so they
+// aren't prefetched.
+
+// cdnPrefetchMP4Bytes is how much of the MP4 is fetched, enough for
+// players to start.
+const cdnPrefetchMP4Bytes = 2 << 20
+
+// cdnPrefetchTimeout bounds the prefetch of a video's files from one PoP.
+const cdnPrefetchTimeout = 2 * time.Minute
+
+// cdnPrefetch fetches objects through the distribution. It's nil when
+// prefetching is off.
+type cdnPrefetch struct {
+	// segments is how many segments of each HLS rendition are fetched.
+	segments int
+	// pops are the edge addresses requests are sent to, by name, with
+	// the clients that connect to them. An empty name is DNS's choice.
+	pops    []string
+	clients map[string]*http.Client
+}
+
+// newCDNPrefetch returns a prefetcher for the edge addresses pops, host
+// names or IP addresses, or where DNS resolves the distribution to if
+// there are none.
+func newCDNPrefetch(pops []string, segments int) *cdnPrefetch {
+	p := &cdnPrefetch{segments: segments, clients: map[string]*http.Client{}}
+	if len(pops) == 0 {
+		p.pops = []string{""}
+		p.clients[""] = &http.Client{Timeout: cdnPrefetchTimeout}
+		return p
+	}
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	for _, pop := range pops {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		// TLS and the Host header still name the distribution; only
+		// the connection goes to the PoP.
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			_, port, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+			return dialer.DialContext(ctx, network, net.JoinHostPort(pop, port))
+		}
+		p.pops = append(p.pops, pop)
+		p.clients[pop] = &http.Client{Timeout: cdnPrefetchTimeout, Transport: transport}
+	}
+	return p
+}
+
+// cdnPrefetchRequest is an object to fetch, with the Range to ask for, if
+// only part of it is needed.
+type cdnPrefetchRequest struct {
+	url       string
+	byteRange string
+}
+
+// prefetchVideo warms the edge cache with the start of video's files, in
+// the background.
+func (cfg *apiConfig) prefetchVideo(video database.Video) {
+	if cfg.cdnPrefetch == nil || video.Visibility == database.VisibilityPrivate {
+		return
+	}
+	cfg.background.run(func() {
+		ctx := context.Background()
+		requests, err := cfg.cdnPrefetchRequests(ctx, video)
+		if err != nil {
+			log.Printf("Couldn't list files of video %s to prefetch: %v", video.ID, err)
+			return
+		}
+		if len(requests) == 0 {
+			return
+		}
+		var wg sync.WaitGroup
+		for _, pop := range cfg.cdnPrefetch.pops {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				cfg.cdnPrefetch.fetch(ctx, video.ID, pop, requests)
+			}()
+		}
+		wg.Wait()
+	})
+}
+
+// cdnPrefetchRequests lists what to fetch of video's files that the
+// distribution fronts.
+func (cfg *apiConfig) cdnPrefetchRequests(ctx context.Context, video database.Video) ([]cdnPrefetchRequest, error) {
+	requests := []cdnPrefetchRequest{}
+	if video.ThumbnailURL != nil {
+		u, err := cfg.thumbnailDeliveryURL(ctx, video, *video.ThumbnailURL)
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(u, cfg.cdnURL+"/") {
+			requests = append(requests, cdnPrefetchRequest{url: u})
+		}
+	}
+
+	target, err := cfg.videoTarget(ctx, video)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := cfg.cdnObjectURL(target, ""); !ok {
+		// The distribution doesn't front the owner's bucket.
+		return requests, nil
+	}
+	if video.HLSURL != nil {
+		keys, err := hlsObjectKeys(ctx, target, *video.HLSURL)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range cdnPrefetchSegments(keys, cfg.cdnPrefetch.segments) {
+			u, err := cfg.videoDeliveryURL(ctx, target, video, key)
+			if err != nil {
+				return nil, err
+			}
+			requests = append(requests, cdnPrefetchRequest{url: u})
+		}
+	}
+	if video.VideoURL != nil {
+		if key, ok := storedObjectKey(target, *video.VideoURL); ok {
+			u, err := cfg.videoDeliveryURL(ctx, target, video, key)
+			if err != nil {
+				return nil, err
+			}
+			requests = append(requests, cdnPrefetchRequest{url: u, byteRange: fmt.Sprintf("bytes=0-%d", cdnPrefetchMP4Bytes-1)})
+		}
+	}
+	return requests, nil
+}
+
+// cdnPrefetchSegments picks the init segment and first n media segments
+// of each rendition out of an HLS output's keys, as hlsObjectKeys lists
+// them. Playlists are served by the API, not the distribution.
+func cdnPrefetchSegments(keys []string, n int) []string {
+	picked := []string{}
+	counts := map[string]int{}
+	for _, key := range keys {
+		switch path.Ext(key) {
+		case ".m3u8":
+		case ".mp4":
+			picked = append(picked, key)
+		default:
+			dir := path.Dir(key)
+			if counts[dir] < n {
+				counts[dir]++
+				picked = append(picked, key)
+			}
+		}
+	}
+	return picked
+}
+
+// fetch requests each object from pop, reading the responses through so
+// the edge caches them, and logs how it went.
+func (p *cdnPrefetch) fetch(ctx context.Context, videoID uuid.UUID, pop string, requests []cdnPrefetchRequest) {
+	ctx, cancel := context.WithTimeout(ctx, cdnPrefetchTimeout)
+	defer cancel()
+	client := p.clients[pop]
+	label := pop
+	if label == "" {
+		label = "dns"
+	}
+	start := time.Now()
+	hits, failed := 0, 0
+	for _, req := range requests {
+		err := func() error {
+			r, err := http.NewRequestWithContext(ctx, http.MethodGet, req.url, nil)
+			if err != nil {
+				return err
+			}
+			if req.byteRange != "" {
+				r.Header.Set("Range", req.byteRange)
+			}
+			resp, err := client.Do(r)
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+			if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+				return err
+			}
+			if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+				return fmt.Errorf("status %d", resp.StatusCode)
+			}
+			// CloudFront says whether the edge already had it.
+			if strings.HasPrefix(resp.Header.Get("X-Cache"), "Hit") {
+				hits++
+			}
+			return nil
+		}()
+		telemetry.cdnPrefetches.Inc(label, outcomeLabel(err))
+		if err != nil {
+			failed++
+			log.Printf("CDN prefetch of video %s from %s failed for %s: %v", videoID, label, strings.SplitN(req.url, "?", 2)[0], err)
+		}
+	}
+	log.Printf("CDN prefetch of video %s from %s: %d files, %d already cached, %d failed, in %v", videoID, label, len(requests), hits, failed, time.Since(start).Round(time.Millisecond))
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tenants"
+)
+
+// With CDN_DOMAIN set, video files in the default bucket and thumbnails
+// are handed out as URLs on the distribution instead of presigned S3 URLs
+// and server URLs. As with invalidations, the distribution is assumed to
+// front the default bucket and the server's /assets. Tenant buckets and
+// other backends keep using presigned URLs.
+
+// cdnObjectURL is the URL of the object under key in target on the
+// distribution, or false if the distribution doesn't front target.
+func (cfg *apiConfig) cdnObjectURL(target tenants.Target, key string) (string, bool) {
+	if cfg.cdnURL == "" || !target.IsS3() || target.Bucket != cfg.tenants.Defaults().Bucket {
+		return "", false
+	}
+	segments := strings.Split(key, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return cfg.cdnURL + "/" + strings.Join(segments, "/"), true
+}
+
+// deliveryURL returns a URL clients can fetch the object under key in
+// target from for cfg.presignExpiry: a URL on the distribution, signed
+// unless URL_SIGNER is s3, or else a presigned S3 URL.
+func (cfg *apiConfig) deliveryURL(ctx context.Context, target tenants.Target, key string) (string, error) {
+	return cfg.expiringDeliveryURL(ctx, target, key, cfg.presignExpiry)
+}
+
+// videoDeliveryURL is deliveryURL for a file of video, valid for
+// cfg.videoURLExpiry. Private videos' files are always presigned on the
+// bucket, so they never pass through the distribution's cache.
+func (cfg *apiConfig) videoDeliveryURL(ctx context.Context, target tenants.Target, video database.Video, key string) (string, error) {
+	expiry := cfg.videoURLExpiry(video)
+	if video.Visibility == database.VisibilityPrivate {
+		return generatePresignedURL(ctx, target, key, expiry)
+	}
+	return cfg.expiringDeliveryURL(ctx, target, key, expiry)
+}
+
+// expiringDeliveryURL is deliveryURL with the URL valid for expiry.
+func (cfg *apiConfig) expiringDeliveryURL(ctx context.Context, target tenants.Target, key string, expiry time.Duration) (string, error) {
+	u, ok := cfg.cdnObjectURL(target, key)
+	if !ok {
+		return generatePresignedURL(ctx, target, key, expiry)
+	}
+	if cfg.signer == nil {
+		return u, nil
+	}
+	return cfg.signer.SignURL(u, cfg.clock.Now().Add(expiry))
+}
+
+// cdnAssetURL rewrites the URL of a local asset, such as a thumbnail, to
+// the distribution. Other URLs are returned as is.
+func (cfg *apiConfig) cdnAssetURL(assetURL string) string {
+	if cfg.cdnURL == "" {
+		return assetURL
+	}
+	name, ok := strings.CutPrefix(assetURL, fmt.Sprintf("http://localhost:%s/assets/", cfg.port))
+	if !ok {
+		return assetURL
+	}
+	return cfg.cdnURL + "/assets/" + name
+}
+
+// setCDNCookies sets signed cookies that let the client fetch every object
+// under prefix in target from the distribution for cfg.presignExpiry. It
+// reports false, setting nothing, unless CDN_COOKIE_DOMAIN is set and the
+// distribution fronts target.
+func (cfg *apiConfig) setCDNCookies(w http.ResponseWriter, target tenants.Target, prefix string) (bool, error) {
+	if cfg.cdnCookieDomain == "" {
+		return false, nil
+	}
+	prefixURL, ok := cfg.cdnObjectURL(target, prefix)
+	if !ok {
+		return false, nil
+	}
+	resource := prefixURL + "/*"
+	expires := cfg.clock.Now().Add(cfg.presignExpiry)
+	cookies, err := cfg.signer.SignedCookies(resource, expires)
+	if err != nil {
+		return false, err
+	}
+	for _, c := range cookies {
+		c.Domain = cfg.cdnCookieDomain
+		c.Path = "/"
+		c.Expires = expires
+		c.Secure = true
+		c.HttpOnly = true
+		// Players fetch segments from the distribution's domain.
+		c.SameSite = http.SameSiteNoneMode
+		http.SetCookie(w, c)
+	}
+	return true, nil
+}
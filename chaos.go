@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/chaos"
+)
+
+// chaosConfigFromEnv reads the fault rates for chaos mode from CHAOS_*
+// variables. Unset ones inject nothing.
+func chaosConfigFromEnv() (chaos.Config, error) {
+	config := chaos.Config{}
+	rates := []struct {
+		name string
+		rate *float64
+	}{
+		{"CHAOS_S3_ERROR_RATE", &config.S3ErrorRate},
+		{"CHAOS_FFMPEG_TIMEOUT_RATE", &config.FFmpegTimeoutRate},
+		{"CHAOS_DB_WRITE_ERROR_RATE", &config.DBWriteErrorRate},
+	}
+	for _, r := range rates {
+		v := os.Getenv(r.name)
+		if v == "" {
+			continue
+		}
+		rate, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return chaos.Config{}, fmt.Errorf("invalid %s: %w", r.name, err)
+		}
+		*r.rate = rate
+	}
+	if v := os.Getenv("CHAOS_S3_OPERATIONS"); v != "" {
+		for _, op := range strings.Split(v, ",") {
+			config.S3Operations = append(config.S3Operations, strings.TrimSpace(op))
+		}
+	}
+	if v := os.Getenv("CHAOS_DISK_DELAY_MS"); v != "" {
+		delay, err := strconv.Atoi(v)
+		if err != nil {
+			return chaos.Config{}, fmt.Errorf("invalid CHAOS_DISK_DELAY_MS: %w", err)
+		}
+		config.DiskDelayMS = delay
+	}
+	if v := os.Getenv("CHAOS_CLOCK_SKEW_MS"); v != "" {
+		skew, err := strconv.Atoi(v)
+		if err != nil {
+			return chaos.Config{}, fmt.Errorf("invalid CHAOS_CLOCK_SKEW_MS: %w", err)
+		}
+		config.ClockSkewMS = skew
+	}
+	return config, config.Validate()
+}
+
+type chaosStatus struct {
+	chaos.Config
+	Injected map[string]int64 `json:"injected"`
+}
+
+func (cfg *apiConfig) chaosStatus() chaosStatus {
+	return chaosStatus{Config: cfg.chaos.Config(), Injected: cfg.chaos.Injected()}
+}
+
+func (cfg *apiConfig) handlerChaosGet(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+	if cfg.chaos == nil {
+		respondWithError(w, http.StatusNotFound, "Chaos mode is not enabled", nil)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, cfg.chaosStatus())
+}
+
+// handlerChaosSet replaces the fault rates, so an integration test can
+// target the failure it exercises. Chaos mode itself can only be turned on
+// at startup.
+func (cfg *apiConfig) handlerChaosSet(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+	if cfg.chaos == nil {
+		respondWithError(w, http.StatusNotFound, "Chaos mode is not enabled", nil)
+		return
+	}
+	params := chaos.Config{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if err := cfg.chaos.SetConfig(params); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, cfg.chaosStatus())
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/chaos"
+)
+
+func TestChaosConfigFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    chaos.Config
+		wantErr string
+	}{
+		{name: "unset", want: chaos.Config{}},
+		{
+			name: "every fault",
+			env: map[string]string{
+				"CHAOS_S3_ERROR_RATE":       "0.5",
+				"CHAOS_S3_OPERATIONS":       "PutObject, UploadPart",
+				"CHAOS_FFMPEG_TIMEOUT_RATE": "0.25",
+				"CHAOS_DB_WRITE_ERROR_RATE": "1",
+				"CHAOS_DISK_DELAY_MS":       "20",
+				"CHAOS_CLOCK_SKEW_MS":       "-45000",
+			},
+			want: chaos.Config{
+				S3ErrorRate:       0.5,
+				S3Operations:      []string{"PutObject", "UploadPart"},
+				FFmpegTimeoutRate: 0.25,
+				DBWriteErrorRate:  1,
+				DiskDelayMS:       20,
+				ClockSkewMS:       -45000,
+			},
+		},
+		{name: "rate that isn't a number", env: map[string]string{"CHAOS_S3_ERROR_RATE": "often"}, wantErr: "CHAOS_S3_ERROR_RATE"},
+		{name: "rate above 1", env: map[string]string{"CHAOS_FFMPEG_TIMEOUT_RATE": "1.5"}, wantErr: "ffmpeg_timeout_rate"},
+		{name: "fractional disk delay", env: map[string]string{"CHAOS_DISK_DELAY_MS": "2.5"}, wantErr: "CHAOS_DISK_DELAY_MS"},
+		{name: "negative disk delay", env: map[string]string{"CHAOS_DISK_DELAY_MS": "-1"}, wantErr: "disk_delay_ms"},
+		{name: "clock skew that isn't a number", env: map[string]string{"CHAOS_CLOCK_SKEW_MS": "1m"}, wantErr: "CHAOS_CLOCK_SKEW_MS"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"CHAOS_S3_ERROR_RATE", "CHAOS_S3_OPERATIONS", "CHAOS_FFMPEG_TIMEOUT_RATE", "CHAOS_DB_WRITE_ERROR_RATE", "CHAOS_DISK_DELAY_MS", "CHAOS_CLOCK_SKEW_MS"} {
+				t.Setenv(name, tt.env[name])
+			}
+
+			got, err := chaosConfigFromEnv()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("chaosConfigFromEnv() error = %v, want one about %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("chaosConfigFromEnv(): %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("chaosConfigFromEnv() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tenants"
+)
+
+// cleanupStack undoes the partial work of a multi-step pipeline. Each step
+// that creates something registers how to remove it: onError for artifacts
+// that should only be kept if the whole pipeline succeeds, such as uploaded
+// objects, always for scratch files, and onCommit for follow-up work that
+// only makes sense once the pipeline's results are live. Run the stack with a deferred call
+// to run, and call commit once everything has succeeded.
+//
+//	cleanup := &cleanupStack{}
+//	defer cleanup.run()
+//	...
+//	cleanup.commit()
+type cleanupStack struct {
+	steps     []cleanupStep
+	committed bool
+}
+
+type cleanupStep struct {
+	name     string
+	fn       func() error
+	always   bool
+	onCommit bool
+}
+
+// onError registers fn to run if the pipeline fails.
+func (c *cleanupStack) onError(name string, fn func() error) {
+	c.steps = append(c.steps, cleanupStep{name: name, fn: fn})
+}
+
+// always registers fn to run when the stack runs, whether or not the
+// pipeline succeeded.
+func (c *cleanupStack) always(name string, fn func() error) {
+	c.steps = append(c.steps, cleanupStep{name: name, fn: fn, always: true})
+}
+
+// onCommit registers fn to run if the pipeline succeeds.
+func (c *cleanupStack) onCommit(name string, fn func() error) {
+	c.steps = append(c.steps, cleanupStep{name: name, fn: fn, onCommit: true})
+}
+
+// removeFile registers path as a scratch file.
+func (c *cleanupStack) removeFile(path string) {
+	c.always("remove "+path, func() error {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	})
+}
+
+// deleteObject registers an uploaded object for deletion if the pipeline
+// fails. The deletion gets its own context, since the request's may already
+// be cancelled by then.
+func (c *cleanupStack) deleteObject(target tenants.Target, key string) {
+	c.onError(fmt.Sprintf("delete s3://%s/%s", target.Bucket, key), func() error {
+		return target.Storage().Delete(context.Background(), key)
+	})
+}
+
+// restoreVideo registers a rollback of the video's row to its state before
+// the pipeline changed it.
+func (c *cleanupStack) restoreVideo(db database.Client, original database.Video) {
+	c.onError(fmt.Sprintf("restore video %s", original.ID), func() error {
+		return db.UpdateVideo(original)
+	})
+}
+
+// commit marks the pipeline as successful, so only the always and onCommit
+// steps run.
+func (c *cleanupStack) commit() {
+	c.committed = true
+}
+
+// run executes the registered steps in reverse order. Failures are logged
+// rather than returned, since run is called from a defer after the response
+// has been decided.
+func (c *cleanupStack) run() {
+	for i := len(c.steps) - 1; i >= 0; i-- {
+		step := c.steps[i]
+		if c.committed && !step.always && !step.onCommit {
+			continue
+		}
+		if !c.committed && step.onCommit {
+			continue
+		}
+		if err := step.fn(); err != nil {
+			log.Printf("cleanup: %s failed: %v", step.name, err)
+		}
+	}
+	c.steps = nil
+}
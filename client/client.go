@@ -0,0 +1,295 @@
+// Package client is a Go client for the Tubely API, covering what upload
+// tools need: signing in, creating videos and the resumable upload
+// protocol. cmd/tubely-upload is built on it.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Client calls a Tubely server as one user. Its methods are safe for
+// concurrent use.
+type Client struct {
+	baseURL string
+	http    *http.Client
+
+	// Retries is how many times a request that failed with a network
+	// error, a 5xx or a 429 is retried, waiting RetryDelay and then twice
+	// as long each time, or as long as the server's Retry-After asks.
+	// Requests that aren't safe to repeat, like completing an upload, are
+	// never retried.
+	Retries    int
+	RetryDelay time.Duration
+
+	mu           sync.Mutex
+	token        string
+	refreshToken string
+	apiKey       string
+}
+
+// New returns a client for the server at baseURL, e.g.
+// "http://localhost:8091".
+func New(baseURL string) *Client {
+	return &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		http:       &http.Client{},
+		Retries:    5,
+		RetryDelay: time.Second,
+	}
+}
+
+// Error is an error response from the server.
+type Error struct {
+	StatusCode int
+	Message    string `json:"error"`
+	// Code is the stable identifier of the error, e.g. "quota_exceeded".
+	Code string `json:"code"`
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("server responded %d", e.StatusCode)
+	}
+	return fmt.Sprintf("server responded %d: %s", e.StatusCode, e.Message)
+}
+
+// StatusCode returns the HTTP status of err if it is an *Error, or 0.
+func StatusCode(err error) int {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.StatusCode
+	}
+	return 0
+}
+
+// SetToken signs the client in with an access token and, optionally, the
+// refresh token it was issued with, which the client uses to get a new
+// access token when the server stops accepting the old one.
+func (c *Client) SetToken(token, refreshToken string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token, c.refreshToken = token, refreshToken
+}
+
+// Tokens returns the current access and refresh tokens. Refreshing rotates
+// both, so callers that save them should read them back after use.
+func (c *Client) Tokens() (token, refreshToken string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.token, c.refreshToken
+}
+
+// SetAPIKey signs the client in with an API key instead of a token. The
+// server only accepts API keys for direct video and thumbnail uploads.
+func (c *Client) SetAPIKey(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.apiKey = key
+}
+
+// Login signs in with an email and password.
+func (c *Client) Login(ctx context.Context, email, password string) error {
+	var resp struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	err := c.doJSON(ctx, http.MethodPost, "/api/login", map[string]string{"email": email, "password": password}, &resp, false)
+	if err != nil {
+		return err
+	}
+	c.SetToken(resp.Token, resp.RefreshToken)
+	return nil
+}
+
+// Refresh exchanges the refresh token for new access and refresh tokens.
+func (c *Client) Refresh(ctx context.Context) error {
+	_, refreshToken := c.Tokens()
+	if refreshToken == "" {
+		return errors.New("no refresh token")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/api/refresh", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+refreshToken)
+	res, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if err := checkResponse(res); err != nil {
+		return err
+	}
+	var resp struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+		return err
+	}
+	c.SetToken(resp.Token, resp.RefreshToken)
+	return nil
+}
+
+// Video is the part of a video's metadata upload tools care about.
+type Video struct {
+	ID           string    `json:"id"`
+	Title        string    `json:"title"`
+	Description  string    `json:"description"`
+	Tags         []string  `json:"tags"`
+	ThumbnailURL *string   `json:"thumbnail_url"`
+	VideoURL     *string   `json:"video_url"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// VideoParams are the fields of a new video.
+type VideoParams struct {
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	Tags        []string `json:"tags,omitempty"`
+	PresetID    string   `json:"preset_id,omitempty"`
+	SeriesID    string   `json:"series_id,omitempty"`
+}
+
+// CreateVideo creates a video without a file, to upload one to.
+func (c *Client) CreateVideo(ctx context.Context, params VideoParams) (Video, error) {
+	var video Video
+	err := c.doJSON(ctx, http.MethodPost, "/api/videos", params, &video, false)
+	return video, err
+}
+
+// GetVideo returns a video.
+func (c *Client) GetVideo(ctx context.Context, id string) (Video, error) {
+	var video Video
+	err := c.doJSON(ctx, http.MethodGet, "/api/videos/"+id, nil, &video, true)
+	return video, err
+}
+
+// RegisterObject records the object under key, which the caller stored in
+// the bucket itself, as the video's file. Only servers in metadata-only
+// mode accept it, and key has to be under {userID}/{videoID}/.
+func (c *Client) RegisterObject(ctx context.Context, id, key, contentType string) (Video, error) {
+	var video Video
+	err := c.doJSON(ctx, http.MethodPost, "/api/videos/"+id+"/object", map[string]string{"key": key, "content_type": contentType}, &video, false)
+	return video, err
+}
+
+// doJSON sends body as JSON and decodes the response into out, if it isn't
+// nil.
+func (c *Client) doJSON(ctx context.Context, method, path string, body, out any, retry bool) error {
+	var data []byte
+	if body != nil {
+		var err error
+		data, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
+	}
+	res, err := c.do(ctx, method, path, data, func(h http.Header) {
+		if body != nil {
+			h.Set("Content-Type", "application/json")
+		}
+	}, retry)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+// do sends a request with body, retrying if retry is set, and returns the
+// response if it succeeded. An access token the server rejects is
+// refreshed once. The caller closes the response's body.
+func (c *Client) do(ctx context.Context, method, path string, body []byte, header func(http.Header), retry bool) (*http.Response, error) {
+	refreshed := false
+	delay := c.RetryDelay
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		c.mu.Lock()
+		if c.apiKey != "" {
+			req.Header.Set("Authorization", "ApiKey "+c.apiKey)
+		} else if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		canRefresh := c.apiKey == "" && c.refreshToken != ""
+		c.mu.Unlock()
+		if header != nil {
+			header(req.Header)
+		}
+
+		res, err := c.http.Do(req)
+		if err == nil {
+			err = checkResponse(res)
+			if err == nil {
+				return res, nil
+			}
+			res.Body.Close()
+		}
+		if StatusCode(err) == http.StatusUnauthorized && canRefresh && !refreshed {
+			refreshed = true
+			if err := c.Refresh(ctx); err != nil {
+				return nil, fmt.Errorf("couldn't refresh access token: %w", err)
+			}
+			attempt--
+			continue
+		}
+		if !retry || attempt >= c.Retries || !retryable(err) {
+			return nil, err
+		}
+
+		wait := delay
+		if res != nil {
+			if seconds, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil && seconds > 0 {
+				wait = time.Duration(seconds) * time.Second
+			}
+		}
+		// Jitter keeps parallel requests that failed together from
+		// retrying together.
+		wait += time.Duration(rand.Int64N(int64(wait)/4 + 1))
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+		delay *= 2
+	}
+}
+
+// retryable reports whether a request that failed with err may succeed if
+// sent again.
+func retryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	status := StatusCode(err)
+	return status == 0 || status == http.StatusTooManyRequests || status >= 500
+}
+
+// checkResponse returns an *Error for responses that aren't 2xx.
+func checkResponse(res *http.Response) error {
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return nil
+	}
+	e := &Error{StatusCode: res.StatusCode}
+	data, _ := io.ReadAll(io.LimitReader(res.Body, 1<<16))
+	_ = json.Unmarshal(data, e)
+	return e
+}
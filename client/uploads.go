@@ -0,0 +1,248 @@
+package client
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Part size limits of the upload protocol. Every part but the last has to
+// be at least MinPartSize.
+const (
+	MinPartSize = 5 << 20
+	MaxPartSize = 64 << 20
+	MaxParts    = 10000
+)
+
+// UploadSession is a resumable upload of a video's file in numbered parts.
+type UploadSession struct {
+	ID          string    `json:"id"`
+	VideoID     string    `json:"video_id"`
+	Status      string    `json:"status"`
+	Filename    string    `json:"filename,omitempty"`
+	ContentType string    `json:"content_type"`
+	PartCount   int32     `json:"part_count,omitempty"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	// HeartbeatIntervalSeconds is how often to send a heartbeat while
+	// uploading, so the session doesn't expire between slow parts.
+	HeartbeatIntervalSeconds int `json:"heartbeat_interval_seconds"`
+	// RecommendedChunkSize and RecommendedParallelism are the part size
+	// and parts to send at once the server suggests for a new session,
+	// from its load and the bandwidth the client reported or was seen at.
+	RecommendedChunkSize   int64        `json:"recommended_chunk_size,omitempty"`
+	RecommendedParallelism int          `json:"recommended_parallelism,omitempty"`
+	Parts                  []UploadPart `json:"parts,omitempty"`
+	MissingParts           []int32      `json:"missing_parts,omitempty"`
+}
+
+// UploadPart is a part the server has stored.
+type UploadPart struct {
+	PartNumber int32     `json:"part_number"`
+	Size       int64     `json:"size"`
+	ETag       string    `json:"etag"`
+	UploadedAt time.Time `json:"uploaded_at"`
+}
+
+// UploadSessionParams describe the file a session will upload.
+type UploadSessionParams struct {
+	VideoID     string `json:"video_id"`
+	Filename    string `json:"filename,omitempty"`
+	ContentType string `json:"content_type"`
+	Profile     string `json:"profile,omitempty"`
+	PresetID    string `json:"preset_id,omitempty"`
+	// Parts is how many parts the file will be sent in, so completing
+	// fails rather than process a file missing its last parts.
+	Parts int32 `json:"parts,omitempty"`
+	// BandwidthKbps is the client's measured upload bandwidth, if it knows
+	// it, for the recommendations in the response.
+	BandwidthKbps int `json:"bandwidth_kbps,omitempty"`
+}
+
+// CreateUploadSession starts an upload of a video's file.
+func (c *Client) CreateUploadSession(ctx context.Context, params UploadSessionParams) (UploadSession, error) {
+	var session UploadSession
+	err := c.doJSON(ctx, http.MethodPost, "/api/uploads", params, &session, false)
+	return session, err
+}
+
+// ResumeUploadSession reconciles a session with what the server holds and
+// returns it with the parts still missing.
+func (c *Client) ResumeUploadSession(ctx context.Context, id string) (UploadSession, error) {
+	var session UploadSession
+	err := c.doJSON(ctx, http.MethodPost, "/api/uploads/"+id+"/resume", nil, &session, true)
+	return session, err
+}
+
+// Heartbeat keeps a session alive.
+func (c *Client) Heartbeat(ctx context.Context, id string) error {
+	return c.doJSON(ctx, http.MethodPost, "/api/uploads/"+id+"/heartbeat", nil, nil, true)
+}
+
+// UploadPart sends part number n of a session's file. Sending a part again
+// replaces it, so failed parts are retried.
+func (c *Client) UploadPart(ctx context.Context, id string, n int32, data []byte) (UploadPart, error) {
+	sum := md5.Sum(data)
+	checksum := base64.StdEncoding.EncodeToString(sum[:])
+	res, err := c.do(ctx, http.MethodPut, fmt.Sprintf("/api/uploads/%s/parts/%d", id, n), data, func(h http.Header) {
+		h.Set("Content-Type", "application/octet-stream")
+		h.Set("Content-MD5", checksum)
+	}, true)
+	if err != nil {
+		return UploadPart{}, err
+	}
+	defer res.Body.Close()
+	var part UploadPart
+	err = json.NewDecoder(res.Body).Decode(&part)
+	return part, err
+}
+
+// CompleteUploadSession assembles the session's parts and processes the
+// file, returning the video once it has been. It isn't retried: if it
+// fails, resume the session to see whether it's still active.
+func (c *Client) CompleteUploadSession(ctx context.Context, id string, parts int) (Video, error) {
+	var video Video
+	err := c.doJSON(ctx, http.MethodPost, "/api/uploads/"+id+"/complete", map[string]int{"parts": parts}, &video, false)
+	return video, err
+}
+
+// AbortUploadSession ends a session and discards its parts.
+func (c *Client) AbortUploadSession(ctx context.Context, id string) error {
+	return c.doJSON(ctx, http.MethodDelete, "/api/uploads/"+id, nil, nil, true)
+}
+
+// ContentType returns the upload content type of a video file going by its
+// extension, or "" if it isn't in a container the server supports.
+func ContentType(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".mp4", ".m4v":
+		return "video/mp4"
+	case ".mov":
+		return "video/quicktime"
+	case ".webm":
+		return "video/webm"
+	case ".mkv":
+		return "video/x-matroska"
+	}
+	return ""
+}
+
+// PartCount is the number of parts of partSize a file of size bytes takes.
+func PartCount(size, partSize int64) int32 {
+	return int32((size + partSize - 1) / partSize)
+}
+
+// UploadPartsOptions control UploadParts.
+type UploadPartsOptions struct {
+	// Parallel is how many parts are sent at once. It defaults to 4.
+	Parallel int
+	// Progress, if set, is called with the size of each part once the
+	// server has it. Calls don't overlap.
+	Progress func(n int64)
+}
+
+// UploadParts sends the parts of file, of size bytes in parts of partSize,
+// that session is missing, sending heartbeats while it does. Use a session
+// just created or returned by ResumeUploadSession.
+func (c *Client) UploadParts(ctx context.Context, session UploadSession, file io.ReaderAt, size, partSize int64, opts UploadPartsOptions) error {
+	if partSize < MinPartSize || partSize > MaxPartSize {
+		return fmt.Errorf("part size must be between %d and %d bytes", MinPartSize, MaxPartSize)
+	}
+	count := PartCount(size, partSize)
+	if count > MaxParts {
+		return fmt.Errorf("%d parts is more than the %d allowed; use larger parts", count, MaxParts)
+	}
+	// A new session is missing every part; the server only lists missing
+	// parts when a session is resumed.
+	missing := session.MissingParts
+	if session.Parts == nil && missing == nil {
+		for n := int32(1); n <= count; n++ {
+			missing = append(missing, n)
+		}
+	}
+	parallel := opts.Parallel
+	if parallel < 1 {
+		parallel = 4
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	go c.heartbeat(ctx, session, cancel)
+
+	queue := make(chan int32)
+	var (
+		wg       sync.WaitGroup
+		progress sync.Mutex
+	)
+	for range parallel {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, partSize)
+			for n := range queue {
+				offset := int64(n-1) * partSize
+				length := min(partSize, size-offset)
+				if length <= 0 {
+					cancel(fmt.Errorf("part %d is past the end of the file", n))
+					continue
+				}
+				data := buf[:length]
+				if _, err := file.ReadAt(data, offset); err != nil && !errors.Is(err, io.EOF) {
+					cancel(err)
+					continue
+				}
+				if _, err := c.UploadPart(ctx, session.ID, n, data); err != nil {
+					cancel(fmt.Errorf("part %d: %w", n, err))
+					continue
+				}
+				if opts.Progress != nil {
+					progress.Lock()
+					opts.Progress(length)
+					progress.Unlock()
+				}
+			}
+		}()
+	}
+	for _, n := range missing {
+		select {
+		case queue <- n:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	close(queue)
+	wg.Wait()
+	return context.Cause(ctx)
+}
+
+// heartbeat keeps session alive until ctx is done, cancelling it if the
+// session can no longer be kept alive.
+func (c *Client) heartbeat(ctx context.Context, session UploadSession, cancel context.CancelCauseFunc) {
+	interval := time.Duration(session.HeartbeatIntervalSeconds) * time.Second
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.Heartbeat(ctx, session.ID); err != nil && ctx.Err() == nil {
+				cancel(fmt.Errorf("heartbeat: %w", err))
+				return
+			}
+		}
+	}
+}
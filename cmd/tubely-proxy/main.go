@@ -0,0 +1,121 @@
+// Command tubely-proxy is a distribution for servers run with
+// URL_SIGNER=hmac: a reverse proxy in front of a bucket, such as one on
+// MinIO, which only forwards GET and HEAD requests that carry a valid,
+// unexpired signature, in their URL or their cookies. It shows what a
+// self-hosted proxy has to check; one in nginx or a CDN worker checks the
+// same.
+//
+//	TUBELY_PROXY_SECRET=... tubely-proxy -listen :8092 -upstream http://minio:9000/tubely
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/cdn"
+)
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(args []string) error {
+	fs := flag.NewFlagSet("tubely-proxy", flag.ContinueOnError)
+	listen := fs.String("listen", envOr("TUBELY_PROXY_LISTEN", ":8092"), "address to listen on (TUBELY_PROXY_LISTEN)")
+	upstream := fs.String("upstream", os.Getenv("TUBELY_PROXY_UPSTREAM"), "URL of the bucket to forward to (TUBELY_PROXY_UPSTREAM); the secret is read from TUBELY_PROXY_SECRET")
+	allowOrigin := fs.String("allow-origin", "", "origin of the web app, allowed to fetch with credentials, for players using signed cookies")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	secret := os.Getenv("TUBELY_PROXY_SECRET")
+	if len(secret) < 32 {
+		return errors.New("set TUBELY_PROXY_SECRET to the server's URL_SIGNER_SECRET")
+	}
+	target, err := url.Parse(*upstream)
+	if err != nil || target.Scheme == "" || target.Host == "" {
+		return errors.New("-upstream must be an absolute URL")
+	}
+
+	p := &proxy{
+		signer:      &cdn.HMACSigner{Secret: []byte(secret)},
+		allowOrigin: *allowOrigin,
+		upstream: &httputil.ReverseProxy{Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+			q := pr.Out.URL.Query()
+			q.Del(cdn.HMACExpiresParam)
+			q.Del(cdn.HMACSignatureParam)
+			pr.Out.URL.RawQuery = q.Encode()
+			// The upstream has no use for the viewer's cookies.
+			pr.Out.Header.Del("Cookie")
+		}},
+	}
+	srv := &http.Server{
+		Addr:              *listen,
+		Handler:           p,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+	log.Printf("Proxying %s to %s", *listen, target)
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+type proxy struct {
+	signer      *cdn.HMACSigner
+	upstream    http.Handler
+	allowOrigin string
+}
+
+func (p *proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if p.allowOrigin != "" && r.Header.Get("Origin") == p.allowOrigin {
+		w.Header().Set("Access-Control-Allow-Origin", p.allowOrigin)
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+		w.Header().Add("Vary", "Origin")
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodOptions:
+		w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD")
+		w.Header().Set("Access-Control-Allow-Headers", "Range")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := p.signer.Verify(r, time.Now()); err != nil {
+		http.Error(w, fmt.Sprintf("Forbidden: %v", err), http.StatusForbidden)
+		return
+	}
+	p.upstream.ServeHTTP(w, r)
+}
+
+func envOr(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
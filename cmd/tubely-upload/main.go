@@ -0,0 +1,236 @@
+// Command tubely-upload uploads a video file to a Tubely server with the
+// resumable upload protocol, sending parts in parallel and retrying the ones
+// that fail. An upload that is interrupted picks up where it left off when
+// the same command is run again.
+//
+//	TUBELY_PASSWORD=... tubely-upload -email me@example.com -title "Boots" boots.mp4
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/client"
+)
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "tubely-upload:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	fs := flag.NewFlagSet("tubely-upload", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: tubely-upload [flags] FILE")
+		fs.PrintDefaults()
+	}
+	server := fs.String("server", envOr("TUBELY_SERVER", "http://localhost:8091"), "server URL (TUBELY_SERVER)")
+	email := fs.String("email", os.Getenv("TUBELY_EMAIL"), "email to log in with (TUBELY_EMAIL); the password is read from TUBELY_PASSWORD")
+	token := fs.String("token", os.Getenv("TUBELY_TOKEN"), "access token to use instead of logging in (TUBELY_TOKEN)")
+	videoID := fs.String("video", "", "ID of an existing video to replace the file of, instead of creating one")
+	title := fs.String("title", "", "title of the new video (default: the file name)")
+	description := fs.String("description", "", "description of the new video")
+	tags := fs.String("tags", "", "comma-separated tags of the new video")
+	preset := fs.String("preset", "", "ID of an upload preset to apply")
+	series := fs.String("series", "", "ID of a series to add the new video to")
+	profile := fs.String("profile", "", "processing profile")
+	contentType := fs.String("content-type", "", "video/mp4 or video/quicktime (default: from the file extension)")
+	partSizeMiB := fs.Int64("part-size", 8, "part size in MiB, 5 to 64")
+	parallel := fs.Int("parallel", 4, "parts to send at once")
+	retries := fs.Int("retries", 5, "times to retry a failed part")
+	quiet := fs.Bool("quiet", false, "don't show progress")
+	fresh := fs.Bool("fresh", false, "start a new upload even if an earlier one of the file can be resumed")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected one file")
+	}
+	path := fs.Arg(0)
+	partSize := *partSizeMiB << 20
+	if partSize < client.MinPartSize || partSize > client.MaxPartSize {
+		return errors.New("-part-size must be between 5 and 64")
+	}
+	if *parallel < 1 {
+		return errors.New("-parallel must be at least 1")
+	}
+	if *contentType == "" {
+		*contentType = client.ContentType(path)
+		if *contentType == "" {
+			return fmt.Errorf("can't tell the type of %s from its extension; pass -content-type", path)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		return fmt.Errorf("%s is empty", path)
+	}
+	if parts := client.PartCount(info.Size(), partSize); parts > client.MaxParts {
+		return fmt.Errorf("%s needs %d parts, more than the %d allowed; use a larger -part-size", path, parts, client.MaxParts)
+	}
+
+	c := client.New(*server)
+	c.Retries = *retries
+	switch {
+	case *token != "":
+		c.SetToken(*token, "")
+	case *email != "":
+		password := os.Getenv("TUBELY_PASSWORD")
+		if password == "" {
+			return errors.New("set TUBELY_PASSWORD to log in")
+		}
+		if err := c.Login(ctx, *email, password); err != nil {
+			return fmt.Errorf("couldn't log in: %w", err)
+		}
+	default:
+		return errors.New("pass -email or -token")
+	}
+
+	key, err := newStateKey(*server, path, info)
+	if err != nil {
+		return err
+	}
+	state, err := loadState(key)
+	if err != nil {
+		return err
+	}
+	var session client.UploadSession
+	if state != nil && !*fresh && state.PartSize == partSize && (*videoID == "" || *videoID == state.VideoID) {
+		session, err = c.ResumeUploadSession(ctx, state.UploadID)
+		switch {
+		case err == nil:
+			logf(*quiet, "Resuming upload %s of video %s", session.ID, session.VideoID)
+		case client.StatusCode(err) == http.StatusNotFound || client.StatusCode(err) == http.StatusConflict:
+			// The session expired or was completed or aborted; the
+			// video it was for is reused.
+			logf(*quiet, "Upload %s can't be resumed, starting again", state.UploadID)
+			*videoID = state.VideoID
+			state = nil
+		default:
+			return fmt.Errorf("couldn't resume upload: %w", err)
+		}
+	} else {
+		state = nil
+	}
+
+	if state == nil {
+		if *videoID == "" {
+			if *title == "" {
+				*title = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+			}
+			video, err := c.CreateVideo(ctx, client.VideoParams{
+				Title:       *title,
+				Description: *description,
+				Tags:        splitTags(*tags),
+				PresetID:    *preset,
+				SeriesID:    *series,
+			})
+			if err != nil {
+				return fmt.Errorf("couldn't create video: %w", err)
+			}
+			*videoID = video.ID
+			logf(*quiet, "Created video %s", video.ID)
+		}
+		session, err = c.CreateUploadSession(ctx, client.UploadSessionParams{
+			VideoID:     *videoID,
+			Filename:    filepath.Base(path),
+			ContentType: *contentType,
+			Profile:     *profile,
+			PresetID:    *preset,
+			Parts:       client.PartCount(info.Size(), partSize),
+		})
+		if err != nil {
+			return fmt.Errorf("couldn't start upload: %w", err)
+		}
+		state = &uploadState{UploadID: session.ID, VideoID: session.VideoID, PartSize: partSize}
+		if err := saveState(key, *state); err != nil {
+			return err
+		}
+	}
+
+	bar := newProgressBar(info.Size(), *quiet)
+	bar.resumed(info.Size() - missingBytes(session, info.Size(), partSize))
+	err = c.UploadParts(ctx, session, file, info.Size(), partSize, client.UploadPartsOptions{
+		Parallel: *parallel,
+		Progress: bar.add,
+	})
+	bar.finish()
+	if err != nil {
+		if ctx.Err() != nil {
+			return errors.New("interrupted; run the same command again to resume")
+		}
+		return fmt.Errorf("upload failed, run the same command again to resume: %w", err)
+	}
+
+	logf(*quiet, "Processing...")
+	start := time.Now()
+	video, err := c.CompleteUploadSession(ctx, session.ID, int(client.PartCount(info.Size(), partSize)))
+	if err != nil {
+		return fmt.Errorf("couldn't complete upload: %w", err)
+	}
+	if err := removeState(key); err != nil {
+		return err
+	}
+	logf(*quiet, "Processed in %s", time.Since(start).Round(time.Second))
+	fmt.Println(video.ID)
+	return nil
+}
+
+// missingBytes is how much of the file is in the parts session is missing.
+func missingBytes(session client.UploadSession, size, partSize int64) int64 {
+	if session.Parts == nil && session.MissingParts == nil {
+		return size
+	}
+	var n int64
+	for _, part := range session.MissingParts {
+		offset := int64(part-1) * partSize
+		n += min(partSize, size-offset)
+	}
+	return n
+}
+
+func splitTags(s string) []string {
+	var tags []string
+	for _, tag := range strings.Split(s, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+func envOr(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// logf reports on stderr, leaving stdout for the video ID.
+func logf(quiet bool, format string, args ...any) {
+	if !quiet {
+		fmt.Fprintf(os.Stderr, format+"\n", args...)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const progressWidth = 30
+
+// progressBar draws the bytes uploaded so far on stderr. It redraws in
+// place on a terminal and prints a line every tenth of the way otherwise.
+type progressBar struct {
+	total, done int64
+	start       time.Time
+	sent        int64
+	quiet       bool
+	terminal    bool
+	lastTenth   int64
+}
+
+func newProgressBar(total int64, quiet bool) *progressBar {
+	info, err := os.Stderr.Stat()
+	return &progressBar{
+		total:    total,
+		start:    time.Now(),
+		quiet:    quiet,
+		terminal: err == nil && info.Mode()&os.ModeCharDevice != 0,
+	}
+}
+
+// resumed counts n bytes uploaded by an earlier run, which don't count
+// towards the rate.
+func (b *progressBar) resumed(n int64) {
+	b.done += n
+	b.draw()
+}
+
+// add counts n more bytes as uploaded.
+func (b *progressBar) add(n int64) {
+	b.done += n
+	b.sent += n
+	b.draw()
+}
+
+func (b *progressBar) draw() {
+	if b.quiet {
+		return
+	}
+	if !b.terminal {
+		tenth := b.done * 10 / b.total
+		if tenth == b.lastTenth && b.done != b.total {
+			return
+		}
+		b.lastTenth = tenth
+	}
+	filled := int(b.done * progressWidth / b.total)
+	line := fmt.Sprintf("[%s%s] %3d%% %s/%s",
+		strings.Repeat("#", filled), strings.Repeat("-", progressWidth-filled),
+		b.done*100/b.total, formatMiB(float64(b.done)), formatMiB(float64(b.total)))
+	if b.sent > 0 {
+		line += fmt.Sprintf(" %s/s", formatMiB(float64(b.sent)/max(time.Since(b.start).Seconds(), 0.001)))
+	}
+	if b.terminal {
+		fmt.Fprintf(os.Stderr, "\r%s", line)
+	} else {
+		fmt.Fprintln(os.Stderr, line)
+	}
+}
+
+// finish ends the bar's line on a terminal.
+func (b *progressBar) finish() {
+	if !b.quiet && b.terminal {
+		fmt.Fprintln(os.Stderr)
+	}
+}
+
+func formatMiB(bytes float64) string {
+	return fmt.Sprintf("%.1f MiB", bytes/(1<<20))
+}
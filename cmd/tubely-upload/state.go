@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// uploadState is what's kept between runs to resume an upload.
+type uploadState struct {
+	UploadID string `json:"upload_id"`
+	VideoID  string `json:"video_id"`
+	PartSize int64  `json:"part_size"`
+}
+
+// newStateKey names the state of an upload of the file at path to server.
+// The file's size and modification time are part of it, so a file that
+// changed starts a new upload rather than resuming with the old parts.
+func newStateKey(server, path string, info fs.FileInfo) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\n%s\n%d\n%d", server, abs, info.Size(), info.ModTime().UnixNano())))
+	return fmt.Sprintf("%x", sum[:12]), nil
+}
+
+func statePath(key string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "tubely-upload", key+".json"), nil
+}
+
+// loadState returns the saved state, or nil if there is none.
+func loadState(key string) (*uploadState, error) {
+	path, err := statePath(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state uploadState
+	if err := json.Unmarshal(data, &state); err != nil {
+		// A state file that can't be read only costs a fresh upload.
+		return nil, nil
+	}
+	return &state, nil
+}
+
+func saveState(key string, state uploadState) error {
+	path, err := statePath(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o600)
+}
+
+func removeState(key string) error {
+	path, err := statePath(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// folderSettings are what uploads from a folder are created with. Any of
+// them can be left out.
+type folderSettings struct {
+	PresetID    string   `json:"preset_id"`
+	SeriesID    string   `json:"series_id"`
+	Profile     string   `json:"profile"`
+	Description string   `json:"description"`
+	Tags        []string `json:"tags"`
+}
+
+// config maps folders, relative to the watched directory with "/" between
+// names, to their settings. A file gets the settings of the deepest folder
+// it's in that has any; "" is the watched directory itself.
+//
+//	{"folders": {"": {"tags": ["studio"]}, "vlogs": {"preset_id": "..."}}}
+type config struct {
+	Folders map[string]folderSettings `json:"folders"`
+}
+
+func loadConfig(path string) (*config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &config{}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+	folders := make(map[string]folderSettings, len(c.Folders))
+	for folder, settings := range c.Folders {
+		folders[strings.Trim(filepath.ToSlash(filepath.Clean("/"+folder)), "/")] = settings
+	}
+	c.Folders = folders
+	return c, nil
+}
+
+// settingsFor returns the settings of the file at rel, relative to the
+// watched directory.
+func (c *config) settingsFor(rel string) folderSettings {
+	folder := filepath.ToSlash(filepath.Dir(rel))
+	for {
+		if folder == "." {
+			folder = ""
+		}
+		if settings, ok := c.Folders[folder]; ok {
+			return settings
+		}
+		if folder == "" {
+			return folderSettings{}
+		}
+		folder = filepath.ToSlash(filepath.Dir(folder))
+	}
+}
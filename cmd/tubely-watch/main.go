@@ -0,0 +1,135 @@
+// Command tubely-watch watches a directory and uploads the video files that
+// appear in it, e.g. recordings copied to a NAS. A file is uploaded once it
+// has stopped changing, with the settings configured for its folder, and is
+// then moved into the archive directory. Uploads interrupted by a restart
+// are resumed.
+//
+//	TUBELY_PASSWORD=... tubely-watch -email studio@example.com -config watch.json /mnt/recordings
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/client"
+)
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(args []string) error {
+	fs := flag.NewFlagSet("tubely-watch", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: tubely-watch [flags] DIR")
+		fs.PrintDefaults()
+	}
+	server := fs.String("server", envOr("TUBELY_SERVER", "http://localhost:8091"), "server URL (TUBELY_SERVER)")
+	email := fs.String("email", os.Getenv("TUBELY_EMAIL"), "email to log in with (TUBELY_EMAIL); the password is read from TUBELY_PASSWORD")
+	token := fs.String("token", os.Getenv("TUBELY_TOKEN"), "access token to use instead of logging in (TUBELY_TOKEN)")
+	configPath := fs.String("config", "", "JSON file of per-folder upload settings")
+	interval := fs.Duration("interval", 10*time.Second, "how often to scan the directory")
+	settle := fs.Duration("settle", 30*time.Second, "how long a file has to stay unchanged before it's uploaded")
+	archive := fs.String("archive", "archive", "directory, relative to DIR, uploaded files are moved to")
+	failed := fs.String("failed", "failed", "directory, relative to DIR, files the server rejects are moved to")
+	partSizeMiB := fs.Int64("part-size", 8, "part size in MiB, 5 to 64")
+	parallel := fs.Int("parallel", 4, "parts to send at once")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected one directory")
+	}
+	dir, err := filepath.Abs(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if info, err := os.Stat(dir); err != nil {
+		return err
+	} else if !info.IsDir() {
+		return fmt.Errorf("%s isn't a directory", dir)
+	}
+	partSize := *partSizeMiB << 20
+	if partSize < client.MinPartSize || partSize > client.MaxPartSize {
+		return errors.New("-part-size must be between 5 and 64")
+	}
+	if *parallel < 1 {
+		return errors.New("-parallel must be at least 1")
+	}
+	if *interval <= 0 || *settle < 0 {
+		return errors.New("-interval must be positive and -settle can't be negative")
+	}
+	if !filepath.IsLocal(*archive) || !filepath.IsLocal(*failed) {
+		return errors.New("-archive and -failed must be relative to DIR")
+	}
+
+	config := &config{}
+	if *configPath != "" {
+		config, err = loadConfig(*configPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	c := client.New(*server)
+	switch {
+	case *token != "":
+		c.SetToken(*token, "")
+	case *email != "":
+		password := os.Getenv("TUBELY_PASSWORD")
+		if password == "" {
+			return errors.New("set TUBELY_PASSWORD to log in")
+		}
+		if err := c.Login(ctx, *email, password); err != nil {
+			return fmt.Errorf("couldn't log in: %w", err)
+		}
+	default:
+		return errors.New("pass -email or -token")
+	}
+
+	w, err := newWatcher(c, dir, config)
+	if err != nil {
+		return err
+	}
+	w.settle = *settle
+	w.archiveDir = filepath.Join(dir, *archive)
+	w.failedDir = filepath.Join(dir, *failed)
+	w.partSize = partSize
+	w.parallel = *parallel
+
+	log.Printf("Watching %s", dir)
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		if err := w.scan(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Couldn't scan %s: %v", dir, err)
+		}
+		select {
+		case <-ctx.Done():
+			log.Printf("Stopping; unfinished uploads will be resumed")
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func envOr(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/client"
+)
+
+// stateDirName is where, inside the watched directory, the watcher keeps
+// its journal of unfinished uploads and its log of results.
+const stateDirName = ".tubely-watch"
+
+// pending is a file being uploaded. It's journaled once the video exists,
+// so a restart resumes the upload into the same video rather than making
+// another one.
+type pending struct {
+	VideoID  string    `json:"video_id"`
+	UploadID string    `json:"upload_id,omitempty"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mod_time"`
+	PartSize int64     `json:"part_size"`
+}
+
+// result is a line of the results log.
+type result struct {
+	File     string    `json:"file"`
+	Status   string    `json:"status"`
+	VideoID  string    `json:"video_id,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Bytes    int64     `json:"bytes"`
+	Seconds  float64   `json:"seconds"`
+	Finished time.Time `json:"finished_at"`
+}
+
+// seen is what a scan found of a file, to tell when it stops changing.
+// A file whose upload failed waits until retryAt, twice as long after each
+// failure, unless it changes.
+type seen struct {
+	size     int64
+	modTime  time.Time
+	since    time.Time
+	failures int
+	retryAt  time.Time
+}
+
+const maxRetryDelay = 10 * time.Minute
+
+type watcher struct {
+	client *client.Client
+	dir    string
+	config *config
+
+	settle     time.Duration
+	archiveDir string
+	failedDir  string
+	partSize   int64
+	parallel   int
+
+	seen    map[string]seen
+	pending map[string]pending
+}
+
+func newWatcher(c *client.Client, dir string, config *config) (*watcher, error) {
+	w := &watcher{
+		client:  c,
+		dir:     dir,
+		config:  config,
+		seen:    map[string]seen{},
+		pending: map[string]pending{},
+	}
+	if err := os.MkdirAll(w.stateDir(), 0o755); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(w.journalPath())
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &w.pending); err != nil {
+			return nil, fmt.Errorf("invalid journal %s: %w", w.journalPath(), err)
+		}
+	}
+	return w, nil
+}
+
+func (w *watcher) stateDir() string    { return filepath.Join(w.dir, stateDirName) }
+func (w *watcher) journalPath() string { return filepath.Join(w.stateDir(), "pending.json") }
+func (w *watcher) resultsPath() string { return filepath.Join(w.stateDir(), "results.jsonl") }
+
+// scan uploads the video files under the directory that have settled, one
+// at a time.
+func (w *watcher) scan(ctx context.Context) error {
+	now := time.Now()
+	var ready []string
+	found := map[string]bool{}
+	err := filepath.WalkDir(w.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != w.dir && (strings.HasPrefix(d.Name(), ".") || path == w.archiveDir || path == w.failedDir) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasPrefix(d.Name(), ".") || !d.Type().IsRegular() || client.ContentType(path) == "" {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(w.dir, path)
+		if err != nil {
+			return err
+		}
+		found[rel] = true
+		prev, ok := w.seen[rel]
+		if !ok || prev.size != info.Size() || !prev.modTime.Equal(info.ModTime()) {
+			prev = seen{size: info.Size(), modTime: info.ModTime(), since: now}
+			w.seen[rel] = prev
+		}
+		if now.Sub(prev.since) >= w.settle && !now.Before(prev.retryAt) {
+			ready = append(ready, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for rel := range w.seen {
+		if !found[rel] {
+			delete(w.seen, rel)
+		}
+	}
+	for rel := range w.pending {
+		if !found[rel] {
+			log.Printf("%s is gone; forgetting its upload to video %s", rel, w.pending[rel].VideoID)
+			delete(w.pending, rel)
+			if err := w.saveJournal(); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, rel := range ready {
+		if ctx.Err() != nil {
+			return nil
+		}
+		w.process(ctx, rel)
+	}
+	return nil
+}
+
+// process uploads one file and logs how it went. Files the server rejects
+// are moved to the failed directory; other failures are retried on a later
+// scan.
+func (w *watcher) process(ctx context.Context, rel string) {
+	start := time.Now()
+	info, err := os.Stat(filepath.Join(w.dir, rel))
+	if err != nil {
+		log.Printf("Couldn't read %s: %v", rel, err)
+		return
+	}
+	log.Printf("Uploading %s (%.1f MiB)", rel, float64(info.Size())/(1<<20))
+	video, err := w.upload(ctx, rel, info)
+	res := result{File: rel, Bytes: info.Size(), Seconds: time.Since(start).Seconds()}
+	switch {
+	case err == nil:
+		res.Status, res.VideoID = "uploaded", video.ID
+		log.Printf("Uploaded %s as video %s in %s", rel, video.ID, time.Since(start).Round(time.Second))
+		if err := w.move(rel, w.archiveDir); err != nil {
+			log.Printf("Couldn't archive %s: %v", rel, err)
+		}
+	case ctx.Err() != nil:
+		return
+	case rejected(err):
+		res.Status, res.Error = "rejected", err.Error()
+		res.VideoID = w.pending[rel].VideoID
+		log.Printf("Server rejected %s: %v", rel, err)
+		if err := w.move(rel, w.failedDir); err != nil {
+			log.Printf("Couldn't move %s: %v", rel, err)
+		}
+	default:
+		res.Status, res.Error = "retrying", err.Error()
+		res.VideoID = w.pending[rel].VideoID
+		s := w.seen[rel]
+		s.failures++
+		s.retryAt = time.Now().Add(min(time.Duration(1<<min(s.failures, 10))*time.Second*15, maxRetryDelay))
+		w.seen[rel] = s
+		log.Printf("Couldn't upload %s, will retry after %s: %v", rel, s.retryAt.Format(time.TimeOnly), err)
+	}
+	if res.Status != "retrying" {
+		delete(w.pending, rel)
+		if err := w.saveJournal(); err != nil {
+			log.Printf("Couldn't save journal: %v", err)
+		}
+		delete(w.seen, rel)
+	}
+	if err := w.logResult(res); err != nil {
+		log.Printf("Couldn't log result: %v", err)
+	}
+}
+
+// rejected reports whether the server refused the file itself, so sending
+// it again won't help.
+func rejected(err error) bool {
+	status := client.StatusCode(err)
+	return status >= 400 && status < 500 &&
+		status != http.StatusUnauthorized && status != http.StatusRequestTimeout && status != http.StatusTooManyRequests
+}
+
+func (w *watcher) upload(ctx context.Context, rel string, info fs.FileInfo) (client.Video, error) {
+	p, ok := w.pending[rel]
+	if ok && (p.Size != info.Size() || !p.ModTime.Equal(info.ModTime()) || p.PartSize != w.partSize) {
+		// The file changed since the upload started, so its parts are no
+		// good; its video is reused.
+		p.UploadID = ""
+	}
+	settings := w.config.settingsFor(rel)
+	if p.VideoID == "" {
+		name := filepath.Base(rel)
+		video, err := w.client.CreateVideo(ctx, client.VideoParams{
+			Title:       strings.TrimSuffix(name, filepath.Ext(name)),
+			Description: settings.Description,
+			Tags:        settings.Tags,
+			PresetID:    settings.PresetID,
+			SeriesID:    settings.SeriesID,
+		})
+		if err != nil {
+			return client.Video{}, fmt.Errorf("couldn't create video: %w", err)
+		}
+		p.VideoID = video.ID
+	}
+	p.Size, p.ModTime, p.PartSize = info.Size(), info.ModTime(), w.partSize
+	w.pending[rel] = p
+	if err := w.saveJournal(); err != nil {
+		return client.Video{}, err
+	}
+
+	var session client.UploadSession
+	if p.UploadID != "" {
+		var err error
+		session, err = w.client.ResumeUploadSession(ctx, p.UploadID)
+		if status := client.StatusCode(err); status == http.StatusNotFound || status == http.StatusConflict {
+			p.UploadID = ""
+		} else if err != nil {
+			return client.Video{}, fmt.Errorf("couldn't resume upload: %w", err)
+		}
+	}
+	if p.UploadID == "" {
+		var err error
+		session, err = w.client.CreateUploadSession(ctx, client.UploadSessionParams{
+			VideoID:     p.VideoID,
+			Filename:    filepath.Base(rel),
+			ContentType: client.ContentType(rel),
+			Profile:     settings.Profile,
+			PresetID:    settings.PresetID,
+			Parts:       client.PartCount(info.Size(), w.partSize),
+		})
+		if err != nil {
+			return client.Video{}, fmt.Errorf("couldn't start upload: %w", err)
+		}
+		p.UploadID = session.ID
+		w.pending[rel] = p
+		if err := w.saveJournal(); err != nil {
+			return client.Video{}, err
+		}
+	}
+
+	file, err := os.Open(filepath.Join(w.dir, rel))
+	if err != nil {
+		return client.Video{}, err
+	}
+	defer file.Close()
+	err = w.client.UploadParts(ctx, session, file, info.Size(), w.partSize, client.UploadPartsOptions{Parallel: w.parallel})
+	if err != nil {
+		return client.Video{}, err
+	}
+	return w.client.CompleteUploadSession(ctx, session.ID, int(client.PartCount(info.Size(), w.partSize)))
+}
+
+// move moves a file into dir at the same relative path. A file already
+// there isn't replaced; the new one gets a timestamp in its name.
+func (w *watcher) move(rel, dir string) error {
+	dest := filepath.Join(dir, rel)
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return err
+	}
+	if _, err := os.Lstat(dest); err == nil {
+		ext := filepath.Ext(dest)
+		dest = fmt.Sprintf("%s-%s%s", strings.TrimSuffix(dest, ext), time.Now().UTC().Format("20060102T150405"), ext)
+	}
+	return os.Rename(filepath.Join(w.dir, rel), dest)
+}
+
+// saveJournal writes the pending uploads, replacing the journal whole so a
+// crash mid-write can't leave it truncated.
+func (w *watcher) saveJournal() error {
+	data, err := json.MarshalIndent(w.pending, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := w.journalPath() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, w.journalPath())
+}
+
+func (w *watcher) logResult(res result) error {
+	res.Finished = time.Now().UTC()
+	data, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(w.resultsPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
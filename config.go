@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ffmpeg"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/settings"
+)
+
+// requiredSettings are the variables the server can't start without.
+var requiredSettings = []string{"DB_PATH", "JWT_SECRET", "PLATFORM", "FILEPATH_ROOT", "ASSETS_ROOT", "S3_BUCKET", "S3_REGION", "S3_CF_DISTRO", "PORT"}
+
+// loadConfig fills in the environment from the YAML file at CONFIG_PATH, if
+// set, then checks the settings every deployment needs and finds ffmpeg and
+// ffprobe, reporting every problem at once. Other settings are checked as
+// they're read.
+func loadConfig() error {
+	if path := os.Getenv("CONFIG_PATH"); path != "" {
+		if err := settings.LoadFile(path); err != nil {
+			return fmt.Errorf("couldn't load CONFIG_PATH: %w", err)
+		}
+	}
+
+	var problems settings.Problems
+	problems.Require(requiredSettings...)
+	if v := os.Getenv("S3_BUCKET"); v != "" {
+		problems.Check("S3_BUCKET", settings.BucketName(v))
+	}
+	// S3-compatible services name their regions as they like.
+	if v := os.Getenv("S3_REGION"); v != "" && os.Getenv("STORAGE_BACKEND") != "s3-compatible" {
+		problems.Check("S3_REGION", settings.Region(v))
+	}
+	if v := os.Getenv("PORT"); v != "" {
+		problems.Check("PORT", settings.Port(v))
+	}
+
+	binaries := []struct {
+		env  string
+		path *string
+	}{
+		{"FFMPEG_PATH", &ffmpeg.FFmpegPath},
+		{"FFPROBE_PATH", &ffmpeg.FFprobePath},
+	}
+	for _, bin := range binaries {
+		if v := os.Getenv(bin.env); v != "" {
+			*bin.path = v
+		}
+		found, err := settings.FindBinary(*bin.path)
+		if err != nil {
+			problems.Check(bin.env, err)
+			continue
+		}
+		*bin.path = found
+	}
+	return problems.Err()
+}
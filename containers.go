@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+)
+
+// videoContainer is a container videos can be uploaded in. The processing
+// pipeline only works on MP4, so uploads in any other container are
+// converted to MP4 first.
+type videoContainer struct {
+	name string
+	// mediaTypes are the Content-Types uploads in the container are sent
+	// as; the first is the one files are given by their extension.
+	mediaTypes []string
+	extensions []string
+	// demuxer is a name ffprobe lists in the format_name of files in the
+	// container.
+	demuxer string
+	// browserCodecs marks the containers whose uploads are converted to
+	// H.264 and AAC, the codecs every browser plays in MP4, copying only
+	// streams that already are. QuickTime files keep any stream MP4 can
+	// carry, which is mostly what Apple devices record.
+	browserCodecs bool
+}
+
+// ebmlMagic starts every Matroska and WebM file.
+var ebmlMagic = []byte{0x1a, 0x45, 0xdf, 0xa3}
+
+var videoContainers = []videoContainer{
+	{name: "mp4", mediaTypes: []string{"video/mp4"}, extensions: []string{".mp4", ".m4v"}, demuxer: "mp4"},
+	{name: "mov", mediaTypes: []string{"video/quicktime"}, extensions: []string{".mov"}, demuxer: "mov"},
+	{name: "webm", mediaTypes: []string{"video/webm"}, extensions: []string{".webm"}, demuxer: "matroska", browserCodecs: true},
+	{name: "mkv", mediaTypes: []string{"video/x-matroska", "video/matroska"}, extensions: []string{".mkv"}, demuxer: "matroska", browserCodecs: true},
+}
+
+// defaultVideoContainers are the containers uploads can come in unless
+// VIDEO_CONTAINERS lists others.
+const defaultVideoContainers = "mp4,mov,webm,mkv"
+
+// parseVideoContainers parses a comma-separated list of container names
+// into the allowed containers by media type.
+func parseVideoContainers(s string) (map[string]videoContainer, error) {
+	allowed := map[string]videoContainer{}
+	for _, name := range strings.Split(s, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		i := slices.IndexFunc(videoContainers, func(c videoContainer) bool { return c.name == name })
+		if i < 0 {
+			return nil, fmt.Errorf("unknown container %q", name)
+		}
+		for _, mediaType := range videoContainers[i].mediaTypes {
+			allowed[mediaType] = videoContainers[i]
+		}
+	}
+	if len(allowed) == 0 {
+		return nil, fmt.Errorf("no containers in %q", s)
+	}
+	return allowed, nil
+}
+
+// videoContentType returns the Content-Type of video files named like
+// name, going by the extension, or "" if it isn't one of a supported
+// container, allowed or not.
+func videoContentType(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	for _, c := range videoContainers {
+		if slices.Contains(c.extensions, ext) {
+			return c.mediaTypes[0]
+		}
+	}
+	return ""
+}
+
+// sniff reports whether head, the start of a file, looks like a file in
+// the container.
+func (c videoContainer) sniff(head []byte) bool {
+	if c.demuxer == "matroska" {
+		return bytes.HasPrefix(head, ebmlMagic)
+	}
+	return sniffVideo(head, c.mediaTypes[0])
+}
+
+// isMP4 reports whether files in the container go through the pipeline as
+// they are.
+func (c videoContainer) isMP4() bool {
+	return c.name == "mp4"
+}
+
+// tempExt is the extension uploads in the container are spooled with, for
+// ffmpeg to recognize them by.
+func (c videoContainer) tempExt() string {
+	return c.extensions[0]
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tenants"
+)
+
+// contentKeyPrefix is where video files are stored by their content, so
+// videos with the same file share one object. Each video that points at
+// one holds a reference to it, and the object is deleted with the last.
+const contentKeyPrefix = "content/"
+
+// contentObjectKey is the key a video file with the given SHA-256 is
+// stored under. A file saved with its filename carries it in its
+// Content-Disposition, so the filename is part of what a file is
+// deduplicated by.
+func contentObjectKey(sum []byte, filename string) string {
+	key := contentKeyPrefix + hex.EncodeToString(sum)
+	if filename != "" {
+		name := sha256.Sum256([]byte(filename))
+		key += "-" + hex.EncodeToString(name[:4])
+	}
+	return key + ".mp4"
+}
+
+func isContentKey(key string) bool {
+	return strings.HasPrefix(key, contentKeyPrefix)
+}
+
+// contentObjects serializes taking and dropping references to content
+// objects, so an object isn't deleted by its last reference going away
+// while another video is deciding to share it.
+type contentObjects struct {
+	mu sync.Mutex
+}
+
+// storedVideoFile is where storeVideoFile put a video's main file.
+// Encryption is the server-side encryption mode it has, empty for the
+// bucket's default.
+type storedVideoFile struct {
+	Key        string
+	SHA256     string
+	Encryption string
+}
+
+// storeVideoFile stores the file at path as a video's main file in target,
+// under its content key. If another video already stored the same file, its
+// object is shared instead of uploading it again, unless it isn't encrypted
+// the way target now asks for. Otherwise the file is uploaded with its
+// SHA-256, so S3 rejects it if it arrives corrupted. The reference is
+// dropped again if cleanup runs without being committed.
+func (cfg *apiConfig) storeVideoFile(ctx context.Context, cleanup *cleanupStack, target tenants.Target, path, filename string) (storedVideoFile, error) {
+	sum, err := sha256File(path)
+	if err != nil {
+		return storedVideoFile{}, err
+	}
+	stored := storedVideoFile{
+		Key:        contentObjectKey(sum, filename),
+		SHA256:     hex.EncodeToString(sum),
+		Encryption: encryptionMode(target),
+	}
+	key := stored.Key
+
+	cfg.contentObjects.mu.Lock()
+	refs, err := cfg.db.RetainContentObject(target.Bucket, key)
+	cfg.contentObjects.mu.Unlock()
+	if err != nil {
+		return storedVideoFile{}, err
+	}
+	cleanup.onError("release "+key, func() error {
+		return cfg.releaseContentObject(context.Background(), target, key)
+	})
+
+	// The object can be missing despite other references if the upload
+	// that holds them is still running or has failed. Uploading it again
+	// writes the same bytes.
+	if refs > 1 {
+		start := time.Now()
+		mode, exists, err := headObjectEncryption(ctx, target, key)
+		if err != nil {
+			return storedVideoFile{}, err
+		}
+		if exists && (target.Encryption == nil || mode == target.Encryption.Mode) {
+			// The object was tagged as trashed if its last reference
+			// was, and this one is live.
+			if target.IsS3() {
+				err = setObjectTrashed(ctx, target, key, false)
+			}
+			logStep(ctx, "s3", fmt.Sprintf("reuse s3://%s/%s (%d references)", target.Bucket, key, refs), start, err)
+			if err != nil {
+				return storedVideoFile{}, err
+			}
+			return stored, nil
+		}
+	}
+
+	opts := []func(*s3.PutObjectInput){withChecksumSHA256(sum)}
+	if filename != "" {
+		opts = append(opts, withContentDisposition(filename))
+	}
+	if err := cfg.uploadVideoFile(ctx, target, key, path, "video/mp4", opts...); err != nil {
+		return storedVideoFile{}, err
+	}
+	return stored, nil
+}
+
+// releaseContentObject drops a video's reference to the object under key
+// and deletes it if that was the last. Objects without recorded references
+// are deleted outright.
+func (cfg *apiConfig) releaseContentObject(ctx context.Context, target tenants.Target, key string) error {
+	cfg.contentObjects.mu.Lock()
+	defer cfg.contentObjects.mu.Unlock()
+	refs, tracked, err := cfg.db.ReleaseContentObject(target.Bucket, key)
+	if err != nil {
+		return err
+	}
+	if tracked && refs > 0 {
+		return nil
+	}
+	return target.Storage().Delete(ctx, key)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Uploads declare their type with a Content-Type the client picks, so the
+// content is checked as well: its first bytes with http.DetectContentType,
+// then videos with ffprobe and thumbnails by decoding them.
+
+// sniffLen is how much of a file http.DetectContentType looks at.
+const sniffLen = 512
+
+// maxThumbnailPixels caps the size of uploaded thumbnails, which are
+// decoded whole to verify them.
+const maxThumbnailPixels = 40_000_000
+
+// quickTimeAtoms are the atoms older QuickTime files start with instead of
+// an ftyp box.
+var quickTimeAtoms = map[string]bool{
+	"moov": true,
+	"mdat": true,
+	"wide": true,
+	"free": true,
+	"skip": true,
+	"pnot": true,
+}
+
+// heicBrands are the ftyp brands of HEIC and HEIF images.
+var heicBrands = map[string]bool{
+	"heic": true,
+	"heix": true,
+	"hevc": true,
+	"hevx": true,
+	"heim": true,
+	"heis": true,
+	"mif1": true,
+	"msf1": true,
+}
+
+// isoBrands returns the major and compatible brands of the ftyp box head
+// starts with, the box MP4, most MOV and HEIC files open with. It returns
+// nil if there is none.
+func isoBrands(head []byte) []string {
+	if len(head) < 16 || string(head[4:8]) != "ftyp" {
+		return nil
+	}
+	size := int(binary.BigEndian.Uint32(head))
+	if size < 16 {
+		return nil
+	}
+	size = min(size, len(head))
+	brands := []string{string(head[8:12])}
+	for i := 16; i+4 <= size; i += 4 {
+		brands = append(brands, string(head[i:i+4]))
+	}
+	return brands
+}
+
+// sniffVideo reports whether head, the start of a file, looks like a video
+// of mediaType. http.DetectContentType only recognizes MP4 brands, so other
+// ISO brands and QuickTime's atoms are recognized by the first box.
+func sniffVideo(head []byte, mediaType string) bool {
+	switch http.DetectContentType(head) {
+	case "video/mp4":
+		return true
+	case "application/octet-stream":
+	default:
+		return false
+	}
+	if brands := isoBrands(head); brands != nil {
+		return !slices.ContainsFunc(brands, func(b string) bool { return heicBrands[b] })
+	}
+	return mediaType == "video/quicktime" && len(head) >= 8 && quickTimeAtoms[string(head[4:8])]
+}
+
+// sniffImage reports whether head, the start of a file, looks like an image
+// of mediaType, one of the thumbnail types.
+func sniffImage(head []byte, mediaType string) bool {
+	if heicTypes[mediaType] {
+		return slices.ContainsFunc(isoBrands(head), func(b string) bool { return heicBrands[b] })
+	}
+	return http.DetectContentType(head) == mediaType
+}
+
+// sniffFile returns the first bytes of the file at path.
+func sniffFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(f, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return head[:n], nil
+}
+
+// verifyVideoContent checks with ffprobe that the file at path is in
+// container and has a video stream, which its first bytes can't tell.
+func (cfg *apiConfig) verifyVideoContent(ctx context.Context, path string, container videoContainer) error {
+	probe, err := cfg.probeVideo(ctx, path)
+	if err != nil {
+		return err
+	}
+	// ffprobe names its MP4 and MOV demuxer "mov,mp4,m4a,3gp,3g2,mj2", and
+	// its Matroska and WebM one "matroska,webm".
+	if !slices.Contains(strings.Split(probe.Format.FormatName, ","), container.demuxer) {
+		return fmt.Errorf("container is %q", probe.Format.FormatName)
+	}
+	for _, stream := range probe.Streams {
+		if stream.CodecType == "video" && stream.Disposition.AttachedPic == 0 {
+			return nil
+		}
+	}
+	return errors.New("no video stream")
+}
+
+// imageCheck decodes an image as it streams past, so a thumbnail is
+// verified without being buffered first.
+type imageCheck struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+// checkImage returns a reader that reads r and feeds it to a decoder that
+// expects an image in format, as image.Decode names them. Call wait once
+// the reader is done with.
+func checkImage(r io.Reader, format string) (io.Reader, *imageCheck) {
+	pr, pw := io.Pipe()
+	c := &imageCheck{pw: pw, done: make(chan error, 1)}
+	go func() {
+		c.done <- decodeImage(pr, format)
+		// Keep the upload flowing if decoding stopped early.
+		io.Copy(io.Discard, pr)
+	}()
+	return io.TeeReader(r, pw), c
+}
+
+// wait returns the decoder's verdict on what was read.
+func (c *imageCheck) wait() error {
+	c.pw.Close()
+	return <-c.done
+}
+
+func decodeImage(r io.Reader, format string) error {
+	// The header is read first, so a huge image is rejected before its
+	// pixels are allocated.
+	var header bytes.Buffer
+	config, got, err := image.DecodeConfig(io.TeeReader(r, &header))
+	if err != nil {
+		return err
+	}
+	if got != format {
+		return fmt.Errorf("image is %s, not %s", got, format)
+	}
+	if config.Width*config.Height > maxThumbnailPixels {
+		return fmt.Errorf("image is %dx%d, over %d pixels", config.Width, config.Height, maxThumbnailPixels)
+	}
+	_, _, err = image.Decode(io.MultiReader(&header, r))
+	return err
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// Custom metadata lets integrators stamp videos with their own IDs, such
+// as a CMS ID or an order number, and find them by it with
+// GET /api/videos?custom_metadata.<key>=<value>.
+const (
+	maxCustomMetadataKeys       = 50
+	maxCustomMetadataValueBytes = 1024
+	// customMetadataFilterPrefix starts the query parameters that filter
+	// video listings by custom metadata.
+	customMetadataFilterPrefix = "custom_metadata."
+)
+
+// customMetadataKey is what a custom metadata key can be: a letter or
+// digit, then up to 63 letters, digits, dots, hyphens and underscores.
+var customMetadataKey = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// handlerVideoCustomMetadataPatch updates a video's custom metadata with a
+// JSON merge patch: each key in the body is set to its value, or removed
+// if it's null, and keys left out are kept.
+func (cfg *apiConfig) handlerVideoCustomMetadataPatch(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.requireVideoOwner(w, r)
+	if !ok {
+		return
+	}
+
+	patch := map[string]*string{}
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Custom metadata must be an object of strings", err)
+		return
+	}
+	metadata := make(map[string]string, len(video.CustomMetadata))
+	for key, value := range video.CustomMetadata {
+		metadata[key] = value
+	}
+	for key, value := range patch {
+		if !customMetadataKey.MatchString(key) {
+			respondWithError(w, http.StatusBadRequest, "Invalid custom metadata key", fmt.Errorf("invalid key %q", key))
+			return
+		}
+		if value == nil {
+			delete(metadata, key)
+			continue
+		}
+		if len(*value) > maxCustomMetadataValueBytes {
+			respondWithError(w, http.StatusBadRequest, "Custom metadata values can be up to 1024 bytes", fmt.Errorf("value of %q is %d bytes", key, len(*value)))
+			return
+		}
+		metadata[key] = *value
+	}
+	if len(metadata) > maxCustomMetadataKeys {
+		respondWithError(w, http.StatusBadRequest, "Videos can have up to 50 custom metadata keys", nil)
+		return
+	}
+
+	if err := cfg.db.SetVideoCustomMetadata(video.ID, metadata); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+	cfg.emitEvent(eventVideoUpdated, video.ID, map[string]any{"custom_metadata": metadata})
+
+	video.CustomMetadata = metadata
+	video, err := cfg.dbVideoToSignedVideo(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URLs", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+// parseCustomMetadataFilter reads the custom_metadata.<key> parameters of
+// a video listing into params. If ok is false, an error response has been
+// written.
+func parseCustomMetadataFilter(w http.ResponseWriter, r *http.Request, params *database.VideoListParams) bool {
+	for name, values := range r.URL.Query() {
+		key, found := strings.CutPrefix(name, customMetadataFilterPrefix)
+		if !found {
+			continue
+		}
+		if !customMetadataKey.MatchString(key) {
+			respondWithError(w, http.StatusBadRequest, "Invalid custom metadata key", fmt.Errorf("invalid key %q", key))
+			return false
+		}
+		if params.CustomMetadata == nil {
+			params.CustomMetadata = map[string]string{}
+		}
+		params.CustomMetadata[key] = values[0]
+	}
+	return true
+}
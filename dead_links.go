@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+type deadLinkSweepResult struct {
+	Checked int `json:"checked"`
+	Broken  int `json:"broken"`
+}
+
+// sweepDeadLinks verifies the video and thumbnail of every video and records
+// the results. A link that becomes broken emits a link.broken event.
+func (cfg *apiConfig) sweepDeadLinks(ctx context.Context) (deadLinkSweepResult, error) {
+	result := deadLinkSweepResult{}
+	videos, err := cfg.db.GetAllVideos()
+	if err != nil {
+		return result, err
+	}
+
+	for _, video := range videos {
+		checks := []database.LinkCheck{}
+		if video.VideoURL != nil {
+			check := database.LinkCheck{VideoID: video.ID, Kind: "video", URL: *video.VideoURL}
+			check.Broken, check.Detail = cfg.checkVideoLink(ctx, video)
+			checks = append(checks, check)
+		}
+		if video.ThumbnailURL != nil {
+			check := database.LinkCheck{VideoID: video.ID, Kind: "thumbnail", URL: *video.ThumbnailURL}
+			check.Broken, check.Detail = cfg.checkThumbnailLink(ctx, *video.ThumbnailURL)
+			checks = append(checks, check)
+		}
+
+		for _, check := range checks {
+			check.CheckedAt = cfg.clock.Now().UTC()
+			wasBroken, err := cfg.db.SaveLinkCheck(check)
+			if err != nil {
+				return result, err
+			}
+			result.Checked++
+			if check.Broken {
+				result.Broken++
+				if !wasBroken {
+					cfg.emitEvent(eventLinkBroken, video.ID, check)
+				}
+			}
+		}
+	}
+	return result, nil
+}
+
+func (cfg *apiConfig) checkVideoLink(ctx context.Context, video database.Video) (broken bool, detail string) {
+	target, key, err := cfg.videoObject(ctx, video)
+	if err != nil {
+		return true, err.Error()
+	}
+	exists, err := objectExists(ctx, target, key)
+	if err != nil {
+		return true, err.Error()
+	}
+	if !exists {
+		return true, "object not found"
+	}
+	return false, ""
+}
+
+func (cfg *apiConfig) checkThumbnailLink(ctx context.Context, thumbnailURL string) (broken bool, detail string) {
+	localPrefix := fmt.Sprintf("http://localhost:%s/assets/", cfg.port)
+	if name, ok := strings.CutPrefix(thumbnailURL, localPrefix); ok {
+		if _, err := os.Stat(filepath.Join(cfg.assetsRoot, filepath.Base(name))); err != nil {
+			return true, "asset file missing"
+		}
+		return false, ""
+	}
+	if strings.HasPrefix(thumbnailURL, thumbnailPrefix) {
+		exists, err := objectExists(ctx, cfg.tenants.Defaults(), thumbnailURL)
+		if err != nil {
+			return true, err.Error()
+		}
+		if !exists {
+			return true, "object not found"
+		}
+		return false, ""
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, thumbnailURL, nil)
+	if err != nil {
+		return true, err.Error()
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return true, err.Error()
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return true, resp.Status
+	}
+	return false, ""
+}
+
+func (cfg *apiConfig) handlerDeadLinksList(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+	links, err := cfg.db.GetBrokenLinks()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve broken links", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, links)
+}
+
+func (cfg *apiConfig) handlerDeadLinksSweep(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+	result, err := cfg.sweepDeadLinks(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Dead link sweep failed", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, result)
+}
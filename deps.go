@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/cdn"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/clock"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ffmpeg"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tenants"
+)
+
+// deps are the subsystems the handlers are built on, as opposed to the
+// settings in the rest of apiConfig. main wires the real ones; tests build
+// their apiConfig around their own, such as a scratch database, fake S3
+// and a fake clock, as newTestConfig does.
+type deps struct {
+	db database.Client
+	// tenants resolves the storage targets objects are kept in.
+	tenants *tenants.Pool
+	// prober describes media files.
+	prober mediaProber
+	// signer signs the distribution's URLs and cookies for private
+	// content; nil leaves them unsigned.
+	signer cdn.Signer
+	// jobs is the processing queue.
+	jobs *jobs.Queue
+	// clock is what token expiry, scheduled publishing, link lifetimes
+	// and expiry sweeps go by. jobs keeps the clock it was made with.
+	clock  clock.Clock
+	logger *slog.Logger
+}
+
+// mediaProber returns ffprobe's JSON description of the streams and format
+// of the file at path.
+type mediaProber interface {
+	Probe(ctx context.Context, path string) ([]byte, error)
+}
+
+// ffprobe is the mediaProber that runs ffprobe.
+type ffprobe struct{}
+
+func (ffprobe) Probe(ctx context.Context, path string) ([]byte, error) {
+	return ffmpeg.FFprobe().
+		Flag("-v", "error").
+		Flag("-print_format", "json").
+		Flag("-show_streams").
+		Flag("-show_format").
+		Input(path).
+		Run(ctx)
+}
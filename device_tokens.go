@@ -0,0 +1,182 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/clock"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Device tokens are for apps such as TV apps, which can't refresh a JWT
+// every hour but mustn't be able to upload either. A user exchanges a JWT
+// for one on the device, which sends it as Authorization: Device <token>
+// along with the same X-Device-ID it was issued for. It identifies the
+// viewer wherever the API only reads, see viewerID; everything that
+// changes anything needs a JWT, which a device token isn't.
+const deviceIDHeader = "X-Device-ID"
+
+var (
+	deviceIDLimit   = textLimit{field: "device_id", maxRunes: 128, maxBytes: 256, required: true}
+	deviceNameLimit = textLimit{field: "device_name", maxRunes: 100, maxBytes: 400}
+)
+
+// deviceTokenPrefixLength is how much of a token is kept in the clear:
+// the "tubely_device_" prefix and the first 8 hex characters.
+const deviceTokenPrefixLength = len(auth.DeviceTokenPrefix) + 8
+
+// deviceTokenTouchInterval is how stale a token's last_used_at can get,
+// so playback doesn't write to the database on every request.
+const deviceTokenTouchInterval = time.Minute
+
+var errInvalidDeviceToken = errors.New("invalid, expired or revoked device token")
+
+// deviceTokenHash is the hash a device token is stored and looked up by,
+// peppered like apiKeyHash. Tokens issued before field encryption was
+// turned on stop working, and their devices exchange a JWT again.
+func (cfg *apiConfig) deviceTokenHash(token string) string {
+	if hash := cfg.fields.Index("device_tokens.token_hash", token); hash != "" {
+		return hash
+	}
+	return auth.HashAPIKey(token)
+}
+
+// deviceTokenUser returns the user whose device token the request carries.
+// It returns errInvalidDeviceToken if the token is unknown, expired,
+// revoked, sent from another device or its user is gone.
+func (cfg *apiConfig) deviceTokenUser(r *http.Request) (*database.User, error) {
+	token, err := auth.GetDeviceToken(r.Header)
+	if err != nil {
+		return nil, errInvalidDeviceToken
+	}
+	deviceToken, err := cfg.db.GetDeviceTokenByHash(cfg.deviceTokenHash(token))
+	if err != nil {
+		return nil, err
+	}
+	if deviceToken == nil || deviceToken.RevokedAt != nil || clock.Expired(cfg.clock, deviceToken.ExpiresAt) {
+		return nil, errInvalidDeviceToken
+	}
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get(deviceIDHeader)), []byte(deviceToken.DeviceID)) != 1 {
+		return nil, errInvalidDeviceToken
+	}
+	user, err := cfg.db.GetUser(deviceToken.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, errInvalidDeviceToken
+	}
+	now := cfg.clock.Now().UTC()
+	if deviceToken.LastUsedAt == nil || now.Sub(*deviceToken.LastUsedAt) >= deviceTokenTouchInterval {
+		if err := cfg.db.TouchDeviceToken(deviceToken.ID, now); err != nil {
+			log.Printf("Couldn't record use of device token %s: %v", deviceToken.ID, err)
+		}
+	}
+	return user, nil
+}
+
+// handlerDeviceTokenCreate exchanges the user's JWT for a device token for
+// the device in the body, replacing any token it had. The token is only
+// ever in this response; afterwards just its prefix is shown. Impersonation
+// tokens can't create device tokens.
+func (cfg *apiConfig) handlerDeviceTokenCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		DeviceID   string `json:"device_id"`
+		DeviceName string `json:"device_name"`
+	}
+	type response struct {
+		database.DeviceToken
+		Token string `json:"token"`
+	}
+
+	userID, ok := cfg.requireOwnSession(w, r)
+	if !ok {
+		return
+	}
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	deviceID, err := deviceIDLimit.apply(params.DeviceID)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	deviceName, err := deviceNameLimit.apply(params.DeviceName)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	token, err := auth.MakeDeviceToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create device token", err)
+		return
+	}
+	now := cfg.clock.Now().UTC()
+	deviceToken := database.DeviceToken{
+		ID:         uuid.New(),
+		UserID:     userID,
+		DeviceID:   deviceID,
+		DeviceName: deviceName,
+		TokenHash:  cfg.deviceTokenHash(token),
+		Prefix:     token[:deviceTokenPrefixLength],
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(cfg.deviceTokenTTL),
+	}
+	if err := cfg.db.CreateDeviceToken(deviceToken); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save device token", err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, response{DeviceToken: deviceToken, Token: token})
+}
+
+// handlerDeviceTokensGet lists the user's device tokens, revoked and
+// expired ones included.
+func (cfg *apiConfig) handlerDeviceTokensGet(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.requireUser(w, r)
+	if !ok {
+		return
+	}
+	tokens, err := cfg.db.GetDeviceTokens(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get device tokens", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, tokens)
+}
+
+// handlerDeviceTokenRevoke revokes one of the user's device tokens, such
+// as that of a lost or sold TV. Its device is signed out from then on.
+func (cfg *apiConfig) handlerDeviceTokenRevoke(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.requireOwnSession(w, r)
+	if !ok {
+		return
+	}
+	tokenID, err := uuid.Parse(r.PathValue("tokenID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid device token ID", err)
+		return
+	}
+	deviceToken, err := cfg.db.GetDeviceToken(tokenID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get device token", err)
+		return
+	}
+	if deviceToken == nil || deviceToken.UserID != userID {
+		respondWithError(w, http.StatusNotFound, "Device token not found", nil)
+		return
+	}
+	if err := cfg.db.RevokeDeviceToken(deviceToken.ID, cfg.clock.Now().UTC()); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke device token", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
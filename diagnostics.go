@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+)
+
+const (
+	// maxDiagnosticPayload bounds the test payload of an upload
+	// diagnostic, which only needs to be big enough to time.
+	maxDiagnosticPayload = 8 << 20
+	// diagnosticBufferingMinPayload is the smallest payload buffering can
+	// be judged from; smaller ones fit in a few TCP segments anyway.
+	diagnosticBufferingMinPayload = 256 << 10
+	// diagnosticBufferingWindow is how quickly a payload that was already
+	// sitting in a proxy's buffer arrives.
+	diagnosticBufferingWindow = 10 * time.Millisecond
+	// diagnosticReadSize is the granularity at which arrival is timed.
+	diagnosticReadSize = 32 << 10
+)
+
+type uploadDiagnostic struct {
+	BytesReceived int64 `json:"bytes_received"`
+	// WaitMS is how long the payload took to start arriving once the
+	// request's headers had, and ReadMS how long it took to arrive.
+	WaitMS                   float64         `json:"wait_ms"`
+	ReadMS                   float64         `json:"read_ms"`
+	ThroughputBytesPerSecond float64         `json:"throughput_bytes_per_second"`
+	Proxy                    diagnosticProxy `json:"proxy"`
+	Limits                   uploadLimits    `json:"limits"`
+}
+
+// diagnosticProxy is what the server can tell about proxies between it and
+// the client. Buffering is "likely" when a large payload arrives faster than
+// any client network could send it, meaning something in front of the server
+// received it first; "unknown" when the payload is too small to tell. A
+// client on the server's own network can look buffered too.
+type diagnosticProxy struct {
+	Buffering    string   `json:"buffering"`
+	Via          []string `json:"via,omitempty"`
+	ForwardedFor []string `json:"forwarded_for,omitempty"`
+}
+
+// uploadLimits are the server's upload size limits in bytes, and how many
+// parts an upload session can have.
+type uploadLimits struct {
+	VideoUpload        int64 `json:"video_upload"`
+	BundleUpload       int64 `json:"bundle_upload"`
+	UploadSession      int64 `json:"upload_session"`
+	UploadPartMin      int64 `json:"upload_part_min"`
+	UploadPartMax      int64 `json:"upload_part_max"`
+	UploadSessionParts int   `json:"upload_session_parts"`
+	DiagnosticPayload  int64 `json:"diagnostic_payload"`
+}
+
+var serverUploadLimits = uploadLimits{
+	VideoUpload:        maxUploadSize,
+	BundleUpload:       maxBundleUploadSize,
+	UploadSession:      maxSessionUploadSize,
+	UploadPartMin:      minPartSize,
+	UploadPartMax:      maxPartSize,
+	UploadSessionParts: maxSessionParts,
+	DiagnosticPayload:  maxDiagnosticPayload,
+}
+
+// handlerUploadDiagnostic reads a test payload of up to
+// maxDiagnosticPayload bytes, discards it and reports how it arrived,
+// together with the server's upload limits. Support can have a user with
+// slow uploads run it: a low throughput with buffering unlikely points at
+// the client's network, while a proxy that buffers uploads slows down every
+// client behind it and hides their progress.
+func (cfg *apiConfig) handlerUploadDiagnostic(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	if _, err := auth.ValidateJWT(token, cfg.jwtSecret); err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+	if r.ContentLength > maxDiagnosticPayload {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Test payload is too large", nil)
+		return
+	}
+
+	start := time.Now()
+	var firstByte time.Time
+	body := http.MaxBytesReader(w, r.Body, maxDiagnosticPayload)
+	buf := make([]byte, diagnosticReadSize)
+	var n int64
+	for {
+		read, err := body.Read(buf)
+		if read > 0 && firstByte.IsZero() {
+			firstByte = time.Now()
+		}
+		n += int64(read)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Couldn't read test payload", err)
+			return
+		}
+	}
+	end := time.Now()
+
+	result := uploadDiagnostic{
+		BytesReceived: n,
+		Proxy: diagnosticProxy{
+			Buffering:    "unknown",
+			Via:          r.Header.Values("Via"),
+			ForwardedFor: r.Header.Values("X-Forwarded-For"),
+		},
+		Limits: serverUploadLimits,
+	}
+	if !firstByte.IsZero() {
+		result.WaitMS = milliseconds(firstByte.Sub(start))
+		result.ReadMS = milliseconds(end.Sub(firstByte))
+	}
+	if elapsed := end.Sub(start); elapsed > 0 {
+		result.ThroughputBytesPerSecond = float64(n) / elapsed.Seconds()
+	}
+	if n >= diagnosticBufferingMinPayload {
+		result.Proxy.Buffering = "unlikely"
+		if end.Sub(firstByte) < diagnosticBufferingWindow {
+			result.Proxy.Buffering = "likely"
+		}
+	}
+	respondWithJSON(w, http.StatusOK, result)
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
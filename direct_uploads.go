@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tenants"
+	"github.com/google/uuid"
+)
+
+// maxDirectUploadURLExpiry is the longest S3 accepts a presigned URL for.
+const maxDirectUploadURLExpiry = 7 * 24 * time.Hour
+
+// directUploadResponse is an upload session the client uploads its file
+// to storage for itself: one PUT of UploadURL with UploadHeaders, which
+// has to be sent before UploadURLExpiresAt.
+type directUploadResponse struct {
+	uploadSessionResponse
+	UploadURL          string            `json:"upload_url"`
+	UploadMethod       string            `json:"upload_method"`
+	UploadHeaders      map[string]string `json:"upload_headers"`
+	UploadURLExpiresAt time.Time         `json:"upload_url_expires_at"`
+}
+
+// handlerVideoUploadURL starts an upload session whose file the client
+// sends straight to storage with a presigned PUT, so it doesn't pass
+// through the server. The content type and size are signed into the URL,
+// and checked again when the upload is completed with
+// handlerVideoUploadComplete. The client keeps the session alive with
+// heartbeats meanwhile, like any other.
+func (cfg *apiConfig) handlerVideoUploadURL(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, tenantID, err := auth.ValidateTenantJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	type parameters struct {
+		Filename    string `json:"filename"`
+		ContentType string `json:"content_type"`
+		Profile     string `json:"profile"`
+		PresetID    string `json:"preset_id"`
+		Size        int64  `json:"size"`
+	}
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Size <= 0 {
+		respondWithError(w, http.StatusBadRequest, "Upload size is required", nil)
+		return
+	}
+
+	resp, ok := cfg.startDirectUpload(w, r, userID, tenantID, uploadSessionRequest{
+		VideoID:     videoID,
+		Filename:    params.Filename,
+		ContentType: params.ContentType,
+		Profile:     params.Profile,
+		PresetID:    params.PresetID,
+		Size:        params.Size,
+	})
+	if !ok {
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, resp)
+}
+
+// startDirectUpload stores a direct upload session for the file params
+// describes and presigns its PUT. If ok is false, an error response has
+// been written.
+func (cfg *apiConfig) startDirectUpload(w http.ResponseWriter, r *http.Request, userID uuid.UUID, tenantID string, params uploadSessionRequest) (resp directUploadResponse, ok bool) {
+	session, target, ok := cfg.newUploadSession(w, r, userID, tenantID, params)
+	if !ok {
+		return directUploadResponse{}, false
+	}
+	session.DirectSize = params.Size
+	session.ObjectKey = videoObjectKey(userID, params.VideoID, "uploads/"+session.ID.String())
+
+	// The URL lasts as long as heartbeats can keep the session alive, so a
+	// slow upload isn't cut off halfway.
+	expiresAt := session.CreatedAt.Add(cfg.uploadSessionMaxAge)
+	expires := min(expiresAt.Sub(cfg.clock.Now()), maxDirectUploadURLExpiry)
+	uploadURL, header, err := target.Storage().PresignPut(r.Context(), session.ObjectKey, session.ContentType, session.DirectSize, expires, withEncryption(target.Encryption))
+	if err != nil {
+		respondWithStorageError(w, http.StatusInternalServerError, "Couldn't presign upload URL", err)
+		return directUploadResponse{}, false
+	}
+	headers := make(map[string]string, len(header))
+	for name := range header {
+		headers[name] = header.Get(name)
+	}
+
+	if err := cfg.db.CreateUploadSession(session); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create upload session", err)
+		return directUploadResponse{}, false
+	}
+	return directUploadResponse{
+		uploadSessionResponse: cfg.uploadSessionResponse(session),
+		UploadURL:             uploadURL,
+		UploadMethod:          http.MethodPut,
+		UploadHeaders:         headers,
+		UploadURLExpiresAt:    cfg.clock.Now().Add(expires).UTC().Truncate(time.Second),
+	}, true
+}
+
+// handlerVideoUploadComplete completes the direct upload {"upload_id"} of
+// the video once the client's PUT has succeeded, processing the stored
+// file like any other upload. It's POST /api/uploads/{uploadID}/complete
+// under the video's path.
+func (cfg *apiConfig) handlerVideoUploadComplete(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		UploadID uuid.UUID `json:"upload_id"`
+	}
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	r.SetPathValue("uploadID", params.UploadID.String())
+	cfg.handlerUploadSessionComplete(w, r)
+}
+
+// requireDirectUpload checks that the client has stored the file of a
+// direct upload session, with the size it declared. If ok is false, an
+// error response has been written and the session is still active, so the
+// client can upload the file again.
+func (cfg *apiConfig) requireDirectUpload(w http.ResponseWriter, r *http.Request, target tenants.Target, session database.UploadSession) (ok bool) {
+	size, err := target.Storage().Size(r.Context(), session.ObjectKey)
+	if errors.Is(err, storage.ErrNotFound) {
+		respondWithError(w, http.StatusBadRequest, "Upload is incomplete", errors.New("the file hasn't been uploaded"))
+		return false
+	}
+	if err != nil {
+		respondWithStorageError(w, http.StatusBadGateway, "Couldn't check uploaded file", err)
+		return false
+	}
+	if size != session.DirectSize {
+		respondWithError(w, http.StatusBadRequest, "Uploaded file doesn't match its declared size", fmt.Errorf("%d bytes were uploaded, not %d", size, session.DirectSize))
+		return false
+	}
+	return true
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tenants"
+)
+
+// A DR bucket holds everything needed to rebuild the service elsewhere.
+// Objects and assets are copied under stable prefixes, so each export only
+// copies what changed since the last one; each export adds a database
+// snapshot and a manifest saying which objects it needs and where they
+// came from.
+const (
+	drObjectsPrefix = "dr/objects/"
+	drAssetsPrefix  = "dr/assets/"
+	drExportsPrefix = "dr/exports/"
+	drManifestName  = "manifest.json"
+	// drDefaultLabel stands in for the tenant of the default bucket in
+	// object keys.
+	drDefaultLabel = "_default"
+)
+
+type drManifest struct {
+	ExportedAt time.Time  `json:"exported_at"`
+	Database   string     `json:"database"`
+	Encrypted  bool       `json:"encrypted"`
+	AssetsURL  string     `json:"assets_url"`
+	Buckets    []drBucket `json:"buckets"`
+	Assets     []drObject `json:"assets"`
+}
+
+// drBucket is the objects of one bucket. ObjectURL is the prefix of their
+// URLs in the database, which an import rewrites if the objects land in a
+// different bucket.
+type drBucket struct {
+	Tenant    string     `json:"tenant,omitempty"`
+	Bucket    string     `json:"bucket"`
+	Region    string     `json:"region"`
+	ObjectURL string     `json:"object_url"`
+	Objects   []drObject `json:"objects"`
+}
+
+type drObject struct {
+	Key   string `json:"key"`
+	Size  int64  `json:"size"`
+	DRKey string `json:"dr_key"`
+}
+
+// parseDRFlags parses the flags the DR commands share plus any extra ones
+// registered by register, and returns the DR bucket.
+func parseDRFlags(ctx context.Context, name string, args []string, register func(*flag.FlagSet)) (tenants.Target, error) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	bucket := fs.String("bucket", "", "DR bucket")
+	region := fs.String("region", "", "region of the DR bucket")
+	if register != nil {
+		register(fs)
+	}
+	if err := fs.Parse(args); err != nil {
+		return tenants.Target{}, err
+	}
+	if *bucket == "" || *region == "" {
+		return tenants.Target{}, errors.New("-bucket and -region are required")
+	}
+	client, err := newS3Client(ctx, *region)
+	if err != nil {
+		return tenants.Target{}, err
+	}
+	return tenants.Target{Client: client, Bucket: *bucket, Region: *region}, nil
+}
+
+// objectSize HEADs key and returns its size, or false if it doesn't exist.
+func objectSize(ctx context.Context, target tenants.Target, key string) (int64, bool, error) {
+	out, err := target.Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(target.Bucket),
+		Key:    aws.String(key),
+	})
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return aws.ToInt64(out.ContentLength), true, nil
+}
+
+// copyIfChanged copies src from srcBucket to dst in target unless dst
+// already has an object of the same size. It reports whether it copied.
+func copyIfChanged(ctx context.Context, target tenants.Target, dst, srcBucket, src string, size int64) (bool, error) {
+	existing, ok, err := objectSize(ctx, target, dst)
+	if err != nil {
+		return false, err
+	}
+	if ok && existing == size {
+		return false, nil
+	}
+	input := &s3.CopyObjectInput{
+		Bucket:     aws.String(target.Bucket),
+		Key:        aws.String(dst),
+		CopySource: aws.String(s3CopySource(srcBucket, src)),
+	}
+	withCopyRetention(target.Retention)(input)
+	withCopyEncryption(target.Encryption)(input)
+	if _, err := target.Client.CopyObject(ctx, input); err != nil {
+		return false, fmt.Errorf("couldn't copy %s/%s: %w", srcBucket, src, err)
+	}
+	return true, nil
+}
+
+// runDRExportCommand copies the service's state to a DR bucket:
+//
+//	tubely dr-export -bucket NAME -region REGION
+//
+// It can run alongside the server. The database snapshot is encrypted
+// with DB_BACKUP_KEY if it is set. The manifest is written last, so an
+// export that fails part way is never picked up by an import.
+func (cfg *apiConfig) runDRExportCommand(ctx context.Context, args []string) error {
+	if !cfg.tenants.Defaults().IsS3() {
+		return errStorageNotS3
+	}
+	dr, err := parseDRFlags(ctx, "dr-export", args, nil)
+	if err != nil {
+		return err
+	}
+
+	now := cfg.clock.Now().UTC()
+	manifest := drManifest{
+		ExportedAt: now.Truncate(time.Second),
+		Encrypted:  cfg.backupKey != nil,
+		AssetsURL:  fmt.Sprintf("http://localhost:%s/assets/", cfg.port),
+		Buckets:    []drBucket{},
+		Assets:     []drObject{},
+	}
+	copied := 0
+	for _, tenantID := range append([]string{""}, cfg.tenants.IDs()...) {
+		target, err := cfg.tenants.Target(ctx, tenantID)
+		if err != nil {
+			return err
+		}
+		label := tenantID
+		if label == "" {
+			label = drDefaultLabel
+		}
+		bucket := drBucket{
+			Tenant:    tenantID,
+			Bucket:    target.Bucket,
+			Region:    target.Region,
+			ObjectURL: target.ObjectURL(""),
+			Objects:   []drObject{},
+		}
+		paginator := s3.NewListObjectsV2Paginator(target.Client, &s3.ListObjectsV2Input{
+			Bucket: aws.String(target.Bucket),
+		})
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				return fmt.Errorf("couldn't list %s: %w", target.Bucket, err)
+			}
+			for _, obj := range page.Contents {
+				key := aws.ToString(obj.Key)
+				// Backups are superseded by the export's own snapshot.
+				if strings.HasPrefix(key, backupPrefix) {
+					continue
+				}
+				o := drObject{Key: key, Size: aws.ToInt64(obj.Size), DRKey: drObjectsPrefix + label + "/" + key}
+				ok, err := copyIfChanged(ctx, dr, o.DRKey, target.Bucket, key, o.Size)
+				if err != nil {
+					return err
+				}
+				if ok {
+					copied++
+				}
+				bucket.Objects = append(bucket.Objects, o)
+			}
+		}
+		manifest.Buckets = append(manifest.Buckets, bucket)
+	}
+
+	entries, err := os.ReadDir(cfg.assetsRoot)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		o := drObject{Key: entry.Name(), Size: info.Size(), DRKey: drAssetsPrefix + entry.Name()}
+		existing, ok, err := objectSize(ctx, dr, o.DRKey)
+		if err != nil {
+			return err
+		}
+		if !ok || existing != o.Size {
+			contentType := mime.TypeByExtension(filepath.Ext(o.Key))
+			if contentType == "" {
+				contentType = "application/octet-stream"
+			}
+			if err := uploadFile(ctx, dr, o.DRKey, filepath.Join(cfg.assetsRoot, o.Key), contentType); err != nil {
+				return fmt.Errorf("couldn't upload asset %s: %w", o.Key, err)
+			}
+			copied++
+		}
+		manifest.Assets = append(manifest.Assets, o)
+	}
+
+	dir, err := os.MkdirTemp("", "tubely-dr-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	snapshot := filepath.Join(dir, "tubely.db")
+	if err := cfg.db.Backup(snapshot); err != nil {
+		return fmt.Errorf("couldn't snapshot database: %w", err)
+	}
+	dat, err := os.ReadFile(snapshot)
+	if err != nil {
+		return err
+	}
+	exportPrefix := drExportsPrefix + now.Format(backupTimeFormat) + "/"
+	manifest.Database = exportPrefix + "tubely.db"
+	if manifest.Encrypted {
+		dat, err = encryptBackup(cfg.backupKey, dat)
+		if err != nil {
+			return err
+		}
+		manifest.Database += ".enc"
+	}
+	if err := putObject(ctx, dr, manifest.Database, bytes.NewReader(dat), "application/octet-stream"); err != nil {
+		return fmt.Errorf("couldn't upload database: %w", err)
+	}
+
+	dat, err = json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := putObject(ctx, dr, exportPrefix+drManifestName, bytes.NewReader(dat), "application/json"); err != nil {
+		return fmt.Errorf("couldn't upload manifest: %w", err)
+	}
+	log.Printf("Exported %s to s3://%s/%s, copying %d changed objects and assets", manifest.ExportedAt.Format(backupTimeFormat), dr.Bucket, exportPrefix, copied)
+	return nil
+}
+
+// latestDRExport returns the prefix of the newest complete export.
+func latestDRExport(ctx context.Context, dr tenants.Target) (string, error) {
+	latest := ""
+	paginator := s3.NewListObjectsV2Paginator(dr.Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(dr.Bucket),
+		Prefix: aws.String(drExportsPrefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return "", err
+		}
+		for _, obj := range page.Contents {
+			key := aws.ToString(obj.Key)
+			if path.Base(key) == drManifestName && key > latest {
+				latest = key
+			}
+		}
+	}
+	if latest == "" {
+		return "", errors.New("no exports found")
+	}
+	return path.Dir(latest) + "/", nil
+}
+
+func getObjectBytes(ctx context.Context, target tenants.Target, key string) ([]byte, error) {
+	obj, err := target.Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(target.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't download %s: %w", key, err)
+	}
+	defer obj.Body.Close()
+	return io.ReadAll(obj.Body)
+}
+
+// runDRImportCommand rebuilds the service from a DR bucket, e.g. in a new
+// region:
+//
+//	tubely dr-import -bucket NAME -region REGION [-export 20060102T150405Z]
+//
+// The server must be stopped first. The newest export is used unless
+// -export picks another. Its objects are copied into the buckets this
+// deployment is configured with, tenant by tenant, and URLs in the
+// database are rewritten to point at them. Every tenant in the export has
+// to be configured here. Once the objects are in place, the dead link sweep
+// checks the imported database so its report reflects the new buckets.
+// The current database is kept next to the imported one.
+func (cfg *apiConfig) runDRImportCommand(ctx context.Context, dbPath string, args []string) error {
+	if !cfg.tenants.Defaults().IsS3() {
+		return errStorageNotS3
+	}
+	var export string
+	dr, err := parseDRFlags(ctx, "dr-import", args, func(fs *flag.FlagSet) {
+		fs.StringVar(&export, "export", "", "export to import; defaults to the newest")
+	})
+	if err != nil {
+		return err
+	}
+
+	exportPrefix := drExportsPrefix + export + "/"
+	if export == "" {
+		exportPrefix, err = latestDRExport(ctx, dr)
+		if err != nil {
+			return err
+		}
+	}
+	dat, err := getObjectBytes(ctx, dr, exportPrefix+drManifestName)
+	if err != nil {
+		return err
+	}
+	var manifest drManifest
+	if err := json.Unmarshal(dat, &manifest); err != nil {
+		return fmt.Errorf("couldn't parse manifest: %w", err)
+	}
+	for _, bucket := range manifest.Buckets {
+		if bucket.Tenant != "" && !cfg.tenants.Exists(bucket.Tenant) {
+			return fmt.Errorf("tenant %q in the export isn't configured", bucket.Tenant)
+		}
+	}
+
+	dat, err = getObjectBytes(ctx, dr, manifest.Database)
+	if err != nil {
+		return err
+	}
+	if manifest.Encrypted {
+		if cfg.backupKey == nil {
+			return errors.New("the export is encrypted; DB_BACKUP_KEY must be set")
+		}
+		dat, err = decryptBackup(cfg.backupKey, dat)
+		if err != nil {
+			return err
+		}
+	}
+	importPath := dbPath + ".importing"
+	if err := os.WriteFile(importPath, dat, 0o600); err != nil {
+		return err
+	}
+	keep := false
+	defer func() {
+		if !keep {
+			os.Remove(importPath)
+		}
+	}()
+	imported, err := database.NewClient(importPath)
+	if err != nil {
+		return fmt.Errorf("export's database isn't usable: %w", err)
+	}
+	defer imported.Close()
+
+	copied := 0
+	for _, bucket := range manifest.Buckets {
+		target, err := cfg.tenants.Target(ctx, bucket.Tenant)
+		if err != nil {
+			return err
+		}
+		for _, o := range bucket.Objects {
+			ok, err := copyIfChanged(ctx, target, o.Key, dr.Bucket, o.DRKey, o.Size)
+			if err != nil {
+				return err
+			}
+			if ok {
+				copied++
+			}
+		}
+		if objectURL := target.ObjectURL(""); objectURL != bucket.ObjectURL {
+			n, err := imported.ReplaceURLPrefix(bucket.ObjectURL, objectURL)
+			if err != nil {
+				return fmt.Errorf("couldn't rewrite URLs for %s: %w", bucket.Bucket, err)
+			}
+			log.Printf("Rewrote %d URLs from %s to %s", n, bucket.ObjectURL, objectURL)
+		}
+	}
+
+	if err := os.MkdirAll(cfg.assetsRoot, 0755); err != nil {
+		return err
+	}
+	for _, o := range manifest.Assets {
+		dat, err := getObjectBytes(ctx, dr, o.DRKey)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(cfg.assetsRoot, filepath.Base(o.Key)), dat, 0644); err != nil {
+			return err
+		}
+	}
+	if assetsURL := fmt.Sprintf("http://localhost:%s/assets/", cfg.port); assetsURL != manifest.AssetsURL {
+		if _, err := imported.ReplaceURLPrefix(manifest.AssetsURL, assetsURL); err != nil {
+			return fmt.Errorf("couldn't rewrite asset URLs: %w", err)
+		}
+	}
+
+	check := *cfg
+	check.db = imported
+	result, err := check.sweepDeadLinks(ctx)
+	if err != nil {
+		return fmt.Errorf("couldn't check imported database against storage: %w", err)
+	}
+	if err := imported.Close(); err != nil {
+		return err
+	}
+
+	previous, err := cfg.swapDatabase(dbPath, importPath, "dr-import")
+	if err != nil {
+		return err
+	}
+	keep = true
+	log.Printf("Imported %s: copied %d objects and %d assets, %d of %d links broken; the previous database is at %s",
+		exportPrefix, copied, len(manifest.Assets), result.Broken, result.Checked, previous)
+	return nil
+}
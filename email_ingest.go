@@ -0,0 +1,824 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/sqs"
+	"github.com/google/uuid"
+)
+
+// Email-in lets users upload by emailing a video, or a link to one, to an
+// address of their own at EMAIL_INGEST_DOMAIN. SES receives the mail: a
+// receipt rule's S3 action stores each message in EMAIL_INGEST_BUCKET and
+// notifies an SNS topic, and the server consumes the notifications from an
+// SQS queue subscribed to the topic, EMAIL_INGEST_QUEUE_URL. Only mail
+// from the user, or a sender they allow, that passes SES's SPF or DKIM
+// checks and isn't flagged as spam or a virus is taken.
+
+// emailIngestConfig is set when email-in is enabled.
+type emailIngestConfig struct {
+	domain string
+	bucket string
+	// maxBytes is the largest message taken, attachments included.
+	maxBytes int64
+	queue    *sqs.Client
+	// client reads and deletes stored messages; it's the default target's.
+	client *s3.Client
+	// links downloads linked videos. It won't connect to loopback,
+	// private or link-local addresses.
+	links *http.Client
+}
+
+const (
+	// defaultEmailMaxBytes is SES's limit on messages stored in S3.
+	defaultEmailMaxBytes = 40 << 20
+	// maxEmailVideos is how many videos one message can upload, attached
+	// and linked.
+	maxEmailVideos = 5
+	// maxEmailLinks is how many links of a message are tried, in order.
+	maxEmailLinks = 10
+	// maxEmailText is how much of each text part is searched for links.
+	maxEmailText = 1 << 20
+	// maxEmailDepth is how deeply multipart parts are searched.
+	maxEmailDepth   = 5
+	maxEmailSenders = 10
+	// emailLinkTimeout is how long a linked video has to download.
+	emailLinkTimeout = 10 * time.Minute
+	// The queue is long-polled for a few notifications at a time. A
+	// notification being handled is hidden from other receivers for
+	// emailIngestVisibility, so it's delivered again only if the server
+	// dies with it.
+	emailIngestPollWait   = 20 * time.Second
+	emailIngestBatch      = 5
+	emailIngestVisibility = 30 * time.Minute
+	emailIngestRetryDelay = 30 * time.Second
+	// emailIngestHistory is how many messages of a user are listed.
+	emailIngestHistory = 50
+)
+
+// emailTokenEncoding spells email-in address tokens, which are
+// case-insensitive like the rest of a local part.
+var emailTokenEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// emailLinkPattern finds links in text and HTML parts.
+var emailLinkPattern = regexp.MustCompile(`https://[^\s<>"'()\[\]]+`)
+
+func newEmailLinkClient(allowPrivateHosts bool) *http.Client {
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	if !allowPrivateHosts {
+		dialer.Control = publicAddressControl
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Transport: transport, Timeout: emailLinkTimeout}
+}
+
+// sesNotification is the part of an SES "Received" notification, from a
+// receipt rule's S3 action, that email-in needs.
+type sesNotification struct {
+	NotificationType string `json:"notificationType"`
+	Mail             struct {
+		MessageID     string `json:"messageId"`
+		CommonHeaders struct {
+			From    []string `json:"from"`
+			Subject string   `json:"subject"`
+		} `json:"commonHeaders"`
+	} `json:"mail"`
+	Receipt struct {
+		Recipients   []string   `json:"recipients"`
+		SPFVerdict   sesVerdict `json:"spfVerdict"`
+		DKIMVerdict  sesVerdict `json:"dkimVerdict"`
+		DMARCVerdict sesVerdict `json:"dmarcVerdict"`
+		SpamVerdict  sesVerdict `json:"spamVerdict"`
+		VirusVerdict sesVerdict `json:"virusVerdict"`
+		Action       struct {
+			Type       string `json:"type"`
+			BucketName string `json:"bucketName"`
+			ObjectKey  string `json:"objectKey"`
+		} `json:"action"`
+	} `json:"receipt"`
+}
+
+type sesVerdict struct {
+	Status string `json:"status"`
+}
+
+// parseSESNotification decodes an SQS message body, which is the SES
+// notification wrapped in an SNS one unless the subscription delivers raw
+// messages.
+func parseSESNotification(body string) (sesNotification, error) {
+	var envelope struct {
+		Type    string `json:"Type"`
+		Message string `json:"Message"`
+	}
+	if err := json.Unmarshal([]byte(body), &envelope); err != nil {
+		return sesNotification{}, err
+	}
+	if envelope.Type == "Notification" {
+		body = envelope.Message
+	}
+	n := sesNotification{}
+	err := json.Unmarshal([]byte(body), &n)
+	return n, err
+}
+
+// runEmailIngest consumes email-in notifications until ctx is done. They
+// wait in the queue while maintenance mode is enabled.
+func (cfg *apiConfig) runEmailIngest(ctx context.Context) {
+	wait := func() {
+		select {
+		case <-ctx.Done():
+		case <-time.After(emailIngestRetryDelay):
+		}
+	}
+	for ctx.Err() == nil {
+		if enabled, _, _ := cfg.maintenance.get(); enabled {
+			wait()
+			continue
+		}
+		messages, err := cfg.emailIngest.queue.Receive(ctx, emailIngestBatch, emailIngestPollWait, emailIngestVisibility)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("Couldn't receive email-in notifications: %v", err)
+			wait()
+			continue
+		}
+		for _, m := range messages {
+			if err := cfg.handleEmailNotification(ctx, m.Body); err != nil {
+				// It's delivered again once its visibility runs out.
+				log.Printf("Couldn't handle email-in notification %s, will retry: %v", m.MessageID, err)
+				continue
+			}
+			if err := cfg.emailIngest.queue.Delete(ctx, m.ReceiptHandle); err != nil {
+				log.Printf("Couldn't delete email-in notification %s: %v", m.MessageID, err)
+			}
+		}
+	}
+}
+
+// handleEmailNotification turns the videos attached to or linked from a
+// received message into uploads of the user it was sent to, and queues
+// them for processing. It returns an error only if trying again might
+// work; messages that can't become uploads are recorded as rejected and
+// deleted.
+func (cfg *apiConfig) handleEmailNotification(ctx context.Context, body string) error {
+	n, err := parseSESNotification(body)
+	if err != nil {
+		log.Printf("Dropping email-in notification that isn't from SES: %v", err)
+		return nil
+	}
+	if n.NotificationType != "Received" {
+		// SES's setup notification and the like.
+		return nil
+	}
+	action := n.Receipt.Action
+	if action.Type != "S3" || action.BucketName != cfg.emailIngest.bucket || action.ObjectKey == "" {
+		log.Printf("Dropping email-in message %s that isn't stored in the email-in bucket", n.Mail.MessageID)
+		return nil
+	}
+
+	address, err := cfg.emailRecipient(n.Receipt.Recipients)
+	if err != nil {
+		return err
+	}
+	if address == nil {
+		log.Printf("Dropping email-in message %s to unknown address %v", n.Mail.MessageID, n.Receipt.Recipients)
+		cfg.deleteStoredEmail(ctx, action.ObjectKey)
+		return nil
+	}
+	if done, err := cfg.db.EmailIngestExists(n.Mail.MessageID); err != nil {
+		return err
+	} else if done {
+		return nil
+	}
+	user, err := cfg.db.GetUser(address.UserID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		cfg.deleteStoredEmail(ctx, action.ObjectKey)
+		return nil
+	}
+
+	ingest := database.EmailIngest{
+		MessageID: n.Mail.MessageID,
+		UserID:    user.ID,
+		Subject:   ingestTitle(n.Mail.CommonHeaders.Subject, ""),
+		Status:    database.EmailIngestRejected,
+		CreatedAt: cfg.clock.Now().UTC(),
+	}
+	if len(n.Mail.CommonHeaders.From) > 0 {
+		if from, err := mail.ParseAddress(n.Mail.CommonHeaders.From[0]); err == nil {
+			ingest.Sender = strings.ToLower(from.Address)
+		}
+	}
+	if reason := emailRejection(n, ingest.Sender, user.Email, address.Senders); reason != "" {
+		ingest.Error = reason
+		return cfg.finishEmailIngest(ctx, ingest, action.ObjectKey)
+	}
+	if user.Suspended() {
+		ingest.Error = accountSuspendedMessage
+		return cfg.finishEmailIngest(ctx, ingest, action.ObjectKey)
+	}
+
+	head, err := cfg.emailIngest.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(action.BucketName),
+		Key:    aws.String(action.ObjectKey),
+	})
+	if err != nil {
+		return err
+	}
+	if aws.ToInt64(head.ContentLength) > cfg.emailIngest.maxBytes {
+		ingest.Error = fmt.Sprintf("Message is larger than %d MB", cfg.emailIngest.maxBytes>>20)
+		return cfg.finishEmailIngest(ctx, ingest, action.ObjectKey)
+	}
+
+	files, err := cfg.spoolEmailVideos(ctx, action.BucketName, action.ObjectKey)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		ingest.Error = "Message has no video attachments or links to videos"
+		return cfg.finishEmailIngest(ctx, ingest, action.ObjectKey)
+	}
+
+	r, err := http.NewRequestWithContext(context.Background(), http.MethodPost, "/", nil)
+	if err != nil {
+		return err
+	}
+	for i, file := range files {
+		title := ingestTitle(strings.TrimSuffix(file.filename, path.Ext(file.filename)), "Email upload")
+		if len(files) == 1 {
+			title = ingestTitle(n.Mail.CommonHeaders.Subject, title)
+		}
+		videoID, msg := cfg.queueEmailVideo(r, user, title, file)
+		if msg != "" {
+			// The rest of the files would most likely fail the same way.
+			ingest.Error = msg
+			for _, file := range files[i+1:] {
+				os.Remove(file.path)
+			}
+			break
+		}
+		ingest.VideoIDs = append(ingest.VideoIDs, videoID)
+	}
+	if len(ingest.VideoIDs) > 0 {
+		ingest.Status = database.EmailIngestAccepted
+	}
+	return cfg.finishEmailIngest(ctx, ingest, action.ObjectKey)
+}
+
+// emailRecipient returns the first email-in address among the recipients,
+// or nil if there are none.
+func (cfg *apiConfig) emailRecipient(recipients []string) (*database.EmailAddress, error) {
+	for _, rcpt := range recipients {
+		i := strings.LastIndex(rcpt, "@")
+		if i < 0 || !strings.EqualFold(rcpt[i+1:], cfg.emailIngest.domain) {
+			continue
+		}
+		address, err := cfg.db.GetEmailAddressByToken(strings.ToLower(rcpt[:i]))
+		if err != nil || address != nil {
+			return address, err
+		}
+	}
+	return nil, nil
+}
+
+// emailRejection returns why a message from sender isn't taken, or "" if
+// it is. SES has to have verified the sender's domain, and the sender has
+// to be the user or one they allow.
+func emailRejection(n sesNotification, sender, userEmail string, senders []string) string {
+	r := n.Receipt
+	if r.VirusVerdict.Status == "FAIL" || r.SpamVerdict.Status == "FAIL" {
+		return "Message was flagged as spam or a virus"
+	}
+	if r.DMARCVerdict.Status == "FAIL" || (r.SPFVerdict.Status != "PASS" && r.DKIMVerdict.Status != "PASS") {
+		return "Sender couldn't be verified"
+	}
+	if sender == "" || !emailSenderAllowed(sender, userEmail, senders) {
+		return "Sender isn't allowed to upload to this address"
+	}
+	return ""
+}
+
+// emailSenderAllowed reports whether sender is the user or one of the
+// senders they allow, which are addresses or, starting with @, domains.
+func emailSenderAllowed(sender, userEmail string, senders []string) bool {
+	if strings.EqualFold(sender, userEmail) {
+		return true
+	}
+	_, domain, _ := strings.Cut(sender, "@")
+	for _, s := range senders {
+		if s == sender || (strings.HasPrefix(s, "@") && s[1:] == domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// finishEmailIngest records what became of the message and deletes it
+// from the bucket.
+func (cfg *apiConfig) finishEmailIngest(ctx context.Context, ingest database.EmailIngest, key string) error {
+	if ingest.Status == database.EmailIngestRejected {
+		log.Printf("Rejected email-in message %s from %q for user %s: %s", ingest.MessageID, ingest.Sender, ingest.UserID, ingest.Error)
+	}
+	if _, err := cfg.db.CreateEmailIngest(ingest); err != nil {
+		if len(ingest.VideoIDs) > 0 {
+			// The videos are queued already; handling it again would
+			// upload them twice.
+			log.Printf("Couldn't record email-in message %s: %v", ingest.MessageID, err)
+		} else {
+			return err
+		}
+	}
+	cfg.deleteStoredEmail(ctx, key)
+	return nil
+}
+
+func (cfg *apiConfig) deleteStoredEmail(ctx context.Context, key string) {
+	_, err := cfg.emailIngest.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(cfg.emailIngest.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		log.Printf("Couldn't delete email-in message s3://%s/%s: %v", cfg.emailIngest.bucket, key, err)
+	}
+}
+
+// emailVideo is a video from a message, spooled for processing.
+type emailVideo struct {
+	path        string
+	filename    string
+	contentType string
+	size        int64
+	// origin is "attachment", or the host of the link.
+	origin string
+}
+
+// spoolEmailVideos copies the videos attached to the stored message, then
+// the ones it links to, into the upload spool, up to maxEmailVideos.
+// Links that don't lead to a video are skipped.
+func (cfg *apiConfig) spoolEmailVideos(ctx context.Context, bucket, key string) (files []emailVideo, err error) {
+	defer func() {
+		if err != nil {
+			for _, file := range files {
+				os.Remove(file.path)
+			}
+		}
+	}()
+
+	obj, err := cfg.emailIngest.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Body.Close()
+	msg, err := mail.ReadMessage(io.LimitReader(obj.Body, cfg.emailIngest.maxBytes))
+	if err != nil {
+		return nil, err
+	}
+
+	var links []string
+	seen := map[string]bool{}
+	err = walkEmailParts(textproto.MIMEHeader(msg.Header), msg.Body, 0, func(mediaType, filename string, body io.Reader) error {
+		if contentType, ok := cfg.emailVideoType(mediaType, filename); ok {
+			if len(files) >= maxEmailVideos {
+				return nil
+			}
+			file, err := cfg.spoolEmailVideo(body, filename, contentType, "attachment")
+			if err != nil {
+				return err
+			}
+			files = append(files, file)
+			return nil
+		}
+		if mediaType != "text/plain" && mediaType != "text/html" {
+			return nil
+		}
+		text, err := io.ReadAll(io.LimitReader(body, maxEmailText))
+		if err != nil {
+			return err
+		}
+		for _, link := range emailLinkPattern.FindAllString(string(text), -1) {
+			link = strings.TrimRight(link, ".,;:!?")
+			if mediaType == "text/html" {
+				link = html.UnescapeString(link)
+			}
+			if !seen[link] && len(links) < maxEmailLinks {
+				seen[link] = true
+				links = append(links, link)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return files, err
+	}
+
+	for _, link := range links {
+		if len(files) >= maxEmailVideos {
+			break
+		}
+		file, err := cfg.downloadEmailLink(ctx, link)
+		if errors.Is(err, errNotAVideo) {
+			continue
+		}
+		if err != nil {
+			log.Printf("Couldn't download email-in link %s: %v", link, err)
+			continue
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// walkEmailParts calls fn with each leaf part of a message, decoded,
+// searching multipart parts up to maxEmailDepth deep.
+func walkEmailParts(header textproto.MIMEHeader, body io.Reader, depth int, fn func(mediaType, filename string, body io.Reader) error) error {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", nil
+	}
+	if strings.HasPrefix(mediaType, "multipart/") {
+		if depth >= maxEmailDepth {
+			return nil
+		}
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := walkEmailParts(part.Header, part, depth+1, fn); err != nil {
+				return err
+			}
+		}
+	}
+
+	switch strings.ToLower(strings.TrimSpace(header.Get("Content-Transfer-Encoding"))) {
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	}
+	filename := params["name"]
+	if _, dispParams, err := mime.ParseMediaType(header.Get("Content-Disposition")); err == nil && dispParams["filename"] != "" {
+		filename = dispParams["filename"]
+	}
+	if decoded, err := new(mime.WordDecoder).DecodeHeader(filename); err == nil {
+		filename = decoded
+	}
+	return fn(mediaType, path.Base(filename), body)
+}
+
+// emailVideoType returns the content type of a part that's a video in an
+// allowed container, going by its file name when its type is generic.
+func (cfg *apiConfig) emailVideoType(mediaType, filename string) (string, bool) {
+	if mediaType == "application/octet-stream" {
+		mediaType = videoContentType(filename)
+	}
+	_, ok := cfg.videoContainers[mediaType]
+	return mediaType, ok
+}
+
+func (cfg *apiConfig) spoolEmailVideo(body io.Reader, filename, contentType, origin string) (emailVideo, error) {
+	if err := os.MkdirAll(cfg.uploadSpoolDir, 0700); err != nil {
+		return emailVideo{}, err
+	}
+	raw, err := os.CreateTemp(cfg.uploadSpoolDir, "email-"+rawUploadPattern)
+	if err != nil {
+		return emailVideo{}, err
+	}
+	n, err := io.Copy(raw, io.LimitReader(body, maxUploadSize+1))
+	if closeErr := raw.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && n > maxUploadSize {
+		err = fmt.Errorf("video is larger than %d bytes", maxUploadSize)
+	}
+	if err != nil {
+		os.Remove(raw.Name())
+		return emailVideo{}, err
+	}
+	if filename == "" || filename == "." || filename == "/" {
+		filename = "video" + cfg.videoContainers[contentType].tempExt()
+	}
+	return emailVideo{path: raw.Name(), filename: filename, contentType: contentType, size: n, origin: origin}, nil
+}
+
+var errNotAVideo = errors.New("link isn't to a video")
+
+// downloadEmailLink spools the video at link. It returns errNotAVideo if
+// link leads to something else.
+func (cfg *apiConfig) downloadEmailLink(ctx context.Context, link string) (emailVideo, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return emailVideo{}, errNotAVideo
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return emailVideo{}, errNotAVideo
+	}
+	resp, err := cfg.emailIngest.links.Do(req)
+	if err != nil {
+		return emailVideo{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return emailVideo{}, errNotAVideo
+	}
+	filename := path.Base(resp.Request.URL.Path)
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil && params["filename"] != "" {
+		filename = path.Base(params["filename"])
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	contentType, ok := cfg.emailVideoType(mediaType, filename)
+	if !ok {
+		return emailVideo{}, errNotAVideo
+	}
+	if resp.ContentLength > maxUploadSize {
+		return emailVideo{}, fmt.Errorf("video is larger than %d bytes", maxUploadSize)
+	}
+	return cfg.spoolEmailVideo(resp.Body, filename, contentType, u.Host)
+}
+
+// queueEmailVideo creates a video of the user from a spooled file and
+// queues it for processing. It returns the error message if it couldn't,
+// having removed the file.
+func (cfg *apiConfig) queueEmailVideo(r *http.Request, user *database.User, title string, file emailVideo) (uuid.UUID, string) {
+	rec := &errorRecorder{ResponseWriter: &discardResponseWriter{}}
+	fail := func() (uuid.UUID, string) {
+		os.Remove(file.path)
+		return uuid.Nil, rec.message()
+	}
+
+	target, err := cfg.tenants.Target(r.Context(), user.TenantID)
+	if err != nil {
+		respondWithError(rec, http.StatusInternalServerError, "Couldn't resolve storage for tenant", err)
+		return fail()
+	}
+	profileName, ok := cfg.uploadProfile(rec, user.ID, "", "")
+	if !ok {
+		return fail()
+	}
+	src := videoSource{
+		filename:    file.filename,
+		contentType: file.contentType,
+		profileName: profileName,
+	}
+	if _, _, ok := cfg.checkVideoSource(rec, src); !ok {
+		return fail()
+	}
+	if !cfg.requireStorageQuota(rec, user.ID, uuid.Nil, database.StorageKindVideo, file.size) {
+		return fail()
+	}
+
+	params := database.CreateVideoParams{
+		Title:  title,
+		Tags:   []string{},
+		UserID: user.ID,
+	}
+	if err := normalizeVideoParams(&params); err != nil {
+		respondWithError(rec, http.StatusBadRequest, err.Error(), err)
+		return fail()
+	}
+	video, err := cfg.db.CreateVideo(params)
+	if err != nil {
+		respondWithError(rec, http.StatusInternalServerError, "Couldn't create video", err)
+		return fail()
+	}
+	if _, err := cfg.db.QueueVideoProcessing(video.ID); err != nil {
+		respondWithError(rec, http.StatusInternalServerError, "Failed to update video metadata", err)
+		if err := cfg.db.DeleteVideo(video.ID); err != nil {
+			log.Printf("Couldn't delete video %s of email-in: %v", video.ID, err)
+		}
+		return fail()
+	}
+	cfg.recordActivity(user.ID, activityVideoCreated, &video.ID, nil, video.Title)
+	cfg.emitEvent(eventVideoUploaded, video.ID, map[string]any{"source": "email", "origin": file.origin, "size": file.size})
+
+	job := uploadJob{
+		videoID: video.ID,
+		target:  target,
+		src:     src,
+		rawPath: file.path,
+		plog:    newProcessingLog(video.ID, "email"),
+	}
+	cfg.background.run(func() { cfg.runUploadJob(r, job) })
+	return video.ID, ""
+}
+
+// emailAddressResponse is a user's email-in address.
+type emailAddressResponse struct {
+	Address   string    `json:"address"`
+	Senders   []string  `json:"senders"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (cfg *apiConfig) emailAddressResponse(a database.EmailAddress) emailAddressResponse {
+	return emailAddressResponse{
+		Address:   a.Token + "@" + cfg.emailIngest.domain,
+		Senders:   a.Senders,
+		CreatedAt: a.CreatedAt,
+	}
+}
+
+func newEmailToken() (string, error) {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return emailTokenEncoding.EncodeToString(b), nil
+}
+
+// requireEmailIngest writes 404 and returns false if email-in isn't
+// configured.
+func (cfg *apiConfig) requireEmailIngest(w http.ResponseWriter) bool {
+	if cfg.emailIngest == nil {
+		respondWithError(w, http.StatusNotFound, "Email-in is not configured", nil)
+		return false
+	}
+	return true
+}
+
+func (cfg *apiConfig) handlerEmailAddressGet(w http.ResponseWriter, r *http.Request) {
+	if !cfg.requireEmailIngest(w) {
+		return
+	}
+	userID, ok := cfg.requireUser(w, r)
+	if !ok {
+		return
+	}
+	address, err := cfg.db.GetEmailAddress(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get email-in address", err)
+		return
+	}
+	if address == nil {
+		respondWithError(w, http.StatusNotFound, "Email-in address not found", nil)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, cfg.emailAddressResponse(*address))
+}
+
+// handlerEmailAddressUpdate sets who besides the user can email them
+// videos, creating their address if they don't have one.
+func (cfg *apiConfig) handlerEmailAddressUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Senders []string `json:"senders"`
+	}
+
+	if !cfg.requireEmailIngest(w) {
+		return
+	}
+	userID, ok := cfg.requireUser(w, r)
+	if !ok {
+		return
+	}
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	senders := []string{}
+	seen := map[string]bool{}
+	for _, s := range params.Senders {
+		s = strings.ToLower(strings.TrimSpace(s))
+		if domain, ok := strings.CutPrefix(s, "@"); ok {
+			if domain == "" || strings.ContainsAny(domain, "@ ") {
+				respondWithError(w, http.StatusBadRequest, "Invalid sender", fmt.Errorf("sender %q", s))
+				return
+			}
+		} else if a, err := mail.ParseAddress(s); err != nil || a.Address != s {
+			respondWithError(w, http.StatusBadRequest, "Invalid sender", fmt.Errorf("sender %q", s))
+			return
+		}
+		if !seen[s] {
+			seen[s] = true
+			senders = append(senders, s)
+		}
+	}
+	if len(senders) > maxEmailSenders {
+		respondWithError(w, http.StatusBadRequest, "Too many senders", fmt.Errorf("%d senders, at most %d are allowed", len(senders), maxEmailSenders))
+		return
+	}
+
+	address, err := cfg.db.GetEmailAddress(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get email-in address", err)
+		return
+	}
+	if address == nil {
+		token, err := newEmailToken()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update email-in address", err)
+			return
+		}
+		address = &database.EmailAddress{UserID: userID, Token: token, CreatedAt: cfg.clock.Now().UTC()}
+	}
+	address.Senders = senders
+	if err := cfg.db.SetEmailAddress(*address); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update email-in address", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, cfg.emailAddressResponse(*address))
+}
+
+// handlerEmailAddressRotate gives the user a new address in place of their
+// old one, which stops working, keeping their senders.
+func (cfg *apiConfig) handlerEmailAddressRotate(w http.ResponseWriter, r *http.Request) {
+	if !cfg.requireEmailIngest(w) {
+		return
+	}
+	userID, ok := cfg.requireUser(w, r)
+	if !ok {
+		return
+	}
+	address, err := cfg.db.GetEmailAddress(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get email-in address", err)
+		return
+	}
+	if address == nil {
+		respondWithError(w, http.StatusNotFound, "Email-in address not found", nil)
+		return
+	}
+	token, err := newEmailToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update email-in address", err)
+		return
+	}
+	address.Token = token
+	address.CreatedAt = cfg.clock.Now().UTC()
+	if err := cfg.db.SetEmailAddress(*address); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update email-in address", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, cfg.emailAddressResponse(*address))
+}
+
+func (cfg *apiConfig) handlerEmailAddressDelete(w http.ResponseWriter, r *http.Request) {
+	if !cfg.requireEmailIngest(w) {
+		return
+	}
+	userID, ok := cfg.requireUser(w, r)
+	if !ok {
+		return
+	}
+	if err := cfg.db.DeleteEmailAddress(userID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update email-in address", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerEmailIngestsGet lists the latest messages sent to the user's
+// address and what became of them.
+func (cfg *apiConfig) handlerEmailIngestsGet(w http.ResponseWriter, r *http.Request) {
+	if !cfg.requireEmailIngest(w) {
+		return
+	}
+	userID, ok := cfg.requireUser(w, r)
+	if !ok {
+		return
+	}
+	ingests, err := cfg.db.GetEmailIngests(userID, emailIngestHistory)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get email-in messages", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, ingests)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tenants"
+)
+
+// withEncryption applies the target's server-side encryption to an upload.
+// Without one, S3 encrypts the object with the bucket's default.
+func withEncryption(encryption *tenants.Encryption) func(*s3.PutObjectInput) {
+	return func(input *s3.PutObjectInput) {
+		if encryption == nil {
+			return
+		}
+		input.ServerSideEncryption = types.ServerSideEncryption(encryption.Mode)
+		if encryption.KMSKeyID != "" {
+			input.SSEKMSKeyId = aws.String(encryption.KMSKeyID)
+		}
+	}
+}
+
+// withCopyEncryption is withEncryption for copies, which otherwise get the
+// destination bucket's default rather than the source's encryption.
+func withCopyEncryption(encryption *tenants.Encryption) func(*s3.CopyObjectInput) {
+	return func(input *s3.CopyObjectInput) {
+		if encryption == nil {
+			return
+		}
+		input.ServerSideEncryption = types.ServerSideEncryption(encryption.Mode)
+		if encryption.KMSKeyID != "" {
+			input.SSEKMSKeyId = aws.String(encryption.KMSKeyID)
+		}
+	}
+}
+
+// encryptionMode is the mode recorded for objects uploaded to target: its
+// configured mode, or empty for the bucket's default.
+func encryptionMode(target tenants.Target) string {
+	if target.Encryption == nil {
+		return ""
+	}
+	return target.Encryption.Mode
+}
+
+// headObjectEncryption HEADs key and returns the mode S3 encrypted it
+// with. ok is false if the object doesn't exist. Backends other than S3
+// report no encryption.
+func headObjectEncryption(ctx context.Context, target tenants.Target, key string) (mode string, ok bool, err error) {
+	if !target.IsS3() {
+		ok, err := objectExists(ctx, target, key)
+		return "", ok, err
+	}
+	out, err := target.Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(target.Bucket),
+		Key:    aws.String(key),
+	})
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return string(out.ServerSideEncryption), true, nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// Every event emitEvent publishes is also appended to the event log, which
+// integrators can read back from any point to rebuild what they keep
+// downstream, such as a search index, or to catch up after an outage.
+// Users read the events of their own videos, and admins all of them or a
+// tenant's. The log is never pruned, and events of deleted videos stay in
+// it, video.deleted last.
+
+const (
+	defaultEventLogLimit = 100
+	maxEventLogLimit     = 1000
+)
+
+// eventLogEntry is an event as GET /api/events lists it. Its cursor is
+// where to resume reading after it.
+type eventLogEntry struct {
+	Cursor string `json:"cursor"`
+	event
+}
+
+// handlerEventsList lists the events of the user's videos, by JWT or API
+// key.
+func (cfg *apiConfig) handlerEventsList(w http.ResponseWriter, r *http.Request) {
+	userID, _, ok := cfg.requireUploader(w, r)
+	if !ok {
+		return
+	}
+	cfg.listEvents(w, r, database.EventLogParams{UserID: &userID})
+}
+
+// handlerAdminEventsList lists the events of every video.
+func (cfg *apiConfig) handlerAdminEventsList(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+	cfg.listEvents(w, r, database.EventLogParams{})
+}
+
+// handlerAdminTenantEventsList lists the events of the videos of a
+// tenant's users.
+func (cfg *apiConfig) handlerAdminTenantEventsList(w http.ResponseWriter, r *http.Request) {
+	tenantID, ok := cfg.requireTenantInPath(w, r)
+	if !ok {
+		return
+	}
+	cfg.listEvents(w, r, database.EventLogParams{TenantID: &tenantID})
+}
+
+// listEvents responds with a page of the events params selects, oldest
+// first. ?after= is the cursor of the last event read, ?limit= caps the
+// events returned and ?type= picks the event types to list, comma-separated
+// or repeated. next_cursor is where the next page starts, even if this one
+// is empty, so clients can keep polling with it.
+func (cfg *apiConfig) listEvents(w http.ResponseWriter, r *http.Request, params database.EventLogParams) {
+	q := r.URL.Query()
+	if v := q.Get("after"); v != "" {
+		after, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || after < 0 {
+			respondWithError(w, http.StatusBadRequest, "Invalid event cursor", err)
+			return
+		}
+		params.After = after
+	}
+	params.Limit = defaultEventLogLimit
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxEventLogLimit {
+			respondWithError(w, http.StatusBadRequest, "limit must be between 1 and 1000", err)
+			return
+		}
+		params.Limit = limit
+	}
+	for _, v := range q["type"] {
+		for _, t := range strings.Split(v, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				params.Types = append(params.Types, t)
+			}
+		}
+	}
+
+	// One more than the page, to tell whether there's another.
+	limit := params.Limit
+	params.Limit++
+	events, err := cfg.db.GetEvents(params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get events", err)
+		return
+	}
+	type response struct {
+		Events     []eventLogEntry `json:"events"`
+		NextCursor string          `json:"next_cursor"`
+		HasMore    bool            `json:"has_more"`
+	}
+	resp := response{
+		Events:     make([]eventLogEntry, 0, len(events)),
+		NextCursor: strconv.FormatInt(params.After, 10),
+		HasMore:    len(events) > limit,
+	}
+	if resp.HasMore {
+		events = events[:limit]
+	}
+	for _, e := range events {
+		entry := eventLogEntry{
+			Cursor: strconv.FormatInt(e.Seq, 10),
+			event: event{
+				ID:         e.ID,
+				Type:       e.Type,
+				VideoID:    e.VideoID,
+				OccurredAt: e.OccurredAt,
+			},
+		}
+		if e.Data != nil {
+			entry.Data = e.Data
+		}
+		resp.Events = append(resp.Events, entry)
+		resp.NextCursor = entry.Cursor
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
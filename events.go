@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	eventLinkBroken    = "link.broken"
+	eventVideoUpdated  = "video.updated"
+	eventVideoDeleted  = "video.deleted"
+	eventVideoTrashed  = "video.trashed"
+	eventVideoRestored = "video.restored"
+)
+
+type event struct {
+	ID         uuid.UUID `json:"id"`
+	Type       string    `json:"type"`
+	VideoID    uuid.UUID `json:"video_id"`
+	OccurredAt time.Time `json:"occurred_at"`
+	Data       any       `json:"data,omitempty"`
+}
+
+// emitEvent publishes a lifecycle event. Events are appended to the event
+// log, logged, queued for the webhooks subscribed to them and sent to the
+// notification channels whose rules match them; this is the single place
+// notification integrations hook into.
+func (cfg *apiConfig) emitEvent(eventType string, videoID uuid.UUID, data any) {
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		log.Printf("Couldn't get owner of video %s for event %s: %v", videoID, eventType, err)
+	}
+	cfg.emitOwnedEvent(eventType, videoID, video.UserID, data)
+}
+
+// emitOwnedEvent is emitEvent for a video whose owner is known, for events
+// about a video GetVideo no longer finds, once it's trashed or deleted.
+func (cfg *apiConfig) emitOwnedEvent(eventType string, videoID, ownerID uuid.UUID, data any) {
+	e := event{
+		ID:         uuid.New(),
+		Type:       eventType,
+		VideoID:    videoID,
+		OccurredAt: cfg.clock.Now().UTC(),
+		Data:       data,
+	}
+	dat, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("Error marshalling event %s: %v", eventType, err)
+		return
+	}
+	cfg.appendEvent(e, ownerID)
+	log.Printf("event: %s", dat)
+	cfg.queueWebhooks(e, dat)
+	cfg.queueNotifications(e)
+
+	if sitemapEvents[eventType] {
+		cfg.sitemap.changed(videoID)
+	}
+}
+
+// appendEvent adds the event to the event log, under ownerID and their
+// tenant, if the video had an owner. Failing to is only logged, like the
+// rest of emitting an event.
+func (cfg *apiConfig) appendEvent(e event, ownerID uuid.UUID) {
+	entry := database.Event{
+		ID:         e.ID,
+		Type:       e.Type,
+		VideoID:    e.VideoID,
+		UserID:     ownerID,
+		OccurredAt: e.OccurredAt,
+	}
+	if e.Data != nil {
+		data, err := json.Marshal(e.Data)
+		if err != nil {
+			log.Printf("Couldn't record event %s in the event log: %v", e.ID, err)
+			return
+		}
+		entry.Data = data
+	}
+	if ownerID != uuid.Nil {
+		owner, err := cfg.db.GetUser(ownerID)
+		if err != nil {
+			log.Printf("Couldn't get owner of video %s for the event log: %v", e.VideoID, err)
+		} else if owner != nil {
+			entry.TenantID = owner.TenantID
+		}
+	}
+	if err := cfg.db.AppendEvent(entry); err != nil {
+		log.Printf("Couldn't record event %s in the event log: %v", e.ID, err)
+	}
+}
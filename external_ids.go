@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"unicode"
+
+	"github.com/google/uuid"
+)
+
+// External IDs let integrations find their videos by their own IDs, such
+// as a CMS's, without keeping ours: a video created with
+// {"external_ids": {"cms": "4412"}} is at
+// GET /api/videos/by-external-id/cms/4412 for its owner.
+const (
+	maxExternalIDs     = 10
+	maxExternalIDBytes = 256
+)
+
+// externalIDSource is what the name of an external ID's system can be.
+var externalIDSource = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,31}$`)
+
+// validateExternalIDs checks the external IDs of a new video.
+func validateExternalIDs(ids map[string]string) error {
+	if len(ids) > maxExternalIDs {
+		return fmt.Errorf("a video can have at most %d external IDs, got %d", maxExternalIDs, len(ids))
+	}
+	for source, id := range ids {
+		if !externalIDSource.MatchString(source) {
+			return fmt.Errorf("external ID source %q must be 1 to 32 lowercase letters, digits, dots, hyphens or underscores", source)
+		}
+		if id == "" || len(id) > maxExternalIDBytes {
+			return fmt.Errorf("external ID from %s must be 1 to %d bytes", source, maxExternalIDBytes)
+		}
+		for _, r := range id {
+			if unicode.IsControl(r) {
+				return fmt.Errorf("external ID from %s can't have control characters", source)
+			}
+		}
+	}
+	return nil
+}
+
+// handlerVideoByExternalID responds with the user's video with an external
+// ID, like GET /api/videos/{videoID}, by JWT or API key.
+func (cfg *apiConfig) handlerVideoByExternalID(w http.ResponseWriter, r *http.Request) {
+	userID, _, ok := cfg.requireUploader(w, r)
+	if !ok {
+		return
+	}
+	videoID, err := cfg.db.GetVideoIDByExternalID(userID, r.PathValue("source"), r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if videoID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		// It's in the trash.
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	video, err = cfg.dbVideoToSignedVideo(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URLs", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, video)
+}
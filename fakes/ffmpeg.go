@@ -0,0 +1,171 @@
+package fakes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ffmpeg"
+)
+
+// FFmpeg is a scripted ffmpeg.Runner: each command is answered by the most
+// recently added rule that matches it, and a command no rule matches fails.
+// Every command is recorded, so tests can assert on what was run.
+type FFmpeg struct {
+	mu    sync.Mutex
+	rules []ffmpegRule
+	calls []Call
+}
+
+type ffmpegRule struct {
+	bin      string
+	contains []string
+	response Response
+}
+
+// Call is one command run through the fake.
+type Call struct {
+	Bin  string
+	Args []string
+}
+
+// Response is what a matched command does.
+type Response struct {
+	Stdout []byte
+	Stderr []byte
+	// Err fails the command, after its output has been written.
+	Err error
+	// Output is written to the command's output file, its last argument,
+	// or to stdout when that is pipe:1.
+	Output []byte
+	// CopyInput writes the first input's contents as the output instead,
+	// which stands in for most remuxes and transcodes.
+	CopyInput bool
+}
+
+func NewFFmpeg() *FFmpeg {
+	return &FFmpeg{}
+}
+
+// On answers commands for bin (ffmpeg.FFmpegPath or ffmpeg.FFprobePath)
+// whose arguments include all of contains.
+func (f *FFmpeg) On(bin string, response Response, contains ...string) *FFmpeg {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rules = append(f.rules, ffmpegRule{bin: bin, contains: contains, response: response})
+	return f
+}
+
+// Probe answers ffprobe's stream listing with a single video stream of the
+// given size and duration, and an AAC audio stream if hasAudio is true.
+func (f *FFmpeg) Probe(width, height int, duration float64, hasAudio bool) *FFmpeg {
+	return f.On(ffmpeg.FFprobePath, Response{Stdout: ProbeJSON(width, height, duration, hasAudio)}, "-show_streams")
+}
+
+// ProbeJSON returns ffprobe -show_streams -show_format output for an MP4
+// with one H.264 video stream and optionally an AAC audio stream.
+func ProbeJSON(width, height int, duration float64, hasAudio bool) []byte {
+	type stream struct {
+		Index     int    `json:"index"`
+		CodecType string `json:"codec_type"`
+		CodecName string `json:"codec_name"`
+		Width     int    `json:"width,omitempty"`
+		Height    int    `json:"height,omitempty"`
+		Channels  int    `json:"channels,omitempty"`
+		PixFmt    string `json:"pix_fmt,omitempty"`
+	}
+	out := struct {
+		Streams []stream `json:"streams"`
+		Format  struct {
+			FormatName string `json:"format_name"`
+			Duration   string `json:"duration"`
+		} `json:"format"`
+	}{
+		Streams: []stream{{Index: 0, CodecType: "video", CodecName: "h264", Width: width, Height: height, PixFmt: "yuv420p"}},
+	}
+	if hasAudio {
+		out.Streams = append(out.Streams, stream{Index: 1, CodecType: "audio", CodecName: "aac", Channels: 2})
+	}
+	out.Format.FormatName = "mov,mp4,m4a,3gp,3g2,mj2"
+	out.Format.Duration = strconv.FormatFloat(duration, 'f', 6, 64)
+	data, _ := json.Marshal(out)
+	return data
+}
+
+// Install makes the ffmpeg package run commands through f, and returns a
+// func that restores the previous runner.
+func (f *FFmpeg) Install() (restore func()) {
+	previous := ffmpeg.Exec
+	ffmpeg.Exec = f
+	return func() { ffmpeg.Exec = previous }
+}
+
+// Calls returns the commands run so far, oldest first.
+func (f *FFmpeg) Calls() []Call {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.calls)
+}
+
+func (f *FFmpeg) Run(ctx context.Context, bin string, args []string, stdout, stderr io.Writer) error {
+	f.mu.Lock()
+	f.calls = append(f.calls, Call{Bin: bin, Args: slices.Clone(args)})
+	response, ok := f.match(bin, args)
+	f.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("fakes: no rule for %s %s", bin, strings.Join(args, " "))
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	stdout.Write(response.Stdout)
+	stderr.Write(response.Stderr)
+	output := response.Output
+	if response.CopyInput {
+		i := slices.Index(args, "-i")
+		if i < 0 || i+1 >= len(args) {
+			return fmt.Errorf("fakes: %s has no input to copy", bin)
+		}
+		data, err := os.ReadFile(args[i+1])
+		if err != nil {
+			return err
+		}
+		output = data
+	}
+	if output != nil && len(args) > 0 {
+		if target := args[len(args)-1]; target == "pipe:1" {
+			stdout.Write(output)
+		} else if err := os.WriteFile(target, output, 0o644); err != nil {
+			return err
+		}
+	}
+	return response.Err
+}
+
+// match returns the newest rule matching the command. f.mu must be held.
+func (f *FFmpeg) match(bin string, args []string) (Response, bool) {
+	for i := len(f.rules) - 1; i >= 0; i-- {
+		rule := f.rules[i]
+		if rule.bin != bin {
+			continue
+		}
+		matched := true
+		for _, arg := range rule.contains {
+			if !slices.Contains(args, arg) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return rule.response, true
+		}
+	}
+	return Response{}, false
+}
@@ -0,0 +1,931 @@
+// Package fakes provides deterministic stand-ins for S3 and the ffmpeg
+// binaries, for the service's own tests and for code that embeds it.
+package fakes
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tenants"
+)
+
+// S3 is an in-memory S3 served over HTTP, so the real SDK client can be
+// pointed at it. It covers the operations the service uses: objects, ranged
+// reads, copies, listings, multipart uploads and their listing, object
+// tags, and Object Lock retention and legal holds. Signatures aren't
+// checked, so presigned URLs work as is.
+//
+// Its clock starts at a fixed time and advances a second on every write,
+// and upload IDs are sequential, so runs are repeatable.
+type S3 struct {
+	server *httptest.Server
+
+	mu         sync.Mutex
+	buckets    map[string]*fakeBucket
+	uploads    map[string]*fakeUpload
+	now        time.Time
+	nextUpload int
+}
+
+type fakeBucket struct {
+	objectLock bool
+	objects    map[string]*fakeObject
+}
+
+type fakeObject struct {
+	data         []byte
+	header       http.Header
+	etag         string
+	checksum     string
+	lastModified time.Time
+	lockMode     string
+	retainUntil  time.Time
+	legalHold    bool
+	tags         map[string]string
+}
+
+type fakeUpload struct {
+	bucket, key string
+	header      http.Header
+	parts       map[int]*fakeObject
+	initiated   time.Time
+}
+
+// storedHeaders are the request headers kept with an object and returned
+// when it is read.
+var storedHeaders = []string{"Content-Type", "Cache-Control", "Content-Disposition", "Content-Language", "X-Amz-Server-Side-Encryption", "X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id"}
+
+// NewS3 starts a fake with the given, empty buckets. Close it when done.
+func NewS3(buckets ...string) *S3 {
+	f := &S3{
+		buckets: map[string]*fakeBucket{},
+		uploads: map[string]*fakeUpload{},
+		now:     time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	for _, name := range buckets {
+		f.CreateBucket(name, false)
+	}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	return f
+}
+
+func (f *S3) Close() {
+	f.server.Close()
+}
+
+// URL is the endpoint clients should use, with path-style addressing.
+func (f *S3) URL() string {
+	return f.server.URL
+}
+
+// CreateBucket adds an empty bucket, with S3 Object Lock enabled if
+// objectLock is true. An existing bucket is emptied.
+func (f *S3) CreateBucket(name string, objectLock bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.buckets[name] = &fakeBucket{objectLock: objectLock, objects: map[string]*fakeObject{}}
+}
+
+// Client returns an SDK client for the fake.
+func (f *S3) Client() *s3.Client {
+	return s3.New(s3.Options{
+		Region:       "us-east-1",
+		Credentials:  credentials.NewStaticCredentialsProvider("fake", "fake", ""),
+		BaseEndpoint: aws.String(f.server.URL),
+		UsePathStyle: true,
+	})
+}
+
+// Target returns a target for bucket on the fake.
+func (f *S3) Target(bucket string) tenants.Target {
+	return tenants.Target{Client: f.Client(), Bucket: bucket, Region: "us-east-1"}
+}
+
+// Put stores an object directly, e.g. to seed a test.
+func (f *S3) Put(bucket, key string, data []byte, contentType string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	b, ok := f.buckets[bucket]
+	if !ok {
+		panic("fakes: unknown bucket " + bucket)
+	}
+	header := http.Header{}
+	header.Set("Content-Type", contentType)
+	b.objects[key] = f.newObject(data, header)
+}
+
+// Object returns a stored object's contents.
+func (f *S3) Object(bucket, key string) ([]byte, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	b, ok := f.buckets[bucket]
+	if !ok {
+		return nil, false
+	}
+	obj, ok := b.objects[key]
+	if !ok {
+		return nil, false
+	}
+	return bytes.Clone(obj.data), true
+}
+
+// Tags returns a stored object's tags, or nil if it has none.
+func (f *S3) Tags(bucket, key string) map[string]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	b, ok := f.buckets[bucket]
+	if !ok {
+		return nil
+	}
+	obj, ok := b.objects[key]
+	if !ok || len(obj.tags) == 0 {
+		return nil
+	}
+	tags := make(map[string]string, len(obj.tags))
+	for k, v := range obj.tags {
+		tags[k] = v
+	}
+	return tags
+}
+
+// LegalHold reports whether a stored object is under an Object Lock legal
+// hold.
+func (f *S3) LegalHold(bucket, key string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	b, ok := f.buckets[bucket]
+	if !ok {
+		return false
+	}
+	obj, ok := b.objects[key]
+	return ok && obj.legalHold
+}
+
+// Keys lists the keys in bucket, sorted.
+func (f *S3) Keys(bucket string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	b, ok := f.buckets[bucket]
+	if !ok {
+		return nil
+	}
+	return b.sortedKeys()
+}
+
+func (b *fakeBucket) sortedKeys() []string {
+	keys := make([]string, 0, len(b.objects))
+	for key := range b.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// tick advances the clock and returns the new time. f.mu must be held.
+func (f *S3) tick() time.Time {
+	f.now = f.now.Add(time.Second)
+	return f.now
+}
+
+func (f *S3) newObject(data []byte, header http.Header) *fakeObject {
+	sum := md5.Sum(data)
+	return &fakeObject{
+		data:         data,
+		header:       header,
+		etag:         `"` + hex.EncodeToString(sum[:]) + `"`,
+		lastModified: f.tick(),
+	}
+}
+
+type s3Error struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string   `xml:"Code"`
+	Message string   `xml:"Message"`
+	status  int
+}
+
+func (e *s3Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+func errorf(status int, code, format string, args ...any) *s3Error {
+	return &s3Error{Code: code, Message: fmt.Sprintf(format, args...), status: status}
+}
+
+func writeXML(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(v)
+}
+
+const s3Namespace = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+func s3Time(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+func (f *S3) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	query := r.URL.Query()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var err *s3Error
+	b, ok := f.buckets[bucket]
+	switch {
+	case !ok:
+		err = errorf(http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+	case key == "" && r.Method == http.MethodGet && query.Has("uploads"):
+		err = f.listMultipartUploads(w, bucket, query)
+	case key == "" && r.Method == http.MethodGet && query.Has("object-lock"):
+		err = f.getObjectLockConfiguration(w, b)
+	case key == "" && r.Method == http.MethodGet:
+		err = f.listObjects(w, bucket, b, query)
+	case key == "":
+		err = errorf(http.StatusNotImplemented, "NotImplemented", "%s on a bucket isn't supported by the fake", r.Method)
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		err = f.createMultipartUpload(w, r, bucket, key)
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		err = f.uploadPart(w, r, query)
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		err = f.completeMultipartUpload(w, r, bucket, key, b, query)
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		err = f.abortMultipartUpload(w, query)
+	case r.Method == http.MethodGet && query.Has("uploadId"):
+		err = f.listParts(w, bucket, key, query)
+	case r.Method == http.MethodPut && query.Has("legal-hold"):
+		err = f.putLegalHold(w, r, b, key)
+	case query.Has("tagging"):
+		err = f.objectTagging(w, r, b, key)
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		err = f.copyObject(w, r, b, key)
+	case r.Method == http.MethodPut:
+		err = f.putObject(w, r, b, key)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		err = f.getObject(w, r, b, key)
+	case r.Method == http.MethodDelete:
+		err = f.deleteObject(w, b, key)
+	default:
+		err = errorf(http.StatusNotImplemented, "NotImplemented", "%s isn't supported by the fake", r.Method)
+	}
+	if err == nil {
+		return
+	}
+	if r.Method == http.MethodHead {
+		w.WriteHeader(err.status)
+		return
+	}
+	writeXML(w, err.status, err)
+}
+
+// readBody returns the request payload, decoding aws-chunked bodies, and
+// the checksum the client sent with it, if any.
+func readBody(r *http.Request) ([]byte, string, *s3Error) {
+	checksum := r.Header.Get("X-Amz-Checksum-Sha256")
+	chunked := strings.Contains(r.Header.Get("Content-Encoding"), "aws-chunked") ||
+		strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-")
+	if !chunked {
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, "", errorf(http.StatusBadRequest, "IncompleteBody", "%v", err)
+		}
+		return data, checksum, nil
+	}
+
+	var data bytes.Buffer
+	br := bufio.NewReader(r.Body)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, "", errorf(http.StatusBadRequest, "IncompleteBody", "truncated aws-chunked body")
+		}
+		sizeHex, _, _ := strings.Cut(strings.TrimSpace(line), ";")
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil {
+			return nil, "", errorf(http.StatusBadRequest, "InvalidRequest", "bad chunk size %q", sizeHex)
+		}
+		if size == 0 {
+			break
+		}
+		if _, err := io.CopyN(&data, br, size); err != nil {
+			return nil, "", errorf(http.StatusBadRequest, "IncompleteBody", "truncated aws-chunked body")
+		}
+		br.ReadString('\n')
+	}
+	for {
+		line, err := br.ReadString('\n')
+		line = strings.TrimSpace(line)
+		if name, value, ok := strings.Cut(line, ":"); ok && strings.EqualFold(name, "x-amz-checksum-sha256") {
+			checksum = strings.TrimSpace(value)
+		}
+		if err != nil || line == "" {
+			break
+		}
+	}
+	return data.Bytes(), checksum, nil
+}
+
+// checkDigests verifies the checksums sent with data and returns its
+// SHA-256 checksum if one was sent or asked for.
+func checkDigests(r *http.Request, data []byte, checksum string) (string, *s3Error) {
+	if want := r.Header.Get("Content-Md5"); want != "" {
+		sum := md5.Sum(data)
+		if want != base64.StdEncoding.EncodeToString(sum[:]) {
+			return "", errorf(http.StatusBadRequest, "BadDigest", "The Content-MD5 you specified did not match what we received")
+		}
+	}
+	algorithm := r.Header.Get("X-Amz-Sdk-Checksum-Algorithm") + r.Header.Get("X-Amz-Checksum-Algorithm")
+	if checksum == "" && !strings.EqualFold(algorithm, "SHA256") {
+		return "", nil
+	}
+	sum := sha256.Sum256(data)
+	actual := base64.StdEncoding.EncodeToString(sum[:])
+	if checksum != "" && checksum != actual {
+		return "", errorf(http.StatusBadRequest, "BadDigest", "The SHA256 you specified did not match the calculated checksum")
+	}
+	return actual, nil
+}
+
+func requestHeaders(r *http.Request) http.Header {
+	header := http.Header{}
+	for _, name := range storedHeaders {
+		if v := r.Header.Get(name); v != "" {
+			header.Set(name, v)
+		}
+	}
+	for name, values := range r.Header {
+		if strings.HasPrefix(strings.ToLower(name), "x-amz-meta-") {
+			header[name] = values
+		}
+	}
+	return header
+}
+
+// applyLock sets the retention and legal hold requested in r.
+func applyLock(r *http.Request, b *fakeBucket, obj *fakeObject) *s3Error {
+	mode := r.Header.Get("X-Amz-Object-Lock-Mode")
+	hold := r.Header.Get("X-Amz-Object-Lock-Legal-Hold") == "ON"
+	if (mode != "" || hold) && !b.objectLock {
+		return errorf(http.StatusBadRequest, "InvalidRequest", "Bucket is missing Object Lock Configuration")
+	}
+	if mode != "" {
+		until, err := time.Parse(time.RFC3339, r.Header.Get("X-Amz-Object-Lock-Retain-Until-Date"))
+		if err != nil {
+			return errorf(http.StatusBadRequest, "InvalidArgument", "invalid retain until date")
+		}
+		obj.lockMode, obj.retainUntil = mode, until
+	}
+	obj.legalHold = hold
+	return nil
+}
+
+func (obj *fakeObject) locked(now time.Time) bool {
+	return obj.legalHold || obj.retainUntil.After(now)
+}
+
+func (f *S3) putObject(w http.ResponseWriter, r *http.Request, b *fakeBucket, key string) *s3Error {
+	data, checksum, err := readBody(r)
+	if err != nil {
+		return err
+	}
+	checksum, err = checkDigests(r, data, checksum)
+	if err != nil {
+		return err
+	}
+	obj := f.newObject(data, requestHeaders(r))
+	obj.checksum = checksum
+	if err := applyLock(r, b, obj); err != nil {
+		return err
+	}
+	b.objects[key] = obj
+	w.Header().Set("ETag", obj.etag)
+	if checksum != "" {
+		w.Header().Set("X-Amz-Checksum-Sha256", checksum)
+	}
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func (f *S3) getObject(w http.ResponseWriter, r *http.Request, b *fakeBucket, key string) *s3Error {
+	obj, ok := b.objects[key]
+	if !ok {
+		return errorf(http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+	}
+	for name, values := range obj.header {
+		w.Header()[name] = values
+	}
+	w.Header().Set("ETag", obj.etag)
+	w.Header().Set("Last-Modified", obj.lastModified.Format(http.TimeFormat))
+	w.Header().Set("Accept-Ranges", "bytes")
+	if obj.checksum != "" && r.Header.Get("X-Amz-Checksum-Mode") == "ENABLED" {
+		w.Header().Set("X-Amz-Checksum-Sha256", obj.checksum)
+	}
+	if obj.lockMode != "" {
+		w.Header().Set("X-Amz-Object-Lock-Mode", obj.lockMode)
+		w.Header().Set("X-Amz-Object-Lock-Retain-Until-Date", obj.retainUntil.UTC().Format(time.RFC3339))
+	}
+	if obj.legalHold {
+		w.Header().Set("X-Amz-Object-Lock-Legal-Hold", "ON")
+	}
+
+	data := obj.data
+	status := http.StatusOK
+	if spec := r.Header.Get("Range"); spec != "" {
+		start, end, ok := parseRange(spec, int64(len(data)))
+		if !ok {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", len(data)))
+			return errorf(http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "The requested range is not satisfiable")
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+		data = data[start : end+1]
+		status = http.StatusPartialContent
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(status)
+	if r.Method == http.MethodGet {
+		w.Write(data)
+	}
+	return nil
+}
+
+// parseRange parses a single bytes=start-end range into inclusive offsets.
+func parseRange(spec string, size int64) (start, end int64, ok bool) {
+	first, last, found := strings.Cut(strings.TrimPrefix(spec, "bytes="), "-")
+	if !found || strings.Contains(last, ",") {
+		return 0, 0, false
+	}
+	var err error
+	switch {
+	case first == "":
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 {
+			return 0, 0, false
+		}
+		start, end = max(size-n, 0), size-1
+	default:
+		start, err = strconv.ParseInt(first, 10, 64)
+		if err != nil {
+			return 0, 0, false
+		}
+		end = size - 1
+		if last != "" {
+			end, err = strconv.ParseInt(last, 10, 64)
+			if err != nil {
+				return 0, 0, false
+			}
+			end = min(end, size-1)
+		}
+	}
+	if start > end || start >= size {
+		return 0, 0, false
+	}
+	return start, end, true
+}
+
+func (f *S3) deleteObject(w http.ResponseWriter, b *fakeBucket, key string) *s3Error {
+	if obj, ok := b.objects[key]; ok && obj.locked(f.now) {
+		return errorf(http.StatusForbidden, "AccessDenied", "Access Denied because object protected by object lock.")
+	}
+	delete(b.objects, key)
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (f *S3) copyObject(w http.ResponseWriter, r *http.Request, b *fakeBucket, key string) *s3Error {
+	source, err := url.PathUnescape(strings.TrimPrefix(r.Header.Get("X-Amz-Copy-Source"), "/"))
+	if err != nil {
+		return errorf(http.StatusBadRequest, "InvalidArgument", "invalid copy source")
+	}
+	sourceBucket, sourceKey, _ := strings.Cut(source, "/")
+	sb, ok := f.buckets[sourceBucket]
+	if !ok {
+		return errorf(http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+	}
+	src, ok := sb.objects[sourceKey]
+	if !ok {
+		return errorf(http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+	}
+	header := src.header.Clone()
+	if r.Header.Get("X-Amz-Metadata-Directive") == "REPLACE" {
+		header = requestHeaders(r)
+	}
+	// Copies are encrypted as the request asks, not as the source was.
+	for _, name := range []string{"X-Amz-Server-Side-Encryption", "X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id"} {
+		header.Del(name)
+		if v := r.Header.Get(name); v != "" {
+			header.Set(name, v)
+		}
+	}
+	obj := f.newObject(bytes.Clone(src.data), header)
+	obj.checksum = src.checksum
+	if strings.EqualFold(r.Header.Get("X-Amz-Checksum-Algorithm"), "SHA256") {
+		sum := sha256.Sum256(obj.data)
+		obj.checksum = base64.StdEncoding.EncodeToString(sum[:])
+	}
+	if err := applyLock(r, b, obj); err != nil {
+		return err
+	}
+	b.objects[key] = obj
+
+	type copyObjectResult struct {
+		XMLName        xml.Name `xml:"CopyObjectResult"`
+		Xmlns          string   `xml:"xmlns,attr"`
+		ETag           string   `xml:"ETag"`
+		LastModified   string   `xml:"LastModified"`
+		ChecksumSHA256 string   `xml:"ChecksumSHA256,omitempty"`
+	}
+	writeXML(w, http.StatusOK, copyObjectResult{
+		Xmlns:          s3Namespace,
+		ETag:           obj.etag,
+		LastModified:   s3Time(obj.lastModified),
+		ChecksumSHA256: obj.checksum,
+	})
+	return nil
+}
+
+func (f *S3) listObjects(w http.ResponseWriter, bucket string, b *fakeBucket, query url.Values) *s3Error {
+	type object struct {
+		Key          string `xml:"Key"`
+		LastModified string `xml:"LastModified"`
+		ETag         string `xml:"ETag"`
+		Size         int    `xml:"Size"`
+		StorageClass string `xml:"StorageClass"`
+	}
+	type commonPrefix struct {
+		Prefix string `xml:"Prefix"`
+	}
+	type listBucketResult struct {
+		XMLName               xml.Name       `xml:"ListBucketResult"`
+		Xmlns                 string         `xml:"xmlns,attr"`
+		Name                  string         `xml:"Name"`
+		Prefix                string         `xml:"Prefix"`
+		Delimiter             string         `xml:"Delimiter,omitempty"`
+		StartAfter            string         `xml:"StartAfter,omitempty"`
+		ContinuationToken     string         `xml:"ContinuationToken,omitempty"`
+		NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
+		KeyCount              int            `xml:"KeyCount"`
+		MaxKeys               int            `xml:"MaxKeys"`
+		IsTruncated           bool           `xml:"IsTruncated"`
+		Contents              []object       `xml:"Contents"`
+		CommonPrefixes        []commonPrefix `xml:"CommonPrefixes"`
+	}
+
+	maxKeys := 1000
+	if v := query.Get("max-keys"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return errorf(http.StatusBadRequest, "InvalidArgument", "invalid max-keys")
+		}
+		maxKeys = min(n, 1000)
+	}
+	result := listBucketResult{
+		Xmlns:             s3Namespace,
+		Name:              bucket,
+		Prefix:            query.Get("prefix"),
+		Delimiter:         query.Get("delimiter"),
+		StartAfter:        query.Get("start-after"),
+		ContinuationToken: query.Get("continuation-token"),
+		MaxKeys:           maxKeys,
+	}
+	after := result.StartAfter
+	if result.ContinuationToken != "" {
+		token, err := base64.StdEncoding.DecodeString(result.ContinuationToken)
+		if err != nil {
+			return errorf(http.StatusBadRequest, "InvalidArgument", "The continuation token provided is incorrect")
+		}
+		after = string(token)
+	}
+
+	seen := map[string]bool{}
+	last := ""
+	for _, key := range b.sortedKeys() {
+		if key <= after || !strings.HasPrefix(key, result.Prefix) {
+			continue
+		}
+		if result.KeyCount == maxKeys {
+			result.IsTruncated = true
+			result.NextContinuationToken = base64.StdEncoding.EncodeToString([]byte(last))
+			break
+		}
+		if result.Delimiter != "" {
+			rest := strings.TrimPrefix(key, result.Prefix)
+			if i := strings.Index(rest, result.Delimiter); i >= 0 {
+				prefix := result.Prefix + rest[:i+len(result.Delimiter)]
+				if !seen[prefix] {
+					seen[prefix] = true
+					result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{Prefix: prefix})
+					result.KeyCount++
+				}
+				last = key
+				continue
+			}
+		}
+		obj := b.objects[key]
+		result.Contents = append(result.Contents, object{
+			Key:          key,
+			LastModified: s3Time(obj.lastModified),
+			ETag:         obj.etag,
+			Size:         len(obj.data),
+			StorageClass: "STANDARD",
+		})
+		result.KeyCount++
+		last = key
+	}
+	writeXML(w, http.StatusOK, result)
+	return nil
+}
+
+func (f *S3) getObjectLockConfiguration(w http.ResponseWriter, b *fakeBucket) *s3Error {
+	if !b.objectLock {
+		return errorf(http.StatusNotFound, "ObjectLockConfigurationNotFoundError", "Object Lock configuration does not exist for this bucket")
+	}
+	type objectLockConfiguration struct {
+		XMLName           xml.Name `xml:"ObjectLockConfiguration"`
+		Xmlns             string   `xml:"xmlns,attr"`
+		ObjectLockEnabled string   `xml:"ObjectLockEnabled"`
+	}
+	writeXML(w, http.StatusOK, objectLockConfiguration{Xmlns: s3Namespace, ObjectLockEnabled: "Enabled"})
+	return nil
+}
+
+func (f *S3) putLegalHold(w http.ResponseWriter, r *http.Request, b *fakeBucket, key string) *s3Error {
+	if !b.objectLock {
+		return errorf(http.StatusBadRequest, "InvalidRequest", "Bucket is missing Object Lock Configuration")
+	}
+	obj, ok := b.objects[key]
+	if !ok {
+		return errorf(http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+	}
+	data, _, err := readBody(r)
+	if err != nil {
+		return err
+	}
+	var hold struct {
+		Status string `xml:"Status"`
+	}
+	if err := xml.Unmarshal(data, &hold); err != nil {
+		return errorf(http.StatusBadRequest, "MalformedXML", "%v", err)
+	}
+	obj.legalHold = hold.Status == "ON"
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// objectTagging returns (GET), replaces (PUT) or removes (DELETE) an
+// object's tags.
+func (f *S3) objectTagging(w http.ResponseWriter, r *http.Request, b *fakeBucket, key string) *s3Error {
+	obj, ok := b.objects[key]
+	if !ok {
+		return errorf(http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+	}
+	switch r.Method {
+	case http.MethodGet:
+		type tag struct {
+			Key   string `xml:"Key"`
+			Value string `xml:"Value"`
+		}
+		type tagging struct {
+			XMLName xml.Name `xml:"Tagging"`
+			Xmlns   string   `xml:"xmlns,attr"`
+			Tags    []tag    `xml:"TagSet>Tag"`
+		}
+		out := tagging{Xmlns: s3Namespace, Tags: []tag{}}
+		keys := make([]string, 0, len(obj.tags))
+		for k := range obj.tags {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			out.Tags = append(out.Tags, tag{Key: k, Value: obj.tags[k]})
+		}
+		writeXML(w, http.StatusOK, out)
+	case http.MethodPut:
+		data, _, err := readBody(r)
+		if err != nil {
+			return err
+		}
+		var tagging struct {
+			Tags []struct {
+				Key   string `xml:"Key"`
+				Value string `xml:"Value"`
+			} `xml:"TagSet>Tag"`
+		}
+		if err := xml.Unmarshal(data, &tagging); err != nil {
+			return errorf(http.StatusBadRequest, "MalformedXML", "%v", err)
+		}
+		obj.tags = map[string]string{}
+		for _, tag := range tagging.Tags {
+			obj.tags[tag.Key] = tag.Value
+		}
+		w.WriteHeader(http.StatusOK)
+	case http.MethodDelete:
+		obj.tags = nil
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		return errorf(http.StatusNotImplemented, "NotImplemented", "%s of tags isn't supported by the fake", r.Method)
+	}
+	return nil
+}
+
+func (f *S3) createMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key string) *s3Error {
+	f.nextUpload++
+	id := fmt.Sprintf("upload-%d", f.nextUpload)
+	f.uploads[id] = &fakeUpload{bucket: bucket, key: key, header: requestHeaders(r), parts: map[int]*fakeObject{}, initiated: f.tick()}
+
+	type initiateMultipartUploadResult struct {
+		XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+		Xmlns    string   `xml:"xmlns,attr"`
+		Bucket   string   `xml:"Bucket"`
+		Key      string   `xml:"Key"`
+		UploadID string   `xml:"UploadId"`
+	}
+	writeXML(w, http.StatusOK, initiateMultipartUploadResult{Xmlns: s3Namespace, Bucket: bucket, Key: key, UploadID: id})
+	return nil
+}
+
+// listMultipartUploads lists the bucket's uploads in progress under the
+// prefix. The fake never has more than fit in one page.
+func (f *S3) listMultipartUploads(w http.ResponseWriter, bucket string, query url.Values) *s3Error {
+	type upload struct {
+		Key       string `xml:"Key"`
+		UploadID  string `xml:"UploadId"`
+		Initiated string `xml:"Initiated"`
+	}
+	type listMultipartUploadsResult struct {
+		XMLName     xml.Name `xml:"ListMultipartUploadsResult"`
+		Xmlns       string   `xml:"xmlns,attr"`
+		Bucket      string   `xml:"Bucket"`
+		Prefix      string   `xml:"Prefix"`
+		IsTruncated bool     `xml:"IsTruncated"`
+		Uploads     []upload `xml:"Upload"`
+	}
+
+	result := listMultipartUploadsResult{Xmlns: s3Namespace, Bucket: bucket, Prefix: query.Get("prefix")}
+	for id, u := range f.uploads {
+		if u.bucket == bucket && strings.HasPrefix(u.key, result.Prefix) {
+			result.Uploads = append(result.Uploads, upload{Key: u.key, UploadID: id, Initiated: u.initiated.Format(time.RFC3339)})
+		}
+	}
+	sort.Slice(result.Uploads, func(i, j int) bool {
+		a, b := result.Uploads[i], result.Uploads[j]
+		return a.Key < b.Key || a.Key == b.Key && a.UploadID < b.UploadID
+	})
+	writeXML(w, http.StatusOK, result)
+	return nil
+}
+
+func (f *S3) upload(query url.Values) (*fakeUpload, *s3Error) {
+	upload, ok := f.uploads[query.Get("uploadId")]
+	if !ok {
+		return nil, errorf(http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist.")
+	}
+	return upload, nil
+}
+
+func (f *S3) uploadPart(w http.ResponseWriter, r *http.Request, query url.Values) *s3Error {
+	upload, err := f.upload(query)
+	if err != nil {
+		return err
+	}
+	number, perr := strconv.Atoi(query.Get("partNumber"))
+	if perr != nil || number < 1 || number > 10000 {
+		return errorf(http.StatusBadRequest, "InvalidArgument", "Part number must be an integer between 1 and 10000")
+	}
+	data, checksum, err := readBody(r)
+	if err != nil {
+		return err
+	}
+	if _, err := checkDigests(r, data, checksum); err != nil {
+		return err
+	}
+	part := f.newObject(data, nil)
+	upload.parts[number] = part
+	w.Header().Set("ETag", part.etag)
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func (f *S3) completeMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key string, b *fakeBucket, query url.Values) *s3Error {
+	upload, err := f.upload(query)
+	if err != nil {
+		return err
+	}
+	body, _, err := readBody(r)
+	if err != nil {
+		return err
+	}
+	var complete struct {
+		Parts []struct {
+			PartNumber int    `xml:"PartNumber"`
+			ETag       string `xml:"ETag"`
+		} `xml:"Part"`
+	}
+	if err := xml.Unmarshal(body, &complete); err != nil {
+		return errorf(http.StatusBadRequest, "MalformedXML", "%v", err)
+	}
+	if len(complete.Parts) == 0 {
+		return errorf(http.StatusBadRequest, "MalformedXML", "The XML you provided was not well-formed")
+	}
+
+	var data bytes.Buffer
+	digests := md5.New()
+	previous := 0
+	for _, p := range complete.Parts {
+		part, ok := upload.parts[p.PartNumber]
+		if !ok || part.etag != `"`+strings.Trim(p.ETag, `"`)+`"` {
+			return errorf(http.StatusBadRequest, "InvalidPart", "One or more of the specified parts could not be found.")
+		}
+		if p.PartNumber <= previous {
+			return errorf(http.StatusBadRequest, "InvalidPartOrder", "The list of parts was not in ascending order.")
+		}
+		previous = p.PartNumber
+		data.Write(part.data)
+		sum, _ := hex.DecodeString(strings.Trim(part.etag, `"`))
+		digests.Write(sum)
+	}
+	obj := f.newObject(data.Bytes(), upload.header)
+	obj.etag = fmt.Sprintf(`"%s-%d"`, hex.EncodeToString(digests.Sum(nil)), len(complete.Parts))
+	b.objects[key] = obj
+	delete(f.uploads, query.Get("uploadId"))
+
+	type completeMultipartUploadResult struct {
+		XMLName  xml.Name `xml:"CompleteMultipartUploadResult"`
+		Xmlns    string   `xml:"xmlns,attr"`
+		Location string   `xml:"Location"`
+		Bucket   string   `xml:"Bucket"`
+		Key      string   `xml:"Key"`
+		ETag     string   `xml:"ETag"`
+	}
+	writeXML(w, http.StatusOK, completeMultipartUploadResult{
+		Xmlns:    s3Namespace,
+		Location: f.server.URL + "/" + bucket + "/" + key,
+		Bucket:   bucket,
+		Key:      key,
+		ETag:     obj.etag,
+	})
+	return nil
+}
+
+func (f *S3) abortMultipartUpload(w http.ResponseWriter, query url.Values) *s3Error {
+	if _, err := f.upload(query); err != nil {
+		return err
+	}
+	delete(f.uploads, query.Get("uploadId"))
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (f *S3) listParts(w http.ResponseWriter, bucket, key string, query url.Values) *s3Error {
+	upload, err := f.upload(query)
+	if err != nil {
+		return err
+	}
+	type part struct {
+		PartNumber   int    `xml:"PartNumber"`
+		LastModified string `xml:"LastModified"`
+		ETag         string `xml:"ETag"`
+		Size         int    `xml:"Size"`
+	}
+	type listPartsResult struct {
+		XMLName     xml.Name `xml:"ListPartsResult"`
+		Xmlns       string   `xml:"xmlns,attr"`
+		Bucket      string   `xml:"Bucket"`
+		Key         string   `xml:"Key"`
+		UploadID    string   `xml:"UploadId"`
+		MaxParts    int      `xml:"MaxParts"`
+		IsTruncated bool     `xml:"IsTruncated"`
+		Parts       []part   `xml:"Part"`
+	}
+	result := listPartsResult{Xmlns: s3Namespace, Bucket: bucket, Key: key, UploadID: query.Get("uploadId"), MaxParts: 10000}
+	numbers := make([]int, 0, len(upload.parts))
+	for n := range upload.parts {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+	for _, n := range numbers {
+		p := upload.parts[n]
+		result.Parts = append(result.Parts, part{PartNumber: n, LastModified: s3Time(p.lastModified), ETag: p.etag, Size: len(p.data)})
+	}
+	writeXML(w, http.StatusOK, result)
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/fieldcrypt"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/kms"
+)
+
+// fieldKeyringFromEnv reads the keys that encrypt personal data and
+// secrets in the database, or returns nil if FIELD_ENCRYPTION_KEYS isn't
+// set.
+//
+// FIELD_ENCRYPTION_KEYS is a comma-separated list of id:key, where key is
+// 32 bytes, base64-encoded, or kms:blob, where blob is a data key KMS
+// encrypted, base64-encoded, such as the CiphertextBlob of aws kms
+// generate-data-key --key-spec AES_256. The first key encrypts; the others
+// are kept to read what they encrypted until reencrypt-fields has moved it
+// to the first. FIELD_ENCRYPTION_INDEX_KEY, a key or kms:blob of at least
+// 32 bytes, keys the email index and API key hashes, and can't be changed
+// without making API keys unusable.
+func fieldKeyringFromEnv(ctx context.Context, region string) (*fieldcrypt.Keyring, error) {
+	spec := os.Getenv("FIELD_ENCRYPTION_KEYS")
+	if spec == "" {
+		if os.Getenv("FIELD_ENCRYPTION_INDEX_KEY") != "" {
+			return nil, errors.New("FIELD_ENCRYPTION_INDEX_KEY needs FIELD_ENCRYPTION_KEYS")
+		}
+		return nil, nil
+	}
+
+	var client *kms.Client
+	decode := func(name, v string) ([]byte, error) {
+		blob, isKMS := strings.CutPrefix(v, "kms:")
+		raw, err := base64.StdEncoding.DecodeString(blob)
+		if err != nil {
+			return nil, fmt.Errorf("%s isn't base64", name)
+		}
+		if !isKMS {
+			return raw, nil
+		}
+		if client == nil {
+			if r := os.Getenv("FIELD_ENCRYPTION_KMS_REGION"); r != "" {
+				region = r
+			}
+			awsCfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
+			if err != nil {
+				return nil, err
+			}
+			client = kms.New(region, os.Getenv("FIELD_ENCRYPTION_KMS_ENDPOINT"), awsCfg.Credentials)
+		}
+		key, err := client.Decrypt(ctx, raw)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't decrypt %s with KMS: %w", name, err)
+		}
+		return key, nil
+	}
+
+	keys := map[string][]byte{}
+	primary := ""
+	for _, entry := range strings.Split(spec, ",") {
+		id, v, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok || id == "" || v == "" {
+			return nil, fmt.Errorf("FIELD_ENCRYPTION_KEYS entry %q must be id:key or id:kms:blob", entry)
+		}
+		if _, ok := keys[id]; ok {
+			return nil, fmt.Errorf("FIELD_ENCRYPTION_KEYS has key %s twice", id)
+		}
+		key, err := decode("key "+id, v)
+		if err != nil {
+			return nil, err
+		}
+		keys[id] = key
+		if primary == "" {
+			primary = id
+		}
+	}
+
+	indexSpec := os.Getenv("FIELD_ENCRYPTION_INDEX_KEY")
+	if indexSpec == "" {
+		return nil, errors.New("FIELD_ENCRYPTION_INDEX_KEY must be set with FIELD_ENCRYPTION_KEYS")
+	}
+	indexKey, err := decode("FIELD_ENCRYPTION_INDEX_KEY", indexSpec)
+	if err != nil {
+		return nil, err
+	}
+	return fieldcrypt.New(primary, keys, indexKey)
+}
+
+// runReencryptCommand encrypts every encrypted field that isn't yet with
+// the first of FIELD_ENCRYPTION_KEYS, after turning encryption on or
+// adding a key. Once it's done, the older keys can be removed.
+func (cfg *apiConfig) runReencryptCommand(args []string) error {
+	fs := flag.NewFlagSet("reencrypt-fields", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if cfg.fields == nil {
+		return errors.New("FIELD_ENCRYPTION_KEYS must be set to re-encrypt fields")
+	}
+	updated, err := cfg.db.ReencryptFields()
+	fields := make([]string, 0, len(updated))
+	for field := range updated {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		log.Printf("Re-encrypted %d %s with key %s", updated[field], field, cfg.fields.Primary())
+	}
+	return err
+}
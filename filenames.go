@@ -0,0 +1,53 @@
+package main
+
+import (
+	"mime"
+	"path"
+	"strings"
+	"unicode"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// maxFilenameBytes matches the limit of most filesystems a download could
+// be saved to.
+const maxFilenameBytes = 255
+
+// sanitizeFilename reduces a client-supplied filename to a safe base name
+// with the given extension: directories, control characters and characters
+// that are special on common filesystems are removed. It returns "" if
+// nothing usable is left.
+func sanitizeFilename(name, ext string) string {
+	name = normalizeText(name, false)
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	name = strings.TrimSuffix(name, path.Ext(name))
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsControl(r), r == unicode.ReplacementChar:
+			return -1
+		case strings.ContainsRune(`/\:*?"<>|`, r):
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.Trim(name, " .")
+	if name == "" {
+		return ""
+	}
+
+	for len(name)+len(ext) > maxFilenameBytes {
+		runes := []rune(name)
+		name = string(runes[:len(runes)-1])
+	}
+	return name + ext
+}
+
+// withContentDisposition makes browsers save the object as filename rather
+// than under its random key. The disposition stays inline so the object
+// still plays in place. Non-ASCII names are encoded per RFC 2231.
+func withContentDisposition(filename string) func(*s3.PutObjectInput) {
+	return func(input *s3.PutObjectInput) {
+		input.ContentDisposition = aws.String(mime.FormatMediaType("inline", map[string]string{"filename": filename}))
+	}
+}
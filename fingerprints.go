@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/fingerprint"
+	"github.com/google/uuid"
+)
+
+const eventFingerprintMatch = "video.fingerprint_match"
+
+// catalogReferences loads the registered references for the local catalog
+// checker.
+func (cfg *apiConfig) catalogReferences(ctx context.Context) ([]fingerprint.Reference, error) {
+	stored, err := cfg.db.GetFingerprintReferences()
+	if err != nil {
+		return nil, err
+	}
+	refs := make([]fingerprint.Reference, 0, len(stored))
+	for _, s := range stored {
+		fp, err := fingerprint.FromBytes(s.Fingerprint)
+		if err != nil {
+			return nil, fmt.Errorf("reference %s: %w", s.ID, err)
+		}
+		refs = append(refs, fingerprint.Reference{ID: s.ID.String(), Title: s.Title, Fingerprint: fp})
+	}
+	return refs, nil
+}
+
+// checkFingerprints runs the configured fingerprint checker on a file with
+// audio. A failing checker doesn't block processing: the failure is recorded
+// on the processing log and the upload goes through unchecked.
+func (cfg *apiConfig) checkFingerprints(ctx context.Context, path string) []fingerprint.Match {
+	if cfg.fingerprints == nil {
+		return nil
+	}
+	probeOutput, err := cfg.probeVideo(ctx, path)
+	if err != nil {
+		return nil
+	}
+	if _, ok := probeOutput.firstStream("audio"); !ok {
+		return nil
+	}
+
+	start := time.Now()
+	matches, err := cfg.fingerprints.Check(ctx, path)
+	logStep(ctx, "fingerprint", fmt.Sprintf("%d matches", len(matches)), start, err)
+	if err != nil {
+		log.Printf("Fingerprint check of %s failed: %v", path, err)
+		return nil
+	}
+	return matches
+}
+
+// flagFingerprintMatches files a copyright report for moderators to review.
+// The caller holds the video until then.
+func (cfg *apiConfig) flagFingerprintMatches(videoID uuid.UUID, matches []fingerprint.Match) {
+	details := make([]string, 0, len(matches))
+	for _, m := range matches {
+		details = append(details, fmt.Sprintf("%s (score %.2f at %.1fs)", m.Title, m.Score, m.OffsetSeconds))
+	}
+	_, err := cfg.db.CreateReport(database.Report{
+		ID:        uuid.New(),
+		VideoID:   videoID,
+		Source:    database.ReportSourceFingerprint,
+		Reason:    "copyright",
+		Details:   "Fingerprint matches: " + strings.Join(details, "; "),
+		Status:    database.ReportStatusOpen,
+		CreatedAt: cfg.clock.Now().UTC(),
+	})
+	if err != nil && !errors.Is(err, database.ErrDuplicateReport) {
+		log.Printf("Couldn't file fingerprint report for video %s: %v", videoID, err)
+	}
+	cfg.emitEvent(eventFingerprintMatch, videoID, map[string]any{"matches": matches})
+}
+
+// handlerFingerprintReferenceCreate registers a reference work from an
+// uploaded audio or video file, sent as the "file" form field with a
+// "title".
+func (cfg *apiConfig) handlerFingerprintReferenceCreate(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+
+	const maxMemory = 10 << 20
+	if err := r.ParseMultipartForm(maxMemory); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Error parsing form data", err)
+		return
+	}
+	title, err := titleLimit.apply(r.FormValue("title"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Unable to parse form file", err)
+		return
+	}
+	defer file.Close()
+
+	tempFile, err := os.CreateTemp("", "tubely-reference-*")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to create temporary file", err)
+		return
+	}
+	defer os.Remove(tempFile.Name())
+	defer tempFile.Close()
+	if _, err := io.Copy(tempFile, file); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to save file to disk", err)
+		return
+	}
+
+	fp, err := fingerprint.Compute(r.Context(), tempFile.Name())
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't fingerprint file audio", err)
+		return
+	}
+
+	ref := database.FingerprintReference{
+		ID:          uuid.New(),
+		Title:       title,
+		Frames:      len(fp),
+		CreatedAt:   cfg.clock.Now().UTC(),
+		Fingerprint: fp.Bytes(),
+	}
+	if err := cfg.db.CreateFingerprintReference(ref); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save fingerprint reference", err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, ref)
+}
+
+func (cfg *apiConfig) handlerFingerprintReferencesList(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+
+	refs, err := cfg.db.GetFingerprintReferences()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get fingerprint references", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, refs)
+}
+
+func (cfg *apiConfig) handlerFingerprintReferenceDelete(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+
+	referenceID, err := uuid.Parse(r.PathValue("referenceID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+	if err := cfg.db.DeleteFingerprintReference(referenceID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete fingerprint reference", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
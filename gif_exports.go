@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ffmpeg"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tenants"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultGIFDuration = 5.0
+	maxGIFDuration     = 15.0
+	defaultGIFFPS      = 12
+	maxGIFFPS          = 30
+	defaultGIFWidth    = 480
+	minGIFWidth        = 32
+	maxGIFWidth        = 800
+	// maxGIFBytes caps the size of an export. GIFs grow quickly with
+	// motion, so a range within the other limits can still be rejected.
+	maxGIFBytes = 20 << 20
+	// gifExportTTL is how long a finished export can still be polled.
+	gifExportTTL = time.Hour
+)
+
+// gifExport is a GIF of a time range of a video being made in the
+// background. URL is set once it's done, and Error if it failed.
+type gifExport struct {
+	mu sync.Mutex
+
+	ID         uuid.UUID   `json:"id"`
+	VideoID    uuid.UUID   `json:"video_id"`
+	Status     jobs.Status `json:"status"`
+	Start      float64     `json:"start"`
+	Duration   float64     `json:"duration"`
+	FPS        int         `json:"fps"`
+	Width      int         `json:"width"`
+	Size       int64       `json:"size,omitempty"`
+	URL        string      `json:"url,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	CreatedAt  time.Time   `json:"created_at"`
+	FinishedAt *time.Time  `json:"finished_at,omitempty"`
+
+	userID uuid.UUID
+	target tenants.Target
+	key    string
+}
+
+// snapshot returns a copy of the export that is safe to encode while its
+// job keeps updating the original.
+func (e *gifExport) snapshot() *gifExport {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return &gifExport{
+		ID:         e.ID,
+		VideoID:    e.VideoID,
+		Status:     e.Status,
+		Start:      e.Start,
+		Duration:   e.Duration,
+		FPS:        e.FPS,
+		Width:      e.Width,
+		Size:       e.Size,
+		Error:      e.Error,
+		CreatedAt:  e.CreatedAt,
+		FinishedAt: e.FinishedAt,
+		userID:     e.userID,
+		target:     e.target,
+		key:        e.key,
+	}
+}
+
+func (e *gifExport) finish(now time.Time, size int64, err error) {
+	finishedAt := now.UTC()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.FinishedAt = &finishedAt
+	if err != nil {
+		e.Status = jobs.StatusFailed
+		e.Error = err.Error()
+		return
+	}
+	e.Status = jobs.StatusDone
+	e.Size = size
+}
+
+// gifExports keeps exports in memory until gifExportTTL after they finish.
+// Each user can have one running at a time, so exports can't crowd video
+// processing out of the queue; exports of GIFs that already exist finish
+// straight away and don't count.
+type gifExports struct {
+	mu      sync.Mutex
+	exports map[uuid.UUID]*gifExport
+}
+
+func newGIFExports() *gifExports {
+	return &gifExports{exports: map[uuid.UUID]*gifExport{}}
+}
+
+var errGIFExportRunning = errors.New("a GIF export is already running")
+
+func (g *gifExports) start(export *gifExport, now time.Time) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for id, other := range g.exports {
+		s := other.snapshot()
+		if s.FinishedAt != nil && now.Sub(*s.FinishedAt) > gifExportTTL {
+			delete(g.exports, id)
+			continue
+		}
+		if export.FinishedAt == nil && s.userID == export.userID && s.FinishedAt == nil {
+			return errGIFExportRunning
+		}
+	}
+	g.exports[export.ID] = export
+	return nil
+}
+
+func (g *gifExports) get(id uuid.UUID) *gifExport {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.exports[id]
+}
+
+// handlerGIFExportCreate starts making a GIF of the video from start for
+// duration seconds, at fps frames per second and width pixels wide, and
+// responds with the export to poll. A GIF of the same range of the same
+// file is only made once.
+func (cfg *apiConfig) handlerGIFExportCreate(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.requireVideoOwner(w, r)
+	if !ok {
+		return
+	}
+
+	type parameters struct {
+		Start    float64  `json:"start"`
+		Duration *float64 `json:"duration"`
+		FPS      int      `json:"fps"`
+		Width    int      `json:"width"`
+	}
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Start < 0 || math.IsInf(params.Start, 0) || math.IsNaN(params.Start) {
+		respondWithError(w, http.StatusBadRequest, "start must be a non-negative timestamp in seconds", nil)
+		return
+	}
+	duration := defaultGIFDuration
+	if params.Duration != nil {
+		duration = *params.Duration
+	}
+	if !(duration > 0 && duration <= maxGIFDuration) {
+		respondWithError(w, http.StatusBadRequest, "duration must be more than 0 and at most 15 seconds", nil)
+		return
+	}
+	if params.FPS == 0 {
+		params.FPS = defaultGIFFPS
+	}
+	if params.FPS < 1 || params.FPS > maxGIFFPS {
+		respondWithError(w, http.StatusBadRequest, "fps must be between 1 and 30", nil)
+		return
+	}
+	if params.Width == 0 {
+		params.Width = defaultGIFWidth
+	}
+	if params.Width < minGIFWidth || params.Width > maxGIFWidth {
+		respondWithError(w, http.StatusBadRequest, "width must be between 32 and 800", nil)
+		return
+	}
+	// Ranges are kept to the millisecond, like frames.
+	start := math.Round(params.Start*1000) / 1000
+	duration = math.Round(duration*1000) / 1000
+
+	if video.VideoURL == nil {
+		respondWithError(w, http.StatusNotFound, "Video has no file", nil)
+		return
+	}
+	target, sourceKey, err := cfg.videoObject(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't locate video file", err)
+		return
+	}
+
+	// The key names the file the GIF was made from, so replacing the
+	// video's file doesn't hand out GIFs of the old one.
+	file := sha256.Sum256([]byte(sourceKey))
+	key := videoObjectKey(video.UserID, video.ID, fmt.Sprintf("gifs/%x-%d-%d-%d-%d.gif", file[:8], int64(start*1000), int64(duration*1000), params.FPS, params.Width))
+
+	export := &gifExport{
+		ID:        uuid.New(),
+		VideoID:   video.ID,
+		Status:    jobs.StatusQueued,
+		Start:     start,
+		Duration:  duration,
+		FPS:       params.FPS,
+		Width:     params.Width,
+		CreatedAt: cfg.clock.Now().UTC(),
+		userID:    video.UserID,
+		target:    target,
+		key:       key,
+	}
+
+	exists, err := objectExists(r.Context(), target, key)
+	if err != nil {
+		respondWithStorageError(w, http.StatusBadGateway, "Couldn't check for an existing GIF", err)
+		return
+	}
+	if exists {
+		export.finish(cfg.clock.Now(), 0, nil)
+	}
+	if err := cfg.gifExports.start(export, cfg.clock.Now()); err != nil {
+		respondWithError(w, http.StatusConflict, "You already have a GIF export running", err)
+		return
+	}
+	if exists {
+		cfg.respondWithGIFExport(w, r, http.StatusOK, export)
+		return
+	}
+	cfg.background.run(func() { cfg.runGIFExport(context.Background(), export, sourceKey) })
+
+	w.Header().Set("Location", fmt.Sprintf("/api/videos/%s/gif/%s", video.ID, export.ID))
+	cfg.respondWithGIFExport(w, r, http.StatusAccepted, export)
+}
+
+// handlerGIFExportGet reports how an export is going, with a URL to the GIF
+// once it's done.
+func (cfg *apiConfig) handlerGIFExportGet(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+	exportID, err := uuid.Parse(r.PathValue("exportID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+	userID, ok := cfg.requireUser(w, r)
+	if !ok {
+		return
+	}
+	export := cfg.gifExports.get(exportID)
+	if export == nil || export.VideoID != videoID || export.userID != userID {
+		respondWithError(w, http.StatusNotFound, "GIF export not found", nil)
+		return
+	}
+	cfg.respondWithGIFExport(w, r, http.StatusOK, export)
+}
+
+// respondWithGIFExport writes export, with the GIF's URL presigned if it's
+// done.
+func (cfg *apiConfig) respondWithGIFExport(w http.ResponseWriter, r *http.Request, code int, export *gifExport) {
+	s := export.snapshot()
+	if s.Status == jobs.StatusDone {
+		var err error
+		s.URL, err = generatePresignedURL(r.Context(), s.target, s.key, cfg.presignExpiry)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't generate GIF URL", err)
+			return
+		}
+	}
+	respondWithJSON(w, code, s)
+}
+
+// runGIFExport makes the GIF on the processing queue, where it waits its
+// turn behind uploads, and stores it under the export's key.
+func (cfg *apiConfig) runGIFExport(ctx context.Context, export *gifExport, sourceKey string) {
+	var size int64
+	err := cfg.jobs.Run(export.ID, export.Duration, func() error {
+		export.mu.Lock()
+		export.Status = jobs.StatusProcessing
+		export.mu.Unlock()
+		var err error
+		size, err = cfg.makeGIF(ctx, export, sourceKey)
+		return err
+	})
+	if err != nil {
+		log.Printf("GIF export %s of video %s failed: %v", export.ID, export.VideoID, err)
+	}
+	export.finish(cfg.clock.Now(), size, err)
+}
+
+func (cfg *apiConfig) makeGIF(ctx context.Context, export *gifExport, sourceKey string) (int64, error) {
+	sourceURL, err := generatePresignedURL(ctx, export.target, sourceKey, cfg.presignExpiry)
+	if err != nil {
+		return 0, fmt.Errorf("couldn't generate source URL: %w", err)
+	}
+
+	palette, err := os.CreateTemp("", "tubely-gif-palette-*.png")
+	if err != nil {
+		return 0, err
+	}
+	palette.Close()
+	defer os.Remove(palette.Name())
+	output, err := os.CreateTemp("", "tubely-gif-*.gif")
+	if err != nil {
+		return 0, err
+	}
+	output.Close()
+	defer os.Remove(output.Name())
+
+	if _, err := ffmpeg.GIFPaletteCommand(sourceURL, palette.Name(), export.Start, export.Duration, export.FPS, export.Width).Run(ctx); err != nil {
+		return 0, err
+	}
+	if info, err := os.Stat(palette.Name()); err != nil {
+		return 0, err
+	} else if info.Size() == 0 {
+		// As with frames, ffmpeg exits cleanly with nothing to show when
+		// the range starts past the end.
+		return 0, errors.New("start is past the end of the video")
+	}
+	if _, err := ffmpeg.GIFCommand(sourceURL, palette.Name(), output.Name(), export.Start, export.Duration, export.FPS, export.Width).Run(ctx); err != nil {
+		return 0, err
+	}
+	info, err := os.Stat(output.Name())
+	if err != nil {
+		return 0, err
+	}
+	if info.Size() > maxGIFBytes {
+		return 0, fmt.Errorf("GIF is %d bytes, more than the %d allowed; try a shorter range, a lower fps or a smaller width", info.Size(), maxGIFBytes)
+	}
+
+	if err := uploadFile(ctx, export.target, export.key, output.Name(), "image/gif"); err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/graphql"
+	"github.com/google/uuid"
+)
+
+const (
+	catalogDefaultLimit = 20
+	catalogMaxLimit     = 100
+	// graphQLMaxBody bounds the size of a query document and its variables.
+	graphQLMaxBody = 1 << 20
+)
+
+// videoStats are a video's view counts, from its recent access history.
+type videoStats struct {
+	Views int `json:"views"`
+	Plays int `json:"plays"`
+}
+
+type catalogStats struct {
+	Videos   int `json:"videos"`
+	Shorts   int `json:"shorts"`
+	Channels int `json:"channels"`
+}
+
+// catalogSchema returns the GraphQL schema for the video catalog as seen by
+// the viewer of r. Channels are the users who own videos. Everything is
+// read-only, and only videos the viewer can see are returned. The loaders
+// live for the request, so the owners, videos and stats of a whole page of
+// results are each fetched in one query.
+func (cfg *apiConfig) catalogSchema(r *http.Request) *graphql.Schema {
+	channels := graphql.NewLoader(func(ids []uuid.UUID) (map[uuid.UUID]database.User, error) {
+		users, err := cfg.db.GetUsersByIDs(ids)
+		if err != nil {
+			return nil, err
+		}
+		byID := map[uuid.UUID]database.User{}
+		for _, user := range users {
+			byID[user.ID] = user
+		}
+		return byID, nil
+	})
+	channelVideos := graphql.NewLoader(func(ids []uuid.UUID) (map[uuid.UUID][]database.Video, error) {
+		videos, err := cfg.db.GetVideosByUsers(ids)
+		if err != nil {
+			return nil, err
+		}
+		byUser := map[uuid.UUID][]database.Video{}
+		for _, id := range ids {
+			byUser[id] = []database.Video{}
+		}
+		for _, video := range videos {
+			if cfg.canList(r, video) {
+				byUser[video.UserID] = append(byUser[video.UserID], video)
+			}
+		}
+		return byUser, nil
+	})
+	stats := graphql.NewLoader(func(ids []uuid.UUID) (map[uuid.UUID]videoStats, error) {
+		counts, err := cfg.db.GetAccessCounts(ids)
+		if err != nil {
+			return nil, err
+		}
+		byVideo := map[uuid.UUID]videoStats{}
+		for _, id := range ids {
+			byVideo[id] = videoStats{}
+		}
+		for _, count := range counts {
+			s := byVideo[count.VideoID]
+			switch count.Kind {
+			case accessKindMetadata:
+				s.Views = count.Count
+			case accessKindPlayback:
+				s.Plays = count.Count
+			}
+			byVideo[count.VideoID] = s
+		}
+		return byVideo, nil
+	})
+
+	pageArgs := map[string]any{"limit": catalogDefaultLimit, "offset": 0}
+
+	statsType := &graphql.Object{Name: "VideoStats", Fields: map[string]*graphql.Field{
+		"views": {},
+		"plays": {},
+	}}
+	channelType := &graphql.Object{Name: "Channel"}
+	videoType := &graphql.Object{Name: "Video"}
+	videoType.Fields = map[string]*graphql.Field{
+		"id":          {},
+		"title":       {},
+		"description": {},
+		"tags":        {},
+		"created_at":  {},
+		"updated_at":  {},
+		"publish_at":  {},
+		"thumbnail_url": {Resolve: func(p graphql.Params) (any, error) {
+			video := p.Source.(database.Video)
+			if video.ThumbnailURL == nil {
+				return nil, nil
+			}
+			return cfg.thumbnailDeliveryURL(p.Context, video, *video.ThumbnailURL)
+		}},
+		"preview_url": {Resolve: func(p graphql.Params) (any, error) {
+			video := p.Source.(database.Video)
+			if _, ok := cfg.unlockedInContext(p.Context, video); video.PreviewURL == nil || !ok {
+				return nil, nil
+			}
+			load := channels.Load(video.UserID)
+			return graphql.Thunk(func() (any, error) {
+				owner, err := load()
+				if err != nil {
+					return nil, err
+				}
+				tenantID := ""
+				if owner != nil {
+					tenantID = owner.(database.User).TenantID
+				}
+				target, err := cfg.tenants.Target(p.Context, tenantID)
+				if err != nil {
+					return nil, err
+				}
+				return cfg.signStoredURL(p.Context, target, video, *video.PreviewURL)
+			}), nil
+		}},
+		"aspect_ratio":   {},
+		"is_short":       {},
+		"is_360":         {},
+		"content_rating": {},
+		"age_restricted": {},
+		"playback_url": {Resolve: func(p graphql.Params) (any, error) {
+			video := p.Source.(database.Video)
+			token, ok := cfg.unlockedInContext(p.Context, video)
+			if video.VideoURL == nil || !ok {
+				return nil, nil
+			}
+			target, err := cfg.videoTarget(p.Context, video)
+			if err != nil {
+				return nil, err
+			}
+			q := url.Values{}
+			if token != "" {
+				q.Set(unlockParam, token)
+			}
+			return cfg.playbackPath(target, video, q), nil
+		}},
+		"channel": {Type: channelType, Resolve: func(p graphql.Params) (any, error) {
+			return channels.Load(p.Source.(database.Video).UserID), nil
+		}},
+		"stats": {Type: statsType, Resolve: func(p graphql.Params) (any, error) {
+			return stats.Load(p.Source.(database.Video).ID), nil
+		}},
+	}
+	channelType.Fields = map[string]*graphql.Field{
+		"id":         {},
+		"created_at": {},
+		"videos": {Type: videoType, Args: pageArgs, Resolve: func(p graphql.Params) (any, error) {
+			load := channelVideos.Load(p.Source.(database.User).ID)
+			return graphql.Thunk(func() (any, error) {
+				videos, err := load()
+				if err != nil || videos == nil {
+					return nil, err
+				}
+				return page(videos.([]database.Video), p.Args)
+			}), nil
+		}},
+		"video_count": {Resolve: func(p graphql.Params) (any, error) {
+			load := channelVideos.Load(p.Source.(database.User).ID)
+			return graphql.Thunk(func() (any, error) {
+				videos, err := load()
+				if err != nil || videos == nil {
+					return 0, err
+				}
+				return len(videos.([]database.Video)), nil
+			}), nil
+		}},
+	}
+
+	query := &graphql.Object{Name: "Query", Fields: map[string]*graphql.Field{
+		"video": {Type: videoType, Args: map[string]any{"id": nil}, Resolve: func(p graphql.Params) (any, error) {
+			id, err := catalogID(p.Args)
+			if err != nil {
+				return nil, err
+			}
+			video, err := cfg.db.GetVideo(id)
+			if err != nil {
+				return nil, err
+			}
+			if video.ID == uuid.Nil || !cfg.canView(r, video) {
+				return nil, nil
+			}
+			return video, nil
+		}},
+		"videos": {Type: videoType, Args: map[string]any{"limit": catalogDefaultLimit, "offset": 0, "shorts": nil}, Resolve: func(p graphql.Params) (any, error) {
+			shorts, filterShorts, err := p.Args.Bool("shorts")
+			if err != nil {
+				return nil, err
+			}
+			videos, err := cfg.catalogVideos(r)
+			if err != nil {
+				return nil, err
+			}
+			if filterShorts {
+				videos = slices.DeleteFunc(videos, func(v database.Video) bool { return v.IsShort != shorts })
+			}
+			return page(videos, p.Args)
+		}},
+		"channel": {Type: channelType, Args: map[string]any{"id": nil}, Resolve: func(p graphql.Params) (any, error) {
+			id, err := catalogID(p.Args)
+			if err != nil {
+				return nil, err
+			}
+			return channels.Load(id), nil
+		}},
+		"stats": {Type: &graphql.Object{Name: "CatalogStats", Fields: map[string]*graphql.Field{
+			"videos":   {},
+			"shorts":   {},
+			"channels": {},
+		}}, Resolve: func(p graphql.Params) (any, error) {
+			videos, err := cfg.catalogVideos(r)
+			if err != nil {
+				return nil, err
+			}
+			s := catalogStats{Videos: len(videos)}
+			owners := map[uuid.UUID]bool{}
+			for _, video := range videos {
+				if video.IsShort {
+					s.Shorts++
+				}
+				owners[video.UserID] = true
+			}
+			s.Channels = len(owners)
+			return s, nil
+		}},
+	}}
+
+	return &graphql.Schema{Query: query}
+}
+
+// catalogVideos returns the videos listed for the viewer of r, newest
+// first.
+func (cfg *apiConfig) catalogVideos(r *http.Request) ([]database.Video, error) {
+	videos, err := cfg.db.GetAllVideos()
+	if err != nil {
+		return nil, err
+	}
+	videos = slices.DeleteFunc(videos, func(v database.Video) bool { return !cfg.canList(r, v) })
+	slices.Reverse(videos)
+	return videos, nil
+}
+
+func catalogID(args graphql.Args) (uuid.UUID, error) {
+	s, err := args.String("id")
+	if err != nil {
+		return uuid.Nil, err
+	}
+	id, err := uuid.Parse(s)
+	if err != nil {
+		return uuid.Nil, errors.New("invalid ID")
+	}
+	return id, nil
+}
+
+// page returns the slice of videos picked by the limit and offset
+// arguments.
+func page(videos []database.Video, args graphql.Args) ([]database.Video, error) {
+	limit, err := args.Int("limit")
+	if err != nil {
+		return nil, err
+	}
+	offset, err := args.Int("offset")
+	if err != nil {
+		return nil, err
+	}
+	if limit < 1 || limit > catalogMaxLimit {
+		return nil, fmt.Errorf("limit must be between 1 and %d", catalogMaxLimit)
+	}
+	if offset < 0 {
+		return nil, errors.New("offset can't be negative")
+	}
+	start := min(offset, len(videos))
+	return videos[start:min(start+limit, len(videos))], nil
+}
+
+// handlerGraphQL serves the catalog's GraphQL endpoint. Queries are sent as
+// a JSON POST body, or in the query string of a GET. Responses use the
+// GraphQL media type, which the /api/v1 envelope leaves alone.
+func (cfg *apiConfig) handlerGraphQL(w http.ResponseWriter, r *http.Request) {
+	req := graphql.Request{}
+	if r.Method == http.MethodGet {
+		req.Query = r.URL.Query().Get("query")
+		req.OperationName = r.URL.Query().Get("operationName")
+		if v := r.URL.Query().Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+				return
+			}
+		}
+	} else if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, graphQLMaxBody)).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	resp := cfg.catalogSchema(r).Execute(r.Context(), req)
+	dat, err := json.Marshal(resp)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error writing response", err)
+		return
+	}
+	status := http.StatusOK
+	if resp.Data == nil {
+		status = http.StatusBadRequest
+	}
+	w.Header().Set("Content-Type", "application/graphql-response+json; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(dat)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// dashboardTopUsers and dashboardRecentFailures size the dashboard's lists.
+const (
+	dashboardTopUsers       = 10
+	dashboardRecentFailures = 20
+)
+
+// uploadSources are the processing log sources that count as uploads.
+var uploadSources = map[string]bool{"upload": true, "bundle": true}
+
+type hourlyUploads struct {
+	Hour   time.Time `json:"hour"`
+	Total  int       `json:"total"`
+	Failed int       `json:"failed"`
+}
+
+type errorRate struct {
+	Source string  `json:"source"`
+	Total  int     `json:"total"`
+	Failed int     `json:"failed"`
+	Rate   float64 `json:"rate"`
+}
+
+type dashboardQueue struct {
+	Depth      int                 `json:"depth"`
+	InFlight   int                 `json:"in_flight"`
+	Admitted   int                 `json:"admitted"`
+	AdmitLimit int                 `json:"admit_limit"`
+	Limits     []concurrencyStatus `json:"limits"`
+}
+
+type dashboardStorage struct {
+	Videos int                   `json:"videos"`
+	Bytes  int64                 `json:"bytes"`
+	Growth []database.StorageDay `json:"growth"`
+	// Circuit is the state of the S3 circuit breaker, and RetryInSeconds
+	// how long until an open one tries S3 again.
+	Circuit        string `json:"circuit"`
+	RetryInSeconds int    `json:"retry_in_seconds,omitempty"`
+}
+
+type dashboard struct {
+	GeneratedAt         time.Time                    `json:"generated_at"`
+	WindowHours         int                          `json:"window_hours"`
+	Queue               dashboardQueue               `json:"queue"`
+	UploadsPerHour      []hourlyUploads              `json:"uploads_per_hour"`
+	ErrorRates          []errorRate                  `json:"error_rates"`
+	Storage             dashboardStorage             `json:"storage"`
+	TopUsersByStorage   []database.UserUsage         `json:"top_users_by_storage"`
+	TopUsersByBandwidth []database.UserUsage         `json:"top_users_by_bandwidth"`
+	RecentFailures      []database.ProcessingFailure `json:"recent_failures"`
+}
+
+// handlerAdminDashboard aggregates the state an ops dashboard shows. Counts
+// cover the last ?hours= hours, 24 by default and at most a week. Byte
+// counts are of processed video files and bandwidth is estimated from
+// playback events; see the database package for the details.
+func (cfg *apiConfig) handlerAdminDashboard(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+
+	hours := 24
+	if v := r.URL.Query().Get("hours"); v != "" {
+		var err error
+		hours, err = strconv.Atoi(v)
+		if err != nil || hours < 1 || hours > 168 {
+			respondWithError(w, http.StatusBadRequest, "hours must be between 1 and 168", err)
+			return
+		}
+	}
+	now := cfg.clock.Now().UTC()
+	since := now.Add(-time.Duration(hours) * time.Hour)
+
+	counts, err := cfg.db.GetProcessingCounts(since)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get dashboard stats", err)
+		return
+	}
+	videos, bytes, err := cfg.db.GetStorageTotals()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get dashboard stats", err)
+		return
+	}
+	growth, err := cfg.db.GetStorageGrowth(since)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get dashboard stats", err)
+		return
+	}
+	byStorage, err := cfg.db.GetTopUsersByStorage(dashboardTopUsers)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get dashboard stats", err)
+		return
+	}
+	byBandwidth, err := cfg.db.GetTopUsersByBandwidth(accessKindPlayback, since, dashboardTopUsers)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get dashboard stats", err)
+		return
+	}
+	failures, err := cfg.db.GetRecentProcessingFailures(dashboardRecentFailures)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get dashboard stats", err)
+		return
+	}
+
+	uploads := []hourlyUploads{}
+	rates := []errorRate{}
+	bySource := map[string]int{}
+	for _, c := range counts {
+		if uploadSources[c.Source] {
+			if n := len(uploads); n > 0 && uploads[n-1].Hour.Equal(c.Hour) {
+				uploads[n-1].Total += c.Total
+				uploads[n-1].Failed += c.Failed
+			} else {
+				uploads = append(uploads, hourlyUploads{Hour: c.Hour, Total: c.Total, Failed: c.Failed})
+			}
+		}
+		i, ok := bySource[c.Source]
+		if !ok {
+			i = len(rates)
+			bySource[c.Source] = i
+			rates = append(rates, errorRate{Source: c.Source})
+		}
+		rates[i].Total += c.Total
+		rates[i].Failed += c.Failed
+	}
+	for i := range rates {
+		rates[i].Rate = float64(rates[i].Failed) / float64(rates[i].Total)
+	}
+
+	circuit, retryIn := cfg.s3Resilience.State(now)
+	admitted, admitLimit := cfg.jobs.Admitted()
+	respondWithJSON(w, http.StatusOK, dashboard{
+		GeneratedAt: now,
+		WindowHours: hours,
+		Queue: dashboardQueue{
+			Depth:      cfg.jobs.Depth(),
+			InFlight:   cfg.jobs.InFlight(),
+			Admitted:   admitted,
+			AdmitLimit: admitLimit,
+			Limits:     concurrencyStatuses(cfg.uploadLimit, cfg.readLimit),
+		},
+		UploadsPerHour: uploads,
+		ErrorRates:     rates,
+		Storage: dashboardStorage{
+			Videos:         videos,
+			Bytes:          bytes,
+			Growth:         growth,
+			Circuit:        circuit,
+			RetryInSeconds: int(math.Ceil(retryIn.Seconds())),
+		},
+		TopUsersByStorage:   byStorage,
+		TopUsersByBandwidth: byBandwidth,
+		RecentFailures:      failures,
+	})
+}
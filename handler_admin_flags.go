@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/flags"
+	"github.com/google/uuid"
+)
+
+// refreshFlagOverrides reloads feature flag overrides from the database.
+func (cfg *apiConfig) refreshFlagOverrides() error {
+	rows, err := cfg.db.GetFeatureFlags()
+	if err != nil {
+		return err
+	}
+	overrides := make([]flags.Flag, 0, len(rows))
+	for _, row := range rows {
+		overrides = append(overrides, flags.Flag{
+			Name:    row.Name,
+			Enabled: row.Enabled,
+			Users:   row.Users,
+			Tenants: row.Tenants,
+			Percent: row.Percent,
+		})
+	}
+	cfg.flags.SetOverrides(overrides)
+	return nil
+}
+
+func (cfg *apiConfig) featureEnabled(name string, userID uuid.UUID, tenantID string) bool {
+	return cfg.flags.Enabled(name, flags.Subject{UserID: userID, Tenant: tenantID})
+}
+
+func (cfg *apiConfig) handlerFlagsList(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+	respondWithJSON(w, http.StatusOK, cfg.flags.All())
+}
+
+func (cfg *apiConfig) handlerFlagSet(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Enabled bool        `json:"enabled"`
+		Users   []uuid.UUID `json:"users"`
+		Tenants []string    `json:"tenants"`
+		Percent int         `json:"percent"`
+	}
+
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	flag := flags.Flag{
+		Name:    r.PathValue("name"),
+		Enabled: params.Enabled,
+		Users:   params.Users,
+		Tenants: params.Tenants,
+		Percent: params.Percent,
+	}
+	if err := flag.Validate(); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	err = cfg.db.UpsertFeatureFlag(database.FeatureFlag{
+		Name:    flag.Name,
+		Enabled: flag.Enabled,
+		Users:   flag.Users,
+		Tenants: flag.Tenants,
+		Percent: flag.Percent,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save flag", err)
+		return
+	}
+	if err := cfg.refreshFlagOverrides(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reload flags", err)
+		return
+	}
+
+	flag, _ = cfg.flags.Get(flag.Name)
+	respondWithJSON(w, http.StatusOK, flag)
+}
+
+func (cfg *apiConfig) handlerFlagDelete(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+
+	err := cfg.db.DeleteFeatureFlag(r.PathValue("name"))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete flag override", err)
+		return
+	}
+	if err := cfg.refreshFlagOverrides(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reload flags", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// handlerMigrateNamespacedKeys moves video objects stored under the old flat
+// {aspectRatio}/{random}.mp4 keys to {userID}/{videoID}/{artifact} and
+// rewrites the stored URLs. Videos under legal hold are skipped, since their
+// objects can't be moved. Pass ?dry_run=true to only report what would
+// change.
+func (cfg *apiConfig) handlerMigrateNamespacedKeys(w http.ResponseWriter, r *http.Request) {
+	type migratedKey struct {
+		VideoID string `json:"video_id"`
+		OldKey  string `json:"old_key"`
+		NewKey  string `json:"new_key"`
+		Error   string `json:"error,omitempty"`
+	}
+	type response struct {
+		DryRun   bool          `json:"dry_run"`
+		Migrated []migratedKey `json:"migrated"`
+		Failed   []migratedKey `json:"failed"`
+		Skipped  int           `json:"skipped"`
+	}
+
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+
+	videos, err := cfg.db.GetAllVideos()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		return
+	}
+
+	// The copies are new objects, so in a compliance bucket they need the
+	// retention uploads get.
+	target, err := cfg.tenants.Target(r.Context(), "")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't resolve storage for tenant", err)
+		return
+	}
+	if !requireS3(w, target) {
+		return
+	}
+
+	resp := response{DryRun: dryRun, Migrated: []migratedKey{}, Failed: []migratedKey{}}
+	for _, video := range videos {
+		if video.VideoURL == nil || video.LegalHold {
+			resp.Skipped++
+			continue
+		}
+		// Tenants' own buckets are left to them.
+		videoTarget, err := cfg.videoTarget(r.Context(), video)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't resolve storage for tenant", err)
+			return
+		}
+		oldKey, ok := storedObjectKey(target, *video.VideoURL)
+		if !ok || videoTarget.Bucket != target.Bucket || isNamespacedKey(oldKey, video.UserID, video.ID) || isContentKey(oldKey) {
+			resp.Skipped++
+			continue
+		}
+
+		result := migratedKey{
+			VideoID: video.ID.String(),
+			OldKey:  oldKey,
+			NewKey:  videoObjectKey(video.UserID, video.ID, path.Base(path.Dir(oldKey))+"-"+path.Base(oldKey)),
+		}
+		if dryRun {
+			resp.Migrated = append(resp.Migrated, result)
+			continue
+		}
+
+		input := &s3.CopyObjectInput{
+			Bucket:     aws.String(target.Bucket),
+			Key:        aws.String(result.NewKey),
+			CopySource: aws.String(s3CopySource(target.Bucket, oldKey)),
+		}
+		withCopyRetention(target.Retention)(input)
+		withCopyEncryption(target.Encryption)(input)
+		_, err = target.Client.CopyObject(r.Context(), input)
+		if err != nil {
+			result.Error = fmt.Sprintf("copy failed: %v", err)
+			resp.Failed = append(resp.Failed, result)
+			continue
+		}
+
+		video.VideoURL = &result.NewKey
+		if err := cfg.db.UpdateVideo(video); err != nil {
+			result.Error = fmt.Sprintf("db update failed: %v", err)
+			resp.Failed = append(resp.Failed, result)
+			continue
+		}
+
+		_, err = target.Client.DeleteObject(r.Context(), &s3.DeleteObjectInput{
+			Bucket: aws.String(target.Bucket),
+			Key:    aws.String(oldKey),
+		})
+		if err != nil {
+			result.Error = fmt.Sprintf("old object left behind: %v", err)
+		}
+		resp.Migrated = append(resp.Migrated, result)
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
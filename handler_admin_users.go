@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerAdminSetUserTenant(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		TenantID string `json:"tenant_id"`
+	}
+
+	adminID, ok := cfg.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.TenantID != "" && !cfg.tenants.Exists(params.TenantID) {
+		respondWithError(w, http.StatusBadRequest, "Unknown tenant", nil)
+		return
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+
+	err = cfg.db.SetUserTenant(userID, params.TenantID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update user", err)
+		return
+	}
+	cfg.recordActivity(userID, activityTenantChanged, nil, &adminID, params.TenantID)
+
+	user, err = cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, user)
+}
+
+// handlerAdminSetUserAgeVerified records the outcome of verifying a user's
+// age. It takes effect in the access tokens issued from the next login or
+// refresh.
+func (cfg *apiConfig) handlerAdminSetUserAgeVerified(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Verified bool `json:"verified"`
+	}
+
+	adminID, ok := cfg.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+
+	if err := cfg.db.SetUserAgeVerified(userID, params.Verified); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update user", err)
+		return
+	}
+	cfg.recordActivity(userID, activityAgeVerifiedChanged, nil, &adminID, strconv.FormatBool(params.Verified))
+
+	user, err = cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, user)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/google/uuid"
+)
+
+// languagePattern accepts ISO 639 codes with an optional region, e.g. "en",
+// "eng" or "pt-BR".
+var languagePattern = regexp.MustCompile(`^[a-z]{2,3}(-[A-Za-z0-9]{2,8})?$`)
+
+func (cfg *apiConfig) handlerAudioTracksGet(w http.ResponseWriter, r *http.Request) {
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil || !cfg.canView(r, video) {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+
+	tracks, err := cfg.db.GetAudioTracks(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get audio tracks", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, tracks)
+}
+
+func (cfg *apiConfig) handlerAudioTrackUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Language string `json:"language"`
+		Title    string `json:"title"`
+	}
+
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+	index, err := strconv.Atoi(r.PathValue("index"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid track index", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't update this video", nil)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Language != "" && !languagePattern.MatchString(params.Language) {
+		respondWithError(w, http.StatusBadRequest, "Language must be an ISO 639 code", nil)
+		return
+	}
+	params.Title, err = trackTitleLimit.apply(params.Title)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	tracks, err := cfg.db.GetAudioTracks(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get audio tracks", err)
+		return
+	}
+	if index < 0 || index >= len(tracks) {
+		respondWithError(w, http.StatusNotFound, "Audio track not found", nil)
+		return
+	}
+
+	err = cfg.db.UpdateAudioTrackLabels(videoID, index, params.Language, params.Title)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update audio track", err)
+		return
+	}
+	tracks[index].Language = params.Language
+	tracks[index].Title = params.Title
+	respondWithJSON(w, http.StatusOK, tracks[index])
+}
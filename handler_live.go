@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ffmpeg"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/fingerprint"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/live"
+	"github.com/google/uuid"
+)
+
+const eventLiveRecorded = "video.live_recorded"
+
+// finalizeLiveStream turns a finished live recording into a regular video by
+// running it through the same processing and storage steps as an upload.
+func (cfg *apiConfig) finalizeLiveStream(ctx context.Context, session live.Session, playlistPath string) (err error) {
+	plog := newProcessingLog(session.VideoID, "live")
+	ctx = plog.context(ctx)
+	defer func() {
+		failure := ""
+		if err != nil {
+			failure = err.Error()
+		}
+		cfg.saveProcessingLog(plog, failure)
+	}()
+
+	video, err := cfg.db.GetVideo(session.VideoID)
+	if err != nil {
+		return err
+	}
+	if video.ID == uuid.Nil {
+		return fmt.Errorf("video %s no longer exists", session.VideoID)
+	}
+	owner, err := cfg.db.GetUser(session.UserID)
+	if err != nil {
+		return err
+	}
+	tenantID := ""
+	if owner != nil {
+		tenantID = owner.TenantID
+	}
+	target, err := cfg.tenants.Target(ctx, tenantID)
+	if err != nil {
+		return err
+	}
+
+	recordingPath := filepath.Join(session.Dir(), "recording.mp4")
+	if _, err := ffmpeg.RemuxCommand(playlistPath, recordingPath).Run(ctx); err != nil {
+		return fmt.Errorf("couldn't remux recording: %w", err)
+	}
+
+	duration, err := cfg.getVideoDuration(ctx, recordingPath)
+	if err != nil {
+		return err
+	}
+	profile, _ := cfg.profiles.Get("")
+	var processedFilePath string
+	var peaks *ffmpeg.Peaks
+	var matches []fingerprint.Match
+	err = cfg.jobs.Run(video.ID, duration, func() error {
+		matches = cfg.checkFingerprints(ctx, recordingPath)
+		var err error
+		processedFilePath, err = processVideo(ctx, recordingPath, profile)
+		if err != nil {
+			return err
+		}
+		peaks, err = cfg.generatePeaks(ctx, processedFilePath)
+		return err
+	})
+	if processedFilePath != "" {
+		defer os.Remove(processedFilePath)
+	}
+	if err != nil {
+		return err
+	}
+
+	aspectRatio, err := cfg.getVideoAspectRatio(ctx, recordingPath)
+	if err != nil {
+		return err
+	}
+	audioTracks, err := cfg.getAudioTracks(ctx, processedFilePath)
+	if err != nil {
+		return err
+	}
+	colorInfo, err := cfg.getColorInfo(ctx, processedFilePath)
+	if err != nil {
+		return err
+	}
+	sphericalInfo, err := cfg.getSphericalInfo(ctx, processedFilePath)
+	if err != nil {
+		return err
+	}
+	metadata, err := cfg.getVideoMetadata(ctx, processedFilePath)
+	if err != nil {
+		return err
+	}
+	randomBytes := make([]byte, 16)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return err
+	}
+
+	cleanup := &cleanupStack{}
+	defer cleanup.run()
+
+	stored, err := cfg.storeVideoFile(ctx, cleanup, target, processedFilePath, "")
+	if err != nil {
+		return err
+	}
+
+	original := video
+	video.VideoURL = &stored.Key
+	video.SourceSHA256 = ""
+	video.VideoSHA256 = stored.SHA256
+	video.Encryption = stored.Encryption
+	video.AspectRatio = aspectRatio
+	video.IsShort = false
+	video.PreviewURL = nil
+	video.ColorInfo = colorInfo
+	video.SphericalInfo = sphericalInfo
+	video.Metadata = metadata
+	video.SDRVideoURL = nil
+	video.HLSURL = nil
+	if len(matches) > 0 {
+		video.ModerationHold = true
+	}
+	video.PeaksURL = nil
+	if peaks != nil {
+		peaksKey := videoObjectKey(video.UserID, video.ID, fmt.Sprintf("peaks-%x.json", randomBytes))
+		if err := uploadPeaks(ctx, target, peaksKey, peaks); err != nil {
+			return err
+		}
+		cleanup.deleteObject(target, peaksKey)
+		video.PeaksURL = &peaksKey
+	}
+	if err := cfg.captureMediaInfo(ctx, video.ID, recordingPath, processedFilePath); err != nil {
+		return err
+	}
+	// As with uploads, a recording is still published without a keyframe
+	// index.
+	if err := cfg.captureKeyframes(ctx, cleanup, video.ID, processedFilePath); err != nil {
+		log.Printf("Couldn't index keyframes for video %s: %v", video.ID, err)
+	}
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		return err
+	}
+	cleanup.restoreVideo(cfg.db, original)
+	if err := cfg.db.ReplaceAudioTracks(video.ID, audioTracks); err != nil {
+		return err
+	}
+	previousRenditions, err := cfg.db.GetRenditions(video.ID)
+	if err != nil {
+		return err
+	}
+	if err := cfg.db.ReplaceRenditions(video.ID, nil); err != nil {
+		return err
+	}
+	if err := cfg.db.ReplaceHLSRenditions(video.ID, nil); err != nil {
+		return err
+	}
+	cfg.deleteVideoFilesOnCommit(cleanup, target, original, previousRenditions)
+	cleanup.commit()
+	if len(matches) > 0 {
+		cfg.flagFingerprintMatches(video.ID, matches)
+	}
+	cfg.emitEvent(eventLiveRecorded, video.ID, map[string]any{"duration_seconds": duration})
+	return nil
+}
+
+func (cfg *apiConfig) handlerLiveStreamCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		database.CreateVideoParams
+	}
+	type response struct {
+		live.Session
+		Video       database.Video `json:"video"`
+		PlaylistURL string         `json:"playlist_url"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	params.UserID = userID
+	if err := normalizeVideoParams(&params.CreateVideoParams); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	video, err := cfg.db.CreateVideo(params.CreateVideoParams)
+	if errors.Is(err, database.ErrExternalIDTaken) {
+		respondWithError(w, http.StatusConflict, "External ID is already in use", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create video", err)
+		return
+	}
+
+	session, err := cfg.live.Start(userID, video.ID)
+	if errors.Is(err, live.ErrNoFreePorts) {
+		cfg.db.DeleteVideo(video.ID)
+		respondWithError(w, http.StatusServiceUnavailable, "No live ingest capacity available", err)
+		return
+	}
+	if err != nil {
+		cfg.db.DeleteVideo(video.ID)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't start live ingest", err)
+		return
+	}
+
+	video, err = cfg.dbVideoToSignedVideo(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URLs", err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, response{
+		Session:     session,
+		Video:       video,
+		PlaylistURL: fmt.Sprintf("/api/live/streams/%s/hls/%s", session.ID, live.PlaylistName),
+	})
+}
+
+// liveSessionForOwner loads the session in the path and checks that it
+// belongs to the authenticated user.
+func (cfg *apiConfig) liveSessionForOwner(w http.ResponseWriter, r *http.Request) (live.Session, bool) {
+	sessionID, err := uuid.Parse(r.PathValue("sessionID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return live.Session{}, false
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return live.Session{}, false
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return live.Session{}, false
+	}
+
+	session, err := cfg.live.Get(sessionID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Live session not found", err)
+		return live.Session{}, false
+	}
+	if session.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't access this live session", nil)
+		return live.Session{}, false
+	}
+	return session, true
+}
+
+func (cfg *apiConfig) handlerLiveStreamGet(w http.ResponseWriter, r *http.Request) {
+	session, ok := cfg.liveSessionForOwner(w, r)
+	if !ok {
+		return
+	}
+	respondWithJSON(w, http.StatusOK, session)
+}
+
+func (cfg *apiConfig) handlerLiveStreamStop(w http.ResponseWriter, r *http.Request) {
+	session, ok := cfg.liveSessionForOwner(w, r)
+	if !ok {
+		return
+	}
+	if err := cfg.live.Stop(session.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't stop live session", err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handlerLiveStreamHLS serves the live HLS playlist and segments while the
+// stream is in progress.
+func (cfg *apiConfig) handlerLiveStreamHLS(w http.ResponseWriter, r *http.Request) {
+	sessionID, err := uuid.Parse(r.PathValue("sessionID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+	session, err := cfg.live.Get(sessionID)
+	if err != nil || (session.Status != live.StatusLive && session.Status != live.StatusWaiting) {
+		respondWithError(w, http.StatusNotFound, "Live stream not found", err)
+		return
+	}
+
+	name := filepath.Base(r.PathValue("file"))
+	switch filepath.Ext(name) {
+	case ".m3u8":
+		msn := -1
+		if v := r.URL.Query().Get("_HLS_msn"); v != "" {
+			msn, err = strconv.Atoi(v)
+			if err != nil || msn < 0 {
+				respondWithError(w, http.StatusBadRequest, "Invalid _HLS_msn", err)
+				return
+			}
+		}
+		playlist, err := cfg.live.ReadPlaylist(r.Context(), session, msn)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, "Playlist not available yet", err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Write(playlist)
+		return
+	case ".ts":
+		w.Header().Set("Content-Type", "video/mp2t")
+	case ".m4s":
+		w.Header().Set("Content-Type", "video/iso.segment")
+	case ".mp4":
+		w.Header().Set("Content-Type", "video/mp4")
+	default:
+		respondWithError(w, http.StatusNotFound, "Not found", nil)
+		return
+	}
+	http.ServeFile(w, r, filepath.Join(session.Dir(), name))
+}
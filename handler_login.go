@@ -40,8 +40,8 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	accessToken, err := auth.MakeJWT(
-		user.ID,
+	accessToken, err := auth.MakeIdentityJWT(
+		auth.Identity{UserID: user.ID, Tenant: user.TenantID, AgeVerified: user.AgeVerified, Suspended: user.Suspended()},
 		cfg.jwtSecret,
 		time.Hour*24*30,
 	)
@@ -50,22 +50,12 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	refreshToken, err := auth.MakeRefreshToken()
+	refreshToken, err := cfg.issueRefreshToken(user.ID)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create refresh token", err)
 		return
 	}
 
-	_, err = cfg.db.CreateRefreshToken(database.CreateRefreshTokenParams{
-		UserID:    user.ID,
-		Token:     refreshToken,
-		ExpiresAt: time.Now().UTC().Add(time.Hour * 24 * 60),
-	})
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't save refresh token", err)
-		return
-	}
-
 	respondWithJSON(w, http.StatusOK, response{
 		User:         user,
 		Token:        accessToken,
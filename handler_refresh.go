@@ -1,15 +1,46 @@
 package main
 
 import (
+	"log"
 	"net/http"
 	"time"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/clock"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
 )
 
+// refreshTokenExpiresIn is how long a refresh token lasts unused. Each
+// refresh rotates it into a new one, so a client that keeps refreshing stays
+// signed in.
+const refreshTokenExpiresIn = 60 * 24 * time.Hour
+
+// issueRefreshToken creates and stores a refresh token for a user.
+func (cfg *apiConfig) issueRefreshToken(userID uuid.UUID) (string, error) {
+	refreshToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		return "", err
+	}
+	_, err = cfg.db.CreateRefreshToken(database.CreateRefreshTokenParams{
+		UserID:    userID,
+		Token:     refreshToken,
+		ExpiresAt: cfg.clock.Now().UTC().Add(refreshTokenExpiresIn),
+	})
+	if err != nil {
+		return "", err
+	}
+	return refreshToken, nil
+}
+
+// handlerRefresh exchanges a refresh token for a new access token and a new
+// refresh token, revoking the one presented. A refresh token that was
+// already rotated has leaked or been replayed, so presenting one revokes
+// every refresh token of its user.
 func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 	type response struct {
-		Token string `json:"token"`
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
 	}
 
 	refreshToken, err := auth.GetBearerToken(r.Header)
@@ -18,14 +49,63 @@ func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := cfg.db.GetUserByRefreshToken(refreshToken)
+	rt, err := cfg.db.GetRefreshToken(refreshToken)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get refresh token", err)
+		return
+	}
+	if rt.Token == "" {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't get user for refresh token", nil)
+		return
+	}
+	if rt.RevokedAt != nil {
+		if rt.ReplacedBy != nil {
+			log.Printf("Refresh token of user %s was reused after rotation; revoking all of the user's sessions", rt.UserID)
+			if err := cfg.db.RevokeUserRefreshTokens(rt.UserID); err != nil {
+				respondWithError(w, http.StatusInternalServerError, "Couldn't revoke session", err)
+				return
+			}
+		}
+		respondWithError(w, http.StatusUnauthorized, "Refresh token has been revoked", nil)
+		return
+	}
+	if clock.Expired(cfg.clock, rt.ExpiresAt) {
+		respondWithError(w, http.StatusUnauthorized, "Refresh token has expired", nil)
+		return
+	}
+
+	user, err := cfg.db.GetUser(rt.UserID)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't get user for refresh token", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't get user for refresh token", nil)
+		return
+	}
+
+	next, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create refresh token", err)
+		return
+	}
+	rotated, err := cfg.db.RotateRefreshToken(refreshToken, database.CreateRefreshTokenParams{
+		UserID:    user.ID,
+		Token:     next,
+		ExpiresAt: cfg.clock.Now().UTC().Add(refreshTokenExpiresIn),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save refresh token", err)
+		return
+	}
+	if !rotated {
+		// Another refresh with the same token got there first.
+		respondWithError(w, http.StatusUnauthorized, "Refresh token has been revoked", nil)
 		return
 	}
 
-	accessToken, err := auth.MakeJWT(
-		user.ID,
+	accessToken, err := auth.MakeIdentityJWT(
+		auth.Identity{UserID: user.ID, Tenant: user.TenantID, AgeVerified: user.AgeVerified, Suspended: user.Suspended()},
 		cfg.jwtSecret,
 		time.Hour,
 	)
@@ -35,7 +115,8 @@ func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 	}
 
 	respondWithJSON(w, http.StatusOK, response{
-		Token: accessToken,
+		Token:        accessToken,
+		RefreshToken: next,
 	})
 }
 
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerRenditionsGet(w http.ResponseWriter, r *http.Request) {
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	// The renditions' URLs play the video as well as its own does.
+	target, ok := cfg.requirePlayback(w, r, video)
+	if !ok {
+		return
+	}
+
+	renditions, err := cfg.db.GetRenditions(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get renditions", err)
+		return
+	}
+	for i := range renditions {
+		renditions[i].URL, err = cfg.signStoredURL(r.Context(), target, video, renditions[i].URL)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URLs", err)
+			return
+		}
+	}
+	respondWithJSON(w, http.StatusOK, renditions)
+}
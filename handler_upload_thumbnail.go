@@ -1,16 +1,26 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/md5"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"mime"
 	"net/http"
+	"net/textproto"
 	"os"
-	"path/filepath"
+	"strings"
 
-	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ffmpeg"
 	"github.com/google/uuid"
 )
 
@@ -22,108 +32,321 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
+	userID, _, ok := cfg.requireUploader(w, r)
+	if !ok {
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't find video", err)
+		return
+	}
+
+	if video.UserID != userID {
+		respondWithError(w, http.StatusUnauthorized, "Not authorized to update this video", nil)
+		return
+	}
+	if !requireNoLegalHold(w, video) {
 		return
 	}
 
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	// A client that already knows the hash of the file it's about to send
+	// can skip the upload when it's the current thumbnail. Checked before
+	// the body is read, so with Expect: 100-continue it's never sent.
+	if video.ThumbnailSHA256 != "" && etagMatches(r.Header.Get("If-None-Match"), video.ThumbnailSHA256) {
+		w.Header().Set("ETag", quoteETag(video.ThumbnailSHA256))
+		respondWithError(w, http.StatusPreconditionFailed, "Thumbnail is unchanged", nil)
+		return
+	}
+
+	cleanup := &cleanupStack{}
+	defer cleanup.run()
+
+	thumbnail, ok := cfg.saveThumbnailForm(w, r, cleanup)
+	if !ok {
+		return
+	}
+
+	// Re-uploading the current thumbnail keeps the existing file and its
+	// variants; the copy just written is removed when cleanup runs.
+	if thumbnail.SHA256 == video.ThumbnailSHA256 && video.ThumbnailURL != nil {
+		w.Header().Set("ETag", quoteETag(video.ThumbnailSHA256))
+		video, err = cfg.dbVideoToSignedVideo(r.Context(), video)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URLs", err)
+			return
+		}
+		respondWithJSON(w, http.StatusOK, video)
+		return
+	}
+
+	if !cfg.requireStorageQuota(w, video.UserID, videoID, database.StorageKindThumbnail, thumbnail.Size) {
+		return
+	}
+	if !cfg.recordStorageUsage(w, cleanup, video.UserID, videoID, database.StorageKindThumbnail, thumbnail.Size) {
+		return
+	}
+	telemetry.uploadBytes.Add(float64(thumbnail.Size), "thumbnail", "upload")
+	cfg.invalidateOnCommit(cleanup, videoID, "thumbnail", cfg.replacedThumbnailPaths(video))
+	cfg.deleteThumbnailOnCommit(cleanup, video)
+	video.ThumbnailURL = &thumbnail.URL
+	video.ThumbnailSHA256 = thumbnail.SHA256
+
+	err = cfg.db.UpdateVideo(video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+	cfg.emitEvent(eventVideoUpdated, videoID, nil)
+	cfg.emitEvent(eventThumbnailUpdated, videoID, map[string]any{"source": "upload"})
+
+	cleanup.commit()
+
+	// A failed variant doesn't fail the upload: the original is usable on
+	// its own, and an admin regeneration job can fill the variants in.
+	if cfg.thumbnails.enabled() {
+		if _, err := cfg.generateThumbnailVariants(r.Context(), videoID, thumbnail.Name); err != nil {
+			log.Printf("Couldn't generate thumbnail variants for video %s: %v", videoID, err)
+		}
+	}
+	w.Header().Set("ETag", quoteETag(video.ThumbnailSHA256))
+	video, err = cfg.dbVideoToSignedVideo(r.Context(), video)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URLs", err)
 		return
 	}
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+// savedThumbnail is a thumbnail saved as an asset named Name. SHA256 is the
+// hex digest of the bytes that were uploaded and Size their count, which
+// for HEIC are of the original rather than the converted JPEG.
+type savedThumbnail struct {
+	Name   string
+	URL    string
+	SHA256 string
+	Size   int64
+}
+
+// byteCounter counts the bytes written to it.
+type byteCounter int64
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	*c += byteCounter(len(p))
+	return len(p), nil
+}
+
+// thumbnailChecksums are the digests a client expects its upload to have.
+// A nil digest isn't checked.
+type thumbnailChecksums struct {
+	MD5    []byte
+	SHA256 []byte
+}
+
+// parseThumbnailChecksums reads the checksums a multipart thumbnail upload
+// declares: a base64 Content-MD5 header on the part, as in RFC 1864, and a
+// checksum_sha256 form field in hex or base64.
+func parseThumbnailChecksums(header textproto.MIMEHeader, sha string) (thumbnailChecksums, error) {
+	var want thumbnailChecksums
+	if v := header.Get("Content-MD5"); v != "" {
+		sum, err := base64.StdEncoding.DecodeString(v)
+		if err != nil || len(sum) != md5.Size {
+			return want, fmt.Errorf("invalid Content-MD5 %q", v)
+		}
+		want.MD5 = sum
+	}
+	if sha != "" {
+		sum, err := hex.DecodeString(sha)
+		if err != nil {
+			sum, err = base64.StdEncoding.DecodeString(sha)
+		}
+		if err != nil || len(sum) != sha256.Size {
+			return want, fmt.Errorf("invalid checksum_sha256 %q", sha)
+		}
+		want.SHA256 = sum
+	}
+	return want, nil
+}
+
+// quoteETag formats a thumbnail hash as a strong entity tag.
+func quoteETag(sha string) string {
+	return `"` + sha + `"`
+}
+
+// etagMatches reports whether an If-None-Match header lists sha, comparing
+// weakly as RFC 9110 requires for If-None-Match.
+func etagMatches(header, sha string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == quoteETag(sha) {
+			return true
+		}
+	}
+	return false
+}
 
+// saveThumbnailForm saves the "thumbnail" file of a multipart request with
+// saveThumbnail, verifying any checksums the request declares.
+func (cfg *apiConfig) saveThumbnailForm(w http.ResponseWriter, r *http.Request, cleanup *cleanupStack) (savedThumbnail, bool) {
 	const maxMemory = 10 << 20 // 10 MB
-	err = r.ParseMultipartForm(maxMemory)
+	err := r.ParseMultipartForm(maxMemory)
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, "Error parsing form data", err)
-		return
+		return savedThumbnail{}, false
 	}
 
 	file, header, err := r.FormFile("thumbnail")
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, "Unable to parse form file", err)
-		return
+		return savedThumbnail{}, false
 	}
 	defer file.Close()
 
 	contentType := header.Header.Get("Content-Type")
 	if contentType == "" {
 		respondWithError(w, http.StatusBadRequest, "Missing Content-Type for thumbnail", nil)
-		return
+		return savedThumbnail{}, false
 	}
+	want, err := parseThumbnailChecksums(header.Header, r.FormValue("checksum_sha256"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid thumbnail checksum", err)
+		return savedThumbnail{}, false
+	}
+	return cfg.saveThumbnail(w, r, file, contentType, want, cleanup)
+}
 
+// saveThumbnail validates an uploaded thumbnail image, checking that its
+// content is of the declared type, and saves it as an asset under a random
+// name, converting HEIC to JPEG. The file
+// is removed again if cleanup runs without being committed, including when
+// the upload doesn't match want. If ok is false, an error response has been
+// written.
+func (cfg *apiConfig) saveThumbnail(w http.ResponseWriter, r *http.Request, file io.Reader, contentType string, want thumbnailChecksums, cleanup *cleanupStack) (savedThumbnail, bool) {
 	// Parse the media type
 	mediaType, _, err := mime.ParseMediaType(contentType)
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, "Invalid Content-Type format", err)
-		return
+		return savedThumbnail{}, false
 	}
 
 	// Validate allowed media types
-	if mediaType != "image/jpeg" && mediaType != "image/png" {
-		respondWithError(w, http.StatusBadRequest, "Unsupported file type. Only JPEG and PNG are allowed.", nil)
-		return
+	if mediaType != "image/jpeg" && mediaType != "image/png" && !heicTypes[mediaType] {
+		respondWithError(w, http.StatusBadRequest, "Unsupported file type. Only JPEG, PNG and HEIC are allowed.", nil)
+		return savedThumbnail{}, false
 	}
 
-	// Extract file extension based on media type
+	// Extract file extension based on media type. HEIC is converted to
+	// JPEG, since most browsers can't display it.
 	extension := ""
-	switch mediaType {
-	case "image/jpeg":
+	switch {
+	case mediaType == "image/jpeg", heicTypes[mediaType]:
 		extension = ".jpg"
-	case "image/png":
+	case mediaType == "image/png":
 		extension = ".png"
 	}
 
+	// Check the content is what the client says it is
+	buffered := bufio.NewReaderSize(file, sniffLen)
+	head, err := buffered.Peek(sniffLen)
+	if err != nil && !errors.Is(err, io.EOF) {
+		respondWithError(w, http.StatusBadRequest, "Unable to parse form file", err)
+		return savedThumbnail{}, false
+	}
+	if !sniffImage(head, mediaType) {
+		respondWithError(w, http.StatusBadRequest, "File content doesn't match its declared type", nil)
+		return savedThumbnail{}, false
+	}
+	file = buffered
+
 	// Generate a random file name
 	randomBytes := make([]byte, 32)
 	_, err = rand.Read(randomBytes)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Failed to generate random filename", err)
-		return
+		return savedThumbnail{}, false
 	}
 	randomFileName := base64.RawURLEncoding.EncodeToString(randomBytes)
 
-	// Construct the file path
-	filePath := filepath.Join(cfg.assetsRoot, fmt.Sprintf("%s%s", randomFileName, extension))
+	// Hash the upload as it's written
+	shaHash, md5Hash := sha256.New(), md5.New()
+	var size byteCounter
+	file = io.TeeReader(file, io.MultiWriter(shaHash, md5Hash, &size))
 
-	// Create the file on the filesystem
-	outFile, err := os.Create(filePath)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to create file on disk", err)
-		return
+	// Construct the object key
+	key := fmt.Sprintf("%s%s", randomFileName, extension)
+
+	if heicTypes[mediaType] {
+		if !cfg.convertHEIC(w, r, file, key, cleanup) {
+			return savedThumbnail{}, false
+		}
+	} else {
+		// HEIC is verified by converting it; the rest is decoded as it's
+		// stored.
+		checked, check := checkImage(file, strings.TrimPrefix(mediaType, "image/"))
+		store := cfg.assetStore()
+		cleanup.onError("delete asset "+key, func() error { return store.Delete(context.Background(), key) })
+		err := cfg.putAsset(r.Context(), store, key, checked, mediaType)
+		decodeErr := check.wait()
+		if err != nil {
+			respondWithStorageError(w, http.StatusInternalServerError, "Failed to save file to disk", err)
+			return savedThumbnail{}, false
+		}
+		if decodeErr != nil {
+			respondWithError(w, http.StatusBadRequest, "Thumbnail isn't a valid image", decodeErr)
+			return savedThumbnail{}, false
+		}
 	}
-	defer outFile.Close()
 
-	// Copy the file data to the new file
-	_, err = io.Copy(outFile, file)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to save file to disk", err)
-		return
+	shaSum, md5Sum := shaHash.Sum(nil), md5Hash.Sum(nil)
+	if (want.MD5 != nil && !bytes.Equal(want.MD5, md5Sum)) || (want.SHA256 != nil && !bytes.Equal(want.SHA256, shaSum)) {
+		respondWithError(w, http.StatusBadRequest, "Thumbnail checksum mismatch", nil)
+		return savedThumbnail{}, false
 	}
 
-	// Construct the thumbnail URL
-	thumbnailURL := fmt.Sprintf("http://localhost:%s/assets/%s%s", cfg.port, randomFileName, extension)
+	return savedThumbnail{Name: key, URL: cfg.assetURL(key), SHA256: hex.EncodeToString(shaSum), Size: int64(size)}, true
+}
 
-	video, err := cfg.db.GetVideo(videoID)
+// heicTypes are the media types iPhones and other phones upload photos as.
+var heicTypes = map[string]bool{
+	"image/heic": true,
+	"image/heif": true,
+}
+
+// convertHEIC decodes a HEIC upload and stores it under key as a JPEG. If it
+// returns false, an error response has been written.
+func (cfg *apiConfig) convertHEIC(w http.ResponseWriter, r *http.Request, file io.Reader, key string, cleanup *cleanupStack) bool {
+	tempFile, err := os.CreateTemp("", "tubely-thumbnail-*.heic")
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't find video", err)
-		return
+		respondWithError(w, http.StatusInternalServerError, "Failed to create temporary file", err)
+		return false
 	}
+	defer os.Remove(tempFile.Name())
+	defer tempFile.Close()
 
-	if video.UserID != userID {
-		respondWithError(w, http.StatusUnauthorized, "Not authorized to update this video", nil)
-		return
+	if _, err := io.Copy(tempFile, file); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to save file to disk", err)
+		return false
 	}
 
-	video.ThumbnailURL = &thumbnailURL
-
-	err = cfg.db.UpdateVideo(video)
+	jpegPath := strings.TrimSuffix(tempFile.Name(), ".heic") + ".jpg"
+	defer os.Remove(jpegPath)
+	if _, err := ffmpeg.ConvertImageCommand(tempFile.Name(), jpegPath, ffmpeg.ImageJPEG).Run(r.Context()); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't convert HEIC thumbnail", err)
+		return false
+	}
+	jpeg, err := os.Open(jpegPath)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
-		return
+		respondWithError(w, http.StatusInternalServerError, "Failed to save file to disk", err)
+		return false
 	}
+	defer jpeg.Close()
 
-	respondWithJSON(w, http.StatusOK, video)
+	store := cfg.assetStore()
+	cleanup.onError("delete asset "+key, func() error { return store.Delete(context.Background(), key) })
+	if err := cfg.putAsset(r.Context(), store, key, jpeg, "image/jpeg"); err != nil {
+		respondWithStorageError(w, http.StatusInternalServerError, "Failed to save file to disk", err)
+		return false
+	}
+	return true
 }
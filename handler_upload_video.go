@@ -4,48 +4,121 @@ import (
 	"bytes"
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"math"
 	"mime"
 	"net/http"
 	"os"
-	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ffmpeg"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/fingerprint"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/i18n"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/scan"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tenants"
 	"github.com/google/uuid"
 )
 
+type streamTags struct {
+	Language string `json:"language"`
+	Title    string `json:"title"`
+	// Rotate is the clockwise display rotation older muxers tag video
+	// streams with, in degrees.
+	Rotate string `json:"rotate"`
+}
+
+type streamDisposition struct {
+	Default     int `json:"default"`
+	AttachedPic int `json:"attached_pic"`
+}
+
+// streamSideData carries the spherical mapping and stereo 3D side data that
+// ffprobe reports for 360° video, and the display matrix of rotated video,
+// whose Rotation is counterclockwise.
+type streamSideData struct {
+	SideDataType string  `json:"side_data_type"`
+	Projection   string  `json:"projection"`
+	Type         string  `json:"type"`
+	Rotation     float64 `json:"rotation"`
+}
+
 type videoStream struct {
-	Width  int `json:"width"`
-	Height int `json:"height"`
+	Index            int               `json:"index"`
+	CodecType        string            `json:"codec_type"`
+	CodecName        string            `json:"codec_name"`
+	Profile          string            `json:"profile"`
+	Width            int               `json:"width"`
+	Height           int               `json:"height"`
+	AvgFrameRate     string            `json:"avg_frame_rate"`
+	Channels         int               `json:"channels"`
+	SampleRate       string            `json:"sample_rate"`
+	PixFmt           string            `json:"pix_fmt"`
+	BitsPerRawSample string            `json:"bits_per_raw_sample"`
+	ColorPrimaries   string            `json:"color_primaries"`
+	ColorTransfer    string            `json:"color_transfer"`
+	ColorSpace       string            `json:"color_space"`
+	Tags             streamTags        `json:"tags"`
+	Disposition      streamDisposition `json:"disposition"`
+	SideDataList     []streamSideData  `json:"side_data_list"`
+}
+
+type videoFormat struct {
+	FormatName string `json:"format_name"`
+	Duration   string `json:"duration"`
+	Size       string `json:"size"`
+	BitRate    string `json:"bit_rate"`
 }
 
 type ffprobeOutput struct {
 	Streams []videoStream `json:"streams"`
+	Format  videoFormat   `json:"format"`
 }
 
-func getVideoAspectRatio(filePath string) (string, error) {
-	cmd := exec.Command("ffprobe", "-v", "error", "-print_format", "json", "-show_streams", filePath)
-	var out bytes.Buffer
-	cmd.Stdout = &out
-	if err := cmd.Run(); err != nil {
-		return "", err
+func (cfg *apiConfig) probeVideo(ctx context.Context, filePath string) (ffprobeOutput, error) {
+	out, err := cfg.prober.Probe(ctx, filePath)
+	if err != nil {
+		return ffprobeOutput{}, err
 	}
 
 	var probeOutput ffprobeOutput
-	if err := json.Unmarshal(out.Bytes(), &probeOutput); err != nil {
+	if err := json.Unmarshal(out, &probeOutput); err != nil {
+		return ffprobeOutput{}, err
+	}
+	return probeOutput, nil
+}
+
+func (p ffprobeOutput) firstStream(codecType string) (videoStream, bool) {
+	for _, stream := range p.Streams {
+		if stream.CodecType == codecType {
+			return stream, true
+		}
+	}
+	return videoStream{}, false
+}
+
+func (cfg *apiConfig) getVideoAspectRatio(ctx context.Context, filePath string) (string, error) {
+	probeOutput, err := cfg.probeVideo(ctx, filePath)
+	if err != nil {
 		return "", err
 	}
 
-	if len(probeOutput.Streams) == 0 {
+	stream, ok := probeOutput.firstStream("video")
+	if !ok || stream.Height == 0 {
 		return "other", nil
 	}
 
-	width := float64(probeOutput.Streams[0].Width)
-	height := float64(probeOutput.Streams[0].Height)
+	width := float64(stream.Width)
+	height := float64(stream.Height)
 
 	// Calculate aspect ratio with a small tolerance for floating-point comparison
 	const tolerance = 0.01
@@ -66,19 +139,304 @@ func getVideoAspectRatio(filePath string) (string, error) {
 	return "other", nil
 }
 
-func processVideoForFastStart(filePath string) (string, error) {
+func (cfg *apiConfig) getVideoDuration(ctx context.Context, filePath string) (float64, error) {
+	probeOutput, err := cfg.probeVideo(ctx, filePath)
+	if err != nil {
+		return 0, err
+	}
+
+	if probeOutput.Format.Duration == "" {
+		return 0, nil
+	}
+	return strconv.ParseFloat(probeOutput.Format.Duration, 64)
+}
+
+// getAudioTracks lists the audio streams of the file in output order, i.e.
+// the order they are mapped by the transcode profiles.
+func (cfg *apiConfig) getAudioTracks(ctx context.Context, filePath string) ([]database.AudioTrack, error) {
+	probeOutput, err := cfg.probeVideo(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+	return probeOutput.audioTracks(), nil
+}
+
+// audioTracks lists the probed audio streams in order; a track's Index is
+// its position among them, as in the -map 0:a:N stream specifier.
+func (p ffprobeOutput) audioTracks() []database.AudioTrack {
+	tracks := []database.AudioTrack{}
+	for _, stream := range p.Streams {
+		if stream.CodecType != "audio" {
+			continue
+		}
+		tracks = append(tracks, database.AudioTrack{
+			Index:     len(tracks),
+			Language:  stream.Tags.Language,
+			Title:     stream.Tags.Title,
+			Codec:     stream.CodecName,
+			Channels:  stream.Channels,
+			IsDefault: stream.Disposition.Default == 1,
+		})
+	}
+	return tracks
+}
+
+// getColorInfo reads the color characteristics of the first video stream.
+// PQ (SMPTE ST 2084) sources are reported as hdr10 and ARIB STD-B67 sources
+// as hlg; everything else is treated as SDR.
+func (cfg *apiConfig) getColorInfo(ctx context.Context, filePath string) (database.ColorInfo, error) {
+	probeOutput, err := cfg.probeVideo(ctx, filePath)
+	if err != nil {
+		return database.ColorInfo{}, err
+	}
+
+	info := database.ColorInfo{DynamicRange: "sdr", BitDepth: 8}
+	stream, ok := probeOutput.firstStream("video")
+	if !ok {
+		return info, nil
+	}
+	info.ColorPrimaries = stream.ColorPrimaries
+	info.ColorTransfer = stream.ColorTransfer
+	info.ColorSpace = stream.ColorSpace
+
+	switch stream.ColorTransfer {
+	case "smpte2084":
+		info.DynamicRange = "hdr10"
+	case "arib-std-b67":
+		info.DynamicRange = "hlg"
+	}
+
+	if bits, err := strconv.Atoi(stream.BitsPerRawSample); err == nil && bits > 0 {
+		info.BitDepth = bits
+	} else if m := pixFmtDepthPattern.FindStringSubmatch(stream.PixFmt); m != nil {
+		info.BitDepth, _ = strconv.Atoi(m[1])
+	}
+	return info, nil
+}
+
+// getSphericalInfo reports whether the first video stream carries spherical
+// (360°) metadata, and its projection and stereo layout if so.
+func (cfg *apiConfig) getSphericalInfo(ctx context.Context, filePath string) (database.SphericalInfo, error) {
+	probeOutput, err := cfg.probeVideo(ctx, filePath)
+	if err != nil {
+		return database.SphericalInfo{}, err
+	}
+
+	info := database.SphericalInfo{}
+	stream, ok := probeOutput.firstStream("video")
+	if !ok {
+		return info, nil
+	}
+	for _, sd := range stream.SideDataList {
+		switch sd.SideDataType {
+		case "Spherical Mapping":
+			info.Spherical = true
+			info.Projection = sd.Projection
+		case "Stereo 3D":
+			info.StereoMode = sd.Type
+		}
+	}
+	if !info.Spherical {
+		info.StereoMode = ""
+	}
+	return info, nil
+}
+
+// getVideoMetadata describes the file for the video record. Fields ffprobe
+// leaves out or can't measure are left zero.
+func (cfg *apiConfig) getVideoMetadata(ctx context.Context, filePath string) (*database.VideoMetadata, error) {
+	probeOutput, err := cfg.probeVideo(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	format := probeOutput.Format
+	metadata := &database.VideoMetadata{Container: format.FormatName}
+	metadata.DurationSeconds, _ = strconv.ParseFloat(format.Duration, 64)
+	metadata.SizeBytes, _ = strconv.ParseInt(format.Size, 10, 64)
+	metadata.BitRate, _ = strconv.ParseInt(format.BitRate, 10, 64)
+	if stream, ok := probeOutput.firstStream("video"); ok {
+		metadata.VideoCodec = stream.CodecName
+		metadata.VideoProfile = stream.Profile
+		metadata.Width = stream.Width
+		metadata.Height = stream.Height
+		metadata.FrameRate = parseFrameRate(stream.AvgFrameRate)
+		metadata.Rotation = streamRotation(stream)
+	}
+	if stream, ok := probeOutput.firstStream("audio"); ok {
+		metadata.AudioCodec = stream.CodecName
+		metadata.AudioChannels = stream.Channels
+		metadata.AudioSampleRate, _ = strconv.Atoi(stream.SampleRate)
+	}
+	return metadata, nil
+}
+
+// parseFrameRate parses ffprobe's rational frame rates, such as
+// "30000/1001", rounded to the thousandth. "0/0", which ffprobe reports
+// when it can't tell, is 0.
+func parseFrameRate(s string) float64 {
+	num, den, ok := strings.Cut(s, "/")
+	if !ok {
+		den = "1"
+	}
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0
+	}
+	d, err := strconv.ParseFloat(den, 64)
+	if err != nil || d == 0 {
+		return 0
+	}
+	return math.Round(n/d*1000) / 1000
+}
+
+// streamRotation returns the clockwise display rotation of the stream,
+// from its display matrix or else its rotate tag, between 0 and 359.
+func streamRotation(stream videoStream) int {
+	degrees := 0
+	if v, err := strconv.Atoi(stream.Tags.Rotate); err == nil {
+		degrees = v
+	}
+	for _, sd := range stream.SideDataList {
+		if sd.SideDataType == "Display Matrix" {
+			degrees = -int(math.Round(sd.Rotation))
+		}
+	}
+	return (degrees%360 + 360) % 360
+}
+
+// pixFmtDepthPattern matches the bit depth suffix of pixel formats such as
+// yuv420p10le or p010le.
+var pixFmtDepthPattern = regexp.MustCompile(`p0?(\d{2})(le|be)$`)
+
+// needsSDRRendition reports whether players without HDR or 10-bit decoding
+// need a separate 8-bit SDR copy of the video.
+func needsSDRRendition(info database.ColorInfo) bool {
+	return info.DynamicRange != "sdr" || info.BitDepth > 8
+}
+
+func createSDRRendition(ctx context.Context, filePath string, info database.ColorInfo) (string, error) {
+	outputFilePath := filePath + ".sdr"
+	cmd := ffmpeg.ToneMapCommand(filePath, outputFilePath, info.DynamicRange != "sdr")
+	if _, err := cmd.Run(ctx); err != nil {
+		os.Remove(outputFilePath)
+		return "", err
+	}
+	return outputFilePath, nil
+}
+
+// generatePeaks summarises the first audio track of the file into waveform
+// peaks. It returns nil if the file has no audio.
+func (cfg *apiConfig) generatePeaks(ctx context.Context, filePath string) (*ffmpeg.Peaks, error) {
+	probeOutput, err := cfg.probeVideo(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := probeOutput.firstStream("audio"); !ok {
+		return nil, nil
+	}
+	peaks, err := ffmpeg.GeneratePeaks(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+	return &peaks, nil
+}
+
+// uploadPeaks stores peaks as JSON under key.
+func uploadPeaks(ctx context.Context, target tenants.Target, key string, peaks *ffmpeg.Peaks) error {
+	dat, err := json.Marshal(peaks)
+	if err != nil {
+		return err
+	}
+	return putObject(ctx, target, key, bytes.NewReader(dat), "application/json")
+}
+
+func processVideo(ctx context.Context, filePath string, profile ffmpeg.Profile) (string, error) {
 	outputFilePath := filePath + ".processed"
-	cmd := exec.Command("ffmpeg", "-i", filePath, "-c", "copy", "-movflags", "faststart", "-f", "mp4", outputFilePath)
-	if err := cmd.Run(); err != nil {
+	if _, err := profile.Command(filePath, outputFilePath).Run(ctx); err != nil {
+		os.Remove(outputFilePath)
 		return "", err
 	}
 	return outputFilePath, nil
 }
 
-func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request) {
-	const maxUploadSize = 1 << 30 // 1 GB
-	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+// maxUploadSize is the largest video that can be uploaded in one request.
+const maxUploadSize = 1 << 30 // 1 GB
+
+// uploadFormOverhead is the room a video upload's body is allowed beyond
+// the file, for the multipart boundaries and the form's other fields, when
+// the storage quota caps the file.
+const uploadFormOverhead = 1 << 20 // 1 MB
 
+// bodyLimit is the most an upload's body may be, and the message a larger
+// one is turned away with.
+type bodyLimit struct {
+	bytes   int64
+	message string
+}
+
+// videoUploadLimit is the limit for a video upload to videoID:
+// maxUploadSize, or less if the user's storage quota has less room left.
+func (cfg *apiConfig) videoUploadLimit(userID, videoID uuid.UUID) (bodyLimit, error) {
+	limit := bodyLimit{bytes: maxUploadSize, message: "Upload is too large"}
+	quota, used, replaced, err := cfg.quotaUsage(userID, videoID, database.StorageKindVideo)
+	if err != nil || quota == 0 {
+		return limit, err
+	}
+	if room := max(quota-used+replaced, 0) + uploadFormOverhead; room < limit.bytes {
+		limit = bodyLimit{bytes: room, message: storageQuotaExceededMessage}
+	}
+	return limit, nil
+}
+
+// limitUploadBody caps r's body at the limit. A request that declares a
+// larger Content-Length is turned away before any of it is read, and one
+// without a Content-Length fails as soon as it goes over, with a read error
+// that respondIfTooLarge reports. If ok is false, an error response has
+// been written.
+func limitUploadBody(w http.ResponseWriter, r *http.Request, limit bodyLimit) (ok bool) {
+	if r.ContentLength > limit.bytes {
+		respondUploadTooLarge(w, limit)
+		return false
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, limit.bytes)
+	return true
+}
+
+// respondIfTooLarge responds with a 413 if err is from reading past the
+// limit of limitUploadBody. It reports whether it did.
+func respondIfTooLarge(w http.ResponseWriter, err error, limit bodyLimit) bool {
+	var tooLarge *http.MaxBytesError
+	if !errors.As(err, &tooLarge) {
+		return false
+	}
+	respondUploadTooLarge(w, limit)
+	return true
+}
+
+// respondUploadTooLarge rejects an upload over the limit, saying what the
+// limit is in limit_bytes.
+func respondUploadTooLarge(w http.ResponseWriter, limit bodyLimit) {
+	type response struct {
+		Error      string `json:"error"`
+		Code       string `json:"code"`
+		LimitBytes int64  `json:"limit_bytes"`
+	}
+	lang := w.Header().Get("Content-Language")
+	if lang == "" {
+		lang = i18n.Default
+	}
+	respondWithJSON(w, http.StatusRequestEntityTooLarge, response{
+		Error:      i18n.Translate(lang, limit.message),
+		Code:       i18n.Code(limit.message, http.StatusRequestEntityTooLarge),
+		LimitBytes: limit.bytes,
+	})
+}
+
+// handlerUploadVideo accepts a video file and queues it for processing,
+// responding with the video marked pending. Processing replaces the video's
+// file once it succeeds.
+func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request) {
 	videoIDString := r.PathValue("videoID")
 	videoID, err := uuid.Parse(videoIDString)
 	if err != nil {
@@ -86,15 +444,14 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+	userID, tenantID, ok := cfg.requireUploader(w, r)
+	if !ok {
 		return
 	}
 
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	target, err := cfg.tenants.Target(r.Context(), tenantID)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't resolve storage for tenant", err)
 		return
 	}
 
@@ -108,89 +465,663 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 		respondWithError(w, http.StatusUnauthorized, "Not authorized to upload for this video", nil)
 		return
 	}
+	if !requireNoLegalHold(w, video) {
+		return
+	}
+	if !cfg.requireUnlockedVideoFile(w, r, video) {
+		return
+	}
+
+	if !requireNotProcessing(w, video) {
+		return
+	}
+	limit, err := cfg.videoUploadLimit(userID, videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get storage usage", err)
+		return
+	}
+	if !limitUploadBody(w, r, limit) {
+		return
+	}
+	release, ok := cfg.admitUpload(w)
+	if !ok {
+		return
+	}
+
+	// The upload ID lets the client follow the upload's progress. A client
+	// that wants to follow it from the first byte picks the ID itself.
+	uploadID := uuid.Nil
+	if v := r.URL.Query().Get("upload_id"); v != "" {
+		if uploadID, err = uuid.Parse(v); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid upload ID", err)
+			return
+		}
+	}
+	progress, ok := cfg.uploadProgress.start(videoID, userID, uploadID, cfg.clock)
+	if !ok {
+		release()
+		respondWithError(w, http.StatusConflict, "Upload ID is already in use", nil)
+		return
+	}
+	w.Header().Set("Upload-ID", progress.id().String())
+	progress.stage(uploadReceiving, max(r.ContentLength, 0))
+	r.Body = progress.body(r.Body)
+
+	// Once the job is queued, it saves the processing log.
+	plog := newProcessingLog(videoID, "upload")
+	plog.release = release
+	rec := &errorRecorder{ResponseWriter: w}
+	w = rec
+	r = r.WithContext(plog.context(r.Context()))
+	queued := false
+	defer func() {
+		if !queued {
+			cfg.saveProcessingLog(plog, rec.failure())
+			progress.finish(rec.failure())
+		}
+	}()
+
+	cleanup := &cleanupStack{}
+	defer cleanup.run()
 
-	file, header, err := r.FormFile("video")
+	// The file is spooled as it's received, and checked along with the
+	// rest of the form once it's all in, wherever the client put the
+	// fields.
+	if err := os.MkdirAll(cfg.uploadSpoolDir, 0700); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to create temporary file", err)
+		return
+	}
+	raw, err := os.CreateTemp(cfg.uploadSpoolDir, videoID.String()+"-"+rawUploadPattern)
 	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to create temporary file", err)
+		return
+	}
+	cleanup.onError("remove "+raw.Name(), func() error { return os.Remove(raw.Name()) })
+	stopRateWatch := cfg.watchUploadRate(w, r)
+	part, form, err := streamMultipartForm(r, "video", cfg.chaos.SlowWriter(raw))
+	stopRateWatch()
+	if closeErr := raw.Close(); err == nil {
+		err = closeErr
+	}
+	var writeErr *formWriteError
+	switch {
+	case err == nil:
+	case respondIfTooLarge(w, err, limit), respondIfTooSlow(w, err):
+		return
+	case errors.As(err, &writeErr):
+		respondWithError(w, http.StatusInternalServerError, "Failed to copy video to temporary file", err)
+		return
+	default:
 		respondWithError(w, http.StatusBadRequest, "Unable to parse video file", err)
 		return
 	}
-	defer file.Close()
 
-	contentType := header.Header.Get("Content-Type")
+	contentType := part.header.Get("Content-Type")
 	if contentType == "" {
 		respondWithError(w, http.StatusBadRequest, "Missing Content-Type for video", nil)
 		return
 	}
+	watermark, err := parseWatermarkOverride(form.Get("watermark"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid watermark value", err)
+		return
+	}
+	profileName, ok := cfg.uploadProfile(w, userID, form.Get("profile"), form.Get("preset_id"))
+	if !ok {
+		return
+	}
+	src := videoSource{
+		filename:    part.filename,
+		contentType: contentType,
+		profileName: profileName,
+		watermark:   watermark,
+	}
+	container, _, ok := cfg.checkVideoSource(w, src)
+	if !ok {
+		return
+	}
+	// Checked again against what processing stores, but an upload that
+	// can't fit is turned away before it's queued.
+	if !cfg.requireStorageQuota(w, video.UserID, videoID, database.StorageKindVideo, part.size) {
+		return
+	}
+	// The full check runs with processing, but a file that isn't a video
+	// at all is rejected before the upload is accepted.
+	head, err := sniffFile(raw.Name())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to copy video to temporary file", err)
+		return
+	}
+	if !container.sniff(head) {
+		respondWithError(w, http.StatusBadRequest, "File content doesn't match its declared type", nil)
+		return
+	}
 
-	mediaType, _, err := mime.ParseMediaType(contentType)
-	if err != nil || mediaType != "video/mp4" {
-		respondWithError(w, http.StatusBadRequest, "Invalid file type. Only MP4 videos are allowed.", err)
+	queuedVideo, err := cfg.db.QueueVideoProcessing(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to update video metadata", err)
+		return
+	}
+	if !queuedVideo {
+		respondWithError(w, http.StatusConflict, "Video is already being processed", nil)
 		return
 	}
+	cleanup.commit()
+	queued = true
+	progress.stage(uploadQueued, 0)
+	cfg.emitEvent(eventVideoUploaded, videoID, map[string]any{"upload_id": progress.id(), "size": part.size})
+	job := uploadJob{
+		videoID:  videoID,
+		target:   target,
+		src:      src,
+		rawPath:  raw.Name(),
+		plog:     plog,
+		progress: progress,
+	}
+	cfg.background.run(func() { cfg.runUploadJob(r, job) })
 
-	tempFile, err := os.CreateTemp("", "tubely-upload-*.mp4")
+	video.ProcessingStatus, video.ProcessingError = database.VideoPending, ""
+	video, err = cfg.dbVideoToSignedVideo(r.Context(), video)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to create temporary file", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URLs", err)
 		return
 	}
-	defer os.Remove(tempFile.Name())
+	respondWithJSON(w, http.StatusAccepted, video)
+}
+
+// videoSource is an uploaded video file and how to process it.
+type videoSource struct {
+	file        io.Reader
+	filename    string
+	contentType string
+	profileName string
+	// hasThumbnail is set when the upload comes with its own thumbnail, so
+	// none is extracted from the video.
+	hasThumbnail bool
+	// watermark overrides the uploader's watermark setting when set.
+	watermark *bool
+	// watermarkText is burned into the video if set. applyUploadSettings
+	// sets it from the uploader's settings.
+	watermarkText string
+}
+
+// checkVideoSource validates src's type and processing profile, which
+// doesn't need the file, and returns its container. If ok is false, an
+// error response has been written.
+func (cfg *apiConfig) checkVideoSource(w http.ResponseWriter, src videoSource) (container videoContainer, profile ffmpeg.Profile, ok bool) {
+	container, ok = cfg.requireVideoContainer(w, src.contentType)
+	if !ok {
+		return videoContainer{}, ffmpeg.Profile{}, false
+	}
+	profile, ok = cfg.profiles.Get(src.profileName)
+	if !ok && src.profileName != ffmpeg.ShortsProfileName {
+		respondWithError(w, http.StatusBadRequest, "Unknown processing profile", nil)
+		return videoContainer{}, ffmpeg.Profile{}, false
+	}
+	return container, profile, true
+}
+
+// requireVideoContainer returns the container of videos sent as
+// contentType. If ok is false, the container isn't allowed and an error
+// response has been written.
+func (cfg *apiConfig) requireVideoContainer(w http.ResponseWriter, contentType string) (videoContainer, bool) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	container, ok := cfg.videoContainers[mediaType]
+	if err != nil || !ok {
+		respondWithError(w, http.StatusBadRequest, "Invalid file type. This kind of video isn't allowed.", err)
+		return videoContainer{}, false
+	}
+	return container, true
+}
+
+// ingestVideo is ingestVideoFile, recording on the video how the upload's
+// processing went.
+func (cfg *apiConfig) ingestVideo(w http.ResponseWriter, r *http.Request, cleanup *cleanupStack, video database.Video, target tenants.Target, src videoSource) (updated database.Video, matches []fingerprint.Match, ok bool) {
+	rec := &errorRecorder{ResponseWriter: w}
+	updated, matches, ok = cfg.ingestVideoFile(rec, r, cleanup, video, target, src)
+	if !ok {
+		cfg.setVideoProcessing(video.ID, database.VideoFailed, rec.message())
+		return database.Video{}, nil, false
+	}
+	cfg.setVideoProcessing(video.ID, database.VideoReady, "")
+	updated.ProcessingStatus, updated.ProcessingError = database.VideoReady, ""
+	return updated, matches, true
+}
+
+// ingestVideoFile runs src through the processing pipeline, uploads the
+// results to target and points video at them. Everything it creates is
+// registered on cleanup, so the caller decides when the upload is complete
+// by committing. If ok is false, an error response has been written.
+func (cfg *apiConfig) ingestVideoFile(w http.ResponseWriter, r *http.Request, cleanup *cleanupStack, video database.Video, target tenants.Target, src videoSource) (updated database.Video, matches []fingerprint.Match, ok bool) {
+	ctx := r.Context()
+	videoID, userID := video.ID, video.UserID
+
+	src, err := cfg.applyUploadSettings(userID, src)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get settings", err)
+		return database.Video{}, nil, false
+	}
+	container, profile, ok := cfg.checkVideoSource(w, src)
+	if !ok {
+		return database.Video{}, nil, false
+	}
+	profileName := src.profileName
+
+	tempFile, err := os.CreateTemp("", "tubely-upload-*"+container.tempExt())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to create temporary file", err)
+		return database.Video{}, nil, false
+	}
+	cleanup.removeFile(tempFile.Name())
 	defer tempFile.Close()
 
-	if _, err := io.Copy(tempFile, file); err != nil {
+	sourceHash := sha256.New()
+	size, err := io.Copy(cfg.chaos.SlowWriter(tempFile), io.TeeReader(src.file, sourceHash))
+	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Failed to copy video to temporary file", err)
-		return
+		return database.Video{}, nil, false
+	}
+	telemetry.uploadBytes.Add(float64(size), "video", processingSource(ctx))
+	if !cfg.requireStorageQuota(w, userID, videoID, database.StorageKindVideo, size) {
+		return database.Video{}, nil, false
 	}
 
 	if _, err := tempFile.Seek(0, io.SeekStart); err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Failed to reset file pointer", err)
-		return
+		return database.Video{}, nil, false
 	}
 
-	processedFilePath, err := processVideoForFastStart(tempFile.Name())
+	head, err := sniffFile(tempFile.Name())
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to process video for fast start", err)
-		return
+		respondWithError(w, http.StatusInternalServerError, "Failed to copy video to temporary file", err)
+		return database.Video{}, nil, false
+	}
+	if !container.sniff(head) {
+		respondWithError(w, http.StatusBadRequest, "File content doesn't match its declared type", nil)
+		return database.Video{}, nil, false
+	}
+	if err := cfg.verifyVideoContent(ctx, tempFile.Name(), container); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Video file isn't a valid video of its type", err)
+		return database.Video{}, nil, false
+	}
+
+	sourceSHA256 := hex.EncodeToString(sourceHash.Sum(nil))
+	if !cfg.requireManifestHash(w, videoID, sourceSHA256) {
+		return database.Video{}, nil, false
+	}
+	verdict := cfg.scanUpload(ctx, tempFile.Name(), sourceSHA256)
+	switch verdict.Status {
+	case scan.StatusBlocked:
+		respondWithError(w, http.StatusUnprocessableEntity, "File was rejected by content scanning", fmt.Errorf("scan: %s", verdict.Reason))
+		return database.Video{}, nil, false
+	case scan.StatusFlagged:
+		cleanup.onCommit("file scan report", func() error {
+			cfg.flagScanVerdict(videoID, verdict)
+			return nil
+		})
 	}
-	defer os.Remove(processedFilePath)
 
-	aspectRatio, err := getVideoAspectRatio(tempFile.Name())
+	duration, err := cfg.getVideoDuration(ctx, tempFile.Name())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to determine video duration", err)
+		return database.Video{}, nil, false
+	}
+
+	colorInfo, err := cfg.getColorInfo(ctx, tempFile.Name())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to determine video color metadata", err)
+		return database.Video{}, nil, false
+	}
+
+	sphericalInfo, err := cfg.getSphericalInfo(ctx, tempFile.Name())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to read spherical video metadata", err)
+		return database.Video{}, nil, false
+	}
+
+	aspectRatio, err := cfg.getVideoAspectRatio(ctx, tempFile.Name())
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Failed to determine video aspect ratio", err)
-		return
+		return database.Video{}, nil, false
+	}
+
+	// The shorts ladder rescales the frame, which would break the
+	// projection of 360° video.
+	shortEligible := cfg.isShortEligible(aspectRatio, duration) && !sphericalInfo.Spherical
+	isShort := profileName == ffmpeg.ShortsProfileName
+	if isShort && !shortEligible {
+		msg := fmt.Sprintf("Shorts must be 9:16 portrait, not 360°, and at most %s long", cfg.shortsMaxDuration)
+		respondWithError(w, http.StatusBadRequest, msg, nil)
+		return database.Video{}, nil, false
+	}
+	if profileName == "" && shortEligible {
+		isShort = true
+	}
+
+	var processedFilePath, sdrFilePath string
+	var short shortOutputs
+	var crops socialCropOutputs
+	var peaks *ffmpeg.Peaks
+	jobStart := time.Now()
+	var remuxedFilePath, watermarkedFilePath, hlsDir string
+	var hlsRenditions []database.HLSRendition
+	progress := uploadProgressFrom(ctx)
+	err = cfg.jobs.Run(videoID, duration, func() error {
+		cfg.setVideoProcessing(videoID, database.VideoProcessing, "")
+		progress.stage(uploadProcessing, 0)
+		encodeCtx := ffmpeg.WithProgress(ctx, func(outTime time.Duration) {
+			if duration > 0 {
+				progress.fraction(outTime.Seconds() / duration)
+			}
+		})
+		var err error
+		sourcePath := tempFile.Name()
+		if !container.isMP4() {
+			if remuxedFilePath, err = cfg.remuxToMP4(ctx, sourcePath, container); err != nil {
+				return err
+			}
+			sourcePath = remuxedFilePath
+		}
+		matches = cfg.checkFingerprints(ctx, sourcePath)
+		if src.watermarkText != "" {
+			if watermarkedFilePath, err = watermarkVideo(ctx, sourcePath, src.watermarkText); err != nil {
+				return err
+			}
+			sourcePath = watermarkedFilePath
+		}
+		if isShort {
+			short, err = cfg.processShort(encodeCtx, sourcePath)
+			if err == nil {
+				processedFilePath = short.ladder[0].path
+			}
+		} else {
+			processedFilePath, err = processVideo(encodeCtx, sourcePath, profile)
+		}
+		if err != nil {
+			return err
+		}
+		if peaks, err = cfg.generatePeaks(ctx, processedFilePath); err != nil {
+			return err
+		}
+		if needsSDRRendition(colorInfo) {
+			if sdrFilePath, err = createSDRRendition(ctx, processedFilePath, colorInfo); err != nil {
+				return err
+			}
+		}
+		// The HLS renditions and social crops are 8-bit, so HDR sources
+		// are encoded from their SDR rendition.
+		eightBitInput := processedFilePath
+		if sdrFilePath != "" {
+			eightBitInput = sdrFilePath
+		}
+		// Cropping would break the projection of 360° video.
+		if cfg.socialCrops && !isShort && !sphericalInfo.Spherical {
+			if crops, err = cfg.processSocialCrops(ctx, eightBitInput); err != nil {
+				return err
+			}
+		}
+		if !cfg.hlsOutput || isShort {
+			return nil
+		}
+		hlsDir, hlsRenditions, err = cfg.createHLS(ctx, eightBitInput)
+		return err
+	})
+	logStep(ctx, "job", fmt.Sprintf("processing job (%.1fs of media, queued and run)", duration), jobStart, err)
+	if isShort {
+		cleanup.always("remove shorts outputs", func() error { short.remove(); return nil })
+	} else if processedFilePath != "" {
+		cleanup.removeFile(processedFilePath)
+	}
+	if sdrFilePath != "" {
+		cleanup.removeFile(sdrFilePath)
+	}
+	if len(crops) > 0 {
+		cleanup.always("remove social crops", func() error { crops.remove(); return nil })
+	}
+	if remuxedFilePath != "" {
+		cleanup.removeFile(remuxedFilePath)
+	}
+	if watermarkedFilePath != "" {
+		cleanup.removeFile(watermarkedFilePath)
+	}
+	if hlsDir != "" {
+		cleanup.always("remove HLS output", func() error { return os.RemoveAll(hlsDir) })
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to process video", err)
+		return database.Video{}, nil, false
+	}
+
+	audioTracks, err := cfg.getAudioTracks(ctx, processedFilePath)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to read audio tracks", err)
+		return database.Video{}, nil, false
+	}
+	metadata, err := cfg.getVideoMetadata(ctx, processedFilePath)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to read video metadata", err)
+		return database.Video{}, nil, false
 	}
 
 	randomBytes := make([]byte, 16)
 	if _, err := rand.Read(randomBytes); err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Failed to generate random key", err)
-		return
+		return database.Video{}, nil, false
 	}
 
-	fileKey := fmt.Sprintf("%s/%x.mp4", aspectRatio, randomBytes)
-
-	processedFile, err := os.Open(processedFilePath)
+	storedPaths := []string{processedFilePath, sdrFilePath, hlsDir}
+	if isShort {
+		storedPaths = append(storedPaths, short.preview)
+		for _, out := range short.ladder[1:] {
+			storedPaths = append(storedPaths, out.path)
+		}
+	}
+	for _, out := range crops {
+		storedPaths = append(storedPaths, out.path)
+	}
+	stored, err := storedSize(storedPaths...)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to open processed file", err)
-		return
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update storage usage", err)
+		return database.Video{}, nil, false
 	}
-	defer processedFile.Close()
+	progress.stage(uploadStoring, stored)
 
-	_, err = cfg.s3Client.PutObject(context.TODO(), &s3.PutObjectInput{
-		Bucket:      &cfg.s3Bucket,
-		Key:         &fileKey,
-		Body:        processedFile,
-		ContentType: &mediaType,
-	})
+	filename := ""
+	if cfg.preserveFilenames {
+		filename = sanitizeFilename(src.filename, ".mp4")
+	}
+
+	videoFile, err := cfg.storeVideoFile(r.Context(), cleanup, target, processedFilePath, filename)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to upload video to S3", err)
-		return
+		respondWithStorageError(w, http.StatusInternalServerError, "Failed to upload video to S3", err)
+		return database.Video{}, nil, false
+	}
+
+	original := video
+	video.OriginalFilename = filename
+
+	video.VideoURL = &videoFile.Key
+	video.SourceSHA256 = sourceSHA256
+	video.VideoSHA256 = videoFile.SHA256
+	video.Encryption = videoFile.Encryption
+	video.AspectRatio = aspectRatio
+	video.IsShort = isShort
+	video.PreviewURL = nil
+	video.ColorInfo = colorInfo
+	video.SDRVideoURL = nil
+	video.SphericalInfo = sphericalInfo
+	video.Metadata = metadata
+	if len(matches) > 0 || verdict.Status == scan.StatusFlagged {
+		video.ModerationHold = true
 	}
 
-	videoURL := fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", cfg.s3Bucket, cfg.s3Region, fileKey)
-	video.VideoURL = &videoURL
+	video.PeaksURL = nil
+	if peaks != nil {
+		peaksKey := videoObjectKey(userID, videoID, fmt.Sprintf("peaks-%x.json", randomBytes))
+		if err := uploadPeaks(r.Context(), target, peaksKey, peaks); err != nil {
+			respondWithStorageError(w, http.StatusInternalServerError, "Failed to upload waveform peaks to S3", err)
+			return database.Video{}, nil, false
+		}
+		cleanup.deleteObject(target, peaksKey)
+		video.PeaksURL = &peaksKey
+	}
+
+	renditions := []database.Rendition{}
+	if isShort {
+		for i, out := range short.ladder {
+			url := videoFile.Key
+			if i > 0 {
+				key := videoObjectKey(userID, videoID, fmt.Sprintf("%s-%s-%x.mp4", aspectRatio, out.rung.Name, randomBytes))
+				if err := cfg.uploadVideoFile(r.Context(), target, key, out.path, "video/mp4"); err != nil {
+					respondWithStorageError(w, http.StatusInternalServerError, "Failed to upload rendition to S3", err)
+					return database.Video{}, nil, false
+				}
+				cleanup.deleteObject(target, key)
+				url = key
+			}
+			renditions = append(renditions, database.Rendition{
+				Name:   out.rung.Name,
+				Width:  out.rung.Width,
+				Height: out.rung.Height,
+				URL:    url,
+			})
+		}
 
+		previewKey := videoObjectKey(userID, videoID, fmt.Sprintf("preview-%x.mp4", randomBytes))
+		if err := cfg.uploadVideoFile(r.Context(), target, previewKey, short.preview, "video/mp4"); err != nil {
+			respondWithStorageError(w, http.StatusInternalServerError, "Failed to upload preview to S3", err)
+			return database.Video{}, nil, false
+		}
+		cleanup.deleteObject(target, previewKey)
+		video.PreviewURL = &previewKey
+	}
+
+	for _, out := range crops {
+		key := videoObjectKey(userID, videoID, fmt.Sprintf("%s-%x.mp4", out.crop.Name, randomBytes))
+		if err := cfg.uploadVideoFile(r.Context(), target, key, out.path, "video/mp4"); err != nil {
+			respondWithStorageError(w, http.StatusInternalServerError, "Failed to upload rendition to S3", err)
+			return database.Video{}, nil, false
+		}
+		cleanup.deleteObject(target, key)
+		renditions = append(renditions, database.Rendition{
+			Name:   out.crop.Name,
+			Width:  out.crop.Width,
+			Height: out.crop.Height,
+			URL:    key,
+			Crop:   true,
+		})
+	}
+
+	if sdrFilePath != "" {
+		sdrKey := videoObjectKey(userID, videoID, fmt.Sprintf("sdr-%x.mp4", randomBytes))
+		if err := cfg.uploadVideoFile(r.Context(), target, sdrKey, sdrFilePath, "video/mp4"); err != nil {
+			respondWithStorageError(w, http.StatusInternalServerError, "Failed to upload SDR rendition to S3", err)
+			return database.Video{}, nil, false
+		}
+		cleanup.deleteObject(target, sdrKey)
+		video.SDRVideoURL = &sdrKey
+	}
+
+	video.HLSURL = nil
+	if hlsDir != "" {
+		masterKey, err := uploadHLS(r.Context(), cleanup, target, hlsDir, videoObjectKey(userID, videoID, fmt.Sprintf("hls-%x", randomBytes)))
+		if err != nil {
+			respondWithStorageError(w, http.StatusInternalServerError, "Failed to upload HLS renditions to S3", err)
+			return database.Video{}, nil, false
+		}
+		video.HLSURL = &masterKey
+		for i := range hlsRenditions {
+			hlsRenditions[i].VideoID = videoID
+			hlsRenditions[i].MasterKey = masterKey
+		}
+	}
+
+	// A video without a thumbnail gets a frame of itself. A failed
+	// extraction doesn't fail the upload, since a thumbnail can still be
+	// uploaded by hand.
+	var autoThumbnailSize int64
+	if video.ThumbnailURL == nil && !src.hasThumbnail && !cfg.autoThumbnail.Off {
+		framePath := processedFilePath
+		if sdrFilePath != "" {
+			framePath = sdrFilePath
+		}
+		thumbnail, err := cfg.extractThumbnail(r, cleanup, framePath, duration)
+		if err != nil {
+			log.Printf("Couldn't extract a thumbnail for video %s: %v", videoID, err)
+		} else {
+			autoThumbnailSize = thumbnail.Size
+			video.ThumbnailURL = &thumbnail.URL
+			video.ThumbnailSHA256 = thumbnail.SHA256
+			if cfg.thumbnails.enabled() {
+				cleanup.onCommit("generate thumbnail variants", func() error {
+					_, err := cfg.generateThumbnailVariants(context.Background(), videoID, thumbnail.Name)
+					return err
+				})
+			}
+		}
+	}
+
+	if err := cfg.captureMediaInfo(ctx, video.ID, tempFile.Name(), processedFilePath); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to capture media info", err)
+		return database.Video{}, nil, false
+	}
+	// The keyframe index only helps clients pick cut points, so the video
+	// is still published without one.
+	if err := cfg.captureKeyframes(ctx, cleanup, video.ID, processedFilePath); err != nil {
+		log.Printf("Couldn't index keyframes for video %s: %v", videoID, err)
+	}
+	previousTracks, err := cfg.db.GetAudioTracks(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to read current audio tracks", err)
+		return database.Video{}, nil, false
+	}
 	if err := cfg.db.UpdateVideo(video); err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Failed to update video metadata", err)
-		return
+		return database.Video{}, nil, false
+	}
+	cleanup.restoreVideo(cfg.db, original)
+	if err := cfg.db.ReplaceAudioTracks(video.ID, audioTracks); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to save audio tracks", err)
+		return database.Video{}, nil, false
+	}
+	cleanup.onError("restore audio tracks", func() error {
+		return cfg.db.ReplaceAudioTracks(video.ID, previousTracks)
+	})
+	previousRenditions, err := cfg.db.GetRenditions(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get renditions", err)
+		return database.Video{}, nil, false
+	}
+	if err := cfg.db.ReplaceRenditions(video.ID, renditions); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to save renditions", err)
+		return database.Video{}, nil, false
+	}
+	cleanup.onError("restore renditions", func() error {
+		return cfg.db.ReplaceRenditions(video.ID, previousRenditions)
+	})
+	previousHLSRenditions, err := cfg.db.GetHLSRenditions(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get renditions", err)
+		return database.Video{}, nil, false
+	}
+	if err := cfg.db.ReplaceHLSRenditions(video.ID, hlsRenditions); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to save renditions", err)
+		return database.Video{}, nil, false
+	}
+	cleanup.onError("restore HLS renditions", func() error {
+		return cfg.db.ReplaceHLSRenditions(video.ID, previousHLSRenditions)
+	})
+	cleanup.onCommit("encode pending HLS renditions", func() error {
+		cfg.hlsBackfill.notify()
+		return nil
+	})
+	if !cfg.recordStorageUsage(w, cleanup, userID, videoID, database.StorageKindVideo, stored) {
+		return database.Video{}, nil, false
+	}
+	if autoThumbnailSize > 0 && !cfg.recordStorageUsage(w, cleanup, userID, videoID, database.StorageKindThumbnail, autoThumbnailSize) {
+		return database.Video{}, nil, false
 	}
-	respondWithJSON(w, http.StatusOK, video)
+	cfg.invalidateOnCommit(cleanup, video.ID, "video", cfg.replacedVideoPaths(target, original, previousRenditions))
+	cfg.deleteVideoFilesOnCommit(cleanup, target, original, previousRenditions)
+	return video, matches, true
 }
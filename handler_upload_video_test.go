@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/fakes"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/clock"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ffmpeg"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tenants"
+	"github.com/google/uuid"
+)
+
+const testBucket = "tubely-test"
+
+// testMP4 is enough of an MP4 to pass the upload's sniffing; the fake
+// ffmpeg does the rest.
+var testMP4 = append([]byte("\x00\x00\x00\x18ftypmp42\x00\x00\x00\x00mp42isom"), bytes.Repeat([]byte{0}, 1024)...)
+
+// newTestConfig returns a config storing videos in testBucket on s3, with a
+// scratch database and the fake ffmpeg installed.
+func newTestConfig(t *testing.T, s3 *fakes.S3, fake *fakes.FFmpeg) *apiConfig {
+	t.Helper()
+	t.Cleanup(fake.Install())
+	db, err := database.NewClient(filepath.Join(t.TempDir(), "tubely.db"))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	pool, err := tenants.NewPool(s3.Target(testBucket), nil)
+	if err != nil {
+		t.Fatalf("NewPool: %v", err)
+	}
+	containers, err := parseVideoContainers(defaultVideoContainers)
+	if err != nil {
+		t.Fatalf("parseVideoContainers: %v", err)
+	}
+	c := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	return &apiConfig{
+		deps: deps{
+			db:      db,
+			tenants: pool,
+			prober:  ffprobe{},
+			jobs:    jobs.NewQueue(1, 0, c),
+			clock:   c,
+			logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+		},
+		jwtSecret:        "test-secret",
+		profiles:         ffmpeg.DefaultProfiles(),
+		videoContainers:  containers,
+		autoThumbnail:    autoThumbnail{Off: true},
+		background:       &backgroundJobs{},
+		uploadSpoolDir:   t.TempDir(),
+		uploadProgress:   newUploadProgresses(),
+		signedURLs:       newSignedURLCache(),
+		contentObjects:   &contentObjects{},
+		hlsBackfill:      newHLSBackfill(),
+		webhooks:         newWebhookDispatcher(false),
+		sitemap:          newSiteMap(),
+		slo:              newSLOTracker(sloConfig{period: 24 * time.Hour}, c.Now()),
+		dailyUploadLimit: newDailyUploadLimit(0),
+	}
+}
+
+// newTestVideo creates a user and a video of theirs, and returns the video
+// with a token for its owner.
+func newTestVideo(t *testing.T, cfg *apiConfig) (database.Video, string) {
+	t.Helper()
+	user, err := cfg.db.CreateUser(database.CreateUserParams{Email: uuid.NewString() + "@example.com", Password: "hash"})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "Boots", UserID: user.ID})
+	if err != nil {
+		t.Fatalf("CreateVideo: %v", err)
+	}
+	token, err := auth.MakeJWT(user.ID, cfg.jwtSecret, time.Hour)
+	if err != nil {
+		t.Fatalf("MakeJWT: %v", err)
+	}
+	return video, token
+}
+
+// uploadTestVideo uploads testMP4 for video and waits for it to be
+// processed.
+func uploadTestVideo(t *testing.T, cfg *apiConfig, video database.Video, token string) database.Video {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", `form-data; name="video"; filename="boots.mp4"`)
+	header.Set("Content-Type", "video/mp4")
+	part, err := form.CreatePart(header)
+	if err != nil {
+		t.Fatalf("CreatePart: %v", err)
+	}
+	part.Write(testMP4)
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/video_upload/"+video.ID.String(), &body)
+	req.SetPathValue("videoID", video.ID.String())
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	cfg.handlerUploadVideo(rec, req)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("upload responded %d: %s", rec.Code, rec.Body)
+	}
+	if _, err := cfg.background.wait(context.Background()); err != nil {
+		t.Fatalf("waiting for processing: %v", err)
+	}
+
+	processed, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		t.Fatalf("GetVideo: %v", err)
+	}
+	return processed
+}
+
+// testPlaylist stands in for the playlists ffmpeg writes for HLS
+// renditions.
+const testPlaylist = "#EXTM3U\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXT-X-MAP:URI=\"init.mp4\"\n#EXTINF:6.000000,\nsegment_0000.m4s\n#EXT-X-ENDLIST\n"
+
+// newTestFFmpeg scripts ffmpeg for a 720p upload with one audio track:
+// transcodes copy their input and HLS encodes write testPlaylist.
+func newTestFFmpeg() *fakes.FFmpeg {
+	return fakes.NewFFmpeg().
+		On(ffmpeg.FFmpegPath, fakes.Response{CopyInput: true}).
+		On(ffmpeg.FFmpegPath, fakes.Response{Output: []byte(testPlaylist)}, "-f", "hls").
+		Probe(1280, 720, 12, true).
+		On(ffmpeg.FFprobePath, fakes.Response{Stdout: []byte("0.000000,K__\n6.000000,K__\n")}, "packet=pts_time,flags")
+}
+
+func TestUploadVideo(t *testing.T) {
+	tests := []struct {
+		name        string
+		hlsOutput   bool
+		noKeyframes bool
+	}{
+		{name: "mp4"},
+		{name: "with HLS", hlsOutput: true},
+		{name: "keyframes can't be indexed", noKeyframes: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s3 := fakes.NewS3(testBucket)
+			defer s3.Close()
+			fake := newTestFFmpeg()
+			if tt.noKeyframes {
+				fake.On(ffmpeg.FFprobePath, fakes.Response{Err: errors.New("probe crashed")}, "packet=pts_time,flags")
+			}
+			cfg := newTestConfig(t, s3, fake)
+			cfg.hlsOutput = tt.hlsOutput
+			video, token := newTestVideo(t, cfg)
+
+			processed := uploadTestVideo(t, cfg, video, token)
+			if processed.ProcessingStatus != database.VideoReady {
+				t.Fatalf("processing status = %s (%s), want %s", processed.ProcessingStatus, processed.ProcessingError, database.VideoReady)
+			}
+			keyframes, err := cfg.db.GetKeyframes(video.ID)
+			if err != nil {
+				t.Fatalf("GetKeyframes: %v", err)
+			}
+			if (keyframes != nil) == tt.noKeyframes {
+				t.Errorf("keyframe index = %+v, want one: %t", keyframes, !tt.noKeyframes)
+			}
+			if processed.VideoURL == nil {
+				t.Fatal("video URL wasn't set")
+			}
+			if data, ok := s3.Object(testBucket, *processed.VideoURL); !ok || !bytes.Equal(data, testMP4) {
+				t.Errorf("video file %s wasn't stored as uploaded", *processed.VideoURL)
+			}
+			if processed.PeaksURL == nil {
+				t.Error("peaks URL wasn't set")
+			} else if _, ok := s3.Object(testBucket, *processed.PeaksURL); !ok {
+				t.Errorf("peaks %s weren't stored", *processed.PeaksURL)
+			}
+			if entries, _ := os.ReadDir(cfg.uploadSpoolDir); len(entries) > 0 {
+				t.Errorf("spooled upload %s was left behind", entries[0].Name())
+			}
+
+			if !tt.hlsOutput {
+				if processed.HLSURL != nil {
+					t.Errorf("HLS URL = %s, want none", *processed.HLSURL)
+				}
+				return
+			}
+			if processed.HLSURL == nil {
+				t.Fatal("HLS URL wasn't set")
+			}
+			// The smallest rung is encoded with the upload and the rest
+			// are left to the backfill.
+			dir := path.Dir(*processed.HLSURL)
+			for _, key := range []string{"480p/index.m3u8", "audio-0/index.m3u8"} {
+				if _, ok := s3.Object(testBucket, dir+"/"+key); !ok {
+					t.Errorf("%s wasn't stored", key)
+				}
+			}
+			if _, ok := s3.Object(testBucket, dir+"/720p/index.m3u8"); ok {
+				t.Error("720p was encoded with the upload")
+			}
+			master, _ := s3.Object(testBucket, *processed.HLSURL)
+			for _, want := range []string{`#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="audio"`, `URI="audio-0/index.m3u8"`, "480p/index.m3u8"} {
+				if !strings.Contains(string(master), want) {
+					t.Errorf("master playlist doesn't have %s:\n%s", want, master)
+				}
+			}
+		})
+	}
+}
+
+// probeFunc is a mediaProber for tests to fail probes with.
+type probeFunc func(ctx context.Context, path string) ([]byte, error)
+
+func (f probeFunc) Probe(ctx context.Context, path string) ([]byte, error) {
+	return f(ctx, path)
+}
+
+func TestUploadVideoRollback(t *testing.T) {
+	tests := []struct {
+		name      string
+		hlsOutput bool
+	}{
+		{name: "mp4"},
+		{name: "with HLS", hlsOutput: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s3 := fakes.NewS3(testBucket)
+			defer s3.Close()
+			cfg := newTestConfig(t, s3, newTestFFmpeg())
+			// Media info is captured after everything has been uploaded.
+			cfg.prober = probeFunc(func(ctx context.Context, path string) ([]byte, error) {
+				if len(s3.Keys(testBucket)) > 0 {
+					return nil, errors.New("probe crashed")
+				}
+				return ffprobe{}.Probe(ctx, path)
+			})
+			cfg.hlsOutput = tt.hlsOutput
+			video, token := newTestVideo(t, cfg)
+
+			processed := uploadTestVideo(t, cfg, video, token)
+			if processed.ProcessingStatus != database.VideoFailed || processed.ProcessingError == "" {
+				t.Errorf("processing status = %s (%q), want %s with an error", processed.ProcessingStatus, processed.ProcessingError, database.VideoFailed)
+			}
+			if processed.VideoURL != nil || processed.PeaksURL != nil || processed.HLSURL != nil {
+				t.Errorf("video still points at its files: %+v", processed)
+			}
+			if keys := s3.Keys(testBucket); len(keys) > 0 {
+				t.Errorf("uploaded objects %v weren't deleted", keys)
+			}
+			tracks, err := cfg.db.GetAudioTracks(video.ID)
+			if err != nil {
+				t.Fatalf("GetAudioTracks: %v", err)
+			}
+			if len(tracks) > 0 {
+				t.Errorf("audio tracks %+v weren't rolled back", tracks)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	accessKindMetadata = "metadata"
+	accessKindPlayback = "playback"
+)
+
+// viewerID returns the user making the request, or nil for anonymous
+// requests. Endpoints that are public use this instead of requiring a JWT,
+// and also accept a device token.
+func (cfg *apiConfig) viewerID(r *http.Request) *uuid.UUID {
+	if auth.HasDeviceToken(r.Header) {
+		user, err := cfg.deviceTokenUser(r)
+		if err != nil {
+			if !errors.Is(err, errInvalidDeviceToken) {
+				log.Printf("Couldn't get device token: %v", err)
+			}
+			return nil
+		}
+		return &user.ID
+	}
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		return nil
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		return nil
+	}
+	return &userID
+}
+
+// recordAccess adds a view of the video to its access history, and counts
+// playbacks toward trending. Both are informational, so failures are only
+// logged.
+func (cfg *apiConfig) recordAccess(r *http.Request, video database.Video, kind string) {
+	now := cfg.clock.Now().UTC()
+	err := cfg.db.RecordAccessEvent(database.AccessEvent{
+		VideoID:    video.ID,
+		ViewerID:   cfg.viewerID(r),
+		Kind:       kind,
+		OccurredAt: now,
+	})
+	if err != nil {
+		log.Printf("Couldn't record access to video %s: %v", video.ID, err)
+	}
+	if kind != accessKindPlayback {
+		return
+	}
+	if err := cfg.db.RecordView(video.ID, now, cfg.viewRetention()); err != nil {
+		log.Printf("Couldn't count view of video %s: %v", video.ID, err)
+	}
+}
+
+// accessGrant is one reason someone can view a video. From is set when the
+// grant only starts at a later time.
+type accessGrant struct {
+	Principal string     `json:"principal"`
+	UserID    *uuid.UUID `json:"user_id,omitempty"`
+	Reason    string     `json:"reason"`
+	From      *time.Time `json:"from,omitempty"`
+}
+
+type videoAccess struct {
+	VideoID       uuid.UUID              `json:"video_id"`
+	Visibility    string                 `json:"visibility"`
+	PublishAt     *time.Time             `json:"publish_at"`
+	AgeRestricted bool                   `json:"age_restricted"`
+	Grants        []accessGrant          `json:"grants"`
+	RecentAccess  []database.AccessEvent `json:"recent_access"`
+}
+
+// accessFor describes who can view the video.
+func accessFor(video database.Video, now time.Time) videoAccess {
+	owner := video.UserID
+	access := videoAccess{
+		VideoID:       video.ID,
+		Visibility:    "public",
+		PublishAt:     video.PublishAt,
+		AgeRestricted: video.AgeRestricted,
+		Grants: []accessGrant{
+			{Principal: "owner", UserID: &owner, Reason: "owns the video"},
+		},
+	}
+	if video.ModerationHold {
+		access.Visibility = "held"
+		return access
+	}
+	if video.Visibility == database.VisibilityPrivate {
+		access.Visibility = database.VisibilityPrivate
+		return access
+	}
+	anyone := accessGrant{Principal: "anyone", Reason: "the video is published"}
+	if video.Visibility == database.VisibilityUnlisted {
+		access.Visibility = database.VisibilityUnlisted
+		anyone = accessGrant{Principal: "link", Reason: "has the video's share link"}
+	}
+	if !video.Published(now) {
+		access.Visibility = "scheduled"
+		anyone.Reason = "the video is scheduled to publish"
+		if video.Premiere {
+			anyone.Reason = "the video is scheduled to premiere"
+		}
+		anyone.From = video.PublishAt
+	}
+	access.Grants = append(access.Grants, anyone)
+	return access
+}
+
+// handlerVideoAccess lists who can view a video and its recent views. Only
+// the owner and admins can see it. ?limit= caps the number of views
+// returned.
+func (cfg *apiConfig) handlerVideoAccess(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	limit := 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > 500 {
+			respondWithError(w, http.StatusBadRequest, "limit must be between 1 and 500", err)
+			return
+		}
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	if video.UserID != userID {
+		user, err := cfg.db.GetUser(userID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+			return
+		}
+		if !cfg.isAdmin(user) {
+			respondWithError(w, http.StatusUnauthorized, "Not authorized to access this video", nil)
+			return
+		}
+	}
+
+	access := accessFor(video, cfg.clock.Now())
+	access.RecentAccess, err = cfg.db.GetAccessEvents(videoID, limit)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get access events", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, access)
+}
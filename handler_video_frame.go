@@ -0,0 +1,154 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ffmpeg"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tenants"
+	"github.com/google/uuid"
+)
+
+// handlerVideoFrame returns the frame at ?t=SS.mmm as a JPEG for editing
+// UIs. With ?cache=true the frame is stored next to the video and the
+// response redirects to it, so repeated requests for the same timestamp
+// don't decode the video again.
+func (cfg *apiConfig) handlerVideoFrame(w http.ResponseWriter, r *http.Request) {
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	seconds, err := strconv.ParseFloat(r.URL.Query().Get("t"), 64)
+	if err != nil || seconds < 0 || math.IsInf(seconds, 0) || math.IsNaN(seconds) {
+		respondWithError(w, http.StatusBadRequest, "t must be a non-negative timestamp in seconds", err)
+		return
+	}
+	millis := int64(math.Round(seconds * 1000))
+	seconds = float64(millis) / 1000
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil || video.VideoURL == nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusUnauthorized, "Not authorized to access this video", nil)
+		return
+	}
+
+	target, sourceKey, err := cfg.videoObject(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't locate video file", err)
+		return
+	}
+
+	cache := r.URL.Query().Get("cache") == "true"
+	frameKey := frameCacheKey(video, sourceKey, millis)
+	if cache {
+		exists, err := objectExists(r.Context(), target, frameKey)
+		if err != nil {
+			respondWithError(w, http.StatusBadGateway, "Couldn't check cached frame", err)
+			return
+		}
+		if exists {
+			cfg.redirectToFrame(w, r, target, frameKey)
+			return
+		}
+	}
+
+	sourceURL, err := generatePresignedURL(r.Context(), target, sourceKey, cfg.presignExpiry)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate source URL", err)
+		return
+	}
+
+	output, err := os.CreateTemp("", "tubely-frame-*.jpg")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to create temporary file", err)
+		return
+	}
+	output.Close()
+	defer os.Remove(output.Name())
+
+	err = cfg.jobs.Run(uuid.New(), 0, func() error {
+		_, err := ffmpeg.RemoteFrameCommand(sourceURL, output.Name(), seconds).Run(r.Context())
+		return err
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't extract frame", err)
+		return
+	}
+
+	frame, err := os.ReadFile(output.Name())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read frame", err)
+		return
+	}
+	if len(frame) == 0 {
+		// ffmpeg exits cleanly without writing a frame when t is past the end.
+		respondWithError(w, http.StatusBadRequest, "t is past the end of the video", nil)
+		return
+	}
+
+	if cache {
+		if err := uploadFile(r.Context(), target, frameKey, output.Name(), "image/jpeg"); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't cache frame", err)
+			return
+		}
+		cfg.redirectToFrame(w, r, target, frameKey)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	w.WriteHeader(http.StatusOK)
+	w.Write(frame)
+}
+
+// frameCacheKey is where the frame millis into the video's current file is
+// cached. The key includes the file's SHA-256, or its key for files stored
+// before hashes were recorded, so frames of a replaced file aren't served
+// after a re-upload.
+func frameCacheKey(video database.Video, sourceKey string, millis int64) string {
+	version := video.VideoSHA256
+	if version == "" {
+		sum := sha256.Sum256([]byte(sourceKey))
+		version = hex.EncodeToString(sum[:8])
+	}
+	return videoObjectKey(video.UserID, video.ID, fmt.Sprintf("frames/%s/%d.jpg", version, millis))
+}
+
+func (cfg *apiConfig) redirectToFrame(w http.ResponseWriter, r *http.Request, target tenants.Target, key string) {
+	frameURL, err := generatePresignedURL(r.Context(), target, key, cfg.presignExpiry)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate frame URL", err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, frameURL, http.StatusFound)
+}
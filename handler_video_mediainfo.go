@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// mediaInfoTags are the container and stream tags kept in captured media
+// info. Others, such as encoder settings, device make and model, or GPS
+// location, are dropped since the output is shown outside of the upload.
+var mediaInfoTags = map[string]bool{
+	"language":      true,
+	"title":         true,
+	"handler_name":  true,
+	"major_brand":   true,
+	"rotate":        true,
+	"creation_time": true,
+}
+
+// sanitizeProbe strips the local file path and untrusted tags from raw
+// ffprobe JSON output.
+func sanitizeProbe(raw []byte) (json.RawMessage, error) {
+	var probe struct {
+		Streams []map[string]any `json:"streams"`
+		Format  map[string]any   `json:"format"`
+	}
+	if err := json.Unmarshal(raw, &probe); err != nil {
+		return nil, err
+	}
+	if probe.Streams == nil {
+		probe.Streams = []map[string]any{}
+	}
+	for _, stream := range probe.Streams {
+		filterProbeTags(stream)
+	}
+	if probe.Format != nil {
+		delete(probe.Format, "filename")
+		filterProbeTags(probe.Format)
+	}
+	return json.Marshal(probe)
+}
+
+func filterProbeTags(section map[string]any) {
+	tags, ok := section["tags"].(map[string]any)
+	if !ok {
+		return
+	}
+	for k := range tags {
+		if !mediaInfoTags[k] {
+			delete(tags, k)
+		}
+	}
+}
+
+// captureMediaInfo probes the source and processed files of a video and
+// stores the sanitized results.
+func (cfg *apiConfig) captureMediaInfo(ctx context.Context, videoID uuid.UUID, sourcePath, outputPath string) error {
+	info := database.MediaInfo{VideoID: videoID, CapturedAt: cfg.clock.Now().UTC()}
+	for _, f := range []struct {
+		path string
+		dest *json.RawMessage
+	}{
+		{sourcePath, &info.Source},
+		{outputPath, &info.Output},
+	} {
+		raw, err := cfg.prober.Probe(ctx, f.path)
+		if err != nil {
+			return err
+		}
+		if *f.dest, err = sanitizeProbe(raw); err != nil {
+			return err
+		}
+	}
+	return cfg.db.SaveMediaInfo(info)
+}
+
+// handlerVideoMediaInfo returns the probe output captured when the video was
+// processed. Only the owner and admins can see it.
+func (cfg *apiConfig) handlerVideoMediaInfo(w http.ResponseWriter, r *http.Request) {
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	if video.UserID != userID {
+		user, err := cfg.db.GetUser(userID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+			return
+		}
+		if !cfg.isAdmin(user) {
+			respondWithError(w, http.StatusUnauthorized, "Not authorized to access this video", nil)
+			return
+		}
+	}
+
+	info, err := cfg.db.GetMediaInfo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get media info", err)
+		return
+	}
+	if info == nil {
+		respondWithError(w, http.StatusNotFound, "No media info captured for this video", nil)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, info)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
@@ -12,6 +13,9 @@ import (
 func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
 		database.CreateVideoParams
+		scheduleParams
+		PresetID string `json:"preset_id"`
+		SeriesID string `json:"series_id"`
 	}
 
 	token, err := auth.GetBearerToken(r.Header)
@@ -33,16 +37,70 @@ func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Requ
 		return
 	}
 	params.UserID = userID
+	preset, ok := cfg.uploadPresetFor(w, userID, params.PresetID)
+	if !ok {
+		return
+	}
+	if preset != nil {
+		applyUploadPreset(&params.CreateVideoParams, preset, cfg.clock.Now())
+	}
+	var series *database.Series
+	if params.SeriesID != "" {
+		if series, ok = cfg.userSeries(w, userID, params.SeriesID); !ok {
+			return
+		}
+	}
+	if err := normalizeVideoParams(&params.CreateVideoParams); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	schedule, err := params.schedule()
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
 
 	video, err := cfg.db.CreateVideo(params.CreateVideoParams)
+	if errors.Is(err, database.ErrExternalIDTaken) {
+		respondWithError(w, http.StatusConflict, "External ID is already in use", err)
+		return
+	}
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create video", err)
 		return
 	}
-
+	if schedule.PublishAt != nil {
+		video.Schedule = schedule
+		if err := cfg.db.UpdateVideo(video); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't create video", err)
+			return
+		}
+		cfg.premieres.notify()
+	}
+	if preset != nil {
+		if err := cfg.db.UseUploadPreset(preset.ID); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update preset", err)
+			return
+		}
+	}
+	if series != nil {
+		if _, err := cfg.db.AddEpisode(series.ID, video.ID, 0); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't add episode", err)
+			return
+		}
+	}
+
+	cfg.recordActivity(userID, activityVideoCreated, &video.ID, nil, video.Title)
+	video, err = cfg.dbVideoToSignedVideo(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URLs", err)
+		return
+	}
 	respondWithJSON(w, http.StatusCreated, video)
 }
 
+// handlerVideoMetaDelete moves the owner's video to the trash, or deletes
+// it at once if TRASH_RETENTION is 0.
 func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Request) {
 	videoIDString := r.PathValue("videoID")
 	videoID, err := uuid.Parse(videoIDString)
@@ -71,13 +129,21 @@ func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Requ
 		respondWithError(w, http.StatusForbidden, "You can't delete this video", err)
 		return
 	}
+	if !requireNoLegalHold(w, video) || !requireNotProcessing(w, video) {
+		return
+	}
+	if !cfg.requireUnlockedVideoFile(w, r, video) {
+		return
+	}
 
-	err = cfg.db.DeleteVideo(videoID)
-	if err != nil {
+	if cfg.trashRetention > 0 {
+		cfg.trashVideo(w, r, video)
+		return
+	}
+	if err := cfg.purgeVideo(r.Context(), video); err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't delete video", err)
 		return
 	}
-
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -94,10 +160,30 @@ func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
 		return
 	}
+	if video.ID == uuid.Nil || !cfg.canView(r, video) {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	cfg.recordAccess(r, video, accessKindMetadata)
 
+	target, err := cfg.videoTarget(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't locate video file", err)
+		return
+	}
+	cfg.setNoIndex(w, target, video)
+	video, err = cfg.dbVideoToSignedVideo(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URLs", err)
+		return
+	}
 	respondWithJSON(w, http.StatusOK, video)
 }
 
+// handlerVideosRetrieve lists the user's videos, newest first unless the
+// query asks otherwise; see parseVideoList. Without limit or cursor it
+// responds with every matching video in an array, and otherwise with a
+// videoListResponse.
 func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Request) {
 	token, err := auth.GetBearerToken(r.Header)
 	if err != nil {
@@ -110,11 +196,25 @@ func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	videos, err := cfg.db.GetVideos(userID)
+	params, paged, ok := parseVideoList(w, r, userID)
+	if !ok {
+		return
+	}
+	videos, err := cfg.db.ListVideos(params)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
 		return
 	}
+	next := nextVideoListCursor(params, videos)
 
-	respondWithJSON(w, http.StatusOK, videos)
+	videos, err = cfg.dbVideosToSignedVideos(r.Context(), videos)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URLs", err)
+		return
+	}
+	if !paged {
+		respondWithJSON(w, http.StatusOK, videos)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, videoListResponse{Videos: videos, NextCursor: next})
 }
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tenants"
+	"github.com/google/uuid"
+)
+
+// handlerVideoPlayback gives players a single stable URL per video. It checks
+// that the object still exists and redirects to a short-lived URL for it.
+// Players that can't decode HDR or 10-bit video pass ?rendition=sdr to get the
+// tone-mapped copy; SDR sources have no separate copy and play as-is. Other
+// ?rendition= values pick a rendition by name, falling back to the source
+// for names the video doesn't have.
+// Passphrase-protected videos need an unlock token, see passphrase.go, and
+// age-restricted videos need to pass the age gate. Videos of
+// suspended owners may have playback blocked, and the owner's target may
+// restrict where videos are played from, see allowPlayback.
+func (cfg *apiConfig) handlerVideoPlayback(w http.ResponseWriter, r *http.Request) {
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.VideoURL == nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	target, ok := cfg.requirePlayback(w, r, video)
+	if !ok {
+		return
+	}
+	if err := cfg.pickRendition(&video, r.URL.Query().Get("rendition")); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get renditions", err)
+		return
+	}
+	key, ok := storedObjectKey(target, *video.VideoURL)
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't locate video file", fmt.Errorf("video URL %q is not in bucket %s", *video.VideoURL, target.Bucket))
+		return
+	}
+	cfg.setNoIndex(w, target, video)
+
+	exists, err := objectExists(r.Context(), target, key)
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Couldn't check video file", err)
+		return
+	}
+	if !exists {
+		respondWithError(w, http.StatusGone, "Video file is no longer available", nil)
+		return
+	}
+
+	// The stream endpoint counts the playback.
+	if cfg.streamVideos {
+		q := url.Values{}
+		if rendition := r.URL.Query().Get("rendition"); rendition != "" {
+			q.Set("rendition", rendition)
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		http.Redirect(w, r, cfg.streamPath(target, video, q), http.StatusFound)
+		return
+	}
+
+	playbackURL, err := cfg.videoDeliveryURL(r.Context(), target, video, key)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate playback URL", err)
+		return
+	}
+
+	cfg.recordAccess(r, video, accessKindPlayback)
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, playbackURL, http.StatusFound)
+}
+
+// playbackDenial runs the checks every path that hands out a video's files
+// makes first: that the requester may see it at all, has unlocked it if
+// it's passphrase-protected and passes its age gate, and that its owner's
+// suspension doesn't block playback. If one fails it returns the status
+// and message to respond with, along with the error if a check couldn't be
+// made. Hotlink protection needs the video's target, see requirePlayback.
+func (cfg *apiConfig) playbackDenial(r *http.Request, video database.Video) (code int, msg string, err error) {
+	if video.ID == uuid.Nil || !cfg.canView(r, video) {
+		return http.StatusNotFound, "Video not found", nil
+	}
+	if msg, ok := cfg.passesPassphrase(r, video); !ok {
+		return http.StatusUnauthorized, msg, nil
+	}
+	if msg, ok := cfg.passesAgeGate(r, video); !ok {
+		return http.StatusForbidden, msg, nil
+	}
+	blocked, err := cfg.playbackBlocked(video)
+	if err != nil {
+		return http.StatusInternalServerError, "Couldn't get video owner", err
+	}
+	if blocked {
+		return http.StatusForbidden, "This video is unavailable", nil
+	}
+	return 0, "", nil
+}
+
+// requirePlayback responds with the first of playbackDenial's checks that
+// fails, then applies the hotlink protection of the video's target. It
+// returns the target, and whether the request may go on.
+func (cfg *apiConfig) requirePlayback(w http.ResponseWriter, r *http.Request, video database.Video) (tenants.Target, bool) {
+	if code, msg, err := cfg.playbackDenial(r, video); msg != "" {
+		respondWithError(w, code, msg, err)
+		return tenants.Target{}, false
+	}
+	target, err := cfg.videoTarget(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't locate video file", err)
+		return tenants.Target{}, false
+	}
+	if !cfg.allowPlayback(w, r, target, video) {
+		return tenants.Target{}, false
+	}
+	return target, true
+}
+
+// pickRendition points video's VideoURL at the file a ?rendition= value
+// asks for: the tone-mapped copy for "sdr", or the rendition of that name.
+// The source is kept for "" and for renditions the video doesn't have.
+func (cfg *apiConfig) pickRendition(video *database.Video, rendition string) error {
+	switch rendition {
+	case "":
+	case "sdr":
+		if video.SDRVideoURL != nil {
+			video.VideoURL = video.SDRVideoURL
+		}
+	default:
+		renditions, err := cfg.db.GetRenditions(video.ID)
+		if err != nil {
+			return err
+		}
+		for _, rd := range renditions {
+			if rd.Name == rendition {
+				video.VideoURL = &rd.URL
+				break
+			}
+		}
+	}
+	return nil
+}
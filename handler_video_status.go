@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
+	"github.com/google/uuid"
+)
+
+// videoStatus is how a video's latest upload is going, and how full the
+// processing queue is. The processing job, if the server still has it,
+// adds its queue position, estimates and progress. HLSRenditions have the
+// status of each HLS rendition, some of which may still be retried after
+// the video is ready.
+type videoStatus struct {
+	VideoID          uuid.UUID               `json:"video_id"`
+	ProcessingStatus string                  `json:"processing_status"`
+	ProcessingError  string                  `json:"processing_error,omitempty"`
+	Queue            processingQueueStatus   `json:"queue"`
+	HLSRenditions    []database.HLSRendition `json:"hls_renditions,omitempty"`
+	*jobs.Estimate
+}
+
+func (cfg *apiConfig) handlerVideoStatus(w http.ResponseWriter, r *http.Request) {
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't view this video's status", nil)
+		return
+	}
+
+	status := videoStatus{
+		VideoID:          videoID,
+		ProcessingStatus: video.ProcessingStatus,
+		ProcessingError:  video.ProcessingError,
+		Queue:            cfg.processingQueueStatus(),
+	}
+	status.HLSRenditions, err = cfg.db.GetHLSRenditions(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get renditions", err)
+		return
+	}
+	est, err := cfg.jobs.Status(videoID)
+	if errors.Is(err, jobs.ErrJobNotFound) {
+		if video.ProcessingStatus == "" {
+			respondWithError(w, http.StatusNotFound, "No processing job found for video", err)
+			return
+		}
+		respondWithJSON(w, http.StatusOK, status)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get job status", err)
+		return
+	}
+	cfg.refreshInvalidations(r.Context(), &est)
+	status.Estimate = &est
+
+	respondWithJSON(w, http.StatusOK, status)
+}
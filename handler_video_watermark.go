@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ffmpeg"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tenants"
+	"github.com/google/uuid"
+)
+
+const flagViewerWatermark = "viewer_watermark"
+
+// handlerVideoWatermarked redirects the viewer to a copy of the video with
+// their identity burned in, generating and caching it on first request. It
+// is only available for owners who have the viewer_watermark flag enabled,
+// and to viewers who may play the video, see requirePlayback.
+func (cfg *apiConfig) handlerVideoWatermarked(w http.ResponseWriter, r *http.Request) {
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	viewerID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+	viewer, err := cfg.db.GetUser(viewerID)
+	if err != nil || viewer == nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find viewer", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil || video.VideoURL == nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+
+	target, ok := cfg.requirePlayback(w, r, video)
+	if !ok {
+		return
+	}
+	sourceKey, ok := storedObjectKey(target, *video.VideoURL)
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't locate video file", fmt.Errorf("video URL %q is not in bucket %s", *video.VideoURL, target.Bucket))
+		return
+	}
+	owner, err := cfg.db.GetUser(video.UserID)
+	if err != nil || owner == nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't find video owner", err)
+		return
+	}
+	if !cfg.featureEnabled(flagViewerWatermark, owner.ID, owner.TenantID) {
+		respondWithError(w, http.StatusNotFound, "Watermarked playback is not enabled for this video", nil)
+		return
+	}
+
+	key := videoObjectKey(video.UserID, video.ID, fmt.Sprintf("watermarks/%s.mp4", viewerID))
+	exists, err := objectExists(r.Context(), target, key)
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Couldn't check watermarked copy", err)
+		return
+	}
+	if !exists {
+		text := fmt.Sprintf("%s %s", viewer.Email, viewer.ID)
+		err = cfg.jobs.Run(uuid.New(), 0, func() error {
+			return createWatermarkedCopy(r.Context(), target, sourceKey, key, text)
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't create watermarked copy", err)
+			return
+		}
+	}
+
+	playbackURL, err := cfg.videoDeliveryURL(r.Context(), target, video, key)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate playback URL", err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, playbackURL, http.StatusFound)
+}
+
+func createWatermarkedCopy(ctx context.Context, target tenants.Target, sourceKey, destKey, text string) error {
+	sourcePath, err := downloadToTemp(ctx, target, sourceKey, "tubely-watermark-src-*.mp4")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(sourcePath)
+
+	outputPath := sourcePath + ".watermarked"
+	defer os.Remove(outputPath)
+	if _, err := ffmpeg.WatermarkCommand(sourcePath, outputPath, text).Run(ctx); err != nil {
+		return err
+	}
+
+	return uploadFile(ctx, target, destKey, outputPath, "video/mp4")
+}
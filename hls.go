@@ -0,0 +1,467 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ffmpeg"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tenants"
+	"github.com/google/uuid"
+	"golang.org/x/text/language"
+)
+
+// With HLS_OUTPUT enabled, uploads that aren't shorts are also encoded as
+// HLS renditions, stored under an hls-* prefix next to the video's MP4.
+// The bucket stays private, so players load the playlists through
+// GET /api/videos/{videoID}/hls/{file}, which rewrites the segments in them
+// to presigned URLs.
+
+// hlsMasterPlaylist is the name of the playlist that lists the renditions.
+const hlsMasterPlaylist = "master.m3u8"
+
+// maxPlaylistSize bounds how much of a stored playlist is read. A six
+// second segment takes about 60 bytes, so this covers days of video.
+const maxPlaylistSize = 4 << 20
+
+var hlsContentTypes = map[string]string{
+	".m3u8": "application/vnd.apple.mpegurl",
+	".m4s":  "video/iso.segment",
+	".mp4":  "video/mp4",
+}
+
+// createHLS encodes the smallest rendition of the video at input into a
+// temporary directory, in a subdirectory of its own, with a master
+// playlist listing it, so the video can be played as soon as it's
+// processed. Each audio track is encoded once, as an audio rendition of
+// its own that every video rendition plays with. The larger renditions are left pending for
+// backfillHLSRenditions to encode from the stored file, smallest first,
+// and add to the master playlist as each one finishes. A rendition that
+// fails to encode here is retried the same way and the next one up is
+// tried instead; only if every one fails does createHLS fail. It returns
+// the status of every rendition. The caller removes the directory.
+func (cfg *apiConfig) createHLS(ctx context.Context, input string) (string, []database.HLSRendition, error) {
+	probeOutput, err := cfg.probeVideo(ctx, input)
+	if err != nil {
+		return "", nil, err
+	}
+	stream, ok := probeOutput.firstStream("video")
+	if !ok || stream.Height == 0 {
+		return "", nil, errors.New("no video stream found")
+	}
+
+	dir, err := os.MkdirTemp("", "tubely-hls-*")
+	if err != nil {
+		return "", nil, err
+	}
+	audioTracks := probeOutput.audioTracks()
+	for _, track := range audioTracks {
+		if err := encodeHLSAudio(ctx, input, dir, track.Index); err != nil {
+			os.RemoveAll(dir)
+			return "", nil, err
+		}
+	}
+	now := cfg.clock.Now().UTC()
+	ladder := ffmpeg.HLSLadderFor(stream.Height)
+	renditions := make([]database.HLSRendition, len(ladder))
+	published := false
+	var encodeErr error
+	for i := len(ladder) - 1; i >= 0; i-- {
+		rung := ladder[i]
+		renditions[i] = database.HLSRendition{
+			Name: rung.Name,
+			// scale=-2 keeps the width even.
+			Width:         (stream.Width*rung.Height/stream.Height + 1) &^ 1,
+			Height:        rung.Height,
+			MaxrateKbps:   rung.MaxrateKbps,
+			Status:        database.HLSRenditionPending,
+			NextAttemptAt: &now,
+			UpdatedAt:     now,
+		}
+		if published {
+			continue
+		}
+		renditions[i].Attempts = 1
+		if err := encodeHLSRendition(ctx, input, dir, rung); err != nil {
+			if ctx.Err() != nil {
+				os.RemoveAll(dir)
+				return "", nil, err
+			}
+			log.Printf("Couldn't encode HLS rendition %s, retrying it later: %v", rung.Name, err)
+			encodeErr = err
+			next := now.Add(hlsRetryDelay)
+			renditions[i].Status = database.HLSRenditionRetrying
+			renditions[i].LastError = err.Error()
+			renditions[i].NextAttemptAt = &next
+			continue
+		}
+		renditions[i].Status = database.HLSRenditionReady
+		renditions[i].NextAttemptAt = nil
+		published = true
+	}
+	if !published {
+		os.RemoveAll(dir)
+		return "", nil, encodeErr
+	}
+	master := hlsMasterPlaylistFor(renditions, audioTracks)
+	if err := os.WriteFile(filepath.Join(dir, hlsMasterPlaylist), master, 0644); err != nil {
+		os.RemoveAll(dir)
+		return "", nil, err
+	}
+	return dir, renditions, nil
+}
+
+// encodeHLSRendition encodes input as the rung into its subdirectory of
+// dir. A failed encode leaves nothing behind.
+func encodeHLSRendition(ctx context.Context, input, dir string, rung ffmpeg.HLSRung) error {
+	rungDir := filepath.Join(dir, rung.Name)
+	if err := os.Mkdir(rungDir, 0755); err != nil {
+		return err
+	}
+	if _, err := ffmpeg.HLSCommand(input, filepath.Join(rungDir, "index.m3u8"), rung).Run(ctx); err != nil {
+		os.RemoveAll(rungDir)
+		return err
+	}
+	return nil
+}
+
+// encodeHLSAudio encodes input's audio track into its subdirectory of
+// dir. A failed encode leaves nothing behind.
+func encodeHLSAudio(ctx context.Context, input, dir string, track int) error {
+	trackDir := filepath.Join(dir, hlsAudioDir(track))
+	if err := os.Mkdir(trackDir, 0755); err != nil {
+		return err
+	}
+	if _, err := ffmpeg.HLSAudioCommand(input, filepath.Join(trackDir, "index.m3u8"), track).Run(ctx); err != nil {
+		os.RemoveAll(trackDir)
+		return err
+	}
+	return nil
+}
+
+// hlsAudioDir is the subdirectory of an HLS output an audio track's
+// rendition is in.
+func hlsAudioDir(track int) string {
+	return fmt.Sprintf("audio-%d", track)
+}
+
+// hlsAudioGroup is the GROUP-ID of the audio renditions.
+const hlsAudioGroup = "audio"
+
+// hlsMasterPlaylistFor writes the master playlist listing the ready
+// renditions, largest first, and the audio tracks as the audio group they
+// all play with. The default track, or else the first, is the one players
+// pick unless the viewer's language matches another.
+func hlsMasterPlaylistFor(renditions []database.HLSRendition, audioTracks []database.AudioTrack) []byte {
+	var master strings.Builder
+	master.WriteString("#EXTM3U\n#EXT-X-VERSION:7\n#EXT-X-INDEPENDENT-SEGMENTS\n")
+	defaultTrack := 0
+	for i, track := range audioTracks {
+		if track.IsDefault {
+			defaultTrack = i
+			break
+		}
+	}
+	names := map[string]bool{}
+	for i, track := range audioTracks {
+		attrs := fmt.Sprintf(`TYPE=AUDIO,GROUP-ID="%s",NAME="%s"`, hlsAudioGroup, hlsAudioName(track, names))
+		if lang := hlsLanguage(track.Language); lang != "" {
+			attrs += fmt.Sprintf(`,LANGUAGE="%s"`, lang)
+		}
+		if i == defaultTrack {
+			attrs += ",DEFAULT=YES"
+		} else {
+			attrs += ",DEFAULT=NO"
+		}
+		attrs += ",AUTOSELECT=YES"
+		if track.Channels > 0 {
+			attrs += fmt.Sprintf(`,CHANNELS="%d"`, track.Channels)
+		}
+		fmt.Fprintf(&master, "#EXT-X-MEDIA:%s,URI=\"%s/index.m3u8\"\n", attrs, hlsAudioDir(track.Index))
+	}
+	for _, r := range renditions {
+		if r.Status != database.HLSRenditionReady {
+			continue
+		}
+		if len(audioTracks) == 0 {
+			fmt.Fprintf(&master, "#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d\n%s/index.m3u8\n",
+				r.MaxrateKbps*1000, r.Width, r.Height, r.Name)
+			continue
+		}
+		fmt.Fprintf(&master, "#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d,AUDIO=\"%s\"\n%s/index.m3u8\n",
+			(r.MaxrateKbps+ffmpeg.HLSAudioBitrateKbps)*1000, r.Width, r.Height, hlsAudioGroup, r.Name)
+	}
+	return []byte(master.String())
+}
+
+// hlsAudioName names an audio track in the master playlist by its title,
+// else its language, else its number. Names must be unique within the
+// group, so later tracks with a name already in names get their number
+// added.
+func hlsAudioName(track database.AudioTrack, names map[string]bool) string {
+	name := hlsQuoted(track.Title)
+	if name == "" {
+		name = hlsQuoted(track.Language)
+	}
+	if name == "" || name == "und" {
+		name = fmt.Sprintf("Track %d", track.Index+1)
+	}
+	if names[name] {
+		name = fmt.Sprintf("%s (%d)", name, track.Index+1)
+	}
+	names[name] = true
+	return name
+}
+
+// hlsLanguage returns lang, as ffprobe reports it, as the BCP 47 tag the
+// LANGUAGE attribute takes, e.g. "en" for "eng", or "" if it's unknown.
+func hlsLanguage(lang string) string {
+	tag, err := language.Parse(lang)
+	if err != nil || tag == language.Und {
+		return ""
+	}
+	return tag.String()
+}
+
+// hlsQuoted makes s safe for a quoted-string attribute, which can't hold
+// double quotes or line breaks.
+func hlsQuoted(s string) string {
+	return strings.NewReplacer(`"`, "'", "\r", " ", "\n", " ").Replace(s)
+}
+
+// uploadHLS puts the files createHLS wrote into target under prefix and
+// returns the key of the master playlist. Uploaded files are deleted again
+// if cleanup runs without being committed.
+func uploadHLS(ctx context.Context, cleanup *cleanupStack, target tenants.Target, dir, prefix string) (string, error) {
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		key := prefix + "/" + filepath.ToSlash(rel)
+		if err := uploadFile(ctx, target, key, p, hlsContentTypes[filepath.Ext(p)]); err != nil {
+			return err
+		}
+		cleanup.deleteObject(target, key)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return prefix + "/" + hlsMasterPlaylist, nil
+}
+
+// hlsObjectKeys returns the keys of every object of the HLS output whose
+// master playlist is stored under masterKey, found by reading its
+// playlists. A missing playlist has nothing left to list.
+func hlsObjectKeys(ctx context.Context, target tenants.Target, masterKey string) ([]string, error) {
+	keys := []string{}
+	pending := []string{masterKey}
+	for len(pending) > 0 {
+		key := pending[0]
+		pending = pending[1:]
+		obj, err := target.Storage().Get(ctx, key)
+		if errors.Is(err, storage.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		playlist, err := io.ReadAll(io.LimitReader(obj, maxPlaylistSize))
+		obj.Close()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+
+		uris := []string{}
+		scanner := bufio.NewScanner(bytes.NewReader(playlist))
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case line == "":
+			case strings.HasPrefix(line, "#"):
+				for _, m := range hlsURIAttribute.FindAllStringSubmatch(line, -1) {
+					uris = append(uris, m[1])
+				}
+			default:
+				uris = append(uris, line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		for _, uri := range uris {
+			if strings.Contains(uri, "://") {
+				continue
+			}
+			uriKey := path.Join(path.Dir(key), uri)
+			if strings.HasSuffix(uri, ".m3u8") {
+				pending = append(pending, uriKey)
+			} else {
+				keys = append(keys, uriKey)
+			}
+		}
+	}
+	return keys, nil
+}
+
+// hlsPlaylistURL is the API path players load a video's master playlist
+// from.
+func hlsPlaylistURL(videoID uuid.UUID) string {
+	return fmt.Sprintf("/api/videos/%s/hls/%s", videoID, hlsMasterPlaylist)
+}
+
+// hlsURIAttribute matches the URI of tags such as EXT-X-MAP.
+var hlsURIAttribute = regexp.MustCompile(`URI="([^"]*)"`)
+
+// signPlaylist rewrites the segment and init URIs of playlist, which is
+// stored under dir, to presigned URLs, or to plain URLs on the distribution
+// if the client was given signed cookies for it. URIs of other playlists
+// stay relative, so players fetch them through the API as well, with query
+// added for the playback token.
+func (cfg *apiConfig) signPlaylist(ctx context.Context, target tenants.Target, video database.Video, dir string, playlist []byte, cookies bool, query string) ([]byte, error) {
+	sign := func(uri string) (string, error) {
+		if strings.Contains(uri, "://") {
+			return uri, nil
+		}
+		if strings.HasSuffix(uri, ".m3u8") {
+			if query != "" {
+				uri += "?" + query
+			}
+			return uri, nil
+		}
+		if u, ok := cfg.cdnObjectURL(target, path.Join(dir, uri)); ok && cookies {
+			return u, nil
+		}
+		return cfg.signStoredURL(ctx, target, video, path.Join(dir, uri))
+	}
+
+	var out bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(playlist))
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#"):
+			var signErr error
+			line = hlsURIAttribute.ReplaceAllStringFunc(line, func(attr string) string {
+				signed, err := sign(hlsURIAttribute.FindStringSubmatch(attr)[1])
+				if err != nil {
+					signErr = err
+				}
+				return `URI="` + signed + `"`
+			})
+			if signErr != nil {
+				return nil, signErr
+			}
+		default:
+			signed, err := sign(line)
+			if err != nil {
+				return nil, err
+			}
+			line = signed
+		}
+		out.WriteString(line)
+		out.WriteByte('\n')
+	}
+	return out.Bytes(), scanner.Err()
+}
+
+// handlerVideoHLS serves a video's HLS playlists with their segments
+// presigned, under the same checks as handlerVideoPlayback. With
+// CDN_COOKIE_DOMAIN set, it sets signed cookies for the video's HLS output
+// instead. Loading the master playlist counts as a playback.
+func (cfg *apiConfig) handlerVideoHLS(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+	file := r.PathValue("file")
+	if path.Ext(file) != ".m3u8" || path.Clean(file) != file || strings.HasPrefix(file, "../") || strings.HasPrefix(file, "/") {
+		respondWithError(w, http.StatusNotFound, "Playlist not found", nil)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.HLSURL == nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	target, ok := cfg.requirePlayback(w, r, video)
+	if !ok {
+		return
+	}
+	cfg.setNoIndex(w, target, video)
+	masterKey, ok := storedObjectKey(target, *video.HLSURL)
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't locate video file", fmt.Errorf("HLS URL %q is not in bucket %s", *video.HLSURL, target.Bucket))
+		return
+	}
+	key := path.Join(path.Dir(masterKey), file)
+
+	obj, err := target.Storage().Get(r.Context(), key)
+	if errors.Is(err, storage.ErrNotFound) {
+		respondWithError(w, http.StatusNotFound, "Playlist not found", nil)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Couldn't read playlist", err)
+		return
+	}
+	playlist, err := io.ReadAll(io.LimitReader(obj, maxPlaylistSize))
+	obj.Close()
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Couldn't read playlist", err)
+		return
+	}
+	// Private videos' segments are presigned one by one, never put on the
+	// distribution.
+	cookies := false
+	if video.Visibility != database.VisibilityPrivate {
+		cookies, err = cfg.setCDNCookies(w, target, path.Dir(masterKey))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't generate playback URL", err)
+			return
+		}
+	}
+	q := cfg.playbackQuery(target, video, url.Values{})
+	if token, _ := cfg.unlocked(r, video); token != "" {
+		q.Set(unlockParam, token)
+	}
+	query := q.Encode()
+	playlist, err = cfg.signPlaylist(r.Context(), target, video, path.Dir(key), playlist, cookies, query)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate playback URL", err)
+		return
+	}
+
+	if file == hlsMasterPlaylist {
+		cfg.recordAccess(r, video, accessKindPlayback)
+	}
+	w.Header().Set("Content-Type", hlsContentTypes[".m3u8"])
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(playlist)
+}
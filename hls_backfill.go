@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ffmpeg"
+	"github.com/google/uuid"
+)
+
+// Events about the HLS renditions encoded after a video is published.
+const (
+	eventRenditionReady  = "video.rendition_ready"
+	eventRenditionFailed = "video.rendition_failed"
+)
+
+// jobKindHLSBackfill is the processing queue's kind for HLS renditions
+// encoded after a video was published.
+const jobKindHLSBackfill = "hls-backfill"
+
+const (
+	// An HLS rendition that failed to encode is retried after
+	// hlsRetryDelay, doubling after each attempt, until it has been tried
+	// hlsMaxAttempts times, counting the one during processing.
+	hlsRetryDelay       = time.Minute
+	hlsMaxAttempts      = 4
+	hlsBackfillInterval = 30 * time.Second
+	hlsBackfillBatch    = 10
+)
+
+// hlsBackfill encodes the HLS renditions a video was published without.
+type hlsBackfill struct {
+	wake chan struct{}
+}
+
+func newHLSBackfill() *hlsBackfill {
+	return &hlsBackfill{wake: make(chan struct{}, 1)}
+}
+
+// notify wakes the backfill after a video was published with renditions
+// pending, if it isn't awake already.
+func (b *hlsBackfill) notify() {
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+}
+
+// runHLSBackfill encodes pending and failed HLS renditions as they come
+// due until ctx is done. Renditions a restart interrupted are encoded
+// again.
+func (cfg *apiConfig) runHLSBackfill(ctx context.Context) {
+	if err := cfg.db.RequeueEncodingHLSRenditions(cfg.clock.Now().UTC()); err != nil {
+		log.Printf("Couldn't requeue interrupted HLS renditions: %v", err)
+	}
+	ticker := time.NewTicker(hlsBackfillInterval)
+	defer ticker.Stop()
+	for {
+		cfg.backfillHLSRenditions(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-cfg.hlsBackfill.wake:
+		}
+	}
+}
+
+// backfillHLSRenditions encodes the renditions that are due, one at a
+// time, so they don't crowd out new uploads.
+func (cfg *apiConfig) backfillHLSRenditions(ctx context.Context) {
+	for ctx.Err() == nil {
+		renditions, err := cfg.db.GetDueHLSRenditions(cfg.clock.Now().UTC(), hlsBackfillBatch)
+		if err != nil {
+			log.Printf("Couldn't get due HLS renditions: %v", err)
+			return
+		}
+		for _, r := range renditions {
+			if ctx.Err() != nil {
+				return
+			}
+			cfg.backfillHLSRendition(ctx, r)
+		}
+		if len(renditions) < hlsBackfillBatch {
+			return
+		}
+	}
+}
+
+// backfillHLSRendition makes an attempt at encoding the rendition and
+// adding it to the video's master playlist, and records how it went.
+func (cfg *apiConfig) backfillHLSRendition(ctx context.Context, r database.HLSRendition) {
+	r.Status = database.HLSRenditionEncoding
+	r.UpdatedAt = cfg.clock.Now().UTC()
+	ok, err := cfg.db.UpdateHLSRendition(r)
+	if err != nil {
+		log.Printf("Couldn't update HLS rendition %s of video %s: %v", r.Name, r.VideoID, err)
+		return
+	}
+	if !ok {
+		// Gone with the output it belonged to.
+		return
+	}
+
+	// A video that can't be read fails the attempt in
+	// publishHLSRendition, which reads it again.
+	duration := 0.0
+	if video, err := cfg.db.GetVideo(r.VideoID); err == nil {
+		duration = mediaDuration(video)
+	}
+	err = cfg.jobs.RunKind(jobKindHLSBackfill, r.VideoID, duration, func() error {
+		return cfg.publishHLSRendition(ctx, r)
+	})
+	if ctx.Err() != nil {
+		// Shutting down; the attempt doesn't count, and the rendition is
+		// requeued on the next start.
+		return
+	}
+	now := cfg.clock.Now().UTC()
+	r.Attempts++
+	r.UpdatedAt = now
+	switch {
+	case err == nil:
+		r.Status, r.LastError, r.NextAttemptAt = database.HLSRenditionReady, "", nil
+		log.Printf("Published HLS rendition %s of video %s", r.Name, r.VideoID)
+	case r.Attempts >= hlsMaxAttempts || errors.Is(err, errHLSOutputReplaced):
+		r.Status, r.LastError, r.NextAttemptAt = database.HLSRenditionFailed, err.Error(), nil
+		log.Printf("Giving up on HLS rendition %s of video %s after %d attempts: %v", r.Name, r.VideoID, r.Attempts, err)
+	default:
+		next := now.Add(hlsRetryDelay << (r.Attempts - 1))
+		r.Status, r.LastError, r.NextAttemptAt = database.HLSRenditionRetrying, err.Error(), &next
+		log.Printf("Couldn't encode HLS rendition %s of video %s, retrying it later: %v", r.Name, r.VideoID, err)
+	}
+	ok, err = cfg.db.UpdateHLSRendition(r)
+	if err != nil {
+		log.Printf("Couldn't update HLS rendition %s of video %s: %v", r.Name, r.VideoID, err)
+		return
+	}
+	if !ok {
+		return
+	}
+	data := map[string]any{"rendition": r.Name, "width": r.Width, "height": r.Height, "attempts": r.Attempts}
+	switch r.Status {
+	case database.HLSRenditionReady:
+		cfg.emitEvent(eventRenditionReady, r.VideoID, data)
+	case database.HLSRenditionFailed:
+		data["error"] = r.LastError
+		cfg.emitEvent(eventRenditionFailed, r.VideoID, data)
+	}
+}
+
+// mediaDuration is how long the video's stored file is, in seconds, or 0
+// if its metadata wasn't read.
+func mediaDuration(video database.Video) float64 {
+	if video.Metadata == nil {
+		return 0
+	}
+	return video.Metadata.DurationSeconds
+}
+
+var errHLSOutputReplaced = errors.New("the video's HLS output has been replaced or removed")
+
+// publishHLSRendition encodes r from the video's stored file, the SDR one
+// if it has one as during processing, uploads it next to the other
+// renditions and rewrites the master playlist to list it.
+func (cfg *apiConfig) publishHLSRendition(ctx context.Context, r database.HLSRendition) error {
+	video, err := cfg.db.GetVideo(r.VideoID)
+	if err != nil {
+		return err
+	}
+	if video.ID == uuid.Nil || video.HLSURL == nil || *video.HLSURL != r.MasterKey {
+		return errHLSOutputReplaced
+	}
+	target, err := cfg.videoTarget(ctx, video)
+	if err != nil {
+		return err
+	}
+	sourceURL := video.VideoURL
+	if video.SDRVideoURL != nil {
+		sourceURL = video.SDRVideoURL
+	}
+	if sourceURL == nil {
+		return errHLSOutputReplaced
+	}
+	sourceKey, ok := storedObjectKey(target, *sourceURL)
+	if !ok {
+		return fmt.Errorf("video URL %q is not in bucket %s", *sourceURL, target.Bucket)
+	}
+	masterKey, ok := storedObjectKey(target, r.MasterKey)
+	if !ok {
+		return fmt.Errorf("HLS URL %q is not in bucket %s", r.MasterKey, target.Bucket)
+	}
+
+	cleanup := &cleanupStack{}
+	defer cleanup.run()
+
+	input, err := downloadToTemp(ctx, target, sourceKey, "tubely-hls-source-*.mp4")
+	if err != nil {
+		return err
+	}
+	cleanup.removeFile(input)
+	dir, err := os.MkdirTemp("", "tubely-hls-*")
+	if err != nil {
+		return err
+	}
+	cleanup.always("remove HLS output", func() error { return os.RemoveAll(dir) })
+	rung := ffmpeg.HLSRung{Name: r.Name, Height: r.Height, MaxrateKbps: r.MaxrateKbps}
+	if err := encodeHLSRendition(ctx, input, dir, rung); err != nil {
+		return err
+	}
+	size, err := storedSize(filepath.Join(dir, r.Name))
+	if err != nil {
+		return err
+	}
+	if _, err := uploadHLS(ctx, cleanup, target, dir, path.Dir(masterKey)); err != nil {
+		return err
+	}
+
+	// Other renditions may have been published since r was read, so the
+	// playlist is written from their current status.
+	renditions, err := cfg.db.GetHLSRenditions(video.ID)
+	if err != nil {
+		return err
+	}
+	for i := range renditions {
+		if renditions[i].Name == r.Name {
+			renditions[i].Status = database.HLSRenditionReady
+		}
+	}
+	// The audio renditions were encoded along with the first video one,
+	// from the tracks of the same file.
+	audioTracks, err := cfg.db.GetAudioTracks(video.ID)
+	if err != nil {
+		return err
+	}
+	masterPath := filepath.Join(dir, hlsMasterPlaylist)
+	if err := os.WriteFile(masterPath, hlsMasterPlaylistFor(renditions, audioTracks), 0644); err != nil {
+		return err
+	}
+	if err := uploadFile(ctx, target, masterKey, masterPath, hlsContentTypes[".m3u8"]); err != nil {
+		return err
+	}
+	cleanup.commit()
+
+	// The rendition adds to the video's files rather than replacing them.
+	used, err := cfg.db.GetStorageUsage(video.ID, database.StorageKindVideo)
+	if err == nil {
+		err = cfg.db.SetStorageUsage(video.UserID, video.ID, database.StorageKindVideo, used+size)
+	}
+	if err != nil {
+		log.Printf("Couldn't update storage usage of video %s: %v", video.ID, err)
+	}
+	return nil
+}
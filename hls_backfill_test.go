@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/fakes"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ffmpeg"
+)
+
+func TestPublishHLSRendition(t *testing.T) {
+	errEncode := errors.New("encoder crashed")
+	tests := []struct {
+		name string
+		// fail makes the 720p encode fail with errEncode.
+		fail bool
+		// replaced points the rendition at an output the video no longer
+		// has.
+		replaced bool
+		wantErr  error
+	}{
+		{name: "published"},
+		{name: "encode fails", fail: true, wantErr: errEncode},
+		{name: "output replaced", replaced: true, wantErr: errHLSOutputReplaced},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s3 := fakes.NewS3(testBucket)
+			defer s3.Close()
+			fake := newTestFFmpeg()
+			cfg := newTestConfig(t, s3, fake)
+			cfg.hlsOutput = true
+			video, token := newTestVideo(t, cfg)
+			video = uploadTestVideo(t, cfg, video, token)
+			if video.HLSURL == nil {
+				t.Fatalf("upload wasn't published as HLS: %s", video.ProcessingError)
+			}
+			master := *video.HLSURL
+			before, _ := s3.Object(testBucket, master)
+			if strings.Contains(string(before), "720p") {
+				t.Fatalf("720p was published with the upload:\n%s", before)
+			}
+
+			renditions, err := cfg.db.GetHLSRenditions(video.ID)
+			if err != nil {
+				t.Fatalf("GetHLSRenditions: %v", err)
+			}
+			var r database.HLSRendition
+			for _, rendition := range renditions {
+				if rendition.Name == "720p" {
+					r = rendition
+				}
+			}
+			if r.Status != database.HLSRenditionPending {
+				t.Fatalf("720p is %s, want it pending", r.Status)
+			}
+			if tt.fail {
+				fake.On(ffmpeg.FFmpegPath, fakes.Response{Err: errEncode}, "-f", "hls", "-maxrate", "2800k")
+			}
+			if tt.replaced {
+				r.MasterKey = path.Dir(master) + "-old/" + hlsMasterPlaylist
+			}
+
+			err = cfg.publishHLSRendition(context.Background(), r)
+			rungKey := path.Dir(master) + "/720p/index.m3u8"
+			after, _ := s3.Object(testBucket, master)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("publishHLSRendition() = %v, want %v", err, tt.wantErr)
+				}
+				if _, ok := s3.Object(testBucket, rungKey); ok {
+					t.Error("720p was stored")
+				}
+				if string(after) != string(before) {
+					t.Errorf("master playlist was rewritten:\n%s", after)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("publishHLSRendition(): %v", err)
+			}
+			if _, ok := s3.Object(testBucket, rungKey); !ok {
+				t.Error("720p wasn't stored")
+			}
+			// Rewritten masters keep the audio group of the upload.
+			for _, want := range []string{"720p/index.m3u8", "480p/index.m3u8", `AUDIO="audio"`, `URI="audio-0/index.m3u8"`} {
+				if !strings.Contains(string(after), want) {
+					t.Errorf("master playlist doesn't have %s:\n%s", want, after)
+				}
+			}
+		})
+	}
+}
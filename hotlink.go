@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/clock"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tenants"
+	"github.com/google/uuid"
+)
+
+// A target's Hotlink settings restrict its videos' playback endpoints:
+// GET /api/videos/{videoID}/playback, its HLS playlists and watermarked
+// copies. The URLs those redirect to expire with PRESIGN_EXPIRY anyway, so
+// the stable API URLs are the ones worth protecting.
+
+// playbackToken signs playback of the video with videoID until expires.
+func (cfg *apiConfig) playbackToken(videoID uuid.UUID, expires time.Time) string {
+	mac := hmac.New(sha256.New, []byte(cfg.jwtSecret))
+	fmt.Fprintf(mac, "playback|%s|%d", videoID, expires.Unix())
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// playbackQuery adds the token playback of video needs in target to q, if
+// any, valid for cfg.presignExpiry, and the share key if video is unlisted.
+func (cfg *apiConfig) playbackQuery(target tenants.Target, video database.Video, q url.Values) url.Values {
+	if video.Visibility == database.VisibilityUnlisted {
+		q.Set(shareKeyParam, video.ShareKey)
+	}
+	if target.Hotlink == nil || !target.Hotlink.RequireToken {
+		return q
+	}
+	expires := cfg.clock.Now().Add(cfg.presignExpiry)
+	q.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	q.Set("token", cfg.playbackToken(video.ID, expires))
+	return q
+}
+
+// playbackPath is the path of the playback endpoint of video, with q and
+// the token and share key target and video need.
+func (cfg *apiConfig) playbackPath(target tenants.Target, video database.Video, q url.Values) string {
+	p := fmt.Sprintf("/api/videos/%s/playback", video.ID)
+	if q = cfg.playbackQuery(target, video, q); len(q) > 0 {
+		p += "?" + q.Encode()
+	}
+	return p
+}
+
+func (cfg *apiConfig) validPlaybackToken(r *http.Request, videoID uuid.UUID) bool {
+	q := r.URL.Query()
+	expires, err := strconv.ParseInt(q.Get("expires"), 10, 64)
+	if err != nil || clock.Expired(cfg.clock, time.Unix(expires, 0)) {
+		return false
+	}
+	want := cfg.playbackToken(videoID, time.Unix(expires, 0))
+	return hmac.Equal([]byte(q.Get("token")), []byte(want))
+}
+
+// allowPlayback applies target's hotlink protection to a playback request,
+// responding with 403 if it fails. Pages of the site itself are always
+// allowed, and signed-in viewers don't need a token.
+func (cfg *apiConfig) allowPlayback(w http.ResponseWriter, r *http.Request, target tenants.Target, video database.Video) bool {
+	h := target.Hotlink
+	if h == nil {
+		return true
+	}
+	if !cfg.allowReferrer(w, r, h) {
+		return false
+	}
+	if h.RequireToken && cfg.viewerID(r) == nil && !cfg.validPlaybackToken(r, video.ID) {
+		respondWithError(w, http.StatusForbidden, "Playback link is invalid or expired", nil)
+		return false
+	}
+	return true
+}
+
+// allowReferrer checks that the page r came from may play h's videos,
+// responding with 403 if it may not. Pages of the site itself always may.
+func (cfg *apiConfig) allowReferrer(w http.ResponseWriter, r *http.Request, h *tenants.Hotlink) bool {
+	if host, ok := cfg.referrerAllowed(r, h); !ok {
+		respondWithError(w, http.StatusForbidden, "Playback isn't allowed from this site", fmt.Errorf("referrer %q isn't allowed", host))
+		return false
+	}
+	return true
+}
+
+// referrerAllowed reports whether the page r came from, on host, may play
+// h's videos.
+func (cfg *apiConfig) referrerAllowed(r *http.Request, h *tenants.Hotlink) (host string, ok bool) {
+	if u, err := url.Parse(r.Header.Get("Referer")); err == nil {
+		host = u.Hostname()
+	}
+	site, _ := url.Parse(cfg.siteURL)
+	return host, h.AllowsReferrer(host) || (host != "" && host == site.Hostname())
+}
+
+// setNoIndex asks search engines not to index a response about video if
+// target keeps videos that aren't publicly listed out of search results.
+func (cfg *apiConfig) setNoIndex(w http.ResponseWriter, target tenants.Target, video database.Video) {
+	if target.NoIndexUnlisted && (!video.Published(cfg.clock.Now()) || video.ModerationHold || video.Visibility != database.VisibilityPublic) {
+		w.Header().Set("X-Robots-Tag", "noindex")
+	}
+}
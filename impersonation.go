@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Impersonation tokens last defaultImpersonationTTL unless the admin asks
+// for less, and never longer than maxImpersonationTTL. They can't be
+// refreshed.
+const (
+	defaultImpersonationTTL = 15 * time.Minute
+	maxImpersonationTTL     = time.Hour
+)
+
+// handlerAdminImpersonate issues a short-lived access token for a user so
+// support staff can reproduce an issue as them. The admin has to give a
+// reason, which goes on the user's activity timeline along with every
+// change made with the token.
+func (cfg *apiConfig) handlerAdminImpersonate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Reason           string `json:"reason"`
+		ExpiresInSeconds int    `json:"expires_in_seconds"`
+	}
+	type response struct {
+		database.User
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+
+	adminID, ok := cfg.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	params.Reason = strings.TrimSpace(params.Reason)
+	if params.Reason == "" {
+		respondWithError(w, http.StatusBadRequest, "A reason is required to impersonate a user", nil)
+		return
+	}
+	ttl := defaultImpersonationTTL
+	if params.ExpiresInSeconds != 0 {
+		ttl = time.Duration(params.ExpiresInSeconds) * time.Second
+		if ttl < 0 || ttl > maxImpersonationTTL {
+			respondWithError(w, http.StatusBadRequest, "expires_in_seconds must be between 1 and 3600", nil)
+			return
+		}
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+	// Acting as an admin would hand out admin access without the audit
+	// trail requireAdmin relies on.
+	if cfg.isAdmin(user) {
+		respondWithError(w, http.StatusForbidden, "Admins can't be impersonated", nil)
+		return
+	}
+
+	token, err := auth.MakeIdentityJWT(auth.Identity{
+		UserID:         user.ID,
+		Tenant:         user.TenantID,
+		AgeVerified:    user.AgeVerified,
+		Suspended:      user.Suspended(),
+		ImpersonatorID: adminID,
+	}, cfg.jwtSecret, ttl)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create access JWT", err)
+		return
+	}
+
+	log.Printf("Admin %s started impersonating user %s: %s", adminID, user.ID, params.Reason)
+	cfg.recordActivity(user.ID, activityImpersonationStarted, nil, &adminID, params.Reason)
+	respondWithJSON(w, http.StatusOK, response{
+		User:      *user,
+		Token:     token,
+		ExpiresAt: cfg.clock.Now().UTC().Add(ttl),
+	})
+}
+
+// impersonationMiddleware enforces the limits of impersonation tokens:
+// nothing can be deleted with one, and every other change made with one is
+// recorded on the user's activity timeline. Responses say who is
+// impersonating, so the UI can show it. Requests without a valid
+// impersonation token pass through untouched.
+func (cfg *apiConfig) impersonationMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, err := auth.GetBearerToken(r.Header)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		id, err := auth.ValidateIdentityJWT(token, cfg.jwtSecret)
+		if err != nil || id.ImpersonatorID == uuid.Nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Tubely-Impersonator", id.ImpersonatorID.String())
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		case http.MethodDelete:
+			respondWithError(w, http.StatusForbidden, "Impersonation tokens can't delete", nil)
+			return
+		default:
+			cfg.recordActivity(id.UserID, activityImpersonatedChange, nil, &id.ImpersonatorID, r.Method+" "+r.URL.Path)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireOwnSession is requireUser for handlers that mint or revoke
+// credentials: an admin impersonating the user gets a 403, so nothing they
+// do lasts past their impersonation token.
+func (cfg *apiConfig) requireOwnSession(w http.ResponseWriter, r *http.Request) (userID uuid.UUID, ok bool) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return uuid.Nil, false
+	}
+	id, err := auth.ValidateIdentityJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return uuid.Nil, false
+	}
+	if id.ImpersonatorID != uuid.Nil {
+		respondWithError(w, http.StatusForbidden, "Impersonation tokens can't manage credentials", nil)
+		return uuid.Nil, false
+	}
+	return id.UserID, true
+}
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Partners bulk-ingest videos by submitting a manifest of the files they
+// will upload, signed with their manifest signing key: X-Tubely-Timestamp
+// is the Unix time of the request, and X-Tubely-Signature is
+// cacheWebhookSignature of it and the body, as with webhooks. The server
+// creates a private video and a direct upload for each file, and
+// publishes the videos together with the manifest's visibility once every
+// file has been uploaded, matched the size and SHA-256 the manifest gave
+// for it, and been processed.
+
+const (
+	ingestManifestMaxBody = 1 << 20
+	// ingestManifestMaxAge is how far a manifest's timestamp can be from
+	// the server's clock, which stops a captured request from being
+	// replayed.
+	ingestManifestMaxAge = 5 * time.Minute
+	maxIngestItems       = 100
+)
+
+var ingestReferenceLimit = textLimit{field: "reference", maxRunes: 200, maxBytes: 200, required: true}
+
+// ingestManifest is what partners submit. Items are uploaded in the
+// order given.
+type ingestManifest struct {
+	Reference  string               `json:"reference"`
+	Visibility string               `json:"visibility"`
+	Items      []ingestManifestItem `json:"items"`
+}
+
+type ingestManifestItem struct {
+	Filename    string   `json:"filename"`
+	ContentType string   `json:"content_type"`
+	Size        int64    `json:"size"`
+	SHA256      string   `json:"sha256"`
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	Tags        []string `json:"tags"`
+	// ExternalIDs are the new video's, as when creating one.
+	ExternalIDs map[string]string `json:"external_ids"`
+}
+
+// handlerIngestKeyCreate creates the user's manifest signing key,
+// replacing any they had, which stops manifests signed with the old one
+// from being accepted. The key is only ever in this response. It needs an
+// access JWT, not an API key, so a leaked API key can't be used to sign
+// manifests, and not an impersonation token.
+func (cfg *apiConfig) handlerIngestKeyCreate(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Secret    string    `json:"secret"`
+		CreatedAt time.Time `json:"created_at"`
+	}
+
+	userID, ok := cfg.requireOwnSession(w, r)
+	if !ok {
+		return
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create signing key", err)
+		return
+	}
+	key := database.IngestKey{
+		UserID:    userID,
+		Secret:    "igsec_" + hex.EncodeToString(secret),
+		CreatedAt: cfg.clock.Now().UTC(),
+	}
+	if err := cfg.db.SetIngestKey(key); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save signing key", err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, response{Secret: key.Secret, CreatedAt: key.CreatedAt})
+}
+
+// handlerIngestKeyDelete removes the user's manifest signing key.
+// Impersonation tokens can't remove it.
+func (cfg *apiConfig) handlerIngestKeyDelete(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.requireOwnSession(w, r)
+	if !ok {
+		return
+	}
+
+	deleted, err := cfg.db.DeleteIngestKey(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete signing key", err)
+		return
+	}
+	if !deleted {
+		respondWithError(w, http.StatusNotFound, "Signing key not found", nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerIngestManifestSubmit checks a signed manifest and starts its
+// batch: a private video for each item, with a direct upload of its file
+// that the partner completes like any other. It responds 201 with the
+// batch and the uploads, in the manifest's order. Submitting a manifest
+// with a reference the partner has used before responds 200 with that
+// batch instead, so a retried submission doesn't create it twice.
+func (cfg *apiConfig) handlerIngestManifestSubmit(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		database.IngestBatch
+		Uploads []directUploadResponse `json:"uploads,omitempty"`
+	}
+
+	userID, tenantID, ok := cfg.requireUploader(w, r)
+	if !ok {
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, ingestManifestMaxBody))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	key, err := cfg.db.GetIngestKey(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get signing key", err)
+		return
+	}
+	if key == nil {
+		respondWithError(w, http.StatusForbidden, "Create a signing key to submit manifests", nil)
+		return
+	}
+	timestamp := r.Header.Get("X-Tubely-Timestamp")
+	sent, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || cfg.clock.Now().Sub(time.Unix(sent, 0)).Abs() > ingestManifestMaxAge {
+		respondWithError(w, http.StatusUnauthorized, "Invalid manifest timestamp", err)
+		return
+	}
+	if !hmac.Equal([]byte(r.Header.Get("X-Tubely-Signature")), []byte(cacheWebhookSignature([]byte(key.Secret), timestamp, body))) {
+		respondWithError(w, http.StatusUnauthorized, "Invalid manifest signature", nil)
+		return
+	}
+
+	manifest := ingestManifest{}
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if manifest.Reference, err = ingestReferenceLimit.apply(manifest.Reference); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	switch manifest.Visibility {
+	case "":
+		manifest.Visibility = database.VisibilityPublic
+	case database.VisibilityPublic, database.VisibilityUnlisted, database.VisibilityPrivate:
+	default:
+		respondWithError(w, http.StatusBadRequest, "visibility must be public, unlisted or private", fmt.Errorf("unknown visibility %q", manifest.Visibility))
+		return
+	}
+	if len(manifest.Items) == 0 || len(manifest.Items) > maxIngestItems {
+		respondWithError(w, http.StatusBadRequest, "A manifest must list 1 to 100 files", fmt.Errorf("%d files", len(manifest.Items)))
+		return
+	}
+
+	existing, err := cfg.db.GetIngestBatchByReference(userID, manifest.Reference)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get ingest batch", err)
+		return
+	}
+	if existing != nil {
+		respondWithJSON(w, http.StatusOK, response{IngestBatch: *existing})
+		return
+	}
+
+	// Every item is checked before anything is created, so a bad one is
+	// reported before any uploads have been started.
+	var total int64
+	params := make([]database.CreateVideoParams, len(manifest.Items))
+	for i, item := range manifest.Items {
+		if item.Size <= 0 || item.Size > maxSessionUploadSize {
+			respondWithError(w, http.StatusBadRequest, "Each file needs a size of at most 1 GB", fmt.Errorf("file %d is %d bytes", i, item.Size))
+			return
+		}
+		if !sha256Pattern.MatchString(item.SHA256) {
+			respondWithError(w, http.StatusBadRequest, "sha256 must be 64 lowercase hex digits", fmt.Errorf("file %d", i))
+			return
+		}
+		if _, ok := cfg.requireVideoContainer(w, item.ContentType); !ok {
+			return
+		}
+		params[i] = database.CreateVideoParams{
+			Title:       ingestTitle(item.Title, ingestTitle(strings.TrimSuffix(item.Filename, path.Ext(item.Filename)), "Partner upload")),
+			Description: item.Description,
+			Tags:        item.Tags,
+			UserID:      userID,
+			ExternalIDs: item.ExternalIDs,
+		}
+		if err := normalizeVideoParams(&params[i]); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error(), err)
+			return
+		}
+		total += item.Size
+	}
+	if !cfg.requireStorageQuota(w, userID, uuid.Nil, database.StorageKindVideo, total) {
+		return
+	}
+
+	cleanup := &cleanupStack{}
+	defer cleanup.run()
+	batch := database.IngestBatch{
+		ID:         uuid.New(),
+		UserID:     userID,
+		Reference:  manifest.Reference,
+		Visibility: manifest.Visibility,
+		Status:     database.IngestBatchPending,
+		CreatedAt:  cfg.clock.Now().UTC(),
+	}
+	uploads := make([]directUploadResponse, 0, len(manifest.Items))
+	for i, item := range manifest.Items {
+		video, err := cfg.db.CreateVideo(params[i])
+		if errors.Is(err, database.ErrExternalIDTaken) {
+			respondWithError(w, http.StatusConflict, "External ID is already in use", err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't create video", err)
+			return
+		}
+		cleanup.onError("ingest video", func() error { return cfg.db.DeleteVideo(video.ID) })
+		if err := cfg.db.SetVideoVisibility(video.ID, database.VisibilityPrivate, ""); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't create video", err)
+			return
+		}
+		upload, ok := cfg.startDirectUpload(w, r, userID, tenantID, uploadSessionRequest{
+			VideoID:     video.ID,
+			Filename:    item.Filename,
+			ContentType: item.ContentType,
+			Size:        item.Size,
+		})
+		if !ok {
+			return
+		}
+		uploads = append(uploads, upload)
+		batch.Items = append(batch.Items, database.IngestItem{
+			VideoID:  video.ID,
+			BatchID:  batch.ID,
+			Filename: item.Filename,
+			Size:     item.Size,
+			SHA256:   item.SHA256,
+			Status:   database.IngestItemPending,
+		})
+	}
+
+	created, err := cfg.db.CreateIngestBatch(batch)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save ingest batch", err)
+		return
+	}
+	if !created {
+		// Another submission of the same manifest got there first.
+		existing, err := cfg.db.GetIngestBatchByReference(userID, manifest.Reference)
+		if err != nil || existing == nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get ingest batch", err)
+			return
+		}
+		respondWithJSON(w, http.StatusOK, response{IngestBatch: *existing})
+		return
+	}
+	cleanup.commit()
+	for i, item := range batch.Items {
+		cfg.recordActivity(userID, activityVideoCreated, &item.VideoID, nil, params[i].Title)
+	}
+	respondWithJSON(w, http.StatusCreated, response{IngestBatch: batch, Uploads: uploads})
+}
+
+// requireIngestBatch returns the batch in the path if it's the
+// requester's. If ok is false, an error response has been written.
+func (cfg *apiConfig) requireIngestBatch(w http.ResponseWriter, r *http.Request) (database.IngestBatch, bool) {
+	userID, _, ok := cfg.requireUploader(w, r)
+	if !ok {
+		return database.IngestBatch{}, false
+	}
+	batchID, err := uuid.Parse(r.PathValue("batchID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return database.IngestBatch{}, false
+	}
+	batch, err := cfg.db.GetIngestBatch(batchID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get ingest batch", err)
+		return database.IngestBatch{}, false
+	}
+	if batch == nil || batch.UserID != userID {
+		respondWithError(w, http.StatusNotFound, "Ingest batch not found", nil)
+		return database.IngestBatch{}, false
+	}
+	return *batch, true
+}
+
+// handlerIngestBatchGet returns a batch with how each of its items is
+// going.
+func (cfg *apiConfig) handlerIngestBatchGet(w http.ResponseWriter, r *http.Request) {
+	batch, ok := cfg.requireIngestBatch(w, r)
+	if !ok {
+		return
+	}
+	respondWithJSON(w, http.StatusOK, batch)
+}
+
+// handlerIngestBatchesList lists the requester's batches, newest first,
+// without their items.
+func (cfg *apiConfig) handlerIngestBatchesList(w http.ResponseWriter, r *http.Request) {
+	userID, _, ok := cfg.requireUploader(w, r)
+	if !ok {
+		return
+	}
+	limit := 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		var err error
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > 500 {
+			respondWithError(w, http.StatusBadRequest, "limit must be between 1 and 500", err)
+			return
+		}
+	}
+	batches, err := cfg.db.GetIngestBatches(userID, limit)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get ingest batches", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, batches)
+}
+
+// requireManifestHash checks an upload of a batch item's video against the
+// SHA-256 its manifest declared. If ok is false, an error response has been
+// written. Uploads of other videos always pass.
+func (cfg *apiConfig) requireManifestHash(w http.ResponseWriter, videoID uuid.UUID, sum string) (ok bool) {
+	item, err := cfg.db.GetIngestItem(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get ingest batch", err)
+		return false
+	}
+	if item != nil && item.SHA256 != sum {
+		if err := cfg.db.SetIngestItemStatus(videoID, database.IngestItemFailed, "file doesn't match the manifest"); err != nil {
+			log.Printf("Couldn't update ingest item of video %s: %v", videoID, err)
+		}
+		respondWithError(w, http.StatusUnprocessableEntity, "Uploaded file doesn't match its manifest", fmt.Errorf("SHA-256 is %s, not %s", sum, item.SHA256))
+		return false
+	}
+	return true
+}
+
+// ingestItemProcessed records how the processing of a batch item's upload
+// went, and publishes the batch once all of its items have verified. A
+// failed item can be uploaded again.
+func (cfg *apiConfig) ingestItemProcessed(videoID uuid.UUID, failure string) {
+	item, err := cfg.db.GetIngestItem(videoID)
+	if err != nil {
+		log.Printf("Couldn't get ingest item of video %s: %v", videoID, err)
+		return
+	}
+	if item == nil {
+		return
+	}
+	status := database.IngestItemVerified
+	if failure != "" {
+		status = database.IngestItemFailed
+	}
+	if err := cfg.db.SetIngestItemStatus(videoID, status, failure); err != nil {
+		log.Printf("Couldn't update ingest item of video %s: %v", videoID, err)
+		return
+	}
+	if failure != "" {
+		return
+	}
+
+	items, err := cfg.db.GetIngestItems(item.BatchID)
+	if err != nil {
+		log.Printf("Couldn't get items of ingest batch %s: %v", item.BatchID, err)
+		return
+	}
+	for _, i := range items {
+		if i.Status != database.IngestItemVerified {
+			return
+		}
+	}
+	batch, err := cfg.db.GetIngestBatch(item.BatchID)
+	if err != nil || batch == nil {
+		log.Printf("Couldn't get ingest batch %s: %v", item.BatchID, err)
+		return
+	}
+	published, err := cfg.db.PublishIngestBatch(batch.ID, cfg.clock.Now().UTC())
+	if err != nil {
+		log.Printf("Couldn't publish ingest batch %s: %v", batch.ID, err)
+		return
+	}
+	if !published {
+		return
+	}
+	for _, i := range items {
+		cfg.publishIngestItem(*batch, i.VideoID)
+	}
+}
+
+// publishIngestItem gives a video of a published batch the batch's
+// visibility.
+func (cfg *apiConfig) publishIngestItem(batch database.IngestBatch, videoID uuid.UUID) {
+	shareKey := ""
+	if batch.Visibility == database.VisibilityUnlisted {
+		var err error
+		if shareKey, err = newShareKey(); err != nil {
+			log.Printf("Couldn't generate share key for video %s: %v", videoID, err)
+			return
+		}
+	}
+	if err := cfg.db.SetVideoVisibility(videoID, batch.Visibility, shareKey); err != nil {
+		log.Printf("Couldn't publish video %s of ingest batch %s: %v", videoID, batch.ID, err)
+		return
+	}
+	cfg.signedURLs.invalidate(videoID)
+	cfg.emitEvent(eventVideoUpdated, videoID, map[string]any{"visibility": batch.Visibility, "ingest_batch_id": batch.ID})
+	if video, err := cfg.db.GetVideo(videoID); err == nil {
+		cfg.prefetchVideo(video)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/fakes"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func TestIngestKeyRequiresOwnSession(t *testing.T) {
+	tests := []struct {
+		name        string
+		impersonate bool
+		wantCreate  int
+		wantDelete  int
+	}{
+		{name: "own session", wantCreate: http.StatusCreated, wantDelete: http.StatusNoContent},
+		{name: "impersonation", impersonate: true, wantCreate: http.StatusForbidden, wantDelete: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s3 := fakes.NewS3(testBucket)
+			defer s3.Close()
+			cfg := newTestConfig(t, s3, fakes.NewFFmpeg())
+			video, _ := newTestVideo(t, cfg)
+			id := auth.Identity{UserID: video.UserID}
+			if tt.impersonate {
+				id.ImpersonatorID = uuid.New()
+			}
+			token, err := auth.MakeIdentityJWT(id, cfg.jwtSecret, time.Hour)
+			if err != nil {
+				t.Fatalf("MakeIdentityJWT: %v", err)
+			}
+			// The user's own key, which an impersonator mustn't be able to
+			// remove.
+			if err := cfg.db.SetIngestKey(database.IngestKey{UserID: video.UserID, Secret: "igsec_existing", CreatedAt: cfg.clock.Now()}); err != nil {
+				t.Fatalf("SetIngestKey: %v", err)
+			}
+
+			for _, step := range []struct {
+				method  string
+				handler http.HandlerFunc
+				want    int
+			}{
+				{http.MethodPost, cfg.handlerIngestKeyCreate, tt.wantCreate},
+				{http.MethodDelete, cfg.handlerIngestKeyDelete, tt.wantDelete},
+			} {
+				req := httptest.NewRequest(step.method, "/api/ingest/signing-key", nil)
+				req.Header.Set("Authorization", "Bearer "+token)
+				rec := httptest.NewRecorder()
+				step.handler(rec, req)
+				if rec.Code != step.want {
+					t.Fatalf("%s responded %d: %s, want %d", step.method, rec.Code, rec.Body, step.want)
+				}
+				if !tt.impersonate {
+					continue
+				}
+				var body struct {
+					Error string `json:"error"`
+				}
+				if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+					t.Fatalf("decoding %s response: %v", step.method, err)
+				}
+				if body.Error != "Impersonation tokens can't manage credentials" {
+					t.Errorf("%s error = %q, want the impersonation one", step.method, body.Error)
+				}
+			}
+
+			key, err := cfg.db.GetIngestKey(video.UserID)
+			if err != nil {
+				t.Fatalf("GetIngestKey: %v", err)
+			}
+			if tt.impersonate && (key == nil || key.Secret != "igsec_existing") {
+				t.Errorf("the user's key was changed: %+v", key)
+			}
+			if !tt.impersonate && key != nil {
+				t.Errorf("key %+v wasn't deleted", key)
+			}
+		})
+	}
+}
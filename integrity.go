@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tenants"
+	"github.com/google/uuid"
+)
+
+// withChecksumSHA256 sends the SHA-256 of an upload along with it, so S3
+// rejects the upload if what arrives doesn't match. Multipart uploads can't
+// carry a checksum of the whole object, so their parts are checksummed
+// instead.
+func withChecksumSHA256(sum []byte) func(*s3.PutObjectInput) {
+	return func(input *s3.PutObjectInput) {
+		input.ChecksumAlgorithm = types.ChecksumAlgorithmSha256
+		input.ChecksumSHA256 = aws.String(base64.StdEncoding.EncodeToString(sum))
+	}
+}
+
+func sha256File(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// hashStoredObject reads key from target and returns its hex SHA-256.
+func hashStoredObject(ctx context.Context, target tenants.Target, key string) (string, error) {
+	obj, err := target.Storage().Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	defer obj.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, obj); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// integrityCheck is the result of checking a video's stored file against
+// the digest recorded when it was uploaded. Method is "checksum" when S3's
+// own checksum of the object was compared, and "download" when the object
+// had to be read and hashed, as for multipart uploads and other backends,
+// and empty when the object is missing.
+type integrityCheck struct {
+	VideoID      uuid.UUID `json:"video_id"`
+	SHA256       string    `json:"sha256"`
+	StoredSHA256 string    `json:"stored_sha256,omitempty"`
+	Method       string    `json:"method,omitempty"`
+	Missing      bool      `json:"missing,omitempty"`
+	Verified     bool      `json:"verified"`
+	CheckedAt    time.Time `json:"checked_at"`
+}
+
+// storedSHA256 returns the hex SHA-256 of key, taking it from a HEAD of
+// the object when S3 has a checksum of the whole of it. It returns
+// storage.ErrNotFound if the object is gone.
+func storedSHA256(ctx context.Context, target tenants.Target, key string) (sum, method string, err error) {
+	if target.IsS3() {
+		out, err := target.Client.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket:       aws.String(target.Bucket),
+			Key:          aws.String(key),
+			ChecksumMode: types.ChecksumModeEnabled,
+		})
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			return "", "", storage.ErrNotFound
+		}
+		if err != nil {
+			return "", "", err
+		}
+		// Checksums of multipart uploads end in -{parts} and are
+		// checksums of the parts' checksums.
+		if checksum := aws.ToString(out.ChecksumSHA256); checksum != "" && !strings.Contains(checksum, "-") {
+			raw, err := base64.StdEncoding.DecodeString(checksum)
+			if err != nil {
+				return "", "", fmt.Errorf("invalid checksum %q: %w", checksum, err)
+			}
+			return hex.EncodeToString(raw), "checksum", nil
+		}
+	}
+	sum, err = hashStoredObject(ctx, target, key)
+	return sum, "download", err
+}
+
+// handlerVideoIntegrity checks that the video's stored file is still the
+// one that was uploaded.
+func (cfg *apiConfig) handlerVideoIntegrity(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+	userID, ok := cfg.requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't view this video", nil)
+		return
+	}
+	if video.VideoURL == nil {
+		respondWithError(w, http.StatusNotFound, "Video has no file", nil)
+		return
+	}
+	if video.VideoSHA256 == "" {
+		respondWithError(w, http.StatusConflict, "Video was uploaded without a checksum", nil)
+		return
+	}
+
+	target, key, err := cfg.videoObject(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't locate video file", err)
+		return
+	}
+	check := integrityCheck{VideoID: videoID, SHA256: video.VideoSHA256, CheckedAt: cfg.clock.Now().UTC()}
+	check.StoredSHA256, check.Method, err = storedSHA256(r.Context(), target, key)
+	if errors.Is(err, storage.ErrNotFound) {
+		check.StoredSHA256, check.Method, check.Missing = "", "", true
+		respondWithJSON(w, http.StatusOK, check)
+		return
+	}
+	if err != nil {
+		respondWithStorageError(w, http.StatusBadGateway, "Couldn't check video file", err)
+		return
+	}
+	check.Verified = check.StoredSHA256 == check.SHA256
+	respondWithJSON(w, http.StatusOK, check)
+}
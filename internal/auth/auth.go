@@ -2,6 +2,7 @@ package auth
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -9,6 +10,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/clock"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
@@ -22,6 +24,11 @@ const (
 
 var ErrNoAuthHeaderIncluded = errors.New("no auth header included in request")
 
+// Clock issues and checks the times in tokens. Tokens are validated
+// allowing for clock.MaxSkew, since the server that issued one may not be
+// the one checking it.
+var Clock clock.Clock = clock.System
+
 func HashPassword(password string) (string, error) {
 	dat, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
@@ -34,50 +41,130 @@ func CheckPasswordHash(password, hash string) error {
 	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 }
 
+type accessClaims struct {
+	Tenant      string       `json:"tenant,omitempty"`
+	AgeVerified bool         `json:"age_verified,omitempty"`
+	Suspended   bool         `json:"suspended,omitempty"`
+	Actor       *actorClaims `json:"act,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// actorClaims is the act claim of RFC 8693: who is acting as the subject.
+type actorClaims struct {
+	Subject string `json:"sub"`
+}
+
+// Identity is what an access token says about its holder. AgeVerified is
+// set for users whose age has been verified, so age-restricted playback can
+// be allowed without a database lookup. Suspended tells clients the user was
+// suspended when the token was issued; enforcement goes by the user record.
+// ImpersonatorID is set when an admin is acting as the user.
+type Identity struct {
+	UserID         uuid.UUID
+	Tenant         string
+	AgeVerified    bool
+	Suspended      bool
+	ImpersonatorID uuid.UUID
+}
+
 func MakeJWT(
 	userID uuid.UUID,
 	tokenSecret string,
 	expiresIn time.Duration,
 ) (string, error) {
+	return MakeTenantJWT(userID, "", tokenSecret, expiresIn)
+}
+
+// MakeTenantJWT is like MakeJWT but also records the tenant the user belongs
+// to, so storage can be routed without a database lookup.
+func MakeTenantJWT(
+	userID uuid.UUID,
+	tenant string,
+	tokenSecret string,
+	expiresIn time.Duration,
+) (string, error) {
+	return MakeIdentityJWT(Identity{UserID: userID, Tenant: tenant}, tokenSecret, expiresIn)
+}
+
+// MakeIdentityJWT issues an access token carrying every claim in id.
+func MakeIdentityJWT(id Identity, tokenSecret string, expiresIn time.Duration) (string, error) {
 	signingKey := []byte(tokenSecret)
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-		Issuer:    string(TokenTypeAccess),
-		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
-		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
-		Subject:   userID.String(),
-	})
+	now := Clock.Now().UTC()
+	claims := accessClaims{
+		Tenant:      id.Tenant,
+		AgeVerified: id.AgeVerified,
+		Suspended:   id.Suspended,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    string(TokenTypeAccess),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(expiresIn)),
+			Subject:   id.UserID.String(),
+		},
+	}
+	if id.ImpersonatorID != uuid.Nil {
+		claims.Actor = &actorClaims{Subject: id.ImpersonatorID.String()}
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return token.SignedString(signingKey)
 }
 
 func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
-	claimsStruct := jwt.RegisteredClaims{}
+	id, _, err := ValidateTenantJWT(tokenString, tokenSecret)
+	return id, err
+}
+
+// ValidateTenantJWT validates an access token and returns the user ID and
+// tenant it was issued for. The tenant is empty for single-tenant users.
+func ValidateTenantJWT(tokenString, tokenSecret string) (uuid.UUID, string, error) {
+	id, err := ValidateIdentityJWT(tokenString, tokenSecret)
+	return id.UserID, id.Tenant, err
+}
+
+// ValidateIdentityJWT validates an access token and returns every claim it
+// carries.
+func ValidateIdentityJWT(tokenString, tokenSecret string) (Identity, error) {
+	claimsStruct := accessClaims{}
 	token, err := jwt.ParseWithClaims(
 		tokenString,
 		&claimsStruct,
 		func(token *jwt.Token) (interface{}, error) { return []byte(tokenSecret), nil },
+		jwt.WithTimeFunc(Clock.Now),
+		jwt.WithLeeway(clock.MaxSkew),
 	)
 	if err != nil {
-		return uuid.Nil, err
+		return Identity{}, err
 	}
 
 	userIDString, err := token.Claims.GetSubject()
 	if err != nil {
-		return uuid.Nil, err
+		return Identity{}, err
 	}
 
 	issuer, err := token.Claims.GetIssuer()
 	if err != nil {
-		return uuid.Nil, err
+		return Identity{}, err
 	}
 	if issuer != string(TokenTypeAccess) {
-		return uuid.Nil, errors.New("invalid issuer")
+		return Identity{}, errors.New("invalid issuer")
 	}
 
 	id, err := uuid.Parse(userIDString)
 	if err != nil {
-		return uuid.Nil, fmt.Errorf("invalid user ID: %w", err)
+		return Identity{}, fmt.Errorf("invalid user ID: %w", err)
+	}
+	identity := Identity{
+		UserID:      id,
+		Tenant:      claimsStruct.Tenant,
+		AgeVerified: claimsStruct.AgeVerified,
+		Suspended:   claimsStruct.Suspended,
+	}
+	if claimsStruct.Actor != nil {
+		identity.ImpersonatorID, err = uuid.Parse(claimsStruct.Actor.Subject)
+		if err != nil {
+			return Identity{}, fmt.Errorf("invalid actor ID: %w", err)
+		}
 	}
-	return id, nil
+	return identity, nil
 }
 
 func GetBearerToken(headers http.Header) (string, error) {
@@ -102,6 +189,31 @@ func MakeRefreshToken() (string, error) {
 	return hex.EncodeToString(token), nil
 }
 
+// APIKeyPrefix starts every API key, so leaked keys are easy to search for.
+const APIKeyPrefix = "tubely_"
+
+// MakeAPIKey returns a new API key. Only its hash should be stored.
+func MakeAPIKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return APIKeyPrefix + hex.EncodeToString(key), nil
+}
+
+// HashAPIKey returns the hash API keys are stored and looked up by. Keys
+// are random, so unlike passwords they don't need a slow hash.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// HasAPIKey reports whether the request authenticates with an API key
+// rather than a bearer token.
+func HasAPIKey(headers http.Header) bool {
+	return strings.HasPrefix(headers.Get("Authorization"), "ApiKey ")
+}
+
 func GetAPIKey(headers http.Header) (string, error) {
 	authHeader := headers.Get("Authorization")
 	if authHeader == "" {
@@ -114,3 +226,36 @@ func GetAPIKey(headers http.Header) (string, error) {
 
 	return splitAuth[1], nil
 }
+
+// DeviceTokenPrefix starts every device token, so leaked tokens are easy
+// to search for.
+const DeviceTokenPrefix = "tubely_device_"
+
+// MakeDeviceToken returns a new device token. Only its hash should be
+// stored, see HashAPIKey.
+func MakeDeviceToken() (string, error) {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return DeviceTokenPrefix + hex.EncodeToString(token), nil
+}
+
+// HasDeviceToken reports whether the request authenticates with a device
+// token rather than a bearer token.
+func HasDeviceToken(headers http.Header) bool {
+	return strings.HasPrefix(headers.Get("Authorization"), "Device ")
+}
+
+func GetDeviceToken(headers http.Header) (string, error) {
+	authHeader := headers.Get("Authorization")
+	if authHeader == "" {
+		return "", ErrNoAuthHeaderIncluded
+	}
+	splitAuth := strings.Split(authHeader, " ")
+	if len(splitAuth) < 2 || splitAuth[0] != "Device" {
+		return "", errors.New("malformed authorization header")
+	}
+
+	return splitAuth[1], nil
+}
@@ -0,0 +1,232 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/clock"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ErrMissingRole is returned for valid admin tokens without the admin role.
+var ErrMissingRole = errors.New("token doesn't have the admin role")
+
+const (
+	// oidcKeysMaxAge is how long signing keys are used before they're
+	// fetched again, and oidcKeysMinInterval how soon a token signed with
+	// a key we don't have can make us fetch them again.
+	oidcKeysMaxAge      = time.Hour
+	oidcKeysMinInterval = time.Minute
+)
+
+// OIDCVerifier validates tokens an OpenID Connect provider issues for an
+// admin UI. They're nothing like Tubely's own access tokens: they're signed
+// by the provider with one of the keys it publishes, must be for Audience,
+// and must carry Role in RoleClaim, which may be a path into nested
+// claims, such as realm_access.roles, and may be a single string or a
+// list.
+type OIDCVerifier struct {
+	Issuer    string
+	Audience  string
+	RoleClaim string
+	Role      string
+	Client    *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]any
+	fetchedAt time.Time
+}
+
+// OIDCIdentity is who an admin token was issued to.
+type OIDCIdentity struct {
+	Issuer  string
+	Subject string
+	Email   string
+}
+
+// Verify validates an admin token and returns who it's for.
+func (v *OIDCVerifier) Verify(ctx context.Context, tokenString string) (OIDCIdentity, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(
+		tokenString,
+		claims,
+		func(token *jwt.Token) (interface{}, error) {
+			kid, _ := token.Header["kid"].(string)
+			return v.key(ctx, kid)
+		},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}),
+		jwt.WithIssuer(v.Issuer),
+		jwt.WithAudience(v.Audience),
+		jwt.WithTimeFunc(Clock.Now),
+		jwt.WithLeeway(clock.MaxSkew),
+	)
+	if err != nil {
+		return OIDCIdentity{}, err
+	}
+	if exp, err := claims.GetExpirationTime(); err != nil || exp == nil {
+		return OIDCIdentity{}, errors.New("token has no expiry")
+	}
+	subject, err := claims.GetSubject()
+	if err != nil || subject == "" {
+		return OIDCIdentity{}, errors.New("token has no subject")
+	}
+	if !hasRole(claims, v.RoleClaim, v.Role) {
+		return OIDCIdentity{}, ErrMissingRole
+	}
+	email, _ := claims["email"].(string)
+	return OIDCIdentity{Issuer: v.Issuer, Subject: subject, Email: email}, nil
+}
+
+// hasRole reports whether the claim at path, with dots between the names
+// of nested claims, is role or a list that holds it.
+func hasRole(claims map[string]any, path, role string) bool {
+	var v any = claims
+	for _, name := range strings.Split(path, ".") {
+		m, ok := v.(map[string]any)
+		if !ok {
+			return false
+		}
+		v = m[name]
+	}
+	switch v := v.(type) {
+	case string:
+		return v == role
+	case []any:
+		for _, r := range v {
+			if r == role {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// key returns the provider's signing key with ID kid, fetching the
+// provider's keys if they're stale or don't have it.
+func (v *OIDCVerifier) key(ctx context.Context, kid string) (any, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	key, ok := v.keys[kid]
+	stale := Clock.Now().Sub(v.fetchedAt) > oidcKeysMaxAge
+	if ok && !stale {
+		return key, nil
+	}
+	if stale || Clock.Now().Sub(v.fetchedAt) > oidcKeysMinInterval {
+		keys, err := v.fetchKeys(ctx)
+		if err != nil {
+			if ok {
+				// Better a key that's a little old than no admin UI while
+				// the provider is down.
+				return key, nil
+			}
+			return nil, fmt.Errorf("couldn't fetch signing keys: %w", err)
+		}
+		v.keys, v.fetchedAt = keys, Clock.Now()
+		if key, ok = keys[kid]; ok {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// fetchKeys finds the provider's JWKS through its discovery document and
+// returns the RSA and EC signing keys in it, by key ID.
+func (v *OIDCVerifier) fetchKeys(ctx context.Context) (map[string]any, error) {
+	var discovery struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := v.getJSON(ctx, strings.TrimSuffix(v.Issuer, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, err
+	}
+	if discovery.Issuer != v.Issuer {
+		return nil, fmt.Errorf("discovery document is for issuer %q", discovery.Issuer)
+	}
+	if discovery.JWKSURI == "" {
+		return nil, errors.New("discovery document has no jwks_uri")
+	}
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := v.getJSON(ctx, discovery.JWKSURI, &jwks); err != nil {
+		return nil, err
+	}
+	keys := map[string]any{}
+	for _, k := range jwks.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(k.N)
+			e, errE := base64.RawURLEncoding.DecodeString(k.E)
+			if errN != nil || errE != nil || len(e) == 0 || len(e) > 4 {
+				continue
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			var curve elliptic.Curve
+			switch k.Crv {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			case "P-521":
+				curve = elliptic.P521()
+			default:
+				continue
+			}
+			x, errX := base64.RawURLEncoding.DecodeString(k.X)
+			y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+			if errX != nil || errY != nil {
+				continue
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("JWKS has no usable signing keys")
+	}
+	return keys, nil
+}
+
+func (v *OIDCVerifier) getJSON(ctx context.Context, url string, dst any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	client := v.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(dst)
+}
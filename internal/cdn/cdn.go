@@ -0,0 +1,79 @@
+package cdn
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudfront"
+	"github.com/aws/aws-sdk-go-v2/service/cloudfront/types"
+	"github.com/google/uuid"
+)
+
+type Status string
+
+const (
+	StatusInProgress Status = "in_progress"
+	StatusCompleted  Status = "completed"
+	StatusFailed     Status = "failed"
+)
+
+// Invalidation is a request to purge paths from the edge caches, recorded
+// so clients can tell when replaced assets stop being served.
+type Invalidation struct {
+	ID        string    `json:"id,omitempty"`
+	Reason    string    `json:"reason"`
+	Paths     []string  `json:"paths"`
+	Status    Status    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Invalidator purges paths from a CDN's edge caches. Paths start with a
+// slash and may end in * to match a prefix.
+type Invalidator interface {
+	Invalidate(ctx context.Context, paths []string) (id string, err error)
+	Status(ctx context.Context, id string) (Status, error)
+}
+
+// CloudFront invalidates paths of a CloudFront distribution.
+type CloudFront struct {
+	Client         *cloudfront.Client
+	DistributionID string
+}
+
+func (c CloudFront) Invalidate(ctx context.Context, paths []string) (string, error) {
+	out, err := c.Client.CreateInvalidation(ctx, &cloudfront.CreateInvalidationInput{
+		DistributionId: aws.String(c.DistributionID),
+		InvalidationBatch: &types.InvalidationBatch{
+			CallerReference: aws.String(uuid.NewString()),
+			Paths: &types.Paths{
+				Items:    paths,
+				Quantity: aws.Int32(int32(len(paths))),
+			},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(out.Invalidation.Id), nil
+}
+
+func (c CloudFront) Status(ctx context.Context, id string) (Status, error) {
+	out, err := c.Client.GetInvalidation(ctx, &cloudfront.GetInvalidationInput{
+		DistributionId: aws.String(c.DistributionID),
+		Id:             aws.String(id),
+	})
+	if err != nil {
+		return "", err
+	}
+	switch status := aws.ToString(out.Invalidation.Status); status {
+	case "InProgress":
+		return StatusInProgress, nil
+	case "Completed":
+		return StatusCompleted, nil
+	default:
+		return "", fmt.Errorf("unknown invalidation status %q", status)
+	}
+}
@@ -0,0 +1,121 @@
+package cdn
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The HMAC scheme is for self-hosted distributions, such as a caching
+// proxy in front of MinIO, which can't check CloudFront's signatures. A
+// URL is signed by appending
+//
+//	tubely_expires={unix seconds}&tubely_signature={signature}
+//
+// and cookies covering a prefix are Tubely-Resource, the path it covers
+// ending in *, Tubely-Expires and Tubely-Signature. The signature is the
+// unpadded URL-safe base64 of the HMAC-SHA256, under the shared secret, of
+// the escaped path, a newline and the expiry. Only the path is signed, so
+// the proxy can sit behind any host name or TLS terminator.
+const (
+	HMACExpiresParam   = "tubely_expires"
+	HMACSignatureParam = "tubely_signature"
+	HMACResourceCookie = "Tubely-Resource"
+	HMACExpiresCookie  = "Tubely-Expires"
+	HMACSignCookie     = "Tubely-Signature"
+)
+
+var (
+	ErrUnsigned         = errors.New("request isn't signed")
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrExpired          = errors.New("signature has expired")
+)
+
+// HMACSigner signs and verifies URLs and cookies with the HMAC scheme.
+type HMACSigner struct {
+	Secret []byte
+}
+
+// SignURL signs rawURL so it can be fetched until expires.
+func (s *HMACSigner) SignURL(rawURL string, expires time.Time) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	sep := "?"
+	if strings.Contains(rawURL, "?") {
+		sep = "&"
+	}
+	return fmt.Sprintf("%s%s%s=%s&%s=%s", rawURL, sep, HMACExpiresParam, exp, HMACSignatureParam, s.signature(u.EscapedPath(), exp)), nil
+}
+
+// SignedCookies returns the cookies for resource.
+func (s *HMACSigner) SignedCookies(resource string, expires time.Time) ([]*http.Cookie, error) {
+	u, err := url.Parse(resource)
+	if err != nil {
+		return nil, err
+	}
+	path := u.EscapedPath()
+	// EscapedPath escapes the * of a resource that wasn't escaped already.
+	if strings.HasSuffix(resource, "*") {
+		path = strings.TrimSuffix(strings.TrimSuffix(path, "%2A"), "*") + "*"
+	}
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	return []*http.Cookie{
+		{Name: HMACResourceCookie, Value: path},
+		{Name: HMACExpiresCookie, Value: exp},
+		{Name: HMACSignCookie, Value: s.signature(path, exp)},
+	}, nil
+}
+
+// Verify checks that r was signed for its path and hasn't expired, by its
+// URL or else its cookies. It returns ErrUnsigned if it carries neither.
+func (s *HMACSigner) Verify(r *http.Request, now time.Time) error {
+	path := r.URL.EscapedPath()
+	q := r.URL.Query()
+	if q.Has(HMACSignatureParam) {
+		return s.verify(path, q.Get(HMACExpiresParam), q.Get(HMACSignatureParam), now)
+	}
+	resource, err := r.Cookie(HMACResourceCookie)
+	if err != nil {
+		return ErrUnsigned
+	}
+	expires, err1 := r.Cookie(HMACExpiresCookie)
+	signature, err2 := r.Cookie(HMACSignCookie)
+	if err1 != nil || err2 != nil {
+		return ErrInvalidSignature
+	}
+	covered := resource.Value == path
+	if prefix, ok := strings.CutSuffix(resource.Value, "*"); ok {
+		covered = strings.HasPrefix(path, prefix)
+	}
+	if !covered {
+		return ErrInvalidSignature
+	}
+	return s.verify(resource.Value, expires.Value, signature.Value, now)
+}
+
+func (s *HMACSigner) verify(path, expires, signature string, now time.Time) error {
+	exp, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || !hmac.Equal([]byte(signature), []byte(s.signature(path, expires))) {
+		return ErrInvalidSignature
+	}
+	if now.Unix() >= exp {
+		return ErrExpired
+	}
+	return nil
+}
+
+func (s *HMACSigner) signature(path, expires string) string {
+	mac := hmac.New(sha256.New, s.Secret)
+	fmt.Fprintf(mac, "%s\n%s", path, expires)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
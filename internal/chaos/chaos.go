@@ -0,0 +1,201 @@
+// Package chaos injects faults into the media pipeline so that its cleanup
+// and retry paths can be exercised in integration tests and staging. It is
+// never meant to be enabled in production.
+package chaos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// The points faults are injected at, as reported by Injected.
+const (
+	PointS3     = "s3"
+	PointFFmpeg = "ffmpeg"
+	PointDB     = "db"
+	PointDisk   = "disk"
+)
+
+// ErrInjected is wrapped by every injected error.
+var ErrInjected = errors.New("injected by chaos mode")
+
+// Config says how often each fault is injected, as a fraction of calls from
+// 0 to 1.
+type Config struct {
+	S3ErrorRate float64 `json:"s3_error_rate"`
+	// S3Operations limits S3 errors to these operations, e.g. PutObject.
+	// Empty means every operation.
+	S3Operations      []string `json:"s3_operations,omitempty"`
+	FFmpegTimeoutRate float64  `json:"ffmpeg_timeout_rate"`
+	DBWriteErrorRate  float64  `json:"db_write_error_rate"`
+	// DiskDelayMS slows every write to a spooled upload by this many
+	// milliseconds.
+	DiskDelayMS int `json:"disk_delay_ms"`
+	// ClockSkewMS sets the server's clock this many milliseconds ahead,
+	// or behind if negative, of the system's, as if it were another
+	// server whose clock has drifted.
+	ClockSkewMS int `json:"clock_skew_ms"`
+}
+
+func (c Config) Validate() error {
+	for name, rate := range map[string]float64{
+		"s3_error_rate":       c.S3ErrorRate,
+		"ffmpeg_timeout_rate": c.FFmpegTimeoutRate,
+		"db_write_error_rate": c.DBWriteErrorRate,
+	} {
+		if rate < 0 || rate > 1 {
+			return fmt.Errorf("%s must be between 0 and 1", name)
+		}
+	}
+	if c.DiskDelayMS < 0 {
+		return errors.New("disk_delay_ms can't be negative")
+	}
+	return nil
+}
+
+// Injector decides when to inject a fault. A nil Injector never does, so
+// its hooks can be wired in unconditionally.
+type Injector struct {
+	mu       sync.Mutex
+	config   Config
+	injected map[string]int64
+}
+
+func New(config Config) (*Injector, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return &Injector{config: config, injected: map[string]int64{}}, nil
+}
+
+func (i *Injector) Config() Config {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.config
+}
+
+// SetConfig changes the fault rates, e.g. between integration tests.
+func (i *Injector) SetConfig(config Config) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.config = config
+	return nil
+}
+
+// Injected returns how many faults have been injected at each point.
+func (i *Injector) Injected() map[string]int64 {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	counts := make(map[string]int64, len(i.injected))
+	for point, n := range i.injected {
+		counts[point] = n
+	}
+	return counts
+}
+
+// fire reports whether to inject a fault at point, and counts it if so.
+func (i *Injector) fire(point string, rate func(Config) float64) bool {
+	if i == nil {
+		return false
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if rand.Float64() >= rate(i.config) {
+		return false
+	}
+	i.injected[point]++
+	return true
+}
+
+// Now is the time on a clock ClockSkewMS off the system's, so the injector
+// can be the server's clock.
+func (i *Injector) Now() time.Time {
+	now := time.Now()
+	if i == nil {
+		return now
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return now.Add(time.Duration(i.config.ClockSkewMS) * time.Millisecond)
+}
+
+// S3Options makes a client fail calls with an S3 InternalError. The error
+// is raised before the SDK's retries, so it reaches the caller the way an
+// error that outlasted them would.
+func (i *Injector) S3Options(o *s3.Options) {
+	if i == nil {
+		return
+	}
+	o.APIOptions = append(o.APIOptions, func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("ChaosFault", func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+			operation := awsmiddleware.GetOperationName(ctx)
+			inject := i.fire(PointS3, func(c Config) float64 {
+				if len(c.S3Operations) > 0 && !slices.Contains(c.S3Operations, operation) {
+					return 0
+				}
+				return c.S3ErrorRate
+			})
+			if inject {
+				return middleware.InitializeOutput{}, middleware.Metadata{}, fmt.Errorf("%w: %w", ErrInjected, &smithy.GenericAPIError{
+					Code:    "InternalError",
+					Message: "We encountered an internal error. Please try again.",
+					Fault:   smithy.FaultServer,
+				})
+			}
+			return next.HandleInitialize(ctx, in)
+		}), middleware.After)
+	})
+}
+
+// FFmpegFault fails an ffmpeg or ffprobe command as if it had timed out.
+func (i *Injector) FFmpegFault(bin string) error {
+	if i.fire(PointFFmpeg, func(c Config) float64 { return c.FFmpegTimeoutRate }) {
+		return fmt.Errorf("%s timed out: %w: %w", bin, ErrInjected, context.DeadlineExceeded)
+	}
+	return nil
+}
+
+// DBWriteFault fails a database write.
+func (i *Injector) DBWriteFault() error {
+	if i.fire(PointDB, func(c Config) float64 { return c.DBWriteErrorRate }) {
+		return fmt.Errorf("database write failed: %w", ErrInjected)
+	}
+	return nil
+}
+
+// SlowWriter returns w, slowed down by the configured disk delay.
+func (i *Injector) SlowWriter(w io.Writer) io.Writer {
+	if i == nil {
+		return w
+	}
+	return slowWriter{w: w, i: i}
+}
+
+type slowWriter struct {
+	w io.Writer
+	i *Injector
+}
+
+func (s slowWriter) Write(p []byte) (int, error) {
+	if delay := s.i.Config().DiskDelayMS; delay > 0 {
+		s.i.mu.Lock()
+		s.i.injected[PointDisk]++
+		s.i.mu.Unlock()
+		time.Sleep(time.Duration(delay) * time.Millisecond)
+	}
+	return s.w.Write(p)
+}
@@ -0,0 +1,210 @@
+package chaos
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+)
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr string
+	}{
+		{name: "off", config: Config{}},
+		{name: "every rate at its limits", config: Config{S3ErrorRate: 1, FFmpegTimeoutRate: 0, DBWriteErrorRate: 0.5, DiskDelayMS: 10, ClockSkewMS: -5000}},
+		{name: "s3 rate above 1", config: Config{S3ErrorRate: 1.5}, wantErr: "s3_error_rate"},
+		{name: "ffmpeg rate below 0", config: Config{FFmpegTimeoutRate: -0.1}, wantErr: "ffmpeg_timeout_rate"},
+		{name: "db rate above 1", config: Config{DBWriteErrorRate: 2}, wantErr: "db_write_error_rate"},
+		{name: "negative disk delay", config: Config{DiskDelayMS: -1}, wantErr: "disk_delay_ms"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Validate() = %v, want an error about %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFaults(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+		fault  func(*Injector) error
+		point  string
+		want   bool
+		// alsoIs is another error an injected fault has to match.
+		alsoIs error
+	}{
+		{name: "ffmpeg always", config: Config{FFmpegTimeoutRate: 1}, fault: func(i *Injector) error { return i.FFmpegFault("ffmpeg") }, point: PointFFmpeg, want: true, alsoIs: context.DeadlineExceeded},
+		{name: "ffmpeg never", config: Config{}, fault: func(i *Injector) error { return i.FFmpegFault("ffmpeg") }, point: PointFFmpeg},
+		{name: "db always", config: Config{DBWriteErrorRate: 1}, fault: (*Injector).DBWriteFault, point: PointDB, want: true},
+		{name: "db never", config: Config{FFmpegTimeoutRate: 1}, fault: (*Injector).DBWriteFault, point: PointDB},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i, err := New(tt.config)
+			if err != nil {
+				t.Fatalf("New: %v", err)
+			}
+			for range 3 {
+				err := tt.fault(i)
+				if !tt.want {
+					if err != nil {
+						t.Fatalf("fault = %v, want nil", err)
+					}
+					continue
+				}
+				if !errors.Is(err, ErrInjected) {
+					t.Fatalf("fault = %v, want an ErrInjected", err)
+				}
+				if tt.alsoIs != nil && !errors.Is(err, tt.alsoIs) {
+					t.Fatalf("fault = %v, want it to match %v", err, tt.alsoIs)
+				}
+			}
+			want := int64(0)
+			if tt.want {
+				want = 3
+			}
+			if got := i.Injected()[tt.point]; got != want {
+				t.Errorf("Injected()[%s] = %d, want %d", tt.point, got, want)
+			}
+		})
+	}
+}
+
+func TestNilInjector(t *testing.T) {
+	var i *Injector
+	if err := i.FFmpegFault("ffmpeg"); err != nil {
+		t.Errorf("FFmpegFault() = %v, want nil", err)
+	}
+	if err := i.DBWriteFault(); err != nil {
+		t.Errorf("DBWriteFault() = %v, want nil", err)
+	}
+	var buf bytes.Buffer
+	if w := i.SlowWriter(&buf); w != &buf {
+		t.Errorf("SlowWriter() wrapped the writer")
+	}
+	if skew := time.Since(i.Now()).Abs(); skew > time.Second {
+		t.Errorf("Now() is %v off the system clock", skew)
+	}
+}
+
+func TestNowSkew(t *testing.T) {
+	tests := []struct {
+		name   string
+		skewMS int
+	}{
+		{name: "none"},
+		{name: "ahead", skewMS: 90_000},
+		{name: "behind", skewMS: -90_000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i, err := New(Config{ClockSkewMS: tt.skewMS})
+			if err != nil {
+				t.Fatalf("New: %v", err)
+			}
+			want := time.Duration(tt.skewMS) * time.Millisecond
+			if off := i.Now().Sub(time.Now()) - want; off.Abs() > time.Second {
+				t.Errorf("Now() is %v off the skewed clock", off)
+			}
+		})
+	}
+}
+
+func TestSlowWriter(t *testing.T) {
+	i, err := New(Config{DiskDelayMS: 5})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	var buf bytes.Buffer
+	w := i.SlowWriter(&buf)
+
+	start := time.Now()
+	for _, chunk := range []string{"spooled ", "upload"} {
+		if _, err := w.Write([]byte(chunk)); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 10*time.Millisecond {
+		t.Errorf("two writes took %v, want at least 10ms", elapsed)
+	}
+	if buf.String() != "spooled upload" {
+		t.Errorf("wrote %q, want %q", buf.String(), "spooled upload")
+	}
+	if got := i.Injected()[PointDisk]; got != 2 {
+		t.Errorf("Injected()[disk] = %d, want 2", got)
+	}
+}
+
+func TestS3Options(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     Config
+		wantPutErr bool
+		wantGetErr bool
+	}{
+		{name: "off", config: Config{}},
+		{name: "every operation", config: Config{S3ErrorRate: 1}, wantPutErr: true, wantGetErr: true},
+		{name: "only PutObject", config: Config{S3ErrorRate: 1, S3Operations: []string{"PutObject"}}, wantPutErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("ok"))
+			}))
+			defer server.Close()
+
+			i, err := New(tt.config)
+			if err != nil {
+				t.Fatalf("New: %v", err)
+			}
+			client := s3.New(s3.Options{
+				Region:       "us-east-1",
+				BaseEndpoint: aws.String(server.URL),
+				UsePathStyle: true,
+				Credentials:  credentials.NewStaticCredentialsProvider("key", "secret", ""),
+			}, i.S3Options)
+
+			ctx := context.Background()
+			_, putErr := client.PutObject(ctx, &s3.PutObjectInput{Bucket: aws.String("bucket"), Key: aws.String("key"), Body: strings.NewReader("body")})
+			checkS3Fault(t, "PutObject", putErr, tt.wantPutErr)
+			_, getErr := client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String("bucket"), Key: aws.String("key")})
+			checkS3Fault(t, "GetObject", getErr, tt.wantGetErr)
+		})
+	}
+}
+
+func checkS3Fault(t *testing.T, operation string, err error, want bool) {
+	t.Helper()
+	if !want {
+		if err != nil {
+			t.Errorf("%s = %v, want nil", operation, err)
+		}
+		return
+	}
+	var apiErr smithy.APIError
+	if !errors.Is(err, ErrInjected) || !errors.As(err, &apiErr) || apiErr.ErrorCode() != "InternalError" {
+		t.Errorf("%s = %v, want an injected InternalError", operation, err)
+	}
+}
@@ -0,0 +1,62 @@
+// Package clock is where the server gets the time from for behavior that
+// depends on it, such as token expiry, scheduled publishing and link
+// lifetimes, so it can be run against a clock that's set by hand.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// MaxSkew is how far apart the clocks of servers that check each other's
+// timestamps, such as the expiry of tokens one issued and another
+// validates, are allowed to be.
+const MaxSkew = 30 * time.Second
+
+// Clock tells the time.
+type Clock interface {
+	Now() time.Time
+}
+
+// System is the system clock.
+var System Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// Expired reports whether expiresAt has passed on c, allowing for MaxSkew
+// in case it was set by another server.
+func Expired(c Clock, expiresAt time.Time) bool {
+	return c.Now().After(expiresAt.Add(MaxSkew))
+}
+
+// Fake is a clock that stands still until it's set or advanced.
+type Fake struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *Fake) Set(now time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = now
+}
+
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+}
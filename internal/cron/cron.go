@@ -0,0 +1,190 @@
+// Package cron parses the schedules of recurring tasks: standard
+// five-field cron expressions, evaluated in UTC, the @hourly, @daily,
+// @weekly and @monthly shorthands, and @every {duration}.
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule says when a task runs next.
+type Schedule interface {
+	// Next returns the first time the task is due after after.
+	Next(after time.Time) time.Time
+	String() string
+}
+
+var shorthands = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+}
+
+// Parse parses spec.
+func Parse(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if d, ok := strings.CutPrefix(spec, "@every "); ok {
+		interval, err := time.ParseDuration(strings.TrimSpace(d))
+		if err != nil || interval < time.Second {
+			return nil, fmt.Errorf("invalid schedule %q: @every needs a duration of at least 1s", spec)
+		}
+		return every{interval: interval}, nil
+	}
+	expr := spec
+	if s, ok := shorthands[spec]; ok {
+		expr = s
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q: expected 5 fields, minute hour day month weekday", spec)
+	}
+	e := expression{spec: spec}
+	bounds := []struct {
+		set      *uint64
+		min, max int
+	}{
+		{&e.minutes, 0, 59},
+		{&e.hours, 0, 23},
+		{&e.days, 1, 31},
+		{&e.months, 1, 12},
+		{&e.weekdays, 0, 7},
+	}
+	for i, field := range fields {
+		set, err := parseField(field, bounds[i].min, bounds[i].max)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %w", spec, err)
+		}
+		*bounds[i].set = set
+	}
+	// Both 0 and 7 are Sunday.
+	if e.weekdays&(1<<7) != 0 {
+		e.weekdays |= 1
+	}
+	// As in Vixie cron, a field starting with * counts as unrestricted
+	// for the day rule, so */1 is the same as *.
+	e.anyDay = strings.HasPrefix(fields[2], "*")
+	e.anyWeekday = strings.HasPrefix(fields[4], "*")
+	if e.Next(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)).IsZero() {
+		return nil, fmt.Errorf("invalid schedule %q: it never matches", spec)
+	}
+	return e, nil
+}
+
+// parseField parses a comma-separated list of *, values, ranges and
+// steps of either into the set of values it matches.
+func parseField(field string, min, max int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepStr)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			step = n
+		}
+		lo, hi := min, max
+		switch {
+		case rng == "*":
+		case strings.Contains(rng, "-"):
+			a, b, _ := strings.Cut(rng, "-")
+			var err1, err2 error
+			lo, err1 = strconv.Atoi(a)
+			hi, err2 = strconv.Atoi(b)
+			if err1 != nil || err2 != nil || lo > hi {
+				return 0, fmt.Errorf("invalid range %q", part)
+			}
+		default:
+			n, err := strconv.Atoi(rng)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value %q", part)
+			}
+			lo, hi = n, n
+			if hasStep {
+				hi = max
+			}
+		}
+		if lo < min || hi > max {
+			return 0, fmt.Errorf("%q is out of range %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+// expression is a five-field cron expression. As in cron, a day matches
+// if either its day of the month or its weekday does, unless one of those
+// fields starts with *, in which case it has to match both.
+type expression struct {
+	spec                                   string
+	minutes, hours, days, months, weekdays uint64
+	anyDay, anyWeekday                     bool
+}
+
+func (e expression) String() string {
+	return e.spec
+}
+
+// maxSearch bounds the search for the next match, for expressions such as
+// "0 0 31 2 *" that never match.
+const maxSearch = 5 * 366 * 24 * time.Hour
+
+func (e expression) Next(after time.Time) time.Time {
+	t := after.UTC().Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(maxSearch)
+	for t.Before(limit) {
+		if e.months&(1<<int(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if !e.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if e.hours&(1<<t.Hour()) == 0 {
+			t = t.Truncate(time.Hour).Add(time.Hour)
+			continue
+		}
+		if e.minutes&(1<<t.Minute()) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (e expression) dayMatches(t time.Time) bool {
+	day := e.days&(1<<t.Day()) != 0
+	weekday := e.weekdays&(1<<int(t.Weekday())) != 0
+	if e.anyDay || e.anyWeekday {
+		return day && weekday
+	}
+	return day || weekday
+}
+
+// every runs a task every interval, counted from when it last ran.
+type every struct {
+	interval time.Duration
+}
+
+func (e every) Next(after time.Time) time.Time {
+	return after.Add(e.interval)
+}
+
+func (e every) String() string {
+	return "@every " + e.interval.String()
+}
+
+// Every returns the schedule of a task that runs every interval.
+func Every(interval time.Duration) Schedule {
+	return every{interval: interval}
+}
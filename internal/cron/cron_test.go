@@ -0,0 +1,105 @@
+package cron
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		wantErr string
+	}{
+		{name: "too few fields", spec: "0 * * *", wantErr: "expected 5 fields"},
+		{name: "too many fields", spec: "0 0 * * * *", wantErr: "expected 5 fields"},
+		{name: "unknown shorthand", spec: "@yearly", wantErr: "expected 5 fields"},
+		{name: "minute out of range", spec: "60 * * * *", wantErr: "out of range 0-59"},
+		{name: "day out of range", spec: "0 0 0 * *", wantErr: "out of range 1-31"},
+		{name: "weekday out of range", spec: "0 0 * * 8", wantErr: "out of range 0-7"},
+		{name: "range out of range", spec: "0 20-24 * * *", wantErr: "out of range 0-23"},
+		{name: "not a number", spec: "0 noon * * *", wantErr: "invalid value"},
+		{name: "empty list item", spec: "0,,30 * * * *", wantErr: "invalid value"},
+		{name: "backwards range", spec: "0 0 * * 5-1", wantErr: "invalid range"},
+		{name: "open range", spec: "0 9- * * *", wantErr: "invalid range"},
+		{name: "zero step", spec: "*/0 * * * *", wantErr: "invalid step"},
+		{name: "step that isn't a number", spec: "*/x * * * *", wantErr: "invalid step"},
+		{name: "never matches", spec: "0 0 30 2 *", wantErr: "never matches"},
+		{name: "every without a duration", spec: "@every soon", wantErr: "@every"},
+		{name: "every under a second", spec: "@every 500ms", wantErr: "@every"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.spec)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Parse(%q) error = %v, want one about %s", tt.spec, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNext(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("no time zone data: %v", err)
+	}
+	utc := func(s string) time.Time {
+		t.Helper()
+		v, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	tests := []struct {
+		name  string
+		spec  string
+		after time.Time
+		want  []string
+	}{
+		{name: "every minute", spec: "* * * * *", after: utc("2026-03-01 10:00"), want: []string{"2026-03-01 10:01", "2026-03-01 10:02"}},
+		{name: "seconds are dropped", spec: "* * * * *", after: utc("2026-03-01 10:00").Add(59 * time.Second), want: []string{"2026-03-01 10:01"}},
+		{name: "range", spec: "0 9-11 * * *", after: utc("2026-03-01 10:30"), want: []string{"2026-03-01 11:00", "2026-03-02 09:00", "2026-03-02 10:00"}},
+		{name: "step", spec: "*/20 * * * *", after: utc("2026-03-01 10:05"), want: []string{"2026-03-01 10:20", "2026-03-01 10:40", "2026-03-01 11:00"}},
+		{name: "step from a value", spec: "45/10 * * * *", after: utc("2026-03-01 10:00"), want: []string{"2026-03-01 10:45", "2026-03-01 10:55", "2026-03-01 11:45"}},
+		{name: "stepped range", spec: "0 8-18/5 * * *", after: utc("2026-03-01 00:00"), want: []string{"2026-03-01 08:00", "2026-03-01 13:00", "2026-03-01 18:00", "2026-03-02 08:00"}},
+		{name: "list", spec: "15,45 6,18 * * *", after: utc("2026-03-01 06:30"), want: []string{"2026-03-01 06:45", "2026-03-01 18:15", "2026-03-01 18:45", "2026-03-02 06:15"}},
+		{name: "shorthand", spec: "@weekly", after: utc("2026-03-04 12:00"), want: []string{"2026-03-08 00:00", "2026-03-15 00:00"}},
+		{name: "sunday as 7", spec: "0 0 * * 7", after: utc("2026-03-04 12:00"), want: []string{"2026-03-08 00:00"}},
+		{name: "weekday range through 7", spec: "0 12 * * 6-7", after: utc("2026-03-06 00:00"), want: []string{"2026-03-07 12:00", "2026-03-08 12:00", "2026-03-14 12:00"}},
+		// 2026-03-01 is a Sunday, so Mondays are the 2nd, 9th and 16th.
+		{name: "day of month or weekday", spec: "0 0 13 * 1", after: utc("2026-03-01 00:00"), want: []string{"2026-03-02 00:00", "2026-03-09 00:00", "2026-03-13 00:00", "2026-03-16 00:00"}},
+		{name: "weekday with any day of month", spec: "0 0 * * 1", after: utc("2026-03-01 00:00"), want: []string{"2026-03-02 00:00", "2026-03-09 00:00"}},
+		{name: "day of month with any weekday", spec: "0 0 13 * *", after: utc("2026-03-01 00:00"), want: []string{"2026-03-13 00:00", "2026-04-13 00:00"}},
+		{name: "star step counts as any day", spec: "0 0 */1 * 1", after: utc("2026-03-01 00:00"), want: []string{"2026-03-02 00:00", "2026-03-09 00:00"}},
+		{name: "star step counts as any weekday", spec: "0 0 13 * */1", after: utc("2026-03-01 00:00"), want: []string{"2026-03-13 00:00", "2026-04-13 00:00"}},
+		{name: "month boundary", spec: "30 23 31 * *", after: utc("2026-03-31 23:45"), want: []string{"2026-05-31 23:30", "2026-07-31 23:30"}},
+		{name: "year boundary", spec: "@monthly", after: utc("2026-12-15 00:00"), want: []string{"2027-01-01 00:00", "2027-02-01 00:00"}},
+		{name: "leap day", spec: "0 0 29 2 *", after: utc("2026-03-01 00:00"), want: []string{"2028-02-29 00:00", "2032-02-29 00:00"}},
+		// Schedules go by UTC, so the clocks going forward or back
+		// doesn't skip or repeat a run.
+		{name: "spring forward", spec: "30 6 * * *", after: time.Date(2026, 3, 8, 1, 0, 0, 0, newYork), want: []string{"2026-03-08 06:30", "2026-03-09 06:30"}},
+		{name: "fall back", spec: "30 5,6 * * *", after: time.Date(2026, 11, 1, 0, 0, 0, 0, newYork), want: []string{"2026-11-01 05:30", "2026-11-01 06:30", "2026-11-02 05:30"}},
+		{name: "every", spec: "@every 1h30m0s", after: utc("2026-03-01 23:00"), want: []string{"2026-03-02 00:30", "2026-03-02 02:00"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Parse(tt.spec)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.spec, err)
+			}
+			if s.String() != tt.spec {
+				t.Errorf("String() = %q, want %q", s.String(), tt.spec)
+			}
+			after := tt.after
+			for _, want := range tt.want {
+				next := s.Next(after)
+				if !next.Equal(utc(want)) {
+					t.Fatalf("Next(%v) = %v, want %s UTC", after, next.UTC(), want)
+				}
+				after = next
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AccessEvent records one view of a video. ViewerID is nil for anonymous
+// viewers.
+type AccessEvent struct {
+	VideoID    uuid.UUID  `json:"video_id"`
+	ViewerID   *uuid.UUID `json:"viewer_id"`
+	Kind       string     `json:"kind"`
+	OccurredAt time.Time  `json:"occurred_at"`
+}
+
+// accessEventsPerVideo is how many access events are kept for each video.
+const accessEventsPerVideo = 500
+
+// RecordAccessEvent stores event and prunes the video's oldest events beyond
+// accessEventsPerVideo.
+func (c Client) RecordAccessEvent(event AccessEvent) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	viewerID := ""
+	if event.ViewerID != nil {
+		viewerID = event.ViewerID.String()
+	}
+	query := `
+	INSERT INTO access_events (video_id, viewer_id, kind, occurred_at)
+	VALUES (?, ?, ?, ?)
+	`
+	if _, err := tx.Exec(query, event.VideoID, viewerID, event.Kind, event.OccurredAt); err != nil {
+		return err
+	}
+
+	prune := `
+	DELETE FROM access_events
+	WHERE video_id = ? AND id NOT IN (
+		SELECT id FROM access_events
+		WHERE video_id = ?
+		ORDER BY id DESC
+		LIMIT ?
+	)
+	`
+	if _, err := tx.Exec(prune, event.VideoID, event.VideoID, accessEventsPerVideo); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetAccessEvents returns up to limit of a video's most recent access
+// events, newest first.
+func (c Client) GetAccessEvents(videoID uuid.UUID, limit int) ([]AccessEvent, error) {
+	query := `
+	SELECT video_id, viewer_id, kind, occurred_at
+	FROM access_events
+	WHERE video_id = ?
+	ORDER BY id DESC
+	LIMIT ?
+	`
+	rows, err := c.db.Query(query, videoID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []AccessEvent{}
+	for rows.Next() {
+		var event AccessEvent
+		var viewerID string
+		if err := rows.Scan(&event.VideoID, &viewerID, &event.Kind, &event.OccurredAt); err != nil {
+			return nil, err
+		}
+		if viewerID != "" {
+			id, err := uuid.Parse(viewerID)
+			if err != nil {
+				return nil, err
+			}
+			event.ViewerID = &id
+		}
+		event.OccurredAt = event.OccurredAt.UTC()
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
@@ -0,0 +1,120 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// APIKey is a long-lived credential a user creates for scripts and bots
+// that upload on their behalf. Only a hash of the key itself is stored;
+// Prefix is its first characters, for telling keys apart.
+type APIKey struct {
+	ID         uuid.UUID  `json:"id"`
+	UserID     uuid.UUID  `json:"-"`
+	Name       string     `json:"name"`
+	KeyHash    string     `json:"-"`
+	Prefix     string     `json:"prefix"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
+const apiKeyColumns = `id, user_id, name, key_hash, prefix, created_at, last_used_at, revoked_at`
+
+func (c Client) CreateAPIKey(k APIKey) error {
+	query := `
+	INSERT INTO api_keys (` + apiKeyColumns + `)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, k.ID, k.UserID, k.Name, k.KeyHash, k.Prefix, k.CreatedAt, k.LastUsedAt, k.RevokedAt)
+	return err
+}
+
+// GetAPIKeys returns the user's keys, revoked ones included, oldest first.
+func (c Client) GetAPIKeys(userID uuid.UUID) ([]APIKey, error) {
+	query := `
+	SELECT ` + apiKeyColumns + `
+	FROM api_keys
+	WHERE user_id = ?
+	ORDER BY created_at
+	`
+	rows, err := c.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// GetAPIKey returns the key, or nil if it doesn't exist.
+func (c Client) GetAPIKey(id uuid.UUID) (*APIKey, error) {
+	query := `
+	SELECT ` + apiKeyColumns + `
+	FROM api_keys
+	WHERE id = ?
+	`
+	k, err := scanAPIKey(c.db.QueryRow(query, id))
+	if isNoRows(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+// GetAPIKeyByHash returns the key with the hash, or nil if there is none.
+func (c Client) GetAPIKeyByHash(hash string) (*APIKey, error) {
+	query := `
+	SELECT ` + apiKeyColumns + `
+	FROM api_keys
+	WHERE key_hash = ?
+	`
+	k, err := scanAPIKey(c.db.QueryRow(query, hash))
+	if isNoRows(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+func scanAPIKey(row rowScanner) (APIKey, error) {
+	var k APIKey
+	err := row.Scan(&k.ID, &k.UserID, &k.Name, &k.KeyHash, &k.Prefix, &k.CreatedAt, &k.LastUsedAt, &k.RevokedAt)
+	if err != nil {
+		return APIKey{}, err
+	}
+	k.CreatedAt = k.CreatedAt.UTC()
+	return k, nil
+}
+
+// SetAPIKeyHash replaces the hash the key is stored by.
+func (c Client) SetAPIKeyHash(id uuid.UUID, hash string) error {
+	_, err := c.db.Exec("UPDATE api_keys SET key_hash = ? WHERE id = ?", hash, id)
+	return err
+}
+
+// TouchAPIKey records that the key was used at now.
+func (c Client) TouchAPIKey(id uuid.UUID, now time.Time) error {
+	_, err := c.db.Exec("UPDATE api_keys SET last_used_at = ? WHERE id = ?", now, id)
+	return err
+}
+
+// RevokeAPIKey revokes the key at now. Revoked keys are kept, so the list
+// still shows when they were last used.
+func (c Client) RevokeAPIKey(id uuid.UUID, now time.Time) error {
+	_, err := c.db.Exec("UPDATE api_keys SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL", now, id)
+	return err
+}
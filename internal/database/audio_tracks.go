@@ -0,0 +1,74 @@
+package database
+
+import (
+	"github.com/google/uuid"
+)
+
+type AudioTrack struct {
+	Index     int    `json:"index"`
+	Language  string `json:"language,omitempty"`
+	Title     string `json:"title,omitempty"`
+	Codec     string `json:"codec"`
+	Channels  int    `json:"channels"`
+	IsDefault bool   `json:"is_default"`
+}
+
+// ReplaceAudioTracks stores the audio tracks of a video's current file,
+// replacing those of any previous upload.
+func (c Client) ReplaceAudioTracks(videoID uuid.UUID, tracks []AudioTrack) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM audio_tracks WHERE video_id = ?", videoID); err != nil {
+		return err
+	}
+	query := `
+	INSERT INTO audio_tracks (video_id, track_index, language, title, codec, channels, is_default)
+	VALUES (?, ?, ?, ?, ?, ?, ?)
+	`
+	for _, t := range tracks {
+		if _, err := tx.Exec(query, videoID, t.Index, t.Language, t.Title, t.Codec, t.Channels, t.IsDefault); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (c Client) GetAudioTracks(videoID uuid.UUID) ([]AudioTrack, error) {
+	query := `
+	SELECT track_index, language, title, codec, channels, is_default
+	FROM audio_tracks
+	WHERE video_id = ?
+	ORDER BY track_index
+	`
+	rows, err := c.db.Query(query, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tracks := []AudioTrack{}
+	for rows.Next() {
+		var t AudioTrack
+		if err := rows.Scan(&t.Index, &t.Language, &t.Title, &t.Codec, &t.Channels, &t.IsDefault); err != nil {
+			return nil, err
+		}
+		tracks = append(tracks, t)
+	}
+	return tracks, rows.Err()
+}
+
+// UpdateAudioTrackLabels lets owners correct the language and title of a
+// track when the uploaded file didn't carry them.
+func (c Client) UpdateAudioTrackLabels(videoID uuid.UUID, index int, language, title string) error {
+	query := `
+	UPDATE audio_tracks
+	SET language = ?, title = ?
+	WHERE video_id = ? AND track_index = ?
+	`
+	_, err := c.db.Exec(query, language, title, videoID, index)
+	return err
+}
@@ -0,0 +1,89 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Backup writes a consistent snapshot of the database to path, which must
+// not exist yet. Writes can carry on while it runs.
+func (c Client) Backup(path string) error {
+	_, err := c.db.Exec("VACUUM INTO ?", path)
+	return err
+}
+
+func (c Client) Close() error {
+	return c.db.Close()
+}
+
+// ReplaceURLPrefix rewrites every text value in the database that starts
+// with from so that it starts with to instead, e.g. when objects have moved
+// to another bucket. It returns how many values changed.
+func (c Client) ReplaceURLPrefix(from, to string) (int64, error) {
+	tables, err := c.db.Query(`SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%'`)
+	if err != nil {
+		return 0, err
+	}
+	names := []string{}
+	for tables.Next() {
+		var name string
+		if err := tables.Scan(&name); err != nil {
+			tables.Close()
+			return 0, err
+		}
+		names = append(names, name)
+	}
+	tables.Close()
+	if err := tables.Err(); err != nil {
+		return 0, err
+	}
+
+	var changed int64
+	for _, table := range names {
+		columns, err := c.textColumns(table)
+		if err != nil {
+			return changed, err
+		}
+		for _, column := range columns {
+			query := fmt.Sprintf("UPDATE %s SET %s = ? || substr(%s, ?) WHERE substr(%s, 1, ?) = ?", table, column, column, column)
+			res, err := c.db.Exec(query, to, len(from)+1, len(from), from)
+			if err != nil {
+				return changed, err
+			}
+			n, err := res.RowsAffected()
+			if err != nil {
+				return changed, err
+			}
+			changed += n
+		}
+	}
+	return changed, nil
+}
+
+func (c Client) textColumns(table string) ([]string, error) {
+	rows, err := c.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := []string{}
+	for rows.Next() {
+		var (
+			cid        int
+			name       string
+			colType    string
+			notNull    bool
+			defaultVal sql.NullString
+			primaryKey int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultVal, &primaryKey); err != nil {
+			return nil, err
+		}
+		if strings.Contains(strings.ToUpper(colType), "TEXT") {
+			columns = append(columns, name)
+		}
+	}
+	return columns, rows.Err()
+}
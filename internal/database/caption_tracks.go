@@ -0,0 +1,60 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// CaptionTrack is a sidecar caption file for a video in one language.
+type CaptionTrack struct {
+	Language  string    `json:"language"`
+	Format    string    `json:"format"`
+	URL       string    `json:"url"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SaveCaptionTrack stores a caption track, replacing the video's existing
+// track in the same language.
+func (c Client) SaveCaptionTrack(videoID uuid.UUID, track CaptionTrack) error {
+	query := `
+	INSERT INTO caption_tracks (video_id, language, format, url, created_at)
+	VALUES (?, ?, ?, ?, ?)
+	ON CONFLICT (video_id, language) DO UPDATE SET
+		format = excluded.format,
+		url = excluded.url,
+		created_at = excluded.created_at
+	`
+	_, err := c.db.Exec(query, videoID, track.Language, track.Format, track.URL, track.CreatedAt)
+	return err
+}
+
+func (c Client) GetCaptionTracks(videoID uuid.UUID) ([]CaptionTrack, error) {
+	query := `
+	SELECT language, format, url, created_at
+	FROM caption_tracks
+	WHERE video_id = ?
+	ORDER BY language
+	`
+	rows, err := c.db.Query(query, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tracks := []CaptionTrack{}
+	for rows.Next() {
+		var t CaptionTrack
+		if err := rows.Scan(&t.Language, &t.Format, &t.URL, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		t.CreatedAt = t.CreatedAt.UTC()
+		tracks = append(tracks, t)
+	}
+	return tracks, rows.Err()
+}
+
+func (c Client) DeleteCaptionTrack(videoID uuid.UUID, language string) error {
+	_, err := c.db.Exec("DELETE FROM caption_tracks WHERE video_id = ? AND language = ?", videoID, language)
+	return err
+}
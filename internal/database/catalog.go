@@ -0,0 +1,125 @@
+package database
+
+import (
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// The batch lookups below back the GraphQL catalog, which loads the owners,
+// videos and stats of a whole page of results in one query each.
+
+// inPlaceholders returns a placeholder for each of ids, and the ids as
+// query arguments.
+func inPlaceholders(ids []uuid.UUID) (string, []any) {
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id.String()
+	}
+	return strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", "), args
+}
+
+// GetUsersByIDs returns the users with the given IDs. Unknown IDs are
+// skipped.
+func (c Client) GetUsersByIDs(ids []uuid.UUID) ([]User, error) {
+	if len(ids) == 0 {
+		return []User{}, nil
+	}
+	placeholders, args := inPlaceholders(ids)
+	query := `
+		SELECT id, created_at, updated_at, tenant_id, age_verified, suspended_at, suspension_reason, playback_blocked, email
+		FROM users
+		WHERE id IN (` + placeholders + `)
+	`
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		var user User
+		var id string
+		if err := rows.Scan(&id, &user.CreatedAt, &user.UpdatedAt, &user.TenantID, &user.AgeVerified, &user.SuspendedAt, &user.SuspensionReason, &user.PlaybackBlocked, &user.Email); err != nil {
+			return nil, err
+		}
+		if err := c.decrypt(fieldUserEmail, &user.Email); err != nil {
+			return nil, err
+		}
+		user.ID, err = uuid.Parse(id)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// GetVideosByUsers returns the videos of the given users, newest first.
+func (c Client) GetVideosByUsers(userIDs []uuid.UUID) ([]Video, error) {
+	if len(userIDs) == 0 {
+		return []Video{}, nil
+	}
+	placeholders, args := inPlaceholders(userIDs)
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE user_id IN (` + placeholders + `) AND deleted_at IS NULL
+	ORDER BY created_at DESC
+	`
+	return c.queryVideos(query, args...)
+}
+
+// GetVideosByIDs returns the videos with the given IDs, in no particular
+// order. Unknown IDs and videos in the trash are skipped.
+func (c Client) GetVideosByIDs(ids []uuid.UUID) ([]Video, error) {
+	if len(ids) == 0 {
+		return []Video{}, nil
+	}
+	placeholders, args := inPlaceholders(ids)
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE id IN (` + placeholders + `) AND deleted_at IS NULL
+	`
+	return c.queryVideos(query, args...)
+}
+
+// AccessCount is how many access events of a kind a video has, out of the
+// accessEventsPerVideo most recent ones that are kept.
+type AccessCount struct {
+	VideoID uuid.UUID
+	Kind    string
+	Count   int
+}
+
+// GetAccessCounts returns the access counts of the given videos, by kind.
+// Videos without access events have none.
+func (c Client) GetAccessCounts(videoIDs []uuid.UUID) ([]AccessCount, error) {
+	if len(videoIDs) == 0 {
+		return []AccessCount{}, nil
+	}
+	placeholders, args := inPlaceholders(videoIDs)
+	query := `
+	SELECT video_id, kind, COUNT(*)
+	FROM access_events
+	WHERE video_id IN (` + placeholders + `)
+	GROUP BY video_id, kind
+	`
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []AccessCount{}
+	for rows.Next() {
+		var count AccessCount
+		if err := rows.Scan(&count.VideoID, &count.Kind, &count.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, count)
+	}
+	return counts, rows.Err()
+}
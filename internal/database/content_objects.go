@@ -0,0 +1,81 @@
+package database
+
+import "time"
+
+// RetainContentObject records one more reference to the content-addressed
+// object under key in bucket, and returns how many there are now.
+func (c Client) RetainContentObject(bucket, key string) (int, error) {
+	query := `
+	INSERT INTO content_objects (bucket, key, refs, created_at)
+	VALUES (?, ?, 1, ?)
+	ON CONFLICT (bucket, key) DO UPDATE SET refs = refs + 1
+	RETURNING refs
+	`
+	if err := c.db.fault(); err != nil {
+		return 0, err
+	}
+	var refs int
+	err := c.db.QueryRow(query, bucket, key, time.Now().UTC()).Scan(&refs)
+	return refs, err
+}
+
+// ReleaseContentObject drops a reference to the object under key in
+// bucket and returns how many are left, forgetting the object once there
+// are none. tracked is false if the object has no references recorded.
+func (c Client) ReleaseContentObject(bucket, key string) (refs int, tracked bool, err error) {
+	query := `
+	UPDATE content_objects
+	SET refs = refs - 1
+	WHERE bucket = ? AND key = ?
+	RETURNING refs
+	`
+	if err := c.db.fault(); err != nil {
+		return 0, false, err
+	}
+	err = c.db.QueryRow(query, bucket, key).Scan(&refs)
+	if isNoRows(err) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	if refs <= 0 {
+		if _, err := c.db.Exec("DELETE FROM content_objects WHERE bucket = ? AND key = ?", bucket, key); err != nil {
+			return 0, true, err
+		}
+	}
+	return refs, true, nil
+}
+
+// ContentObjectRefs returns how many references the object under key in
+// bucket has. tracked is false if it has none recorded.
+func (c Client) ContentObjectRefs(bucket, key string) (refs int, tracked bool, err error) {
+	err = c.db.QueryRow("SELECT refs FROM content_objects WHERE bucket = ? AND key = ?", bucket, key).Scan(&refs)
+	if isNoRows(err) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return refs, true, nil
+}
+
+// ContentObjectInUse reports whether the object under key in bucket has
+// references recorded, or is the file of a video, trashed or not, stored
+// before references were.
+func (c Client) ContentObjectInUse(bucket, key string) (bool, error) {
+	query := `
+	SELECT EXISTS (SELECT 1 FROM content_objects WHERE bucket = ? AND key = ? AND refs > 0)
+		OR EXISTS (SELECT 1 FROM videos WHERE video_url = ?)
+	`
+	var inUse bool
+	err := c.db.QueryRow(query, bucket, key, key).Scan(&inUse)
+	return inUse, err
+}
+
+// MoveContentObjects carries the references to objects in one bucket over
+// to another the objects were copied to.
+func (c Client) MoveContentObjects(from, to string) error {
+	_, err := c.db.Exec("UPDATE content_objects SET bucket = ? WHERE bucket = ?", to, from)
+	return err
+}
@@ -0,0 +1,208 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Sizes on the dashboard are those of the processed video files, taken from
+// the probe output captured at processing time. Renditions, previews and
+// thumbnails aren't included, and videos processed before media info capture
+// was added count as 0 bytes.
+const videoSizeExpr = `COALESCE(CAST(json_extract(m.output, '$.format.size') AS INTEGER), 0)`
+
+// ProcessingCount is how many processing attempts from a source started in
+// the hour starting at Hour, and how many of them failed.
+type ProcessingCount struct {
+	Hour   time.Time `json:"hour"`
+	Source string    `json:"source"`
+	Total  int       `json:"total"`
+	Failed int       `json:"failed"`
+}
+
+// GetProcessingCounts returns the processing attempts since since, by hour
+// and source, oldest first. Only the logs kept for each video are counted.
+func (c Client) GetProcessingCounts(since time.Time) ([]ProcessingCount, error) {
+	query := `
+	SELECT substr(started_at, 1, 13) AS hour, source, COUNT(*), SUM(status = 'failed')
+	FROM processing_logs
+	WHERE started_at >= ?
+	GROUP BY hour, source
+	ORDER BY hour, source
+	`
+	rows, err := c.db.Query(query, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []ProcessingCount{}
+	for rows.Next() {
+		var count ProcessingCount
+		var hour string
+		if err := rows.Scan(&hour, &count.Source, &count.Total, &count.Failed); err != nil {
+			return nil, err
+		}
+		count.Hour, err = time.Parse("2006-01-02 15", hour)
+		if err != nil {
+			return nil, err
+		}
+		counts = append(counts, count)
+	}
+	return counts, rows.Err()
+}
+
+// ProcessingFailure is a failed processing attempt without its steps.
+type ProcessingFailure struct {
+	ID         uuid.UUID `json:"id"`
+	VideoID    uuid.UUID `json:"video_id"`
+	Source     string    `json:"source"`
+	Error      string    `json:"error"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+// GetRecentProcessingFailures returns the latest failed processing attempts
+// across all videos, newest first.
+func (c Client) GetRecentProcessingFailures(limit int) ([]ProcessingFailure, error) {
+	query := `
+	SELECT id, video_id, source, error, started_at, finished_at
+	FROM processing_logs
+	WHERE status = 'failed'
+	ORDER BY started_at DESC
+	LIMIT ?
+	`
+	rows, err := c.db.Query(query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	failures := []ProcessingFailure{}
+	for rows.Next() {
+		var f ProcessingFailure
+		if err := rows.Scan(&f.ID, &f.VideoID, &f.Source, &f.Error, &f.StartedAt, &f.FinishedAt); err != nil {
+			return nil, err
+		}
+		failures = append(failures, f)
+	}
+	return failures, rows.Err()
+}
+
+// StorageDay is how many videos were created on Day and how many bytes
+// their files take up.
+type StorageDay struct {
+	Day    time.Time `json:"day"`
+	Videos int       `json:"videos"`
+	Bytes  int64     `json:"bytes"`
+}
+
+// GetStorageTotals returns the number of videos and the bytes their files
+// take up.
+func (c Client) GetStorageTotals() (videos int, bytes int64, err error) {
+	query := `
+	SELECT COUNT(*), COALESCE(SUM(` + videoSizeExpr + `), 0)
+	FROM videos v
+	LEFT JOIN media_info m ON m.video_id = v.id
+	`
+	err = c.db.QueryRow(query).Scan(&videos, &bytes)
+	return videos, bytes, err
+}
+
+// GetStorageGrowth returns the videos created since since, by day, oldest
+// first.
+func (c Client) GetStorageGrowth(since time.Time) ([]StorageDay, error) {
+	query := `
+	SELECT substr(v.created_at, 1, 10) AS day, COUNT(*), COALESCE(SUM(` + videoSizeExpr + `), 0)
+	FROM videos v
+	LEFT JOIN media_info m ON m.video_id = v.id
+	WHERE v.created_at >= ?
+	GROUP BY day
+	ORDER BY day
+	`
+	rows, err := c.db.Query(query, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	days := []StorageDay{}
+	for rows.Next() {
+		var d StorageDay
+		var day string
+		if err := rows.Scan(&day, &d.Videos, &d.Bytes); err != nil {
+			return nil, err
+		}
+		d.Day, err = time.Parse(time.DateOnly, day)
+		if err != nil {
+			return nil, err
+		}
+		days = append(days, d)
+	}
+	return days, rows.Err()
+}
+
+// UserUsage is how much of something a user's videos account for: Count is
+// videos for storage and plays for bandwidth.
+type UserUsage struct {
+	UserID uuid.UUID `json:"user_id"`
+	Email  string    `json:"email"`
+	Count  int       `json:"count"`
+	Bytes  int64     `json:"bytes"`
+}
+
+// GetTopUsersByStorage returns the users whose videos take up the most
+// bytes, largest first.
+func (c Client) GetTopUsersByStorage(limit int) ([]UserUsage, error) {
+	query := `
+	SELECT v.user_id, COALESCE(u.email, ''), COUNT(*), COALESCE(SUM(` + videoSizeExpr + `), 0) AS bytes
+	FROM videos v
+	LEFT JOIN media_info m ON m.video_id = v.id
+	LEFT JOIN users u ON u.id = v.user_id
+	GROUP BY v.user_id
+	ORDER BY bytes DESC
+	LIMIT ?
+	`
+	return c.queryUserUsage(query, limit)
+}
+
+// GetTopUsersByBandwidth returns the users whose videos were played the
+// most bytes' worth since since, largest first. Each access event of kind
+// counts as one download of the whole file, and only the events kept for
+// each video are counted, so this is an estimate.
+func (c Client) GetTopUsersByBandwidth(kind string, since time.Time, limit int) ([]UserUsage, error) {
+	query := `
+	SELECT v.user_id, COALESCE(u.email, ''), COUNT(*), COALESCE(SUM(` + videoSizeExpr + `), 0) AS bytes
+	FROM access_events e
+	JOIN videos v ON v.id = e.video_id
+	LEFT JOIN media_info m ON m.video_id = v.id
+	LEFT JOIN users u ON u.id = v.user_id
+	WHERE e.kind = ? AND e.occurred_at >= ?
+	GROUP BY v.user_id
+	ORDER BY bytes DESC
+	LIMIT ?
+	`
+	return c.queryUserUsage(query, kind, since, limit)
+}
+
+func (c Client) queryUserUsage(query string, args ...any) ([]UserUsage, error) {
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	usage := []UserUsage{}
+	for rows.Next() {
+		var u UserUsage
+		if err := rows.Scan(&u.UserID, &u.Email, &u.Count, &u.Bytes); err != nil {
+			return nil, err
+		}
+		if err := c.decrypt(fieldUserEmail, &u.Email); err != nil {
+			return nil, err
+		}
+		usage = append(usage, u)
+	}
+	return usage, rows.Err()
+}
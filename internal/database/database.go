@@ -1,14 +1,51 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/fieldcrypt"
 	_ "github.com/mattn/go-sqlite3"
 )
 
 type Client struct {
-	db *sql.DB
+	db conn
+	// fields encrypts personal data and secrets; nil stores them as they
+	// are.
+	fields *fieldcrypt.Keyring
+}
+
+// conn is the database handle. If writeFault is set, it is called before
+// every write and fails it with the error it returns; chaos mode uses it to
+// simulate failed writes.
+type conn struct {
+	*sql.DB
+	writeFault func() error
+}
+
+// fault returns the error to fail a write with, if any. Writes made with
+// QueryRow, for their RETURNING clause, check it themselves.
+func (c conn) fault() error {
+	if c.writeFault == nil {
+		return nil
+	}
+	return c.writeFault()
+}
+
+func (c conn) Exec(query string, args ...any) (sql.Result, error) {
+	if err := c.fault(); err != nil {
+		return nil, err
+	}
+	return c.DB.Exec(query, args...)
+}
+
+func (c conn) Begin() (*sql.Tx, error) {
+	if err := c.fault(); err != nil {
+		return nil, err
+	}
+	return c.DB.Begin()
 }
 
 func NewClient(pathToDB string) (Client, error) {
@@ -16,7 +53,7 @@ func NewClient(pathToDB string) (Client, error) {
 	if err != nil {
 		return Client{}, err
 	}
-	c := Client{db}
+	c := Client{db: conn{DB: db}}
 	err = c.autoMigrate()
 	if err != nil {
 		return Client{}, err
@@ -25,6 +62,42 @@ func NewClient(pathToDB string) (Client, error) {
 
 }
 
+// WithWriteFault returns a client whose writes first call fault and fail
+// with its error, if any.
+func (c Client) WithWriteFault(fault func() error) Client {
+	c.db.writeFault = fault
+	return c
+}
+
+// Ping checks that the database can still be reached.
+func (c Client) Ping(ctx context.Context) error {
+	return c.db.PingContext(ctx)
+}
+
+// Warm opens n connections to the database at once and reads through each
+// of them, leaving as many as the pool keeps idle open for the requests
+// that come next.
+func (c Client) Warm(ctx context.Context, n int) error {
+	conns := make([]*sql.Conn, 0, n)
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+	for range n {
+		conn, err := c.db.Conn(ctx)
+		if err != nil {
+			return err
+		}
+		conns = append(conns, conn)
+		var count int
+		if err := conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM (SELECT 1 FROM videos LIMIT 1)").Scan(&count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (c *Client) autoMigrate() error {
 	userTable := `
 	CREATE TABLE IF NOT EXISTS users (
@@ -72,9 +145,921 @@ func (c *Client) autoMigrate() error {
 	if err != nil {
 		return err
 	}
+
+	err = c.addColumnIfMissing("refresh_tokens", "replaced_by", "TEXT")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfMissing("users", "tenant_id", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfMissing("users", "age_verified", "BOOLEAN NOT NULL DEFAULT FALSE")
+	if err != nil {
+		return err
+	}
+	userColumns := []struct{ name, definition string }{
+		{"suspended_at", "TIMESTAMP"},
+		{"suspension_reason", "TEXT NOT NULL DEFAULT ''"},
+		{"playback_blocked", "BOOLEAN NOT NULL DEFAULT FALSE"},
+		// email_index finds users by email when emails are encrypted.
+		{"email_index", "TEXT"},
+	}
+	for _, col := range userColumns {
+		if err := c.addColumnIfMissing("users", col.name, col.definition); err != nil {
+			return err
+		}
+	}
+	if _, err := c.db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS users_email_index ON users(email_index)"); err != nil {
+		return err
+	}
+
+	videoColumns := []struct{ name, definition string }{
+		{"dynamic_range", "TEXT NOT NULL DEFAULT 'sdr'"},
+		{"bit_depth", "INTEGER NOT NULL DEFAULT 8"},
+		{"color_primaries", "TEXT NOT NULL DEFAULT ''"},
+		{"color_transfer", "TEXT NOT NULL DEFAULT ''"},
+		{"color_space", "TEXT NOT NULL DEFAULT ''"},
+		{"sdr_video_url", "TEXT"},
+		{"aspect_ratio", "TEXT NOT NULL DEFAULT ''"},
+		{"is_short", "BOOLEAN NOT NULL DEFAULT FALSE"},
+		{"preview_url", "TEXT"},
+		{"spherical", "BOOLEAN NOT NULL DEFAULT FALSE"},
+		{"projection", "TEXT NOT NULL DEFAULT ''"},
+		{"stereo_mode", "TEXT NOT NULL DEFAULT ''"},
+		{"peaks_url", "TEXT"},
+		{"original_filename", "TEXT NOT NULL DEFAULT ''"},
+		{"publish_at", "TIMESTAMP"},
+		{"publish_time_zone", "TEXT NOT NULL DEFAULT ''"},
+		{"moderation_hold", "BOOLEAN NOT NULL DEFAULT FALSE"},
+		{"content_rating", "TEXT NOT NULL DEFAULT ''"},
+		{"age_restricted", "BOOLEAN NOT NULL DEFAULT FALSE"},
+		{"rating_set_by", "TEXT NOT NULL DEFAULT ''"},
+		{"thumbnail_sha256", "TEXT NOT NULL DEFAULT ''"},
+		{"legal_hold", "BOOLEAN NOT NULL DEFAULT FALSE"},
+		{"processing_status", "TEXT NOT NULL DEFAULT ''"},
+		{"processing_error", "TEXT NOT NULL DEFAULT ''"},
+		{"tags", "TEXT NOT NULL DEFAULT '[]'"},
+		{"hls_url", "TEXT"},
+		{"source_sha256", "TEXT NOT NULL DEFAULT ''"},
+		{"video_sha256", "TEXT NOT NULL DEFAULT ''"},
+		{"encryption", "TEXT NOT NULL DEFAULT ''"},
+		{"metadata", "TEXT"},
+		{"deleted_at", "TIMESTAMP"},
+		{"visibility", "TEXT NOT NULL DEFAULT 'public'"},
+		{"share_key", "TEXT NOT NULL DEFAULT ''"},
+		{"passphrase_hash", "TEXT NOT NULL DEFAULT ''"},
+		{"passphrase_requires_login", "BOOLEAN NOT NULL DEFAULT FALSE"},
+		{"premiere", "BOOLEAN NOT NULL DEFAULT FALSE"},
+		{"premiere_started_at", "TIMESTAMP"},
+		{"custom_metadata", "TEXT NOT NULL DEFAULT '{}'"},
+	}
+	for _, col := range videoColumns {
+		if err := c.addColumnIfMissing("videos", col.name, col.definition); err != nil {
+			return err
+		}
+	}
+	videoIndexes := `
+	CREATE INDEX IF NOT EXISTS videos_user_created_at ON videos(user_id, created_at, id);
+	CREATE INDEX IF NOT EXISTS videos_user_updated_at ON videos(user_id, updated_at, id);
+	CREATE INDEX IF NOT EXISTS videos_user_title ON videos(user_id, title COLLATE NOCASE, id);
+	CREATE INDEX IF NOT EXISTS videos_deleted_at ON videos(deleted_at) WHERE deleted_at IS NOT NULL;
+	`
+	if _, err := c.db.Exec(videoIndexes); err != nil {
+		return err
+	}
+
+	featureFlagTable := `
+	CREATE TABLE IF NOT EXISTS feature_flags (
+		name TEXT PRIMARY KEY,
+		enabled BOOLEAN NOT NULL DEFAULT FALSE,
+		users TEXT NOT NULL DEFAULT 'null',
+		tenants TEXT NOT NULL DEFAULT 'null',
+		percent INTEGER NOT NULL DEFAULT 0,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	`
+	_, err = c.db.Exec(featureFlagTable)
+	if err != nil {
+		return err
+	}
+
+	linkCheckTable := `
+	CREATE TABLE IF NOT EXISTS link_checks (
+		video_id TEXT NOT NULL,
+		kind TEXT NOT NULL,
+		url TEXT NOT NULL,
+		broken BOOLEAN NOT NULL,
+		detail TEXT NOT NULL DEFAULT '',
+		checked_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (video_id, kind),
+		FOREIGN KEY(video_id) REFERENCES videos(id) ON DELETE CASCADE
+	);
+	`
+	_, err = c.db.Exec(linkCheckTable)
+	if err != nil {
+		return err
+	}
+
+	audioTrackTable := `
+	CREATE TABLE IF NOT EXISTS audio_tracks (
+		video_id TEXT NOT NULL,
+		track_index INTEGER NOT NULL,
+		language TEXT NOT NULL DEFAULT '',
+		title TEXT NOT NULL DEFAULT '',
+		codec TEXT NOT NULL DEFAULT '',
+		channels INTEGER NOT NULL DEFAULT 0,
+		is_default BOOLEAN NOT NULL DEFAULT FALSE,
+		PRIMARY KEY (video_id, track_index),
+		FOREIGN KEY(video_id) REFERENCES videos(id) ON DELETE CASCADE
+	);
+	`
+	_, err = c.db.Exec(audioTrackTable)
+	if err != nil {
+		return err
+	}
+
+	renditionTable := `
+	CREATE TABLE IF NOT EXISTS renditions (
+		video_id TEXT NOT NULL,
+		name TEXT NOT NULL,
+		width INTEGER NOT NULL,
+		height INTEGER NOT NULL,
+		url TEXT NOT NULL,
+		PRIMARY KEY (video_id, name),
+		FOREIGN KEY(video_id) REFERENCES videos(id) ON DELETE CASCADE
+	);
+	`
+	_, err = c.db.Exec(renditionTable)
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfMissing("renditions", "crop", "BOOLEAN NOT NULL DEFAULT FALSE")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfMissing("renditions", "captions", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+
+	mediaInfoTable := `
+	CREATE TABLE IF NOT EXISTS media_info (
+		video_id TEXT PRIMARY KEY,
+		source TEXT NOT NULL,
+		output TEXT NOT NULL,
+		captured_at TIMESTAMP NOT NULL,
+		FOREIGN KEY(video_id) REFERENCES videos(id) ON DELETE CASCADE
+	);
+	`
+	_, err = c.db.Exec(mediaInfoTable)
+	if err != nil {
+		return err
+	}
+
+	keyframesTable := `
+	CREATE TABLE IF NOT EXISTS keyframes (
+		video_id TEXT PRIMARY KEY,
+		timestamps TEXT NOT NULL,
+		captured_at TIMESTAMP NOT NULL,
+		FOREIGN KEY(video_id) REFERENCES videos(id) ON DELETE CASCADE
+	);
+	`
+	_, err = c.db.Exec(keyframesTable)
+	if err != nil {
+		return err
+	}
+
+	processingLogTable := `
+	CREATE TABLE IF NOT EXISTS processing_logs (
+		id TEXT PRIMARY KEY,
+		video_id TEXT NOT NULL,
+		source TEXT NOT NULL,
+		status TEXT NOT NULL,
+		error TEXT NOT NULL DEFAULT '',
+		started_at TIMESTAMP NOT NULL,
+		finished_at TIMESTAMP NOT NULL,
+		entries TEXT NOT NULL,
+		FOREIGN KEY(video_id) REFERENCES videos(id) ON DELETE CASCADE
+	);
+	CREATE INDEX IF NOT EXISTS processing_logs_video_id ON processing_logs(video_id, started_at);
+	`
+	_, err = c.db.Exec(processingLogTable)
+	if err != nil {
+		return err
+	}
+	if err := c.addColumnIfMissing("processing_logs", "request_id", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+
+	accessEventTable := `
+	CREATE TABLE IF NOT EXISTS access_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		video_id TEXT NOT NULL,
+		viewer_id TEXT NOT NULL DEFAULT '',
+		kind TEXT NOT NULL,
+		occurred_at TIMESTAMP NOT NULL,
+		FOREIGN KEY(video_id) REFERENCES videos(id) ON DELETE CASCADE
+	);
+	CREATE INDEX IF NOT EXISTS access_events_video_id ON access_events(video_id, id);
+	`
+	_, err = c.db.Exec(accessEventTable)
+	if err != nil {
+		return err
+	}
+
+	reportTable := `
+	CREATE TABLE IF NOT EXISTS reports (
+		id TEXT PRIMARY KEY,
+		video_id TEXT NOT NULL,
+		reporter_id TEXT NOT NULL,
+		reason TEXT NOT NULL,
+		details TEXT NOT NULL DEFAULT '',
+		status TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		resolved_at TIMESTAMP,
+		resolved_by TEXT,
+		resolution_note TEXT NOT NULL DEFAULT '',
+		FOREIGN KEY(video_id) REFERENCES videos(id) ON DELETE CASCADE
+	);
+	CREATE INDEX IF NOT EXISTS reports_status ON reports(status, created_at);
+	CREATE INDEX IF NOT EXISTS reports_video_id ON reports(video_id, status);
+	`
+	_, err = c.db.Exec(reportTable)
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfMissing("reports", "source", "TEXT NOT NULL DEFAULT 'viewer'")
+	if err != nil {
+		return err
+	}
+
+	fingerprintReferenceTable := `
+	CREATE TABLE IF NOT EXISTS fingerprint_references (
+		id TEXT PRIMARY KEY,
+		title TEXT NOT NULL,
+		frames INTEGER NOT NULL,
+		fingerprint BLOB NOT NULL,
+		created_at TIMESTAMP NOT NULL
+	);
+	`
+	_, err = c.db.Exec(fingerprintReferenceTable)
+	if err != nil {
+		return err
+	}
+
+	thumbnailVariantTable := `
+	CREATE TABLE IF NOT EXISTS thumbnail_variants (
+		video_id TEXT NOT NULL,
+		width INTEGER NOT NULL,
+		format TEXT NOT NULL,
+		url TEXT NOT NULL,
+		PRIMARY KEY (video_id, width, format),
+		FOREIGN KEY(video_id) REFERENCES videos(id) ON DELETE CASCADE
+	);
+	`
+	_, err = c.db.Exec(thumbnailVariantTable)
+	if err != nil {
+		return err
+	}
+
+	thumbnailCandidateTable := `
+	CREATE TABLE IF NOT EXISTS thumbnail_candidates (
+		id TEXT PRIMARY KEY,
+		video_id TEXT NOT NULL,
+		url TEXT NOT NULL,
+		impressions INTEGER NOT NULL DEFAULT 0,
+		clicks INTEGER NOT NULL DEFAULT 0,
+		created_at TIMESTAMP NOT NULL,
+		FOREIGN KEY(video_id) REFERENCES videos(id) ON DELETE CASCADE
+	);
+	`
+	_, err = c.db.Exec(thumbnailCandidateTable)
+	if err != nil {
+		return err
+	}
+
+	captionTrackTable := `
+	CREATE TABLE IF NOT EXISTS caption_tracks (
+		video_id TEXT NOT NULL,
+		language TEXT NOT NULL,
+		format TEXT NOT NULL,
+		url TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (video_id, language),
+		FOREIGN KEY(video_id) REFERENCES videos(id) ON DELETE CASCADE
+	);
+	`
+	_, err = c.db.Exec(captionTrackTable)
+	if err != nil {
+		return err
+	}
+
+	uploadSessionTable := `
+	CREATE TABLE IF NOT EXISTS upload_sessions (
+		id TEXT PRIMARY KEY,
+		video_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		tenant_id TEXT NOT NULL DEFAULT '',
+		status TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		last_heartbeat_at TIMESTAMP NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		FOREIGN KEY(video_id) REFERENCES videos(id) ON DELETE CASCADE
+	);
+	`
+	_, err = c.db.Exec(uploadSessionTable)
+	if err != nil {
+		return err
+	}
+
+	uploadSessionColumns := []struct{ name, definition string }{
+		{"filename", "TEXT NOT NULL DEFAULT ''"},
+		{"content_type", "TEXT NOT NULL DEFAULT ''"},
+		{"profile", "TEXT NOT NULL DEFAULT ''"},
+		{"part_count", "INTEGER NOT NULL DEFAULT 0"},
+		{"object_key", "TEXT NOT NULL DEFAULT ''"},
+		{"s3_upload_id", "TEXT NOT NULL DEFAULT ''"},
+		{"upload_length", "INTEGER NOT NULL DEFAULT 0"},
+		{"upload_offset", "INTEGER NOT NULL DEFAULT 0"},
+		{"direct_size", "INTEGER NOT NULL DEFAULT 0"},
+		{"bytes_transferred", "INTEGER NOT NULL DEFAULT 0"},
+		{"transfer_ms", "INTEGER NOT NULL DEFAULT 0"},
+	}
+	for _, col := range uploadSessionColumns {
+		if err := c.addColumnIfMissing("upload_sessions", col.name, col.definition); err != nil {
+			return err
+		}
+	}
+
+	uploadPartTable := `
+	CREATE TABLE IF NOT EXISTS upload_parts (
+		session_id TEXT NOT NULL,
+		part_number INTEGER NOT NULL,
+		size INTEGER NOT NULL,
+		etag TEXT NOT NULL,
+		uploaded_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (session_id, part_number),
+		FOREIGN KEY(session_id) REFERENCES upload_sessions(id) ON DELETE CASCADE
+	);
+	`
+	_, err = c.db.Exec(uploadPartTable)
+	if err != nil {
+		return err
+	}
+
+	// Activity outlives the videos it mentions, so video_id has no foreign
+	// key.
+	userActivityTable := `
+	CREATE TABLE IF NOT EXISTS user_activity (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id TEXT NOT NULL,
+		kind TEXT NOT NULL,
+		video_id TEXT,
+		actor_id TEXT,
+		detail TEXT NOT NULL DEFAULT '',
+		occurred_at TIMESTAMP NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS user_activity_user_id ON user_activity(user_id, id);
+	`
+	_, err = c.db.Exec(userActivityTable)
+	if err != nil {
+		return err
+	}
+
+	storageMigrationTable := `
+	CREATE TABLE IF NOT EXISTS storage_migrations (
+		id TEXT PRIMARY KEY,
+		status TEXT NOT NULL,
+		source_bucket TEXT NOT NULL,
+		source_region TEXT NOT NULL,
+		dest_bucket TEXT NOT NULL,
+		dest_region TEXT NOT NULL,
+		objects_per_second INTEGER NOT NULL,
+		cursor TEXT NOT NULL DEFAULT '',
+		copied INTEGER NOT NULL DEFAULT 0,
+		verified INTEGER NOT NULL DEFAULT 0,
+		bytes INTEGER NOT NULL DEFAULT 0,
+		rewritten_urls INTEGER NOT NULL DEFAULT 0,
+		error TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP NOT NULL,
+		updated_at TIMESTAMP NOT NULL,
+		switched_at TIMESTAMP
+	);
+	`
+	_, err = c.db.Exec(storageMigrationTable)
+	if err != nil {
+		return err
+	}
+
+	watchProgressTable := `
+	CREATE TABLE IF NOT EXISTS watch_progress (
+		user_id TEXT NOT NULL,
+		video_id TEXT NOT NULL,
+		position_seconds REAL NOT NULL,
+		duration_seconds REAL NOT NULL DEFAULT 0,
+		updated_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (user_id, video_id)
+	);
+	CREATE INDEX IF NOT EXISTS watch_progress_user_id ON watch_progress(user_id, updated_at);
+	`
+	_, err = c.db.Exec(watchProgressTable)
+	if err != nil {
+		return err
+	}
+
+	viewCountsTable := `
+	CREATE TABLE IF NOT EXISTS view_counts (
+		video_id TEXT NOT NULL,
+		hour TIMESTAMP NOT NULL,
+		views INTEGER NOT NULL,
+		PRIMARY KEY (video_id, hour)
+	);
+	CREATE INDEX IF NOT EXISTS view_counts_hour ON view_counts(hour);
+	CREATE TABLE IF NOT EXISTS view_totals (
+		video_id TEXT PRIMARY KEY,
+		views INTEGER NOT NULL
+	);
+	`
+	_, err = c.db.Exec(viewCountsTable)
+	if err != nil {
+		return err
+	}
+
+	userSettingsTable := `
+	CREATE TABLE IF NOT EXISTS user_settings (
+		user_id TEXT PRIMARY KEY,
+		default_profile TEXT NOT NULL DEFAULT '',
+		watermark BOOLEAN NOT NULL DEFAULT FALSE,
+		watermark_text TEXT NOT NULL DEFAULT '',
+		notify_processing_done BOOLEAN NOT NULL DEFAULT TRUE,
+		notify_processing_failed BOOLEAN NOT NULL DEFAULT TRUE,
+		updated_at TIMESTAMP NOT NULL
+	);
+	`
+	_, err = c.db.Exec(userSettingsTable)
+	if err != nil {
+		return err
+	}
+
+	storageUsageTable := `
+	CREATE TABLE IF NOT EXISTS storage_usage (
+		user_id TEXT NOT NULL,
+		video_id TEXT NOT NULL,
+		kind TEXT NOT NULL,
+		bytes INTEGER NOT NULL,
+		updated_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (video_id, kind)
+	);
+	CREATE INDEX IF NOT EXISTS storage_usage_user_id ON storage_usage(user_id);
+	CREATE TABLE IF NOT EXISTS storage_quotas (
+		user_id TEXT PRIMARY KEY,
+		quota_bytes INTEGER NOT NULL
+	);
+	`
+	_, err = c.db.Exec(storageUsageTable)
+	if err != nil {
+		return err
+	}
+
+	uploadPresetTable := `
+	CREATE TABLE IF NOT EXISTS upload_presets (
+		id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		name TEXT NOT NULL,
+		title_pattern TEXT NOT NULL DEFAULT '',
+		description TEXT NOT NULL DEFAULT '',
+		tags TEXT NOT NULL DEFAULT '[]',
+		profile TEXT NOT NULL DEFAULT '',
+		uses INTEGER NOT NULL DEFAULT 0,
+		created_at TIMESTAMP NOT NULL,
+		updated_at TIMESTAMP NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS upload_presets_user_id ON upload_presets(user_id, name);
+	`
+	_, err = c.db.Exec(uploadPresetTable)
+	if err != nil {
+		return err
+	}
+
+	seriesTable := `
+	CREATE TABLE IF NOT EXISTS series (
+		id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		title TEXT NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP NOT NULL,
+		updated_at TIMESTAMP NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS series_user_id ON series(user_id, created_at);
+	CREATE TABLE IF NOT EXISTS series_episodes (
+		video_id TEXT PRIMARY KEY,
+		series_id TEXT NOT NULL,
+		episode_number INTEGER NOT NULL,
+		UNIQUE (series_id, episode_number)
+	);
+	`
+	_, err = c.db.Exec(seriesTable)
+	if err != nil {
+		return err
+	}
+
+	contentObjectTable := `
+	CREATE TABLE IF NOT EXISTS content_objects (
+		bucket TEXT NOT NULL,
+		key TEXT NOT NULL,
+		refs INTEGER NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (bucket, key)
+	);
+	`
+	_, err = c.db.Exec(contentObjectTable)
+	if err != nil {
+		return err
+	}
+
+	apiKeyTable := `
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		name TEXT NOT NULL,
+		key_hash TEXT NOT NULL UNIQUE,
+		prefix TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		last_used_at TIMESTAMP,
+		revoked_at TIMESTAMP,
+		FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+	);
+	CREATE INDEX IF NOT EXISTS api_keys_user_id ON api_keys(user_id, created_at);
+	`
+	_, err = c.db.Exec(apiKeyTable)
+	if err != nil {
+		return err
+	}
+
+	webhookTable := `
+	CREATE TABLE IF NOT EXISTS webhooks (
+		id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		url TEXT NOT NULL,
+		secret TEXT NOT NULL,
+		events TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+	);
+	CREATE INDEX IF NOT EXISTS webhooks_user_id ON webhooks(user_id, created_at);
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id TEXT PRIMARY KEY,
+		webhook_id TEXT NOT NULL,
+		event_type TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		status TEXT NOT NULL,
+		attempts INTEGER NOT NULL,
+		last_error TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		next_attempt_at TIMESTAMP NOT NULL,
+		delivered_at TIMESTAMP,
+		FOREIGN KEY(webhook_id) REFERENCES webhooks(id) ON DELETE CASCADE
+	);
+	CREATE INDEX IF NOT EXISTS webhook_deliveries_due ON webhook_deliveries(status, next_attempt_at);
+	CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_id ON webhook_deliveries(webhook_id, created_at);
+	`
+	_, err = c.db.Exec(webhookTable)
+	if err != nil {
+		return err
+	}
+	webhookColumns := []struct{ name, definition string }{
+		{"payload_template", "TEXT NOT NULL DEFAULT ''"},
+		{"headers", "TEXT NOT NULL DEFAULT '{}'"},
+	}
+	for _, col := range webhookColumns {
+		if err := c.addColumnIfMissing("webhooks", col.name, col.definition); err != nil {
+			return err
+		}
+	}
+
+	sftpTable := `
+	CREATE TABLE IF NOT EXISTS sftp_accounts (
+		username TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL UNIQUE,
+		created_at TIMESTAMP NOT NULL,
+		FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+	);
+	CREATE TABLE IF NOT EXISTS sftp_ingests (
+		bucket TEXT NOT NULL,
+		key TEXT NOT NULL,
+		etag TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		video_id TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (bucket, key, etag)
+	);
+	`
+	_, err = c.db.Exec(sftpTable)
+	if err != nil {
+		return err
+	}
+
+	emailTable := `
+	CREATE TABLE IF NOT EXISTS email_addresses (
+		user_id TEXT PRIMARY KEY,
+		token TEXT NOT NULL UNIQUE,
+		senders TEXT NOT NULL DEFAULT '[]',
+		created_at TIMESTAMP NOT NULL,
+		FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+	);
+	CREATE TABLE IF NOT EXISTS email_ingests (
+		message_id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		sender TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		status TEXT NOT NULL,
+		error TEXT NOT NULL DEFAULT '',
+		video_ids TEXT NOT NULL DEFAULT '[]',
+		created_at TIMESTAMP NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS email_ingests_user_id ON email_ingests(user_id, created_at);
+	`
+	_, err = c.db.Exec(emailTable)
+	if err != nil {
+		return err
+	}
+
+	notificationChannelTable := `
+	CREATE TABLE IF NOT EXISTS notification_channels (
+		id TEXT PRIMARY KEY,
+		user_id TEXT,
+		tenant_id TEXT NOT NULL DEFAULT '',
+		kind TEXT NOT NULL,
+		url TEXT NOT NULL,
+		rules TEXT NOT NULL DEFAULT '[]',
+		last_sent_at TIMESTAMP,
+		last_error TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP NOT NULL,
+		FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+	);
+	CREATE INDEX IF NOT EXISTS notification_channels_user_id ON notification_channels(user_id, created_at);
+	CREATE INDEX IF NOT EXISTS notification_channels_tenant_id ON notification_channels(tenant_id, created_at);
+	`
+	_, err = c.db.Exec(notificationChannelTable)
+	if err != nil {
+		return err
+	}
+
+	scanVerdictTable := `
+	CREATE TABLE IF NOT EXISTS scan_verdicts (
+		sha256 TEXT NOT NULL,
+		scanner TEXT NOT NULL,
+		status TEXT NOT NULL,
+		reason TEXT NOT NULL DEFAULT '',
+		scanned_at TIMESTAMP NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		PRIMARY KEY(sha256, scanner)
+	);
+	CREATE INDEX IF NOT EXISTS scan_verdicts_scanned_at ON scan_verdicts(scanned_at);
+	`
+	_, err = c.db.Exec(scanVerdictTable)
+	if err != nil {
+		return err
+	}
+
+	tenantBrandingTable := `
+	CREATE TABLE IF NOT EXISTS tenant_branding (
+		tenant_id TEXT PRIMARY KEY,
+		logo_url TEXT NOT NULL DEFAULT '',
+		primary_color TEXT NOT NULL DEFAULT '',
+		background_color TEXT NOT NULL DEFAULT '',
+		watermark_position TEXT NOT NULL DEFAULT '',
+		watermark_opacity REAL NOT NULL DEFAULT 0,
+		feed_title TEXT NOT NULL DEFAULT '',
+		updated_at TIMESTAMP NOT NULL
+	);
+	`
+	_, err = c.db.Exec(tenantBrandingTable)
+	if err != nil {
+		return err
+	}
+
+	ingestTables := `
+	CREATE TABLE IF NOT EXISTS ingest_keys (
+		user_id TEXT PRIMARY KEY,
+		secret TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS ingest_batches (
+		id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		reference TEXT NOT NULL,
+		visibility TEXT NOT NULL,
+		status TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		published_at TIMESTAMP,
+		UNIQUE(user_id, reference)
+	);
+	CREATE TABLE IF NOT EXISTS ingest_items (
+		video_id TEXT PRIMARY KEY,
+		batch_id TEXT NOT NULL,
+		position INTEGER NOT NULL,
+		filename TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		sha256 TEXT NOT NULL,
+		status TEXT NOT NULL,
+		error TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX IF NOT EXISTS ingest_items_batch ON ingest_items(batch_id, position);
+	`
+	_, err = c.db.Exec(ingestTables)
+	if err != nil {
+		return err
+	}
+
+	s3ImportTables := `
+	CREATE TABLE IF NOT EXISTS s3_sources (
+		user_id TEXT PRIMARY KEY,
+		role_arn TEXT NOT NULL,
+		external_id TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		updated_at TIMESTAMP NOT NULL,
+		FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+	);
+	CREATE TABLE IF NOT EXISTS s3_imports (
+		user_id TEXT NOT NULL,
+		bucket TEXT NOT NULL,
+		key TEXT NOT NULL,
+		etag TEXT NOT NULL,
+		video_id TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (user_id, bucket, key, etag)
+	);
+	`
+	_, err = c.db.Exec(s3ImportTables)
+	if err != nil {
+		return err
+	}
+
+	eventTable := `
+	CREATE TABLE IF NOT EXISTS events (
+		seq INTEGER PRIMARY KEY AUTOINCREMENT,
+		id TEXT NOT NULL UNIQUE,
+		type TEXT NOT NULL,
+		video_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		tenant_id TEXT NOT NULL DEFAULT '',
+		occurred_at TIMESTAMP NOT NULL,
+		data TEXT
+	);
+	CREATE INDEX IF NOT EXISTS events_user ON events(user_id, seq);
+	CREATE INDEX IF NOT EXISTS events_tenant ON events(tenant_id, seq);
+	`
+	_, err = c.db.Exec(eventTable)
+	if err != nil {
+		return err
+	}
+
+	warehouseExportTable := `
+	CREATE TABLE IF NOT EXISTS warehouse_exports (
+		day TEXT PRIMARY KEY,
+		videos INTEGER NOT NULL,
+		events INTEGER NOT NULL,
+		usage INTEGER NOT NULL,
+		exported_at TIMESTAMP NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS events_occurred_at ON events(occurred_at);
+	`
+	_, err = c.db.Exec(warehouseExportTable)
+	if err != nil {
+		return err
+	}
+
+	externalIDTable := `
+	CREATE TABLE IF NOT EXISTS external_ids (
+		user_id TEXT NOT NULL,
+		source TEXT NOT NULL,
+		external_id TEXT NOT NULL,
+		video_id TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (user_id, source, external_id),
+		FOREIGN KEY(video_id) REFERENCES videos(id) ON DELETE CASCADE
+	);
+	CREATE INDEX IF NOT EXISTS external_ids_video_id ON external_ids(video_id);
+	`
+	_, err = c.db.Exec(externalIDTable)
+	if err != nil {
+		return err
+	}
+
+	deviceTokenTable := `
+	CREATE TABLE IF NOT EXISTS device_tokens (
+		id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		device_id TEXT NOT NULL,
+		device_name TEXT NOT NULL,
+		token_hash TEXT NOT NULL UNIQUE,
+		prefix TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		last_used_at TIMESTAMP,
+		revoked_at TIMESTAMP,
+		FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+	);
+	CREATE INDEX IF NOT EXISTS device_tokens_user_id ON device_tokens(user_id, device_id);
+	`
+	_, err = c.db.Exec(deviceTokenTable)
+	if err != nil {
+		return err
+	}
+
+	hlsRenditionTable := `
+	CREATE TABLE IF NOT EXISTS hls_renditions (
+		video_id TEXT NOT NULL,
+		master_key TEXT NOT NULL,
+		name TEXT NOT NULL,
+		width INTEGER NOT NULL,
+		height INTEGER NOT NULL,
+		maxrate_kbps INTEGER NOT NULL,
+		status TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		last_error TEXT NOT NULL DEFAULT '',
+		next_attempt_at TIMESTAMP,
+		updated_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (video_id, name),
+		FOREIGN KEY(video_id) REFERENCES videos(id) ON DELETE CASCADE
+	);
+	CREATE INDEX IF NOT EXISTS hls_renditions_due ON hls_renditions(status, next_attempt_at);
+	`
+	_, err = c.db.Exec(hlsRenditionTable)
+	if err != nil {
+		return err
+	}
+
+	scheduledTaskTable := `
+	CREATE TABLE IF NOT EXISTS scheduled_tasks (
+		name TEXT PRIMARY KEY,
+		schedule TEXT NOT NULL DEFAULT '',
+		paused BOOLEAN NOT NULL DEFAULT FALSE,
+		next_run_at TIMESTAMP
+	);
+	CREATE TABLE IF NOT EXISTS scheduled_task_runs (
+		id TEXT PRIMARY KEY,
+		task TEXT NOT NULL,
+		trigger TEXT NOT NULL,
+		status TEXT NOT NULL,
+		error TEXT NOT NULL DEFAULT '',
+		result TEXT,
+		started_at TIMESTAMP NOT NULL,
+		finished_at TIMESTAMP,
+		duration_ms INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE INDEX IF NOT EXISTS scheduled_task_runs_task ON scheduled_task_runs(task, started_at);
+	`
+	_, err = c.db.Exec(scheduledTaskTable)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// addColumnIfMissing adds a column to a table created by an earlier version
+// of autoMigrate.
+func (c *Client) addColumnIfMissing(table, column, definition string) error {
+	rows, err := c.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid        int
+			name       string
+			colType    string
+			notNull    bool
+			defaultVal sql.NullString
+			primaryKey int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultVal, &primaryKey); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = c.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	if err != nil {
+		return fmt.Errorf("failed to add column %s.%s: %w", table, column, err)
+	}
 	return nil
 }
 
+func isNoRows(err error) bool {
+	return errors.Is(err, sql.ErrNoRows)
+}
+
 func (c Client) Reset() error {
 	if _, err := c.db.Exec("DELETE FROM refresh_tokens"); err != nil {
 		return fmt.Errorf("failed to reset table refresh_tokens: %w", err)
@@ -82,8 +1067,149 @@ func (c Client) Reset() error {
 	if _, err := c.db.Exec("DELETE FROM users"); err != nil {
 		return fmt.Errorf("failed to reset table users: %w", err)
 	}
+	if _, err := c.db.Exec("DELETE FROM audio_tracks"); err != nil {
+		return fmt.Errorf("failed to reset table audio_tracks: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM link_checks"); err != nil {
+		return fmt.Errorf("failed to reset table link_checks: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM renditions"); err != nil {
+		return fmt.Errorf("failed to reset table renditions: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM media_info"); err != nil {
+		return fmt.Errorf("failed to reset table media_info: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM keyframes"); err != nil {
+		return fmt.Errorf("failed to reset table keyframes: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM processing_logs"); err != nil {
+		return fmt.Errorf("failed to reset table processing_logs: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM access_events"); err != nil {
+		return fmt.Errorf("failed to reset table access_events: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM reports"); err != nil {
+		return fmt.Errorf("failed to reset table reports: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM fingerprint_references"); err != nil {
+		return fmt.Errorf("failed to reset table fingerprint_references: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM thumbnail_variants"); err != nil {
+		return fmt.Errorf("failed to reset table thumbnail_variants: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM thumbnail_candidates"); err != nil {
+		return fmt.Errorf("failed to reset table thumbnail_candidates: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM caption_tracks"); err != nil {
+		return fmt.Errorf("failed to reset table caption_tracks: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM upload_parts"); err != nil {
+		return fmt.Errorf("failed to reset table upload_parts: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM upload_sessions"); err != nil {
+		return fmt.Errorf("failed to reset table upload_sessions: %w", err)
+	}
 	if _, err := c.db.Exec("DELETE FROM videos"); err != nil {
 		return fmt.Errorf("failed to reset table videos: %w", err)
 	}
+	if _, err := c.db.Exec("DELETE FROM watch_progress"); err != nil {
+		return fmt.Errorf("failed to reset table watch_progress: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM user_activity"); err != nil {
+		return fmt.Errorf("failed to reset table user_activity: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM view_counts"); err != nil {
+		return fmt.Errorf("failed to reset table view_counts: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM view_totals"); err != nil {
+		return fmt.Errorf("failed to reset table view_totals: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM user_settings"); err != nil {
+		return fmt.Errorf("failed to reset table user_settings: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM storage_usage"); err != nil {
+		return fmt.Errorf("failed to reset table storage_usage: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM storage_quotas"); err != nil {
+		return fmt.Errorf("failed to reset table storage_quotas: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM upload_presets"); err != nil {
+		return fmt.Errorf("failed to reset table upload_presets: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM series_episodes"); err != nil {
+		return fmt.Errorf("failed to reset table series_episodes: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM series"); err != nil {
+		return fmt.Errorf("failed to reset table series: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM content_objects"); err != nil {
+		return fmt.Errorf("failed to reset table content_objects: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM api_keys"); err != nil {
+		return fmt.Errorf("failed to reset table api_keys: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM device_tokens"); err != nil {
+		return fmt.Errorf("failed to reset table device_tokens: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM hls_renditions"); err != nil {
+		return fmt.Errorf("failed to reset table hls_renditions: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM scheduled_task_runs"); err != nil {
+		return fmt.Errorf("failed to reset table scheduled_task_runs: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM scheduled_tasks"); err != nil {
+		return fmt.Errorf("failed to reset table scheduled_tasks: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM webhook_deliveries"); err != nil {
+		return fmt.Errorf("failed to reset table webhook_deliveries: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM webhooks"); err != nil {
+		return fmt.Errorf("failed to reset table webhooks: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM sftp_accounts"); err != nil {
+		return fmt.Errorf("failed to reset table sftp_accounts: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM sftp_ingests"); err != nil {
+		return fmt.Errorf("failed to reset table sftp_ingests: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM email_addresses"); err != nil {
+		return fmt.Errorf("failed to reset table email_addresses: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM email_ingests"); err != nil {
+		return fmt.Errorf("failed to reset table email_ingests: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM notification_channels"); err != nil {
+		return fmt.Errorf("failed to reset table notification_channels: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM scan_verdicts"); err != nil {
+		return fmt.Errorf("failed to reset table scan_verdicts: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM tenant_branding"); err != nil {
+		return fmt.Errorf("failed to reset table tenant_branding: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM ingest_keys"); err != nil {
+		return fmt.Errorf("failed to reset table ingest_keys: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM ingest_batches"); err != nil {
+		return fmt.Errorf("failed to reset table ingest_batches: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM ingest_items"); err != nil {
+		return fmt.Errorf("failed to reset table ingest_items: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM s3_sources"); err != nil {
+		return fmt.Errorf("failed to reset table s3_sources: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM s3_imports"); err != nil {
+		return fmt.Errorf("failed to reset table s3_imports: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM events"); err != nil {
+		return fmt.Errorf("failed to reset table events: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM warehouse_exports"); err != nil {
+		return fmt.Errorf("failed to reset table warehouse_exports: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM external_ids"); err != nil {
+		return fmt.Errorf("failed to reset table external_ids: %w", err)
+	}
 	return nil
 }
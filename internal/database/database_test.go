@@ -0,0 +1,79 @@
+package database
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestWriteFault(t *testing.T) {
+	errFault := errors.New("database write failed")
+	tests := []struct {
+		name  string
+		write func(c Client) error
+	}{
+		{
+			name: "exec",
+			write: func(c Client) error {
+				_, err := c.CreateUser(CreateUserParams{Email: uuid.NewString() + "@example.com", Password: "hash"})
+				return err
+			},
+		},
+		{
+			name: "transaction",
+			write: func(c Client) error {
+				now := time.Now().UTC()
+				return c.FinishTaskRun(TaskRun{ID: uuid.New(), Task: "janitor", Status: TaskRunSucceeded, FinishedAt: &now})
+			},
+		},
+		{
+			name: "insert returning",
+			write: func(c Client) error {
+				_, err := c.RetainContentObject("bucket", uuid.NewString())
+				return err
+			},
+		},
+		{
+			name: "update returning",
+			write: func(c Client) error {
+				_, _, err := c.ReleaseContentObject("bucket", uuid.NewString())
+				return err
+			},
+		},
+		{
+			name: "insert select returning",
+			write: func(c Client) error {
+				_, err := c.AddEpisode(uuid.New(), uuid.New(), 0)
+				return err
+			},
+		},
+	}
+
+	c, err := NewClient(filepath.Join(t.TempDir(), "tubely.db"))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	faulty := c.WithWriteFault(func() error { return errFault })
+	healthy := c.WithWriteFault(func() error { return nil })
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.write(faulty); !errors.Is(err, errFault) {
+				t.Errorf("with a fault: %v, want the fault's error", err)
+			}
+			if err := tt.write(healthy); err != nil {
+				t.Errorf("with a fault that didn't fire: %v", err)
+			}
+			if err := tt.write(c); err != nil {
+				t.Errorf("without a fault: %v", err)
+			}
+		})
+	}
+
+	// Reads go ahead regardless.
+	if _, err := faulty.GetUsers(); err != nil {
+		t.Errorf("GetUsers with a fault: %v", err)
+	}
+}
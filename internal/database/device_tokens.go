@@ -0,0 +1,135 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DeviceToken is a long-lived, playback-only credential a user's JWT is
+// exchanged for on a device such as a TV, which can only use it along with
+// its DeviceID. Only a hash of the token itself is stored; Prefix is its
+// first characters, for telling tokens apart.
+type DeviceToken struct {
+	ID         uuid.UUID  `json:"id"`
+	UserID     uuid.UUID  `json:"-"`
+	DeviceID   string     `json:"device_id"`
+	DeviceName string     `json:"device_name"`
+	TokenHash  string     `json:"-"`
+	Prefix     string     `json:"prefix"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
+const deviceTokenColumns = `id, user_id, device_id, device_name, token_hash, prefix, created_at, expires_at, last_used_at, revoked_at`
+
+// CreateDeviceToken stores a token, revoking any other the user has for
+// the same device, so a device holds one token at a time.
+func (c Client) CreateDeviceToken(t DeviceToken) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE device_tokens SET revoked_at = ? WHERE user_id = ? AND device_id = ? AND revoked_at IS NULL", t.CreatedAt, t.UserID, t.DeviceID)
+	if err != nil {
+		return err
+	}
+	query := `
+	INSERT INTO device_tokens (` + deviceTokenColumns + `)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err = tx.Exec(query, t.ID, t.UserID, t.DeviceID, t.DeviceName, t.TokenHash, t.Prefix, t.CreatedAt, t.ExpiresAt, t.LastUsedAt, t.RevokedAt)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetDeviceTokens returns the user's tokens, revoked and expired ones
+// included, oldest first.
+func (c Client) GetDeviceTokens(userID uuid.UUID) ([]DeviceToken, error) {
+	query := `
+	SELECT ` + deviceTokenColumns + `
+	FROM device_tokens
+	WHERE user_id = ?
+	ORDER BY created_at
+	`
+	rows, err := c.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []DeviceToken{}
+	for rows.Next() {
+		t, err := scanDeviceToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+// GetDeviceToken returns the token, or nil if it doesn't exist.
+func (c Client) GetDeviceToken(id uuid.UUID) (*DeviceToken, error) {
+	query := `
+	SELECT ` + deviceTokenColumns + `
+	FROM device_tokens
+	WHERE id = ?
+	`
+	t, err := scanDeviceToken(c.db.QueryRow(query, id))
+	if isNoRows(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// GetDeviceTokenByHash returns the token with the hash, or nil if there is
+// none.
+func (c Client) GetDeviceTokenByHash(hash string) (*DeviceToken, error) {
+	query := `
+	SELECT ` + deviceTokenColumns + `
+	FROM device_tokens
+	WHERE token_hash = ?
+	`
+	t, err := scanDeviceToken(c.db.QueryRow(query, hash))
+	if isNoRows(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func scanDeviceToken(row rowScanner) (DeviceToken, error) {
+	var t DeviceToken
+	err := row.Scan(&t.ID, &t.UserID, &t.DeviceID, &t.DeviceName, &t.TokenHash, &t.Prefix, &t.CreatedAt, &t.ExpiresAt, &t.LastUsedAt, &t.RevokedAt)
+	if err != nil {
+		return DeviceToken{}, err
+	}
+	t.CreatedAt = t.CreatedAt.UTC()
+	t.ExpiresAt = t.ExpiresAt.UTC()
+	return t, nil
+}
+
+// TouchDeviceToken records that the token was used at now.
+func (c Client) TouchDeviceToken(id uuid.UUID, now time.Time) error {
+	_, err := c.db.Exec("UPDATE device_tokens SET last_used_at = ? WHERE id = ?", now, id)
+	return err
+}
+
+// RevokeDeviceToken revokes the token at now. Revoked tokens are kept, so
+// the list still shows when they were last used.
+func (c Client) RevokeDeviceToken(id uuid.UUID, now time.Time) error {
+	_, err := c.db.Exec("UPDATE device_tokens SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL", now, id)
+	return err
+}
@@ -0,0 +1,170 @@
+package database
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// EmailAddress is a user's email-in address: mail to the token at the
+// email-in domain becomes their uploads, if it's from one of Senders or
+// the user's own address.
+type EmailAddress struct {
+	UserID    uuid.UUID `json:"-"`
+	Token     string    `json:"-"`
+	Senders   []string  `json:"senders"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SetEmailAddress creates or replaces the user's email-in address.
+func (c Client) SetEmailAddress(a EmailAddress) error {
+	senders, err := json.Marshal(a.Senders)
+	if err != nil {
+		return err
+	}
+	stored, err := c.encrypt(fieldEmailSenders, string(senders))
+	if err != nil {
+		return err
+	}
+	query := `
+	INSERT INTO email_addresses (user_id, token, senders, created_at)
+	VALUES (?, ?, ?, ?)
+	ON CONFLICT(user_id) DO UPDATE SET token = excluded.token, senders = excluded.senders, created_at = excluded.created_at
+	`
+	_, err = c.db.Exec(query, a.UserID, a.Token, stored, a.CreatedAt)
+	return err
+}
+
+// DeleteEmailAddress turns off the user's email-in address.
+func (c Client) DeleteEmailAddress(userID uuid.UUID) error {
+	_, err := c.db.Exec("DELETE FROM email_addresses WHERE user_id = ?", userID)
+	return err
+}
+
+// GetEmailAddress returns the user's email-in address, or nil if they
+// don't have one.
+func (c Client) GetEmailAddress(userID uuid.UUID) (*EmailAddress, error) {
+	return c.getEmailAddress("user_id = ?", userID)
+}
+
+// GetEmailAddressByToken returns the email-in address with the token, or
+// nil if there isn't one.
+func (c Client) GetEmailAddressByToken(token string) (*EmailAddress, error) {
+	return c.getEmailAddress("token = ?", token)
+}
+
+func (c Client) getEmailAddress(where string, arg any) (*EmailAddress, error) {
+	query := `
+	SELECT user_id, token, senders, created_at
+	FROM email_addresses
+	WHERE ` + where
+	var a EmailAddress
+	var senders string
+	err := c.db.QueryRow(query, arg).Scan(&a.UserID, &a.Token, &senders, &a.CreatedAt)
+	if isNoRows(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := c.decrypt(fieldEmailSenders, &senders); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(senders), &a.Senders); err != nil {
+		return nil, err
+	}
+	if a.Senders == nil {
+		a.Senders = []string{}
+	}
+	a.CreatedAt = a.CreatedAt.UTC()
+	return &a, nil
+}
+
+type EmailIngestStatus string
+
+const (
+	EmailIngestAccepted EmailIngestStatus = "accepted"
+	EmailIngestRejected EmailIngestStatus = "rejected"
+)
+
+// EmailIngest records what became of a message sent to an email-in
+// address, so a message SES reports twice is only handled once.
+type EmailIngest struct {
+	MessageID string            `json:"message_id"`
+	UserID    uuid.UUID         `json:"-"`
+	Sender    string            `json:"sender"`
+	Subject   string            `json:"subject"`
+	Status    EmailIngestStatus `json:"status"`
+	Error     string            `json:"error,omitempty"`
+	VideoIDs  []uuid.UUID       `json:"video_ids"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// CreateEmailIngest records the message. It returns false, recording
+// nothing, if the message was already handled.
+func (c Client) CreateEmailIngest(i EmailIngest) (bool, error) {
+	if i.VideoIDs == nil {
+		i.VideoIDs = []uuid.UUID{}
+	}
+	videoIDs, err := json.Marshal(i.VideoIDs)
+	if err != nil {
+		return false, err
+	}
+	sender, err := c.encrypt(fieldEmailIngestSender, i.Sender)
+	if err != nil {
+		return false, err
+	}
+	query := `
+	INSERT OR IGNORE INTO email_ingests (message_id, user_id, sender, subject, status, error, video_ids, created_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	res, err := c.db.Exec(query, i.MessageID, i.UserID, sender, i.Subject, i.Status, i.Error, string(videoIDs), i.CreatedAt)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// EmailIngestExists reports whether the message was already handled.
+func (c Client) EmailIngestExists(messageID string) (bool, error) {
+	var n int
+	err := c.db.QueryRow("SELECT COUNT(*) FROM email_ingests WHERE message_id = ?", messageID).Scan(&n)
+	return n > 0, err
+}
+
+// GetEmailIngests returns the latest messages sent to the user's email-in
+// address, newest first.
+func (c Client) GetEmailIngests(userID uuid.UUID, limit int) ([]EmailIngest, error) {
+	query := `
+	SELECT message_id, user_id, sender, subject, status, error, video_ids, created_at
+	FROM email_ingests
+	WHERE user_id = ?
+	ORDER BY created_at DESC
+	LIMIT ?
+	`
+	rows, err := c.db.Query(query, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ingests := []EmailIngest{}
+	for rows.Next() {
+		var i EmailIngest
+		var videoIDs string
+		if err := rows.Scan(&i.MessageID, &i.UserID, &i.Sender, &i.Subject, &i.Status, &i.Error, &videoIDs, &i.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := c.decrypt(fieldEmailIngestSender, &i.Sender); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(videoIDs), &i.VideoIDs); err != nil {
+			return nil, err
+		}
+		i.CreatedAt = i.CreatedAt.UTC()
+		ingests = append(ingests, i)
+	}
+	return ingests, rows.Err()
+}
@@ -0,0 +1,110 @@
+package database
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Event is a lifecycle event as the event log keeps it. Seq orders the log:
+// it only goes up, so an event's Seq is where to resume reading after it.
+// UserID and TenantID are of the video's owner when the event happened.
+type Event struct {
+	Seq        int64
+	ID         uuid.UUID
+	Type       string
+	VideoID    uuid.UUID
+	UserID     uuid.UUID
+	TenantID   string
+	OccurredAt time.Time
+	Data       json.RawMessage
+}
+
+// AppendEvent adds an event to the end of the log.
+func (c Client) AppendEvent(e Event) error {
+	query := `
+	INSERT INTO events (id, type, video_id, user_id, tenant_id, occurred_at, data)
+	VALUES (?, ?, ?, ?, ?, ?, ?)
+	`
+	var data *string
+	if len(e.Data) > 0 {
+		s := string(e.Data)
+		data = &s
+	}
+	_, err := c.db.Exec(query, e.ID, e.Type, e.VideoID, e.UserID, e.TenantID, e.OccurredAt.UTC(), data)
+	return err
+}
+
+// EventLogParams selects a page of the event log. A nil UserID or TenantID,
+// and empty Types, match every event.
+type EventLogParams struct {
+	UserID   *uuid.UUID
+	TenantID *string
+	Types    []string
+	// After is the Seq of the last event read, or 0 to start from the
+	// beginning.
+	After int64
+	// OccurredFrom and OccurredBefore, unless zero, bound when the events
+	// happened.
+	OccurredFrom   time.Time
+	OccurredBefore time.Time
+	Limit          int
+}
+
+// GetEvents returns the events after params.After that match, oldest
+// first.
+func (c Client) GetEvents(params EventLogParams) ([]Event, error) {
+	where := []string{"seq > ?"}
+	args := []any{params.After}
+	if params.UserID != nil {
+		where = append(where, "user_id = ?")
+		args = append(args, *params.UserID)
+	}
+	if params.TenantID != nil {
+		where = append(where, "tenant_id = ?")
+		args = append(args, *params.TenantID)
+	}
+	if !params.OccurredFrom.IsZero() {
+		where = append(where, "occurred_at >= ?")
+		args = append(args, params.OccurredFrom.UTC())
+	}
+	if !params.OccurredBefore.IsZero() {
+		where = append(where, "occurred_at < ?")
+		args = append(args, params.OccurredBefore.UTC())
+	}
+	if len(params.Types) > 0 {
+		where = append(where, "type IN (?"+strings.Repeat(", ?", len(params.Types)-1)+")")
+		for _, t := range params.Types {
+			args = append(args, t)
+		}
+	}
+	query := `
+	SELECT seq, id, type, video_id, user_id, tenant_id, occurred_at, data
+	FROM events
+	WHERE ` + strings.Join(where, " AND ") + `
+	ORDER BY seq
+	LIMIT ?
+	`
+	rows, err := c.db.Query(query, append(args, params.Limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []Event{}
+	for rows.Next() {
+		var e Event
+		var data *string
+		if err := rows.Scan(&e.Seq, &e.ID, &e.Type, &e.VideoID, &e.UserID, &e.TenantID, &e.OccurredAt, &data); err != nil {
+			return nil, err
+		}
+		e.OccurredAt = e.OccurredAt.UTC()
+		if data != nil {
+			e.Data = json.RawMessage(*data)
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
@@ -0,0 +1,27 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/google/uuid"
+)
+
+// ErrExternalIDTaken is returned for a new video with an external ID
+// another of its owner's videos has.
+var ErrExternalIDTaken = errors.New("external ID is already in use")
+
+// GetVideoIDByExternalID returns the user's video with the external ID
+// from source, or uuid.Nil if there's none.
+func (c Client) GetVideoIDByExternalID(userID uuid.UUID, source, externalID string) (uuid.UUID, error) {
+	query := `
+	SELECT video_id FROM external_ids
+	WHERE user_id = ? AND source = ? AND external_id = ?
+	`
+	var id uuid.UUID
+	err := c.db.QueryRow(query, userID, source, externalID).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return uuid.Nil, nil
+	}
+	return id, err
+}
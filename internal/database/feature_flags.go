@@ -0,0 +1,79 @@
+package database
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type FeatureFlag struct {
+	Name      string      `json:"name"`
+	Enabled   bool        `json:"enabled"`
+	Users     []uuid.UUID `json:"users"`
+	Tenants   []string    `json:"tenants"`
+	Percent   int         `json:"percent"`
+	UpdatedAt time.Time   `json:"updated_at"`
+}
+
+func (c Client) GetFeatureFlags() ([]FeatureFlag, error) {
+	query := `
+	SELECT name, enabled, users, tenants, percent, updated_at
+	FROM feature_flags
+	ORDER BY name
+	`
+	rows, err := c.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	flags := []FeatureFlag{}
+	for rows.Next() {
+		var flag FeatureFlag
+		var users, tenants string
+		if err := rows.Scan(&flag.Name, &flag.Enabled, &users, &tenants, &flag.Percent, &flag.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(users), &flag.Users); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(tenants), &flag.Tenants); err != nil {
+			return nil, err
+		}
+		flags = append(flags, flag)
+	}
+	return flags, rows.Err()
+}
+
+func (c Client) UpsertFeatureFlag(flag FeatureFlag) error {
+	users, err := json.Marshal(flag.Users)
+	if err != nil {
+		return err
+	}
+	tenants, err := json.Marshal(flag.Tenants)
+	if err != nil {
+		return err
+	}
+	query := `
+	INSERT INTO feature_flags (name, enabled, users, tenants, percent, updated_at)
+	VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+	ON CONFLICT(name) DO UPDATE SET
+		enabled = excluded.enabled,
+		users = excluded.users,
+		tenants = excluded.tenants,
+		percent = excluded.percent,
+		updated_at = CURRENT_TIMESTAMP
+	`
+	_, err = c.db.Exec(query, flag.Name, flag.Enabled, string(users), string(tenants), flag.Percent)
+	return err
+}
+
+func (c Client) DeleteFeatureFlag(name string) error {
+	query := `
+	DELETE FROM feature_flags
+	WHERE name = ?
+	`
+	_, err := c.db.Exec(query, name)
+	return err
+}
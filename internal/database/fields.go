@@ -0,0 +1,161 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/fieldcrypt"
+)
+
+// The fields encrypted with the client's keyring. Their names are
+// authenticated with their values, so they mustn't change.
+const (
+	fieldUserEmail         = "users.email"
+	fieldEmailSenders      = "email_addresses.senders"
+	fieldEmailIngestSender = "email_ingests.sender"
+	fieldWebhookSecret     = "webhooks.secret"
+	fieldWebhookHeaders    = "webhooks.headers"
+	fieldNotificationURL   = "notification_channels.url"
+	fieldIngestKeySecret   = "ingest_keys.secret"
+)
+
+// encryptedField is where an encrypted field is stored, and the column
+// that indexes it, if any.
+type encryptedField struct {
+	name   string
+	table  string
+	key    string
+	column string
+	index  string
+}
+
+var encryptedFields = []encryptedField{
+	{name: fieldUserEmail, table: "users", key: "id", column: "email", index: "email_index"},
+	{name: fieldEmailSenders, table: "email_addresses", key: "user_id", column: "senders"},
+	{name: fieldEmailIngestSender, table: "email_ingests", key: "message_id", column: "sender"},
+	{name: fieldWebhookSecret, table: "webhooks", key: "id", column: "secret"},
+	{name: fieldWebhookHeaders, table: "webhooks", key: "id", column: "headers"},
+	{name: fieldNotificationURL, table: "notification_channels", key: "id", column: "url"},
+	{name: fieldIngestKeySecret, table: "ingest_keys", key: "user_id", column: "secret"},
+}
+
+// WithFieldEncryption returns a client that encrypts users' emails, the
+// senders of email-in addresses and ingests, webhook secrets and custom
+// headers, notification channel URLs and manifest signing keys with k.
+// Fields stored before are still read, and ReencryptFields encrypts them.
+func (c Client) WithFieldEncryption(k *fieldcrypt.Keyring) Client {
+	c.fields = k
+	return c
+}
+
+func (c Client) encrypt(field, value string) (string, error) {
+	return c.fields.Encrypt(field, value)
+}
+
+// decrypt decrypts a field that has been scanned into value, in place.
+func (c Client) decrypt(field string, value *string) error {
+	v, err := c.fields.Decrypt(field, *value)
+	if err != nil {
+		return err
+	}
+	*value = v
+	return nil
+}
+
+// emailIndex is the email_index of a user with the email, which is NULL
+// while emails aren't encrypted.
+func (c Client) emailIndex(email string) any {
+	if index := c.fields.Index(fieldUserEmail, email); index != "" {
+		return index
+	}
+	return nil
+}
+
+// ReencryptFields encrypts every encrypted field that's stored as it is,
+// or with a key other than the primary one, with the primary key, and
+// brings the email index up to date. It returns how many rows it updated,
+// by field. A row is only updated if it hasn't changed since it was read,
+// so the server can keep running meanwhile; a row that did change was
+// written with the primary key anyway.
+func (c Client) ReencryptFields() (map[string]int, error) {
+	if c.fields == nil {
+		return nil, errors.New("no encryption keys are set")
+	}
+	updated := map[string]int{}
+	for _, f := range encryptedFields {
+		n, err := c.reencryptField(f)
+		if err != nil {
+			return updated, fmt.Errorf("%s: %w", f.name, err)
+		}
+		updated[f.name] = n
+	}
+	return updated, nil
+}
+
+func (c Client) reencryptField(f encryptedField) (int, error) {
+	index := "NULL"
+	if f.index != "" {
+		index = f.index
+	}
+	rows, err := c.db.Query(fmt.Sprintf("SELECT %s, %s, %s FROM %s", f.key, f.column, index, f.table))
+	if err != nil {
+		return 0, err
+	}
+	type row struct {
+		key, stored string
+		index       sql.NullString
+	}
+	var all []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.key, &r.stored, &r.index); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		all = append(all, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	updated := 0
+	for _, r := range all {
+		value, err := c.fields.Decrypt(f.name, r.stored)
+		if err != nil {
+			return updated, err
+		}
+		if value == "" {
+			continue
+		}
+		stored := r.stored
+		if fieldcrypt.KeyID(stored) != c.fields.Primary() {
+			if stored, err = c.encrypt(f.name, value); err != nil {
+				return updated, err
+			}
+		}
+		var wantIndex string
+		if f.index != "" {
+			wantIndex = c.fields.Index(f.name, value)
+		}
+		if stored == r.stored && wantIndex == r.index.String {
+			continue
+		}
+
+		query := fmt.Sprintf("UPDATE %s SET %s = ? WHERE %s = ? AND %s = ?", f.table, f.column, f.key, f.column)
+		args := []any{stored, r.key, r.stored}
+		if f.index != "" {
+			query = fmt.Sprintf("UPDATE %s SET %s = ?, %s = ? WHERE %s = ? AND %s = ?", f.table, f.column, f.index, f.key, f.column)
+			args = []any{stored, wantIndex, r.key, r.stored}
+		}
+		res, err := c.db.Exec(query, args...)
+		if err != nil {
+			return updated, err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			updated++
+		}
+	}
+	return updated, nil
+}
@@ -0,0 +1,57 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// FingerprintReference is a registered work that uploads are checked
+// against. Fingerprint is the encoded audio fingerprint.
+type FingerprintReference struct {
+	ID          uuid.UUID `json:"id"`
+	Title       string    `json:"title"`
+	Frames      int       `json:"frames"`
+	CreatedAt   time.Time `json:"created_at"`
+	Fingerprint []byte    `json:"-"`
+}
+
+func (c Client) CreateFingerprintReference(ref FingerprintReference) error {
+	query := `
+	INSERT INTO fingerprint_references (id, title, frames, fingerprint, created_at)
+	VALUES (?, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, ref.ID, ref.Title, ref.Frames, ref.Fingerprint, ref.CreatedAt)
+	return err
+}
+
+// GetFingerprintReferences returns every reference, oldest first, with
+// their fingerprints.
+func (c Client) GetFingerprintReferences() ([]FingerprintReference, error) {
+	query := `
+	SELECT id, title, frames, fingerprint, created_at
+	FROM fingerprint_references
+	ORDER BY created_at
+	`
+	rows, err := c.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	refs := []FingerprintReference{}
+	for rows.Next() {
+		var ref FingerprintReference
+		if err := rows.Scan(&ref.ID, &ref.Title, &ref.Frames, &ref.Fingerprint, &ref.CreatedAt); err != nil {
+			return nil, err
+		}
+		ref.CreatedAt = ref.CreatedAt.UTC()
+		refs = append(refs, ref)
+	}
+	return refs, rows.Err()
+}
+
+func (c Client) DeleteFingerprintReference(id uuid.UUID) error {
+	_, err := c.db.Exec("DELETE FROM fingerprint_references WHERE id = ?", id)
+	return err
+}
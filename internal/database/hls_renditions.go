@@ -0,0 +1,135 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// The status of an HLS rendition. A pending rendition is encoded after
+// the video is published, from NextAttemptAt. A retrying one failed to
+// encode and is tried again at NextAttemptAt; a failed one ran out of
+// attempts.
+const (
+	HLSRenditionPending  = "pending"
+	HLSRenditionEncoding = "encoding"
+	HLSRenditionReady    = "ready"
+	HLSRenditionRetrying = "retrying"
+	HLSRenditionFailed   = "failed"
+)
+
+// HLSRendition is one rung of a video's HLS output, under the master
+// playlist at MasterKey. The master playlist lists the ready ones.
+type HLSRendition struct {
+	VideoID       uuid.UUID  `json:"-"`
+	MasterKey     string     `json:"-"`
+	Name          string     `json:"name"`
+	Width         int        `json:"width"`
+	Height        int        `json:"height"`
+	MaxrateKbps   int        `json:"-"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	LastError     string     `json:"last_error,omitempty"`
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+const hlsRenditionColumns = `video_id, master_key, name, width, height, maxrate_kbps, status, attempts, last_error, next_attempt_at, updated_at`
+
+// ReplaceHLSRenditions stores the HLS renditions of a video's current
+// file, replacing those of any previous upload.
+func (c Client) ReplaceHLSRenditions(videoID uuid.UUID, renditions []HLSRendition) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM hls_renditions WHERE video_id = ?", videoID); err != nil {
+		return err
+	}
+	query := `
+	INSERT INTO hls_renditions (` + hlsRenditionColumns + `)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	for _, r := range renditions {
+		if _, err := tx.Exec(query, videoID, r.MasterKey, r.Name, r.Width, r.Height, r.MaxrateKbps, r.Status, r.Attempts, r.LastError, r.NextAttemptAt, r.UpdatedAt); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetHLSRenditions returns a video's HLS renditions, largest first.
+func (c Client) GetHLSRenditions(videoID uuid.UUID) ([]HLSRendition, error) {
+	query := `
+	SELECT ` + hlsRenditionColumns + `
+	FROM hls_renditions
+	WHERE video_id = ?
+	ORDER BY height DESC
+	`
+	return c.queryHLSRenditions(query, videoID)
+}
+
+// GetDueHLSRenditions returns up to limit pending or retrying renditions
+// whose next attempt is due at now, the longest overdue first and the
+// smallest first of those due at once.
+func (c Client) GetDueHLSRenditions(now time.Time, limit int) ([]HLSRendition, error) {
+	query := `
+	SELECT ` + hlsRenditionColumns + `
+	FROM hls_renditions
+	WHERE status IN (?, ?) AND next_attempt_at <= ?
+	ORDER BY next_attempt_at, height
+	LIMIT ?
+	`
+	return c.queryHLSRenditions(query, HLSRenditionPending, HLSRenditionRetrying, now, limit)
+}
+
+// RequeueEncodingHLSRenditions makes the renditions that were being
+// encoded when the server stopped due again at now. The interrupted
+// attempt doesn't count.
+func (c Client) RequeueEncodingHLSRenditions(now time.Time) error {
+	query := `
+	UPDATE hls_renditions
+	SET status = CASE WHEN attempts = 0 THEN ? ELSE ? END, next_attempt_at = ?, updated_at = ?
+	WHERE status = ?
+	`
+	_, err := c.db.Exec(query, HLSRenditionPending, HLSRenditionRetrying, now, now, HLSRenditionEncoding)
+	return err
+}
+
+func (c Client) queryHLSRenditions(query string, args ...any) ([]HLSRendition, error) {
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	renditions := []HLSRendition{}
+	for rows.Next() {
+		var r HLSRendition
+		if err := rows.Scan(&r.VideoID, &r.MasterKey, &r.Name, &r.Width, &r.Height, &r.MaxrateKbps, &r.Status, &r.Attempts, &r.LastError, &r.NextAttemptAt, &r.UpdatedAt); err != nil {
+			return nil, err
+		}
+		r.UpdatedAt = r.UpdatedAt.UTC()
+		renditions = append(renditions, r)
+	}
+	return renditions, rows.Err()
+}
+
+// UpdateHLSRendition saves the status of a rendition after an attempt. It
+// reports false if the video's HLS output has been replaced since, and the
+// rendition is gone with it.
+func (c Client) UpdateHLSRendition(r HLSRendition) (bool, error) {
+	query := `
+	UPDATE hls_renditions
+	SET status = ?, attempts = ?, last_error = ?, next_attempt_at = ?, updated_at = ?
+	WHERE video_id = ? AND master_key = ? AND name = ?
+	`
+	res, err := c.db.Exec(query, r.Status, r.Attempts, r.LastError, r.NextAttemptAt, r.UpdatedAt, r.VideoID, r.MasterKey, r.Name)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
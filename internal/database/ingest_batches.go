@@ -0,0 +1,260 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// IngestKey is the secret a partner signs its upload manifests with.
+type IngestKey struct {
+	UserID    uuid.UUID
+	Secret    string
+	CreatedAt time.Time
+}
+
+// SetIngestKey creates or replaces the user's manifest signing key.
+func (c Client) SetIngestKey(k IngestKey) error {
+	secret, err := c.encrypt(fieldIngestKeySecret, k.Secret)
+	if err != nil {
+		return err
+	}
+	query := `
+	INSERT INTO ingest_keys (user_id, secret, created_at)
+	VALUES (?, ?, ?)
+	ON CONFLICT(user_id) DO UPDATE SET
+		secret = excluded.secret,
+		created_at = excluded.created_at
+	`
+	_, err = c.db.Exec(query, k.UserID, secret, k.CreatedAt)
+	return err
+}
+
+// GetIngestKey returns the user's manifest signing key, or nil if they
+// have none.
+func (c Client) GetIngestKey(userID uuid.UUID) (*IngestKey, error) {
+	var k IngestKey
+	err := c.db.QueryRow("SELECT user_id, secret, created_at FROM ingest_keys WHERE user_id = ?", userID).Scan(&k.UserID, &k.Secret, &k.CreatedAt)
+	if isNoRows(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := c.decrypt(fieldIngestKeySecret, &k.Secret); err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+// DeleteIngestKey removes the user's manifest signing key. It returns
+// false if they had none.
+func (c Client) DeleteIngestKey(userID uuid.UUID) (bool, error) {
+	res, err := c.db.Exec("DELETE FROM ingest_keys WHERE user_id = ?", userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// An ingest batch is pending until every item has verified, and then
+// published. Its items are pending until their file is uploaded and
+// processed, then verified, or failed if it didn't match the manifest or
+// couldn't be processed; a failed item can be uploaded again.
+const (
+	IngestBatchPending   = "pending"
+	IngestBatchPublished = "published"
+
+	IngestItemPending  = "pending"
+	IngestItemVerified = "verified"
+	IngestItemFailed   = "failed"
+)
+
+// IngestBatch is a manifest of videos a partner submitted to be uploaded
+// and published together. Reference is the partner's own name for it,
+// unique per user, and Visibility what the videos get once they're
+// published; they're private until then.
+type IngestBatch struct {
+	ID          uuid.UUID    `json:"id"`
+	UserID      uuid.UUID    `json:"-"`
+	Reference   string       `json:"reference"`
+	Visibility  string       `json:"visibility"`
+	Status      string       `json:"status"`
+	CreatedAt   time.Time    `json:"created_at"`
+	PublishedAt *time.Time   `json:"published_at,omitempty"`
+	Items       []IngestItem `json:"items,omitempty"`
+}
+
+// IngestItem is a file of an ingest batch, which becomes VideoID. Size and
+// SHA256 are what the manifest declared the file to be.
+type IngestItem struct {
+	VideoID  uuid.UUID `json:"video_id"`
+	BatchID  uuid.UUID `json:"-"`
+	Filename string    `json:"filename"`
+	Size     int64     `json:"size"`
+	SHA256   string    `json:"sha256"`
+	Status   string    `json:"status"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// CreateIngestBatch stores a batch and its items. It returns false,
+// storing nothing, if the user already has a batch with its reference.
+func (c Client) CreateIngestBatch(b IngestBatch) (bool, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	query := `
+	INSERT OR IGNORE INTO ingest_batches (id, user_id, reference, visibility, status, created_at)
+	VALUES (?, ?, ?, ?, ?, ?)
+	`
+	res, err := tx.Exec(query, b.ID, b.UserID, b.Reference, b.Visibility, b.Status, b.CreatedAt)
+	if err != nil {
+		return false, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+	query = `
+	INSERT INTO ingest_items (video_id, batch_id, position, filename, size, sha256, status, error)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	for i, item := range b.Items {
+		if _, err := tx.Exec(query, item.VideoID, b.ID, i, item.Filename, item.Size, item.SHA256, item.Status, item.Error); err != nil {
+			return false, err
+		}
+	}
+	return true, tx.Commit()
+}
+
+const ingestBatchColumns = `id, user_id, reference, visibility, status, created_at, published_at`
+
+func (c Client) scanIngestBatch(row rowScanner) (IngestBatch, error) {
+	var b IngestBatch
+	err := row.Scan(&b.ID, &b.UserID, &b.Reference, &b.Visibility, &b.Status, &b.CreatedAt, &b.PublishedAt)
+	if err != nil {
+		return b, err
+	}
+	b.CreatedAt = b.CreatedAt.UTC()
+	if b.PublishedAt != nil {
+		publishedAt := b.PublishedAt.UTC()
+		b.PublishedAt = &publishedAt
+	}
+	return b, nil
+}
+
+// GetIngestBatch returns the batch with its items, or nil if it doesn't
+// exist.
+func (c Client) GetIngestBatch(id uuid.UUID) (*IngestBatch, error) {
+	row := c.db.QueryRow("SELECT "+ingestBatchColumns+" FROM ingest_batches WHERE id = ?", id)
+	return c.ingestBatchWithItems(row)
+}
+
+// GetIngestBatchByReference returns the user's batch with the reference,
+// with its items, or nil if they have none.
+func (c Client) GetIngestBatchByReference(userID uuid.UUID, reference string) (*IngestBatch, error) {
+	row := c.db.QueryRow("SELECT "+ingestBatchColumns+" FROM ingest_batches WHERE user_id = ? AND reference = ?", userID, reference)
+	return c.ingestBatchWithItems(row)
+}
+
+func (c Client) ingestBatchWithItems(row rowScanner) (*IngestBatch, error) {
+	b, err := c.scanIngestBatch(row)
+	if isNoRows(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	b.Items, err = c.GetIngestItems(b.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// GetIngestBatches returns the user's batches, newest first, without their
+// items.
+func (c Client) GetIngestBatches(userID uuid.UUID, limit int) ([]IngestBatch, error) {
+	query := `
+	SELECT ` + ingestBatchColumns + `
+	FROM ingest_batches
+	WHERE user_id = ?
+	ORDER BY created_at DESC
+	LIMIT ?
+	`
+	rows, err := c.db.Query(query, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	batches := []IngestBatch{}
+	for rows.Next() {
+		b, err := c.scanIngestBatch(rows)
+		if err != nil {
+			return nil, err
+		}
+		batches = append(batches, b)
+	}
+	return batches, rows.Err()
+}
+
+const ingestItemColumns = `video_id, batch_id, filename, size, sha256, status, error`
+
+func scanIngestItem(row rowScanner) (IngestItem, error) {
+	var i IngestItem
+	err := row.Scan(&i.VideoID, &i.BatchID, &i.Filename, &i.Size, &i.SHA256, &i.Status, &i.Error)
+	return i, err
+}
+
+// GetIngestItems returns a batch's items in the manifest's order.
+func (c Client) GetIngestItems(batchID uuid.UUID) ([]IngestItem, error) {
+	rows, err := c.db.Query("SELECT "+ingestItemColumns+" FROM ingest_items WHERE batch_id = ? ORDER BY position", batchID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []IngestItem{}
+	for rows.Next() {
+		i, err := scanIngestItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	return items, rows.Err()
+}
+
+// GetIngestItem returns the batch item the video was created for, or nil
+// if it wasn't.
+func (c Client) GetIngestItem(videoID uuid.UUID) (*IngestItem, error) {
+	i, err := scanIngestItem(c.db.QueryRow("SELECT "+ingestItemColumns+" FROM ingest_items WHERE video_id = ?", videoID))
+	if isNoRows(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &i, nil
+}
+
+func (c Client) SetIngestItemStatus(videoID uuid.UUID, status, errMsg string) error {
+	_, err := c.db.Exec("UPDATE ingest_items SET status = ?, error = ? WHERE video_id = ?", status, errMsg, videoID)
+	return err
+}
+
+// PublishIngestBatch marks a pending batch as published at now. It
+// returns false if the batch wasn't pending, so only one caller goes on to
+// publish its videos.
+func (c Client) PublishIngestBatch(id uuid.UUID, now time.Time) (bool, error) {
+	res, err := c.db.Exec("UPDATE ingest_batches SET status = ?, published_at = ? WHERE id = ? AND status = ?", IngestBatchPublished, now, id, IngestBatchPending)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
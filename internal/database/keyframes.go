@@ -0,0 +1,60 @@
+package database
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Keyframes is the keyframe index of a video's file: the timestamps, in
+// seconds to the millisecond, at which a cut can start without re-encoding.
+type Keyframes struct {
+	VideoID    uuid.UUID `json:"video_id"`
+	Timestamps []float64 `json:"timestamps"`
+	CapturedAt time.Time `json:"captured_at"`
+}
+
+func (c Client) SaveKeyframes(keyframes Keyframes) error {
+	timestamps, err := json.Marshal(keyframes.Timestamps)
+	if err != nil {
+		return err
+	}
+	query := `
+	INSERT INTO keyframes (video_id, timestamps, captured_at)
+	VALUES (?, ?, ?)
+	ON CONFLICT(video_id) DO UPDATE SET
+		timestamps = excluded.timestamps,
+		captured_at = excluded.captured_at
+	`
+	_, err = c.db.Exec(query, keyframes.VideoID, string(timestamps), keyframes.CapturedAt)
+	return err
+}
+
+// GetKeyframes returns the keyframe index of a video, or nil if its file
+// hasn't been indexed.
+func (c Client) GetKeyframes(videoID uuid.UUID) (*Keyframes, error) {
+	query := `
+	SELECT video_id, timestamps, captured_at
+	FROM keyframes
+	WHERE video_id = ?
+	`
+	var keyframes Keyframes
+	var timestamps string
+	err := c.db.QueryRow(query, videoID).Scan(&keyframes.VideoID, &timestamps, &keyframes.CapturedAt)
+	if isNoRows(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(timestamps), &keyframes.Timestamps); err != nil {
+		return nil, err
+	}
+	return &keyframes, nil
+}
+
+func (c Client) DeleteKeyframes(videoID uuid.UUID) error {
+	_, err := c.db.Exec("DELETE FROM keyframes WHERE video_id = ?", videoID)
+	return err
+}
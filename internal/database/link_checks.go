@@ -0,0 +1,63 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type LinkCheck struct {
+	VideoID   uuid.UUID `json:"video_id"`
+	Kind      string    `json:"kind"`
+	URL       string    `json:"url"`
+	Broken    bool      `json:"broken"`
+	Detail    string    `json:"detail,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// SaveLinkCheck records the latest result for a video's link and returns
+// whether the link was previously known to be broken.
+func (c Client) SaveLinkCheck(check LinkCheck) (wasBroken bool, err error) {
+	err = c.db.QueryRow(`
+	SELECT broken FROM link_checks WHERE video_id = ? AND kind = ?
+	`, check.VideoID, check.Kind).Scan(&wasBroken)
+	if err != nil && !isNoRows(err) {
+		return false, err
+	}
+
+	query := `
+	INSERT INTO link_checks (video_id, kind, url, broken, detail, checked_at)
+	VALUES (?, ?, ?, ?, ?, ?)
+	ON CONFLICT(video_id, kind) DO UPDATE SET
+		url = excluded.url,
+		broken = excluded.broken,
+		detail = excluded.detail,
+		checked_at = excluded.checked_at
+	`
+	_, err = c.db.Exec(query, check.VideoID, check.Kind, check.URL, check.Broken, check.Detail, check.CheckedAt)
+	return wasBroken, err
+}
+
+func (c Client) GetBrokenLinks() ([]LinkCheck, error) {
+	query := `
+	SELECT video_id, kind, url, broken, detail, checked_at
+	FROM link_checks
+	WHERE broken = TRUE
+	ORDER BY checked_at DESC
+	`
+	rows, err := c.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	checks := []LinkCheck{}
+	for rows.Next() {
+		var check LinkCheck
+		if err := rows.Scan(&check.VideoID, &check.Kind, &check.URL, &check.Broken, &check.Detail, &check.CheckedAt); err != nil {
+			return nil, err
+		}
+		checks = append(checks, check)
+	}
+	return checks, rows.Err()
+}
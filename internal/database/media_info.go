@@ -0,0 +1,52 @@
+package database
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MediaInfo is the probe output of a video's source upload and of the file
+// produced from it, captured at processing time.
+type MediaInfo struct {
+	VideoID    uuid.UUID       `json:"video_id"`
+	Source     json.RawMessage `json:"source"`
+	Output     json.RawMessage `json:"output"`
+	CapturedAt time.Time       `json:"captured_at"`
+}
+
+func (c Client) SaveMediaInfo(info MediaInfo) error {
+	query := `
+	INSERT INTO media_info (video_id, source, output, captured_at)
+	VALUES (?, ?, ?, ?)
+	ON CONFLICT(video_id) DO UPDATE SET
+		source = excluded.source,
+		output = excluded.output,
+		captured_at = excluded.captured_at
+	`
+	_, err := c.db.Exec(query, info.VideoID, string(info.Source), string(info.Output), info.CapturedAt)
+	return err
+}
+
+// GetMediaInfo returns the captured probe output for a video, or nil if it
+// hasn't been processed since media info capture was added.
+func (c Client) GetMediaInfo(videoID uuid.UUID) (*MediaInfo, error) {
+	query := `
+	SELECT video_id, source, output, captured_at
+	FROM media_info
+	WHERE video_id = ?
+	`
+	var info MediaInfo
+	var source, output string
+	err := c.db.QueryRow(query, videoID).Scan(&info.VideoID, &source, &output, &info.CapturedAt)
+	if isNoRows(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	info.Source = json.RawMessage(source)
+	info.Output = json.RawMessage(output)
+	return &info, nil
+}
@@ -0,0 +1,175 @@
+package database
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// NotificationChannel is a Slack or Discord incoming webhook that gets a
+// message when processing of a video ends. It belongs to a user, for their
+// own videos, or to a tenant, for the videos of all its users; UserID is
+// nil for tenant channels.
+type NotificationChannel struct {
+	ID       uuid.UUID  `json:"id"`
+	UserID   *uuid.UUID `json:"-"`
+	TenantID string     `json:"tenant_id,omitempty"`
+	Kind     string     `json:"kind"`
+	URL      string     `json:"url"`
+	// Rules pick the events the channel is sent; the first rule an event
+	// matches applies. No rules sends every event.
+	Rules      []NotificationRule `json:"rules"`
+	LastSentAt *time.Time         `json:"last_sent_at"`
+	LastError  string             `json:"last_error,omitempty"`
+	CreatedAt  time.Time          `json:"created_at"`
+}
+
+// NotificationRule matches events by type and by the source of the upload
+// they're about, such as upload or sftp. Empty lists match anything.
+// Mention is put at the start of the messages it matches, such as <!here>
+// on Slack or @here on Discord.
+type NotificationRule struct {
+	Events  []string `json:"events,omitempty"`
+	Sources []string `json:"sources,omitempty"`
+	Mention string   `json:"mention,omitempty"`
+}
+
+// Match returns the first of the channel's rules that an event of
+// eventType about an upload from source matches.
+func (n NotificationChannel) Match(eventType, source string) (NotificationRule, bool) {
+	if len(n.Rules) == 0 {
+		return NotificationRule{}, true
+	}
+	for _, rule := range n.Rules {
+		if matchesAny(rule.Events, eventType) && matchesAny(rule.Sources, source) {
+			return rule, true
+		}
+	}
+	return NotificationRule{}, false
+}
+
+func matchesAny(list []string, s string) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+const notificationChannelColumns = `id, user_id, tenant_id, kind, url, rules, last_sent_at, last_error, created_at`
+
+func (c Client) CreateNotificationChannel(n NotificationChannel) error {
+	rules, err := json.Marshal(n.Rules)
+	if err != nil {
+		return err
+	}
+	// Slack and Discord webhook URLs are all it takes to post to a channel.
+	url, err := c.encrypt(fieldNotificationURL, n.URL)
+	if err != nil {
+		return err
+	}
+	query := `
+	INSERT INTO notification_channels (` + notificationChannelColumns + `)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err = c.db.Exec(query, n.ID, n.UserID, n.TenantID, n.Kind, url, string(rules), n.LastSentAt, n.LastError, n.CreatedAt)
+	return err
+}
+
+// GetNotificationChannels returns the user's channels, oldest first.
+func (c Client) GetNotificationChannels(userID uuid.UUID) ([]NotificationChannel, error) {
+	return c.queryNotificationChannels("user_id = ?", userID)
+}
+
+// GetTenantNotificationChannels returns the tenant's channels, oldest
+// first.
+func (c Client) GetTenantNotificationChannels(tenantID string) ([]NotificationChannel, error) {
+	return c.queryNotificationChannels("user_id IS NULL AND tenant_id = ?", tenantID)
+}
+
+func (c Client) queryNotificationChannels(where string, arg any) ([]NotificationChannel, error) {
+	query := `
+	SELECT ` + notificationChannelColumns + `
+	FROM notification_channels
+	WHERE ` + where + `
+	ORDER BY created_at
+	`
+	rows, err := c.db.Query(query, arg)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	channels := []NotificationChannel{}
+	for rows.Next() {
+		n, err := c.scanNotificationChannel(rows)
+		if err != nil {
+			return nil, err
+		}
+		channels = append(channels, n)
+	}
+	return channels, rows.Err()
+}
+
+// GetNotificationChannel returns the channel, or nil if it doesn't exist.
+func (c Client) GetNotificationChannel(id uuid.UUID) (*NotificationChannel, error) {
+	query := `
+	SELECT ` + notificationChannelColumns + `
+	FROM notification_channels
+	WHERE id = ?
+	`
+	n, err := c.scanNotificationChannel(c.db.QueryRow(query, id))
+	if isNoRows(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+func (c Client) scanNotificationChannel(row rowScanner) (NotificationChannel, error) {
+	var n NotificationChannel
+	var rules string
+	err := row.Scan(&n.ID, &n.UserID, &n.TenantID, &n.Kind, &n.URL, &rules, &n.LastSentAt, &n.LastError, &n.CreatedAt)
+	if err != nil {
+		return NotificationChannel{}, err
+	}
+	if err := c.decrypt(fieldNotificationURL, &n.URL); err != nil {
+		return NotificationChannel{}, err
+	}
+	if err := json.Unmarshal([]byte(rules), &n.Rules); err != nil {
+		return NotificationChannel{}, err
+	}
+	if n.Rules == nil {
+		n.Rules = []NotificationRule{}
+	}
+	if n.LastSentAt != nil {
+		t := n.LastSentAt.UTC()
+		n.LastSentAt = &t
+	}
+	n.CreatedAt = n.CreatedAt.UTC()
+	return n, nil
+}
+
+// RecordNotificationSent records how the latest message to the channel
+// went: sent at sentAt, or failed with errMsg.
+func (c Client) RecordNotificationSent(id uuid.UUID, sentAt *time.Time, errMsg string) error {
+	query := `
+	UPDATE notification_channels
+	SET last_sent_at = COALESCE(?, last_sent_at), last_error = ?
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, sentAt, errMsg, id)
+	return err
+}
+
+func (c Client) DeleteNotificationChannel(id uuid.UUID) error {
+	_, err := c.db.Exec("DELETE FROM notification_channels WHERE id = ?", id)
+	return err
+}
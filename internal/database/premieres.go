@@ -0,0 +1,37 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// GetPendingPremieres returns the premieres, of any user, that haven't
+// been started yet, the soonest first.
+func (c Client) GetPendingPremieres() ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE premiere AND publish_at IS NOT NULL AND premiere_started_at IS NULL AND deleted_at IS NULL
+	ORDER BY publish_at, id
+	`
+	return c.queryVideos(query)
+}
+
+// StartPremiere marks a video's premiere as started at now. It returns
+// false if it already was, so only one caller announces it.
+func (c Client) StartPremiere(id uuid.UUID, now time.Time) (bool, error) {
+	res, err := c.db.Exec("UPDATE videos SET premiere_started_at = ? WHERE id = ? AND premiere_started_at IS NULL", now.UTC(), id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// ResetPremiere forgets that a video's premiere has started, for when it's
+// rescheduled.
+func (c Client) ResetPremiere(id uuid.UUID) error {
+	_, err := c.db.Exec("UPDATE videos SET premiere_started_at = NULL WHERE id = ?", id)
+	return err
+}
@@ -0,0 +1,104 @@
+package database
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ProcessingLog records one attempt at processing a video: every ffmpeg
+// run with its stderr, every S3 request, and how the attempt ended.
+// RequestID is the ID of the request that started it, which the server's
+// log lines about it carry too.
+type ProcessingLog struct {
+	ID         uuid.UUID            `json:"id"`
+	VideoID    uuid.UUID            `json:"video_id"`
+	Source     string               `json:"source"`
+	RequestID  string               `json:"request_id,omitempty"`
+	Status     string               `json:"status"`
+	Error      string               `json:"error,omitempty"`
+	StartedAt  time.Time            `json:"started_at"`
+	FinishedAt time.Time            `json:"finished_at"`
+	Entries    []ProcessingLogEntry `json:"entries"`
+}
+
+type ProcessingLogEntry struct {
+	Stage      string    `json:"stage"`
+	Detail     string    `json:"detail"`
+	StartedAt  time.Time `json:"started_at"`
+	DurationMS int64     `json:"duration_ms"`
+	Error      string    `json:"error,omitempty"`
+	Output     string    `json:"output,omitempty"`
+}
+
+// processingLogsPerVideo is how many attempts are kept for each video.
+const processingLogsPerVideo = 20
+
+// SaveProcessingLog stores log and prunes the video's oldest logs beyond
+// processingLogsPerVideo.
+func (c Client) SaveProcessingLog(log ProcessingLog) error {
+	entries, err := json.Marshal(log.Entries)
+	if err != nil {
+		return err
+	}
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+	INSERT INTO processing_logs (id, video_id, source, request_id, status, error, started_at, finished_at, entries)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err = tx.Exec(query, log.ID, log.VideoID, log.Source, log.RequestID, log.Status, log.Error, log.StartedAt, log.FinishedAt, string(entries))
+	if err != nil {
+		return err
+	}
+
+	prune := `
+	DELETE FROM processing_logs
+	WHERE video_id = ? AND id NOT IN (
+		SELECT id FROM processing_logs
+		WHERE video_id = ?
+		ORDER BY started_at DESC
+		LIMIT ?
+	)
+	`
+	if _, err := tx.Exec(prune, log.VideoID, log.VideoID, processingLogsPerVideo); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetProcessingLogs returns a video's processing logs, newest first.
+func (c Client) GetProcessingLogs(videoID uuid.UUID) ([]ProcessingLog, error) {
+	query := `
+	SELECT id, video_id, source, request_id, status, error, started_at, finished_at, entries
+	FROM processing_logs
+	WHERE video_id = ?
+	ORDER BY started_at DESC
+	`
+	rows, err := c.db.Query(query, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	logs := []ProcessingLog{}
+	for rows.Next() {
+		var log ProcessingLog
+		var entries string
+		err := rows.Scan(&log.ID, &log.VideoID, &log.Source, &log.RequestID, &log.Status, &log.Error, &log.StartedAt, &log.FinishedAt, &entries)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(entries), &log.Entries); err != nil {
+			return nil, err
+		}
+		logs = append(logs, log)
+	}
+	return logs, rows.Err()
+}
@@ -12,6 +12,9 @@ type RefreshToken struct {
 	CreatedAt time.Time  `json:"created_at"`
 	UpdatedAt time.Time  `json:"updated_at"`
 	RevokedAt *time.Time `json:"revoked_at"`
+	// ReplacedBy is the token this one was rotated into, if it was revoked
+	// by a refresh rather than by the user.
+	ReplacedBy *string `json:"-"`
 }
 
 type CreateRefreshTokenParams struct {
@@ -48,16 +51,66 @@ func (c Client) RevokeRefreshToken(token string) error {
 	return err
 }
 
+// RotateRefreshToken revokes token in favor of next, which it creates. It
+// reports false, creating nothing, if token was already revoked, so of
+// concurrent refreshes with the same token only one succeeds.
+func (c Client) RotateRefreshToken(token string, next CreateRefreshTokenParams) (bool, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP, replaced_by = ?
+		WHERE token = ? AND revoked_at IS NULL
+	`
+	res, err := tx.Exec(query, next.Token, token)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+	query = `
+		INSERT INTO refresh_tokens (
+			token,
+			created_at,
+			updated_at,
+			user_id,
+			expires_at
+		) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?)
+	`
+	if _, err := tx.Exec(query, next.Token, next.UserID.String(), next.ExpiresAt); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// RevokeUserRefreshTokens revokes every refresh token of a user, signing
+// them out everywhere once their access tokens expire.
+func (c Client) RevokeUserRefreshTokens(userID uuid.UUID) error {
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE user_id = ? AND revoked_at IS NULL
+	`
+	_, err := c.db.Exec(query, userID.String())
+	return err
+}
+
 func (c Client) GetRefreshToken(token string) (RefreshToken, error) {
 	query := `
-		SELECT token, created_at, updated_at, user_id, expires_at, revoked_at
+		SELECT token, created_at, updated_at, user_id, expires_at, revoked_at, replaced_by
 		FROM refresh_tokens
 		WHERE token = ?
 	`
 	var rt RefreshToken
 	var userID string
 	err := c.db.QueryRow(query, token).
-		Scan(&rt.Token, &rt.CreatedAt, &rt.UpdatedAt, &userID, &rt.ExpiresAt, &rt.RevokedAt)
+		Scan(&rt.Token, &rt.CreatedAt, &rt.UpdatedAt, &userID, &rt.ExpiresAt, &rt.RevokedAt, &rt.ReplacedBy)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return RefreshToken{}, nil
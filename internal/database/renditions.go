@@ -0,0 +1,76 @@
+package database
+
+import (
+	"github.com/google/uuid"
+)
+
+// Rendition is an alternate encoding of a video, e.g. one rung of the shorts
+// ladder. Crop is set for renditions cut to another shape, such as the
+// social crops, and Captions to the language of captions burned into the
+// rendition; players shouldn't switch to either.
+type Rendition struct {
+	Name     string `json:"name"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	URL      string `json:"url"`
+	Crop     bool   `json:"crop,omitempty"`
+	Captions string `json:"captions,omitempty"`
+}
+
+// ReplaceRenditions stores the renditions of a video's current file,
+// replacing those of any previous upload.
+func (c Client) ReplaceRenditions(videoID uuid.UUID, renditions []Rendition) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM renditions WHERE video_id = ?", videoID); err != nil {
+		return err
+	}
+	query := `
+	INSERT INTO renditions (video_id, name, width, height, url, crop, captions)
+	VALUES (?, ?, ?, ?, ?, ?, ?)
+	`
+	for _, r := range renditions {
+		if _, err := tx.Exec(query, videoID, r.Name, r.Width, r.Height, r.URL, r.Crop, r.Captions); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (c Client) GetRenditions(videoID uuid.UUID) ([]Rendition, error) {
+	query := `
+	SELECT name, width, height, url, crop, captions
+	FROM renditions
+	WHERE video_id = ?
+	ORDER BY height DESC
+	`
+	rows, err := c.db.Query(query, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	renditions := []Rendition{}
+	for rows.Next() {
+		var r Rendition
+		if err := rows.Scan(&r.Name, &r.Width, &r.Height, &r.URL, &r.Crop, &r.Captions); err != nil {
+			return nil, err
+		}
+		renditions = append(renditions, r)
+	}
+	return renditions, rows.Err()
+}
+
+// AddRendition stores one more rendition of a video's current file.
+func (c Client) AddRendition(videoID uuid.UUID, r Rendition) error {
+	query := `
+	INSERT INTO renditions (video_id, name, width, height, url, crop, captions)
+	VALUES (?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, videoID, r.Name, r.Width, r.Height, r.URL, r.Crop, r.Captions)
+	return err
+}
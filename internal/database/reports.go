@@ -0,0 +1,182 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrDuplicateReport is returned when a user already has an open report
+// against a video.
+var ErrDuplicateReport = errors.New("report already open")
+
+// Reports come from viewers or from automated checks such as copyright
+// fingerprinting and file scans, which file with a nil reporter.
+const (
+	ReportSourceViewer      = "viewer"
+	ReportSourceFingerprint = "fingerprint"
+	ReportSourceScan        = "scan"
+)
+
+const (
+	ReportStatusOpen      = "open"
+	ReportStatusDismissed = "dismissed"
+	ReportStatusActioned  = "actioned"
+)
+
+// Report is a viewer's flag on a video, waiting for or resolved by a
+// moderator.
+type Report struct {
+	ID             uuid.UUID  `json:"id"`
+	VideoID        uuid.UUID  `json:"video_id"`
+	ReporterID     uuid.UUID  `json:"reporter_id"`
+	Source         string     `json:"source"`
+	Reason         string     `json:"reason"`
+	Details        string     `json:"details,omitempty"`
+	Status         string     `json:"status"`
+	CreatedAt      time.Time  `json:"created_at"`
+	ResolvedAt     *time.Time `json:"resolved_at,omitempty"`
+	ResolvedBy     *uuid.UUID `json:"resolved_by,omitempty"`
+	ResolutionNote string     `json:"resolution_note,omitempty"`
+}
+
+const reportColumns = `
+		id,
+		video_id,
+		reporter_id,
+		source,
+		reason,
+		details,
+		status,
+		created_at,
+		resolved_at,
+		resolved_by,
+		resolution_note`
+
+func scanReport(row rowScanner) (Report, error) {
+	var report Report
+	var resolvedBy sql.NullString
+	err := row.Scan(
+		&report.ID,
+		&report.VideoID,
+		&report.ReporterID,
+		&report.Source,
+		&report.Reason,
+		&report.Details,
+		&report.Status,
+		&report.CreatedAt,
+		&report.ResolvedAt,
+		&resolvedBy,
+		&report.ResolutionNote,
+	)
+	if err != nil {
+		return Report{}, err
+	}
+	if resolvedBy.Valid {
+		id, err := uuid.Parse(resolvedBy.String)
+		if err != nil {
+			return Report{}, err
+		}
+		report.ResolvedBy = &id
+	}
+	report.CreatedAt = report.CreatedAt.UTC()
+	if report.ResolvedAt != nil {
+		resolvedAt := report.ResolvedAt.UTC()
+		report.ResolvedAt = &resolvedAt
+	}
+	return report, nil
+}
+
+// CreateReport files a report and returns how many distinct reporters from
+// the same source now have open reports against the video.
+func (c Client) CreateReport(report Report) (openReporters int, err error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var existing int
+	err = tx.QueryRow(`
+	SELECT COUNT(*) FROM reports WHERE video_id = ? AND reporter_id = ? AND status = ?
+	`, report.VideoID, report.ReporterID, ReportStatusOpen).Scan(&existing)
+	if err != nil {
+		return 0, err
+	}
+	if existing > 0 {
+		return 0, ErrDuplicateReport
+	}
+
+	query := `
+	INSERT INTO reports (id, video_id, reporter_id, source, reason, details, status, created_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err = tx.Exec(query, report.ID, report.VideoID, report.ReporterID, report.Source, report.Reason, report.Details, ReportStatusOpen, report.CreatedAt)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.QueryRow(`
+	SELECT COUNT(DISTINCT reporter_id) FROM reports WHERE video_id = ? AND source = ? AND status = ?
+	`, report.VideoID, report.Source, ReportStatusOpen).Scan(&openReporters)
+	if err != nil {
+		return 0, err
+	}
+	return openReporters, tx.Commit()
+}
+
+// GetReports returns reports with the given status, oldest first so the
+// queue is worked in order. An empty status returns every report.
+func (c Client) GetReports(status string) ([]Report, error) {
+	query := `
+	SELECT` + reportColumns + `
+	FROM reports
+	WHERE ? = '' OR status = ?
+	ORDER BY created_at
+	`
+	rows, err := c.db.Query(query, status, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reports := []Report{}
+	for rows.Next() {
+		report, err := scanReport(rows)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, report)
+	}
+	return reports, rows.Err()
+}
+
+// GetReport returns nil if there is no report with the ID.
+func (c Client) GetReport(id uuid.UUID) (*Report, error) {
+	query := `
+	SELECT` + reportColumns + `
+	FROM reports
+	WHERE id = ?
+	`
+	report, err := scanReport(c.db.QueryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &report, nil
+}
+
+// ResolveReport closes a report with status, recording who resolved it.
+func (c Client) ResolveReport(id uuid.UUID, status string, resolvedBy uuid.UUID, note string, resolvedAt time.Time) error {
+	query := `
+	UPDATE reports
+	SET status = ?, resolved_by = ?, resolution_note = ?, resolved_at = ?
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, status, resolvedBy.String(), note, resolvedAt, id)
+	return err
+}
@@ -0,0 +1,110 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// S3Source is the IAM role, in a customer's own AWS account, that the
+// server assumes to import the user's videos from their buckets.
+// ExternalID is a value the server picks for the user, which the role's
+// trust policy requires, so nobody else can have the server assume it.
+type S3Source struct {
+	UserID     uuid.UUID `json:"-"`
+	RoleARN    string    `json:"role_arn"`
+	ExternalID string    `json:"external_id"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// SetS3Source creates or replaces the user's S3 source.
+func (c Client) SetS3Source(s S3Source) error {
+	query := `
+	INSERT INTO s3_sources (user_id, role_arn, external_id, created_at, updated_at)
+	VALUES (?, ?, ?, ?, ?)
+	ON CONFLICT(user_id) DO UPDATE SET
+		role_arn = excluded.role_arn,
+		external_id = excluded.external_id,
+		updated_at = excluded.updated_at
+	`
+	_, err := c.db.Exec(query, s.UserID, s.RoleARN, s.ExternalID, s.CreatedAt, s.UpdatedAt)
+	return err
+}
+
+// GetS3Source returns the user's S3 source, or nil if they have none.
+func (c Client) GetS3Source(userID uuid.UUID) (*S3Source, error) {
+	query := `
+	SELECT user_id, role_arn, external_id, created_at, updated_at
+	FROM s3_sources
+	WHERE user_id = ?
+	`
+	var s S3Source
+	err := c.db.QueryRow(query, userID).Scan(&s.UserID, &s.RoleARN, &s.ExternalID, &s.CreatedAt, &s.UpdatedAt)
+	if isNoRows(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.CreatedAt = s.CreatedAt.UTC()
+	s.UpdatedAt = s.UpdatedAt.UTC()
+	return &s, nil
+}
+
+// DeleteS3Source removes the user's S3 source. It returns false if they
+// had none.
+func (c Client) DeleteS3Source(userID uuid.UUID) (bool, error) {
+	res, err := c.db.Exec("DELETE FROM s3_sources WHERE user_id = ?", userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// S3Import records that a user's object, as identified by its ETag, became
+// a video, so importing the same object twice only imports it once.
+type S3Import struct {
+	UserID    uuid.UUID
+	Bucket    string
+	Key       string
+	ETag      string
+	VideoID   uuid.UUID
+	CreatedAt time.Time
+}
+
+// CreateS3Import records the import. It returns false, recording nothing,
+// if the user already imported the object.
+func (c Client) CreateS3Import(i S3Import) (bool, error) {
+	query := `
+	INSERT OR IGNORE INTO s3_imports (user_id, bucket, key, etag, video_id, created_at)
+	VALUES (?, ?, ?, ?, ?, ?)
+	`
+	res, err := c.db.Exec(query, i.UserID, i.Bucket, i.Key, i.ETag, i.VideoID, i.CreatedAt)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// GetS3Import returns the user's import of the object, or nil if they
+// haven't imported it.
+func (c Client) GetS3Import(userID uuid.UUID, bucket, key, etag string) (*S3Import, error) {
+	query := `
+	SELECT user_id, bucket, key, etag, video_id, created_at
+	FROM s3_imports
+	WHERE user_id = ? AND bucket = ? AND key = ? AND etag = ?
+	`
+	var i S3Import
+	err := c.db.QueryRow(query, userID, bucket, key, etag).Scan(&i.UserID, &i.Bucket, &i.Key, &i.ETag, &i.VideoID, &i.CreatedAt)
+	if isNoRows(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	i.CreatedAt = i.CreatedAt.UTC()
+	return &i, nil
+}
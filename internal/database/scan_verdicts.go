@@ -0,0 +1,92 @@
+package database
+
+import "time"
+
+// ScanVerdict is a scanner's cached verdict on a file, keyed by the file's
+// SHA-256 and the scanner that gave it.
+type ScanVerdict struct {
+	SHA256    string    `json:"sha256"`
+	Scanner   string    `json:"scanner"`
+	Status    string    `json:"status"`
+	Reason    string    `json:"reason,omitempty"`
+	ScannedAt time.Time `json:"scanned_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// SaveScanVerdict caches a verdict, replacing any earlier one for the same
+// file and scanner.
+func (c Client) SaveScanVerdict(v ScanVerdict) error {
+	query := `
+	INSERT INTO scan_verdicts (sha256, scanner, status, reason, scanned_at, expires_at)
+	VALUES (?, ?, ?, ?, ?, ?)
+	ON CONFLICT(sha256, scanner) DO UPDATE SET
+		status = excluded.status,
+		reason = excluded.reason,
+		scanned_at = excluded.scanned_at,
+		expires_at = excluded.expires_at
+	`
+	_, err := c.db.Exec(query, v.SHA256, v.Scanner, v.Status, v.Reason, v.ScannedAt, v.ExpiresAt)
+	return err
+}
+
+// GetScanVerdict returns the scanner's cached verdict on the file with the
+// given SHA-256, if it hasn't expired by now.
+func (c Client) GetScanVerdict(sha256, scanner string, now time.Time) (ScanVerdict, bool, error) {
+	query := `
+	SELECT sha256, scanner, status, reason, scanned_at, expires_at
+	FROM scan_verdicts
+	WHERE sha256 = ? AND scanner = ? AND expires_at > ?
+	`
+	var v ScanVerdict
+	err := c.db.QueryRow(query, sha256, scanner, now).Scan(&v.SHA256, &v.Scanner, &v.Status, &v.Reason, &v.ScannedAt, &v.ExpiresAt)
+	if isNoRows(err) {
+		return ScanVerdict{}, false, nil
+	}
+	if err != nil {
+		return ScanVerdict{}, false, err
+	}
+	return v, true, nil
+}
+
+// GetScanVerdicts returns the cached verdicts, most recent first, filtered
+// by file and status when those aren't empty.
+func (c Client) GetScanVerdicts(sha256, status string, limit int) ([]ScanVerdict, error) {
+	query := `
+	SELECT sha256, scanner, status, reason, scanned_at, expires_at
+	FROM scan_verdicts
+	WHERE (? = '' OR sha256 = ?) AND (? = '' OR status = ?)
+	ORDER BY scanned_at DESC
+	LIMIT ?
+	`
+	rows, err := c.db.Query(query, sha256, sha256, status, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	verdicts := []ScanVerdict{}
+	for rows.Next() {
+		var v ScanVerdict
+		if err := rows.Scan(&v.SHA256, &v.Scanner, &v.Status, &v.Reason, &v.ScannedAt, &v.ExpiresAt); err != nil {
+			return nil, err
+		}
+		verdicts = append(verdicts, v)
+	}
+	return verdicts, rows.Err()
+}
+
+// DeleteScanVerdicts drops the cached verdicts on the file with the given
+// SHA-256, or every file's if it's empty, with the given status unless
+// that's empty, so the files are scanned again. It returns how many were
+// dropped.
+func (c Client) DeleteScanVerdicts(sha256, status string) (int64, error) {
+	query := `
+	DELETE FROM scan_verdicts
+	WHERE (? = '' OR sha256 = ?) AND (? = '' OR status = ?)
+	`
+	result, err := c.db.Exec(query, sha256, sha256, status, status)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,193 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// The status of a run of a scheduled task. A run still running when the
+// server stopped is recorded as interrupted when it starts again.
+const (
+	TaskRunRunning     = "running"
+	TaskRunSucceeded   = "succeeded"
+	TaskRunFailed      = "failed"
+	TaskRunInterrupted = "interrupted"
+)
+
+// maxTaskRuns is how many of each task's runs are kept.
+const maxTaskRuns = 50
+
+// ScheduledTaskState is what is kept of a recurring task across restarts:
+// whether an admin paused it and when it's due next, under the schedule it
+// had then.
+type ScheduledTaskState struct {
+	Name      string
+	Schedule  string
+	Paused    bool
+	NextRunAt *time.Time
+}
+
+// TaskRun is one run of a scheduled task. Result is what the task
+// reported, such as the janitor's report.
+type TaskRun struct {
+	ID         uuid.UUID       `json:"id"`
+	Task       string          `json:"task"`
+	Trigger    string          `json:"trigger"`
+	Status     string          `json:"status"`
+	Error      string          `json:"error,omitempty"`
+	Result     json.RawMessage `json:"result,omitempty"`
+	StartedAt  time.Time       `json:"started_at"`
+	FinishedAt *time.Time      `json:"finished_at,omitempty"`
+	DurationMS int64           `json:"duration_ms"`
+}
+
+// GetScheduledTaskStates returns the state of every task that has any,
+// by name.
+func (c Client) GetScheduledTaskStates() (map[string]ScheduledTaskState, error) {
+	rows, err := c.db.Query("SELECT name, schedule, paused, next_run_at FROM scheduled_tasks")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	states := map[string]ScheduledTaskState{}
+	for rows.Next() {
+		var s ScheduledTaskState
+		if err := rows.Scan(&s.Name, &s.Schedule, &s.Paused, &s.NextRunAt); err != nil {
+			return nil, err
+		}
+		states[s.Name] = s
+	}
+	return states, rows.Err()
+}
+
+// SetScheduledTaskState saves the state of a task.
+func (c Client) SetScheduledTaskState(s ScheduledTaskState) error {
+	query := `
+	INSERT INTO scheduled_tasks (name, schedule, paused, next_run_at)
+	VALUES (?, ?, ?, ?)
+	ON CONFLICT(name) DO UPDATE SET schedule = excluded.schedule, paused = excluded.paused, next_run_at = excluded.next_run_at
+	`
+	_, err := c.db.Exec(query, s.Name, s.Schedule, s.Paused, s.NextRunAt)
+	return err
+}
+
+// CreateTaskRun records that a run has started.
+func (c Client) CreateTaskRun(run TaskRun) error {
+	query := `
+	INSERT INTO scheduled_task_runs (id, task, trigger, status, error, result, started_at, finished_at, duration_ms)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, run.ID, run.Task, run.Trigger, run.Status, run.Error, nullableJSON(run.Result), run.StartedAt, run.FinishedAt, run.DurationMS)
+	return err
+}
+
+// FinishTaskRun records how a run went, and drops all but the task's
+// newest maxTaskRuns runs.
+func (c Client) FinishTaskRun(run TaskRun) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+	UPDATE scheduled_task_runs
+	SET status = ?, error = ?, result = ?, finished_at = ?, duration_ms = ?
+	WHERE id = ?
+	`
+	if _, err := tx.Exec(query, run.Status, run.Error, nullableJSON(run.Result), run.FinishedAt, run.DurationMS, run.ID); err != nil {
+		return err
+	}
+	prune := `
+	DELETE FROM scheduled_task_runs
+	WHERE task = ? AND id NOT IN (
+		SELECT id FROM scheduled_task_runs WHERE task = ? ORDER BY started_at DESC LIMIT ?
+	)
+	`
+	if _, err := tx.Exec(prune, run.Task, run.Task, maxTaskRuns); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// InterruptTaskRuns marks the runs that were still running when the
+// server stopped as interrupted.
+func (c Client) InterruptTaskRuns(now time.Time) error {
+	query := `
+	UPDATE scheduled_task_runs
+	SET status = ?, error = 'the server stopped during the run', finished_at = ?
+	WHERE status = ?
+	`
+	_, err := c.db.Exec(query, TaskRunInterrupted, now, TaskRunRunning)
+	return err
+}
+
+// GetTaskRuns returns a task's newest runs, newest first.
+func (c Client) GetTaskRuns(task string, limit int) ([]TaskRun, error) {
+	query := `
+	SELECT id, task, trigger, status, error, result, started_at, finished_at, duration_ms
+	FROM scheduled_task_runs
+	WHERE task = ?
+	ORDER BY started_at DESC
+	LIMIT ?
+	`
+	rows, err := c.db.Query(query, task, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []TaskRun{}
+	for rows.Next() {
+		run, err := scanTaskRun(rows)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
+
+// GetLastTaskRun returns a task's newest run, or nil if it hasn't run.
+func (c Client) GetLastTaskRun(task string) (*TaskRun, error) {
+	query := `
+	SELECT id, task, trigger, status, error, result, started_at, finished_at, duration_ms
+	FROM scheduled_task_runs
+	WHERE task = ?
+	ORDER BY started_at DESC
+	LIMIT 1
+	`
+	run, err := scanTaskRun(c.db.QueryRow(query, task))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+func scanTaskRun(row interface{ Scan(...any) error }) (TaskRun, error) {
+	var run TaskRun
+	var result sql.NullString
+	if err := row.Scan(&run.ID, &run.Task, &run.Trigger, &run.Status, &run.Error, &result, &run.StartedAt, &run.FinishedAt, &run.DurationMS); err != nil {
+		return TaskRun{}, err
+	}
+	if result.Valid {
+		run.Result = json.RawMessage(result.String)
+	}
+	run.StartedAt = run.StartedAt.UTC()
+	return run, nil
+}
+
+func nullableJSON(raw json.RawMessage) any {
+	if len(raw) == 0 {
+		return nil
+	}
+	return string(raw)
+}
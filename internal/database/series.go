@@ -0,0 +1,174 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Series is an ordered run of a user's videos, its episodes.
+type Series struct {
+	ID          uuid.UUID `json:"id"`
+	UserID      uuid.UUID `json:"user_id"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Episode places a video in a series. A video is in at most one series,
+// and episode numbers are unique within a series.
+type Episode struct {
+	SeriesID      uuid.UUID `json:"series_id"`
+	VideoID       uuid.UUID `json:"video_id"`
+	EpisodeNumber int       `json:"episode_number"`
+}
+
+const seriesColumns = `id, user_id, title, description, created_at, updated_at`
+
+func (c Client) CreateSeries(s Series) error {
+	query := `
+	INSERT INTO series (` + seriesColumns + `)
+	VALUES (?, ?, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, s.ID, s.UserID, s.Title, s.Description, s.CreatedAt, s.UpdatedAt)
+	return err
+}
+
+// GetSeries returns the series, or nil if it doesn't exist.
+func (c Client) GetSeries(id uuid.UUID) (*Series, error) {
+	query := `
+	SELECT ` + seriesColumns + `
+	FROM series
+	WHERE id = ?
+	`
+	s, err := scanSeries(c.db.QueryRow(query, id))
+	if isNoRows(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// GetUserSeries returns the user's series, newest first.
+func (c Client) GetUserSeries(userID uuid.UUID) ([]Series, error) {
+	query := `
+	SELECT ` + seriesColumns + `
+	FROM series
+	WHERE user_id = ?
+	ORDER BY created_at DESC
+	`
+	rows, err := c.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	series := []Series{}
+	for rows.Next() {
+		s, err := scanSeries(rows)
+		if err != nil {
+			return nil, err
+		}
+		series = append(series, s)
+	}
+	return series, rows.Err()
+}
+
+func scanSeries(row rowScanner) (Series, error) {
+	var s Series
+	err := row.Scan(&s.ID, &s.UserID, &s.Title, &s.Description, &s.CreatedAt, &s.UpdatedAt)
+	if err != nil {
+		return Series{}, err
+	}
+	s.CreatedAt, s.UpdatedAt = s.CreatedAt.UTC(), s.UpdatedAt.UTC()
+	return s, nil
+}
+
+func (c Client) UpdateSeries(s Series) error {
+	query := `
+	UPDATE series
+	SET title = ?, description = ?, updated_at = ?
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, s.Title, s.Description, s.UpdatedAt, s.ID)
+	return err
+}
+
+// DeleteSeries deletes the series and takes its videos out of it. The
+// videos themselves stay.
+func (c Client) DeleteSeries(id uuid.UUID) error {
+	if _, err := c.db.Exec("DELETE FROM series_episodes WHERE series_id = ?", id); err != nil {
+		return err
+	}
+	_, err := c.db.Exec("DELETE FROM series WHERE id = ?", id)
+	return err
+}
+
+// AddEpisode adds a video to a series as the given episode, or as the one
+// after the last if number is 0, and returns its episode number.
+func (c Client) AddEpisode(seriesID, videoID uuid.UUID, number int) (int, error) {
+	query := `
+	INSERT INTO series_episodes (series_id, video_id, episode_number)
+	SELECT ?, ?, CASE WHEN ? > 0 THEN ? ELSE COALESCE(MAX(episode_number), 0) + 1 END
+	FROM series_episodes
+	WHERE series_id = ?
+	RETURNING episode_number
+	`
+	if err := c.db.fault(); err != nil {
+		return 0, err
+	}
+	err := c.db.QueryRow(query, seriesID, videoID, number, number, seriesID).Scan(&number)
+	return number, err
+}
+
+// GetEpisodes returns the episodes of a series in order.
+func (c Client) GetEpisodes(seriesID uuid.UUID) ([]Episode, error) {
+	query := `
+	SELECT series_id, video_id, episode_number
+	FROM series_episodes
+	WHERE series_id = ?
+	ORDER BY episode_number
+	`
+	rows, err := c.db.Query(query, seriesID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	episodes := []Episode{}
+	for rows.Next() {
+		var e Episode
+		if err := rows.Scan(&e.SeriesID, &e.VideoID, &e.EpisodeNumber); err != nil {
+			return nil, err
+		}
+		episodes = append(episodes, e)
+	}
+	return episodes, rows.Err()
+}
+
+// GetVideoEpisode returns the episode a video is, or nil if it isn't in a
+// series.
+func (c Client) GetVideoEpisode(videoID uuid.UUID) (*Episode, error) {
+	query := `
+	SELECT series_id, video_id, episode_number
+	FROM series_episodes
+	WHERE video_id = ?
+	`
+	var e Episode
+	err := c.db.QueryRow(query, videoID).Scan(&e.SeriesID, &e.VideoID, &e.EpisodeNumber)
+	if isNoRows(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+func (c Client) RemoveEpisode(videoID uuid.UUID) error {
+	_, err := c.db.Exec("DELETE FROM series_episodes WHERE video_id = ?", videoID)
+	return err
+}
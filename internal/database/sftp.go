@@ -0,0 +1,130 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SFTPAccount links an SFTP user name, as the SFTP server authenticates
+// it, to the Tubely user whose videos their drops become. Each user has at
+// most one.
+type SFTPAccount struct {
+	Username  string    `json:"username"`
+	UserID    uuid.UUID `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SetSFTPAccount links a.Username to a.UserID, replacing the user's
+// previous SFTP user name, if any.
+func (c Client) SetSFTPAccount(a SFTPAccount) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM sftp_accounts WHERE user_id = ?", a.UserID); err != nil {
+		return err
+	}
+	query := `
+	INSERT INTO sftp_accounts (username, user_id, created_at)
+	VALUES (?, ?, ?)
+	`
+	if _, err := tx.Exec(query, a.Username, a.UserID, a.CreatedAt); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteSFTPAccount unlinks the user's SFTP user name.
+func (c Client) DeleteSFTPAccount(userID uuid.UUID) error {
+	_, err := c.db.Exec("DELETE FROM sftp_accounts WHERE user_id = ?", userID)
+	return err
+}
+
+// GetSFTPAccount returns the account of the SFTP user name, or nil if it
+// isn't linked.
+func (c Client) GetSFTPAccount(username string) (*SFTPAccount, error) {
+	query := `
+	SELECT username, user_id, created_at
+	FROM sftp_accounts
+	WHERE username = ?
+	`
+	var a SFTPAccount
+	err := c.db.QueryRow(query, username).Scan(&a.Username, &a.UserID, &a.CreatedAt)
+	if isNoRows(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	a.CreatedAt = a.CreatedAt.UTC()
+	return &a, nil
+}
+
+// GetSFTPAccounts returns every linked SFTP user name, in order.
+func (c Client) GetSFTPAccounts() ([]SFTPAccount, error) {
+	rows, err := c.db.Query("SELECT username, user_id, created_at FROM sftp_accounts ORDER BY username")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := []SFTPAccount{}
+	for rows.Next() {
+		var a SFTPAccount
+		if err := rows.Scan(&a.Username, &a.UserID, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		a.CreatedAt = a.CreatedAt.UTC()
+		accounts = append(accounts, a)
+	}
+	return accounts, rows.Err()
+}
+
+// SFTPIngest records that a dropped object, as identified by its ETag,
+// became a video, so the same drop reported twice is only ingested once.
+type SFTPIngest struct {
+	Bucket    string
+	Key       string
+	ETag      string
+	UserID    uuid.UUID
+	VideoID   uuid.UUID
+	CreatedAt time.Time
+}
+
+// CreateSFTPIngest records the ingest. It returns false, recording
+// nothing, if the object was already ingested.
+func (c Client) CreateSFTPIngest(i SFTPIngest) (bool, error) {
+	query := `
+	INSERT OR IGNORE INTO sftp_ingests (bucket, key, etag, user_id, video_id, created_at)
+	VALUES (?, ?, ?, ?, ?, ?)
+	`
+	res, err := c.db.Exec(query, i.Bucket, i.Key, i.ETag, i.UserID, i.VideoID, i.CreatedAt)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// GetSFTPIngest returns the ingest of the object, or nil if it wasn't
+// ingested.
+func (c Client) GetSFTPIngest(bucket, key, etag string) (*SFTPIngest, error) {
+	query := `
+	SELECT bucket, key, etag, user_id, video_id, created_at
+	FROM sftp_ingests
+	WHERE bucket = ? AND key = ? AND etag = ?
+	`
+	var i SFTPIngest
+	err := c.db.QueryRow(query, bucket, key, etag).Scan(&i.Bucket, &i.Key, &i.ETag, &i.UserID, &i.VideoID, &i.CreatedAt)
+	if isNoRows(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	i.CreatedAt = i.CreatedAt.UTC()
+	return &i, nil
+}
@@ -0,0 +1,157 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// A storage migration copies objects while copying and flips the default
+// bucket during cutover. Paused and failed migrations can be resumed;
+// switched is final.
+const (
+	StorageMigrationCopying  = "copying"
+	StorageMigrationCutover  = "cutover"
+	StorageMigrationSwitched = "switched"
+	StorageMigrationPaused   = "paused"
+	StorageMigrationFailed   = "failed"
+)
+
+// StorageMigration moves the default bucket's objects to another bucket.
+// Cursor is the last key copied, so a resumed migration carries on after it.
+type StorageMigration struct {
+	ID               uuid.UUID  `json:"id"`
+	Status           string     `json:"status"`
+	SourceBucket     string     `json:"source_bucket"`
+	SourceRegion     string     `json:"source_region"`
+	DestBucket       string     `json:"dest_bucket"`
+	DestRegion       string     `json:"dest_region"`
+	ObjectsPerSecond int        `json:"objects_per_second"`
+	Cursor           string     `json:"cursor,omitempty"`
+	Copied           int64      `json:"copied"`
+	Verified         int64      `json:"verified"`
+	Bytes            int64      `json:"bytes"`
+	RewrittenURLs    int64      `json:"rewritten_urls"`
+	Error            string     `json:"error,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+	SwitchedAt       *time.Time `json:"switched_at,omitempty"`
+}
+
+// Active reports whether the migration is still running.
+func (m StorageMigration) Active() bool {
+	return m.Status == StorageMigrationCopying || m.Status == StorageMigrationCutover
+}
+
+const storageMigrationColumns = `
+		id,
+		status,
+		source_bucket,
+		source_region,
+		dest_bucket,
+		dest_region,
+		objects_per_second,
+		cursor,
+		copied,
+		verified,
+		bytes,
+		rewritten_urls,
+		error,
+		created_at,
+		updated_at,
+		switched_at`
+
+func scanStorageMigration(row rowScanner) (StorageMigration, error) {
+	var m StorageMigration
+	var switchedAt sql.NullTime
+	err := row.Scan(
+		&m.ID,
+		&m.Status,
+		&m.SourceBucket,
+		&m.SourceRegion,
+		&m.DestBucket,
+		&m.DestRegion,
+		&m.ObjectsPerSecond,
+		&m.Cursor,
+		&m.Copied,
+		&m.Verified,
+		&m.Bytes,
+		&m.RewrittenURLs,
+		&m.Error,
+		&m.CreatedAt,
+		&m.UpdatedAt,
+		&switchedAt,
+	)
+	if err != nil {
+		return StorageMigration{}, err
+	}
+	m.CreatedAt = m.CreatedAt.UTC()
+	m.UpdatedAt = m.UpdatedAt.UTC()
+	if switchedAt.Valid {
+		t := switchedAt.Time.UTC()
+		m.SwitchedAt = &t
+	}
+	return m, nil
+}
+
+func (c Client) CreateStorageMigration(m StorageMigration) error {
+	query := `
+	INSERT INTO storage_migrations (` + storageMigrationColumns + `)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, m.ID, m.Status, m.SourceBucket, m.SourceRegion, m.DestBucket, m.DestRegion, m.ObjectsPerSecond, m.Cursor, m.Copied, m.Verified, m.Bytes, m.RewrittenURLs, m.Error, m.CreatedAt, m.UpdatedAt, m.SwitchedAt)
+	return err
+}
+
+// UpdateStorageMigration saves the migration's progress.
+func (c Client) UpdateStorageMigration(m StorageMigration) error {
+	query := `
+	UPDATE storage_migrations
+	SET status = ?, cursor = ?, copied = ?, verified = ?, bytes = ?, rewritten_urls = ?, error = ?, updated_at = ?, switched_at = ?
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, m.Status, m.Cursor, m.Copied, m.Verified, m.Bytes, m.RewrittenURLs, m.Error, m.UpdatedAt, m.SwitchedAt, m.ID)
+	return err
+}
+
+// GetStorageMigration returns nil if there is no migration with the ID.
+func (c Client) GetStorageMigration(id uuid.UUID) (*StorageMigration, error) {
+	query := `
+	SELECT` + storageMigrationColumns + `
+	FROM storage_migrations
+	WHERE id = ?
+	`
+	m, err := scanStorageMigration(c.db.QueryRow(query, id))
+	if isNoRows(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// GetStorageMigrations returns all migrations, newest first.
+func (c Client) GetStorageMigrations() ([]StorageMigration, error) {
+	query := `
+	SELECT` + storageMigrationColumns + `
+	FROM storage_migrations
+	ORDER BY created_at DESC
+	`
+	rows, err := c.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	migrations := []StorageMigration{}
+	for rows.Next() {
+		m, err := scanStorageMigration(rows)
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, m)
+	}
+	return migrations, rows.Err()
+}
@@ -0,0 +1,82 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Kinds of file a video's storage usage is recorded for.
+const (
+	StorageKindVideo     = "video"
+	StorageKindThumbnail = "thumbnail"
+)
+
+// SetStorageUsage records that the files of the given kind stored for a
+// video take up bytes, replacing what was recorded before. 0 removes the
+// record.
+func (c Client) SetStorageUsage(userID, videoID uuid.UUID, kind string, bytes int64) error {
+	if bytes == 0 {
+		_, err := c.db.Exec("DELETE FROM storage_usage WHERE video_id = ? AND kind = ?", videoID, kind)
+		return err
+	}
+	query := `
+	INSERT INTO storage_usage (user_id, video_id, kind, bytes, updated_at)
+	VALUES (?, ?, ?, ?, ?)
+	ON CONFLICT (video_id, kind) DO UPDATE SET
+		user_id = excluded.user_id,
+		bytes = excluded.bytes,
+		updated_at = excluded.updated_at
+	`
+	_, err := c.db.Exec(query, userID, videoID, kind, bytes, time.Now().UTC())
+	return err
+}
+
+// GetStorageUsage returns the bytes recorded for a video's files of the
+// given kind, or 0 if there are none.
+func (c Client) GetStorageUsage(videoID uuid.UUID, kind string) (int64, error) {
+	var bytes int64
+	err := c.db.QueryRow("SELECT bytes FROM storage_usage WHERE video_id = ? AND kind = ?", videoID, kind).Scan(&bytes)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return bytes, err
+}
+
+// GetUserStorageUsed returns the bytes stored for all of a user's videos.
+func (c Client) GetUserStorageUsed(userID uuid.UUID) (int64, error) {
+	var bytes int64
+	err := c.db.QueryRow("SELECT COALESCE(SUM(bytes), 0) FROM storage_usage WHERE user_id = ?", userID).Scan(&bytes)
+	return bytes, err
+}
+
+// GetStorageQuota returns the quota set for a user, and false if they have
+// none of their own.
+func (c Client) GetStorageQuota(userID uuid.UUID) (int64, bool, error) {
+	var bytes int64
+	err := c.db.QueryRow("SELECT quota_bytes FROM storage_quotas WHERE user_id = ?", userID).Scan(&bytes)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return bytes, true, nil
+}
+
+// SetStorageQuota sets a user's own quota, or removes it if bytes is nil.
+func (c Client) SetStorageQuota(userID uuid.UUID, bytes *int64) error {
+	if bytes == nil {
+		_, err := c.db.Exec("DELETE FROM storage_quotas WHERE user_id = ?", userID)
+		return err
+	}
+	query := `
+	INSERT INTO storage_quotas (user_id, quota_bytes)
+	VALUES (?, ?)
+	ON CONFLICT (user_id) DO UPDATE SET quota_bytes = excluded.quota_bytes
+	`
+	_, err := c.db.Exec(query, userID, *bytes)
+	return err
+}
@@ -0,0 +1,68 @@
+package database
+
+import "time"
+
+// TenantBranding is how a tenant's embed player, feeds and notifications
+// look. LogoURL is the recorded URL of the logo asset, and the watermark
+// is the logo shown over the player, in the corner WatermarkPosition names
+// or not at all when that's empty.
+type TenantBranding struct {
+	TenantID          string    `json:"tenant_id"`
+	LogoURL           string    `json:"-"`
+	PrimaryColor      string    `json:"primary_color,omitempty"`
+	BackgroundColor   string    `json:"background_color,omitempty"`
+	WatermarkPosition string    `json:"watermark_position,omitempty"`
+	WatermarkOpacity  float64   `json:"watermark_opacity,omitempty"`
+	FeedTitle         string    `json:"feed_title,omitempty"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+// GetTenantBranding returns the tenant's branding, or nil if it has none.
+func (c Client) GetTenantBranding(tenantID string) (*TenantBranding, error) {
+	query := `
+	SELECT tenant_id, logo_url, primary_color, background_color, watermark_position, watermark_opacity, feed_title, updated_at
+	FROM tenant_branding
+	WHERE tenant_id = ?
+	`
+	var b TenantBranding
+	err := c.db.QueryRow(query, tenantID).Scan(
+		&b.TenantID,
+		&b.LogoURL,
+		&b.PrimaryColor,
+		&b.BackgroundColor,
+		&b.WatermarkPosition,
+		&b.WatermarkOpacity,
+		&b.FeedTitle,
+		&b.UpdatedAt,
+	)
+	if isNoRows(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// SaveTenantBranding creates or replaces a tenant's branding.
+func (c Client) SaveTenantBranding(b TenantBranding) error {
+	query := `
+	INSERT INTO tenant_branding (tenant_id, logo_url, primary_color, background_color, watermark_position, watermark_opacity, feed_title, updated_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT(tenant_id) DO UPDATE SET
+		logo_url = excluded.logo_url,
+		primary_color = excluded.primary_color,
+		background_color = excluded.background_color,
+		watermark_position = excluded.watermark_position,
+		watermark_opacity = excluded.watermark_opacity,
+		feed_title = excluded.feed_title,
+		updated_at = excluded.updated_at
+	`
+	_, err := c.db.Exec(query, b.TenantID, b.LogoURL, b.PrimaryColor, b.BackgroundColor, b.WatermarkPosition, b.WatermarkOpacity, b.FeedTitle, b.UpdatedAt)
+	return err
+}
+
+func (c Client) DeleteTenantBranding(tenantID string) error {
+	_, err := c.db.Exec("DELETE FROM tenant_branding WHERE tenant_id = ?", tenantID)
+	return err
+}
@@ -0,0 +1,97 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ThumbnailCandidate is one of the thumbnails being A/B tested for a video,
+// with the impressions and clicks viewers have reported for it.
+type ThumbnailCandidate struct {
+	ID          uuid.UUID `json:"id"`
+	VideoID     uuid.UUID `json:"video_id"`
+	URL         string    `json:"url"`
+	Impressions int       `json:"impressions"`
+	Clicks      int       `json:"clicks"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func (c Client) CreateThumbnailCandidate(candidate ThumbnailCandidate) error {
+	query := `
+	INSERT INTO thumbnail_candidates (id, video_id, url, impressions, clicks, created_at)
+	VALUES (?, ?, ?, 0, 0, ?)
+	`
+	_, err := c.db.Exec(query, candidate.ID, candidate.VideoID, candidate.URL, candidate.CreatedAt)
+	return err
+}
+
+// GetThumbnailCandidates returns a video's candidates, oldest first.
+func (c Client) GetThumbnailCandidates(videoID uuid.UUID) ([]ThumbnailCandidate, error) {
+	query := `
+	SELECT id, video_id, url, impressions, clicks, created_at
+	FROM thumbnail_candidates
+	WHERE video_id = ?
+	ORDER BY created_at, id
+	`
+	rows, err := c.db.Query(query, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	candidates := []ThumbnailCandidate{}
+	for rows.Next() {
+		candidate, err := scanThumbnailCandidate(rows)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, candidate)
+	}
+	return candidates, rows.Err()
+}
+
+// GetThumbnailCandidate returns nil if there is no candidate with the ID.
+func (c Client) GetThumbnailCandidate(id uuid.UUID) (*ThumbnailCandidate, error) {
+	query := `
+	SELECT id, video_id, url, impressions, clicks, created_at
+	FROM thumbnail_candidates
+	WHERE id = ?
+	`
+	candidate, err := scanThumbnailCandidate(c.db.QueryRow(query, id))
+	if isNoRows(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &candidate, nil
+}
+
+func scanThumbnailCandidate(row rowScanner) (ThumbnailCandidate, error) {
+	var candidate ThumbnailCandidate
+	err := row.Scan(&candidate.ID, &candidate.VideoID, &candidate.URL, &candidate.Impressions, &candidate.Clicks, &candidate.CreatedAt)
+	candidate.CreatedAt = candidate.CreatedAt.UTC()
+	return candidate, err
+}
+
+// RecordThumbnailStats adds impressions and clicks to a candidate's counts.
+// It reports false if the candidate doesn't exist.
+func (c Client) RecordThumbnailStats(id uuid.UUID, impressions, clicks int) (bool, error) {
+	query := `
+	UPDATE thumbnail_candidates
+	SET impressions = impressions + ?, clicks = clicks + ?
+	WHERE id = ?
+	`
+	result, err := c.db.Exec(query, impressions, clicks, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func (c Client) DeleteThumbnailCandidate(id uuid.UUID) error {
+	_, err := c.db.Exec("DELETE FROM thumbnail_candidates WHERE id = ?", id)
+	return err
+}
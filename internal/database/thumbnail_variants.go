@@ -0,0 +1,60 @@
+package database
+
+import (
+	"github.com/google/uuid"
+)
+
+// ThumbnailVariant is a resized or re-encoded copy of a video's thumbnail.
+type ThumbnailVariant struct {
+	Width  int    `json:"width"`
+	Format string `json:"format"`
+	URL    string `json:"url"`
+}
+
+// ReplaceThumbnailVariants stores the variants of a video's current
+// thumbnail, replacing those of any previous one.
+func (c Client) ReplaceThumbnailVariants(videoID uuid.UUID, variants []ThumbnailVariant) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM thumbnail_variants WHERE video_id = ?", videoID); err != nil {
+		return err
+	}
+	query := `
+	INSERT INTO thumbnail_variants (video_id, width, format, url)
+	VALUES (?, ?, ?, ?)
+	`
+	for _, v := range variants {
+		if _, err := tx.Exec(query, videoID, v.Width, v.Format, v.URL); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (c Client) GetThumbnailVariants(videoID uuid.UUID) ([]ThumbnailVariant, error) {
+	query := `
+	SELECT width, format, url
+	FROM thumbnail_variants
+	WHERE video_id = ?
+	ORDER BY width DESC, format
+	`
+	rows, err := c.db.Query(query, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	variants := []ThumbnailVariant{}
+	for rows.Next() {
+		var v ThumbnailVariant
+		if err := rows.Scan(&v.Width, &v.Format, &v.URL); err != nil {
+			return nil, err
+		}
+		variants = append(variants, v)
+	}
+	return variants, rows.Err()
+}
@@ -0,0 +1,78 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TrashVideo moves a video to the trash. It reports whether the video was
+// there to trash.
+func (c Client) TrashVideo(id uuid.UUID) (bool, error) {
+	res, err := c.db.Exec("UPDATE videos SET deleted_at = CURRENT_TIMESTAMP WHERE id = ? AND deleted_at IS NULL", id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// RestoreVideo takes a video out of the trash. It reports whether the video
+// was in it.
+func (c Client) RestoreVideo(id uuid.UUID) (bool, error) {
+	res, err := c.db.Exec("UPDATE videos SET deleted_at = NULL WHERE id = ? AND deleted_at IS NOT NULL", id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// GetTrashedVideo returns a video in the trash, or a zero Video if there's
+// no such video in it.
+func (c Client) GetTrashedVideo(id uuid.UUID) (Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE id = ? AND deleted_at IS NOT NULL
+	`
+	video, err := scanVideo(c.db.QueryRow(query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Video{}, nil
+	}
+	return video, err
+}
+
+// VideoExists reports whether there's a video with the ID, in the trash or
+// not.
+func (c Client) VideoExists(id uuid.UUID) (bool, error) {
+	var n int
+	err := c.db.QueryRow("SELECT COUNT(*) FROM videos WHERE id = ?", id).Scan(&n)
+	return n > 0, err
+}
+
+// GetTrashedVideos returns a user's trashed videos, most recently trashed
+// first.
+func (c Client) GetTrashedVideos(userID uuid.UUID) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE user_id = ? AND deleted_at IS NOT NULL
+	ORDER BY deleted_at DESC, id
+	`
+	return c.queryVideos(query, userID)
+}
+
+// GetVideosTrashedBefore returns the videos, of any user, that were moved
+// to the trash before t, the longest trashed first.
+func (c Client) GetVideosTrashedBefore(t time.Time) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE deleted_at IS NOT NULL AND deleted_at < ?
+	ORDER BY deleted_at, id
+	`
+	return c.queryVideos(query, t.UTC().Format(timestampLayout))
+}
@@ -0,0 +1,124 @@
+package database
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UploadPreset is a named set of defaults a user applies to new videos,
+// such as the episodes of a series.
+type UploadPreset struct {
+	ID     uuid.UUID `json:"id"`
+	UserID uuid.UUID `json:"-"`
+	Name   string    `json:"name"`
+	// TitlePattern is the title of videos created without one. See
+	// expandTitlePattern in the main package for its placeholders.
+	TitlePattern string   `json:"title_pattern"`
+	Description  string   `json:"description"`
+	Tags         []string `json:"tags"`
+	// Profile is the processing profile of uploads that don't name one.
+	Profile string `json:"profile"`
+	// Uses counts the videos created with the preset.
+	Uses      int       `json:"uses"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+const uploadPresetColumns = `id, user_id, name, title_pattern, description, tags, profile, uses, created_at, updated_at`
+
+func (c Client) CreateUploadPreset(p UploadPreset) error {
+	tags, err := marshalTags(p.Tags)
+	if err != nil {
+		return err
+	}
+	query := `
+	INSERT INTO upload_presets (` + uploadPresetColumns + `)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err = c.db.Exec(query, p.ID, p.UserID, p.Name, p.TitlePattern, p.Description, tags, p.Profile, p.Uses, p.CreatedAt, p.UpdatedAt)
+	return err
+}
+
+// GetUploadPresets returns the user's presets by name.
+func (c Client) GetUploadPresets(userID uuid.UUID) ([]UploadPreset, error) {
+	query := `
+	SELECT ` + uploadPresetColumns + `
+	FROM upload_presets
+	WHERE user_id = ?
+	ORDER BY name
+	`
+	rows, err := c.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	presets := []UploadPreset{}
+	for rows.Next() {
+		p, err := scanUploadPreset(rows)
+		if err != nil {
+			return nil, err
+		}
+		presets = append(presets, p)
+	}
+	return presets, rows.Err()
+}
+
+// GetUploadPreset returns the preset, or nil if it doesn't exist.
+func (c Client) GetUploadPreset(id uuid.UUID) (*UploadPreset, error) {
+	query := `
+	SELECT ` + uploadPresetColumns + `
+	FROM upload_presets
+	WHERE id = ?
+	`
+	p, err := scanUploadPreset(c.db.QueryRow(query, id))
+	if isNoRows(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func scanUploadPreset(row rowScanner) (UploadPreset, error) {
+	var p UploadPreset
+	var tags string
+	err := row.Scan(&p.ID, &p.UserID, &p.Name, &p.TitlePattern, &p.Description, &tags, &p.Profile, &p.Uses, &p.CreatedAt, &p.UpdatedAt)
+	if err != nil {
+		return UploadPreset{}, err
+	}
+	if err := json.Unmarshal([]byte(tags), &p.Tags); err != nil {
+		return UploadPreset{}, err
+	}
+	p.CreatedAt, p.UpdatedAt = p.CreatedAt.UTC(), p.UpdatedAt.UTC()
+	return p, nil
+}
+
+// UpdateUploadPreset saves the preset's editable fields.
+func (c Client) UpdateUploadPreset(p UploadPreset) error {
+	tags, err := marshalTags(p.Tags)
+	if err != nil {
+		return err
+	}
+	query := `
+	UPDATE upload_presets
+	SET name = ?, title_pattern = ?, description = ?, tags = ?, profile = ?, updated_at = ?
+	WHERE id = ?
+	`
+	_, err = c.db.Exec(query, p.Name, p.TitlePattern, p.Description, tags, p.Profile, p.UpdatedAt, p.ID)
+	return err
+}
+
+// UseUploadPreset counts a video created with the preset.
+func (c Client) UseUploadPreset(id uuid.UUID) error {
+	_, err := c.db.Exec("UPDATE upload_presets SET uses = uses + 1 WHERE id = ?", id)
+	return err
+}
+
+func (c Client) DeleteUploadPreset(id uuid.UUID) error {
+	_, err := c.db.Exec("DELETE FROM upload_presets WHERE id = ?", id)
+	return err
+}
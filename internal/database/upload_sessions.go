@@ -0,0 +1,338 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// An upload session is active while parts are being uploaded and
+// processing once they have been assembled; the other statuses are final.
+const (
+	UploadSessionActive     = "active"
+	UploadSessionProcessing = "processing"
+	UploadSessionCompleted  = "completed"
+	UploadSessionFailed     = "failed"
+	UploadSessionAborted    = "aborted"
+	UploadSessionExpired    = "expired"
+)
+
+// UploadSession is an upload of a video that spans several requests. It
+// stays active while its client keeps it alive and expires at ExpiresAt
+// otherwise. Parts are uploaded to an S3 multipart upload of ObjectKey,
+// which is assembled and processed when the session completes. PartCount is
+// how many parts the client said it would send, or 0 if it didn't.
+//
+// A session with an UploadLength is uploaded by appending chunks instead:
+// they're written to a file on the server, UploadOffset bytes of which are
+// stored, and there's no multipart upload.
+//
+// A session with a DirectSize is uploaded by the client straight to
+// ObjectKey, in one presigned PUT of that many bytes.
+//
+// BytesTransferred and TransferMillis add up the parts and chunks the
+// server has received and how long their bodies took to arrive, which is
+// the session's observed throughput.
+type UploadSession struct {
+	ID               uuid.UUID `json:"id"`
+	VideoID          uuid.UUID `json:"video_id"`
+	UserID           uuid.UUID `json:"user_id"`
+	TenantID         string    `json:"tenant_id,omitempty"`
+	Status           string    `json:"status"`
+	Filename         string    `json:"filename,omitempty"`
+	ContentType      string    `json:"content_type"`
+	Profile          string    `json:"profile,omitempty"`
+	PartCount        int32     `json:"part_count,omitempty"`
+	ObjectKey        string    `json:"-"`
+	S3UploadID       string    `json:"-"`
+	UploadLength     int64     `json:"upload_length,omitempty"`
+	UploadOffset     int64     `json:"upload_offset,omitempty"`
+	DirectSize       int64     `json:"direct_size,omitempty"`
+	BytesTransferred int64     `json:"-"`
+	TransferMillis   int64     `json:"-"`
+	CreatedAt        time.Time `json:"created_at"`
+	LastHeartbeatAt  time.Time `json:"last_heartbeat_at"`
+	ExpiresAt        time.Time `json:"expires_at"`
+}
+
+// UploadPart is a part of an upload session that has been stored in S3.
+type UploadPart struct {
+	PartNumber int32     `json:"part_number"`
+	Size       int64     `json:"size"`
+	ETag       string    `json:"etag"`
+	UploadedAt time.Time `json:"uploaded_at"`
+}
+
+const uploadSessionColumns = `
+		id,
+		video_id,
+		user_id,
+		tenant_id,
+		status,
+		filename,
+		content_type,
+		profile,
+		part_count,
+		object_key,
+		s3_upload_id,
+		upload_length,
+		upload_offset,
+		direct_size,
+		bytes_transferred,
+		transfer_ms,
+		created_at,
+		last_heartbeat_at,
+		expires_at`
+
+func scanUploadSession(row rowScanner) (UploadSession, error) {
+	var s UploadSession
+	err := row.Scan(
+		&s.ID,
+		&s.VideoID,
+		&s.UserID,
+		&s.TenantID,
+		&s.Status,
+		&s.Filename,
+		&s.ContentType,
+		&s.Profile,
+		&s.PartCount,
+		&s.ObjectKey,
+		&s.S3UploadID,
+		&s.UploadLength,
+		&s.UploadOffset,
+		&s.DirectSize,
+		&s.BytesTransferred,
+		&s.TransferMillis,
+		&s.CreatedAt,
+		&s.LastHeartbeatAt,
+		&s.ExpiresAt,
+	)
+	if err != nil {
+		return UploadSession{}, err
+	}
+	s.CreatedAt = s.CreatedAt.UTC()
+	s.LastHeartbeatAt = s.LastHeartbeatAt.UTC()
+	s.ExpiresAt = s.ExpiresAt.UTC()
+	return s, nil
+}
+
+func (c Client) CreateUploadSession(s UploadSession) error {
+	query := `
+	INSERT INTO upload_sessions (` + uploadSessionColumns + `)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, s.ID, s.VideoID, s.UserID, s.TenantID, s.Status, s.Filename, s.ContentType, s.Profile, s.PartCount, s.ObjectKey, s.S3UploadID, s.UploadLength, s.UploadOffset, s.DirectSize, s.BytesTransferred, s.TransferMillis, s.CreatedAt, s.LastHeartbeatAt, s.ExpiresAt)
+	return err
+}
+
+// GetUploadSession returns nil if there is no session with the ID.
+func (c Client) GetUploadSession(id uuid.UUID) (*UploadSession, error) {
+	query := `
+	SELECT` + uploadSessionColumns + `
+	FROM upload_sessions
+	WHERE id = ?
+	`
+	s, err := scanUploadSession(c.db.QueryRow(query, id))
+	if isNoRows(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// HeartbeatUploadSession records a heartbeat at now and moves the session's
+// expiry to expiresAt. It reports false if the session is no longer active.
+func (c Client) HeartbeatUploadSession(id uuid.UUID, now, expiresAt time.Time) (bool, error) {
+	query := `
+	UPDATE upload_sessions
+	SET last_heartbeat_at = ?, expires_at = ?
+	WHERE id = ? AND status = ?
+	`
+	res, err := c.db.Exec(query, now, expiresAt, id, UploadSessionActive)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// TransitionUploadSession moves a session from status from to status to.
+// It reports false if the session wasn't in status from, so of concurrent
+// callers only one gets to act on the change.
+func (c Client) TransitionUploadSession(id uuid.UUID, from, to string) (bool, error) {
+	query := `
+	UPDATE upload_sessions
+	SET status = ?
+	WHERE id = ? AND status = ?
+	`
+	res, err := c.db.Exec(query, to, id, from)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// RecordUploadTransfer adds a part or chunk of n bytes whose body took d to
+// arrive to the session's observed throughput.
+func (c Client) RecordUploadTransfer(id uuid.UUID, n int64, d time.Duration) error {
+	query := `
+	UPDATE upload_sessions
+	SET bytes_transferred = bytes_transferred + ?, transfer_ms = transfer_ms + ?
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, n, max(d.Milliseconds(), 1), id)
+	return err
+}
+
+// GetUploadThroughput adds up the bytes and milliseconds transferred by the
+// user's sessions created since since, for their throughput across
+// sessions.
+func (c Client) GetUploadThroughput(userID uuid.UUID, since time.Time) (bytes, millis int64, err error) {
+	query := `
+	SELECT COALESCE(SUM(bytes_transferred), 0), COALESCE(SUM(transfer_ms), 0)
+	FROM upload_sessions
+	WHERE user_id = ? AND created_at >= ?
+	`
+	err = c.db.QueryRow(query, userID, since).Scan(&bytes, &millis)
+	return bytes, millis, err
+}
+
+// SetUploadOffset moves an active session's offset from from to to. It
+// reports false if the session is no longer active or its offset has
+// already moved.
+func (c Client) SetUploadOffset(id uuid.UUID, from, to int64) (bool, error) {
+	query := `
+	UPDATE upload_sessions
+	SET upload_offset = ?
+	WHERE id = ? AND status = ? AND upload_offset = ?
+	`
+	res, err := c.db.Exec(query, to, id, UploadSessionActive, from)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// GetActiveUploadSessions returns the video's active sessions.
+func (c Client) GetActiveUploadSessions(videoID uuid.UUID) ([]UploadSession, error) {
+	query := `
+	SELECT` + uploadSessionColumns + `
+	FROM upload_sessions
+	WHERE video_id = ? AND status = ?
+	ORDER BY created_at
+	`
+	return c.queryUploadSessions(query, videoID, UploadSessionActive)
+}
+
+// GetAllActiveUploadSessions returns the active sessions of every video.
+func (c Client) GetAllActiveUploadSessions() ([]UploadSession, error) {
+	query := `
+	SELECT` + uploadSessionColumns + `
+	FROM upload_sessions
+	WHERE status = ?
+	ORDER BY created_at
+	`
+	return c.queryUploadSessions(query, UploadSessionActive)
+}
+
+// GetExpiredUploadSessions returns the active sessions whose expiry is
+// before now.
+func (c Client) GetExpiredUploadSessions(now time.Time) ([]UploadSession, error) {
+	query := `
+	SELECT` + uploadSessionColumns + `
+	FROM upload_sessions
+	WHERE status = ? AND expires_at < ?
+	ORDER BY expires_at
+	`
+	return c.queryUploadSessions(query, UploadSessionActive, now)
+}
+
+func (c Client) queryUploadSessions(query string, args ...any) ([]UploadSession, error) {
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []UploadSession{}
+	for rows.Next() {
+		s, err := scanUploadSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+// SaveUploadPart records a stored part, replacing an earlier upload of the
+// same part number.
+func (c Client) SaveUploadPart(sessionID uuid.UUID, part UploadPart) error {
+	query := `
+	INSERT INTO upload_parts (session_id, part_number, size, etag, uploaded_at)
+	VALUES (?, ?, ?, ?, ?)
+	ON CONFLICT (session_id, part_number) DO UPDATE SET
+		size = excluded.size,
+		etag = excluded.etag,
+		uploaded_at = excluded.uploaded_at
+	`
+	_, err := c.db.Exec(query, sessionID, part.PartNumber, part.Size, part.ETag, part.UploadedAt)
+	return err
+}
+
+func (c Client) GetUploadParts(sessionID uuid.UUID) ([]UploadPart, error) {
+	query := `
+	SELECT part_number, size, etag, uploaded_at
+	FROM upload_parts
+	WHERE session_id = ?
+	ORDER BY part_number
+	`
+	rows, err := c.db.Query(query, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	parts := []UploadPart{}
+	for rows.Next() {
+		var p UploadPart
+		if err := rows.Scan(&p.PartNumber, &p.Size, &p.ETag, &p.UploadedAt); err != nil {
+			return nil, err
+		}
+		p.UploadedAt = p.UploadedAt.UTC()
+		parts = append(parts, p)
+	}
+	return parts, rows.Err()
+}
+
+// ReplaceUploadParts replaces the session's recorded parts with parts.
+func (c Client) ReplaceUploadParts(sessionID uuid.UUID, parts []UploadPart) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM upload_parts WHERE session_id = ?", sessionID); err != nil {
+		return err
+	}
+	query := `
+	INSERT INTO upload_parts (session_id, part_number, size, etag, uploaded_at)
+	VALUES (?, ?, ?, ?, ?)
+	`
+	for _, p := range parts {
+		if _, err := tx.Exec(query, sessionID, p.PartNumber, p.Size, p.ETag, p.UploadedAt); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (c Client) DeleteUploadParts(sessionID uuid.UUID) error {
+	_, err := c.db.Exec("DELETE FROM upload_parts WHERE session_id = ?", sessionID)
+	return err
+}
@@ -0,0 +1,81 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UserActivity is one entry on a user's activity timeline. ActorID is set
+// when someone other than the user, e.g. an admin, did it. Entries outlive
+// the videos they mention, so deleted videos stay on the timeline.
+type UserActivity struct {
+	ID         int64      `json:"id"`
+	UserID     uuid.UUID  `json:"user_id"`
+	Kind       string     `json:"kind"`
+	VideoID    *uuid.UUID `json:"video_id,omitempty"`
+	ActorID    *uuid.UUID `json:"actor_id,omitempty"`
+	Detail     string     `json:"detail,omitempty"`
+	OccurredAt time.Time  `json:"occurred_at"`
+}
+
+func (c Client) RecordUserActivity(a UserActivity) error {
+	query := `
+	INSERT INTO user_activity (user_id, kind, video_id, actor_id, detail, occurred_at)
+	VALUES (?, ?, ?, ?, ?, ?)
+	`
+	var videoID, actorID sql.NullString
+	if a.VideoID != nil {
+		videoID = sql.NullString{String: a.VideoID.String(), Valid: true}
+	}
+	if a.ActorID != nil {
+		actorID = sql.NullString{String: a.ActorID.String(), Valid: true}
+	}
+	_, err := c.db.Exec(query, a.UserID, a.Kind, videoID, actorID, a.Detail, a.OccurredAt)
+	return err
+}
+
+// GetUserActivity returns up to limit of the user's entries, newest first.
+// If before is non-zero, only entries older than the one with that ID are
+// returned, so the last ID of a page fetches the next one.
+func (c Client) GetUserActivity(userID uuid.UUID, before int64, limit int) ([]UserActivity, error) {
+	query := `
+	SELECT id, user_id, kind, video_id, actor_id, detail, occurred_at
+	FROM user_activity
+	WHERE user_id = ? AND (? = 0 OR id < ?)
+	ORDER BY id DESC
+	LIMIT ?
+	`
+	rows, err := c.db.Query(query, userID, before, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	activity := []UserActivity{}
+	for rows.Next() {
+		var a UserActivity
+		var videoID, actorID sql.NullString
+		if err := rows.Scan(&a.ID, &a.UserID, &a.Kind, &videoID, &actorID, &a.Detail, &a.OccurredAt); err != nil {
+			return nil, err
+		}
+		if videoID.Valid {
+			id, err := uuid.Parse(videoID.String)
+			if err != nil {
+				return nil, err
+			}
+			a.VideoID = &id
+		}
+		if actorID.Valid {
+			id, err := uuid.Parse(actorID.String)
+			if err != nil {
+				return nil, err
+			}
+			a.ActorID = &id
+		}
+		a.OccurredAt = a.OccurredAt.UTC()
+		activity = append(activity, a)
+	}
+	return activity, rows.Err()
+}
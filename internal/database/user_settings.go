@@ -0,0 +1,79 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UserSettings are a user's defaults for their uploads, applied when an
+// upload doesn't say otherwise, and which notifications they get.
+type UserSettings struct {
+	UserID uuid.UUID `json:"-"`
+	// DefaultProfile is the processing profile for uploads that don't
+	// name one. Empty picks the profile automatically, as for uploads
+	// without settings.
+	DefaultProfile string `json:"default_profile"`
+	// Watermark burns WatermarkText into uploaded videos.
+	Watermark     bool   `json:"watermark"`
+	WatermarkText string `json:"watermark_text"`
+	NotificationSettings
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// NotificationSettings choose which of a user's processing outcomes they
+// are notified of.
+type NotificationSettings struct {
+	NotifyProcessingDone   bool `json:"notify_processing_done"`
+	NotifyProcessingFailed bool `json:"notify_processing_failed"`
+}
+
+// DefaultUserSettings are the settings of a user who hasn't saved any.
+func DefaultUserSettings(userID uuid.UUID) UserSettings {
+	return UserSettings{
+		UserID: userID,
+		NotificationSettings: NotificationSettings{
+			NotifyProcessingDone:   true,
+			NotifyProcessingFailed: true,
+		},
+	}
+}
+
+// GetUserSettings returns the user's settings, or DefaultUserSettings if
+// they haven't saved any.
+func (c Client) GetUserSettings(userID uuid.UUID) (UserSettings, error) {
+	query := `
+	SELECT default_profile, watermark, watermark_text, notify_processing_done, notify_processing_failed, updated_at
+	FROM user_settings
+	WHERE user_id = ?
+	`
+	s := UserSettings{UserID: userID}
+	err := c.db.QueryRow(query, userID).Scan(&s.DefaultProfile, &s.Watermark, &s.WatermarkText, &s.NotifyProcessingDone, &s.NotifyProcessingFailed, &s.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return DefaultUserSettings(userID), nil
+	}
+	if err != nil {
+		return UserSettings{}, err
+	}
+	s.UpdatedAt = s.UpdatedAt.UTC()
+	return s, nil
+}
+
+// SaveUserSettings replaces the user's settings.
+func (c Client) SaveUserSettings(s UserSettings) error {
+	query := `
+	INSERT INTO user_settings (user_id, default_profile, watermark, watermark_text, notify_processing_done, notify_processing_failed, updated_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT (user_id) DO UPDATE SET
+		default_profile = excluded.default_profile,
+		watermark = excluded.watermark,
+		watermark_text = excluded.watermark_text,
+		notify_processing_done = excluded.notify_processing_done,
+		notify_processing_failed = excluded.notify_processing_failed,
+		updated_at = excluded.updated_at
+	`
+	_, err := c.db.Exec(query, s.UserID, s.DefaultProfile, s.Watermark, s.WatermarkText, s.NotifyProcessingDone, s.NotifyProcessingFailed, s.UpdatedAt)
+	return err
+}
@@ -12,9 +12,34 @@ type User struct {
 	ID        uuid.UUID `json:"id"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+	TenantID  string    `json:"tenant_id,omitempty"`
+	// AgeVerified is set by an admin once the user's age has been
+	// verified, and is carried in the user's access tokens.
+	AgeVerified bool `json:"age_verified"`
+	Suspension
 	CreateUserParams
 }
 
+// Suspension is set while an admin has suspended a user. A suspended user
+// can't upload, and if PlaybackBlocked is set their videos can't be played
+// either. Their objects are kept, so lifting the suspension restores
+// everything.
+type Suspension struct {
+	SuspendedAt      *time.Time `json:"suspended_at,omitempty"`
+	SuspensionReason string     `json:"suspension_reason,omitempty"`
+	PlaybackBlocked  bool       `json:"playback_blocked,omitempty"`
+}
+
+// Suspended reports whether the user is suspended.
+func (s Suspension) Suspended() bool {
+	return s.SuspendedAt != nil
+}
+
+// BlocksPlayback reports whether the user's videos can't be played.
+func (s Suspension) BlocksPlayback() bool {
+	return s.Suspended() && s.PlaybackBlocked
+}
+
 type CreateUserParams struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
@@ -41,6 +66,9 @@ func (c Client) GetUsers() ([]User, error) {
 		if err := rows.Scan(&id, &user.Email); err != nil {
 			return nil, err
 		}
+		if err := c.decrypt(fieldUserEmail, &user.Email); err != nil {
+			return nil, err
+		}
 		user.ID, err = uuid.Parse(id)
 		if err != nil {
 			return nil, err
@@ -53,19 +81,22 @@ func (c Client) GetUsers() ([]User, error) {
 
 func (c Client) GetUserByEmail(email string) (User, error) {
 	query := `
-		SELECT id, created_at, updated_at, email, password
+		SELECT id, created_at, updated_at, tenant_id, age_verified, suspended_at, suspension_reason, playback_blocked, email, password
 		FROM users
-		WHERE email = ?
+		WHERE email = ? OR email_index = ?
 	`
 	var user User
 	var id string
-	err := c.db.QueryRow(query, email).Scan(&id, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password)
+	err := c.db.QueryRow(query, email, c.emailIndex(email)).Scan(&id, &user.CreatedAt, &user.UpdatedAt, &user.TenantID, &user.AgeVerified, &user.SuspendedAt, &user.SuspensionReason, &user.PlaybackBlocked, &user.Email, &user.Password)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return User{}, nil
 		}
 		return User{}, err
 	}
+	if err := c.decrypt(fieldUserEmail, &user.Email); err != nil {
+		return User{}, err
+	}
 	user.ID, err = uuid.Parse(id)
 	if err != nil {
 		return User{}, err
@@ -75,7 +106,7 @@ func (c Client) GetUserByEmail(email string) (User, error) {
 
 func (c Client) GetUserByRefreshToken(token string) (*User, error) {
 	query := `
-		SELECT u.id, u.email, u.created_at, u.updated_at, u.password
+		SELECT u.id, u.email, u.created_at, u.updated_at, u.tenant_id, u.age_verified, u.suspended_at, u.suspension_reason, u.playback_blocked, u.password
 		FROM users u
 		JOIN refresh_tokens rt ON u.id = rt.user_id
 		WHERE rt.token = ?
@@ -83,13 +114,16 @@ func (c Client) GetUserByRefreshToken(token string) (*User, error) {
 
 	var user User
 	var id string
-	err := c.db.QueryRow(query, token).Scan(&id, &user.Email, &user.CreatedAt, &user.UpdatedAt, &user.Password)
+	err := c.db.QueryRow(query, token).Scan(&id, &user.Email, &user.CreatedAt, &user.UpdatedAt, &user.TenantID, &user.AgeVerified, &user.SuspendedAt, &user.SuspensionReason, &user.PlaybackBlocked, &user.Password)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
 		}
 		return nil, err
 	}
+	if err := c.decrypt(fieldUserEmail, &user.Email); err != nil {
+		return nil, err
+	}
 	user.ID, err = uuid.Parse(id)
 	if err != nil {
 		return nil, err
@@ -98,38 +132,55 @@ func (c Client) GetUserByRefreshToken(token string) (*User, error) {
 	return &user, nil
 }
 
+// ErrEmailTaken is returned for a new user whose email another user has.
+var ErrEmailTaken = errors.New("email is already in use")
+
 func (c Client) CreateUser(params CreateUserParams) (*User, error) {
 	id := uuid.New()
+	email, err := c.encrypt(fieldUserEmail, params.Email)
+	if err != nil {
+		return nil, err
+	}
 
+	// The unique indexes can't tell an encrypted email from the same one
+	// stored before emails were encrypted, so the insert checks for it.
 	query := `
 		INSERT INTO users
-		    (id, created_at, updated_at, email, password)
-		VALUES
-		    (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?)
+		    (id, created_at, updated_at, email, email_index, password)
+		SELECT ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?
+		WHERE NOT EXISTS (SELECT 1 FROM users WHERE email = ?)
 	`
-	_, err := c.db.Exec(query, id.String(), params.Email, params.Password)
+	res, err := c.db.Exec(query, id.String(), email, c.emailIndex(params.Email), params.Password, params.Email)
 	if err != nil {
 		return nil, err
 	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, ErrEmailTaken
+	}
 
 	return c.GetUser(id)
 }
 
 func (c Client) GetUser(id uuid.UUID) (*User, error) {
 	query := `
-		SELECT id, created_at, updated_at, email, password
+		SELECT id, created_at, updated_at, tenant_id, age_verified, suspended_at, suspension_reason, playback_blocked, email, password
 		FROM users
 		WHERE id = ?
 	`
 	var user User
 	var idStr string
-	err := c.db.QueryRow(query, id.String()).Scan(&idStr, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password)
+	err := c.db.QueryRow(query, id.String()).Scan(&idStr, &user.CreatedAt, &user.UpdatedAt, &user.TenantID, &user.AgeVerified, &user.SuspendedAt, &user.SuspensionReason, &user.PlaybackBlocked, &user.Email, &user.Password)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
 		}
 		return nil, err
 	}
+	if err := c.decrypt(fieldUserEmail, &user.Email); err != nil {
+		return nil, err
+	}
 	user.ID, err = uuid.Parse(idStr)
 	if err != nil {
 		return nil, err
@@ -137,6 +188,38 @@ func (c Client) GetUser(id uuid.UUID) (*User, error) {
 	return &user, nil
 }
 
+func (c Client) SetUserTenant(id uuid.UUID, tenantID string) error {
+	query := `
+		UPDATE users
+		SET tenant_id = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	_, err := c.db.Exec(query, tenantID, id.String())
+	return err
+}
+
+func (c Client) SetUserAgeVerified(id uuid.UUID, verified bool) error {
+	query := `
+		UPDATE users
+		SET age_verified = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	_, err := c.db.Exec(query, verified, id.String())
+	return err
+}
+
+// SetUserSuspension suspends the user, or lifts their suspension if s is
+// the zero Suspension.
+func (c Client) SetUserSuspension(id uuid.UUID, s Suspension) error {
+	query := `
+		UPDATE users
+		SET suspended_at = ?, suspension_reason = ?, playback_blocked = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	_, err := c.db.Exec(query, s.SuspendedAt, s.SuspensionReason, s.PlaybackBlocked, id.String())
+	return err
+}
+
 func (c Client) DeleteUser(id uuid.UUID) error {
 	query := `
 		DELETE FROM users
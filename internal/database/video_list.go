@@ -0,0 +1,143 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// The orders ListVideos can list videos in.
+const (
+	VideoSortCreatedAt = "created_at"
+	VideoSortUpdatedAt = "updated_at"
+	VideoSortTitle     = "title"
+)
+
+// videoSortColumns are the expressions each order sorts by, before the ID
+// that breaks ties. Each has an index on (user_id, expression, id).
+var videoSortColumns = map[string]string{
+	VideoSortCreatedAt: "created_at",
+	VideoSortUpdatedAt: "updated_at",
+	VideoSortTitle:     "title COLLATE NOCASE",
+}
+
+// timestampLayout is how SQLite's CURRENT_TIMESTAMP stores times, which
+// compares in order as text.
+const timestampLayout = "2006-01-02 15:04:05"
+
+// VideoListParams selects a page of a user's videos. Empty filters, and
+// zero times, match every video.
+type VideoListParams struct {
+	UserID           uuid.UUID
+	AspectRatio      string
+	ProcessingStatus string
+	// CreatedAfter and CreatedBefore bound the videos' creation times,
+	// exclusively.
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	// Search matches titles that contain it, ignoring case.
+	Search string
+	// CustomMetadata matches videos whose custom metadata has all of its
+	// keys with the same values.
+	CustomMetadata map[string]string
+	Sort           string
+	Descending     bool
+	// After is the key of the last video of the previous page, or nil for
+	// the first page. A Limit of zero lists every video after it.
+	After *VideoListKey
+	Limit int
+}
+
+// VideoListKey is where a video falls in a listing: the value it's sorted
+// by, as stored, and its ID.
+type VideoListKey struct {
+	Value string    `json:"v"`
+	ID    uuid.UUID `json:"id"`
+}
+
+// VideoListKeyOf returns the key of video in a listing sorted by sort.
+func VideoListKeyOf(sort string, video Video) VideoListKey {
+	key := VideoListKey{ID: video.ID}
+	switch sort {
+	case VideoSortUpdatedAt:
+		key.Value = video.UpdatedAt.UTC().Format(timestampLayout)
+	case VideoSortTitle:
+		key.Value = video.Title
+	default:
+		key.Value = video.CreatedAt.UTC().Format(timestampLayout)
+	}
+	return key
+}
+
+// ListVideos returns a page of a user's videos, filtered and ordered by
+// params. Videos with the same sort value are ordered by ID, so pages
+// follow on from each other however many share it.
+func (c Client) ListVideos(params VideoListParams) ([]Video, error) {
+	column, ok := videoSortColumns[params.Sort]
+	if !ok {
+		return nil, fmt.Errorf("unknown sort %q", params.Sort)
+	}
+
+	where := []string{"user_id = ?", "deleted_at IS NULL"}
+	args := []any{params.UserID}
+	if params.AspectRatio != "" {
+		where = append(where, "aspect_ratio = ?")
+		args = append(args, params.AspectRatio)
+	}
+	if params.ProcessingStatus != "" {
+		where = append(where, "processing_status = ?")
+		args = append(args, params.ProcessingStatus)
+	}
+	if !params.CreatedAfter.IsZero() {
+		where = append(where, "created_at > ?")
+		args = append(args, params.CreatedAfter.UTC().Format(timestampLayout))
+	}
+	if !params.CreatedBefore.IsZero() {
+		where = append(where, "created_at < ?")
+		args = append(args, params.CreatedBefore.UTC().Format(timestampLayout))
+	}
+	if params.Search != "" {
+		where = append(where, `title LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+escapeLike(params.Search)+"%")
+	}
+	for key, value := range params.CustomMetadata {
+		where = append(where, "json_extract(custom_metadata, ?) = ?")
+		args = append(args, customMetadataPath(key), value)
+	}
+
+	direction, after := "ASC", ">"
+	if params.Descending {
+		direction, after = "DESC", "<"
+	}
+	if params.After != nil {
+		where = append(where, fmt.Sprintf("(%s, id) %s (?, ?)", column, after))
+		args = append(args, params.After.Value, params.After.ID)
+	}
+
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE ` + strings.Join(where, " AND ") + `
+	ORDER BY ` + column + ` ` + direction + `, id ` + direction + `
+	LIMIT ?
+	`
+	limit := params.Limit
+	if limit <= 0 {
+		limit = -1
+	}
+	args = append(args, limit)
+	return c.queryVideos(query, args...)
+}
+
+// customMetadataPath is the JSON path of a custom metadata key, which
+// can't have quotes in it.
+func customMetadataPath(key string) string {
+	return `$."` + key + `"`
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern with backslashes.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -2,30 +2,176 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+// Video is a video's row. VideoURL, PreviewURL, PeaksURL and SDRVideoURL
+// hold S3 object keys, or the object URLs stored before keys were; handlers
+// replace them with presigned URLs before returning a video.
 type Video struct {
-	ID           uuid.UUID `json:"id"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
-	ThumbnailURL *string   `json:"thumbnail_url"`
-	VideoURL     *string   `json:"video_url"`
+	ID               uuid.UUID `json:"id"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+	ThumbnailURL     *string   `json:"thumbnail_url"`
+	VideoURL         *string   `json:"video_url"`
+	AspectRatio      string    `json:"aspect_ratio,omitempty"`
+	IsShort          bool      `json:"is_short"`
+	PreviewURL       *string   `json:"preview_url,omitempty"`
+	PeaksURL         *string   `json:"peaks_url,omitempty"`
+	OriginalFilename string    `json:"original_filename,omitempty"`
+	// HLSURL is the key of the HLS master playlist, when adaptive bitrate
+	// renditions were generated. The renditions' playlists and segments
+	// are stored next to it.
+	HLSURL *string `json:"hls_url,omitempty"`
+	// ModerationHold hides the video from everyone but its owner while
+	// moderators review reports against it.
+	ModerationHold bool `json:"moderation_hold"`
+	// LegalHold keeps the video and its objects from being deleted or
+	// replaced until an admin releases it. Only SetVideoLegalHold changes
+	// it; UpdateVideo leaves it alone.
+	LegalHold bool `json:"legal_hold"`
+	// ThumbnailSHA256 is the hex SHA-256 of the uploaded thumbnail, used to
+	// skip re-uploads of the same file.
+	ThumbnailSHA256 string `json:"thumbnail_sha256,omitempty"`
+	// SourceSHA256 is the hex SHA-256 of the video file as it was
+	// uploaded, and VideoSHA256 that of the file stored at VideoURL, which
+	// S3 checked on upload.
+	SourceSHA256 string `json:"source_sha256,omitempty"`
+	VideoSHA256  string `json:"video_sha256,omitempty"`
+	// Encryption is the server-side encryption mode the file at VideoURL
+	// was stored with, AES256 or aws:kms, or empty for the bucket's
+	// default.
+	Encryption string `json:"encryption,omitempty"`
+	// ThumbnailSrcset is, for each format the thumbnail has variants in, an
+	// HTML srcset of them. It isn't stored; handlers fill it in along with
+	// the thumbnail's URL.
+	ThumbnailSrcset map[string]string `json:"thumbnail_srcset,omitempty"`
+	// Captions are the video's caption tracks for players to load. They
+	// aren't stored here; handlers fill them in along with the video's
+	// URLs.
+	Captions []CaptionTrack `json:"captions,omitempty"`
+	// ProcessingStatus is how the latest upload of the video file is
+	// going, and ProcessingError why it failed. They are empty until a
+	// file is uploaded. A video whose latest upload failed keeps playing
+	// its previous file, if it had one. Only SetVideoProcessing changes
+	// them; UpdateVideo leaves them alone.
+	ProcessingStatus string `json:"processing_status,omitempty"`
+	ProcessingError  string `json:"processing_error,omitempty"`
+	// Metadata describes the stored file. It is nil until a file is
+	// processed with it captured.
+	Metadata *VideoMetadata `json:"metadata"`
+	// CustomMetadata is what integrators stamp the video with, such as its
+	// ID in their CMS, as string keys and values. Only
+	// SetVideoCustomMetadata changes it; UpdateVideo leaves it alone.
+	CustomMetadata map[string]string `json:"custom_metadata,omitempty"`
+	// DeletedAt is when the video was moved to the trash. Trashed videos
+	// are left out of every query but the trash's own, until they're
+	// restored or purged.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// Visibility is who can find and watch the video, see the Visibility
+	// constants. ShareKey is the secret an unlisted video's links carry.
+	// Only SetVideoVisibility changes them; UpdateVideo leaves them alone.
+	Visibility string `json:"visibility"`
+	ShareKey   string `json:"share_key,omitempty"`
+	// PassphraseHash is the bcrypt hash of the passphrase viewers have to
+	// enter to play the video, or empty if it has none, and
+	// PassphraseRequiresLogin whether they also have to be signed in.
+	// PassphraseProtected isn't stored; it's set when PassphraseHash is.
+	// Only SetVideoPassphrase changes them; UpdateVideo leaves them alone.
+	PassphraseHash          string `json:"-"`
+	PassphraseProtected     bool   `json:"passphrase_protected"`
+	PassphraseRequiresLogin bool   `json:"passphrase_requires_login,omitempty"`
+	Schedule
+	Rating
+	ColorInfo
+	SphericalInfo
 	CreateVideoParams
 }
 
+// ColorInfo describes the color characteristics of a video's source file.
+// SDRVideoURL points at an 8-bit SDR copy, generated for HDR and 10-bit
+// sources so players without HDR support have something to play.
+type ColorInfo struct {
+	DynamicRange   string  `json:"dynamic_range"`
+	BitDepth       int     `json:"bit_depth"`
+	ColorPrimaries string  `json:"color_primaries,omitempty"`
+	ColorTransfer  string  `json:"color_transfer,omitempty"`
+	ColorSpace     string  `json:"color_space,omitempty"`
+	SDRVideoURL    *string `json:"sdr_video_url,omitempty"`
+}
+
+// VideoMetadata is what ffprobe reports of a video's stored file, for
+// clients to show its duration or pick a player. Rotation is how far the
+// frame is turned clockwise for display, in degrees. FrameRate is the
+// average, so it's fractional for variable and NTSC rates. The audio fields
+// describe the first audio stream and are empty if there is none.
+type VideoMetadata struct {
+	DurationSeconds float64 `json:"duration_seconds"`
+	Container       string  `json:"container"`
+	SizeBytes       int64   `json:"size_bytes"`
+	BitRate         int64   `json:"bit_rate"`
+	VideoCodec      string  `json:"video_codec"`
+	VideoProfile    string  `json:"video_profile,omitempty"`
+	Width           int     `json:"width"`
+	Height          int     `json:"height"`
+	FrameRate       float64 `json:"frame_rate"`
+	Rotation        int     `json:"rotation"`
+	AudioCodec      string  `json:"audio_codec,omitempty"`
+	AudioChannels   int     `json:"audio_channels,omitempty"`
+	AudioSampleRate int     `json:"audio_sample_rate,omitempty"`
+}
+
+// SphericalInfo flags 360° video so players can enable VR controls.
+// Projection is e.g. "equirectangular" or "cubemap"; StereoMode is set for
+// stereoscopic video, e.g. "top and bottom".
+type SphericalInfo struct {
+	Spherical  bool   `json:"is_360"`
+	Projection string `json:"projection,omitempty"`
+	StereoMode string `json:"stereo_mode,omitempty"`
+}
+
+// Schedule holds back a video from viewers other than its owner until
+// PublishAt, which is stored in UTC. TimeZone is the IANA zone the owner
+// scheduled in, kept so clients can show the time the way it was entered.
+// A Premiere starts for everyone at PublishAt, with a countdown until then.
+type Schedule struct {
+	PublishAt *time.Time `json:"publish_at"`
+	TimeZone  string     `json:"publish_time_zone,omitempty"`
+	Premiere  bool       `json:"premiere,omitempty"`
+}
+
+// Published reports whether a video with this schedule is visible at now.
+func (s Schedule) Published(now time.Time) bool {
+	return s.PublishAt == nil || !now.Before(*s.PublishAt)
+}
+
+// Rating is a video's content rating. Age-restricted videos can only be
+// played by viewers who have confirmed or verified their age. RatingSetBy
+// is "owner" or "moderator"; owners can't change a moderator's rating.
+type Rating struct {
+	ContentRating string `json:"content_rating,omitempty"`
+	AgeRestricted bool   `json:"age_restricted"`
+	RatingSetBy   string `json:"rating_set_by,omitempty"`
+}
+
 type CreateVideoParams struct {
-	Title       string    `json:"title"`
-	Description string    `json:"description"`
-	UserID      uuid.UUID `json:"user_id"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	// Tags are lowercase keywords describing the video, used for
+	// recommendations.
+	Tags   []string  `json:"tags"`
+	UserID uuid.UUID `json:"user_id"`
+	// ExternalIDs are the video's IDs in other systems, such as a CMS, by
+	// the name of the system. Each is the owner's video in that system
+	// alone. Only CreateVideo sets them.
+	ExternalIDs map[string]string `json:"external_ids,omitempty"`
 }
 
-func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
-	query := `
-	SELECT
+const videoColumns = `
 		id,
 		created_at,
 		updated_at,
@@ -33,13 +179,134 @@ func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
 		description,
 		thumbnail_url,
 		video_url,
-		user_id
-	FROM videos
-	WHERE user_id = ?
-	ORDER BY created_at DESC
-	`
+		user_id,
+		dynamic_range,
+		bit_depth,
+		color_primaries,
+		color_transfer,
+		color_space,
+		sdr_video_url,
+		aspect_ratio,
+		is_short,
+		preview_url,
+		spherical,
+		projection,
+		stereo_mode,
+		peaks_url,
+		original_filename,
+		publish_at,
+		publish_time_zone,
+		premiere,
+		moderation_hold,
+		content_rating,
+		age_restricted,
+		rating_set_by,
+		thumbnail_sha256,
+		legal_hold,
+		processing_status,
+		processing_error,
+		tags,
+		hls_url,
+		source_sha256,
+		video_sha256,
+		encryption,
+		metadata,
+		deleted_at,
+		visibility,
+		share_key,
+		passphrase_hash,
+		passphrase_requires_login,
+		custom_metadata,
+		(SELECT json_group_object(source, external_id) FROM external_ids WHERE external_ids.video_id = videos.id)`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanVideo(row rowScanner) (Video, error) {
+	var video Video
+	var tags string
+	var metadata sql.NullString
+	var customMetadata, externalIDs string
+	err := row.Scan(
+		&video.ID,
+		&video.CreatedAt,
+		&video.UpdatedAt,
+		&video.Title,
+		&video.Description,
+		&video.ThumbnailURL,
+		&video.VideoURL,
+		&video.UserID,
+		&video.DynamicRange,
+		&video.BitDepth,
+		&video.ColorPrimaries,
+		&video.ColorTransfer,
+		&video.ColorSpace,
+		&video.SDRVideoURL,
+		&video.AspectRatio,
+		&video.IsShort,
+		&video.PreviewURL,
+		&video.Spherical,
+		&video.Projection,
+		&video.StereoMode,
+		&video.PeaksURL,
+		&video.OriginalFilename,
+		&video.PublishAt,
+		&video.TimeZone,
+		&video.Premiere,
+		&video.ModerationHold,
+		&video.ContentRating,
+		&video.AgeRestricted,
+		&video.RatingSetBy,
+		&video.ThumbnailSHA256,
+		&video.LegalHold,
+		&video.ProcessingStatus,
+		&video.ProcessingError,
+		&tags,
+		&video.HLSURL,
+		&video.SourceSHA256,
+		&video.VideoSHA256,
+		&video.Encryption,
+		&metadata,
+		&video.DeletedAt,
+		&video.Visibility,
+		&video.ShareKey,
+		&video.PassphraseHash,
+		&video.PassphraseRequiresLogin,
+		&customMetadata,
+		&externalIDs,
+	)
+	if err != nil {
+		return video, err
+	}
+	if metadata.Valid {
+		if err := json.Unmarshal([]byte(metadata.String), &video.Metadata); err != nil {
+			return video, err
+		}
+	}
+	if video.PublishAt != nil {
+		publishAt := video.PublishAt.UTC()
+		video.PublishAt = &publishAt
+	}
+	if video.DeletedAt != nil {
+		deletedAt := video.DeletedAt.UTC()
+		video.DeletedAt = &deletedAt
+	}
+	video.PassphraseProtected = video.PassphraseHash != ""
+	if err := json.Unmarshal([]byte(tags), &video.Tags); err != nil {
+		return video, err
+	}
+	if err := json.Unmarshal([]byte(customMetadata), &video.CustomMetadata); err != nil {
+		return video, err
+	}
+	if err := json.Unmarshal([]byte(externalIDs), &video.ExternalIDs); err != nil {
+		return video, err
+	}
+	return video, nil
+}
 
-	rows, err := c.db.Query(query, userID)
+func (c Client) queryVideos(query string, args ...any) ([]Video, error) {
+	rows, err := c.db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -47,27 +314,46 @@ func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
 
 	videos := []Video{}
 	for rows.Next() {
-		var video Video
-		if err := rows.Scan(
-			&video.ID,
-			&video.CreatedAt,
-			&video.UpdatedAt,
-			&video.Title,
-			&video.Description,
-			&video.ThumbnailURL,
-			&video.VideoURL,
-			&video.UserID,
-		); err != nil {
+		video, err := scanVideo(rows)
+		if err != nil {
 			return nil, err
 		}
 		videos = append(videos, video)
 	}
 
-	return videos, nil
+	return videos, rows.Err()
+}
+
+// GetVideosByAspectRatio returns a user's videos with the given aspect
+// ratio, newest first.
+func (c Client) GetVideosByAspectRatio(userID uuid.UUID, aspectRatio string) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE user_id = ? AND aspect_ratio = ? AND deleted_at IS NULL
+	ORDER BY created_at DESC
+	`
+	return c.queryVideos(query, userID, aspectRatio)
+}
+
+// GetAllVideos returns every video that isn't trashed, regardless of
+// owner.
+func (c Client) GetAllVideos() ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE deleted_at IS NULL
+	ORDER BY created_at
+	`
+	return c.queryVideos(query)
 }
 
 func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 	id := uuid.New()
+	tags, err := marshalTags(params.Tags)
+	if err != nil {
+		return Video{}, err
+	}
 	query := `
 	INSERT INTO videos (
 		id,
@@ -75,42 +361,49 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 		updated_at,
 		title,
 		description,
+		tags,
 		user_id
-	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?)
 	`
-	_, err := c.db.Exec(query, id, params.Title, params.Description, params.UserID)
+	tx, err := c.db.Begin()
 	if err != nil {
 		return Video{}, err
 	}
+	defer tx.Rollback()
+	_, err = tx.Exec(query, id, params.Title, params.Description, tags, params.UserID)
+	if err != nil {
+		return Video{}, err
+	}
+	for source, externalID := range params.ExternalIDs {
+		res, err := tx.Exec(`
+		INSERT INTO external_ids (user_id, source, external_id, video_id, created_at)
+		VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT DO NOTHING
+		`, params.UserID, source, externalID, id)
+		if err != nil {
+			return Video{}, err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return Video{}, err
+		} else if n == 0 {
+			return Video{}, ErrExternalIDTaken
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return Video{}, err
+	}
 
 	return c.GetVideo(id)
 }
 
 func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 	query := `
-	SELECT
-		id,
-		created_at,
-		updated_at,
-		title,
-		description,
-		thumbnail_url,
-		video_url,
-		user_id
+	SELECT` + videoColumns + `
 	FROM videos
-	WHERE id = ?
+	WHERE id = ? AND deleted_at IS NULL
 	`
 
-	var video Video
-	err := c.db.QueryRow(query, id).Scan(
-		&video.ID,
-		&video.CreatedAt,
-		&video.UpdatedAt,
-		&video.Title,
-		&video.Description,
-		&video.ThumbnailURL,
-		&video.VideoURL,
-		&video.UserID)
+	video, err := scanVideo(c.db.QueryRow(query, id))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return Video{}, nil
@@ -121,31 +414,247 @@ func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 	return video, nil
 }
 
+// marshalTags encodes tags for the tags column. Videos without tags store
+// an empty list rather than null.
+func marshalTags(tags []string) (string, error) {
+	if tags == nil {
+		tags = []string{}
+	}
+	b, err := json.Marshal(tags)
+	return string(b), err
+}
+
+// marshalMetadata encodes metadata for its column, where NULL is none.
+func marshalMetadata(metadata *VideoMetadata) (*string, error) {
+	if metadata == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, err
+	}
+	s := string(b)
+	return &s, nil
+}
+
 func (c Client) UpdateVideo(video Video) error {
+	tags, err := marshalTags(video.Tags)
+	if err != nil {
+		return err
+	}
+	metadata, err := marshalMetadata(video.Metadata)
+	if err != nil {
+		return err
+	}
 	query := `
 	UPDATE videos
 	SET
 		title = ?,
 		description = ?,
+		tags = ?,
 		thumbnail_url = ?,
 		video_url = ?,
-		user_id = ?
+		user_id = ?,
+		dynamic_range = ?,
+		bit_depth = ?,
+		color_primaries = ?,
+		color_transfer = ?,
+		color_space = ?,
+		sdr_video_url = ?,
+		aspect_ratio = ?,
+		is_short = ?,
+		preview_url = ?,
+		spherical = ?,
+		projection = ?,
+		stereo_mode = ?,
+		peaks_url = ?,
+		original_filename = ?,
+		publish_at = ?,
+		publish_time_zone = ?,
+		premiere = ?,
+		moderation_hold = ?,
+		content_rating = ?,
+		age_restricted = ?,
+		rating_set_by = ?,
+		thumbnail_sha256 = ?,
+		hls_url = ?,
+		source_sha256 = ?,
+		video_sha256 = ?,
+		encryption = ?,
+		metadata = ?
 	WHERE id = ?
 	`
 
-	_, err := c.db.Exec(
+	_, err = c.db.Exec(
 		query,
 		video.Title,
 		video.Description,
+		tags,
 		&video.ThumbnailURL,
 		&video.VideoURL,
 		video.UserID,
+		video.DynamicRange,
+		video.BitDepth,
+		video.ColorPrimaries,
+		video.ColorTransfer,
+		video.ColorSpace,
+		&video.SDRVideoURL,
+		video.AspectRatio,
+		video.IsShort,
+		&video.PreviewURL,
+		video.Spherical,
+		video.Projection,
+		video.StereoMode,
+		&video.PeaksURL,
+		video.OriginalFilename,
+		video.PublishAt,
+		video.TimeZone,
+		video.Premiere,
+		video.ModerationHold,
+		video.ContentRating,
+		video.AgeRestricted,
+		video.RatingSetBy,
+		video.ThumbnailSHA256,
+		&video.HLSURL,
+		video.SourceSHA256,
+		video.VideoSHA256,
+		video.Encryption,
+		metadata,
 		video.ID,
 	)
 	return err
 }
 
+// Processing statuses of a video's latest upload. Uploads wait in the
+// processing queue as pending.
+const (
+	VideoPending    = "pending"
+	VideoProcessing = "processing"
+	VideoReady      = "ready"
+	VideoFailed     = "failed"
+)
+
+func (c Client) SetVideoProcessing(id uuid.UUID, status, errMsg string) error {
+	query := `
+	UPDATE videos
+	SET processing_status = ?, processing_error = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, status, errMsg, id)
+	return err
+}
+
+// QueueVideoProcessing marks a video's new upload as pending, unless an
+// earlier upload is still pending or processing. It reports whether it did.
+func (c Client) QueueVideoProcessing(id uuid.UUID) (bool, error) {
+	query := `
+	UPDATE videos
+	SET processing_status = ?, processing_error = '', updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND processing_status NOT IN (?, ?)
+	`
+	res, err := c.db.Exec(query, VideoPending, id, VideoPending, VideoProcessing)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// FailInterruptedProcessing marks the videos still pending or processing as
+// failed with errMsg, for uploads a restart cut off. It returns how many
+// there were.
+func (c Client) FailInterruptedProcessing(errMsg string) (int64, error) {
+	query := `
+	UPDATE videos
+	SET processing_status = ?, processing_error = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE processing_status IN (?, ?)
+	`
+	res, err := c.db.Exec(query, VideoFailed, errMsg, VideoPending, VideoProcessing)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (c Client) SetVideoLegalHold(id uuid.UUID, held bool) error {
+	query := `
+	UPDATE videos
+	SET legal_hold = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, held, id)
+	return err
+}
+
+// FileHeldByOtherVideo reports whether a video other than id, trashed or
+// not, is under legal hold with its file stored under key.
+func (c Client) FileHeldByOtherVideo(key string, id uuid.UUID) (bool, error) {
+	query := `
+	SELECT EXISTS (SELECT 1 FROM videos WHERE video_url = ? AND legal_hold AND id != ?)
+	`
+	var held bool
+	err := c.db.QueryRow(query, key, id).Scan(&held)
+	return held, err
+}
+
+// Visibilities of a video. Public videos are listed and anyone can watch
+// them, unlisted ones only those with their share key, and private ones only
+// their owner.
+const (
+	VisibilityPublic   = "public"
+	VisibilityUnlisted = "unlisted"
+	VisibilityPrivate  = "private"
+)
+
+func (c Client) SetVideoVisibility(id uuid.UUID, visibility, shareKey string) error {
+	query := `
+	UPDATE videos
+	SET visibility = ?, share_key = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, visibility, shareKey, id)
+	return err
+}
+
+// SetVideoCustomMetadata replaces a video's custom metadata.
+func (c Client) SetVideoCustomMetadata(id uuid.UUID, metadata map[string]string) error {
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+	b, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	query := `
+	UPDATE videos
+	SET custom_metadata = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err = c.db.Exec(query, string(b), id)
+	return err
+}
+
+// SetVideoPassphrase sets the hash of the passphrase protecting a video, or
+// removes it if hash is empty.
+func (c Client) SetVideoPassphrase(id uuid.UUID, hash string, requiresLogin bool) error {
+	query := `
+	UPDATE videos
+	SET passphrase_hash = ?, passphrase_requires_login = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, hash, requiresLogin, id)
+	return err
+}
+
 func (c Client) DeleteVideo(id uuid.UUID) error {
+	if _, err := c.db.Exec("DELETE FROM upload_parts WHERE session_id IN (SELECT id FROM upload_sessions WHERE video_id = ?)", id); err != nil {
+		return err
+	}
+	for _, table := range []string{"link_checks", "audio_tracks", "renditions", "hls_renditions", "media_info", "processing_logs", "access_events", "reports", "thumbnail_variants", "thumbnail_candidates", "caption_tracks", "watch_progress", "view_counts", "view_totals", "upload_sessions", "storage_usage", "series_episodes", "ingest_items", "s3_imports", "external_ids"} {
+		if _, err := c.db.Exec("DELETE FROM "+table+" WHERE video_id = ?", id); err != nil {
+			return err
+		}
+	}
 	query := `
 	DELETE FROM videos
 	WHERE id = ?
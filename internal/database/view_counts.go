@@ -0,0 +1,104 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ViewCount is how many times a video was played, overall or within a
+// window.
+type ViewCount struct {
+	VideoID uuid.UUID
+	Views   int
+}
+
+// RecordView counts a playback of a video at the given time, both in the
+// video's total and in the hourly bucket it falls in. Buckets older than
+// retention are pruned, so retention must cover the longest window counts
+// are asked for.
+func (c Client) RecordView(videoID uuid.UUID, at time.Time, retention time.Duration) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	hour := at.UTC().Truncate(time.Hour)
+	bucket := `
+	INSERT INTO view_counts (video_id, hour, views)
+	VALUES (?, ?, 1)
+	ON CONFLICT (video_id, hour) DO UPDATE SET views = views + 1
+	`
+	if _, err := tx.Exec(bucket, videoID, hour); err != nil {
+		return err
+	}
+	total := `
+	INSERT INTO view_totals (video_id, views)
+	VALUES (?, 1)
+	ON CONFLICT (video_id) DO UPDATE SET views = views + 1
+	`
+	if _, err := tx.Exec(total, videoID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM view_counts WHERE hour < ?", hour.Add(-retention)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetViewCounts returns the views of every video played since the given
+// time, most viewed first. Views are counted by the hour, so since is
+// rounded down to one.
+func (c Client) GetViewCounts(since time.Time) ([]ViewCount, error) {
+	query := `
+	SELECT video_id, SUM(views) AS total
+	FROM view_counts
+	WHERE hour >= ?
+	GROUP BY video_id
+	ORDER BY total DESC
+	`
+	return c.queryViewCounts(query, since.UTC().Truncate(time.Hour))
+}
+
+// GetViewTotals returns the views of every video ever played, most viewed
+// first.
+func (c Client) GetViewTotals() ([]ViewCount, error) {
+	query := `
+	SELECT video_id, views
+	FROM view_totals
+	ORDER BY views DESC
+	`
+	return c.queryViewCounts(query)
+}
+
+// GetVideoViews returns a video's views since the given time, rounded
+// down to the hour, or ever if since is zero.
+func (c Client) GetVideoViews(videoID uuid.UUID, since time.Time) (int, error) {
+	var views int
+	var err error
+	if since.IsZero() {
+		err = c.db.QueryRow("SELECT COALESCE(SUM(views), 0) FROM view_totals WHERE video_id = ?", videoID).Scan(&views)
+	} else {
+		err = c.db.QueryRow("SELECT COALESCE(SUM(views), 0) FROM view_counts WHERE video_id = ? AND hour >= ?", videoID, since.UTC().Truncate(time.Hour)).Scan(&views)
+	}
+	return views, err
+}
+
+func (c Client) queryViewCounts(query string, args ...any) ([]ViewCount, error) {
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []ViewCount{}
+	for rows.Next() {
+		var count ViewCount
+		if err := rows.Scan(&count.VideoID, &count.Views); err != nil {
+			return nil, err
+		}
+		counts = append(counts, count)
+	}
+	return counts, rows.Err()
+}
@@ -0,0 +1,125 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// WarehouseExport records that a day's partitions were exported, with how
+// many rows each dataset got.
+type WarehouseExport struct {
+	Day        string    `json:"day"`
+	Videos     int       `json:"videos"`
+	Events     int       `json:"events"`
+	Usage      int       `json:"usage"`
+	ExportedAt time.Time `json:"exported_at"`
+}
+
+// RecordWarehouseExport saves an export, replacing an earlier one of the
+// same day.
+func (c Client) RecordWarehouseExport(e WarehouseExport) error {
+	query := `
+	INSERT INTO warehouse_exports (day, videos, events, usage, exported_at)
+	VALUES (?, ?, ?, ?, ?)
+	ON CONFLICT (day) DO UPDATE SET
+		videos = excluded.videos,
+		events = excluded.events,
+		usage = excluded.usage,
+		exported_at = excluded.exported_at
+	`
+	_, err := c.db.Exec(query, e.Day, e.Videos, e.Events, e.Usage, e.ExportedAt.UTC())
+	return err
+}
+
+// GetWarehouseExports returns the latest exports, newest day first.
+func (c Client) GetWarehouseExports(limit int) ([]WarehouseExport, error) {
+	query := `
+	SELECT day, videos, events, usage, exported_at
+	FROM warehouse_exports
+	ORDER BY day DESC
+	LIMIT ?
+	`
+	rows, err := c.db.Query(query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	exports := []WarehouseExport{}
+	for rows.Next() {
+		var e WarehouseExport
+		if err := rows.Scan(&e.Day, &e.Videos, &e.Events, &e.Usage, &e.ExportedAt); err != nil {
+			return nil, err
+		}
+		exports = append(exports, e)
+	}
+	return exports, rows.Err()
+}
+
+// GetLatestWarehouseExportDay returns the latest day exported, or "" if
+// none has been.
+func (c Client) GetLatestWarehouseExportDay() (string, error) {
+	var day string
+	err := c.db.QueryRow("SELECT day FROM warehouse_exports ORDER BY day DESC LIMIT 1").Scan(&day)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return day, err
+}
+
+// GetUserTenants returns the tenant of every user who is in one.
+func (c Client) GetUserTenants() (map[uuid.UUID]string, error) {
+	rows, err := c.db.Query("SELECT id, tenant_id FROM users WHERE tenant_id != ''")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tenants := map[uuid.UUID]string{}
+	for rows.Next() {
+		var id uuid.UUID
+		var tenantID string
+		if err := rows.Scan(&id, &tenantID); err != nil {
+			return nil, err
+		}
+		tenants[id] = tenantID
+	}
+	return tenants, rows.Err()
+}
+
+// GetViewCountsBetween returns the views of every video played from start
+// until end, by the hour.
+func (c Client) GetViewCountsBetween(start, end time.Time) ([]ViewCount, error) {
+	query := `
+	SELECT video_id, SUM(views) AS total
+	FROM view_counts
+	WHERE hour >= ? AND hour < ?
+	GROUP BY video_id
+	ORDER BY total DESC
+	`
+	return c.queryViewCounts(query, start.UTC().Truncate(time.Hour), end.UTC().Truncate(time.Hour))
+}
+
+// GetVideoStorageUsage returns the bytes recorded for the files of every
+// video that has any.
+func (c Client) GetVideoStorageUsage() (map[uuid.UUID]int64, error) {
+	rows, err := c.db.Query("SELECT video_id, SUM(bytes) FROM storage_usage GROUP BY video_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	usage := map[uuid.UUID]int64{}
+	for rows.Next() {
+		var id uuid.UUID
+		var bytes int64
+		if err := rows.Scan(&id, &bytes); err != nil {
+			return nil, err
+		}
+		usage[id] = bytes
+	}
+	return usage, rows.Err()
+}
@@ -0,0 +1,126 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// WatchProgress is how far a user got through a video, as last reported by
+// their player.
+type WatchProgress struct {
+	UserID          uuid.UUID `json:"-"`
+	VideoID         uuid.UUID `json:"video_id"`
+	PositionSeconds float64   `json:"position_seconds"`
+	// DurationSeconds is the length of the video as the player saw it, or 0
+	// if it didn't say.
+	DurationSeconds float64   `json:"duration_seconds,omitempty"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// SaveWatchProgress records a position report, replacing the user's last one
+// for the video. Reports less than minInterval after the last one are
+// dropped, and SaveWatchProgress reports false along with when the next one
+// will be taken.
+func (c Client) SaveWatchProgress(p WatchProgress, minInterval time.Duration) (saved bool, next time.Time, err error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return false, time.Time{}, err
+	}
+	defer tx.Rollback()
+
+	var last time.Time
+	err = tx.QueryRow("SELECT updated_at FROM watch_progress WHERE user_id = ? AND video_id = ?", p.UserID, p.VideoID).Scan(&last)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return false, time.Time{}, err
+	}
+	if err == nil && p.UpdatedAt.Before(last.Add(minInterval)) {
+		return false, last.Add(minInterval).UTC(), nil
+	}
+
+	query := `
+	INSERT INTO watch_progress (user_id, video_id, position_seconds, duration_seconds, updated_at)
+	VALUES (?, ?, ?, ?, ?)
+	ON CONFLICT (user_id, video_id) DO UPDATE SET
+		position_seconds = excluded.position_seconds,
+		duration_seconds = excluded.duration_seconds,
+		updated_at = excluded.updated_at
+	`
+	if _, err := tx.Exec(query, p.UserID, p.VideoID, p.PositionSeconds, p.DurationSeconds, p.UpdatedAt); err != nil {
+		return false, time.Time{}, err
+	}
+	return true, time.Time{}, tx.Commit()
+}
+
+// GetWatchProgress returns the user's progress through a video, or nil if
+// they haven't watched it.
+func (c Client) GetWatchProgress(userID, videoID uuid.UUID) (*WatchProgress, error) {
+	query := `
+	SELECT user_id, video_id, position_seconds, duration_seconds, updated_at
+	FROM watch_progress
+	WHERE user_id = ? AND video_id = ?
+	`
+	var p WatchProgress
+	err := c.db.QueryRow(query, userID, videoID).Scan(&p.UserID, &p.VideoID, &p.PositionSeconds, &p.DurationSeconds, &p.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	p.UpdatedAt = p.UpdatedAt.UTC()
+	return &p, nil
+}
+
+// GetWatchHistory returns the videos the user has watched, most recently
+// watched first.
+func (c Client) GetWatchHistory(userID uuid.UUID) ([]WatchProgress, error) {
+	query := `
+	SELECT user_id, video_id, position_seconds, duration_seconds, updated_at
+	FROM watch_progress
+	WHERE user_id = ?
+	ORDER BY updated_at DESC, video_id
+	`
+	rows, err := c.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := []WatchProgress{}
+	for rows.Next() {
+		var p WatchProgress
+		if err := rows.Scan(&p.UserID, &p.VideoID, &p.PositionSeconds, &p.DurationSeconds, &p.UpdatedAt); err != nil {
+			return nil, err
+		}
+		p.UpdatedAt = p.UpdatedAt.UTC()
+		history = append(history, p)
+	}
+	return history, rows.Err()
+}
+
+// DeleteWatchProgress forgets the user's progress through a video. It
+// reports false if there was none.
+func (c Client) DeleteWatchProgress(userID, videoID uuid.UUID) (bool, error) {
+	res, err := c.db.Exec("DELETE FROM watch_progress WHERE user_id = ? AND video_id = ?", userID, videoID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// DeleteWatchHistory forgets everything the user has watched and returns
+// how many entries that was.
+func (c Client) DeleteWatchHistory(userID uuid.UUID) (int64, error) {
+	res, err := c.db.Exec("DELETE FROM watch_progress WHERE user_id = ?", userID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,267 @@
+package database
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Webhook is a URL a user has the server POST lifecycle events of their
+// videos to. Events lists the event types it wants; empty is all of them.
+// Secret signs each delivery so the receiver can tell it came from us.
+// PayloadTemplate, if set, is a Go template over the event that renders
+// the JSON body sent in place of the event itself, and Headers are added
+// to every delivery; their values can hold credentials, so only the names
+// are listed.
+type Webhook struct {
+	ID              uuid.UUID         `json:"id"`
+	UserID          uuid.UUID         `json:"-"`
+	URL             string            `json:"url"`
+	Secret          string            `json:"-"`
+	Events          []string          `json:"events"`
+	PayloadTemplate string            `json:"payload_template,omitempty"`
+	Headers         map[string]string `json:"-"`
+	HeaderNames     []string          `json:"header_names,omitempty"`
+	CreatedAt       time.Time         `json:"created_at"`
+}
+
+// Wants reports whether the webhook is subscribed to eventType.
+func (w Webhook) Wants(eventType string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, e := range w.Events {
+		if e == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+type WebhookDeliveryStatus string
+
+const (
+	WebhookDeliveryPending   WebhookDeliveryStatus = "pending"
+	WebhookDeliveryDelivered WebhookDeliveryStatus = "delivered"
+	WebhookDeliveryFailed    WebhookDeliveryStatus = "failed"
+)
+
+// WebhookDelivery is one event on its way to one webhook. A pending
+// delivery is sent again at NextAttemptAt until it succeeds or runs out of
+// attempts.
+type WebhookDelivery struct {
+	ID            uuid.UUID             `json:"id"`
+	WebhookID     uuid.UUID             `json:"webhook_id"`
+	EventType     string                `json:"event_type"`
+	Payload       string                `json:"-"`
+	Status        WebhookDeliveryStatus `json:"status"`
+	Attempts      int                   `json:"attempts"`
+	LastError     string                `json:"last_error,omitempty"`
+	CreatedAt     time.Time             `json:"created_at"`
+	NextAttemptAt time.Time             `json:"next_attempt_at"`
+	DeliveredAt   *time.Time            `json:"delivered_at"`
+}
+
+const webhookColumns = `id, user_id, url, secret, events, payload_template, headers, created_at`
+
+func (c Client) CreateWebhook(w Webhook) error {
+	events, err := json.Marshal(w.Events)
+	if err != nil {
+		return err
+	}
+	if w.Headers == nil {
+		w.Headers = map[string]string{}
+	}
+	headers, err := json.Marshal(w.Headers)
+	if err != nil {
+		return err
+	}
+	secret, err := c.encrypt(fieldWebhookSecret, w.Secret)
+	if err != nil {
+		return err
+	}
+	storedHeaders, err := c.encrypt(fieldWebhookHeaders, string(headers))
+	if err != nil {
+		return err
+	}
+	query := `
+	INSERT INTO webhooks (` + webhookColumns + `)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err = c.db.Exec(query, w.ID, w.UserID, w.URL, secret, string(events), w.PayloadTemplate, storedHeaders, w.CreatedAt)
+	return err
+}
+
+// GetWebhooks returns the user's webhooks, oldest first.
+func (c Client) GetWebhooks(userID uuid.UUID) ([]Webhook, error) {
+	query := `
+	SELECT ` + webhookColumns + `
+	FROM webhooks
+	WHERE user_id = ?
+	ORDER BY created_at
+	`
+	rows, err := c.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []Webhook{}
+	for rows.Next() {
+		w, err := c.scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, w)
+	}
+	return webhooks, rows.Err()
+}
+
+// GetWebhook returns the webhook, or nil if it doesn't exist.
+func (c Client) GetWebhook(id uuid.UUID) (*Webhook, error) {
+	query := `
+	SELECT ` + webhookColumns + `
+	FROM webhooks
+	WHERE id = ?
+	`
+	w, err := c.scanWebhook(c.db.QueryRow(query, id))
+	if isNoRows(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+func (c Client) scanWebhook(row rowScanner) (Webhook, error) {
+	var w Webhook
+	var events, headers string
+	err := row.Scan(&w.ID, &w.UserID, &w.URL, &w.Secret, &events, &w.PayloadTemplate, &headers, &w.CreatedAt)
+	if err != nil {
+		return Webhook{}, err
+	}
+	if err := c.decrypt(fieldWebhookSecret, &w.Secret); err != nil {
+		return Webhook{}, err
+	}
+	if err := c.decrypt(fieldWebhookHeaders, &headers); err != nil {
+		return Webhook{}, err
+	}
+	if err := json.Unmarshal([]byte(events), &w.Events); err != nil {
+		return Webhook{}, err
+	}
+	if w.Events == nil {
+		w.Events = []string{}
+	}
+	if err := json.Unmarshal([]byte(headers), &w.Headers); err != nil {
+		return Webhook{}, err
+	}
+	w.HeaderNames = make([]string, 0, len(w.Headers))
+	for name := range w.Headers {
+		w.HeaderNames = append(w.HeaderNames, name)
+	}
+	sort.Strings(w.HeaderNames)
+	w.CreatedAt = w.CreatedAt.UTC()
+	return w, nil
+}
+
+// DeleteWebhook deletes the webhook and its deliveries, including the
+// ones still pending.
+func (c Client) DeleteWebhook(id uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM webhook_deliveries WHERE webhook_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM webhooks WHERE id = ?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+const webhookDeliveryColumns = `id, webhook_id, event_type, payload, status, attempts, last_error, created_at, next_attempt_at, delivered_at`
+
+// CreateWebhookDeliveries queues deliveries, all or none of them.
+func (c Client) CreateWebhookDeliveries(deliveries []WebhookDelivery) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+	INSERT INTO webhook_deliveries (` + webhookDeliveryColumns + `)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	for _, d := range deliveries {
+		_, err := tx.Exec(query, d.ID, d.WebhookID, d.EventType, d.Payload, d.Status, d.Attempts, d.LastError, d.CreatedAt, d.NextAttemptAt, d.DeliveredAt)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetDueWebhookDeliveries returns up to limit pending deliveries whose
+// next attempt is due at now, the longest overdue first.
+func (c Client) GetDueWebhookDeliveries(now time.Time, limit int) ([]WebhookDelivery, error) {
+	query := `
+	SELECT ` + webhookDeliveryColumns + `
+	FROM webhook_deliveries
+	WHERE status = ? AND next_attempt_at <= ?
+	ORDER BY next_attempt_at
+	LIMIT ?
+	`
+	return c.queryWebhookDeliveries(query, WebhookDeliveryPending, now, limit)
+}
+
+// GetWebhookDeliveries returns the webhook's latest deliveries, newest
+// first.
+func (c Client) GetWebhookDeliveries(webhookID uuid.UUID, limit int) ([]WebhookDelivery, error) {
+	query := `
+	SELECT ` + webhookDeliveryColumns + `
+	FROM webhook_deliveries
+	WHERE webhook_id = ?
+	ORDER BY created_at DESC
+	LIMIT ?
+	`
+	return c.queryWebhookDeliveries(query, webhookID, limit)
+}
+
+func (c Client) queryWebhookDeliveries(query string, args ...any) ([]WebhookDelivery, error) {
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		var d WebhookDelivery
+		err := rows.Scan(&d.ID, &d.WebhookID, &d.EventType, &d.Payload, &d.Status, &d.Attempts, &d.LastError, &d.CreatedAt, &d.NextAttemptAt, &d.DeliveredAt)
+		if err != nil {
+			return nil, err
+		}
+		d.CreatedAt = d.CreatedAt.UTC()
+		d.NextAttemptAt = d.NextAttemptAt.UTC()
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// UpdateWebhookDelivery records the outcome of an attempt at d.
+func (c Client) UpdateWebhookDelivery(d WebhookDelivery) error {
+	query := `
+	UPDATE webhook_deliveries
+	SET status = ?, attempts = ?, last_error = ?, next_attempt_at = ?, delivered_at = ?
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, d.Status, d.Attempts, d.LastError, d.NextAttemptAt, d.DeliveredAt, d.ID)
+	return err
+}
@@ -0,0 +1,20 @@
+package ffmpeg
+
+// BurnCaptionsCommand renders the WebVTT or SRT file at captions into the
+// video at input, keeping every audio stream as is. The output is 8-bit
+// 4:2:0 so it plays wherever sidecar captions can't.
+func BurnCaptionsCommand(input, output, captions string) *Cmd {
+	return FFmpeg().
+		Input(input).
+		Flag("-map", "0:v:0").
+		Flag("-map", "0:a?").
+		Filters("-vf",
+			NewFilter("subtitles").Option("filename", captions),
+			NewFilter("format").Option("pix_fmts", "yuv420p"),
+		).
+		Flag("-c:v", "libx264").
+		Flag("-preset", "veryfast").
+		Flag("-crf", "23").
+		Flag("-c:a", "copy").
+		MP4Output(output)
+}
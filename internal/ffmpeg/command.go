@@ -0,0 +1,345 @@
+package ffmpeg
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Paths to the binaries. They can be overridden at startup.
+var (
+	FFmpegPath  = "ffmpeg"
+	FFprobePath = "ffprobe"
+)
+
+// Fault, if set, is called before every command is built and fails it with
+// the error it returns. Chaos mode uses it to simulate timeouts.
+var Fault func(bin string) error
+
+var (
+	flagPattern     = regexp.MustCompile(`^-[a-z0-9_]+(:[a-z0-9_]+)?$`)
+	protocolPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9+.\-]*:`)
+)
+
+// Cmd builds an ffmpeg or ffprobe invocation. Every argument goes through a
+// typed method so that paths can't be mistaken for options or protocols and
+// user-supplied values are escaped before they reach a filter graph. The
+// first validation error is kept and returned from Build.
+type Cmd struct {
+	bin  string
+	args []string
+	err  error
+}
+
+func FFmpeg() *Cmd {
+	return &Cmd{bin: FFmpegPath, args: []string{"-y", "-nostdin"}}
+}
+
+func FFprobe() *Cmd {
+	return &Cmd{bin: FFprobePath}
+}
+
+func (c *Cmd) fail(err error) *Cmd {
+	if c.err == nil {
+		c.err = err
+	}
+	return c
+}
+
+// Flag appends an option and its values, e.g. Flag("-c:v", "libx264").
+func (c *Cmd) Flag(name string, values ...string) *Cmd {
+	if !flagPattern.MatchString(name) {
+		return c.fail(fmt.Errorf("invalid ffmpeg option %q", name))
+	}
+	for _, v := range values {
+		if err := validateValue(v); err != nil {
+			return c.fail(fmt.Errorf("option %s: %w", name, err))
+		}
+	}
+	c.args = append(c.args, name)
+	c.args = append(c.args, values...)
+	return c
+}
+
+// Seconds appends an option whose value is a timestamp in seconds.
+func (c *Cmd) Seconds(name string, seconds float64) *Cmd {
+	if seconds < 0 {
+		return c.fail(fmt.Errorf("option %s: negative timestamp", name))
+	}
+	return c.Flag(name, strconv.FormatFloat(seconds, 'f', 3, 64))
+}
+
+// Input appends -i path.
+func (c *Cmd) Input(path string) *Cmd {
+	if err := ValidatePath(path); err != nil {
+		return c.fail(err)
+	}
+	c.args = append(c.args, "-i", path)
+	return c
+}
+
+// ListenInput appends an input that ffmpeg serves itself, e.g. an RTMP
+// listener. Only rtmp:// URLs are accepted.
+func (c *Cmd) ListenInput(url string) *Cmd {
+	if !strings.HasPrefix(url, "rtmp://") || strings.ContainsAny(url, " \x00") {
+		return c.fail(fmt.Errorf("invalid listen URL %q", url))
+	}
+	c.args = append(c.args, "-listen", "1", "-i", url)
+	return c
+}
+
+// RemoteInput appends an http(s):// input, such as a presigned object URL;
+// plain http is for MinIO and local storage in development. ffmpeg reads it
+// with range requests, so seeking doesn't download the whole file.
+func (c *Cmd) RemoteInput(url string) *Cmd {
+	if !(strings.HasPrefix(url, "https://") || strings.HasPrefix(url, "http://")) || strings.ContainsAny(url, " \x00") {
+		return c.fail(fmt.Errorf("invalid remote input URL %q", url))
+	}
+	c.args = append(c.args, "-i", url)
+	return c
+}
+
+// Output appends the output path. It must be the last argument.
+func (c *Cmd) Output(path string) *Cmd {
+	if err := ValidatePath(path); err != nil {
+		return c.fail(err)
+	}
+	c.args = append(c.args, path)
+	return c
+}
+
+// MP4Output finishes the command with an MP4 output at path. The moov atom
+// is moved to the front for progressive playback, and the muxer is allowed to
+// write the spherical video (sv3d/st3d) boxes that keep 360° metadata intact.
+func (c *Cmd) MP4Output(path string) *Cmd {
+	return c.Flag("-strict", "unofficial").
+		Flag("-movflags", "faststart").
+		Flag("-f", "mp4").
+		Output(path)
+}
+
+// PipeOutput writes the output to stdout. It must be the last argument.
+func (c *Cmd) PipeOutput() *Cmd {
+	c.args = append(c.args, "pipe:1")
+	return c
+}
+
+// Filters appends a filter chain for the given option ("-vf", "-af" or
+// "-filter_complex").
+func (c *Cmd) Filters(name string, filters ...Filter) *Cmd {
+	if len(filters) == 0 {
+		return c
+	}
+	parts := make([]string, 0, len(filters))
+	for _, f := range filters {
+		if f.err != nil {
+			return c.fail(f.err)
+		}
+		parts = append(parts, f.String())
+	}
+	if !flagPattern.MatchString(name) {
+		return c.fail(fmt.Errorf("invalid ffmpeg option %q", name))
+	}
+	c.args = append(c.args, name, strings.Join(parts, ","))
+	return c
+}
+
+// Args returns the arguments built so far, or the first validation error.
+func (c *Cmd) Args() ([]string, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	return append([]string(nil), c.args...), nil
+}
+
+// Runner runs a built command, writing its output to stdout and stderr.
+// The default runs the binaries; the fakes package provides a scripted one
+// for tests.
+type Runner interface {
+	Run(ctx context.Context, bin string, args []string, stdout, stderr io.Writer) error
+}
+
+// Exec runs every command started with Run or StreamPCM.
+var Exec Runner = execRunner{}
+
+type execRunner struct{}
+
+func (execRunner) Run(ctx context.Context, bin string, args []string, stdout, stderr io.Writer) error {
+	cmd := exec.CommandContext(ctx, bin, args...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	return cmd.Run()
+}
+
+// prepare returns the arguments to run the command with, or the first
+// validation error or injected fault.
+func (c *Cmd) prepare() ([]string, error) {
+	args, err := c.Args()
+	if err != nil {
+		return nil, err
+	}
+	if Fault != nil {
+		if err := Fault(c.bin); err != nil {
+			return nil, err
+		}
+	}
+	return args, nil
+}
+
+// Build returns the exec.Cmd, or the first validation error. Commands that
+// need to control the process, such as live ingest, use it directly; they
+// always run the real binary.
+func (c *Cmd) Build(ctx context.Context) (*exec.Cmd, error) {
+	args, err := c.prepare()
+	if err != nil {
+		return nil, err
+	}
+	return exec.CommandContext(ctx, c.bin, args...), nil
+}
+
+// Run runs the command and returns its stdout. Stderr is included in the
+// error when the command fails. If ctx carries a Progress, ffmpeg reports
+// to it as it runs.
+func (c *Cmd) Run(ctx context.Context) ([]byte, error) {
+	args, err := c.prepare()
+	if err != nil {
+		return nil, err
+	}
+	var stdout, stderr bytes.Buffer
+	runArgs, errOut, flush := c.withProgress(ctx, args, &stderr)
+	start := time.Now()
+	err = Exec.Run(ctx, c.bin, runArgs, &stdout, errOut)
+	flush()
+	if err != nil {
+		err = fmt.Errorf("%s failed: %w: %s", c.bin, err, lastLine(stderr.String()))
+	}
+	observe(ctx, append([]string{c.bin}, args...), stderr.Bytes(), time.Since(start), err)
+	if err != nil {
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
+
+// Completed, if set, is told how long every command run with Run or
+// StreamPCM took, whatever its context. Metrics use it to time ffmpeg.
+var Completed func(bin string, elapsed time.Duration, err error)
+
+// Observer is told about every command run with a context that carries it,
+// e.g. to keep per-job processing logs.
+type Observer func(args []string, stderr []byte, elapsed time.Duration, err error)
+
+type observerKey struct{}
+
+// WithObserver returns a context that reports commands run with it to obs.
+func WithObserver(ctx context.Context, obs Observer) context.Context {
+	return context.WithValue(ctx, observerKey{}, obs)
+}
+
+func observe(ctx context.Context, args []string, stderr []byte, elapsed time.Duration, err error) {
+	if Completed != nil {
+		Completed(args[0], elapsed, err)
+	}
+	if obs, ok := ctx.Value(observerKey{}).(Observer); ok {
+		obs(args, stderr, elapsed, err)
+	}
+}
+
+// ValidatePath rejects paths that ffmpeg would interpret as something other
+// than a local file.
+func ValidatePath(path string) error {
+	if path == "" {
+		return errors.New("empty path")
+	}
+	if strings.ContainsRune(path, 0) {
+		return errors.New("path contains NUL byte")
+	}
+	if strings.HasPrefix(path, "-") {
+		return fmt.Errorf("path %q looks like an option", path)
+	}
+	if protocolPattern.MatchString(path) {
+		return fmt.Errorf("path %q looks like a protocol URL", path)
+	}
+	return nil
+}
+
+func validateValue(v string) error {
+	if strings.ContainsRune(v, 0) {
+		return errors.New("value contains NUL byte")
+	}
+	if strings.HasPrefix(v, "-") {
+		if _, err := strconv.ParseFloat(v, 64); err != nil {
+			return fmt.Errorf("value %q looks like an option", v)
+		}
+	}
+	return nil
+}
+
+func lastLine(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		return s[i+1:]
+	}
+	return s
+}
+
+// Filter is a single filter in a filter chain, e.g. scale=w=1280:h=-2.
+type Filter struct {
+	name    string
+	options []string
+	err     error
+}
+
+var filterNamePattern = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+func NewFilter(name string) Filter {
+	f := Filter{name: name}
+	if !filterNamePattern.MatchString(name) {
+		f.err = fmt.Errorf("invalid filter name %q", name)
+	}
+	return f
+}
+
+// Option adds key=value to the filter. value is escaped so it can carry
+// arbitrary user text without breaking out of the filter graph.
+func (f Filter) Option(key, value string) Filter {
+	if !filterNamePattern.MatchString(key) {
+		f.err = fmt.Errorf("invalid filter option %q", key)
+		return f
+	}
+	f.options = append(append([]string(nil), f.options...), key+"="+EscapeFilterValue(value))
+	return f
+}
+
+func (f Filter) String() string {
+	if len(f.options) == 0 {
+		return f.name
+	}
+	return f.name + "=" + strings.Join(f.options, ":")
+}
+
+// rawFilter accepts a filter string that has already been validated, such as
+// the filters of a profile loaded at startup.
+func rawFilter(s string) Filter {
+	if !filterPattern.MatchString(s) {
+		return Filter{err: fmt.Errorf("invalid filter %q", s)}
+	}
+	return Filter{name: s}
+}
+
+var (
+	optionEscaper = strings.NewReplacer(`\`, `\\`, `'`, `\'`, `:`, `\:`)
+	graphEscaper  = strings.NewReplacer(`\`, `\\`, `'`, `\'`, `[`, `\[`, `]`, `\]`, `,`, `\,`, `;`, `\;`)
+)
+
+// EscapeFilterValue escapes s for use as a filter option value, applying
+// both the option-level and filter-graph-level escaping ffmpeg expects.
+func EscapeFilterValue(s string) string {
+	return graphEscaper.Replace(optionEscaper.Replace(s))
+}
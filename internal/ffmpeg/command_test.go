@@ -0,0 +1,77 @@
+package ffmpeg
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+)
+
+// countingRunner stands in for the binaries and counts what it's asked to
+// run.
+type countingRunner struct {
+	runs map[string]int
+}
+
+func (r *countingRunner) Run(ctx context.Context, bin string, args []string, stdout, stderr io.Writer) error {
+	r.runs[bin]++
+	return nil
+}
+
+func TestFault(t *testing.T) {
+	errTimeout := errors.New("timed out")
+	tests := []struct {
+		name  string
+		fault func(bin string) error
+		// wantErr maps each binary to whether its command is failed.
+		wantErr map[string]bool
+	}{
+		{name: "no fault", wantErr: map[string]bool{FFmpegPath: false, FFprobePath: false}},
+		{name: "fault that doesn't fire", fault: func(string) error { return nil }, wantErr: map[string]bool{FFmpegPath: false, FFprobePath: false}},
+		{name: "every command", fault: func(string) error { return errTimeout }, wantErr: map[string]bool{FFmpegPath: true, FFprobePath: true}},
+		{
+			name: "only ffprobe",
+			fault: func(bin string) error {
+				if bin == FFprobePath {
+					return errTimeout
+				}
+				return nil
+			},
+			wantErr: map[string]bool{FFmpegPath: false, FFprobePath: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := &countingRunner{runs: map[string]int{}}
+			previousExec, previousFault := Exec, Fault
+			Exec, Fault = runner, tt.fault
+			defer func() { Exec, Fault = previousExec, previousFault }()
+
+			cmds := map[string]*Cmd{
+				FFmpegPath:  FFmpeg().Input("in.mp4").Flag("-c", "copy").Output("out.mp4"),
+				FFprobePath: FFprobe().Flag("-show_streams").Input("in.mp4"),
+			}
+			for bin, cmd := range cmds {
+				_, err := cmd.Run(context.Background())
+				if tt.wantErr[bin] {
+					if !errors.Is(err, errTimeout) {
+						t.Errorf("%s: Run() = %v, want the fault's error", bin, err)
+					}
+					if runner.runs[bin] != 0 {
+						t.Errorf("%s: a failed command still ran", bin)
+					}
+					if _, err := cmd.Build(context.Background()); !errors.Is(err, errTimeout) {
+						t.Errorf("%s: Build() = %v, want the fault's error", bin, err)
+					}
+					continue
+				}
+				if err != nil {
+					t.Errorf("%s: Run() = %v, want nil", bin, err)
+				}
+				if runner.runs[bin] != 1 {
+					t.Errorf("%s: ran %d times, want 1", bin, runner.runs[bin])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package ffmpeg
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// SocialCrop is a crop of a landscape video to the shape a social network
+// favors, for cross-posting.
+type SocialCrop struct {
+	Name   string `json:"name"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// SocialCrops lists the crops produced for landscape videos, at the largest
+// size they are encoded at.
+var SocialCrops = []SocialCrop{
+	{Name: "square", Width: 1080, Height: 1080},
+	{Name: "vertical", Width: 1080, Height: 1920},
+}
+
+// SocialCropFor scales crop down so it isn't taller than a source of the
+// given height, keeping its aspect ratio and even dimensions.
+func SocialCropFor(crop SocialCrop, sourceHeight int) SocialCrop {
+	if sourceHeight <= 0 || crop.Height <= sourceHeight {
+		return crop
+	}
+	height := sourceHeight &^ 1
+	crop.Width = (height * crop.Width / crop.Height) &^ 1
+	crop.Height = height
+	return crop
+}
+
+// CropCommand cuts the largest centered region of crop's aspect ratio out of
+// input and encodes it as an MP4 at crop's resolution, keeping every audio
+// stream.
+func CropCommand(input, output string, crop SocialCrop) *Cmd {
+	ratio := fmt.Sprintf("%d/%d", crop.Width, crop.Height)
+	return FFmpeg().
+		Input(input).
+		Flag("-map", "0:v:0").
+		Flag("-map", "0:a?").
+		Filters("-vf",
+			NewFilter("crop").Option("w", "min(iw,ih*"+ratio+")").Option("h", "min(ih,iw/("+ratio+"))"),
+			NewFilter("scale").Option("w", strconv.Itoa(crop.Width)).Option("h", strconv.Itoa(crop.Height)),
+			NewFilter("setsar").Option("sar", "1"),
+		).
+		Flag("-c:v", "libx264").
+		Flag("-preset", "veryfast").
+		Flag("-crf", "23").
+		Flag("-c:a", "aac").
+		Flag("-b:a", "128k").
+		MP4Output(output)
+}
@@ -0,0 +1,31 @@
+package ffmpeg
+
+// FrameCommand writes the frame at the given timestamp of input to output as
+// a JPEG. Seeking before the input with re-encoding is frame accurate: ffmpeg
+// jumps to the preceding keyframe and decodes forward to the exact time.
+func FrameCommand(input, output string, seconds float64) *Cmd {
+	return frameCommand(FFmpeg().Seconds("-ss", seconds).Input(input), output)
+}
+
+// RemoteFrameCommand is FrameCommand for an https:// input such as a
+// presigned URL.
+func RemoteFrameCommand(url, output string, seconds float64) *Cmd {
+	return frameCommand(FFmpeg().Seconds("-ss", seconds).RemoteInput(url), output)
+}
+
+func frameCommand(cmd *Cmd, output string) *Cmd {
+	return cmd.
+		Flag("-frames:v", "1").
+		Flag("-q:v", "2").
+		Flag("-f", "image2").
+		Output(output)
+}
+
+// SceneFrameCommand writes a representative frame of input to output as a
+// JPEG. ffmpeg's thumbnail filter picks the one of the 300 frames following
+// start that is closest to their average, which passes over flashes and
+// fades.
+func SceneFrameCommand(input, output string, start float64) *Cmd {
+	cmd := FFmpeg().Seconds("-ss", start).Input(input).Filters("-vf", NewFilter("thumbnail").Option("n", "300"))
+	return frameCommand(cmd, output)
+}
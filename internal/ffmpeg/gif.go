@@ -0,0 +1,38 @@
+package ffmpeg
+
+import "strconv"
+
+// GIFPaletteCommand writes the 256-colour palette that best fits duration
+// seconds of the video at url from start, at the given frame rate and width,
+// to palette as a PNG. stats_mode=diff weighs the parts of frames that move,
+// which is what the eye follows in a looping clip.
+func GIFPaletteCommand(url, palette string, start, duration float64, fps, width int) *Cmd {
+	return gifInput(url, start, duration).
+		Filters("-vf", append(gifScale(fps, width), NewFilter("palettegen").Option("stats_mode", "diff"))...).
+		Flag("-frames:v", "1").
+		Flag("-f", "image2").
+		Output(palette)
+}
+
+// GIFCommand writes the same range of the video at url to output as a GIF
+// using the palette from GIFPaletteCommand. paletteuse's second input isn't
+// labelled, so ffmpeg feeds it the palette, the next unused video stream.
+func GIFCommand(url, palette, output string, start, duration float64, fps, width int) *Cmd {
+	return gifInput(url, start, duration).
+		Input(palette).
+		Filters("-filter_complex", append(gifScale(fps, width), NewFilter("paletteuse").Option("dither", "bayer").Option("bayer_scale", "5").Option("diff_mode", "rectangle"))...).
+		Flag("-loop", "0").
+		Flag("-f", "gif").
+		Output(output)
+}
+
+func gifInput(url string, start, duration float64) *Cmd {
+	return FFmpeg().Seconds("-ss", start).Seconds("-t", duration).RemoteInput(url)
+}
+
+func gifScale(fps, width int) []Filter {
+	return []Filter{
+		NewFilter("fps").Option("fps", strconv.Itoa(fps)),
+		NewFilter("scale").Option("w", strconv.Itoa(width)).Option("h", "-2").Option("flags", "lanczos"),
+	}
+}
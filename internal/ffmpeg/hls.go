@@ -0,0 +1,91 @@
+package ffmpeg
+
+import (
+	"path/filepath"
+	"strconv"
+)
+
+// HLSSegmentSeconds is the target length of an HLS segment. Keyframes are
+// forced on the same boundaries in every rendition, so players can switch
+// between them at any segment.
+const HLSSegmentSeconds = 6
+
+// HLSRung is one rendition of the HLS ladder. MaxrateKbps caps its video
+// bitrate and is advertised as its bandwidth.
+type HLSRung struct {
+	Name        string `json:"name"`
+	Height      int    `json:"height"`
+	MaxrateKbps int    `json:"maxrate_kbps"`
+}
+
+// HLSLadder lists the adaptive bitrate renditions, largest first.
+var HLSLadder = []HLSRung{
+	{Name: "1080p", Height: 1080, MaxrateKbps: 5000},
+	{Name: "720p", Height: 720, MaxrateKbps: 2800},
+	{Name: "480p", Height: 480, MaxrateKbps: 1200},
+}
+
+// HLSLadderFor returns the rungs that don't upscale a source of the given
+// height. The smallest rung is always included.
+func HLSLadderFor(sourceHeight int) []HLSRung {
+	var rungs []HLSRung
+	for _, r := range HLSLadder {
+		if r.Height <= sourceHeight {
+			rungs = append(rungs, r)
+		}
+	}
+	if len(rungs) == 0 {
+		rungs = HLSLadder[len(HLSLadder)-1:]
+	}
+	return rungs
+}
+
+// HLSAudioBitrateKbps is the bitrate of each audio rendition.
+const HLSAudioBitrateKbps = 128
+
+// HLSCommand encodes the first video stream of input as a VOD HLS
+// rendition at the rung's height, keeping the aspect ratio. The audio is
+// left to HLSAudioCommand, so every rung shares the same audio
+// renditions. The playlist is written to playlistPath, with fMP4 segments
+// and their init.mp4 next to it.
+func HLSCommand(input, playlistPath string, rung HLSRung) *Cmd {
+	maxrate := strconv.Itoa(rung.MaxrateKbps) + "k"
+	bufsize := strconv.Itoa(2*rung.MaxrateKbps) + "k"
+	return hlsOutput(FFmpeg().
+		Input(input).
+		Flag("-map", "0:v:0").
+		Filters("-vf", NewFilter("scale").Option("w", "-2").Option("h", strconv.Itoa(rung.Height))).
+		Flag("-c:v", "libx264").
+		Flag("-preset", "veryfast").
+		Flag("-crf", "23").
+		Flag("-maxrate", maxrate).
+		Flag("-bufsize", bufsize).
+		Flag("-pix_fmt", "yuv420p").
+		Flag("-force_key_frames", "expr:gte(t,n_forced*"+strconv.Itoa(HLSSegmentSeconds)+")").
+		Flag("-an"), playlistPath)
+}
+
+// HLSAudioCommand encodes input's audio stream track, counting from 0, as
+// an audio-only VOD HLS rendition, segmented like the video rungs. The
+// playlist is written to playlistPath, with fMP4 segments and their
+// init.mp4 next to it.
+func HLSAudioCommand(input, playlistPath string, track int) *Cmd {
+	return hlsOutput(FFmpeg().
+		Input(input).
+		Flag("-map", "0:a:"+strconv.Itoa(track)).
+		Flag("-vn").
+		Flag("-c:a", "aac").
+		Flag("-b:a", strconv.Itoa(HLSAudioBitrateKbps)+"k"), playlistPath)
+}
+
+func hlsOutput(cmd *Cmd, playlistPath string) *Cmd {
+	return cmd.
+		Flag("-f", "hls").
+		Flag("-hls_time", strconv.Itoa(HLSSegmentSeconds)).
+		Flag("-hls_playlist_type", "vod").
+		Flag("-hls_segment_type", "fmp4").
+		Flag("-hls_flags", "independent_segments").
+		Flag("-hls_fmp4_init_filename", "init.mp4").
+		Flag("-hls_segment_filename", filepath.Join(filepath.Dir(playlistPath), "segment_%04d.m4s")).
+		Output(playlistPath)
+}
@@ -0,0 +1,46 @@
+package ffmpeg
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Keyframes returns the presentation timestamps, in seconds, of the
+// keyframes of the first video stream of input, in order. It reads packet
+// flags rather than decoding, so it takes about as long as reading the file.
+func Keyframes(ctx context.Context, input string) ([]float64, error) {
+	out, err := FFprobe().
+		Flag("-v", "error").
+		Flag("-select_streams", "v:0").
+		Flag("-show_entries", "packet=pts_time,flags").
+		Flag("-of", "csv=p=0").
+		Input(input).
+		Run(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return parseKeyframes(string(out))
+}
+
+// parseKeyframes reads ffprobe's pts_time,flags lines, keeping the packets
+// flagged K. Packets come in decode order, so B-frames make them unsorted.
+func parseKeyframes(out string) ([]float64, error) {
+	keyframes := []float64{}
+	for _, line := range strings.Split(out, "\n") {
+		pts, flags, ok := strings.Cut(strings.TrimSpace(line), ",")
+		if !ok || !strings.Contains(flags, "K") || pts == "N/A" {
+			continue
+		}
+		seconds, err := strconv.ParseFloat(pts, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid packet timestamp %q: %w", pts, err)
+		}
+		keyframes = append(keyframes, math.Round(seconds*1000)/1000)
+	}
+	sort.Float64s(keyframes)
+	return keyframes, nil
+}
@@ -0,0 +1,32 @@
+package ffmpeg
+
+import "strconv"
+
+// LiveIngestCommand listens for a single RTMP publisher on listenURL and
+// writes the stream as an HLS event playlist, keeping every segment so the
+// recording can be turned into a VOD when the stream ends. lowLatency
+// switches to fMP4 segments that start on independent frames so they can be
+// fetched as soon as they are written.
+func LiveIngestCommand(listenURL, playlistPath string, segmentSeconds int, lowLatency bool) *Cmd {
+	cmd := FFmpeg().
+		ListenInput(listenURL).
+		Flag("-c", "copy").
+		Flag("-f", "hls").
+		Flag("-hls_time", strconv.Itoa(segmentSeconds)).
+		Flag("-hls_list_size", "0").
+		Flag("-hls_playlist_type", "event")
+	if lowLatency {
+		cmd.Flag("-hls_segment_type", "fmp4").
+			Flag("-hls_flags", "independent_segments+program_date_time")
+	}
+	return cmd.Output(playlistPath)
+}
+
+// RemuxCommand copies the streams of input into an MP4 at output without
+// re-encoding.
+func RemuxCommand(input, output string) *Cmd {
+	return FFmpeg().
+		Input(input).
+		Flag("-c", "copy").
+		MP4Output(output)
+}
@@ -0,0 +1,113 @@
+package ffmpeg
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Peaks is an audio waveform summary in the audiowaveform JSON format: a
+// min/max pair per pixel column, as signed 8-bit values.
+type Peaks struct {
+	Version         int    `json:"version"`
+	Channels        int    `json:"channels"`
+	SampleRate      int    `json:"sample_rate"`
+	SamplesPerPixel int    `json:"samples_per_pixel"`
+	Bits            int    `json:"bits"`
+	Length          int    `json:"length"`
+	Data            []int8 `json:"data"`
+}
+
+const (
+	peaksSampleRate      = 8000
+	peaksSamplesPerPixel = 256
+)
+
+// GeneratePeaks decodes the first audio stream of input to mono PCM and
+// summarises it into peaks. The PCM is streamed through rather than held in
+// memory, so long files are fine.
+func GeneratePeaks(ctx context.Context, input string) (Peaks, error) {
+	peaks := Peaks{
+		Version:         2,
+		Channels:        1,
+		SampleRate:      peaksSampleRate,
+		SamplesPerPixel: peaksSamplesPerPixel,
+		Bits:            8,
+		Data:            []int8{},
+	}
+	err := StreamPCM(ctx, input, peaksSampleRate, func(r io.Reader) error {
+		return readPeaks(r, &peaks)
+	})
+	if err != nil {
+		return Peaks{}, err
+	}
+	return peaks, nil
+}
+
+// StreamPCM decodes the first audio stream of input to mono signed 16-bit
+// little-endian PCM at sampleRate and passes it to read as it is produced.
+func StreamPCM(ctx context.Context, input string, sampleRate int, read func(io.Reader) error) error {
+	cmd := FFmpeg().
+		Flag("-v", "error").
+		Input(input).
+		Flag("-map", "0:a:0").
+		Flag("-ac", "1").
+		Flag("-ar", strconv.Itoa(sampleRate)).
+		Flag("-f", "s16le").
+		PipeOutput()
+	args, err := cmd.prepare()
+	if err != nil {
+		return err
+	}
+	var stderr bytes.Buffer
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	start := time.Now()
+	go func() {
+		err := Exec.Run(ctx, cmd.bin, args, pw, &stderr)
+		pw.CloseWithError(err)
+		done <- err
+	}()
+
+	readErr := read(pr)
+	// Drain what read left so ffmpeg isn't blocked writing to the pipe.
+	io.Copy(io.Discard, pr)
+	err = <-done
+	if err != nil {
+		err = fmt.Errorf("%s failed: %w: %s", FFmpegPath, err, lastLine(stderr.String()))
+	} else {
+		err = readErr
+	}
+	observe(ctx, append([]string{cmd.bin}, args...), stderr.Bytes(), time.Since(start), err)
+	return err
+}
+
+func readPeaks(r io.Reader, peaks *Peaks) error {
+	buf := make([]byte, 2*peaks.SamplesPerPixel)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n >= 2 {
+			lo, hi := int16(math.MaxInt16), int16(math.MinInt16)
+			for i := 0; i+1 < n; i += 2 {
+				sample := int16(binary.LittleEndian.Uint16(buf[i:]))
+				lo = min(lo, sample)
+				hi = max(hi, sample)
+			}
+			peaks.Data = append(peaks.Data, int8(lo>>8), int8(hi>>8))
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	peaks.Length = len(peaks.Data) / 2
+	return nil
+}
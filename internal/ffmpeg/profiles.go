@@ -0,0 +1,170 @@
+package ffmpeg
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// DefaultProfileName is the profile used when no config file is given. It
+// remuxes the input without re-encoding and moves the moov atom to the front.
+const DefaultProfileName = "faststart"
+
+var (
+	videoCodecs = map[string]bool{"copy": true, "libx264": true, "libx265": true, "libvpx-vp9": true}
+	audioCodecs = map[string]bool{"copy": true, "aac": true, "libopus": true}
+	presets     = map[string]bool{
+		"ultrafast": true, "superfast": true, "veryfast": true, "faster": true, "fast": true,
+		"medium": true, "slow": true, "slower": true, "veryslow": true,
+	}
+	bitratePattern = regexp.MustCompile(`^[0-9]+k$`)
+	filterPattern  = regexp.MustCompile(`^[a-z0-9_]+(=[A-Za-z0-9_:.\-]+)?$`)
+	namePattern    = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+)
+
+// Profile is a named set of transcode settings.
+type Profile struct {
+	Name         string   `json:"name"`
+	VideoCodec   string   `json:"video_codec"`
+	CRF          int      `json:"crf,omitempty"`
+	Preset       string   `json:"preset,omitempty"`
+	AudioCodec   string   `json:"audio_codec"`
+	AudioBitrate string   `json:"audio_bitrate,omitempty"`
+	Filters      []string `json:"filters,omitempty"`
+}
+
+func (p Profile) Validate() error {
+	if !namePattern.MatchString(p.Name) {
+		return fmt.Errorf("invalid profile name %q", p.Name)
+	}
+	if !videoCodecs[p.VideoCodec] {
+		return fmt.Errorf("profile %q: unsupported video codec %q", p.Name, p.VideoCodec)
+	}
+	if !audioCodecs[p.AudioCodec] {
+		return fmt.Errorf("profile %q: unsupported audio codec %q", p.Name, p.AudioCodec)
+	}
+	if p.VideoCodec == "copy" {
+		if p.CRF != 0 || p.Preset != "" || len(p.Filters) > 0 {
+			return fmt.Errorf("profile %q: crf, preset and filters require re-encoding video", p.Name)
+		}
+	} else {
+		if p.CRF < 0 || p.CRF > 51 {
+			return fmt.Errorf("profile %q: crf must be between 0 and 51", p.Name)
+		}
+		if p.Preset != "" && !presets[p.Preset] {
+			return fmt.Errorf("profile %q: unknown preset %q", p.Name, p.Preset)
+		}
+	}
+	if p.AudioBitrate != "" {
+		if p.AudioCodec == "copy" {
+			return fmt.Errorf("profile %q: audio_bitrate requires re-encoding audio", p.Name)
+		}
+		if !bitratePattern.MatchString(p.AudioBitrate) {
+			return fmt.Errorf("profile %q: audio_bitrate must look like 128k", p.Name)
+		}
+	}
+	for _, f := range p.Filters {
+		if !filterPattern.MatchString(f) {
+			return fmt.Errorf("profile %q: invalid filter %q", p.Name, f)
+		}
+	}
+	return nil
+}
+
+// Command returns the ffmpeg invocation that transcodes input into an MP4
+// at output using this profile. The first video stream and every audio
+// stream are kept, so alternate language and commentary tracks survive.
+func (p Profile) Command(input, output string) *Cmd {
+	cmd := FFmpeg().
+		Input(input).
+		Flag("-map", "0:v:0").
+		Flag("-map", "0:a?").
+		Flag("-c:v", p.VideoCodec)
+	if p.VideoCodec != "copy" {
+		cmd.Flag("-crf", strconv.Itoa(p.CRF))
+		if p.Preset != "" {
+			cmd.Flag("-preset", p.Preset)
+		}
+		filters := make([]Filter, 0, len(p.Filters))
+		for _, f := range p.Filters {
+			filters = append(filters, rawFilter(f))
+		}
+		cmd.Filters("-vf", filters...)
+	}
+	cmd.Flag("-c:a", p.AudioCodec)
+	if p.AudioBitrate != "" {
+		cmd.Flag("-b:a", p.AudioBitrate)
+	}
+	return cmd.MP4Output(output)
+}
+
+// Profiles is the set of profiles available to the pipeline.
+type Profiles struct {
+	Default  string
+	profiles map[string]Profile
+}
+
+type profilesFile struct {
+	Default  string    `json:"default"`
+	Profiles []Profile `json:"profiles"`
+}
+
+// DefaultProfiles returns the built-in profile set used when no config file
+// is configured.
+func DefaultProfiles() *Profiles {
+	return &Profiles{
+		Default: DefaultProfileName,
+		profiles: map[string]Profile{
+			DefaultProfileName: {Name: DefaultProfileName, VideoCodec: "copy", AudioCodec: "copy"},
+		},
+	}
+}
+
+// LoadProfiles reads and validates a JSON profiles file.
+func LoadProfiles(path string) (*Profiles, error) {
+	dat, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file profilesFile
+	if err := json.Unmarshal(dat, &file); err != nil {
+		return nil, fmt.Errorf("couldn't parse profiles file: %w", err)
+	}
+
+	ps := &Profiles{Default: file.Default, profiles: map[string]Profile{}}
+	for _, p := range file.Profiles {
+		if err := p.Validate(); err != nil {
+			return nil, err
+		}
+		if _, ok := ps.profiles[p.Name]; ok {
+			return nil, fmt.Errorf("duplicate profile %q", p.Name)
+		}
+		ps.profiles[p.Name] = p
+	}
+	if _, ok := ps.profiles[ps.Default]; !ok {
+		return nil, fmt.Errorf("default profile %q is not defined", ps.Default)
+	}
+	return ps, nil
+}
+
+// Get returns the named profile, or the default profile if name is empty.
+func (ps *Profiles) Get(name string) (Profile, bool) {
+	if name == "" {
+		name = ps.Default
+	}
+	p, ok := ps.profiles[name]
+	return p, ok
+}
+
+// Names returns the names of all profiles, sorted.
+func (ps *Profiles) Names() []string {
+	names := make([]string, 0, len(ps.profiles))
+	for name := range ps.profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
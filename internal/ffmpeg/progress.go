@@ -0,0 +1,83 @@
+package ffmpeg
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Progress is told how far into its input an ffmpeg command has got.
+type Progress func(outTime time.Duration)
+
+type progressKey struct{}
+
+// WithProgress returns a context that has ffmpeg commands run with it
+// report their progress to fn. ffprobe commands don't report any.
+func WithProgress(ctx context.Context, fn Progress) context.Context {
+	return context.WithValue(ctx, progressKey{}, fn)
+}
+
+// progressLine matches the key=value lines -progress writes, which are told
+// apart from ffmpeg's log this way.
+var progressLine = regexp.MustCompile(`^[a-z_0-9]+=\S*$`)
+
+// progressWriter takes the -progress output out of ffmpeg's stderr, reporting
+// out_time_us to fn and passing everything else through to w.
+type progressWriter struct {
+	w       io.Writer
+	fn      Progress
+	partial []byte
+}
+
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	pw.partial = append(pw.partial, p...)
+	for {
+		i := bytes.IndexByte(pw.partial, '\n')
+		if i < 0 {
+			break
+		}
+		line := pw.partial[:i+1]
+		pw.partial = pw.partial[i+1:]
+		if err := pw.line(line); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (pw *progressWriter) line(line []byte) error {
+	trimmed := bytes.TrimSpace(line)
+	if !progressLine.Match(trimmed) {
+		_, err := pw.w.Write(line)
+		return err
+	}
+	if value, ok := bytes.CutPrefix(trimmed, []byte("out_time_us=")); ok {
+		if us, err := strconv.ParseInt(string(value), 10, 64); err == nil && us >= 0 {
+			pw.fn(time.Duration(us) * time.Microsecond)
+		}
+	}
+	return nil
+}
+
+// flush passes through a last line without a newline.
+func (pw *progressWriter) flush() {
+	if len(pw.partial) > 0 {
+		pw.line(pw.partial)
+		pw.partial = nil
+	}
+}
+
+// withProgress adds -progress to args and wraps stderr to parse it if ctx
+// asks for progress.
+func (c *Cmd) withProgress(ctx context.Context, args []string, stderr io.Writer) ([]string, io.Writer, func()) {
+	fn, ok := ctx.Value(progressKey{}).(Progress)
+	if !ok || c.bin != FFmpegPath {
+		return args, stderr, func() {}
+	}
+	args = append([]string{"-progress", "pipe:2", "-nostats"}, args...)
+	pw := &progressWriter{w: stderr, fn: fn}
+	return args, pw, pw.flush
+}
@@ -0,0 +1,41 @@
+package ffmpeg
+
+// RemuxOptions says which streams of an input must be re-encoded because
+// MP4 can't carry their codec, such as ProRes video or PCM audio, or
+// because browsers can't play it.
+type RemuxOptions struct {
+	TranscodeVideo bool
+	TranscodeAudio bool
+	// HEVC marks copied HEVC video, which is tagged hvc1 so that Apple
+	// players recognize it in MP4.
+	HEVC bool
+}
+
+// ToMP4Command rewraps a QuickTime, Matroska or WebM input as MP4, copying
+// streams unless opts says otherwise. Only the first video stream and the
+// audio streams are kept; timecode and other data tracks that screen
+// recorders add, and Matroska's subtitles and attachments, have no MP4
+// equivalent.
+func ToMP4Command(input, output string, opts RemuxOptions) *Cmd {
+	cmd := FFmpeg().
+		Input(input).
+		Flag("-map", "0:v:0").
+		Flag("-map", "0:a?")
+	if opts.TranscodeVideo {
+		cmd.Flag("-c:v", "libx264").
+			Flag("-crf", "20").
+			Flag("-preset", "veryfast").
+			Flag("-pix_fmt", "yuv420p")
+	} else {
+		cmd.Flag("-c:v", "copy")
+		if opts.HEVC {
+			cmd.Flag("-tag:v", "hvc1")
+		}
+	}
+	if opts.TranscodeAudio {
+		cmd.Flag("-c:a", "aac").Flag("-b:a", "192k")
+	} else {
+		cmd.Flag("-c:a", "copy")
+	}
+	return cmd.MP4Output(output)
+}
@@ -0,0 +1,79 @@
+package ffmpeg
+
+import "strconv"
+
+// ShortsProfileName selects the shorts pipeline instead of a transcode
+// profile. Portrait uploads within the shorts duration cap use it by default.
+const ShortsProfileName = "shorts"
+
+// Rung is one 9:16 rendition of the shorts ladder.
+type Rung struct {
+	Name   string `json:"name"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// ShortsLadder lists the portrait renditions produced for shorts, largest
+// first.
+var ShortsLadder = []Rung{
+	{Name: "1080p", Width: 1080, Height: 1920},
+	{Name: "720p", Width: 720, Height: 1280},
+	{Name: "480p", Width: 480, Height: 854},
+}
+
+// LadderFor returns the rungs that don't upscale a source of the given
+// height. The smallest rung is always included.
+func LadderFor(sourceHeight int) []Rung {
+	var rungs []Rung
+	for _, r := range ShortsLadder {
+		if r.Height <= sourceHeight {
+			rungs = append(rungs, r)
+		}
+	}
+	if len(rungs) == 0 {
+		rungs = ShortsLadder[len(ShortsLadder)-1:]
+	}
+	return rungs
+}
+
+// PortraitCommand encodes input as a 9:16 MP4 at the rung's resolution,
+// keeping every audio stream.
+func PortraitCommand(input, output string, rung Rung) *Cmd {
+	return FFmpeg().
+		Input(input).
+		Flag("-map", "0:v:0").
+		Flag("-map", "0:a?").
+		Filters("-vf",
+			NewFilter("scale").Option("w", strconv.Itoa(rung.Width)).Option("h", strconv.Itoa(rung.Height)),
+			NewFilter("setsar").Option("sar", "1"),
+		).
+		Flag("-c:v", "libx264").
+		Flag("-preset", "veryfast").
+		Flag("-crf", "23").
+		Flag("-c:a", "aac").
+		Flag("-b:a", "128k").
+		MP4Output(output)
+}
+
+// LoopPreviewCommand cuts a short, silent, low-resolution clip from the start
+// of input for feeds that autoplay shorts on a loop. The clip fades out over
+// its last half second so the jump back to the start is less abrupt.
+func LoopPreviewCommand(input, output string, seconds float64) *Cmd {
+	fadeStart := seconds - 0.5
+	if fadeStart < 0 {
+		fadeStart = 0
+	}
+	return FFmpeg().
+		Seconds("-t", seconds).
+		Input(input).
+		Flag("-map", "0:v:0").
+		Filters("-vf",
+			NewFilter("scale").Option("w", "360").Option("h", "640"),
+			NewFilter("fade").Option("t", "out").Option("st", strconv.FormatFloat(fadeStart, 'f', 3, 64)).Option("d", "0.5"),
+		).
+		Flag("-an").
+		Flag("-c:v", "libx264").
+		Flag("-preset", "veryfast").
+		Flag("-crf", "28").
+		MP4Output(output)
+}
@@ -0,0 +1,88 @@
+package ffmpeg
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Image formats thumbnail variants can be encoded in.
+const (
+	ImageJPEG = "jpeg"
+	ImageWebP = "webp"
+)
+
+// ImageExtensions maps each supported image format to its file extension.
+var ImageExtensions = map[string]string{
+	ImageJPEG: ".jpg",
+	ImageWebP: ".webp",
+}
+
+// Ways ResizeCommand can fit an image into a box.
+const (
+	FitCover   = "cover"
+	FitContain = "contain"
+)
+
+// ResizeCommand scales the image at input into a width by height box and
+// encodes it to output in format. FitCover fills the box and crops the
+// overflow around the center; FitContain fits the whole image inside it. If
+// width or height is 0 it follows from the aspect ratio and fit is ignored.
+func ResizeCommand(input, output string, width, height int, fit, format string) *Cmd {
+	w, h := strconv.Itoa(width), strconv.Itoa(height)
+	var filters []Filter
+	switch {
+	case width == 0 || height == 0:
+		if width == 0 {
+			w = "-2"
+		}
+		if height == 0 {
+			h = "-2"
+		}
+		filters = append(filters, NewFilter("scale").Option("w", w).Option("h", h))
+	case fit == FitCover:
+		filters = append(filters,
+			NewFilter("scale").Option("w", w).Option("h", h).Option("force_original_aspect_ratio", "increase"),
+			NewFilter("crop").Option("w", w).Option("h", h),
+		)
+	case fit == FitContain:
+		filters = append(filters,
+			NewFilter("scale").Option("w", w).Option("h", h).Option("force_original_aspect_ratio", "decrease"),
+		)
+	default:
+		return FFmpeg().fail(fmt.Errorf("unsupported fit %q", fit))
+	}
+	return imageOutput(FFmpeg().Input(input).Filters("-vf", filters...), output, format)
+}
+
+// ThumbnailCommand resizes the image at input to the given width, keeping
+// its aspect ratio, and encodes it to output in format. Images narrower than
+// width keep their size rather than being upscaled.
+func ThumbnailCommand(input, output string, width int, format string) *Cmd {
+	cmd := FFmpeg().
+		Input(input).
+		Filters("-vf",
+			NewFilter("scale").Option("w", fmt.Sprintf("min(%d,iw)", width)).Option("h", "-2"),
+		)
+	return imageOutput(cmd, output, format)
+}
+
+// ConvertImageCommand re-encodes the image at input, such as a HEIC photo,
+// to output in format at its original size. HEIC images from phones are
+// stored as a grid of tiles; ffmpeg 7.1 or newer is needed to decode the
+// whole grid rather than only its first tile.
+func ConvertImageCommand(input, output string, format string) *Cmd {
+	return imageOutput(FFmpeg().Input(input), output, format)
+}
+
+func imageOutput(cmd *Cmd, output, format string) *Cmd {
+	cmd = cmd.Flag("-frames:v", "1")
+	switch format {
+	case ImageJPEG:
+		cmd = cmd.Flag("-c:v", "mjpeg").Flag("-q:v", "3").Flag("-f", "image2")
+	case ImageWebP:
+		cmd = cmd.Flag("-c:v", "libwebp").Flag("-quality", "80").Flag("-f", "webp")
+	default:
+		return cmd.fail(fmt.Errorf("unsupported image format %q", format))
+	}
+	return cmd.Output(output)
+}
@@ -0,0 +1,33 @@
+package ffmpeg
+
+// ToneMapCommand writes an 8-bit BT.709 SDR copy of input to output. When
+// hdr is set the source is linearised and tone-mapped with the hable curve
+// first; otherwise the video is only converted down to 8-bit 4:2:0, which is
+// all a 10-bit SDR source needs to play on older hardware decoders.
+func ToneMapCommand(input, output string, hdr bool) *Cmd {
+	filters := []Filter{}
+	if hdr {
+		filters = append(filters,
+			NewFilter("zscale").Option("t", "linear").Option("npl", "100"),
+			NewFilter("format").Option("pix_fmts", "gbrpf32le"),
+			NewFilter("zscale").Option("p", "bt709"),
+			NewFilter("tonemap").Option("tonemap", "hable").Option("desat", "0"),
+			NewFilter("zscale").Option("t", "bt709").Option("m", "bt709").Option("r", "tv"),
+		)
+	}
+	filters = append(filters, NewFilter("format").Option("pix_fmts", "yuv420p"))
+
+	return FFmpeg().
+		Input(input).
+		Flag("-map", "0:v:0").
+		Flag("-map", "0:a?").
+		Filters("-vf", filters...).
+		Flag("-c:v", "libx264").
+		Flag("-preset", "veryfast").
+		Flag("-crf", "21").
+		Flag("-color_primaries", "bt709").
+		Flag("-color_trc", "bt709").
+		Flag("-colorspace", "bt709").
+		Flag("-c:a", "copy").
+		MP4Output(output)
+}
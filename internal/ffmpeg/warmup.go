@@ -0,0 +1,28 @@
+package ffmpeg
+
+import (
+	"context"
+	"fmt"
+)
+
+// Warmup checks that ffprobe and ffmpeg run, and has ffmpeg encode half a
+// second of generated video and audio with the codecs uploads use, so the
+// binaries and their libraries are loaded and paged in before the first
+// real job needs them.
+func Warmup(ctx context.Context) error {
+	if _, err := FFprobe().Flag("-version").Run(ctx); err != nil {
+		return err
+	}
+	cmd := FFmpeg().
+		Flag("-f", "lavfi").Input("testsrc2=duration=0.5:size=320x180:rate=30").
+		Flag("-f", "lavfi").Input("sine=duration=0.5").
+		Flag("-c:v", "libx264").
+		Flag("-preset", "veryfast").
+		Flag("-c:a", "aac").
+		Flag("-f", "null").
+		PipeOutput()
+	if _, err := cmd.Run(ctx); err != nil {
+		return fmt.Errorf("test encode: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+package ffmpeg
+
+// WatermarkCommand burns text into the bottom-right corner of the video at
+// input. text may contain arbitrary user data such as an email address; it
+// is escaped and drawtext expansion is disabled.
+func WatermarkCommand(input, output, text string) *Cmd {
+	overlay := NewFilter("drawtext").
+		Option("text", text).
+		Option("expansion", "none").
+		Option("fontsize", "h/30").
+		Option("fontcolor", "white@0.35").
+		Option("x", "w-tw-20").
+		Option("y", "h-th-20")
+	return FFmpeg().
+		Input(input).
+		Filters("-vf", overlay).
+		Flag("-c:v", "libx264").
+		Flag("-preset", "veryfast").
+		Flag("-crf", "23").
+		Flag("-c:a", "copy").
+		MP4Output(output)
+}
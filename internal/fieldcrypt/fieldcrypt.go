@@ -0,0 +1,149 @@
+// Package fieldcrypt encrypts database fields that hold personal data or
+// secrets, such as emails and webhook secrets, with AES-256-GCM, and keys
+// the hashes that let encrypted fields still be looked up by value.
+//
+// An encrypted field is stored as enc:v1:<key ID>:<nonce and ciphertext,
+// base64>, so a keyring holding old keys as well as the current one reads
+// everything while fields are re-encrypted with the current one.
+package fieldcrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const prefix = "enc:v1:"
+
+// ErrNoKeys is returned for encrypted fields read without a keyring.
+var ErrNoKeys = errors.New("field is encrypted but no encryption keys are set")
+
+var keyIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+// Keyring encrypts fields with its primary key and decrypts them with any
+// of its keys. A nil Keyring encrypts nothing: fields are stored as they
+// are.
+type Keyring struct {
+	primary string
+	keys    map[string]cipher.AEAD
+	macKey  []byte
+}
+
+// New returns a keyring that encrypts with the key primary and decrypts
+// with any of keys, which must be 32 bytes each. macKey keys Index and
+// isn't rotated with the others.
+func New(primary string, keys map[string][]byte, macKey []byte) (*Keyring, error) {
+	if _, ok := keys[primary]; !ok {
+		return nil, fmt.Errorf("primary key %q isn't one of the keys", primary)
+	}
+	if len(macKey) < 32 {
+		return nil, errors.New("index key must be at least 32 bytes")
+	}
+	k := &Keyring{primary: primary, keys: make(map[string]cipher.AEAD, len(keys)), macKey: macKey}
+	for id, key := range keys {
+		if !keyIDPattern.MatchString(id) {
+			return nil, fmt.Errorf("invalid key ID %q", id)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("key %s must be 32 bytes", id)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		gcm, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		k.keys[id] = gcm
+	}
+	return k, nil
+}
+
+// Primary returns the ID of the key fields are encrypted with, or "" for
+// a nil keyring.
+func (k *Keyring) Primary() string {
+	if k == nil {
+		return ""
+	}
+	return k.primary
+}
+
+// Encrypt encrypts the value of field, such as users.email, which is
+// authenticated with it so a value can't be passed off as another field's.
+// Empty values are stored empty.
+func (k *Keyring) Encrypt(field, value string) (string, error) {
+	if k == nil || value == "" {
+		return value, nil
+	}
+	gcm := k.keys[k.primary]
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(value), []byte(field))
+	return prefix + k.primary + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt returns the value of field that stored was made from. Values
+// that aren't encrypted, such as those written before encryption was
+// turned on, are returned as they are.
+func (k *Keyring) Decrypt(field, stored string) (string, error) {
+	id := KeyID(stored)
+	if id == "" {
+		return stored, nil
+	}
+	if k == nil {
+		return "", ErrNoKeys
+	}
+	gcm, ok := k.keys[id]
+	if !ok {
+		return "", fmt.Errorf("%s is encrypted with key %q, which isn't set", field, id)
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(stored[len(prefix)+len(id)+1:])
+	if err != nil || len(sealed) < gcm.NonceSize() {
+		return "", fmt.Errorf("%s is malformed", field)
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	value, err := gcm.Open(nil, nonce, ciphertext, []byte(field))
+	if err != nil {
+		return "", fmt.Errorf("%s can't be decrypted with key %q", field, id)
+	}
+	return string(value), nil
+}
+
+// KeyID returns the ID of the key stored is encrypted with, or "" if it
+// isn't encrypted.
+func KeyID(stored string) string {
+	rest, ok := strings.CutPrefix(stored, prefix)
+	if !ok {
+		return ""
+	}
+	id, _, ok := strings.Cut(rest, ":")
+	if !ok {
+		return ""
+	}
+	return id
+}
+
+// Index returns a keyed hash of the value of field, hex-encoded, for
+// finding encrypted fields by value and for storing secrets, such as API
+// keys, with a pepper. It returns "" for a nil keyring or an empty value.
+func (k *Keyring) Index(field, value string) string {
+	if k == nil || value == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, k.macKey)
+	mac.Write([]byte(field))
+	mac.Write([]byte{0})
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
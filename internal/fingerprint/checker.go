@@ -0,0 +1,125 @@
+package fingerprint
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+)
+
+// DefaultThreshold is the similarity above which audio is considered a
+// match. Re-encoded copies of the same recording typically score above
+// 0.75; unrelated audio stays close to 0.5.
+const DefaultThreshold = 0.65
+
+// Match is a registered reference found in an upload.
+type Match struct {
+	ReferenceID   string  `json:"reference_id"`
+	Title         string  `json:"title"`
+	Score         float64 `json:"score"`
+	OffsetSeconds float64 `json:"offset_seconds"`
+}
+
+// Checker checks a media file against a catalog of reference works.
+type Checker interface {
+	Check(ctx context.Context, path string) ([]Match, error)
+}
+
+// Reference is a registered work to check uploads against.
+type Reference struct {
+	ID          string
+	Title       string
+	Fingerprint Fingerprint
+}
+
+// Catalog checks files locally against the references it loads on each
+// check, so newly registered references apply right away.
+type Catalog struct {
+	References func(ctx context.Context) ([]Reference, error)
+	Threshold  float64
+}
+
+func (c Catalog) Check(ctx context.Context, path string) ([]Match, error) {
+	refs, err := c.References(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(refs) == 0 {
+		return nil, nil
+	}
+	query, err := Compute(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := []Match{}
+	for _, ref := range refs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		score, offset, ok := Compare(query, ref.Fingerprint)
+		if !ok || score < c.Threshold {
+			continue
+		}
+		matches = append(matches, Match{
+			ReferenceID:   ref.ID,
+			Title:         ref.Title,
+			Score:         score,
+			OffsetSeconds: float64(offset) * FrameSeconds,
+		})
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].Score > matches[j].Score })
+	return matches, nil
+}
+
+// API delegates checks to a third-party service. The file is POSTed to URL
+// as the request body, and the service responds with
+// {"matches": [Match, ...]}.
+type API struct {
+	URL    string
+	Token  string
+	Client *http.Client
+}
+
+func (a API) Check(ctx context.Context, path string) ([]Match, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.URL, f)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if a.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+a.Token)
+	}
+
+	client := a.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Minute}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("fingerprint API responded %s: %s", resp.Status, body)
+	}
+
+	var result struct {
+		Matches []Match `json:"matches"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("couldn't decode fingerprint API response: %w", err)
+	}
+	return result.Matches, nil
+}
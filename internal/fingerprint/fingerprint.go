@@ -0,0 +1,199 @@
+package fingerprint
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"math/bits"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ffmpeg"
+)
+
+// Audio is fingerprinted in overlapping frames. Each frame's spectrum is
+// split into bands between bandLow and bandHigh, and each bit of the frame's
+// sub-fingerprint records whether the energy difference between two adjacent
+// bands grew or shrank since the previous frame. That survives re-encoding,
+// resampling and volume changes, which is what matching uploads against
+// reference recordings needs.
+const (
+	sampleRate = 5512
+	frameSize  = 2048
+	hopSize    = 256
+	bandLow    = 300.0
+	bandHigh   = 2000.0
+	numBands   = 33
+)
+
+// FrameSeconds is the time between consecutive sub-fingerprints.
+const FrameSeconds = float64(hopSize) / sampleRate
+
+// minOverlap is the fewest frames, about five seconds, two fingerprints
+// must overlap by to be compared.
+const minOverlap = 108
+
+// Fingerprint is a sequence of 32-bit sub-fingerprints, one per frame.
+type Fingerprint []uint32
+
+// Compute fingerprints the first audio stream of the file at path.
+func Compute(ctx context.Context, path string) (Fingerprint, error) {
+	var fp Fingerprint
+	err := ffmpeg.StreamPCM(ctx, path, sampleRate, func(r io.Reader) error {
+		var err error
+		fp, err = compute(r)
+		return err
+	})
+	return fp, err
+}
+
+func compute(r io.Reader) (Fingerprint, error) {
+	window := make([]float64, frameSize)
+	for i := range window {
+		window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(frameSize-1))
+	}
+	edges := bandEdges()
+
+	fp := Fingerprint{}
+	samples := make([]float64, 0, frameSize)
+	re := make([]float64, frameSize)
+	im := make([]float64, frameSize)
+	var prev []float64
+	buf := make([]byte, 2*hopSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		for i := 0; i+1 < n; i += 2 {
+			samples = append(samples, float64(int16(binary.LittleEndian.Uint16(buf[i:]))))
+		}
+		for len(samples) >= frameSize {
+			for i := range re {
+				re[i] = samples[i] * window[i]
+				im[i] = 0
+			}
+			fft(re, im)
+			energies := make([]float64, numBands)
+			for b := range energies {
+				for k := edges[b]; k < edges[b+1]; k++ {
+					energies[b] += re[k]*re[k] + im[k]*im[k]
+				}
+			}
+			if prev != nil {
+				fp = append(fp, subFingerprint(energies, prev))
+			}
+			prev = energies
+			samples = samples[:copy(samples, samples[hopSize:])]
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return fp, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func subFingerprint(cur, prev []float64) uint32 {
+	var sub uint32
+	for m := 0; m < 32; m++ {
+		if (cur[m]-cur[m+1])-(prev[m]-prev[m+1]) > 0 {
+			sub |= 1 << m
+		}
+	}
+	return sub
+}
+
+// bandEdges returns the FFT bin boundaries of numBands logarithmically
+// spaced bands. Each adjacent pair of bands yields one of the 32 bits.
+func bandEdges() []int {
+	edges := make([]int, numBands+1)
+	ratio := math.Pow(bandHigh/bandLow, 1/float64(numBands))
+	for i := range edges {
+		freq := bandLow * math.Pow(ratio, float64(i))
+		edges[i] = int(freq * frameSize / sampleRate)
+	}
+	return edges
+}
+
+// fft is an in-place iterative radix-2 FFT; len(re) must be a power of two.
+func fft(re, im []float64) {
+	n := len(re)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			re[i], re[j] = re[j], re[i]
+			im[i], im[j] = im[j], im[i]
+		}
+	}
+	for size := 2; size <= n; size <<= 1 {
+		angle := -2 * math.Pi / float64(size)
+		wRe, wIm := math.Cos(angle), math.Sin(angle)
+		for start := 0; start < n; start += size {
+			uRe, uIm := 1.0, 0.0
+			for k := 0; k < size/2; k++ {
+				a, b := start+k, start+k+size/2
+				tRe := uRe*re[b] - uIm*im[b]
+				tIm := uRe*im[b] + uIm*re[b]
+				re[b], im[b] = re[a]-tRe, im[a]-tIm
+				re[a], im[a] = re[a]+tRe, im[a]+tIm
+				uRe, uIm = uRe*wRe-uIm*wIm, uRe*wIm+uIm*wRe
+			}
+		}
+	}
+}
+
+// Compare slides reference along query and returns the best similarity
+// found, from 0.5 for unrelated audio to 1 for identical audio, and the
+// frame offset into query where it was found. Frames of digital silence in
+// the reference, which fingerprint as zero, are skipped so that silence
+// doesn't match silence. ok is false if the two are too short to compare.
+func Compare(query, reference Fingerprint) (score float64, offset int, ok bool) {
+	best := -1.0
+	for off := -(len(reference) - minOverlap); off <= len(query)-minOverlap; off++ {
+		qStart, rStart := max(off, 0), max(-off, 0)
+		overlap := min(len(query)-qStart, len(reference)-rStart)
+		compared, errorBits := 0, 0
+		for i := 0; i < overlap; i++ {
+			if reference[rStart+i] == 0 {
+				continue
+			}
+			compared++
+			errorBits += bits.OnesCount32(query[qStart+i] ^ reference[rStart+i])
+		}
+		if compared < minOverlap {
+			continue
+		}
+		s := 1 - float64(errorBits)/float64(32*compared)
+		if s > best {
+			best, offset = s, off
+		}
+	}
+	if best < 0 {
+		return 0, 0, false
+	}
+	return best, offset, true
+}
+
+// Bytes encodes f for storage.
+func (f Fingerprint) Bytes() []byte {
+	b := make([]byte, 4*len(f))
+	for i, sub := range f {
+		binary.LittleEndian.PutUint32(b[4*i:], sub)
+	}
+	return b
+}
+
+// FromBytes decodes a fingerprint encoded by Bytes.
+func FromBytes(b []byte) (Fingerprint, error) {
+	if len(b)%4 != 0 {
+		return nil, errors.New("fingerprint length is not a multiple of 4")
+	}
+	f := make(Fingerprint, len(b)/4)
+	for i := range f {
+		f[i] = binary.LittleEndian.Uint32(b[4*i:])
+	}
+	return f, nil
+}
@@ -0,0 +1,158 @@
+package flags
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+type Source string
+
+const (
+	SourceConfig   Source = "config"
+	SourceOverride Source = "override"
+)
+
+// Flag describes who a feature is enabled for. A subject gets the feature if
+// the flag is enabled globally, if they are explicitly targeted, or if they
+// fall inside the rollout percentage.
+type Flag struct {
+	Name    string      `json:"name"`
+	Enabled bool        `json:"enabled"`
+	Users   []uuid.UUID `json:"users,omitempty"`
+	Tenants []string    `json:"tenants,omitempty"`
+	Percent int         `json:"percent,omitempty"`
+	Source  Source      `json:"source"`
+}
+
+// Subject is who a flag is being evaluated for.
+type Subject struct {
+	UserID uuid.UUID
+	Tenant string
+}
+
+func (f Flag) Validate() error {
+	if f.Name == "" {
+		return fmt.Errorf("flag name is required")
+	}
+	if f.Percent < 0 || f.Percent > 100 {
+		return fmt.Errorf("flag %q: percent must be between 0 and 100", f.Name)
+	}
+	return nil
+}
+
+func (f Flag) enabledFor(s Subject) bool {
+	if f.Enabled {
+		return true
+	}
+	for _, id := range f.Users {
+		if id == s.UserID {
+			return true
+		}
+	}
+	for _, tenant := range f.Tenants {
+		if s.Tenant != "" && tenant == s.Tenant {
+			return true
+		}
+	}
+	if f.Percent > 0 && s.UserID != uuid.Nil {
+		h := fnv.New32a()
+		h.Write([]byte(f.Name))
+		h.Write(s.UserID[:])
+		return int(h.Sum32()%100) < f.Percent
+	}
+	return false
+}
+
+// Set holds flags from the config file, with overrides layered on top.
+type Set struct {
+	mu        sync.RWMutex
+	config    map[string]Flag
+	overrides map[string]Flag
+}
+
+func NewSet(config []Flag) (*Set, error) {
+	s := &Set{
+		config:    map[string]Flag{},
+		overrides: map[string]Flag{},
+	}
+	for _, f := range config {
+		if err := f.Validate(); err != nil {
+			return nil, err
+		}
+		f.Source = SourceConfig
+		s.config[f.Name] = f
+	}
+	return s, nil
+}
+
+// LoadFile reads a JSON array of flags from path.
+func LoadFile(path string) ([]Flag, error) {
+	dat, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config []Flag
+	if err := json.Unmarshal(dat, &config); err != nil {
+		return nil, fmt.Errorf("couldn't parse flags file: %w", err)
+	}
+	return config, nil
+}
+
+// SetOverrides replaces all overrides, e.g. after loading them from the
+// database.
+func (s *Set) SetOverrides(overrides []Flag) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.overrides = map[string]Flag{}
+	for _, f := range overrides {
+		f.Source = SourceOverride
+		s.overrides[f.Name] = f
+	}
+}
+
+// Enabled reports whether the named feature is on for subject. Unknown flags
+// are off.
+func (s *Set) Enabled(name string, subject Subject) bool {
+	f, ok := s.Get(name)
+	if !ok {
+		return false
+	}
+	return f.enabledFor(subject)
+}
+
+// Get returns the effective definition of the named flag.
+func (s *Set) Get(name string) (Flag, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if f, ok := s.overrides[name]; ok {
+		return f, true
+	}
+	f, ok := s.config[name]
+	return f, ok
+}
+
+// All returns the effective definition of every known flag, sorted by name.
+func (s *Set) All() []Flag {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	all := []Flag{}
+	for name, f := range s.config {
+		if o, ok := s.overrides[name]; ok {
+			f = o
+		}
+		all = append(all, f)
+	}
+	for name, f := range s.overrides {
+		if _, ok := s.config[name]; !ok {
+			all = append(all, f)
+		}
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Name < all[j].Name })
+	return all
+}
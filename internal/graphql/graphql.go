@@ -0,0 +1,532 @@
+// Package graphql executes read-only GraphQL queries against a schema
+// defined in Go. It covers what the catalog API needs: queries with
+// variables, aliases, arguments, fragments and the @include and @skip
+// directives. There is no introspection beyond __typename.
+//
+// Resolvers may return a Thunk instead of a value to defer their work. The
+// executor resolves every field it can reach before running any thunk, so a
+// Loader can batch the lookups made by all the fields at one depth of the
+// query.
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+)
+
+// Schema is the root of the types a query can select from.
+type Schema struct {
+	Query *Object
+	// MaxDepth limits how deeply selections can be nested. Zero means 10.
+	MaxDepth int
+}
+
+// Object is an object type. Its fields are resolved by Go functions.
+type Object struct {
+	Name   string
+	Fields map[string]*Field
+}
+
+// Field is a field of an object.
+type Field struct {
+	// Type is the object the field returns, either alone or in a slice, or
+	// nil for scalars and lists of scalars. Scalar values are returned as
+	// their JSON encoding.
+	Type *Object
+	// Args are the arguments the field accepts, with their defaults; a nil
+	// default means there is none.
+	Args map[string]any
+	// Resolve returns the field's value. A nil Resolve returns the Go
+	// struct field or map entry of the source tagged with the field's name.
+	Resolve func(p Params) (any, error)
+}
+
+// Params are what a resolver is called with.
+type Params struct {
+	Context context.Context
+	// Source is the value of the object the field belongs to, nil at the
+	// root.
+	Source any
+	Args   Args
+}
+
+// Args are a field's arguments, with defaults filled in.
+type Args map[string]any
+
+// Int returns an integer argument. A missing or null argument is 0.
+func (a Args) Int(name string) (int, error) {
+	switch v := a[name].(type) {
+	case nil:
+		return 0, nil
+	case int:
+		return v, nil
+	case float64:
+		// Variables are decoded from JSON, where every number is a float.
+		if v == float64(int(v)) {
+			return int(v), nil
+		}
+	}
+	return 0, fmt.Errorf("argument %q must be an integer", name)
+}
+
+// String returns a string or ID argument. A missing or null argument is "".
+func (a Args) String(name string) (string, error) {
+	switch v := a[name].(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	}
+	return "", fmt.Errorf("argument %q must be a string", name)
+}
+
+// Bool returns a boolean argument, and whether it was given.
+func (a Args) Bool(name string) (value, ok bool, err error) {
+	switch v := a[name].(type) {
+	case nil:
+		return false, false, nil
+	case bool:
+		return v, true, nil
+	}
+	return false, false, fmt.Errorf("argument %q must be a boolean", name)
+}
+
+// Thunk is a deferred field value.
+type Thunk func() (any, error)
+
+// Request is a GraphQL request, as decoded from the JSON body of a POST.
+type Request struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName"`
+	Variables     map[string]any `json:"variables"`
+}
+
+// Response is the result of a request. Data is absent when the request
+// couldn't be executed at all.
+type Response struct {
+	Data   *Result  `json:"data,omitempty"`
+	Errors []*Error `json:"errors,omitempty"`
+}
+
+// Error is a request or field error. Path is set for field errors.
+type Error struct {
+	Message string `json:"message"`
+	Path    []any  `json:"path,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Result is a selected object, which keeps its fields in query order.
+type Result struct {
+	keys   []string
+	values map[string]any
+}
+
+func newResult() *Result {
+	return &Result{values: map[string]any{}}
+}
+
+func (r *Result) set(key string, value any) {
+	if _, ok := r.values[key]; !ok {
+		r.keys = append(r.keys, key)
+	}
+	r.values[key] = value
+}
+
+// Get returns the value of a field, by its response key.
+func (r *Result) Get(key string) any {
+	return r.values[key]
+}
+
+func (r *Result) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range r.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, _ := json.Marshal(key)
+		buf.Write(k)
+		buf.WriteByte(':')
+		v, err := json.Marshal(r.values[key])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// Execute runs a query.
+func (s *Schema) Execute(ctx context.Context, req Request) Response {
+	doc, err := parse(req.Query)
+	if err != nil {
+		return errorResponse(err)
+	}
+	op, err := selectOperation(doc, req.OperationName)
+	if err != nil {
+		return errorResponse(err)
+	}
+	vars, err := coerceVariables(op, req.Variables)
+	if err != nil {
+		return errorResponse(err)
+	}
+	maxDepth := s.MaxDepth
+	if maxDepth == 0 {
+		maxDepth = 10
+	}
+	v := &validator{doc: doc, op: op, maxDepth: maxDepth}
+	v.selections(s.Query, op.selections, 1)
+	if len(v.errors) > 0 {
+		return Response{Errors: v.errors}
+	}
+
+	e := &executor{ctx: ctx, doc: doc, vars: vars}
+	data := e.selectionSet(s.Query, nil, []*field{{selections: op.selections}}, nil)
+	for len(e.pending) > 0 {
+		pending := e.pending
+		e.pending = nil
+		for _, run := range pending {
+			run()
+		}
+	}
+	return Response{Data: data, Errors: e.errors}
+}
+
+func errorResponse(err error) Response {
+	return Response{Errors: []*Error{{Message: err.Error()}}}
+}
+
+func selectOperation(doc *document, name string) (*operation, error) {
+	var op *operation
+	switch {
+	case name == "" && len(doc.operations) > 1:
+		return nil, errors.New("operationName is required when the document has several operations")
+	case name == "":
+		op = doc.operations[0]
+	default:
+		for _, o := range doc.operations {
+			if o.name == name {
+				op = o
+			}
+		}
+		if op == nil {
+			return nil, fmt.Errorf("unknown operation %q", name)
+		}
+	}
+	if op.kind != "query" {
+		return nil, fmt.Errorf("only queries are supported, not %ss", op.kind)
+	}
+	return op, nil
+}
+
+func coerceVariables(op *operation, given map[string]any) (map[string]any, error) {
+	vars := map[string]any{}
+	for _, def := range op.variables {
+		value, ok := given[def.name]
+		if !ok && def.hasDefault {
+			value, ok = def.defaultVal, true
+		}
+		if def.nonNull && value == nil {
+			return nil, fmt.Errorf("variable $%s is required", def.name)
+		}
+		if ok {
+			vars[def.name] = value
+		}
+	}
+	return vars, nil
+}
+
+// resolveValue replaces the variables in a value from the document.
+func resolveValue(value any, vars map[string]any) any {
+	switch v := value.(type) {
+	case variableRef:
+		return vars[string(v)]
+	case enumValue:
+		return string(v)
+	case []any:
+		list := make([]any, len(v))
+		for i, item := range v {
+			list[i] = resolveValue(item, vars)
+		}
+		return list
+	case map[string]any:
+		obj := make(map[string]any, len(v))
+		for k, item := range v {
+			obj[k] = resolveValue(item, vars)
+		}
+		return obj
+	}
+	return value
+}
+
+// included applies @include and @skip.
+func included(directives []directive, vars map[string]any) bool {
+	for _, d := range directives {
+		for _, arg := range d.args {
+			if arg.name != "if" {
+				continue
+			}
+			cond, _ := resolveValue(arg.value, vars).(bool)
+			if d.name == "include" && !cond || d.name == "skip" && cond {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// validator checks a query against the schema before anything runs, so a
+// bad query fails as a whole.
+type validator struct {
+	doc      *document
+	op       *operation
+	maxDepth int
+	errors   []*Error
+	visiting []string
+}
+
+func (v *validator) fail(format string, args ...any) {
+	v.errors = append(v.errors, &Error{Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) selections(obj *Object, selections []selection, depth int) {
+	if depth > v.maxDepth {
+		v.fail("query is nested more than %d levels deep", v.maxDepth)
+		return
+	}
+	for _, sel := range selections {
+		switch sel := sel.(type) {
+		case *field:
+			v.field(obj, sel, depth)
+		case *inlineFragment:
+			if sel.typeCondition != "" && sel.typeCondition != obj.Name {
+				v.fail("fragment on %s can't be spread in %s", sel.typeCondition, obj.Name)
+				continue
+			}
+			v.selections(obj, sel.selections, depth)
+		case *fragmentSpread:
+			f, ok := v.doc.fragments[sel.name]
+			if !ok {
+				v.fail("unknown fragment %q", sel.name)
+				continue
+			}
+			if slices.Contains(v.visiting, sel.name) {
+				v.fail("fragment %q spreads itself", sel.name)
+				continue
+			}
+			if f.typeCondition != obj.Name {
+				v.fail("fragment %q on %s can't be spread in %s", sel.name, f.typeCondition, obj.Name)
+				continue
+			}
+			v.visiting = append(v.visiting, sel.name)
+			v.selections(obj, f.selections, depth)
+			v.visiting = v.visiting[:len(v.visiting)-1]
+		}
+	}
+}
+
+func (v *validator) field(obj *Object, f *field, depth int) {
+	if f.name == "__typename" {
+		if f.selections != nil {
+			v.fail("field __typename can't have a selection")
+		}
+		return
+	}
+	def, ok := obj.Fields[f.name]
+	if !ok {
+		v.fail("cannot query field %q on type %s", f.name, obj.Name)
+		return
+	}
+	for _, arg := range f.args {
+		if _, ok := def.Args[arg.name]; !ok {
+			v.fail("unknown argument %q on field %s.%s", arg.name, obj.Name, f.name)
+		}
+		if ref, ok := arg.value.(variableRef); ok && !v.declared(string(ref)) {
+			v.fail("variable $%s is not defined", ref)
+		}
+	}
+	switch {
+	case def.Type == nil && f.selections != nil:
+		v.fail("field %s.%s is a scalar and can't have a selection", obj.Name, f.name)
+	case def.Type != nil && f.selections == nil:
+		v.fail("field %s.%s of type %s must have a selection", obj.Name, f.name, def.Type.Name)
+	case def.Type != nil:
+		v.selections(def.Type, f.selections, depth+1)
+	}
+}
+
+// declared reports whether the operation defines a variable.
+func (v *validator) declared(name string) bool {
+	for _, def := range v.op.variables {
+		if def.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+type executor struct {
+	ctx     context.Context
+	doc     *document
+	vars    map[string]any
+	pending []func()
+	errors  []*Error
+}
+
+// collectFields groups the fields selected on obj by response key, in
+// query order, expanding fragments.
+func (e *executor) collectFields(selections []selection, keys *[]string, fields map[string][]*field) {
+	for _, sel := range selections {
+		switch sel := sel.(type) {
+		case *field:
+			if !included(sel.directives, e.vars) {
+				continue
+			}
+			key := sel.responseKey()
+			if _, ok := fields[key]; !ok {
+				*keys = append(*keys, key)
+			}
+			fields[key] = append(fields[key], sel)
+		case *inlineFragment:
+			if included(sel.directives, e.vars) {
+				e.collectFields(sel.selections, keys, fields)
+			}
+		case *fragmentSpread:
+			if included(sel.directives, e.vars) {
+				e.collectFields(e.doc.fragments[sel.name].selections, keys, fields)
+			}
+		}
+	}
+}
+
+// selectionSet resolves the merged selections of nodes on source.
+func (e *executor) selectionSet(obj *Object, source any, nodes []*field, path []any) *Result {
+	keys := []string{}
+	fields := map[string][]*field{}
+	for _, node := range nodes {
+		e.collectFields(node.selections, &keys, fields)
+	}
+
+	result := newResult()
+	for _, key := range keys {
+		result.set(key, nil)
+		fieldPath := append(slices.Clip(path), key)
+		e.resolveField(obj, source, fields[key], fieldPath, func(v any) { result.set(key, v) })
+	}
+	return result
+}
+
+func (e *executor) resolveField(obj *Object, source any, nodes []*field, path []any, set func(any)) {
+	f := nodes[0]
+	if f.name == "__typename" {
+		set(obj.Name)
+		return
+	}
+	def := obj.Fields[f.name]
+
+	args := Args{}
+	for name, defaultValue := range def.Args {
+		if defaultValue != nil {
+			args[name] = defaultValue
+		}
+	}
+	for _, arg := range f.args {
+		args[arg.name] = resolveValue(arg.value, e.vars)
+	}
+
+	var value any
+	var err error
+	if def.Resolve != nil {
+		value, err = def.Resolve(Params{Context: e.ctx, Source: source, Args: args})
+	} else {
+		value, err = sourceField(source, f.name)
+	}
+	e.complete(def.Type, nodes, value, err, path, set)
+}
+
+func (e *executor) complete(typ *Object, nodes []*field, value any, err error, path []any, set func(any)) {
+	if err != nil {
+		e.errors = append(e.errors, &Error{Message: err.Error(), Path: path})
+		set(nil)
+		return
+	}
+	if thunk, ok := value.(Thunk); ok {
+		e.pending = append(e.pending, func() {
+			value, err := thunk()
+			e.complete(typ, nodes, value, err, path, set)
+		})
+		return
+	}
+
+	rv := reflect.ValueOf(value)
+	if !rv.IsValid() || (rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Interface) && rv.IsNil() {
+		set(nil)
+		return
+	}
+	if typ == nil {
+		set(value)
+		return
+	}
+	if rv.Kind() == reflect.Slice {
+		items := make([]any, rv.Len())
+		set(items)
+		for i := range items {
+			itemPath := append(slices.Clip(path), i)
+			e.complete(typ, nodes, rv.Index(i).Interface(), nil, itemPath, func(v any) { items[i] = v })
+		}
+		return
+	}
+	set(e.selectionSet(typ, value, nodes, path))
+}
+
+// sourceField returns the struct field tagged json:"name" of source, or the
+// map entry name.
+func sourceField(source any, name string) (any, error) {
+	rv := reflect.ValueOf(source)
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return nil, nil
+		}
+		rv = rv.Elem()
+	}
+	switch rv.Kind() {
+	case reflect.Map:
+		v := rv.MapIndex(reflect.ValueOf(name))
+		if !v.IsValid() {
+			return nil, nil
+		}
+		return v.Interface(), nil
+	case reflect.Struct:
+		if v, ok := structField(rv, name); ok {
+			return v.Interface(), nil
+		}
+	}
+	return nil, fmt.Errorf("field %q has no resolver", name)
+}
+
+func structField(rv reflect.Value, name string) (reflect.Value, bool) {
+	t := rv.Type()
+	for i := range t.NumField() {
+		sf := t.Field(i)
+		tag, _, _ := bytes.Cut([]byte(sf.Tag.Get("json")), []byte(","))
+		if sf.Anonymous && sf.Type.Kind() == reflect.Struct && len(tag) == 0 {
+			if v, ok := structField(rv.Field(i), name); ok {
+				return v, true
+			}
+			continue
+		}
+		if string(tag) == name && sf.IsExported() {
+			return rv.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
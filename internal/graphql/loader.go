@@ -0,0 +1,68 @@
+package graphql
+
+// Loader batches lookups by key. Load queues a key and returns a thunk; the
+// first of those thunks to run fetches every queued key in one call, and the
+// results are cached for the rest of the request. A Loader is meant to live
+// for one request and isn't safe for concurrent use, which the executor
+// doesn't need.
+type Loader[K comparable, V any] struct {
+	fetch  func(keys []K) (map[K]V, error)
+	queued []K
+	loaded map[K]V
+	errs   map[K]error
+}
+
+// NewLoader returns a loader that fetches with fetch. Keys missing from the
+// map it returns load as null.
+func NewLoader[K comparable, V any](fetch func(keys []K) (map[K]V, error)) *Loader[K, V] {
+	return &Loader[K, V]{fetch: fetch, loaded: map[K]V{}, errs: map[K]error{}}
+}
+
+func (l *Loader[K, V]) Load(key K) Thunk {
+	if !l.known(key) {
+		l.queued = append(l.queued, key)
+	}
+	return func() (any, error) {
+		if len(l.queued) > 0 {
+			l.dispatch()
+		}
+		if err, ok := l.errs[key]; ok {
+			return nil, err
+		}
+		if v, ok := l.loaded[key]; ok {
+			return v, nil
+		}
+		return nil, nil
+	}
+}
+
+// known reports whether key has been fetched or is queued.
+func (l *Loader[K, V]) known(key K) bool {
+	if _, ok := l.loaded[key]; ok {
+		return true
+	}
+	if _, ok := l.errs[key]; ok {
+		return true
+	}
+	for _, k := range l.queued {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *Loader[K, V]) dispatch() {
+	keys := l.queued
+	l.queued = nil
+	values, err := l.fetch(keys)
+	for _, key := range keys {
+		if err != nil {
+			l.errs[key] = err
+			continue
+		}
+		if v, ok := values[key]; ok {
+			l.loaded[key] = v
+		}
+	}
+}
@@ -0,0 +1,495 @@
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type document struct {
+	operations []*operation
+	fragments  map[string]*fragment
+}
+
+type operation struct {
+	kind       string
+	name       string
+	variables  []*variableDefinition
+	selections []selection
+}
+
+type variableDefinition struct {
+	name       string
+	nonNull    bool
+	defaultVal any
+	hasDefault bool
+}
+
+// selection is a *field, *fragmentSpread or *inlineFragment.
+type selection interface{}
+
+type field struct {
+	alias      string
+	name       string
+	args       []argument
+	directives []directive
+	selections []selection
+}
+
+// responseKey is the name the field's value is returned under.
+func (f *field) responseKey() string {
+	if f.alias != "" {
+		return f.alias
+	}
+	return f.name
+}
+
+type argument struct {
+	name  string
+	value any
+}
+
+type directive struct {
+	name string
+	args []argument
+}
+
+type fragmentSpread struct {
+	name       string
+	directives []directive
+}
+
+type inlineFragment struct {
+	typeCondition string
+	directives    []directive
+	selections    []selection
+}
+
+type fragment struct {
+	name          string
+	typeCondition string
+	selections    []selection
+}
+
+// Values in the document are parsed to Go values: int, float64, string,
+// bool, nil, []any and map[string]any, plus these for variables and enums.
+type (
+	variableRef string
+	enumValue   string
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenPunct
+	tokenName
+	tokenInt
+	tokenFloat
+	tokenString
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	pos   int
+}
+
+type parser struct {
+	src string
+	pos int
+	tok token
+}
+
+// parse parses a query document.
+func parse(src string) (doc *document, err error) {
+	p := &parser{src: src}
+	defer func() {
+		if r := recover(); r != nil {
+			syntaxErr, ok := r.(syntaxError)
+			if !ok {
+				panic(r)
+			}
+			doc, err = nil, syntaxErr
+		}
+	}()
+	p.next()
+	doc = &document{fragments: map[string]*fragment{}}
+	for p.tok.kind != tokenEOF {
+		switch {
+		case p.peek("{"):
+			doc.operations = append(doc.operations, &operation{kind: "query", selections: p.selectionSet()})
+		case p.peek("fragment"):
+			f := p.fragmentDefinition()
+			if _, ok := doc.fragments[f.name]; ok {
+				p.fail("there can be only one fragment named %q", f.name)
+			}
+			doc.fragments[f.name] = f
+		case p.peek("query"), p.peek("mutation"), p.peek("subscription"):
+			doc.operations = append(doc.operations, p.operationDefinition())
+		default:
+			p.fail("unexpected %s", p.describe())
+		}
+	}
+	if len(doc.operations) == 0 {
+		return nil, syntaxError("document has no operations")
+	}
+	return doc, nil
+}
+
+type syntaxError string
+
+func (e syntaxError) Error() string {
+	return "syntax error: " + string(e)
+}
+
+func (p *parser) fail(format string, args ...any) {
+	line := 1 + strings.Count(p.src[:p.tok.pos], "\n")
+	panic(syntaxError(fmt.Sprintf(format, args...) + fmt.Sprintf(" on line %d", line)))
+}
+
+func (p *parser) describe() string {
+	if p.tok.kind == tokenEOF {
+		return "end of document"
+	}
+	return strconv.Quote(p.tok.value)
+}
+
+// peek reports whether the current token is the punctuator or name s.
+func (p *parser) peek(s string) bool {
+	return (p.tok.kind == tokenPunct || p.tok.kind == tokenName) && p.tok.value == s
+}
+
+func (p *parser) skip(s string) bool {
+	if p.peek(s) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(s string) {
+	if !p.skip(s) {
+		p.fail("expected %q, found %s", s, p.describe())
+	}
+}
+
+func (p *parser) name() string {
+	if p.tok.kind != tokenName {
+		p.fail("expected a name, found %s", p.describe())
+	}
+	name := p.tok.value
+	p.next()
+	return name
+}
+
+func (p *parser) operationDefinition() *operation {
+	op := &operation{kind: p.name()}
+	if p.tok.kind == tokenName {
+		op.name = p.name()
+	}
+	if p.skip("(") {
+		for !p.skip(")") {
+			p.expect("$")
+			v := &variableDefinition{name: p.name()}
+			p.expect(":")
+			v.nonNull = p.typeRef()
+			if p.skip("=") {
+				v.defaultVal, v.hasDefault = p.value(true), true
+			}
+			op.variables = append(op.variables, v)
+		}
+	}
+	p.directives()
+	op.selections = p.selectionSet()
+	return op
+}
+
+// typeRef skips a type such as [Int!]! and reports whether it is non-null.
+func (p *parser) typeRef() bool {
+	if p.skip("[") {
+		p.typeRef()
+		p.expect("]")
+	} else {
+		p.name()
+	}
+	return p.skip("!")
+}
+
+func (p *parser) fragmentDefinition() *fragment {
+	p.expect("fragment")
+	f := &fragment{name: p.name()}
+	if f.name == "on" {
+		p.fail("fragment can't be named \"on\"")
+	}
+	p.expect("on")
+	f.typeCondition = p.name()
+	p.directives()
+	f.selections = p.selectionSet()
+	return f
+}
+
+func (p *parser) selectionSet() []selection {
+	p.expect("{")
+	selections := []selection{}
+	for !p.skip("}") {
+		if p.skip("...") {
+			if p.peek("on") || p.peek("{") || p.peek("@") {
+				f := &inlineFragment{}
+				if p.skip("on") {
+					f.typeCondition = p.name()
+				}
+				f.directives = p.directives()
+				f.selections = p.selectionSet()
+				selections = append(selections, f)
+				continue
+			}
+			s := &fragmentSpread{name: p.name()}
+			s.directives = p.directives()
+			selections = append(selections, s)
+			continue
+		}
+
+		f := &field{name: p.name()}
+		if p.skip(":") {
+			f.alias, f.name = f.name, p.name()
+		}
+		f.args = p.arguments(false)
+		f.directives = p.directives()
+		if p.peek("{") {
+			f.selections = p.selectionSet()
+		}
+		selections = append(selections, f)
+	}
+	if len(selections) == 0 {
+		p.fail("selection set can't be empty")
+	}
+	return selections
+}
+
+func (p *parser) arguments(constant bool) []argument {
+	args := []argument{}
+	if !p.skip("(") {
+		return args
+	}
+	for !p.skip(")") {
+		arg := argument{name: p.name()}
+		p.expect(":")
+		arg.value = p.value(constant)
+		args = append(args, arg)
+	}
+	return args
+}
+
+func (p *parser) directives() []directive {
+	directives := []directive{}
+	for p.skip("@") {
+		directives = append(directives, directive{name: p.name(), args: p.arguments(false)})
+	}
+	return directives
+}
+
+func (p *parser) value(constant bool) any {
+	tok := p.tok
+	switch {
+	case p.skip("$"):
+		if constant {
+			p.fail("variables aren't allowed here")
+		}
+		return variableRef(p.name())
+	case p.skip("["):
+		list := []any{}
+		for !p.skip("]") {
+			list = append(list, p.value(constant))
+		}
+		return list
+	case p.skip("{"):
+		obj := map[string]any{}
+		for !p.skip("}") {
+			name := p.name()
+			p.expect(":")
+			obj[name] = p.value(constant)
+		}
+		return obj
+	}
+
+	p.next()
+	switch tok.kind {
+	case tokenInt:
+		n, err := strconv.Atoi(tok.value)
+		if err != nil {
+			p.fail("integer %s is out of range", tok.value)
+		}
+		return n
+	case tokenFloat:
+		f, _ := strconv.ParseFloat(tok.value, 64)
+		return f
+	case tokenString:
+		return tok.value
+	case tokenName:
+		switch tok.value {
+		case "true":
+			return true
+		case "false":
+			return false
+		case "null":
+			return nil
+		}
+		return enumValue(tok.value)
+	}
+	p.tok = tok
+	p.fail("expected a value, found %s", p.describe())
+	return nil
+}
+
+// next reads the next token into p.tok.
+func (p *parser) next() {
+	// Whitespace, commas and comments are insignificant.
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c == '#' {
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' && p.src[p.pos] != '\r' {
+				p.pos++
+			}
+			continue
+		}
+		if c != ' ' && c != '\t' && c != '\n' && c != '\r' && c != ',' {
+			break
+		}
+		p.pos++
+	}
+
+	start := p.pos
+	p.tok = token{pos: start}
+	if p.pos >= len(p.src) {
+		p.tok.kind = tokenEOF
+		return
+	}
+
+	c := p.src[p.pos]
+	switch {
+	case strings.HasPrefix(p.src[p.pos:], "..."):
+		p.pos += 3
+		p.tok.kind, p.tok.value = tokenPunct, "..."
+	case strings.IndexByte("!$&():=@[]{}|", c) >= 0:
+		p.pos++
+		p.tok.kind, p.tok.value = tokenPunct, string(c)
+	case c == '_' || isLetter(c):
+		for p.pos < len(p.src) && (p.src[p.pos] == '_' || isLetter(p.src[p.pos]) || isDigit(p.src[p.pos])) {
+			p.pos++
+		}
+		p.tok.kind, p.tok.value = tokenName, p.src[start:p.pos]
+	case c == '-' || isDigit(c):
+		p.number()
+	case c == '"':
+		p.string()
+	default:
+		r, _ := utf8.DecodeRuneInString(p.src[p.pos:])
+		p.tok.value = string(r)
+		p.fail("unexpected character %q", r)
+	}
+}
+
+func (p *parser) number() {
+	start := p.pos
+	kind := tokenInt
+	if p.src[p.pos] == '-' {
+		p.pos++
+	}
+	digits := func() {
+		begin := p.pos
+		for p.pos < len(p.src) && isDigit(p.src[p.pos]) {
+			p.pos++
+		}
+		if p.pos == begin {
+			p.tok.value = p.src[start:p.pos]
+			p.fail("invalid number %q", p.src[start:p.pos])
+		}
+	}
+	digits()
+	if p.pos < len(p.src) && p.src[p.pos] == '.' {
+		kind = tokenFloat
+		p.pos++
+		digits()
+	}
+	if p.pos < len(p.src) && (p.src[p.pos] == 'e' || p.src[p.pos] == 'E') {
+		kind = tokenFloat
+		p.pos++
+		if p.pos < len(p.src) && (p.src[p.pos] == '+' || p.src[p.pos] == '-') {
+			p.pos++
+		}
+		digits()
+	}
+	p.tok.kind, p.tok.value = kind, p.src[start:p.pos]
+}
+
+func (p *parser) string() {
+	if strings.HasPrefix(p.src[p.pos:], `"""`) {
+		end := strings.Index(p.src[p.pos+3:], `"""`)
+		if end < 0 {
+			p.fail("unterminated string")
+		}
+		p.tok.kind, p.tok.value = tokenString, strings.TrimSpace(p.src[p.pos+3:p.pos+3+end])
+		p.pos += end + 6
+		return
+	}
+
+	var b strings.Builder
+	p.pos++
+	for {
+		if p.pos >= len(p.src) || p.src[p.pos] == '\n' {
+			p.fail("unterminated string")
+		}
+		c := p.src[p.pos]
+		if c == '"' {
+			p.pos++
+			break
+		}
+		if c != '\\' {
+			b.WriteByte(c)
+			p.pos++
+			continue
+		}
+		if p.pos+1 >= len(p.src) {
+			p.fail("unterminated string")
+		}
+		escape := p.src[p.pos+1]
+		p.pos += 2
+		switch escape {
+		case '"', '\\', '/':
+			b.WriteByte(escape)
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'u':
+			if p.pos+4 > len(p.src) {
+				p.fail("invalid unicode escape")
+			}
+			r, err := strconv.ParseUint(p.src[p.pos:p.pos+4], 16, 32)
+			if err != nil {
+				p.fail("invalid unicode escape")
+			}
+			b.WriteRune(rune(r))
+			p.pos += 4
+		default:
+			p.fail("invalid escape \\%c", escape)
+		}
+	}
+	p.tok.kind, p.tok.value = tokenString, b.String()
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
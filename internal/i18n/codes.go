@@ -0,0 +1,510 @@
+package i18n
+
+// codes maps each English error message to its stable code. Rewording a
+// message means updating its key here; the code itself must not change, and
+// messages that mean the same thing to a client share a code.
+var codes = map[string]string{
+	// Authentication and authorization
+	"Couldn't find JWT":                                          "missing_token",
+	"Couldn't find token":                                        "missing_token",
+	"Couldn't validate JWT":                                      "invalid_token",
+	"Couldn't validate admin token":                              "invalid_token",
+	"Couldn't validate metrics token":                            "invalid_token",
+	"Couldn't validate API key":                                  "invalid_api_key",
+	"Couldn't validate token":                                    "invalid_token",
+	"Incorrect email or password":                                "invalid_credentials",
+	"Email and password are required":                            "credentials_required",
+	"Admin access required":                                      "admin_required",
+	"Impersonation tokens can't delete":                          "impersonation_read_only",
+	"Impersonation tokens can't manage credentials":              "impersonation_credentials",
+	"This video is unavailable":                                  "video_unavailable",
+	"Playback link is invalid or expired":                        "playback_link_invalid",
+	"Playback isn't allowed from this site":                      "playback_referrer_blocked",
+	"Your account is suspended":                                  "account_suspended",
+	"Admins can't be impersonated":                               "impersonation_forbidden",
+	"Not authorized to access this video":                        "video_forbidden",
+	"Not authorized to upload for this video":                    "video_forbidden",
+	"Not authorized to update this video":                        "video_forbidden",
+	"You can't view this video's status":                         "video_forbidden",
+	"You can't view this video":                                  "video_forbidden",
+	"You can't update this video":                                "video_forbidden",
+	"You can't delete this video":                                "video_forbidden",
+	"You can't access this live session":                         "live_session_forbidden",
+	"You can't change this series":                               "series_forbidden",
+	"Age verification is required to watch this video":           "age_verification_required",
+	"This video is age-restricted. Confirm your age to watch it": "age_confirmation_required",
+	"Sign in and enter the passphrase to watch this video":       "passphrase_login_required",
+	"Enter the passphrase to watch this video":                   "passphrase_required",
+	"This video's rating was set by a moderator":                 "rating_locked",
+	"Couldn't hash password":                                     "internal_error",
+	"Couldn't create access JWT":                                 "internal_error",
+	"Couldn't create refresh token":                              "internal_error",
+	"Couldn't save refresh token":                                "internal_error",
+	"Couldn't get user for refresh token":                        "invalid_token",
+	"Refresh token has expired":                                  "refresh_token_expired",
+	"Refresh token has been revoked":                             "refresh_token_revoked",
+	"Couldn't revoke session":                                    "internal_error",
+	"Invalid resize signature":                                   "invalid_signature",
+	"Invalid webhook timestamp":                                  "invalid_webhook_timestamp",
+	"Invalid webhook signature":                                  "invalid_signature",
+
+	// Request validation
+	"Couldn't decode parameters":         "invalid_body",
+	"Invalid ID":                         "invalid_id",
+	"Invalid video ID":                   "invalid_video_id",
+	"Invalid upload ID":                  "invalid_upload_id",
+	"Invalid preset ID":                  "invalid_preset_id",
+	"Invalid API key ID":                 "invalid_api_key_id",
+	"Invalid device token ID":            "invalid_device_token_id",
+	"Invalid episode number":             "invalid_episode_number",
+	"Invalid series ID":                  "invalid_series_id",
+	"Invalid track index":                "invalid_track_index",
+	"Language must be an ISO 639 code":   "invalid_language",
+	"Error parsing form data":            "invalid_form",
+	"Unable to parse form file":          "invalid_form",
+	"Unable to parse video file":         "invalid_form",
+	"Missing Content-Type for thumbnail": "missing_content_type",
+	"Missing Content-Type for video":     "missing_content_type",
+	"Invalid Content-Type format":        "invalid_content_type",
+	"Unsupported file type. Only JPEG, PNG and HEIC are allowed.":                 "unsupported_thumbnail_type",
+	"Invalid file type. This kind of video isn't allowed.":                        "unsupported_video_type",
+	"Thumbnail isn't a valid image":                                               "invalid_thumbnail_image",
+	"Video file isn't a valid video of its type":                                  "invalid_video_file",
+	"File content doesn't match its declared type":                                "content_type_mismatch",
+	"Couldn't decode metadata.json":                                               "invalid_bundle_metadata",
+	"Bundle is not a valid zip archive":                                           "invalid_bundle",
+	"Unknown processing profile":                                                  "unknown_profile",
+	"Watermark text is required":                                                  "watermark_text_required",
+	"Watermark text is too long":                                                  "watermark_text_too_long",
+	"Invalid watermark value":                                                     "invalid_watermark",
+	"Unknown content rating":                                                      "invalid_content_rating",
+	"Invalid bandwidth":                                                           "invalid_bandwidth",
+	"No watch progress for this video":                                            "watch_progress_not_found",
+	"Progress reported too often":                                                 "progress_too_frequent",
+	"Invalid playback position":                                                   "invalid_playback_position",
+	"Device must be mobile, tablet, desktop or tv":                                "invalid_device",
+	"Unknown tenant":                                                              "unknown_tenant",
+	"This needs S3 storage":                                                       "storage_unsupported",
+	"That bucket is already the default bucket":                                   "bucket_already_default",
+	"Bucket and region are required":                                              "bucket_required",
+	"t must be a non-negative timestamp in seconds":                               "invalid_timestamp",
+	"t is past the end of the video":                                              "timestamp_out_of_range",
+	"width must be between 32 and 800":                                            "invalid_gif_width",
+	"fps must be between 1 and 30":                                                "invalid_gif_fps",
+	"duration must be more than 0 and at most 15 seconds":                         "invalid_gif_duration",
+	"start must be a non-negative timestamp in seconds":                           "invalid_gif_start",
+	"retry_after_seconds can't be negative":                                       "invalid_retry_after",
+	"Invalid _HLS_msn":                                                            "invalid_hls_msn",
+	"Unknown report reason":                                                       "invalid_report_reason",
+	"Details are required for reason other":                                       "report_details_required",
+	"Unknown report status":                                                       "invalid_report_status",
+	"You can't report your own video":                                             "cannot_report_own_video",
+	"You have already reported this video":                                        "duplicate_report",
+	"Upload session is no longer active":                                          "upload_session_inactive",
+	"A chunk is already being appended to this upload":                            "append_in_progress",
+	"Upload-Offset doesn't match the stored offset":                               "upload_offset_mismatch",
+	"Upload session takes appended chunks":                                        "upload_protocol_mismatch",
+	"Upload session takes numbered parts":                                         "upload_protocol_mismatch",
+	"Upload session takes a direct upload":                                        "upload_protocol_mismatch",
+	"This video is under legal hold":                                              "legal_hold",
+	"Video is already being processed":                                            "video_processing",
+	"Upload ID is already in use":                                                 "upload_id_taken",
+	"Episode number is taken":                                                     "episode_number_taken",
+	"Video is already in a series":                                                "video_in_series",
+	"The video's files are locked by S3 Object Lock":                              "object_locked",
+	"limit must be between 1 and 500":                                             "invalid_limit",
+	"limit must be between 1 and 100":                                             "invalid_recommendation_limit",
+	"Unknown trending window":                                                     "unknown_trending_window",
+	"Too many video IDs":                                                          "too_many_video_ids",
+	"Video IDs are required":                                                      "video_ids_required",
+	"Scope must be urls or all":                                                   "invalid_scope",
+	"expires_in_seconds must be between 1 and 3600":                               "invalid_expiry",
+	"A reason is required to impersonate a user":                                  "impersonation_reason_required",
+	"A reason is required to suspend a user":                                      "suspension_reason_required",
+	"Invalid pagination cursor":                                                   "invalid_cursor",
+	"limit must be between 1 and 1000":                                            "invalid_event_limit",
+	"Invalid event cursor":                                                        "invalid_event_cursor",
+	"event must be impression or click":                                           "invalid_event_type",
+	"order must be asc or desc":                                                   "invalid_order",
+	"sort must be created_at, updated_at or title":                                "invalid_sort",
+	"Videos can have up to 50 custom metadata keys":                               "too_many_custom_metadata_keys",
+	"Custom metadata values can be up to 1024 bytes":                              "custom_metadata_value_too_large",
+	"Invalid custom metadata key":                                                 "invalid_custom_metadata_key",
+	"External ID is already in use":                                               "external_id_taken",
+	"Custom metadata must be an object of strings":                                "invalid_custom_metadata",
+	"visibility must be public, unlisted or private":                              "invalid_visibility",
+	"passphrase must be 4 to 72 bytes":                                            "invalid_passphrase",
+	"video_ids must have 1 to 100 IDs":                                            "invalid_presign_batch",
+	"Video has no passphrase":                                                     "passphrase_not_found",
+	"Too many passphrase attempts, try again later":                               "too_many_passphrase_attempts",
+	"Incorrect passphrase":                                                        "incorrect_passphrase",
+	"Scan verdict not found":                                                      "scan_verdict_not_found",
+	"Tenant has no logo":                                                          "logo_not_found",
+	"Tenant has no branding":                                                      "branding_not_found",
+	"watermark_opacity must be above 0 and at most 1":                             "invalid_watermark_opacity",
+	"watermark_position must be top-left, top-right, bottom-left or bottom-right": "invalid_watermark_position",
+	"Colors must be hex colors such as #1a73e8":                                   "invalid_color",
+	"sha256 must be 64 lowercase hex digits":                                      "invalid_sha256",
+	"status must be clean, flagged or blocked":                                    "invalid_scan_status",
+	"File was rejected by content scanning":                                       "file_rejected",
+	"created_after and created_before must be RFC 3339 times":                     "invalid_created_range",
+	"hours must be between 1 and 168":                                             "invalid_hours",
+	"Thumbnail is unchanged":                                                      "thumbnail_unchanged",
+	"Thumbnail checksum mismatch":                                                 "checksum_mismatch",
+	"Invalid thumbnail checksum":                                                  "invalid_checksum",
+	"Upload is incomplete":                                                        "upload_incomplete",
+	"Uploaded file doesn't match its declared size":                               "upload_size_mismatch",
+	"Object is empty":                                                             "object_empty",
+	"Couldn't check object":                                                       "object_check_failed",
+	"Object not found":                                                            "object_not_found",
+	"Object key must be under the video's prefix":                                 "object_key_outside_video",
+	"Video already has a file":                                                    "video_file_exists",
+	"This server only records metadata. Upload the file to storage and register it instead.": "metadata_only",
+	"Signing key not found":                                  "ingest_key_not_found",
+	"The linked IAM role can't read the S3 object":           "s3_object_access_denied",
+	"S3 object not found":                                    "s3_object_not_found",
+	"Imported file doesn't match its checksum":               "import_checksum_mismatch",
+	"Link an IAM role to import from S3":                     "s3_source_required",
+	"Invalid region":                                         "invalid_region",
+	"Invalid bucket or key":                                  "invalid_s3_object",
+	"Invalid role ARN":                                       "invalid_role_arn",
+	"S3 source not found":                                    "s3_source_not_found",
+	"Ingest batch not found":                                 "ingest_batch_not_found",
+	"Uploaded file doesn't match its manifest":               "manifest_hash_mismatch",
+	"Each file needs a size of at most 1 GB":                 "invalid_manifest_size",
+	"A manifest must list 1 to 100 files":                    "invalid_manifest_items",
+	"Invalid manifest signature":                             "invalid_manifest_signature",
+	"Invalid manifest timestamp":                             "invalid_manifest_timestamp",
+	"Create a signing key to submit manifests":               "ingest_key_required",
+	"Upload size is required":                                "upload_size_required",
+	"Content-Length is required":                             "length_required",
+	"Couldn't read part":                                     "part_read_failed",
+	"Couldn't read test payload":                             "payload_read_failed",
+	"Part is empty":                                          "empty_part",
+	"Part checksum mismatch":                                 "part_checksum_mismatch",
+	"Invalid part checksum":                                  "invalid_part_checksum",
+	"Invalid part number":                                    "invalid_part_number",
+	"Invalid part count":                                     "invalid_part_count",
+	"Chunks must be sent as application/offset+octet-stream": "unsupported_chunk_type",
+	"Couldn't read chunk":                                    "part_read_failed",
+	"Invalid Upload-Offset":                                  "invalid_upload_offset",
+	"Send either a part count or a size":                     "invalid_upload_size",
+	"Invalid resize parameters":                              "invalid_resize_params",
+
+	// Not found
+	"Not found":                                          "not_found",
+	"Video not found":                                    "video_not_found",
+	"Video isn't in the trash":                           "video_not_in_trash",
+	"Video has no thumbnail":                             "video_has_no_thumbnail",
+	"Video has no file":                                  "video_has_no_file",
+	"Caption track not found":                            "caption_track_not_found",
+	"Captions must be valid WebVTT or SRT":               "invalid_captions",
+	"Captions must be a .vtt or .srt file":               "invalid_caption_type",
+	"Video was uploaded without a checksum":              "video_checksum_missing",
+	"Playlist not found":                                 "playlist_not_found",
+	"Couldn't find video":                                "video_not_found",
+	"User not found":                                     "user_not_found",
+	"Couldn't find viewer":                               "user_not_found",
+	"Couldn't find video owner":                          "user_not_found",
+	"Audio track not found":                              "audio_track_not_found",
+	"Thumbnail not found":                                "thumbnail_not_found",
+	"Thumbnail candidate not found":                      "thumbnail_candidate_not_found",
+	"Live session not found":                             "live_session_not_found",
+	"Live stream not found":                              "live_session_not_found",
+	"No processing job found for video":                  "job_not_found",
+	"No media info captured for this video":              "media_info_not_found",
+	"No keyframe index for this video":                   "keyframes_not_found",
+	"Video file is no longer available":                  "video_file_gone",
+	"Requested range isn't in the video file":            "range_not_satisfiable",
+	"Playlist not available yet":                         "playlist_not_ready",
+	"Report not found":                                   "report_not_found",
+	"Upload session not found":                           "upload_session_not_found",
+	"Upload not found":                                   "upload_not_found",
+	"Preset not found":                                   "preset_not_found",
+	"API key not found":                                  "api_key_not_found",
+	"Device token not found":                             "device_token_not_found",
+	"Invalid webhook URL":                                "invalid_webhook_url",
+	"Invalid payload template":                           "invalid_payload_template",
+	"Invalid webhook header":                             "invalid_webhook_header",
+	"Unknown webhook event":                              "unknown_webhook_event",
+	"Too many webhooks":                                  "too_many_webhooks",
+	"Invalid webhook ID":                                 "invalid_webhook_id",
+	"Webhook not found":                                  "webhook_not_found",
+	"Unknown notification channel kind":                  "unknown_notification_channel_kind",
+	"Too many notification rules":                        "too_many_notification_rules",
+	"Unknown notification event":                         "unknown_notification_event",
+	"Invalid mention":                                    "invalid_mention",
+	"Too many notification channels":                     "too_many_notification_channels",
+	"Invalid notification channel ID":                    "invalid_notification_channel_id",
+	"Notification channel not found":                     "notification_channel_not_found",
+	"Episode not found":                                  "episode_not_found",
+	"Series not found":                                   "series_not_found",
+	"Thumbnail regeneration job not found":               "regen_job_not_found",
+	"Storage migration not found":                        "storage_migration_not_found",
+	"Scheduled task not found":                           "scheduled_task_not_found",
+	"GIF export not found":                               "gif_export_not_found",
+	"Watermarked playback is not enabled for this video": "watermark_disabled",
+
+	// Capacity
+	"No live ingest capacity available":                                  "live_capacity_exhausted",
+	"A thumbnail regeneration job is already running":                    "regen_job_running",
+	"The default bucket has changed since the storage migration started": "storage_migration_stale",
+	"Storage migration has already switched":                             "storage_migration_switched",
+	"Storage migration isn't running":                                    "storage_migration_not_running",
+	"A storage migration is already running":                             "storage_migration_running",
+	"delete_orphans must be true or false":                               "invalid_delete_orphans",
+	"A janitor sweep is already running":                                 "janitor_sweep_running",
+	"Scheduled task is already running":                                  "scheduled_task_running",
+	"You already have a GIF export running":                              "gif_export_running",
+	"Thumbnail variants are not configured":                              "thumbnail_variants_disabled",
+	"Image resizing is not configured":                                   "resize_disabled",
+	"Cache webhook is not configured":                                    "cache_webhook_disabled",
+	"SFTP ingest is not configured":                                      "sftp_ingest_disabled",
+	"Invalid ingest key":                                                 "invalid_ingest_key",
+	"Unsupported event":                                                  "unsupported_event",
+	"File isn't in the SFTP ingest bucket":                               "sftp_wrong_bucket",
+	"SFTP user isn't linked to an account":                               "sftp_user_not_linked",
+	"SFTP username is linked to another user":                            "sftp_username_taken",
+	"Invalid SFTP username":                                              "invalid_sftp_username",
+	"Email-in is not configured":                                         "email_ingest_disabled",
+	"Email-in address not found":                                         "email_address_not_found",
+	"Invalid sender":                                                     "invalid_email_sender",
+	"Too many senders":                                                   "too_many_email_senders",
+	"Database backups are not configured":                                "backups_not_configured",
+	"day must be a past date, YYYY-MM-DD":                                "invalid_warehouse_day",
+	"Warehouse exports are not configured":                               "warehouse_exports_not_configured",
+	"Chaos mode is not enabled":                                          "chaos_disabled",
+	"Unknown API version":                                                "unknown_api_version",
+	"This video already has the maximum number of thumbnail candidates":  "thumbnail_candidate_limit",
+	"Server is busy, please try again shortly":                           "server_busy",
+	"Too many uploads are waiting to be processed":                       "processing_queue_full",
+	"Daily upload limit reached":                                         "daily_upload_limit",
+	"Too many requests, please slow down":                                "rate_limited",
+	"Upload is too large":                                                "upload_too_large",
+	"Upload is too slow":                                                 "upload_too_slow",
+	"Caption file is too large":                                          "caption_too_large",
+	"Storage quota exceeded":                                             "storage_quota_exceeded",
+	"Invalid storage quota":                                              "invalid_storage_quota",
+	"Part is too large":                                                  "part_too_large",
+	"Test payload is too large":                                          "payload_too_large",
+	"Chunk runs past the upload's size":                                  "chunk_exceeds_upload_length",
+
+	// Processing
+	"Failed to determine video duration":       "probe_failed",
+	"Failed to determine video aspect ratio":   "probe_failed",
+	"Failed to determine video color metadata": "probe_failed",
+	"Failed to read spherical video metadata":  "probe_failed",
+	"Failed to read audio tracks":              "probe_failed",
+	"Failed to read video metadata":            "probe_failed",
+	"Failed to process video":                  "processing_failed",
+	"Couldn't burn in captions":                "processing_failed",
+	"Failed to capture media info":             "processing_failed",
+	"Couldn't extract frame":                   "frame_extraction_failed",
+	"Couldn't read frame":                      "frame_extraction_failed",
+	"Couldn't convert HEIC thumbnail":          "thumbnail_conversion_failed",
+	"Couldn't resize image":                    "resize_failed",
+	"Couldn't create watermarked copy":         "watermark_failed",
+	"Couldn't start live ingest":               "live_ingest_failed",
+	"Dead link sweep failed":                   "sweep_failed",
+	"Couldn't fingerprint file audio":          "fingerprint_failed",
+
+	// Storage
+	"Failed to upload video to S3":            "upload_failed",
+	"Failed to upload preview to S3":          "upload_failed",
+	"Failed to upload rendition to S3":        "upload_failed",
+	"Failed to upload SDR rendition to S3":    "upload_failed",
+	"Failed to upload HLS renditions to S3":   "upload_failed",
+	"Failed to upload waveform peaks to S3":   "upload_failed",
+	"Failed to upload captions to S3":         "upload_failed",
+	"Couldn't resolve storage for tenant":     "storage_unavailable",
+	"Couldn't locate video file":              "storage_unavailable",
+	"Couldn't read playlist":                  "storage_unavailable",
+	"Couldn't read video file":                "storage_unavailable",
+	"Couldn't check video file":               "storage_unavailable",
+	"Couldn't list database backups":          "storage_unavailable",
+	"Couldn't set legal hold on video files":  "storage_unavailable",
+	"Couldn't check bucket object lock":       "storage_unavailable",
+	"Couldn't generate playback URL":          "storage_unavailable",
+	"Couldn't invalidate CDN cache":           "cdn_unavailable",
+	"Couldn't sign video URLs":                "storage_unavailable",
+	"Couldn't generate source URL":            "storage_unavailable",
+	"Couldn't generate frame URL":             "storage_unavailable",
+	"Couldn't check cached frame":             "storage_unavailable",
+	"Couldn't generate GIF URL":               "storage_unavailable",
+	"Couldn't check for an existing GIF":      "storage_unavailable",
+	"Couldn't cache frame":                    "storage_unavailable",
+	"Couldn't check watermarked copy":         "storage_unavailable",
+	"Failed to read assembled upload from S3": "storage_unavailable",
+	"Couldn't check uploaded file":            "storage_unavailable",
+	"Couldn't presign upload URL":             "storage_unavailable",
+	"Couldn't get SFTP upload":                "storage_unavailable",
+	"Couldn't download SFTP upload":           "storage_unavailable",
+	"Failed to list uploaded parts in S3":     "storage_unavailable",
+	"Failed to assemble upload in S3":         "upload_failed",
+	"Failed to upload part to S3":             "upload_failed",
+	"Failed to start multipart upload in S3":  "upload_failed",
+	"Storage bucket doesn't exist":            "storage_bucket_not_found",
+	"Storage denied access":                   "storage_access_denied",
+	"Storage is throttling requests":          "storage_throttled",
+	"File is too large for storage":           "storage_entity_too_large",
+	"Storage timed out":                       "storage_timeout",
+	"Storage is unavailable":                  "storage_unavailable",
+
+	// Remediation hints sent with storage errors
+	"The server's storage is misconfigured. Contact the administrator.":                       "hint_contact_admin",
+	"The server's storage credentials lack a permission. Contact the administrator.":          "hint_storage_permissions",
+	"Retry after the number of seconds in Retry-After.":                                       "hint_retry_after",
+	"Upload files over 5 GB with an upload session.":                                          "hint_use_upload_session",
+	"Retry the request. Over a slow connection, use an upload session so uploads can resume.": "hint_retry_resumable",
+
+	// Everything else is a server-side failure the client can only retry.
+	"Couldn't get video":                     "internal_error",
+	"Couldn't get user":                      "internal_error",
+	"Couldn't get audio tracks":              "internal_error",
+	"Couldn't get renditions":                "internal_error",
+	"Couldn't get processing logs":           "internal_error",
+	"Couldn't get media info":                "internal_error",
+	"Couldn't get job status":                "internal_error",
+	"Couldn't get reports":                   "internal_error",
+	"Couldn't get report":                    "internal_error",
+	"Couldn't save report":                   "internal_error",
+	"Couldn't update report":                 "internal_error",
+	"Couldn't get access events":             "internal_error",
+	"Couldn't retrieve videos":               "internal_error",
+	"Couldn't retrieve shorts":               "internal_error",
+	"Couldn't retrieve broken links":         "internal_error",
+	"Couldn't create video":                  "internal_error",
+	"Couldn't update video":                  "internal_error",
+	"Couldn't delete video":                  "internal_error",
+	"Couldn't create user":                   "internal_error",
+	"Couldn't update user":                   "internal_error",
+	"Couldn't update audio track":            "internal_error",
+	"Couldn't stop live session":             "internal_error",
+	"Couldn't save flag":                     "internal_error",
+	"Couldn't delete flag override":          "internal_error",
+	"Couldn't reload flags":                  "internal_error",
+	"Couldn't reset database":                "internal_error",
+	"Failed to update video metadata":        "internal_error",
+	"Failed to save renditions":              "internal_error",
+	"Failed to save audio tracks":            "internal_error",
+	"Failed to read current audio tracks":    "internal_error",
+	"Failed to create temporary file":        "internal_error",
+	"Failed to copy video to temporary file": "internal_error",
+	"Failed to reset file pointer":           "internal_error",
+	"Failed to save file to disk":            "internal_error",
+	"Failed to create file on disk":          "internal_error",
+	"Failed to generate random key":          "internal_error",
+	"Failed to generate random filename":     "internal_error",
+	"Couldn't save fingerprint reference":    "internal_error",
+	"Couldn't get fingerprint references":    "internal_error",
+	"Couldn't delete fingerprint reference":  "internal_error",
+	"Couldn't get thumbnail variants":        "internal_error",
+	"Couldn't get thumbnail candidates":      "internal_error",
+	"Couldn't save thumbnail candidate":      "internal_error",
+	"Couldn't delete thumbnail candidate":    "internal_error",
+	"Couldn't record thumbnail stats":        "internal_error",
+	"Couldn't get caption tracks":            "internal_error",
+	"Couldn't save caption track":            "internal_error",
+	"Couldn't create upload session":         "internal_error",
+	"Couldn't get upload session":            "internal_error",
+	"Couldn't update upload session":         "internal_error",
+	"Couldn't get upload parts":              "internal_error",
+	"Couldn't save upload part":              "internal_error",
+	"Couldn't get dashboard stats":           "internal_error",
+	"Couldn't get user activity":             "internal_error",
+	"Couldn't get video owner":               "internal_error",
+	"Database backup failed":                 "internal_error",
+	"Couldn't create storage migration":      "internal_error",
+	"Couldn't get storage migrations":        "internal_error",
+	"Couldn't store chunk":                   "internal_error",
+	"Couldn't read uploaded file":            "internal_error",
+	"Couldn't delete watch history":          "internal_error",
+	"Couldn't get watch history":             "internal_error",
+	"Couldn't get watch progress":            "internal_error",
+	"Couldn't save watch progress":           "internal_error",
+	"Couldn't get videos":                    "internal_error",
+	"Couldn't get video stats":               "internal_error",
+	"Couldn't rank recommendations":          "internal_error",
+	"Couldn't get view counts":               "internal_error",
+	"Couldn't build sitemap":                 "internal_error",
+	"Couldn't get settings":                  "internal_error",
+	"Couldn't save settings":                 "internal_error",
+	"Couldn't get storage usage":             "internal_error",
+	"Couldn't update storage usage":          "internal_error",
+	"Couldn't get preset":                    "internal_error",
+	"Couldn't get presets":                   "internal_error",
+	"Couldn't save preset":                   "internal_error",
+	"Couldn't update preset":                 "internal_error",
+	"Couldn't delete preset":                 "internal_error",
+	"Couldn't get series":                    "internal_error",
+	"Couldn't save series":                   "internal_error",
+	"Couldn't delete series":                 "internal_error",
+	"Couldn't get episodes":                  "internal_error",
+	"Couldn't add episode":                   "internal_error",
+	"Couldn't remove episode":                "internal_error",
+	"Couldn't build feed":                    "internal_error",
+	"Couldn't locate caption file":           "internal_error",
+	"Couldn't get keyframes":                 "internal_error",
+	"Couldn't get refresh token":             "internal_error",
+	"Couldn't get API key":                   "internal_error",
+	"Couldn't create API key":                "internal_error",
+	"Couldn't save API key":                  "internal_error",
+	"Couldn't get API keys":                  "internal_error",
+	"Couldn't revoke API key":                "internal_error",
+	"Couldn't get webhooks":                  "internal_error",
+	"Couldn't create webhook":                "internal_error",
+	"Couldn't save webhook":                  "internal_error",
+	"Couldn't get webhook":                   "internal_error",
+	"Couldn't get webhook deliveries":        "internal_error",
+	"Couldn't delete webhook":                "internal_error",
+	"Couldn't get SFTP account":              "internal_error",
+	"Couldn't get SFTP ingest":               "internal_error",
+	"Couldn't save SFTP ingest":              "internal_error",
+	"Couldn't get SFTP accounts":             "internal_error",
+	"Couldn't update SFTP account":           "internal_error",
+	"Couldn't get email-in address":          "internal_error",
+	"Couldn't update email-in address":       "internal_error",
+	"Couldn't get email-in messages":         "internal_error",
+	"Couldn't get notification channels":     "internal_error",
+	"Couldn't save notification channel":     "internal_error",
+	"Couldn't get notification channel":      "internal_error",
+	"Couldn't delete notification channel":   "internal_error",
+	"Couldn't restore video":                 "internal_error",
+	"Couldn't delete caption track":          "internal_error",
+	"Couldn't generate share key":            "internal_error",
+	"Couldn't get scan verdicts":             "internal_error",
+	"Couldn't delete scan verdicts":          "internal_error",
+	"Couldn't get branding":                  "internal_error",
+	"Couldn't save branding":                 "internal_error",
+	"Couldn't delete branding":               "internal_error",
+	"Couldn't sign logo URL":                 "internal_error",
+	"Couldn't hash passphrase":               "internal_error",
+	"Couldn't create signing key":            "internal_error",
+	"Couldn't save signing key":              "internal_error",
+	"Couldn't delete signing key":            "internal_error",
+	"Couldn't get signing key":               "internal_error",
+	"Couldn't get ingest batch":              "internal_error",
+	"Couldn't get ingest batches":            "internal_error",
+	"Couldn't save ingest batch":             "internal_error",
+	"Couldn't get S3 source":                 "internal_error",
+	"Couldn't update S3 source":              "internal_error",
+	"Couldn't delete S3 source":              "internal_error",
+	"Couldn't create S3 client":              "internal_error",
+	"Couldn't get S3 import":                 "internal_error",
+	"Couldn't save S3 import":                "internal_error",
+	"Couldn't get S3 object":                 "internal_error",
+	"Couldn't copy S3 object":                "internal_error",
+	"Couldn't get events":                    "internal_error",
+	"Couldn't get warehouse exports":         "internal_error",
+	"Warehouse export failed":                "internal_error",
+	"Couldn't get device token":              "internal_error",
+	"Couldn't create device token":           "internal_error",
+	"Couldn't save device token":             "internal_error",
+	"Couldn't get device tokens":             "internal_error",
+	"Couldn't revoke device token":           "internal_error",
+	"Couldn't get scheduled tasks":           "internal_error",
+	"Couldn't get scheduled task":            "internal_error",
+	"Couldn't get scheduled task runs":       "internal_error",
+	"Couldn't save scheduled task":           "internal_error",
+	"Error writing response":                 "internal_error",
+}
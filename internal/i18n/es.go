@@ -0,0 +1,277 @@
+package i18n
+
+var es = map[string]string{
+	"account_suspended":                 "Tu cuenta está suspendida",
+	"admin_required":                    "Se requiere acceso de administrador",
+	"age_confirmation_required":         "Este vídeo tiene restricción de edad. Confirma tu edad para verlo",
+	"age_verification_required":         "Se requiere verificación de edad para ver este vídeo",
+	"api_key_not_found":                 "Clave de API no encontrada",
+	"append_in_progress":                "Ya se está añadiendo un fragmento a esta subida",
+	"audio_track_not_found":             "No se encontró la pista de audio",
+	"backups_not_configured":            "Las copias de seguridad de la base de datos no están configuradas",
+	"branding_not_found":                "El inquilino no tiene personalización de marca",
+	"bucket_already_default":            "Ese bucket ya es el bucket predeterminado",
+	"bucket_required":                   "El bucket y la región son obligatorios",
+	"cache_webhook_disabled":            "El webhook de caché no está configurado",
+	"cannot_report_own_video":           "No puedes denunciar tu propio vídeo",
+	"caption_too_large":                 "El archivo de subtítulos es demasiado grande",
+	"caption_track_not_found":           "No se encontró la pista de subtítulos",
+	"cdn_unavailable":                   "La CDN no está disponible en este momento",
+	"chaos_disabled":                    "El modo de caos no está activado",
+	"checksum_mismatch":                 "La suma de comprobación de la miniatura no coincide",
+	"chunk_exceeds_upload_length":       "El fragmento supera el tamaño de la subida",
+	"content_type_mismatch":             "El contenido del archivo no coincide con el tipo declarado",
+	"credentials_required":              "El correo electrónico y la contraseña son obligatorios",
+	"custom_metadata_value_too_large":   "Los valores de metadatos personalizados pueden tener hasta 1024 bytes",
+	"daily_upload_limit":                "Has alcanzado el límite diario de subidas",
+	"device_token_not_found":            "Token de dispositivo no encontrado",
+	"duplicate_report":                  "Ya has denunciado este vídeo",
+	"email_address_not_found":           "No se encontró la dirección de envío por correo",
+	"email_ingest_disabled":             "El envío por correo no está configurado",
+	"empty_part":                        "La parte está vacía",
+	"episode_not_found":                 "Episodio no encontrado",
+	"episode_number_taken":              "El número de episodio ya está en uso",
+	"external_id_taken":                 "El ID externo ya está en uso",
+	"file_rejected":                     "El análisis de contenido rechazó el archivo",
+	"fingerprint_failed":                "No se pudo calcular la huella del audio del archivo",
+	"frame_extraction_failed":           "No se pudo extraer el fotograma",
+	"gif_export_not_found":              "No se encontró la exportación GIF",
+	"gif_export_running":                "Ya tienes una exportación GIF en curso",
+	"hint_contact_admin":                "El almacenamiento del servidor está mal configurado. Contacta con el administrador.",
+	"hint_retry_after":                  "Vuelve a intentarlo tras los segundos indicados en Retry-After.",
+	"hint_retry_resumable":              "Vuelve a intentarlo. Con una conexión lenta, usa una sesión de subida para poder reanudarla.",
+	"hint_storage_permissions":          "A las credenciales de almacenamiento del servidor les falta un permiso. Contacta con el administrador.",
+	"hint_use_upload_session":           "Sube los archivos de más de 5 GB con una sesión de subida.",
+	"impersonation_credentials":         "Los tokens de suplantación no pueden gestionar credenciales",
+	"impersonation_forbidden":           "No se puede suplantar a un administrador",
+	"impersonation_read_only":           "Los tokens de suplantación no pueden eliminar",
+	"impersonation_reason_required":     "Se requiere un motivo para suplantar a un usuario",
+	"import_checksum_mismatch":          "El archivo importado no coincide con su suma de comprobación",
+	"incorrect_passphrase":              "Frase de contraseña incorrecta",
+	"ingest_batch_not_found":            "Lote de ingesta no encontrado",
+	"ingest_key_not_found":              "Clave de firma no encontrada",
+	"ingest_key_required":               "Crea una clave de firma para enviar manifiestos",
+	"internal_error":                    "Se produjo un error interno. Inténtalo de nuevo",
+	"invalid_api_key":                   "No se pudo validar la clave de API",
+	"invalid_api_key_id":                "ID de clave de API no válido",
+	"invalid_bandwidth":                 "Ancho de banda no válido",
+	"invalid_body":                      "No se pudieron leer los parámetros",
+	"invalid_bundle":                    "El paquete no es un archivo zip válido",
+	"invalid_bundle_metadata":           "No se pudo leer metadata.json",
+	"invalid_caption_type":              "Los subtítulos deben ser un archivo .vtt o .srt",
+	"invalid_captions":                  "Los subtítulos deben ser WebVTT o SRT válidos",
+	"invalid_checksum":                  "Suma de comprobación de la miniatura no válida",
+	"invalid_color":                     "Los colores deben ser colores hexadecimales como #1a73e8",
+	"invalid_content_rating":            "Clasificación de contenido desconocida",
+	"invalid_content_type":              "El formato de Content-Type no es válido",
+	"invalid_created_range":             "created_after y created_before deben ser fechas RFC 3339",
+	"invalid_credentials":               "Correo electrónico o contraseña incorrectos",
+	"invalid_cursor":                    "Cursor de paginación no válido",
+	"invalid_custom_metadata":           "Los metadatos personalizados deben ser un objeto de cadenas",
+	"invalid_custom_metadata_key":       "Clave de metadatos personalizados no válida",
+	"invalid_delete_orphans":            "delete_orphans debe ser true o false",
+	"invalid_device":                    "El dispositivo debe ser mobile, tablet, desktop o tv",
+	"invalid_device_token_id":           "ID de token de dispositivo no válido",
+	"invalid_email_sender":              "Remitente no válido",
+	"invalid_episode_number":            "Número de episodio no válido",
+	"invalid_event_cursor":              "Cursor de eventos no válido",
+	"invalid_event_limit":               "limit debe estar entre 1 y 1000",
+	"invalid_event_type":                "event debe ser impression o click",
+	"invalid_expiry":                    "expires_in_seconds debe estar entre 1 y 3600",
+	"invalid_form":                      "No se pudo leer el formulario",
+	"invalid_gif_duration":              "duration debe ser mayor que 0 y como máximo 15 segundos",
+	"invalid_gif_fps":                   "fps debe estar entre 1 y 30",
+	"invalid_gif_start":                 "start debe ser una marca de tiempo no negativa en segundos",
+	"invalid_gif_width":                 "width debe estar entre 32 y 800",
+	"invalid_hls_msn":                   "_HLS_msn no es válido",
+	"invalid_hours":                     "hours debe estar entre 1 y 168",
+	"invalid_id":                        "El ID no es válido",
+	"invalid_ingest_key":                "Clave de ingesta no válida",
+	"invalid_language":                  "El idioma debe ser un código ISO 639",
+	"invalid_limit":                     "limit debe estar entre 1 y 500",
+	"invalid_manifest_items":            "Un manifiesto debe incluir entre 1 y 100 archivos",
+	"invalid_manifest_signature":        "Firma del manifiesto no válida",
+	"invalid_manifest_size":             "Cada archivo necesita un tamaño de como máximo 1 GB",
+	"invalid_manifest_timestamp":        "Marca de tiempo del manifiesto no válida",
+	"invalid_mention":                   "Mención no válida",
+	"invalid_notification_channel_id":   "ID de canal de notificaciones no válido",
+	"invalid_order":                     "order debe ser asc o desc",
+	"invalid_part_checksum":             "Suma de comprobación de la parte no válida",
+	"invalid_part_count":                "Número de partes no válido",
+	"invalid_part_number":               "Número de parte no válido",
+	"invalid_passphrase":                "La frase de contraseña debe tener entre 4 y 72 bytes",
+	"invalid_payload_template":          "Plantilla de contenido no válida",
+	"invalid_playback_position":         "Posición de reproducción no válida",
+	"invalid_preset_id":                 "El ID de la plantilla no es válido",
+	"invalid_presign_batch":             "video_ids debe tener entre 1 y 100 IDs",
+	"invalid_recommendation_limit":      "limit debe estar entre 1 y 100",
+	"invalid_region":                    "Región no válida",
+	"invalid_report_reason":             "Motivo de denuncia desconocido",
+	"invalid_report_status":             "Estado de denuncia desconocido",
+	"invalid_resize_params":             "Parámetros de redimensionado no válidos",
+	"invalid_retry_after":               "retry_after_seconds no puede ser negativo",
+	"invalid_role_arn":                  "ARN de rol no válido",
+	"invalid_s3_object":                 "Bucket o clave no válidos",
+	"invalid_scan_status":               "status debe ser clean, flagged o blocked",
+	"invalid_scope":                     "El alcance debe ser urls o all",
+	"invalid_series_id":                 "El ID de la serie no es válido",
+	"invalid_sftp_username":             "Usuario SFTP no válido",
+	"invalid_sha256":                    "sha256 debe tener 64 dígitos hexadecimales en minúscula",
+	"invalid_signature":                 "Firma no válida",
+	"invalid_sort":                      "sort debe ser created_at, updated_at o title",
+	"invalid_storage_quota":             "Cuota de almacenamiento no válida",
+	"invalid_thumbnail_image":           "La miniatura no es una imagen válida",
+	"invalid_timestamp":                 "t debe ser una marca de tiempo no negativa en segundos",
+	"invalid_token":                     "No se pudo validar el token",
+	"invalid_track_index":               "El índice de pista no es válido",
+	"invalid_upload_id":                 "El ID de la subida no es válido",
+	"invalid_upload_offset":             "Upload-Offset no válido",
+	"invalid_upload_size":               "Envía un número de partes o un tamaño, no ambos",
+	"invalid_video_file":                "El archivo no es un vídeo válido de su tipo",
+	"invalid_video_id":                  "El ID del vídeo no es válido",
+	"invalid_visibility":                "visibility debe ser public, unlisted o private",
+	"invalid_warehouse_day":             "day debe ser una fecha pasada, AAAA-MM-DD",
+	"invalid_watermark":                 "Valor de marca de agua no válido",
+	"invalid_watermark_opacity":         "watermark_opacity debe ser mayor que 0 y como máximo 1",
+	"invalid_watermark_position":        "watermark_position debe ser top-left, top-right, bottom-left o bottom-right",
+	"invalid_webhook_header":            "Cabecera de webhook no válida",
+	"invalid_webhook_id":                "ID de webhook no válido",
+	"invalid_webhook_timestamp":         "Marca de tiempo del webhook no válida",
+	"invalid_webhook_url":               "URL de webhook no válida",
+	"janitor_sweep_running":             "Ya hay una limpieza en curso",
+	"job_not_found":                     "No se encontró ningún trabajo de procesamiento para el vídeo",
+	"keyframes_not_found":               "No hay índice de fotogramas clave para este vídeo",
+	"legal_hold":                        "Este video está bajo retención legal",
+	"length_required":                   "Se requiere Content-Length",
+	"live_capacity_exhausted":           "No hay capacidad disponible para transmisiones en directo",
+	"live_ingest_failed":                "No se pudo iniciar la transmisión en directo",
+	"live_session_forbidden":            "No tienes acceso a esta sesión en directo",
+	"live_session_not_found":            "No se encontró la sesión en directo",
+	"logo_not_found":                    "El inquilino no tiene logotipo",
+	"manifest_hash_mismatch":            "El archivo subido no coincide con su manifiesto",
+	"media_info_not_found":              "No hay información multimedia para este vídeo",
+	"metadata_only":                     "Este servidor solo registra metadatos. Sube el archivo al almacenamiento y regístralo en su lugar.",
+	"missing_content_type":              "Falta el Content-Type",
+	"missing_token":                     "Falta el token de autenticación",
+	"not_found":                         "No encontrado",
+	"notification_channel_not_found":    "Canal de notificaciones no encontrado",
+	"object_check_failed":               "No se pudo comprobar el objeto",
+	"object_empty":                      "El objeto está vacío",
+	"object_key_outside_video":          "La clave del objeto debe estar bajo el prefijo del video",
+	"object_locked":                     "Los archivos del video están bloqueados por S3 Object Lock",
+	"object_not_found":                  "Objeto no encontrado",
+	"part_checksum_mismatch":            "La suma de comprobación de la parte no coincide",
+	"part_read_failed":                  "No se pudo leer la parte",
+	"part_too_large":                    "La parte es demasiado grande",
+	"passphrase_login_required":         "Inicia sesión e introduce la frase de contraseña para ver este vídeo",
+	"passphrase_not_found":              "El vídeo no tiene frase de contraseña",
+	"passphrase_required":               "Introduce la frase de contraseña para ver este vídeo",
+	"payload_read_failed":               "No se pudo leer la carga de prueba",
+	"payload_too_large":                 "La carga de prueba es demasiado grande",
+	"playback_link_invalid":             "El enlace de reproducción no es válido o ha caducado",
+	"playback_referrer_blocked":         "No se permite la reproducción desde este sitio",
+	"playlist_not_found":                "Lista de reproducción no encontrada",
+	"playlist_not_ready":                "La lista de reproducción aún no está disponible",
+	"preset_not_found":                  "Plantilla no encontrada",
+	"probe_failed":                      "No se pudo analizar el archivo de vídeo",
+	"processing_failed":                 "No se pudo procesar el vídeo",
+	"processing_queue_full":             "Hay demasiadas subidas esperando a ser procesadas",
+	"progress_too_frequent":             "El progreso se informa con demasiada frecuencia",
+	"range_not_satisfiable":             "El rango solicitado no está en el archivo de vídeo",
+	"rate_limited":                      "Demasiadas solicitudes, ve más despacio",
+	"rating_locked":                     "La clasificación de este vídeo la fijó un moderador",
+	"refresh_token_expired":             "El token de actualización ha caducado",
+	"refresh_token_revoked":             "El token de actualización ha sido revocado",
+	"regen_job_not_found":               "No se encontró el trabajo de regeneración de miniaturas",
+	"regen_job_running":                 "Ya hay un trabajo de regeneración de miniaturas en curso",
+	"report_details_required":           "Los detalles son obligatorios para el motivo other",
+	"report_not_found":                  "No se encontró la denuncia",
+	"resize_disabled":                   "El redimensionado de imágenes no está configurado",
+	"resize_failed":                     "No se pudo redimensionar la imagen",
+	"s3_object_access_denied":           "El rol de IAM vinculado no puede leer el objeto de S3",
+	"s3_object_not_found":               "Objeto de S3 no encontrado",
+	"s3_source_not_found":               "Origen S3 no encontrado",
+	"s3_source_required":                "Vincula un rol de IAM para importar desde S3",
+	"scan_verdict_not_found":            "No se encontró el veredicto del análisis",
+	"scheduled_task_not_found":          "Tarea programada no encontrada",
+	"scheduled_task_running":            "La tarea programada ya está en curso",
+	"series_forbidden":                  "No tienes permiso para modificar esta serie",
+	"series_not_found":                  "Serie no encontrada",
+	"server_busy":                       "El servidor está ocupado, inténtalo de nuevo en breve",
+	"sftp_ingest_disabled":              "La ingesta por SFTP no está configurada",
+	"sftp_user_not_linked":              "El usuario SFTP no está vinculado a ninguna cuenta",
+	"sftp_username_taken":               "El usuario SFTP está vinculado a otro usuario",
+	"sftp_wrong_bucket":                 "El archivo no está en el bucket de ingesta SFTP",
+	"storage_access_denied":             "El almacenamiento denegó el acceso",
+	"storage_bucket_not_found":          "El bucket de almacenamiento no existe",
+	"storage_entity_too_large":          "El archivo es demasiado grande para el almacenamiento",
+	"storage_migration_not_found":       "Migración de almacenamiento no encontrada",
+	"storage_migration_not_running":     "La migración de almacenamiento no está en curso",
+	"storage_migration_running":         "Ya hay una migración de almacenamiento en curso",
+	"storage_migration_stale":           "El bucket predeterminado ha cambiado desde que empezó la migración de almacenamiento",
+	"storage_migration_switched":        "La migración de almacenamiento ya se completó",
+	"storage_quota_exceeded":            "Se superó la cuota de almacenamiento",
+	"storage_throttled":                 "El almacenamiento está limitando las solicitudes",
+	"storage_timeout":                   "Se agotó el tiempo de espera del almacenamiento",
+	"storage_unavailable":               "El almacenamiento no está disponible en este momento",
+	"storage_unsupported":               "Esto requiere almacenamiento S3",
+	"suspension_reason_required":        "Se requiere un motivo para suspender a un usuario",
+	"sweep_failed":                      "Falló la revisión de enlaces rotos",
+	"thumbnail_candidate_limit":         "Este vídeo ya tiene el número máximo de miniaturas candidatas",
+	"thumbnail_candidate_not_found":     "No se encontró la miniatura candidata",
+	"thumbnail_conversion_failed":       "No se pudo convertir la miniatura HEIC",
+	"thumbnail_not_found":               "No se encontró la miniatura",
+	"thumbnail_unchanged":               "La miniatura no ha cambiado",
+	"thumbnail_variants_disabled":       "Las variantes de miniatura no están configuradas",
+	"timestamp_out_of_range":            "t supera la duración del vídeo",
+	"too_many_custom_metadata_keys":     "Los videos pueden tener hasta 50 claves de metadatos personalizados",
+	"too_many_email_senders":            "Demasiados remitentes",
+	"too_many_notification_channels":    "Demasiados canales de notificaciones",
+	"too_many_notification_rules":       "Demasiadas reglas de notificación",
+	"too_many_passphrase_attempts":      "Demasiados intentos de frase de contraseña, inténtalo más tarde",
+	"too_many_video_ids":                "Demasiados ID de vídeo",
+	"too_many_webhooks":                 "Demasiados webhooks",
+	"unknown_api_version":               "Versión de la API desconocida",
+	"unknown_notification_channel_kind": "Tipo de canal de notificaciones desconocido",
+	"unknown_notification_event":        "Evento de notificación desconocido",
+	"unknown_profile":                   "Perfil de procesamiento desconocido",
+	"unknown_tenant":                    "Inquilino desconocido",
+	"unknown_trending_window":           "Ventana de tendencias desconocida",
+	"unknown_webhook_event":             "Evento de webhook desconocido",
+	"unsupported_chunk_type":            "Los fragmentos deben enviarse como application/offset+octet-stream",
+	"unsupported_event":                 "Evento no admitido",
+	"unsupported_thumbnail_type":        "Tipo de archivo no compatible. Solo se admiten JPEG, PNG y HEIC.",
+	"unsupported_video_type":            "Tipo de archivo no válido. No se admite este tipo de vídeo.",
+	"upload_failed":                     "No se pudo subir el archivo",
+	"upload_id_taken":                   "El ID de la subida ya está en uso",
+	"upload_incomplete":                 "La subida está incompleta",
+	"upload_not_found":                  "Subida no encontrada",
+	"upload_offset_mismatch":            "Upload-Offset no coincide con el desplazamiento almacenado",
+	"upload_protocol_mismatch":          "La sesión de subida no admite este tipo de carga",
+	"upload_session_inactive":           "La sesión de subida ya no está activa",
+	"upload_session_not_found":          "Sesión de subida no encontrada",
+	"upload_size_mismatch":              "El archivo subido no tiene el tamaño declarado",
+	"upload_size_required":              "Se necesita el tamaño de la subida",
+	"upload_too_large":                  "La subida es demasiado grande",
+	"upload_too_slow":                   "La subida es demasiado lenta",
+	"user_not_found":                    "No se encontró el usuario",
+	"video_checksum_missing":            "El vídeo se subió sin suma de verificación",
+	"video_file_exists":                 "El video ya tiene un archivo",
+	"video_file_gone":                   "El archivo de vídeo ya no está disponible",
+	"video_forbidden":                   "No tienes permiso para acceder a este vídeo",
+	"video_has_no_file":                 "El vídeo no tiene archivo",
+	"video_has_no_thumbnail":            "El vídeo no tiene miniatura",
+	"video_ids_required":                "Los ID de vídeo son obligatorios",
+	"video_in_series":                   "El vídeo ya forma parte de una serie",
+	"video_not_found":                   "No se encontró el vídeo",
+	"video_not_in_trash":                "El vídeo no está en la papelera",
+	"video_processing":                  "El video ya se está procesando",
+	"video_unavailable":                 "Este video no está disponible",
+	"warehouse_exports_not_configured":  "Las exportaciones al almacén de datos no están configuradas",
+	"watch_progress_not_found":          "No hay progreso de visualización para este video",
+	"watermark_disabled":                "La reproducción con marca de agua no está activada para este vídeo",
+	"watermark_failed":                  "No se pudo crear la copia con marca de agua",
+	"watermark_text_required":           "Se requiere el texto de la marca de agua",
+	"watermark_text_too_long":           "El texto de la marca de agua es demasiado largo",
+	"webhook_not_found":                 "Webhook no encontrado",
+}
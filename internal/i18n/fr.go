@@ -0,0 +1,277 @@
+package i18n
+
+var fr = map[string]string{
+	"account_suspended":                 "Votre compte est suspendu",
+	"admin_required":                    "Accès administrateur requis",
+	"age_confirmation_required":         "Cette vidéo est soumise à une limite d'âge. Confirmez votre âge pour la regarder",
+	"age_verification_required":         "Une vérification de l'âge est requise pour regarder cette vidéo",
+	"api_key_not_found":                 "Clé d'API introuvable",
+	"append_in_progress":                "Un fragment est déjà en cours d'ajout à ce téléversement",
+	"audio_track_not_found":             "Piste audio introuvable",
+	"backups_not_configured":            "Les sauvegardes de la base de données ne sont pas configurées",
+	"branding_not_found":                "Le locataire n'a pas de personnalisation de marque",
+	"bucket_already_default":            "Ce bucket est déjà le bucket par défaut",
+	"bucket_required":                   "Le bucket et la région sont obligatoires",
+	"cache_webhook_disabled":            "Le webhook de cache n'est pas configuré",
+	"cannot_report_own_video":           "Vous ne pouvez pas signaler votre propre vidéo",
+	"caption_too_large":                 "Le fichier de sous-titres est trop volumineux",
+	"caption_track_not_found":           "Piste de sous-titres introuvable",
+	"cdn_unavailable":                   "Le CDN est momentanément indisponible",
+	"chaos_disabled":                    "Le mode chaos n'est pas activé",
+	"checksum_mismatch":                 "La somme de contrôle de la miniature ne correspond pas",
+	"chunk_exceeds_upload_length":       "Le fragment dépasse la taille du téléversement",
+	"content_type_mismatch":             "Le contenu du fichier ne correspond pas au type déclaré",
+	"credentials_required":              "L'adresse e-mail et le mot de passe sont obligatoires",
+	"custom_metadata_value_too_large":   "Les valeurs de métadonnées personnalisées peuvent faire jusqu'à 1024 octets",
+	"daily_upload_limit":                "Limite quotidienne de téléversements atteinte",
+	"device_token_not_found":            "Jeton d'appareil introuvable",
+	"duplicate_report":                  "Vous avez déjà signalé cette vidéo",
+	"email_address_not_found":           "Adresse d'envoi par e-mail introuvable",
+	"email_ingest_disabled":             "L'envoi par e-mail n'est pas configuré",
+	"empty_part":                        "La partie est vide",
+	"episode_not_found":                 "Épisode introuvable",
+	"episode_number_taken":              "Le numéro d'épisode est déjà utilisé",
+	"external_id_taken":                 "L'identifiant externe est déjà utilisé",
+	"file_rejected":                     "Le fichier a été rejeté par l'analyse de contenu",
+	"fingerprint_failed":                "Impossible de calculer l'empreinte audio du fichier",
+	"frame_extraction_failed":           "Impossible d'extraire l'image",
+	"gif_export_not_found":              "Export GIF introuvable",
+	"gif_export_running":                "Vous avez déjà un export GIF en cours",
+	"hint_contact_admin":                "Le stockage du serveur est mal configuré. Contactez l'administrateur.",
+	"hint_retry_after":                  "Réessayez après le nombre de secondes indiqué dans Retry-After.",
+	"hint_retry_resumable":              "Réessayez. Sur une connexion lente, utilisez une session de téléversement pour pouvoir la reprendre.",
+	"hint_storage_permissions":          "Il manque une autorisation aux identifiants de stockage du serveur. Contactez l'administrateur.",
+	"hint_use_upload_session":           "Envoyez les fichiers de plus de 5 Go avec une session de téléversement.",
+	"impersonation_credentials":         "Les jetons d'usurpation ne peuvent pas gérer les identifiants",
+	"impersonation_forbidden":           "Les administrateurs ne peuvent pas être usurpés",
+	"impersonation_read_only":           "Les jetons d'usurpation ne peuvent pas supprimer",
+	"impersonation_reason_required":     "Un motif est requis pour usurper un utilisateur",
+	"import_checksum_mismatch":          "Le fichier importé ne correspond pas à sa somme de contrôle",
+	"incorrect_passphrase":              "Phrase secrète incorrecte",
+	"ingest_batch_not_found":            "Lot d'ingestion introuvable",
+	"ingest_key_not_found":              "Clé de signature introuvable",
+	"ingest_key_required":               "Créez une clé de signature pour soumettre des manifestes",
+	"internal_error":                    "Une erreur interne s'est produite. Veuillez réessayer",
+	"invalid_api_key":                   "Impossible de valider la clé d'API",
+	"invalid_api_key_id":                "ID de clé d'API invalide",
+	"invalid_bandwidth":                 "Bande passante invalide",
+	"invalid_body":                      "Impossible de lire les paramètres",
+	"invalid_bundle":                    "Le paquet n'est pas une archive zip valide",
+	"invalid_bundle_metadata":           "Impossible de lire metadata.json",
+	"invalid_caption_type":              "Les sous-titres doivent être un fichier .vtt ou .srt",
+	"invalid_captions":                  "Les sous-titres doivent être au format WebVTT ou SRT valide",
+	"invalid_checksum":                  "Somme de contrôle de la miniature invalide",
+	"invalid_color":                     "Les couleurs doivent être des couleurs hexadécimales comme #1a73e8",
+	"invalid_content_rating":            "Classification de contenu inconnue",
+	"invalid_content_type":              "Format de Content-Type invalide",
+	"invalid_created_range":             "created_after et created_before doivent être des dates RFC 3339",
+	"invalid_credentials":               "Adresse e-mail ou mot de passe incorrect",
+	"invalid_cursor":                    "Curseur de pagination invalide",
+	"invalid_custom_metadata":           "Les métadonnées personnalisées doivent être un objet de chaînes",
+	"invalid_custom_metadata_key":       "Clé de métadonnées personnalisées invalide",
+	"invalid_delete_orphans":            "delete_orphans doit valoir true ou false",
+	"invalid_device":                    "L'appareil doit être mobile, tablet, desktop ou tv",
+	"invalid_device_token_id":           "Identifiant de jeton d'appareil invalide",
+	"invalid_email_sender":              "Expéditeur non valide",
+	"invalid_episode_number":            "Numéro d'épisode invalide",
+	"invalid_event_cursor":              "Curseur d'événements invalide",
+	"invalid_event_limit":               "limit doit être compris entre 1 et 1000",
+	"invalid_event_type":                "event doit être impression ou click",
+	"invalid_expiry":                    "expires_in_seconds doit être compris entre 1 et 3600",
+	"invalid_form":                      "Impossible de lire le formulaire",
+	"invalid_gif_duration":              "duration doit être supérieur à 0 et d'au plus 15 secondes",
+	"invalid_gif_fps":                   "fps doit être compris entre 1 et 30",
+	"invalid_gif_start":                 "start doit être un horodatage positif en secondes",
+	"invalid_gif_width":                 "width doit être compris entre 32 et 800",
+	"invalid_hls_msn":                   "_HLS_msn invalide",
+	"invalid_hours":                     "hours doit être compris entre 1 et 168",
+	"invalid_id":                        "ID invalide",
+	"invalid_ingest_key":                "Clé d'ingestion invalide",
+	"invalid_language":                  "La langue doit être un code ISO 639",
+	"invalid_limit":                     "limit doit être compris entre 1 et 500",
+	"invalid_manifest_items":            "Un manifeste doit lister de 1 à 100 fichiers",
+	"invalid_manifest_signature":        "Signature du manifeste non valide",
+	"invalid_manifest_size":             "Chaque fichier doit avoir une taille d'au plus 1 Go",
+	"invalid_manifest_timestamp":        "Horodatage du manifeste non valide",
+	"invalid_mention":                   "Mention invalide",
+	"invalid_notification_channel_id":   "ID de canal de notifications invalide",
+	"invalid_order":                     "order doit être asc ou desc",
+	"invalid_part_checksum":             "Somme de contrôle de la partie invalide",
+	"invalid_part_count":                "Nombre de parties invalide",
+	"invalid_part_number":               "Numéro de partie invalide",
+	"invalid_passphrase":                "La phrase secrète doit faire entre 4 et 72 octets",
+	"invalid_payload_template":          "Modèle de contenu invalide",
+	"invalid_playback_position":         "Position de lecture invalide",
+	"invalid_preset_id":                 "ID de modèle invalide",
+	"invalid_presign_batch":             "video_ids doit contenir de 1 à 100 identifiants",
+	"invalid_recommendation_limit":      "limit doit être compris entre 1 et 100",
+	"invalid_region":                    "Région non valide",
+	"invalid_report_reason":             "Motif de signalement inconnu",
+	"invalid_report_status":             "Statut de signalement inconnu",
+	"invalid_resize_params":             "Paramètres de redimensionnement invalides",
+	"invalid_retry_after":               "retry_after_seconds ne peut pas être négatif",
+	"invalid_role_arn":                  "ARN de rôle non valide",
+	"invalid_s3_object":                 "Bucket ou clé non valide",
+	"invalid_scan_status":               "status doit être clean, flagged ou blocked",
+	"invalid_scope":                     "La portée doit être urls ou all",
+	"invalid_series_id":                 "ID de série invalide",
+	"invalid_sftp_username":             "Nom d'utilisateur SFTP invalide",
+	"invalid_sha256":                    "sha256 doit comporter 64 chiffres hexadécimaux en minuscules",
+	"invalid_signature":                 "Signature invalide",
+	"invalid_sort":                      "sort doit être created_at, updated_at ou title",
+	"invalid_storage_quota":             "Quota de stockage invalide",
+	"invalid_thumbnail_image":           "La miniature n'est pas une image valide",
+	"invalid_timestamp":                 "t doit être un horodatage positif en secondes",
+	"invalid_token":                     "Impossible de valider le jeton",
+	"invalid_track_index":               "Index de piste invalide",
+	"invalid_upload_id":                 "ID d'envoi invalide",
+	"invalid_upload_offset":             "Upload-Offset invalide",
+	"invalid_upload_size":               "Envoyez soit un nombre de parties, soit une taille",
+	"invalid_video_file":                "Le fichier n'est pas une vidéo valide de son type",
+	"invalid_video_id":                  "ID de vidéo invalide",
+	"invalid_visibility":                "visibility doit être public, unlisted ou private",
+	"invalid_warehouse_day":             "day doit être une date passée, AAAA-MM-JJ",
+	"invalid_watermark":                 "Valeur de filigrane invalide",
+	"invalid_watermark_opacity":         "watermark_opacity doit être supérieur à 0 et au plus 1",
+	"invalid_watermark_position":        "watermark_position doit être top-left, top-right, bottom-left ou bottom-right",
+	"invalid_webhook_header":            "En-tête de webhook invalide",
+	"invalid_webhook_id":                "ID de webhook invalide",
+	"invalid_webhook_timestamp":         "Horodatage du webhook invalide",
+	"invalid_webhook_url":               "URL de webhook invalide",
+	"janitor_sweep_running":             "Un nettoyage est déjà en cours",
+	"job_not_found":                     "Aucune tâche de traitement trouvée pour cette vidéo",
+	"keyframes_not_found":               "Aucun index des images clés pour cette vidéo",
+	"legal_hold":                        "Cette vidéo est soumise à une conservation légale",
+	"length_required":                   "Content-Length est requis",
+	"live_capacity_exhausted":           "Aucune capacité disponible pour le direct",
+	"live_ingest_failed":                "Impossible de démarrer le direct",
+	"live_session_forbidden":            "Vous n'avez pas accès à cette session en direct",
+	"live_session_not_found":            "Session en direct introuvable",
+	"logo_not_found":                    "Le locataire n'a pas de logo",
+	"manifest_hash_mismatch":            "Le fichier envoyé ne correspond pas à son manifeste",
+	"media_info_not_found":              "Aucune information média pour cette vidéo",
+	"metadata_only":                     "Ce serveur n'enregistre que les métadonnées. Envoyez le fichier vers le stockage puis enregistrez-le.",
+	"missing_content_type":              "Content-Type manquant",
+	"missing_token":                     "Jeton d'authentification manquant",
+	"not_found":                         "Introuvable",
+	"notification_channel_not_found":    "Canal de notifications introuvable",
+	"object_check_failed":               "Impossible de vérifier l'objet",
+	"object_empty":                      "L'objet est vide",
+	"object_key_outside_video":          "La clé de l'objet doit se trouver sous le préfixe de la vidéo",
+	"object_locked":                     "Les fichiers de la vidéo sont verrouillés par S3 Object Lock",
+	"object_not_found":                  "Objet introuvable",
+	"part_checksum_mismatch":            "La somme de contrôle de la partie ne correspond pas",
+	"part_read_failed":                  "Impossible de lire la partie",
+	"part_too_large":                    "La partie est trop volumineuse",
+	"passphrase_login_required":         "Connectez-vous et saisissez la phrase secrète pour regarder cette vidéo",
+	"passphrase_not_found":              "La vidéo n'a pas de phrase secrète",
+	"passphrase_required":               "Saisissez la phrase secrète pour regarder cette vidéo",
+	"payload_read_failed":               "Impossible de lire la charge de test",
+	"payload_too_large":                 "La charge de test est trop volumineuse",
+	"playback_link_invalid":             "Le lien de lecture est invalide ou a expiré",
+	"playback_referrer_blocked":         "La lecture n'est pas autorisée depuis ce site",
+	"playlist_not_found":                "Playlist introuvable",
+	"playlist_not_ready":                "La playlist n'est pas encore disponible",
+	"preset_not_found":                  "Modèle introuvable",
+	"probe_failed":                      "Impossible d'analyser le fichier vidéo",
+	"processing_failed":                 "Impossible de traiter la vidéo",
+	"processing_queue_full":             "Trop de téléversements attendent d'être traités",
+	"progress_too_frequent":             "Progression signalée trop souvent",
+	"range_not_satisfiable":             "La plage demandée ne fait pas partie du fichier vidéo",
+	"rate_limited":                      "Trop de requêtes, veuillez ralentir",
+	"rating_locked":                     "La classification de cette vidéo a été fixée par un modérateur",
+	"refresh_token_expired":             "Le jeton d'actualisation a expiré",
+	"refresh_token_revoked":             "Le jeton d'actualisation a été révoqué",
+	"regen_job_not_found":               "Tâche de régénération des miniatures introuvable",
+	"regen_job_running":                 "Une tâche de régénération des miniatures est déjà en cours",
+	"report_details_required":           "Les détails sont obligatoires pour le motif other",
+	"report_not_found":                  "Signalement introuvable",
+	"resize_disabled":                   "Le redimensionnement des images n'est pas configuré",
+	"resize_failed":                     "Impossible de redimensionner l'image",
+	"s3_object_access_denied":           "Le rôle IAM associé ne peut pas lire l'objet S3",
+	"s3_object_not_found":               "Objet S3 introuvable",
+	"s3_source_not_found":               "Source S3 introuvable",
+	"s3_source_required":                "Associez un rôle IAM pour importer depuis S3",
+	"scan_verdict_not_found":            "Verdict d'analyse introuvable",
+	"scheduled_task_not_found":          "Tâche planifiée introuvable",
+	"scheduled_task_running":            "La tâche planifiée est déjà en cours",
+	"series_forbidden":                  "Vous n'êtes pas autorisé à modifier cette série",
+	"series_not_found":                  "Série introuvable",
+	"server_busy":                       "Le serveur est occupé, veuillez réessayer sous peu",
+	"sftp_ingest_disabled":              "L'ingestion SFTP n'est pas configurée",
+	"sftp_user_not_linked":              "L'utilisateur SFTP n'est lié à aucun compte",
+	"sftp_username_taken":               "L'utilisateur SFTP est lié à un autre utilisateur",
+	"sftp_wrong_bucket":                 "Le fichier n'est pas dans le bucket d'ingestion SFTP",
+	"storage_access_denied":             "Le stockage a refusé l'accès",
+	"storage_bucket_not_found":          "Le bucket de stockage n'existe pas",
+	"storage_entity_too_large":          "Le fichier est trop volumineux pour le stockage",
+	"storage_migration_not_found":       "Migration du stockage introuvable",
+	"storage_migration_not_running":     "La migration du stockage n'est pas en cours",
+	"storage_migration_running":         "Une migration du stockage est déjà en cours",
+	"storage_migration_stale":           "Le bucket par défaut a changé depuis le début de la migration du stockage",
+	"storage_migration_switched":        "La migration du stockage est déjà terminée",
+	"storage_quota_exceeded":            "Quota de stockage dépassé",
+	"storage_throttled":                 "Le stockage limite les requêtes",
+	"storage_timeout":                   "Le stockage a expiré",
+	"storage_unavailable":               "Le stockage est momentanément indisponible",
+	"storage_unsupported":               "Cela nécessite un stockage S3",
+	"suspension_reason_required":        "Un motif est requis pour suspendre un utilisateur",
+	"sweep_failed":                      "La vérification des liens morts a échoué",
+	"thumbnail_candidate_limit":         "Cette vidéo a déjà le nombre maximal de miniatures candidates",
+	"thumbnail_candidate_not_found":     "Miniature candidate introuvable",
+	"thumbnail_conversion_failed":       "Impossible de convertir la miniature HEIC",
+	"thumbnail_not_found":               "Miniature introuvable",
+	"thumbnail_unchanged":               "La miniature n'a pas changé",
+	"thumbnail_variants_disabled":       "Les variantes de miniature ne sont pas configurées",
+	"timestamp_out_of_range":            "t dépasse la fin de la vidéo",
+	"too_many_custom_metadata_keys":     "Les vidéos peuvent avoir jusqu'à 50 clés de métadonnées personnalisées",
+	"too_many_email_senders":            "Trop d'expéditeurs",
+	"too_many_notification_channels":    "Trop de canaux de notifications",
+	"too_many_notification_rules":       "Trop de règles de notification",
+	"too_many_passphrase_attempts":      "Trop de tentatives de phrase secrète, réessayez plus tard",
+	"too_many_video_ids":                "Trop d'identifiants de vidéo",
+	"too_many_webhooks":                 "Trop de webhooks",
+	"unknown_api_version":               "Version de l'API inconnue",
+	"unknown_notification_channel_kind": "Type de canal de notifications inconnu",
+	"unknown_notification_event":        "Événement de notification inconnu",
+	"unknown_profile":                   "Profil de traitement inconnu",
+	"unknown_tenant":                    "Locataire inconnu",
+	"unknown_trending_window":           "Fenêtre de tendances inconnue",
+	"unknown_webhook_event":             "Événement de webhook inconnu",
+	"unsupported_chunk_type":            "Les fragments doivent être envoyés en application/offset+octet-stream",
+	"unsupported_event":                 "Événement non pris en charge",
+	"unsupported_thumbnail_type":        "Type de fichier non pris en charge. Seuls JPEG, PNG et HEIC sont acceptés.",
+	"unsupported_video_type":            "Type de fichier invalide. Ce type de vidéo n'est pas accepté.",
+	"upload_failed":                     "Impossible d'envoyer le fichier",
+	"upload_id_taken":                   "L'ID d'envoi est déjà utilisé",
+	"upload_incomplete":                 "L'envoi est incomplet",
+	"upload_not_found":                  "Envoi introuvable",
+	"upload_offset_mismatch":            "Upload-Offset ne correspond pas au décalage enregistré",
+	"upload_protocol_mismatch":          "La session de téléversement n'accepte pas ce type d'envoi",
+	"upload_session_inactive":           "La session d'envoi n'est plus active",
+	"upload_session_not_found":          "Session d'envoi introuvable",
+	"upload_size_mismatch":              "Le fichier téléversé n'a pas la taille déclarée",
+	"upload_size_required":              "La taille du téléversement est requise",
+	"upload_too_large":                  "L'envoi est trop volumineux",
+	"upload_too_slow":                   "Le téléversement est trop lent",
+	"user_not_found":                    "Utilisateur introuvable",
+	"video_checksum_missing":            "La vidéo a été envoyée sans somme de contrôle",
+	"video_file_exists":                 "La vidéo a déjà un fichier",
+	"video_file_gone":                   "Le fichier vidéo n'est plus disponible",
+	"video_forbidden":                   "Vous n'êtes pas autorisé à accéder à cette vidéo",
+	"video_has_no_file":                 "La vidéo n'a pas de fichier",
+	"video_has_no_thumbnail":            "La vidéo n'a pas de miniature",
+	"video_ids_required":                "Les identifiants de vidéo sont obligatoires",
+	"video_in_series":                   "La vidéo fait déjà partie d'une série",
+	"video_not_found":                   "Vidéo introuvable",
+	"video_not_in_trash":                "La vidéo n'est pas dans la corbeille",
+	"video_processing":                  "La vidéo est déjà en cours de traitement",
+	"video_unavailable":                 "Cette vidéo n'est pas disponible",
+	"warehouse_exports_not_configured":  "Les exports vers l'entrepôt de données ne sont pas configurés",
+	"watch_progress_not_found":          "Aucune progression de lecture pour cette vidéo",
+	"watermark_disabled":                "La lecture avec filigrane n'est pas activée pour cette vidéo",
+	"watermark_failed":                  "Impossible de créer la copie avec filigrane",
+	"watermark_text_required":           "Le texte du filigrane est requis",
+	"watermark_text_too_long":           "Le texte du filigrane est trop long",
+	"webhook_not_found":                 "Webhook introuvable",
+}
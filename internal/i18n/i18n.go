@@ -0,0 +1,77 @@
+// Package i18n localizes user-facing API error messages.
+//
+// Handlers keep writing their messages in English. The English text is the
+// message ID: it maps to a stable machine-readable code, and the code maps
+// to a translation in each catalog. Clients should branch on the code, which
+// doesn't change with the language or with rewording of the English text.
+package i18n
+
+import (
+	"net/http"
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+// Default is the language used when the client doesn't ask for a supported
+// one.
+const Default = "en"
+
+var catalogs = map[string]map[string]string{
+	"es": es,
+	"fr": fr,
+}
+
+var matcher = language.NewMatcher([]language.Tag{
+	language.English,
+	language.Spanish,
+	language.French,
+})
+
+// Negotiate picks the supported language that best matches an
+// Accept-Language header, falling back to Default.
+func Negotiate(acceptLanguage string) string {
+	if acceptLanguage == "" {
+		return Default
+	}
+	tags, _, err := language.ParseAcceptLanguage(acceptLanguage)
+	if err != nil || len(tags) == 0 {
+		return Default
+	}
+	tag, _, confidence := matcher.Match(tags...)
+	if confidence == language.No {
+		return Default
+	}
+	base, _ := tag.Base()
+	if _, ok := catalogs[base.String()]; !ok {
+		return Default
+	}
+	return base.String()
+}
+
+// Code returns the stable code for an English message. Messages without one,
+// such as validation errors built at runtime, get a code for their HTTP
+// status instead.
+func Code(msg string, status int) string {
+	if code, ok := codes[msg]; ok {
+		return code
+	}
+	text := http.StatusText(status)
+	if text == "" {
+		return "error"
+	}
+	return strings.ReplaceAll(strings.ToLower(text), " ", "_")
+}
+
+// Translate returns msg in lang, or msg itself if the catalog has no
+// translation for it.
+func Translate(lang, msg string) string {
+	code, ok := codes[msg]
+	if !ok {
+		return msg
+	}
+	if translated, ok := catalogs[lang][code]; ok {
+		return translated
+	}
+	return msg
+}
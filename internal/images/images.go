@@ -0,0 +1,114 @@
+// Package images decodes, downscales and encodes thumbnails in Go, so the
+// common JPEG and PNG cases don't need an ffmpeg process per size.
+package images
+
+import (
+	"errors"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"math"
+)
+
+// MaxPixels caps the size of the images Decode takes on. A small, highly
+// compressed file can decode to gigabytes of pixels.
+const MaxPixels = 40_000_000
+
+// JPEGQuality is the quality JPEG variants are encoded at.
+const JPEGQuality = 85
+
+// ErrTooLarge is returned by Decode for images over MaxPixels.
+var ErrTooLarge = errors.New("image is too large to decode")
+
+// Decode decodes a JPEG or PNG image from r, which it reads twice: once for
+// the header, to check the size, and once for the pixels.
+func Decode(r io.ReadSeeker) (image.Image, string, error) {
+	config, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil, "", err
+	}
+	if config.Width*config.Height > MaxPixels {
+		return nil, "", ErrTooLarge
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, "", err
+	}
+	return image.Decode(r)
+}
+
+// Resize scales img down to width pixels wide, keeping its aspect ratio.
+// Each output pixel is the average of the source pixels it covers, so fine
+// detail doesn't alias the way it does with point sampling. img is
+// returned as is if it is no wider than width.
+func Resize(img image.Image, width int) image.Image {
+	b := img.Bounds()
+	if width <= 0 || width >= b.Dx() {
+		return img
+	}
+	height := max(1, int(math.Round(float64(b.Dy())*float64(width)/float64(b.Dx()))))
+
+	src := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+
+	// Scale each row to the new width, then each column of that to the
+	// new height.
+	cols := boxWeights(b.Dx(), width)
+	rows := boxWeights(b.Dy(), height)
+	mid := make([]float32, width*b.Dy()*4)
+	for y := 0; y < b.Dy(); y++ {
+		in := src.Pix[y*src.Stride:]
+		out := mid[y*width*4:]
+		for x, ws := range cols {
+			var px [4]float32
+			for _, w := range ws {
+				for c := range px {
+					px[c] += float32(in[w.index*4+c]) * w.weight
+				}
+			}
+			copy(out[x*4:], px[:])
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y, ws := range rows {
+		out := dst.Pix[y*dst.Stride:]
+		for x := 0; x < width*4; x++ {
+			var v float32
+			for _, w := range ws {
+				v += mid[w.index*width*4+x] * w.weight
+			}
+			out[x] = uint8(min(max(v+0.5, 0), 255))
+		}
+	}
+	return dst
+}
+
+type boxWeight struct {
+	index  int
+	weight float32
+}
+
+// boxWeights returns, for each of the to output pixels along an axis of
+// from source pixels, the source pixels it covers and how much of it each
+// one makes up.
+func boxWeights(from, to int) [][]boxWeight {
+	scale := float64(from) / float64(to)
+	weights := make([][]boxWeight, to)
+	for i := range weights {
+		start, end := float64(i)*scale, float64(i+1)*scale
+		for j := int(start); j < from && float64(j) < end; j++ {
+			overlap := min(end, float64(j+1)) - max(start, float64(j))
+			if overlap > 0 {
+				weights[i] = append(weights[i], boxWeight{index: j, weight: float32(overlap / scale)})
+			}
+		}
+	}
+	return weights
+}
+
+// EncodeJPEG writes img to w as a JPEG at JPEGQuality.
+func EncodeJPEG(w io.Writer, img image.Image) error {
+	return jpeg.Encode(w, img, &jpeg.Options{Quality: JPEGQuality})
+}
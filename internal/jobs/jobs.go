@@ -0,0 +1,347 @@
+package jobs
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/cdn"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/clock"
+	"github.com/google/uuid"
+)
+
+type Status string
+
+const (
+	StatusQueued     Status = "queued"
+	StatusProcessing Status = "processing"
+	StatusDone       Status = "done"
+	StatusFailed     Status = "failed"
+)
+
+// defaultSecondsPerMediaMinute seeds the estimator before any job has
+// finished.
+const defaultSecondsPerMediaMinute = 10.0
+
+// rateSmoothing is the weight given to the most recent job when updating
+// the historical processing rate.
+const rateSmoothing = 0.2
+
+// finishedRetention is how long a finished job's status is kept once no
+// newer job for its video has replaced it.
+const finishedRetention = time.Hour
+
+var ErrJobNotFound = errors.New("job not found")
+
+type Job struct {
+	ID      uuid.UUID `json:"id"`
+	VideoID uuid.UUID `json:"video_id"`
+	// Kind tells work on a video other than processing its upload apart,
+	// and is empty for uploads.
+	Kind         string     `json:"kind,omitempty"`
+	Status       Status     `json:"status"`
+	MediaSeconds float64    `json:"media_seconds"`
+	EnqueuedAt   time.Time  `json:"enqueued_at"`
+	StartedAt    *time.Time `json:"started_at,omitempty"`
+	FinishedAt   *time.Time `json:"finished_at,omitempty"`
+	Error        string     `json:"error,omitempty"`
+	// Invalidations are the CDN invalidations issued for assets the job's
+	// outputs replaced.
+	Invalidations []cdn.Invalidation `json:"invalidations,omitempty"`
+}
+
+// Estimate describes where a job sits in the queue and how long it is
+// expected to take.
+type Estimate struct {
+	Job
+	QueuePosition         int     `json:"queue_position"`
+	EstimatedWaitSeconds  float64 `json:"estimated_wait_seconds"`
+	EstimatedTotalSeconds float64 `json:"estimated_total_seconds"`
+	// Progress is how far along the job is, from 0 to 1, going by the
+	// estimate while it runs.
+	Progress float64 `json:"progress"`
+}
+
+// Queue runs processing work on a fixed number of workers and keeps enough
+// history to estimate how long queued work will take.
+//
+// Uploads are admitted before they're received, so a burst of them can be
+// turned away up front instead of all being spooled to disk to wait for a
+// worker: with maxWaiting set, up to workers+maxWaiting uploads are taken
+// in at once, counting from when they start coming in until their
+// processing ends.
+type Queue struct {
+	mu         sync.Mutex
+	workers    int
+	maxWaiting int
+	admitted   int
+	slots      chan struct{}
+	pending    []*Job
+	running    []*Job
+	byVideo    map[jobKey]*Job
+	clock      clock.Clock
+
+	secondsPerMediaMinute float64
+}
+
+// NewQueue returns a queue with workers workers that times its jobs by c.
+// maxWaiting caps the uploads admitted beyond those the workers can run at
+// once; 0 admits every upload.
+func NewQueue(workers, maxWaiting int, c clock.Clock) *Queue {
+	if workers < 1 {
+		workers = 1
+	}
+	return &Queue{
+		workers:               workers,
+		maxWaiting:            maxWaiting,
+		slots:                 make(chan struct{}, workers),
+		byVideo:               map[jobKey]*Job{},
+		clock:                 c,
+		secondsPerMediaMinute: defaultSecondsPerMediaMinute,
+	}
+}
+
+// jobKey is what the latest job of a kind is kept for a video under.
+type jobKey struct {
+	kind    string
+	videoID uuid.UUID
+}
+
+// Run enqueues fn for videoID, blocks until a worker slot is available,
+// runs it, and records the outcome. mediaSeconds is the duration of the
+// input and is used to estimate processing time.
+func (q *Queue) Run(videoID uuid.UUID, mediaSeconds float64, fn func() error) error {
+	return q.RunKind("", videoID, mediaSeconds, fn)
+}
+
+// RunKind is Run for work of the given kind on videoID, which is kept
+// apart from the video's uploads, so it doesn't replace their status.
+func (q *Queue) RunKind(kind string, videoID uuid.UUID, mediaSeconds float64, fn func() error) error {
+	job := &Job{
+		ID:           uuid.New(),
+		VideoID:      videoID,
+		Kind:         kind,
+		Status:       StatusQueued,
+		MediaSeconds: mediaSeconds,
+		EnqueuedAt:   q.clock.Now().UTC(),
+	}
+
+	q.mu.Lock()
+	q.evictFinished(job.EnqueuedAt)
+	q.pending = append(q.pending, job)
+	q.byVideo[jobKey{kind, videoID}] = job
+	q.mu.Unlock()
+
+	q.slots <- struct{}{}
+	defer func() { <-q.slots }()
+
+	q.mu.Lock()
+	q.pending = removeJob(q.pending, job)
+	q.running = append(q.running, job)
+	job.Status = StatusProcessing
+	started := q.clock.Now().UTC()
+	job.StartedAt = &started
+	q.mu.Unlock()
+
+	err := fn()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.running = removeJob(q.running, job)
+	finished := q.clock.Now().UTC()
+	job.FinishedAt = &finished
+	if err != nil {
+		job.Status = StatusFailed
+		job.Error = err.Error()
+		return err
+	}
+	job.Status = StatusDone
+	q.recordRate(job)
+	return nil
+}
+
+// Status returns the latest upload job for videoID along with its queue
+// position and estimated wait. Finished jobs are swept when a job is run
+// more than finishedRetention after they finished; until then, Status
+// still returns them.
+func (q *Queue) Status(videoID uuid.UUID) (Estimate, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job, ok := q.byVideo[jobKey{videoID: videoID}]
+	if !ok {
+		return Estimate{}, ErrJobNotFound
+	}
+
+	est := Estimate{
+		Job:                   *job,
+		EstimatedTotalSeconds: q.estimate(job),
+	}
+	est.Invalidations = slices.Clone(job.Invalidations)
+
+	switch job.Status {
+	case StatusQueued:
+		ahead := 0.0
+		for _, running := range q.running {
+			ahead += q.remaining(running)
+		}
+		for i, pending := range q.pending {
+			if pending == job {
+				est.QueuePosition = i + 1
+				break
+			}
+			ahead += q.estimate(pending)
+		}
+		est.EstimatedWaitSeconds = ahead/float64(q.workers) + est.EstimatedTotalSeconds
+	case StatusProcessing:
+		est.EstimatedWaitSeconds = q.remaining(job)
+		elapsed := q.clock.Now().Sub(*job.StartedAt).Seconds()
+		if total := elapsed + est.EstimatedWaitSeconds; total > 0 {
+			// A job that outruns its estimate isn't done until it is.
+			est.Progress = min(elapsed/total, 0.99)
+		}
+	case StatusDone:
+		est.Progress = 1
+	}
+	return est, nil
+}
+
+// RecordInvalidation adds inv to the latest upload job for videoID, or
+// replaces the one with the same ID. It reports whether the video has a
+// job to record it on.
+func (q *Queue) RecordInvalidation(videoID uuid.UUID, inv cdn.Invalidation) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job, ok := q.byVideo[jobKey{videoID: videoID}]
+	if !ok {
+		return false
+	}
+	for i, existing := range job.Invalidations {
+		if inv.ID != "" && existing.ID == inv.ID {
+			job.Invalidations[i] = inv
+			return true
+		}
+	}
+	job.Invalidations = append(job.Invalidations, inv)
+	return true
+}
+
+// Admit takes in an upload if there's room for it, returning the func
+// that gives its place back once it has been processed, or failed. The
+// release func can be called more than once.
+func (q *Queue) Admit() (release func(), ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.maxWaiting > 0 && q.admitted >= q.workers+q.maxWaiting {
+		return nil, false
+	}
+	q.admitted++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			q.mu.Lock()
+			q.admitted--
+			q.mu.Unlock()
+		})
+	}, true
+}
+
+// Admitted returns the number of uploads admitted and not yet processed,
+// and how many can be at once, or 0 if there's no limit.
+func (q *Queue) Admitted() (admitted, limit int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.maxWaiting > 0 {
+		limit = q.workers + q.maxWaiting
+	}
+	return q.admitted, limit
+}
+
+// Wait estimates how long a job queued now would wait for a worker.
+func (q *Queue) Wait() time.Duration {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	ahead := 0.0
+	for _, running := range q.running {
+		ahead += q.remaining(running)
+	}
+	for _, pending := range q.pending {
+		ahead += q.estimate(pending)
+	}
+	return time.Duration(ahead / float64(q.workers) * float64(time.Second))
+}
+
+// Depth returns the number of jobs waiting for a worker.
+func (q *Queue) Depth() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending)
+}
+
+// Oldest returns how long the job that has been in the queue longest,
+// waiting or running, has been in it, or 0 if there are none.
+func (q *Queue) Oldest() time.Duration {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var oldest time.Time
+	for _, list := range [][]*Job{q.running, q.pending} {
+		for _, job := range list {
+			if oldest.IsZero() || job.EnqueuedAt.Before(oldest) {
+				oldest = job.EnqueuedAt
+			}
+		}
+	}
+	if oldest.IsZero() {
+		return 0
+	}
+	return q.clock.Now().Sub(oldest)
+}
+
+// InFlight returns the number of jobs that are queued or running.
+func (q *Queue) InFlight() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending) + len(q.running)
+}
+
+// evictFinished forgets the jobs that finished more than finishedRetention
+// before now. The caller holds q.mu.
+func (q *Queue) evictFinished(now time.Time) {
+	for key, job := range q.byVideo {
+		if job.FinishedAt != nil && now.Sub(*job.FinishedAt) > finishedRetention {
+			delete(q.byVideo, key)
+		}
+	}
+}
+
+func (q *Queue) estimate(job *Job) float64 {
+	return job.MediaSeconds / 60 * q.secondsPerMediaMinute
+}
+
+func (q *Queue) remaining(job *Job) float64 {
+	left := q.estimate(job) - q.clock.Now().Sub(*job.StartedAt).Seconds()
+	if left < 0 {
+		return 0
+	}
+	return left
+}
+
+func (q *Queue) recordRate(job *Job) {
+	if job.MediaSeconds <= 0 {
+		return
+	}
+	took := job.FinishedAt.Sub(*job.StartedAt).Seconds()
+	rate := took / (job.MediaSeconds / 60)
+	q.secondsPerMediaMinute = rateSmoothing*rate + (1-rateSmoothing)*q.secondsPerMediaMinute
+}
+
+func removeJob(list []*Job, job *Job) []*Job {
+	for i, j := range list {
+		if j == job {
+			return append(list[:i], list[i+1:]...)
+		}
+	}
+	return list
+}
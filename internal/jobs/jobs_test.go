@@ -0,0 +1,125 @@
+package jobs
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/clock"
+	"github.com/google/uuid"
+)
+
+func TestQueueTimesJobsByItsClock(t *testing.T) {
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	c := clock.NewFake(start)
+	q := NewQueue(1, 0, c)
+	videoID := uuid.New()
+
+	err := q.Run(videoID, 60, func() error {
+		c.Advance(30 * time.Second)
+		est, err := q.Status(videoID)
+		if err != nil {
+			t.Fatalf("Status while running: %v", err)
+		}
+		if est.Status != StatusProcessing {
+			t.Errorf("Status while running = %s, want %s", est.Status, StatusProcessing)
+		}
+		if got := q.Oldest(); got != 30*time.Second {
+			t.Errorf("Oldest() = %v, want 30s", got)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	est, err := q.Status(videoID)
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	if !est.EnqueuedAt.Equal(start) {
+		t.Errorf("EnqueuedAt = %v, want %v", est.EnqueuedAt, start)
+	}
+	if want := start.Add(30 * time.Second); est.FinishedAt == nil || !est.FinishedAt.Equal(want) {
+		t.Errorf("FinishedAt = %v, want %v", est.FinishedAt, want)
+	}
+}
+
+func TestQueueForgetsFinishedJobs(t *testing.T) {
+	tests := []struct {
+		name    string
+		after   time.Duration
+		fail    bool
+		wantErr error
+	}{
+		{name: "done, within retention", after: finishedRetention},
+		{name: "failed, within retention", after: finishedRetention, fail: true},
+		{name: "done, past retention", after: finishedRetention + time.Second, wantErr: ErrJobNotFound},
+		{name: "failed, past retention", after: finishedRetention + time.Second, fail: true, wantErr: ErrJobNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := clock.NewFake(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+			q := NewQueue(1, 0, c)
+			videoID := uuid.New()
+			q.Run(videoID, 0, func() error {
+				if tt.fail {
+					return errors.New("encode failed")
+				}
+				return nil
+			})
+
+			c.Advance(tt.after)
+			// Finished jobs are only swept when another one is queued.
+			q.Run(uuid.New(), 0, func() error { return nil })
+
+			if _, err := q.Status(videoID); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Status() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestQueueKeepsLatestJobPerVideo(t *testing.T) {
+	c := clock.NewFake(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	q := NewQueue(1, 0, c)
+	videoID := uuid.New()
+
+	q.Run(videoID, 0, func() error { return errors.New("encode failed") })
+	q.Run(videoID, 0, func() error { return nil })
+
+	est, err := q.Status(videoID)
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	if est.Status != StatusDone {
+		t.Errorf("Status = %s, want %s", est.Status, StatusDone)
+	}
+	if len(q.byVideo) != 1 {
+		t.Errorf("queue remembers %d jobs, want 1", len(q.byVideo))
+	}
+}
+
+func TestQueueKeepsKindsApart(t *testing.T) {
+	c := clock.NewFake(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	q := NewQueue(1, 0, c)
+	videoID := uuid.New()
+
+	q.Run(videoID, 60, func() error { return nil })
+	q.RunKind("hls-backfill", videoID, 30, func() error { return errors.New("encode failed") })
+	q.RunKind("hls-backfill", videoID, 30, func() error { return nil })
+
+	est, err := q.Status(videoID)
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	if est.Kind != "" || est.MediaSeconds != 60 {
+		t.Errorf("Status = %+v, want the upload's job", est.Job)
+	}
+	if len(q.byVideo) != 2 {
+		t.Errorf("queue remembers %d jobs, want one of each kind", len(q.byVideo))
+	}
+	if job := q.byVideo[jobKey{"hls-backfill", videoID}]; job == nil || job.Status != StatusDone || job.MediaSeconds != 30 {
+		t.Errorf("latest backfill job = %+v, want the one that succeeded", job)
+	}
+}
@@ -0,0 +1,104 @@
+// Package kms is a minimal client for the KMS JSON protocol, covering the
+// one call the server makes: decrypting the data keys that encrypt
+// database fields.
+package kms
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+// Client calls KMS in one region.
+type Client struct {
+	Endpoint    string
+	Region      string
+	Credentials aws.CredentialsProvider
+	HTTPClient  *http.Client
+
+	signer *v4.Signer
+}
+
+// New returns a client for KMS in region. endpoint defaults to the
+// region's KMS endpoint; it's set for local stand-ins.
+func New(region, endpoint string, credentials aws.CredentialsProvider) *Client {
+	if endpoint == "" {
+		endpoint = "https://kms." + region + ".amazonaws.com/"
+	}
+	return &Client{
+		Endpoint:    endpoint,
+		Region:      region,
+		Credentials: credentials,
+		HTTPClient:  &http.Client{Timeout: 10 * time.Second},
+		signer:      v4.NewSigner(),
+	}
+}
+
+// Decrypt decrypts a ciphertext blob, such as the CiphertextBlob of a data
+// key from GenerateDataKey. KMS knows which key it was encrypted with.
+func (c *Client) Decrypt(ctx context.Context, blob []byte) ([]byte, error) {
+	var out struct {
+		// []byte fields are base64 in JSON, as KMS has them.
+		Plaintext []byte `json:"Plaintext"`
+	}
+	err := c.call(ctx, "Decrypt", map[string]any{"CiphertextBlob": blob}, &out)
+	return out.Plaintext, err
+}
+
+// Error is an error KMS responded with.
+type Error struct {
+	StatusCode int
+	Type       string `json:"__type"`
+	Message    string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("kms: %d %s: %s", e.StatusCode, e.Type, e.Message)
+}
+
+func (c *Client) call(ctx context.Context, action string, input, output any) error {
+	body, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService."+action)
+
+	creds, err := c.Credentials.Retrieve(ctx)
+	if err != nil {
+		return err
+	}
+	hash := sha256.Sum256(body)
+	if err := c.signer.SignHTTP(ctx, creds, req, hex.EncodeToString(hash[:]), "kms", c.Region, time.Now()); err != nil {
+		return err
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		e := &Error{StatusCode: resp.StatusCode}
+		json.Unmarshal(data, e)
+		return e
+	}
+	return json.Unmarshal(data, output)
+}
@@ -0,0 +1,223 @@
+package live
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ffmpeg"
+	"github.com/google/uuid"
+)
+
+type Status string
+
+const (
+	StatusWaiting    Status = "waiting"
+	StatusLive       Status = "live"
+	StatusFinalizing Status = "finalizing"
+	StatusDone       Status = "done"
+	StatusFailed     Status = "failed"
+)
+
+// PlaylistName is the HLS playlist written into each session directory.
+const PlaylistName = "index.m3u8"
+
+var (
+	ErrNoFreePorts     = errors.New("no free ingest ports")
+	ErrSessionNotFound = errors.New("live session not found")
+)
+
+type Session struct {
+	ID        uuid.UUID  `json:"id"`
+	VideoID   uuid.UUID  `json:"video_id"`
+	UserID    uuid.UUID  `json:"user_id"`
+	IngestURL string     `json:"ingest_url"`
+	Status    Status     `json:"status"`
+	CreatedAt time.Time  `json:"created_at"`
+	EndedAt   *time.Time `json:"ended_at,omitempty"`
+	Error     string     `json:"error,omitempty"`
+
+	port   int
+	dir    string
+	cancel context.CancelFunc
+}
+
+// Dir is the local directory holding the session's HLS segments.
+func (s Session) Dir() string {
+	return s.dir
+}
+
+// FinalizeFunc turns a finished recording into a regular video. playlistPath
+// points at the complete HLS event playlist.
+type FinalizeFunc func(ctx context.Context, s Session, playlistPath string) error
+
+type Config struct {
+	// PublicHost is the host name streamers connect to.
+	PublicHost string
+	// BasePort is the first RTMP port handed out; MaxStreams ports are used.
+	BasePort       int
+	MaxStreams     int
+	SegmentSeconds int
+	// LowLatency writes short fMP4 segments and advertises blocking
+	// playlist reload so players can stay close to the live edge.
+	LowLatency bool
+	// Root is where session directories are created.
+	Root string
+}
+
+// Manager runs one ffmpeg RTMP listener per live session.
+type Manager struct {
+	mu       sync.Mutex
+	cfg      Config
+	finalize FinalizeFunc
+	sessions map[uuid.UUID]*Session
+	ports    map[int]bool
+}
+
+func NewManager(cfg Config, finalize FinalizeFunc) (*Manager, error) {
+	if cfg.SegmentSeconds <= 0 {
+		cfg.SegmentSeconds = 4
+		if cfg.LowLatency {
+			cfg.SegmentSeconds = 1
+		}
+	}
+	if err := os.MkdirAll(cfg.Root, 0755); err != nil {
+		return nil, err
+	}
+	return &Manager{
+		cfg:      cfg,
+		finalize: finalize,
+		sessions: map[uuid.UUID]*Session{},
+		ports:    map[int]bool{},
+	}, nil
+}
+
+// Start reserves a port and starts listening for a publisher for videoID.
+func (m *Manager) Start(userID, videoID uuid.UUID) (Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	port := 0
+	for p := m.cfg.BasePort; p < m.cfg.BasePort+m.cfg.MaxStreams; p++ {
+		if !m.ports[p] {
+			port = p
+			break
+		}
+	}
+	if port == 0 {
+		return Session{}, ErrNoFreePorts
+	}
+
+	keyBytes := make([]byte, 16)
+	if _, err := rand.Read(keyBytes); err != nil {
+		return Session{}, err
+	}
+	streamKey := hex.EncodeToString(keyBytes)
+
+	s := &Session{
+		ID:        uuid.New(),
+		VideoID:   videoID,
+		UserID:    userID,
+		IngestURL: fmt.Sprintf("rtmp://%s:%d/live/%s", m.cfg.PublicHost, port, streamKey),
+		Status:    StatusWaiting,
+		CreatedAt: time.Now().UTC(),
+		port:      port,
+	}
+	s.dir = filepath.Join(m.cfg.Root, s.ID.String())
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return Session{}, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	listenURL := fmt.Sprintf("rtmp://0.0.0.0:%d/live/%s", port, streamKey)
+	cmd, err := ffmpeg.LiveIngestCommand(listenURL, filepath.Join(s.dir, PlaylistName), m.cfg.SegmentSeconds, m.cfg.LowLatency).Build(ctx)
+	if err != nil {
+		cancel()
+		return Session{}, err
+	}
+	// Interrupt rather than kill so ffmpeg closes the playlist cleanly.
+	cmd.Cancel = func() error { return cmd.Process.Signal(os.Interrupt) }
+	cmd.WaitDelay = 10 * time.Second
+	if err := cmd.Start(); err != nil {
+		cancel()
+		return Session{}, err
+	}
+	s.cancel = cancel
+
+	m.ports[port] = true
+	m.sessions[s.ID] = s
+	go m.wait(s, cmd.Wait)
+	return *s, nil
+}
+
+func (m *Manager) wait(s *Session, wait func() error) {
+	waitErr := wait()
+
+	m.mu.Lock()
+	delete(m.ports, s.port)
+	ended := time.Now().UTC()
+	s.EndedAt = &ended
+	playlist := filepath.Join(s.dir, PlaylistName)
+	if _, err := os.Stat(playlist); err != nil {
+		s.Status = StatusFailed
+		s.Error = "no stream was received"
+		if waitErr != nil {
+			s.Error = fmt.Sprintf("%s: %v", s.Error, waitErr)
+		}
+		m.mu.Unlock()
+		os.RemoveAll(s.dir)
+		return
+	}
+	s.Status = StatusFinalizing
+	snapshot := *s
+	m.mu.Unlock()
+
+	err := m.finalize(context.Background(), snapshot, playlist)
+
+	m.mu.Lock()
+	if err != nil {
+		log.Printf("Couldn't finalize live session %s: %v", s.ID, err)
+		s.Status = StatusFailed
+		s.Error = err.Error()
+	} else {
+		s.Status = StatusDone
+	}
+	m.mu.Unlock()
+	os.RemoveAll(s.dir)
+}
+
+// Get returns the session with id. A waiting session is reported as live once
+// its first segment has been written.
+func (m *Manager) Get(id uuid.UUID) (Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return Session{}, ErrSessionNotFound
+	}
+	if s.Status == StatusWaiting {
+		if _, err := os.Stat(filepath.Join(s.dir, PlaylistName)); err == nil {
+			s.Status = StatusLive
+		}
+	}
+	return *s, nil
+}
+
+// Stop ends the session. If anything was recorded it is finalized as usual.
+func (m *Manager) Stop(id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return ErrSessionNotFound
+	}
+	s.cancel()
+	return nil
+}
@@ -0,0 +1,95 @@
+package live
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// playlistState is what a client needs to know to decide whether a playlist
+// is new enough: the sequence number of the last segment and the target
+// segment duration.
+type playlistState struct {
+	lastSequence   int
+	targetDuration float64
+	ended          bool
+}
+
+func parsePlaylist(data []byte) playlistState {
+	state := playlistState{lastSequence: -1}
+	mediaSequence, segments := 0, 0
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"):
+			mediaSequence, _ = strconv.Atoi(strings.TrimPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"))
+		case strings.HasPrefix(line, "#EXT-X-TARGETDURATION:"):
+			state.targetDuration, _ = strconv.ParseFloat(strings.TrimPrefix(line, "#EXT-X-TARGETDURATION:"), 64)
+		case line == "#EXT-X-ENDLIST":
+			state.ended = true
+		case line != "" && !strings.HasPrefix(line, "#"):
+			segments++
+		}
+	}
+	state.lastSequence = mediaSequence + segments - 1
+	return state
+}
+
+// addServerControl advertises blocking playlist reload support right after
+// the #EXTM3U header.
+func addServerControl(data []byte, holdBack float64) []byte {
+	header := []byte("#EXTM3U\n")
+	if !bytes.HasPrefix(data, header) {
+		return data
+	}
+	control := fmt.Sprintf("#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,HOLD-BACK=%.1f\n", holdBack)
+	out := make([]byte, 0, len(data)+len(control))
+	out = append(out, header...)
+	out = append(out, control...)
+	return append(out, data[len(header):]...)
+}
+
+// ReadPlaylist returns the session's playlist. If msn is not negative it
+// blocks until the playlist contains media sequence number msn (the LL-HLS
+// _HLS_msn directive), the stream ends, or about three target durations
+// pass.
+func (m *Manager) ReadPlaylist(ctx context.Context, s Session, msn int) ([]byte, error) {
+	path := filepath.Join(s.dir, PlaylistName)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	state := parsePlaylist(data)
+
+	if msn >= 0 && state.lastSequence < msn && !state.ended {
+		wait := time.Duration(3*state.targetDuration*float64(time.Second)) + time.Second
+		ctx, cancel := context.WithTimeout(ctx, wait)
+		defer cancel()
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+	poll:
+		for state.lastSequence < msn && !state.ended {
+			select {
+			case <-ctx.Done():
+				break poll
+			case <-ticker.C:
+				if next, err := os.ReadFile(path); err == nil {
+					data = next
+					state = parsePlaylist(data)
+				}
+			}
+		}
+	}
+
+	if m.cfg.LowLatency {
+		data = addServerControl(data, 3*state.targetDuration)
+	}
+	return data, nil
+}
@@ -0,0 +1,322 @@
+// Package metrics keeps counters, gauges and histograms and writes them in
+// the Prometheus text exposition format, for scraping from /metrics.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are histogram buckets in seconds for things that take
+// from milliseconds to minutes, such as requests and ffmpeg runs.
+var DefaultBuckets = []float64{0.005, 0.025, 0.1, 0.5, 1, 2.5, 10, 30, 60, 300, 900}
+
+// Registry holds metrics in the order they were registered.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+// WriteText writes every metric in the text exposition format.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+	return bw.Flush()
+}
+
+// vec is the series of a metric, one per combination of label values.
+type vec[T any] struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	mu     sync.Mutex
+	series map[string]*T
+	values map[string][]string
+	newT   func() *T
+}
+
+func newVec[T any](name, help, kind string, labels []string, newT func() *T) *vec[T] {
+	return &vec[T]{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		series: map[string]*T{},
+		values: map[string][]string{},
+		newT:   newT,
+	}
+}
+
+// with returns the series for labelValues, which must be one value per
+// label, creating it if needed.
+func (v *vec[T]) with(labelValues []string) *T {
+	if len(labelValues) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", v.name, len(v.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	s, ok := v.series[key]
+	if !ok {
+		s = v.newT()
+		v.series[key] = s
+		v.values[key] = append([]string(nil), labelValues...)
+	}
+	return s
+}
+
+// each calls fn for every series, sorted by label values, with its labels
+// rendered.
+func (v *vec[T]) each(fn func(labels []string, values []string, s *T)) {
+	v.mu.Lock()
+	keys := make([]string, 0, len(v.series))
+	for k := range v.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	series := make([]*T, len(keys))
+	values := make([][]string, len(keys))
+	for i, k := range keys {
+		series[i], values[i] = v.series[k], v.values[k]
+	}
+	v.mu.Unlock()
+	for i := range keys {
+		fn(v.labels, values[i], series[i])
+	}
+}
+
+func (v *vec[T]) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.name, helpEscaper.Replace(v.help), v.name, v.kind)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+// formatLabels renders {name="value",...}, with extra appended, or ""
+// when there are none.
+func formatLabels(names, values []string, extra ...string) string {
+	if len(names) == 0 && len(extra) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(names)+len(extra)/2)
+	for i, name := range names {
+		parts = append(parts, name+`="`+labelEscaper.Replace(values[i])+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		parts = append(parts, extra[i]+`="`+labelEscaper.Replace(extra[i+1])+`"`)
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatValue(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+type value struct {
+	mu sync.Mutex
+	v  float64
+}
+
+func (v *value) add(d float64) {
+	v.mu.Lock()
+	v.v += d
+	v.mu.Unlock()
+}
+
+func (v *value) get() float64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.v
+}
+
+// Counter is a value that only goes up, such as a number of requests.
+type Counter struct {
+	vec *vec[value]
+}
+
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	c := &Counter{vec: newVec(name, help, "counter", labels, func() *value { return &value{} })}
+	r.register(c)
+	return c
+}
+
+// Add adds d, which mustn't be negative, to the series for labelValues.
+func (c *Counter) Add(d float64, labelValues ...string) {
+	if d < 0 {
+		return
+	}
+	c.vec.with(labelValues).add(d)
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	c.vec.writeHeader(w)
+	c.vec.each(func(names, values []string, v *value) {
+		fmt.Fprintf(w, "%s%s %s\n", c.vec.name, formatLabels(names, values), formatValue(v.get()))
+	})
+}
+
+// Gauge is a value that goes up and down, such as uploads in progress.
+type Gauge struct {
+	vec *vec[value]
+}
+
+func (r *Registry) Gauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{vec: newVec(name, help, "gauge", labels, func() *value { return &value{} })}
+	r.register(g)
+	return g
+}
+
+func (g *Gauge) Add(d float64, labelValues ...string) {
+	g.vec.with(labelValues).add(d)
+}
+
+func (g *Gauge) Inc(labelValues ...string) {
+	g.Add(1, labelValues...)
+}
+
+func (g *Gauge) Dec(labelValues ...string) {
+	g.Add(-1, labelValues...)
+}
+
+func (g *Gauge) write(w *bufio.Writer) {
+	g.vec.writeHeader(w)
+	g.vec.each(func(names, values []string, v *value) {
+		fmt.Fprintf(w, "%s%s %s\n", g.vec.name, formatLabels(names, values), formatValue(v.get()))
+	})
+}
+
+// gaugeFunc is a gauge read when it's scraped.
+type gaugeFunc struct {
+	name, help string
+	fn         func() float64
+}
+
+// GaugeFunc registers a gauge whose value is fn's at the time of each
+// scrape, for values something else keeps, such as a queue's length.
+func (r *Registry) GaugeFunc(name, help string, fn func() float64) {
+	r.register(&gaugeFunc{name: name, help: help, fn: fn})
+}
+
+func (g *gaugeFunc) write(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", g.name, helpEscaper.Replace(g.help), g.name, g.name, formatValue(g.fn()))
+}
+
+// gaugeVecFunc is a gauge with labels whose series are read when it's
+// scraped.
+type gaugeVecFunc struct {
+	name, help string
+	labels     []string
+	fn         func(set func(v float64, labelValues ...string))
+}
+
+// GaugeVecFunc registers a gauge whose series are those fn sets at the time
+// of each scrape, in the order it sets them, for values something else
+// computes, such as ratios over rolling windows. Each call to set must
+// pass one value per label.
+func (r *Registry) GaugeVecFunc(name, help string, labels []string, fn func(set func(v float64, labelValues ...string))) {
+	r.register(&gaugeVecFunc{name: name, help: help, labels: labels, fn: fn})
+}
+
+func (g *gaugeVecFunc) write(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", g.name, helpEscaper.Replace(g.help), g.name)
+	g.fn(func(v float64, labelValues ...string) {
+		if len(labelValues) != len(g.labels) {
+			panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", g.name, len(g.labels), len(labelValues)))
+		}
+		fmt.Fprintf(w, "%s%s %s\n", g.name, formatLabels(g.labels, labelValues), formatValue(v))
+	})
+}
+
+type histogramSeries struct {
+	mu     sync.Mutex
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Histogram counts observations, such as durations, into buckets.
+type Histogram struct {
+	vec     *vec[histogramSeries]
+	buckets []float64
+}
+
+// Histogram registers a histogram with the given upper bounds, in
+// increasing order; a +Inf bucket is added.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	h := &Histogram{buckets: buckets}
+	h.vec = newVec(name, help, "histogram", labels, func() *histogramSeries {
+		return &histogramSeries{counts: make([]uint64, len(buckets))}
+	})
+	r.register(h)
+	return h
+}
+
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	s := h.vec.with(labelValues)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, upper := range h.buckets {
+		if v <= upper {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += v
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.vec.writeHeader(w)
+	h.vec.each(func(names, values []string, s *histogramSeries) {
+		s.mu.Lock()
+		counts := append([]uint64(nil), s.counts...)
+		count, sum := s.count, s.sum
+		s.mu.Unlock()
+		for i, upper := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.vec.name, formatLabels(names, values, "le", formatValue(upper)), counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.vec.name, formatLabels(names, values, "le", "+Inf"), count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.vec.name, formatLabels(names, values), formatValue(sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.vec.name, formatLabels(names, values), count)
+	})
+}
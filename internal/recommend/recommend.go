@@ -0,0 +1,125 @@
+// Package recommend ranks videos for a viewer. Scoring is behind the Scorer
+// interface, so the heuristic here can be replaced, e.g. by a model served
+// elsewhere, without touching how candidates and profiles are gathered.
+package recommend
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Candidate is a video that could be recommended.
+type Candidate struct {
+	ID   uuid.UUID
+	Tags []string
+	// Plays is how often the video was played recently.
+	Plays       int
+	PublishedAt time.Time
+}
+
+// Profile is what is known about the viewer's taste.
+type Profile struct {
+	// Tags weighs the tags of the videos the viewer has watched, from 0
+	// to 1 for the tag they watch the most.
+	Tags map[string]float64
+}
+
+// Scorer scores candidates for a viewer, higher being a better fit. It
+// returns one score per candidate, in order.
+type Scorer interface {
+	Score(ctx context.Context, profile Profile, candidates []Candidate) ([]float64, error)
+}
+
+// Recommendation is a ranked candidate.
+type Recommendation struct {
+	ID    uuid.UUID
+	Score float64
+}
+
+// Rank scores candidates with s and returns up to limit of them, best
+// first.
+func Rank(ctx context.Context, s Scorer, profile Profile, candidates []Candidate, limit int) ([]Recommendation, error) {
+	scores, err := s.Score(ctx, profile, candidates)
+	if err != nil {
+		return nil, err
+	}
+	if len(scores) != len(candidates) {
+		return nil, fmt.Errorf("scorer returned %d scores for %d candidates", len(scores), len(candidates))
+	}
+	ranked := make([]Recommendation, len(candidates))
+	for i, c := range candidates {
+		ranked[i] = Recommendation{ID: c.ID, Score: scores[i]}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool { return ranked[i].Score > ranked[j].Score })
+	if len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+	return ranked, nil
+}
+
+// Heuristic scores candidates by how much their tags overlap the viewer's,
+// how popular they are and how new they are, each from 0 to 1 and weighted.
+// Viewers without a history get popular and recent videos.
+type Heuristic struct {
+	TagWeight        float64
+	PopularityWeight float64
+	RecencyWeight    float64
+	// HalfLife is the age at which a video's recency score halves.
+	HalfLife time.Duration
+	// Now is the clock recency is measured against; nil means time.Now.
+	Now func() time.Time
+}
+
+// DefaultHeuristic favors tag overlap, then popularity.
+func DefaultHeuristic() Heuristic {
+	return Heuristic{
+		TagWeight:        0.6,
+		PopularityWeight: 0.25,
+		RecencyWeight:    0.15,
+		HalfLife:         14 * 24 * time.Hour,
+	}
+}
+
+func (h Heuristic) Score(ctx context.Context, profile Profile, candidates []Candidate) ([]float64, error) {
+	now := time.Now()
+	if h.Now != nil {
+		now = h.Now()
+	}
+	maxPlays := 0
+	for _, c := range candidates {
+		maxPlays = max(maxPlays, c.Plays)
+	}
+
+	scores := make([]float64, len(candidates))
+	for i, c := range candidates {
+		score := h.TagWeight * tagOverlap(profile.Tags, c.Tags)
+		if maxPlays > 0 {
+			// Plays are log-scaled so a few viral videos don't drown out
+			// everything else.
+			score += h.PopularityWeight * math.Log1p(float64(c.Plays)) / math.Log1p(float64(maxPlays))
+		}
+		if h.HalfLife > 0 {
+			age := max(0, now.Sub(c.PublishedAt))
+			score += h.RecencyWeight * math.Exp2(-float64(age)/float64(h.HalfLife))
+		}
+		scores[i] = score
+	}
+	return scores, nil
+}
+
+// tagOverlap is the mean weight the viewer gives the candidate's tags.
+func tagOverlap(weights map[string]float64, tags []string) float64 {
+	if len(tags) == 0 || len(weights) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, tag := range tags {
+		sum += weights[tag]
+	}
+	return sum / float64(len(tags))
+}
@@ -0,0 +1,116 @@
+// Package scan checks uploaded files with an antivirus or content
+// moderation service.
+package scan
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// A scan's verdict is that the file is clean, that it should be held for a
+// moderator to review, or that it must not be published at all.
+const (
+	StatusClean   = "clean"
+	StatusFlagged = "flagged"
+	StatusBlocked = "blocked"
+)
+
+// Verdict is what a scanner made of a file. Reason says why it wasn't
+// clean.
+type Verdict struct {
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Scanner checks a file on disk.
+type Scanner interface {
+	Scan(ctx context.Context, path string) (Verdict, error)
+}
+
+// API delegates scans to a third-party service. The file is POSTed to URL
+// as the request body, and the service responds with a Verdict.
+type API struct {
+	URL    string
+	Token  string
+	Client *http.Client
+}
+
+func (a API) Scan(ctx context.Context, path string) (Verdict, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Verdict{}, err
+	}
+	defer f.Close()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.URL, f)
+	if err != nil {
+		return Verdict{}, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if a.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+a.Token)
+	}
+
+	client := a.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Minute}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return Verdict{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return Verdict{}, fmt.Errorf("scan API responded %s: %s", resp.Status, body)
+	}
+
+	var verdict Verdict
+	if err := json.NewDecoder(resp.Body).Decode(&verdict); err != nil {
+		return Verdict{}, fmt.Errorf("couldn't decode scan API response: %w", err)
+	}
+	switch verdict.Status {
+	case StatusClean, StatusFlagged, StatusBlocked:
+		return verdict, nil
+	default:
+		return Verdict{}, fmt.Errorf("scan API responded with unknown status %q", verdict.Status)
+	}
+}
+
+// Command runs a local scanner such as clamscan with the file's path as its
+// last argument. Exiting with 0 means the file is clean and with 1 that it
+// is infected, which blocks it, as clamscan does; the first line of output
+// is the reason. Any other exit is a failed scan.
+type Command struct {
+	Path string
+	Args []string
+}
+
+func (c Command) Scan(ctx context.Context, path string) (Verdict, error) {
+	cmd := exec.CommandContext(ctx, c.Path, append(c.Args, path)...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err == nil {
+		return Verdict{Status: StatusClean}, nil
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		reason, _, _ := strings.Cut(strings.TrimSpace(stdout.String()), "\n")
+		// The output names the file by its path, a temporary file the
+		// reason shouldn't mention.
+		reason = strings.TrimSpace(strings.TrimPrefix(reason, path+":"))
+		return Verdict{Status: StatusBlocked, Reason: reason}, nil
+	}
+	return Verdict{}, fmt.Errorf("%s: %w: %s", c.Path, err, strings.TrimSpace(stderr.String()))
+}
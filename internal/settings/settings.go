@@ -0,0 +1,158 @@
+// Package settings loads the server's settings and checks the ones every
+// deployment needs. Settings are environment variables, which can also be
+// given in a YAML file.
+package settings
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+var envName = regexp.MustCompile(`^[A-Z][A-Z0-9_]*$`)
+
+// LoadFile sets the environment variables given in the YAML file at path
+// that are unset or empty, so the environment and .env take precedence over
+// the file. The file maps variable names to their values, such as
+// "PROCESSING_WORKERS: 4"; lists are joined with commas, as variables that
+// take several values expect.
+func LoadFile(path string) error {
+	dat, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	values := map[string]any{}
+	if err := yaml.Unmarshal(dat, &values); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	for name, value := range values {
+		if !envName.MatchString(name) {
+			return fmt.Errorf("%s: %q isn't an environment variable name", path, name)
+		}
+		s, err := envValue(value)
+		if err != nil {
+			return fmt.Errorf("%s: %s: %w", path, name, err)
+		}
+		if os.Getenv(name) != "" {
+			continue
+		}
+		if err := os.Setenv(name, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func envValue(value any) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case []any:
+		items := make([]string, len(v))
+		for i, item := range v {
+			s, err := envValue(item)
+			if err != nil {
+				return "", err
+			}
+			if strings.Contains(s, ",") {
+				return "", fmt.Errorf("list item %q contains a comma", s)
+			}
+			items[i] = s
+		}
+		return strings.Join(items, ","), nil
+	default:
+		return "", fmt.Errorf("must be a scalar or a list, not %T", value)
+	}
+}
+
+// Problems collects what's wrong with the settings, so they can all be
+// reported at once rather than one per restart.
+type Problems []string
+
+func (p *Problems) Add(format string, args ...any) {
+	*p = append(*p, fmt.Sprintf(format, args...))
+}
+
+// Check adds err, if any, as a problem with the variable name.
+func (p *Problems) Check(name string, err error) {
+	if err != nil {
+		p.Add("%s: %v", name, err)
+	}
+}
+
+// Require adds a problem for each of names that is unset or empty.
+func (p *Problems) Require(names ...string) {
+	for _, name := range names {
+		if os.Getenv(name) == "" {
+			p.Add("%s must be set", name)
+		}
+	}
+}
+
+// Err returns the problems as one error, or nil if there are none.
+func (p Problems) Err() error {
+	if len(p) == 0 {
+		return nil
+	}
+	return errors.New("invalid configuration:\n  " + strings.Join(p, "\n  "))
+}
+
+var (
+	bucketPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+	ipPattern     = regexp.MustCompile(`^\d+\.\d+\.\d+\.\d+$`)
+	regionPattern = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-\d+$`)
+)
+
+// BucketName checks name against S3's rules for bucket names.
+func BucketName(name string) error {
+	switch {
+	case !bucketPattern.MatchString(name):
+		return fmt.Errorf("%q must be 3 to 63 lowercase letters, digits, dots and hyphens, starting and ending with a letter or digit", name)
+	case strings.Contains(name, ".."):
+		return fmt.Errorf("%q must not contain two dots in a row", name)
+	case ipPattern.MatchString(name):
+		return fmt.Errorf("%q must not be formatted as an IP address", name)
+	}
+	return nil
+}
+
+// Region checks that region looks like an AWS region, such as us-east-2.
+func Region(region string) error {
+	if !regionPattern.MatchString(region) {
+		return fmt.Errorf("%q isn't an AWS region, such as us-east-2", region)
+	}
+	return nil
+}
+
+// Port checks that port is a TCP port number.
+func Port(port string) error {
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("%q must be a port number between 1 and 65535", port)
+	}
+	return nil
+}
+
+// FindBinary returns the path of the executable named by path, which is
+// looked up on PATH unless it contains a slash.
+func FindBinary(path string) (string, error) {
+	found, err := exec.LookPath(path)
+	if err != nil {
+		return "", fmt.Errorf("%s not found; install it or set its path", path)
+	}
+	return found, nil
+}
@@ -0,0 +1,145 @@
+// Package sqs is a minimal client for the SQS JSON protocol, covering the
+// calls the server makes to consume a queue: receiving messages and
+// deleting them once they're handled.
+package sqs
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+// Message is a message received from a queue. ReceiptHandle deletes it.
+type Message struct {
+	MessageID     string `json:"MessageId"`
+	ReceiptHandle string `json:"ReceiptHandle"`
+	Body          string `json:"Body"`
+}
+
+// Client consumes one queue.
+type Client struct {
+	QueueURL    string
+	Region      string
+	Credentials aws.CredentialsProvider
+	HTTPClient  *http.Client
+
+	signer *v4.Signer
+}
+
+// New returns a client for the queue at queueURL, such as
+// https://sqs.us-east-2.amazonaws.com/123456789012/tubely-email. The
+// region is the queue's unless it's given, which it has to be for queues
+// of local stand-ins such as ElasticMQ.
+func New(queueURL, region string, credentials aws.CredentialsProvider) (*Client, error) {
+	u, err := url.Parse(queueURL)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, fmt.Errorf("invalid queue URL %q", queueURL)
+	}
+	if region == "" {
+		// sqs.<region>.amazonaws.com
+		parts := strings.Split(u.Hostname(), ".")
+		if len(parts) < 4 || parts[0] != "sqs" {
+			return nil, fmt.Errorf("can't tell the region of queue URL %q", queueURL)
+		}
+		region = parts[1]
+	}
+	return &Client{
+		QueueURL:    queueURL,
+		Region:      region,
+		Credentials: credentials,
+		// Long polls hold the request open for up to 20 seconds.
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+		signer:     v4.NewSigner(),
+	}, nil
+}
+
+// Receive long-polls the queue for up to wait, at most 20 seconds, and
+// returns up to maxMessages, at most 10. Received messages are hidden
+// from other receivers for visibility, after which they're delivered again
+// unless they've been deleted.
+func (c *Client) Receive(ctx context.Context, maxMessages int, wait, visibility time.Duration) ([]Message, error) {
+	var out struct {
+		Messages []Message `json:"Messages"`
+	}
+	err := c.call(ctx, "ReceiveMessage", map[string]any{
+		"QueueUrl":            c.QueueURL,
+		"MaxNumberOfMessages": maxMessages,
+		"WaitTimeSeconds":     int(wait.Seconds()),
+		"VisibilityTimeout":   int(visibility.Seconds()),
+	}, &out)
+	return out.Messages, err
+}
+
+// Delete deletes a received message so it isn't delivered again.
+func (c *Client) Delete(ctx context.Context, receiptHandle string) error {
+	return c.call(ctx, "DeleteMessage", map[string]any{
+		"QueueUrl":      c.QueueURL,
+		"ReceiptHandle": receiptHandle,
+	}, nil)
+}
+
+// Error is an error SQS responded with.
+type Error struct {
+	StatusCode int
+	Type       string `json:"__type"`
+	Message    string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("sqs: %d %s: %s", e.StatusCode, e.Type, e.Message)
+}
+
+func (c *Client) call(ctx context.Context, action string, input, output any) error {
+	body, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.QueueURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.0")
+	req.Header.Set("X-Amz-Target", "AmazonSQS."+action)
+
+	creds, err := c.Credentials.Retrieve(ctx)
+	if err != nil {
+		return err
+	}
+	hash := sha256.Sum256(body)
+	if err := c.signer.SignHTTP(ctx, creds, req, hex.EncodeToString(hash[:]), "sqs", c.Region, time.Now()); err != nil {
+		return err
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		e := &Error{StatusCode: resp.StatusCode}
+		json.Unmarshal(data, e)
+		return e
+	}
+	if output == nil {
+		return nil
+	}
+	return json.Unmarshal(data, output)
+}
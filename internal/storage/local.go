@@ -0,0 +1,198 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errInvalidKey = errors.New("invalid object key")
+
+// Local stores objects as files under Root, for development without a
+// bucket. Its presigned URLs point at BaseURL, where the Local itself has
+// to be served as an http.Handler, and are signed with Secret.
+type Local struct {
+	Root    string
+	BaseURL string
+	Secret  []byte
+}
+
+func NewLocal(root, baseURL string, secret []byte) *Local {
+	return &Local{Root: root, BaseURL: strings.TrimSuffix(baseURL, "/"), Secret: secret}
+}
+
+// path returns the file key is stored in. Keys that would escape Root are
+// rejected.
+func (l *Local) path(key string) (string, error) {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") || path.Clean(key) != key || strings.HasPrefix(key, "../") || key == ".." {
+		return "", errInvalidKey
+	}
+	return filepath.Join(l.Root, filepath.FromSlash(key)), nil
+}
+
+// Put writes body to a temporary file next to its destination and renames
+// it into place, so readers never see a partial object.
+func (l *Local) Put(ctx context.Context, key string, body io.Reader, contentType string, opts ...PutOption) error {
+	dest, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(dest), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := io.Copy(f, body); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), dest)
+}
+
+func (l *Local) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	p, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+func (l *Local) Delete(ctx context.Context, key string) error {
+	p, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (l *Local) PresignGet(ctx context.Context, key string, expires time.Duration) (string, error) {
+	return l.presign(http.MethodGet, key, "", 0, expires)
+}
+
+// PresignPut ignores opts, like Put.
+func (l *Local) PresignPut(ctx context.Context, key, contentType string, size int64, expires time.Duration, opts ...PutOption) (string, http.Header, error) {
+	u, err := l.presign(http.MethodPut, key, contentType, size, expires)
+	if err != nil {
+		return "", nil, err
+	}
+	header := http.Header{}
+	header.Set("Content-Type", contentType)
+	header.Set("Content-Length", strconv.FormatInt(size, 10))
+	return u, header, nil
+}
+
+func (l *Local) Size(ctx context.Context, key string) (int64, error) {
+	p, err := l.path(key)
+	if err != nil {
+		return 0, err
+	}
+	info, err := os.Stat(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+func (l *Local) presign(method, key, contentType string, size int64, expires time.Duration) (string, error) {
+	if _, err := l.path(key); err != nil {
+		return "", err
+	}
+	exp := strconv.FormatInt(Clock.Now().Add(expires).Unix(), 10)
+	q := url.Values{}
+	q.Set("expires", exp)
+	q.Set("signature", l.signature(method, key, contentType, size, exp))
+	return fmt.Sprintf("%s/%s?%s", l.BaseURL, (&url.URL{Path: key}).EscapedPath(), q.Encode()), nil
+}
+
+// signature signs a request for key. A PUT's size is signed with it, so
+// only a body of that size can be stored.
+func (l *Local) signature(method, key, contentType string, size int64, expires string) string {
+	mac := hmac.New(sha256.New, l.Secret)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s", method, key, contentType, expires)
+	if method == http.MethodPut {
+		fmt.Fprintf(mac, "\n%d", size)
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ServeHTTP serves the URLs from PresignGet and PresignPut, with the key as
+// the request path. Mount it with http.StripPrefix for BaseURL's path.
+func (l *Local) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	expires := r.URL.Query().Get("expires")
+	method, contentType, size := r.Method, "", int64(0)
+	switch r.Method {
+	case http.MethodHead:
+		method = http.MethodGet
+	case http.MethodPut:
+		contentType, size = r.Header.Get("Content-Type"), r.ContentLength
+	}
+	exp, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || Clock.Now().Unix() > exp ||
+		!hmac.Equal([]byte(r.URL.Query().Get("signature")), []byte(l.signature(method, key, contentType, size, expires))) {
+		http.Error(w, "Invalid or expired signature", http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		p, err := l.path(key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f, err := os.Open(p)
+		if errors.Is(err, os.ErrNotExist) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Couldn't open object", http.StatusInternalServerError)
+			return
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			http.Error(w, "Couldn't open object", http.StatusInternalServerError)
+			return
+		}
+		http.ServeContent(w, r, p, info.ModTime(), f)
+	case http.MethodPut:
+		if err := l.Put(r.Context(), key, r.Body, contentType); err != nil {
+			http.Error(w, "Couldn't store object", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// Resilience makes S3 clients ride out transient failures. Requests S3
+// doesn't start answering within Timeout are abandoned, and those and other
+// retryable errors, such as 5xx responses, throttling and dropped
+// connections, are retried up to MaxAttempts times in all with exponential
+// backoff of up to MaxBackoff. Once BreakerThreshold calls in a row have
+// failed that way even so, the circuit opens: calls fail at once with a
+// *CircuitOpenError for BreakerCooldown, after which a single call is let
+// through to see whether S3 is back. A BreakerThreshold of 0 never opens
+// it.
+//
+// The breaker is shared by every client a Resilience is applied to.
+type Resilience struct {
+	Timeout          time.Duration
+	MaxAttempts      int
+	MaxBackoff       time.Duration
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// CircuitOpenError is returned for calls made while the circuit is open.
+// RetryAfter is how long until S3 is tried again.
+type CircuitOpenError struct {
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("S3 is failing, not calling it for another %s", e.RetryAfter.Round(time.Second))
+}
+
+// Options applies r to an S3 client. It replaces the client's retryer and
+// HTTP client, so options that set their own go after it.
+func (r *Resilience) Options(o *s3.Options) {
+	o.Retryer = retry.NewStandard(func(so *retry.StandardOptions) {
+		if r.MaxAttempts > 0 {
+			so.MaxAttempts = r.MaxAttempts
+		}
+		if r.MaxBackoff > 0 {
+			so.MaxBackoff = r.MaxBackoff
+		}
+	})
+	if r.Timeout > 0 {
+		// A deadline on the whole request would cut off downloads and
+		// uploads that are slow but moving, so only the wait for S3 to
+		// connect and start responding is bounded.
+		o.HTTPClient = awshttp.NewBuildableClient().
+			WithDialerOptions(func(d *net.Dialer) { d.Timeout = r.Timeout }).
+			WithTransportOptions(func(t *http.Transport) { t.ResponseHeaderTimeout = r.Timeout })
+	}
+	if r.BreakerThreshold > 0 {
+		o.APIOptions = append(o.APIOptions, func(stack *middleware.Stack) error {
+			// Before everything else, so the breaker sees calls as their
+			// callers do, after all retries.
+			return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("CircuitBreaker", func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+				probe, err := r.allow(Clock.Now())
+				if err != nil {
+					return middleware.InitializeOutput{}, middleware.Metadata{}, err
+				}
+				out, md, err := next.HandleInitialize(ctx, in)
+				r.record(ctx, probe, err, Clock.Now())
+				return out, md, err
+			}), middleware.Before)
+		})
+	}
+}
+
+// allow returns a *CircuitOpenError unless a call may go ahead at now,
+// and whether the call is the one probing an open circuit.
+func (r *Resilience) allow(now time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failures < r.BreakerThreshold {
+		return false, nil
+	}
+	if now.Before(r.openUntil) {
+		return false, &CircuitOpenError{RetryAfter: r.openUntil.Sub(now)}
+	}
+	if r.probing {
+		return false, &CircuitOpenError{RetryAfter: r.BreakerCooldown}
+	}
+	r.probing = true
+	return true, nil
+}
+
+// record counts the outcome of a call. Errors S3 wouldn't give when it's
+// healthy count as failures; anything else, NoSuchKey and AccessDenied
+// included, shows S3 is up.
+func (r *Resilience) record(ctx context.Context, probe bool, err error, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if probe {
+		r.probing = false
+	}
+	if err != nil && ctx.Err() != nil {
+		// Cancelled by the caller; that says nothing about S3.
+		return
+	}
+	if err == nil || !isOutage(err) {
+		r.failures = 0
+		return
+	}
+	r.failures++
+	if probe || r.failures == r.BreakerThreshold {
+		r.openUntil = now.Add(r.BreakerCooldown)
+	}
+}
+
+// isOutage reports whether err is one an unhealthy S3 returns: one the
+// SDK would retry, or any other fault on S3's side.
+func isOutage(err error) bool {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorFault() == smithy.FaultServer {
+		return true
+	}
+	return retry.IsErrorRetryables(retry.DefaultRetryables).IsErrorRetryable(err) == aws.TrueTernary
+}
+
+// State describes the breaker: closed, open or probing, and for open, how
+// long until S3 is tried again, which is 0 once the next call will be.
+func (r *Resilience) State(now time.Time) (string, time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch {
+	case r.BreakerThreshold == 0 || r.failures < r.BreakerThreshold:
+		return "closed", 0
+	case r.probing:
+		return "probing", 0
+	case now.Before(r.openUntil):
+		return "open", r.openUntil.Sub(now)
+	}
+	return "open", 0
+}
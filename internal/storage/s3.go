@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// S3 stores objects in an S3 bucket. S3-compatible services work the same
+// way with a client configured with WithEndpoint.
+type S3 struct {
+	Client *s3.Client
+	Bucket string
+}
+
+func NewS3(client *s3.Client, bucket string) *S3 {
+	return &S3{Client: client, Bucket: bucket}
+}
+
+// WithEndpoint points an S3 client at an S3-compatible service instead of
+// AWS. Most such services, MinIO included, need path-style requests, since
+// they don't serve buckets as subdomains.
+func WithEndpoint(endpoint string, pathStyle bool) func(*s3.Options) {
+	return func(o *s3.Options) {
+		o.BaseEndpoint = aws.String(endpoint)
+		o.UsePathStyle = pathStyle
+	}
+}
+
+// WithRequesterPays has an S3 client accept the charges for its requests,
+// which requester-pays buckets reject requests without. The header is added
+// to every operation; presigned URLs carry it in their query instead.
+func WithRequesterPays(o *s3.Options) {
+	o.APIOptions = append(o.APIOptions, func(stack *middleware.Stack) error {
+		return stack.Build.Add(middleware.BuildMiddlewareFunc("RequesterPays", func(ctx context.Context, in middleware.BuildInput, next middleware.BuildHandler) (middleware.BuildOutput, middleware.Metadata, error) {
+			if req, ok := in.Request.(*smithyhttp.Request); ok {
+				req.Header.Set("X-Amz-Request-Payer", string(types.RequestPayerRequester))
+			}
+			return next.HandleBuild(ctx, in)
+		}), middleware.After)
+	})
+}
+
+func (s *S3) Put(ctx context.Context, key string, body io.Reader, contentType string, opts ...PutOption) error {
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(s.Bucket),
+		Key:         aws.String(key),
+		Body:        body,
+		ContentType: aws.String(contentType),
+	}
+	for _, opt := range opts {
+		opt(input)
+	}
+	_, err := s.Client.PutObject(ctx, input)
+	return err
+}
+
+func (s *S3) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	obj, err := s.Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	})
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return obj.Body, nil
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	_, err := s.Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
+func (s *S3) PresignGet(ctx context.Context, key string, expires time.Duration) (string, error) {
+	req, err := s3.NewPresignClient(s.Client).PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	}, s3.WithPresignExpires(expires))
+	if err != nil {
+		return "", err
+	}
+	return req.URL, nil
+}
+
+// PresignPut signs the Content-Type and Content-Length of the PUT, and
+// the headers of any options, such as server-side encryption, so S3
+// rejects uploads that don't match them.
+func (s *S3) PresignPut(ctx context.Context, key, contentType string, size int64, expires time.Duration, opts ...PutOption) (string, http.Header, error) {
+	input := &s3.PutObjectInput{
+		Bucket:        aws.String(s.Bucket),
+		Key:           aws.String(key),
+		ContentType:   aws.String(contentType),
+		ContentLength: aws.Int64(size),
+	}
+	for _, opt := range opts {
+		opt(input)
+	}
+	req, err := s3.NewPresignClient(s.Client).PresignPutObject(ctx, input, s3.WithPresignExpires(expires))
+	if err != nil {
+		return "", nil, err
+	}
+	header := req.SignedHeader.Clone()
+	header.Del("Host")
+	return req.URL, header, nil
+}
+
+func (s *S3) Size(ctx context.Context, key string) (int64, error) {
+	out, err := s.Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	})
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	return aws.ToInt64(out.ContentLength), nil
+}
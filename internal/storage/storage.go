@@ -0,0 +1,78 @@
+// Package storage abstracts where uploaded objects are kept, so the server
+// can run against S3, an S3-compatible service such as MinIO or GCS's XML
+// API, or a directory on local disk.
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/clock"
+)
+
+var ErrNotFound = errors.New("object not found")
+
+// Clock is what the expiry of Local's presigned URLs and the circuit
+// breaker's cooldown go by. S3 checks its own presigned URLs.
+var Clock clock.Clock = clock.System
+
+// Storage keeps objects under slash-separated keys.
+type Storage interface {
+	// Put stores body under key, replacing any object already there.
+	Put(ctx context.Context, key string, body io.Reader, contentType string, opts ...PutOption) error
+	// Get opens the object under key, or returns ErrNotFound. The caller
+	// closes it.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the object under key. Deleting a missing object
+	// isn't an error.
+	Delete(ctx context.Context, key string) error
+	// PresignGet returns a URL anyone can fetch the object under key from
+	// until it expires.
+	PresignGet(ctx context.Context, key string, expires time.Duration) (string, error)
+	// PresignPut returns a URL anyone can PUT an object of the given
+	// content type and size to until it expires, and the headers the PUT
+	// has to send.
+	PresignPut(ctx context.Context, key, contentType string, size int64, expires time.Duration, opts ...PutOption) (string, http.Header, error)
+	// Size returns the size of the object under key, or ErrNotFound.
+	Size(ctx context.Context, key string) (int64, error)
+}
+
+// PutOption adjusts an upload, such as setting its Content-Disposition or
+// Object Lock retention. Options are written against the S3 API; backends
+// that aren't S3 ignore them.
+type PutOption = func(*s3.PutObjectInput)
+
+// Prefixed keeps the objects of one kind apart in a shared backend by
+// storing them under Prefix.
+type Prefixed struct {
+	Storage Storage
+	Prefix  string
+}
+
+func (p Prefixed) Put(ctx context.Context, key string, body io.Reader, contentType string, opts ...PutOption) error {
+	return p.Storage.Put(ctx, p.Prefix+key, body, contentType, opts...)
+}
+
+func (p Prefixed) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return p.Storage.Get(ctx, p.Prefix+key)
+}
+
+func (p Prefixed) Delete(ctx context.Context, key string) error {
+	return p.Storage.Delete(ctx, p.Prefix+key)
+}
+
+func (p Prefixed) PresignGet(ctx context.Context, key string, expires time.Duration) (string, error) {
+	return p.Storage.PresignGet(ctx, p.Prefix+key, expires)
+}
+
+func (p Prefixed) PresignPut(ctx context.Context, key, contentType string, size int64, expires time.Duration, opts ...PutOption) (string, http.Header, error) {
+	return p.Storage.PresignPut(ctx, p.Prefix+key, contentType, size, expires, opts...)
+}
+
+func (p Prefixed) Size(ctx context.Context, key string) (int64, error) {
+	return p.Storage.Size(ctx, p.Prefix+key)
+}
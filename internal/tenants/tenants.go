@@ -0,0 +1,335 @@
+package tenants
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+var ErrUnknownTenant = errors.New("unknown tenant")
+
+// Tenant is a customer whose media is isolated in its own bucket, optionally
+// accessed through an IAM role in the customer's account.
+type Tenant struct {
+	ID         string `json:"id"`
+	Bucket     string `json:"bucket"`
+	Region     string `json:"region"`
+	RoleARN    string `json:"role_arn,omitempty"`
+	ExternalID string `json:"external_id,omitempty"`
+	// Retention is set for tenants whose bucket is a compliance (WORM)
+	// bucket with S3 Object Lock enabled.
+	Retention *Retention `json:"retention,omitempty"`
+	// Encryption, Hotlink and NoIndexUnlisted default to the default target's when
+	// unset.
+	Encryption      *Encryption `json:"encryption,omitempty"`
+	Hotlink         *Hotlink    `json:"hotlink,omitempty"`
+	NoIndexUnlisted *bool       `json:"noindex_unlisted,omitempty"`
+}
+
+// Retention is the S3 Object Lock retention every object uploaded to a
+// bucket gets. Mode is GOVERNANCE or COMPLIANCE.
+type Retention struct {
+	Mode string `json:"mode"`
+	Days int    `json:"days"`
+}
+
+// Validate checks that r is a retention S3 accepts.
+func (r Retention) Validate() error {
+	switch types.ObjectLockMode(r.Mode) {
+	case types.ObjectLockModeGovernance, types.ObjectLockModeCompliance:
+	default:
+		return fmt.Errorf("retention mode must be %s or %s", types.ObjectLockModeGovernance, types.ObjectLockModeCompliance)
+	}
+	if r.Days < 1 {
+		return errors.New("retention needs at least 1 day")
+	}
+	return nil
+}
+
+// RetainUntil is when an object uploaded at now may be deleted.
+func (r Retention) RetainUntil(now time.Time) time.Time {
+	return now.AddDate(0, 0, r.Days)
+}
+
+// Encryption is the server-side encryption every object uploaded to a
+// bucket gets. Mode is AES256 for S3-managed keys or aws:kms, with the KMS
+// key in KMSKeyID; aws:kms without one uses the account's AWS managed key.
+type Encryption struct {
+	Mode     string `json:"mode"`
+	KMSKeyID string `json:"kms_key_id,omitempty"`
+}
+
+// Validate checks that e is an encryption S3 accepts.
+func (e Encryption) Validate() error {
+	switch types.ServerSideEncryption(e.Mode) {
+	case types.ServerSideEncryptionAes256:
+		if e.KMSKeyID != "" {
+			return fmt.Errorf("a KMS key needs encryption mode %s", types.ServerSideEncryptionAwsKms)
+		}
+	case types.ServerSideEncryptionAwsKms:
+	default:
+		return fmt.Errorf("encryption mode must be %s or %s", types.ServerSideEncryptionAes256, types.ServerSideEncryptionAwsKms)
+	}
+	return nil
+}
+
+// Hotlink restricts where a target's videos can be played from.
+type Hotlink struct {
+	// AllowedReferrers are the hosts whose pages may play the videos, where
+	// a leading "*." also matches subdomains. Empty allows every page.
+	AllowedReferrers []string `json:"allowed_referrers,omitempty"`
+	// BlockEmptyReferrer rejects playback without a Referer, which is
+	// allowed otherwise, so links opened directly keep working.
+	BlockEmptyReferrer bool `json:"block_empty_referrer,omitempty"`
+	// RequireToken makes playback need a token the API adds to the
+	// playback URLs it hands out, so copied URLs stop working once it
+	// expires.
+	RequireToken bool `json:"require_token,omitempty"`
+}
+
+// Validate checks that h's referrers are hosts.
+func (h Hotlink) Validate() error {
+	for _, host := range h.AllowedReferrers {
+		name := strings.TrimPrefix(host, "*.")
+		if name == "" || strings.ContainsAny(name, "/:*") {
+			return fmt.Errorf("allowed referrer %q must be a host such as example.com or *.example.com", host)
+		}
+	}
+	return nil
+}
+
+// AllowsReferrer reports whether a page on host may play the videos. An
+// empty host is a request without a Referer.
+func (h Hotlink) AllowsReferrer(host string) bool {
+	if host == "" {
+		return !h.BlockEmptyReferrer
+	}
+	if len(h.AllowedReferrers) == 0 {
+		return true
+	}
+	host = strings.ToLower(host)
+	for _, allowed := range h.AllowedReferrers {
+		allowed = strings.ToLower(allowed)
+		if parent, ok := strings.CutPrefix(allowed, "*."); ok {
+			if host == parent || strings.HasSuffix(host, "."+parent) {
+				return true
+			}
+		} else if host == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// Target is the client and bucket a request's objects should go to.
+// Retention and Encryption, if set, are applied to every object uploaded to
+// it. Backend, if
+// set, stores the objects instead of the bucket; targets backed by local
+// disk have no Client. Hotlink, if set, restricts playback of the videos,
+// and NoIndexUnlisted asks search engines not to index videos that aren't
+// publicly listed.
+type Target struct {
+	Client          *s3.Client
+	Bucket          string
+	Region          string
+	Retention       *Retention
+	Encryption      *Encryption
+	Backend         storage.Storage
+	Hotlink         *Hotlink
+	NoIndexUnlisted bool
+}
+
+// Storage returns the backend the target's objects are kept in.
+func (t Target) Storage() storage.Storage {
+	if t.Backend != nil {
+		return t.Backend
+	}
+	return storage.NewS3(t.Client, t.Bucket)
+}
+
+// IsS3 reports whether the target is an S3 bucket, as opposed to another
+// backend. Features built on S3 APIs, like multipart uploads and Object
+// Lock, need one.
+func (t Target) IsS3() bool {
+	return t.Client != nil
+}
+
+func (t Target) ObjectURL(key string) string {
+	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", t.Bucket, t.Region, key)
+}
+
+// Pool resolves tenants to S3 targets, creating and caching one client per
+// tenant the first time it is used. Requests without a tenant use the
+// default target.
+type Pool struct {
+	mu       sync.Mutex
+	defaults Target
+	tenants  map[string]Tenant
+	clients  map[string]*s3.Client
+	optFns   []func(*s3.Options)
+}
+
+// NewPool validates the tenants. optFns are applied to every tenant client
+// it creates.
+func NewPool(defaults Target, tenants []Tenant, optFns ...func(*s3.Options)) (*Pool, error) {
+	if defaults.Retention != nil {
+		if err := defaults.Retention.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	if defaults.Encryption != nil {
+		if err := defaults.Encryption.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	if defaults.Hotlink != nil {
+		if err := defaults.Hotlink.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	p := &Pool{
+		defaults: defaults,
+		tenants:  map[string]Tenant{},
+		clients:  map[string]*s3.Client{},
+		optFns:   optFns,
+	}
+	for _, t := range tenants {
+		if t.ID == "" || t.Bucket == "" {
+			return nil, errors.New("tenants need an id and a bucket")
+		}
+		if _, ok := p.tenants[t.ID]; ok {
+			return nil, fmt.Errorf("duplicate tenant %q", t.ID)
+		}
+		if t.Region == "" {
+			t.Region = defaults.Region
+		}
+		if t.Retention != nil {
+			if err := t.Retention.Validate(); err != nil {
+				return nil, fmt.Errorf("tenant %q: %w", t.ID, err)
+			}
+		}
+		if t.Encryption != nil {
+			if err := t.Encryption.Validate(); err != nil {
+				return nil, fmt.Errorf("tenant %q: %w", t.ID, err)
+			}
+		}
+		if t.Hotlink != nil {
+			if err := t.Hotlink.Validate(); err != nil {
+				return nil, fmt.Errorf("tenant %q: %w", t.ID, err)
+			}
+		}
+		p.tenants[t.ID] = t
+	}
+	return p, nil
+}
+
+// LoadFile reads a JSON array of tenants from path.
+func LoadFile(path string) ([]Tenant, error) {
+	dat, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var tenants []Tenant
+	if err := json.Unmarshal(dat, &tenants); err != nil {
+		return nil, fmt.Errorf("couldn't parse tenants file: %w", err)
+	}
+	return tenants, nil
+}
+
+// IDs lists the configured tenants, sorted.
+func (p *Pool) IDs() []string {
+	ids := make([]string, 0, len(p.tenants))
+	for id := range p.tenants {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Exists reports whether id is a configured tenant.
+func (p *Pool) Exists(id string) bool {
+	_, ok := p.tenants[id]
+	return ok
+}
+
+// Defaults returns the default target.
+func (p *Pool) Defaults() Target {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.defaults
+}
+
+// SetDefaults switches the default target, e.g. once its objects have been
+// moved to another bucket. Requests already holding the old target finish
+// with it.
+func (p *Pool) SetDefaults(defaults Target) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.defaults = defaults
+}
+
+// Target returns the S3 target for tenant id. An empty id returns the
+// default target.
+func (p *Pool) Target(ctx context.Context, id string) (Target, error) {
+	if id == "" {
+		return p.Defaults(), nil
+	}
+	t, ok := p.tenants[id]
+	if !ok {
+		return Target{}, fmt.Errorf("%w: %s", ErrUnknownTenant, id)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	client, ok := p.clients[id]
+	if !ok {
+		var err error
+		client, err = newClient(ctx, t, p.optFns)
+		if err != nil {
+			return Target{}, fmt.Errorf("couldn't create client for tenant %s: %w", id, err)
+		}
+		p.clients[id] = client
+	}
+	target := Target{Client: client, Bucket: t.Bucket, Region: t.Region, Retention: t.Retention, Encryption: p.defaults.Encryption, Hotlink: p.defaults.Hotlink, NoIndexUnlisted: p.defaults.NoIndexUnlisted}
+	if t.Encryption != nil {
+		target.Encryption = t.Encryption
+	}
+	if t.Hotlink != nil {
+		target.Hotlink = t.Hotlink
+	}
+	if t.NoIndexUnlisted != nil {
+		target.NoIndexUnlisted = *t.NoIndexUnlisted
+	}
+	return target, nil
+}
+
+func newClient(ctx context.Context, t Tenant, optFns []func(*s3.Options)) (*s3.Client, error) {
+	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(t.Region))
+	if err != nil {
+		return nil, err
+	}
+	if t.RoleARN != "" {
+		provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), t.RoleARN, func(o *stscreds.AssumeRoleOptions) {
+			o.RoleSessionName = "tubely-" + t.ID
+			if t.ExternalID != "" {
+				o.ExternalID = aws.String(t.ExternalID)
+			}
+		})
+		cfg.Credentials = aws.NewCredentialsCache(provider)
+	}
+	return s3.NewFromConfig(cfg, optFns...), nil
+}
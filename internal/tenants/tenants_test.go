@@ -0,0 +1,46 @@
+package tenants
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestPoolAppliesOptionsToTenantClients(t *testing.T) {
+	tests := []struct {
+		name    string
+		tenants []string
+		// wantRegions are the regions of the clients the options were
+		// applied to, one per client created.
+		wantRegions []string
+	}{
+		{name: "default target", tenants: []string{""}},
+		{name: "one tenant, resolved twice", tenants: []string{"acme", "acme"}, wantRegions: []string{"eu-west-1"}},
+		{name: "two tenants", tenants: []string{"acme", "globex"}, wantRegions: []string{"eu-west-1", "us-east-2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("AWS_ACCESS_KEY_ID", "key")
+			t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+			var regions []string
+			p, err := NewPool(Target{Bucket: "default", Region: "us-east-2"}, []Tenant{
+				{ID: "acme", Bucket: "acme-media", Region: "eu-west-1"},
+				{ID: "globex", Bucket: "globex-media"},
+			}, func(o *s3.Options) { regions = append(regions, o.Region) })
+			if err != nil {
+				t.Fatalf("NewPool: %v", err)
+			}
+
+			for _, id := range tt.tenants {
+				if _, err := p.Target(context.Background(), id); err != nil {
+					t.Fatalf("Target(%q): %v", id, err)
+				}
+			}
+			if !slices.Equal(regions, tt.wantRegions) {
+				t.Errorf("options applied to clients in %v, want %v", regions, tt.wantRegions)
+			}
+		})
+	}
+}
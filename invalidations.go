@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"log"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/cdn"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tenants"
+	"github.com/google/uuid"
+)
+
+// invalidationTimeout bounds the call that issues an invalidation.
+const invalidationTimeout = 30 * time.Second
+
+// invalidateOnCommit purges paths from the CDN once cleanup commits and
+// records the invalidation on the video's latest job. It does nothing when
+// no CDN is configured or there is nothing to purge.
+//
+// Every upload is stored under a fresh random key, so new URLs never hit a
+// stale edge copy. Edge caches keep serving the old URLs though, which
+// matters when an asset was replaced to take it down.
+func (cfg *apiConfig) invalidateOnCommit(cleanup *cleanupStack, videoID uuid.UUID, reason string, paths []string) {
+	if cfg.cdn == nil || len(paths) == 0 {
+		return
+	}
+	cleanup.onCommit("invalidate "+reason+" of video "+videoID.String(), func() error {
+		cfg.background.run(func() { cfg.invalidate(videoID, reason, paths) })
+		return nil
+	})
+}
+
+// invalidate issues an invalidation of paths and records it on the video's
+// latest job.
+func (cfg *apiConfig) invalidate(videoID uuid.UUID, reason string, paths []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), invalidationTimeout)
+	defer cancel()
+
+	inv := cdn.Invalidation{
+		Reason:    reason,
+		Paths:     paths,
+		Status:    cdn.StatusInProgress,
+		CreatedAt: cfg.clock.Now().UTC(),
+	}
+	id, err := cfg.cdn.Invalidate(ctx, paths)
+	if err != nil {
+		log.Printf("Couldn't invalidate %s of video %s: %v", reason, videoID, err)
+		inv.Status = cdn.StatusFailed
+		inv.Error = err.Error()
+	}
+	inv.ID = id
+	if !cfg.jobs.RecordInvalidation(videoID, inv) {
+		log.Printf("Invalidation of %s of video %s has no job to record it on", reason, videoID)
+	}
+}
+
+// refreshInvalidations updates the in-progress invalidations in est from
+// the CDN. Failures are logged and leave the last known status.
+func (cfg *apiConfig) refreshInvalidations(ctx context.Context, est *jobs.Estimate) {
+	if cfg.cdn == nil {
+		return
+	}
+	for i, inv := range est.Invalidations {
+		if inv.Status != cdn.StatusInProgress || inv.ID == "" {
+			continue
+		}
+		status, err := cfg.cdn.Status(ctx, inv.ID)
+		if err != nil {
+			log.Printf("Couldn't get status of invalidation %s: %v", inv.ID, err)
+			continue
+		}
+		inv.Status = status
+		est.Invalidations[i] = inv
+		cfg.jobs.RecordInvalidation(est.VideoID, inv)
+	}
+}
+
+// replacedVideoPaths are the CDN paths of the files video pointed at before
+// a new upload replaced them in target. Only the default bucket is fronted
+// by the distribution, so there are none for a tenant's own bucket.
+func (cfg *apiConfig) replacedVideoPaths(target tenants.Target, video database.Video, renditions []database.Rendition) []string {
+	if target.Bucket != cfg.tenants.Defaults().Bucket {
+		return []string{}
+	}
+	urls := []*string{video.VideoURL, video.PeaksURL, video.PreviewURL, video.SDRVideoURL}
+	for _, rendition := range renditions {
+		urls = append(urls, &rendition.URL)
+	}
+
+	seen := map[string]bool{}
+	paths := []string{}
+	for _, u := range urls {
+		if u == nil {
+			continue
+		}
+		key, ok := storedObjectKey(target, *u)
+		if !ok || seen[key] {
+			continue
+		}
+		seen[key] = true
+		paths = append(paths, "/"+key)
+	}
+	if video.HLSURL != nil {
+		if key, ok := storedObjectKey(target, *video.HLSURL); ok {
+			paths = append(paths, "/"+path.Dir(key)+"/*")
+		}
+	}
+	return paths
+}
+
+// replacedThumbnailPaths are the CDN paths of a replaced thumbnail: the
+// original, its variants and its resized copies. For local assets they
+// assume the distribution fronts the server's /assets as well.
+func (cfg *apiConfig) replacedThumbnailPaths(video database.Video) []string {
+	if video.ThumbnailURL == nil {
+		return nil
+	}
+	name, ok := cfg.thumbnailAssetName(*video.ThumbnailURL)
+	if !ok {
+		return nil
+	}
+	base := strings.TrimSuffix(name, filepath.Ext(name))
+	if strings.HasPrefix(*video.ThumbnailURL, thumbnailPrefix) {
+		return []string{
+			"/" + thumbnailPrefix + name,
+			"/" + thumbnailPrefix + base + "-*",
+			"/assets/" + resizedDir + "/" + base + "-*",
+		}
+	}
+	return []string{
+		"/assets/" + name,
+		"/assets/" + base + "-*",
+		"/assets/" + resizedDir + "/" + base + "-*",
+	}
+}
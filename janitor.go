@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tenants"
+	"github.com/google/uuid"
+)
+
+// tempFilePrefix starts the names of the scratch files and directories
+// handlers and jobs create in the system's temporary directory.
+const tempFilePrefix = "tubely-"
+
+// janitor sweeps up what failed requests and crashes leave behind: multipart
+// uploads nothing will complete, scratch files, and objects no video
+// records. Anything younger than maxAge is left alone, since a request may
+// still be using it. Orphaned objects are only reported unless
+// deleteOrphans is set.
+type janitor struct {
+	maxAge        time.Duration
+	deleteOrphans bool
+
+	// mu keeps sweeps from overlapping.
+	mu sync.Mutex
+}
+
+// janitorReport is what a sweep found. Uploads are listed as
+// s3://bucket/key?uploadId=id and objects as s3://bucket/key.
+type janitorReport struct {
+	AbortedUploads   []string `json:"aborted_uploads"`
+	RemovedTempFiles []string `json:"removed_temp_files"`
+	OrphanedObjects  []string `json:"orphaned_objects"`
+	DeletedOrphans   bool     `json:"deleted_orphans"`
+	Errors           []string `json:"errors,omitempty"`
+}
+
+func (rep *janitorReport) fail(format string, args ...any) {
+	rep.Errors = append(rep.Errors, fmt.Sprintf(format, args...))
+}
+
+// janitorTask is the scheduled sweep. The run fails if anything in the
+// sweep did, with the report as its result either way.
+func (cfg *apiConfig) janitorTask(ctx context.Context) (any, error) {
+	rep, ok := cfg.sweepJanitor(ctx, cfg.janitor.deleteOrphans)
+	if !ok {
+		return nil, errors.New("a janitor sweep is already running")
+	}
+	log.Printf("Janitor sweep aborted %d uploads, removed %d temporary files and found %d orphaned objects (%d errors)",
+		len(rep.AbortedUploads), len(rep.RemovedTempFiles), len(rep.OrphanedObjects), len(rep.Errors))
+	for _, e := range rep.Errors {
+		log.Printf("Janitor: %s", e)
+	}
+	if len(rep.Errors) > 0 {
+		return rep, fmt.Errorf("%d errors, the first: %s", len(rep.Errors), rep.Errors[0])
+	}
+	return rep, nil
+}
+
+// handlerJanitorSweep runs a sweep now and returns its report. With
+// ?delete_orphans=true or false it overrides JANITOR_DELETE_ORPHANS for
+// this sweep.
+func (cfg *apiConfig) handlerJanitorSweep(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+	deleteOrphans := cfg.janitor.deleteOrphans
+	if v := r.URL.Query().Get("delete_orphans"); v != "" {
+		var err error
+		if deleteOrphans, err = strconv.ParseBool(v); err != nil {
+			respondWithError(w, http.StatusBadRequest, "delete_orphans must be true or false", err)
+			return
+		}
+	}
+	rep, ok := cfg.sweepJanitor(r.Context(), deleteOrphans)
+	if !ok {
+		respondWithError(w, http.StatusConflict, "A janitor sweep is already running", nil)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, rep)
+}
+
+// sweepJanitor runs one sweep and reports what it did. Failures don't stop
+// the sweep; they're collected in the report. ok is false if another sweep
+// is running.
+func (cfg *apiConfig) sweepJanitor(ctx context.Context, deleteOrphans bool) (rep janitorReport, ok bool) {
+	if !cfg.janitor.mu.TryLock() {
+		return rep, false
+	}
+	defer cfg.janitor.mu.Unlock()
+
+	rep = janitorReport{AbortedUploads: []string{}, RemovedTempFiles: []string{}, OrphanedObjects: []string{}, DeletedOrphans: deleteOrphans}
+	cutoff := cfg.clock.Now().Add(-cfg.janitor.maxAge)
+	sessions, err := cfg.db.GetAllActiveUploadSessions()
+	if err != nil {
+		rep.fail("couldn't get upload sessions: %v", err)
+		return rep, true
+	}
+
+	cfg.sweepTempFiles(&rep, cutoff, sessions)
+	for _, target := range cfg.janitorTargets(ctx, &rep) {
+		cfg.sweepMultipartUploads(ctx, &rep, target, cutoff, sessions)
+		cfg.sweepOrphanedObjects(ctx, &rep, target, cutoff, deleteOrphans)
+	}
+	return rep, true
+}
+
+// janitorTargets returns the S3 buckets of the default target and every
+// tenant, each once. Other backends have no listing to sweep.
+func (cfg *apiConfig) janitorTargets(ctx context.Context, rep *janitorReport) []tenants.Target {
+	targets := []tenants.Target{}
+	seen := map[string]bool{}
+	add := func(target tenants.Target) {
+		if target.IsS3() && !seen[target.Bucket] {
+			seen[target.Bucket] = true
+			targets = append(targets, target)
+		}
+	}
+	add(cfg.tenants.Defaults())
+	for _, id := range cfg.tenants.IDs() {
+		target, err := cfg.tenants.Target(ctx, id)
+		if err != nil {
+			rep.fail("couldn't resolve storage for tenant %s: %v", id, err)
+			continue
+		}
+		add(target)
+	}
+	return targets
+}
+
+// sweepTempFiles removes scratch files in the temporary directory, and
+// spooled uploads, last changed before cutoff. An upload spooled for a
+// video that's still queued or processing is kept however old it is, as
+// are the chunks of active append sessions.
+func (cfg *apiConfig) sweepTempFiles(rep *janitorReport, cutoff time.Time, sessions []database.UploadSession) {
+	active := map[string]bool{}
+	for _, session := range sessions {
+		active[cfg.uploadSpoolPath(session)] = true
+	}
+	spoolDir := filepath.Clean(cfg.uploadSpoolDir)
+	// Directories configured to live in the temporary directory aren't
+	// scratch, however old they are.
+	protected := map[string]bool{}
+	for _, dir := range []string{cfg.uploadSpoolDir, cfg.assetsRoot, cfg.localStorageRoot()} {
+		if abs, err := filepath.Abs(dir); err == nil && dir != "" {
+			protected[abs] = true
+		}
+	}
+
+	remove := func(path string, keep func(name string) bool) {
+		info, err := os.Lstat(path)
+		if err != nil || !info.ModTime().Before(cutoff) || keep(filepath.Base(path)) {
+			return
+		}
+		if abs, err := filepath.Abs(path); err != nil || protected[abs] {
+			return
+		}
+		if err := os.RemoveAll(path); err != nil {
+			rep.fail("couldn't remove %s: %v", path, err)
+			return
+		}
+		rep.RemovedTempFiles = append(rep.RemovedTempFiles, path)
+	}
+
+	tmp, err := filepath.Glob(filepath.Join(os.TempDir(), tempFilePrefix+"*"))
+	if err != nil {
+		rep.fail("couldn't list temporary files: %v", err)
+	}
+	for _, path := range tmp {
+		remove(path, func(string) bool { return false })
+	}
+
+	spooled, err := os.ReadDir(spoolDir)
+	if err != nil && !os.IsNotExist(err) {
+		rep.fail("couldn't list spooled uploads: %v", err)
+	}
+	for _, entry := range spooled {
+		path := filepath.Join(spoolDir, entry.Name())
+		remove(path, func(name string) bool {
+			if active[path] {
+				return true
+			}
+			if !strings.HasSuffix(name, ".raw") || len(name) < 36 {
+				return false
+			}
+			videoID, err := uuid.Parse(name[:36])
+			if err != nil {
+				return false
+			}
+			video, err := cfg.db.GetVideo(videoID)
+			if err != nil {
+				rep.fail("couldn't get video %s: %v", videoID, err)
+				return true
+			}
+			return video.ProcessingStatus == database.VideoPending || video.ProcessingStatus == database.VideoProcessing
+		})
+	}
+}
+
+// localStorageRoot returns the directory the default target keeps its
+// objects in, or "" if it's not on local disk.
+func (cfg *apiConfig) localStorageRoot() string {
+	if local, ok := cfg.tenants.Defaults().Backend.(*storage.Local); ok {
+		return local.Root
+	}
+	return ""
+}
+
+// sweepMultipartUploads aborts the multipart uploads in target started
+// before cutoff that no active upload session is still sending parts to.
+// Aborting one deletes the parts uploaded so far.
+func (cfg *apiConfig) sweepMultipartUploads(ctx context.Context, rep *janitorReport, target tenants.Target, cutoff time.Time, sessions []database.UploadSession) {
+	inUse := map[string]bool{}
+	for _, session := range sessions {
+		if session.S3UploadID != "" {
+			inUse[session.S3UploadID] = true
+		}
+	}
+
+	paginator := s3.NewListMultipartUploadsPaginator(target.Client, &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(target.Bucket),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			rep.fail("couldn't list multipart uploads in %s: %v", target.Bucket, err)
+			return
+		}
+		for _, upload := range page.Uploads {
+			id := aws.ToString(upload.UploadId)
+			if inUse[id] || upload.Initiated == nil || !upload.Initiated.Before(cutoff) {
+				continue
+			}
+			name := fmt.Sprintf("s3://%s/%s?uploadId=%s", target.Bucket, aws.ToString(upload.Key), id)
+			_, err := target.Client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+				Bucket:   aws.String(target.Bucket),
+				Key:      upload.Key,
+				UploadId: upload.UploadId,
+			})
+			if err != nil {
+				rep.fail("couldn't abort %s: %v", name, err)
+				continue
+			}
+			rep.AbortedUploads = append(rep.AbortedUploads, name)
+		}
+	}
+}
+
+// sweepOrphanedObjects finds the objects in target, last changed before
+// cutoff, that nothing records: ones under the prefix of a video that no
+// longer exists, and content objects no video shares. A trashed video still
+// exists, so its objects are kept until it's purged. Keys laid out any
+// other way, such as files uploaded before keys were namespaced, are never
+// taken for orphans.
+func (cfg *apiConfig) sweepOrphanedObjects(ctx context.Context, rep *janitorReport, target tenants.Target, cutoff time.Time, deleteOrphans bool) {
+	videos := map[uuid.UUID]bool{}
+	paginator := s3.NewListObjectsV2Paginator(target.Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(target.Bucket),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			rep.fail("couldn't list objects in %s: %v", target.Bucket, err)
+			return
+		}
+		for _, obj := range page.Contents {
+			if obj.LastModified == nil || !obj.LastModified.Before(cutoff) {
+				continue
+			}
+			name := fmt.Sprintf("s3://%s/%s", target.Bucket, aws.ToString(obj.Key))
+			orphaned, err := cfg.sweepObject(ctx, target, aws.ToString(obj.Key), videos, deleteOrphans)
+			if err != nil {
+				rep.fail("couldn't sweep %s: %v", name, err)
+				continue
+			}
+			if orphaned {
+				rep.OrphanedObjects = append(rep.OrphanedObjects, name)
+			}
+		}
+	}
+}
+
+// sweepObject reports whether the object under key is orphaned, and deletes
+// it if so and deleteOrphans is set. Content objects are checked and
+// deleted under the lock that sharing them takes, so a video can't start
+// sharing one in between.
+func (cfg *apiConfig) sweepObject(ctx context.Context, target tenants.Target, key string, videos map[uuid.UUID]bool, deleteOrphans bool) (bool, error) {
+	if isContentKey(key) {
+		cfg.contentObjects.mu.Lock()
+		defer cfg.contentObjects.mu.Unlock()
+	}
+	orphaned, err := cfg.isOrphanedObject(target, key, videos)
+	if err != nil || !orphaned || !deleteOrphans {
+		return orphaned, err
+	}
+	return true, target.Storage().Delete(ctx, key)
+}
+
+// isOrphanedObject reports whether nothing records the object under key,
+// see sweepOrphanedObjects. videos caches whether each video exists.
+func (cfg *apiConfig) isOrphanedObject(target tenants.Target, key string, videos map[uuid.UUID]bool) (bool, error) {
+	if isContentKey(key) {
+		inUse, err := cfg.db.ContentObjectInUse(target.Bucket, key)
+		return !inUse, err
+	}
+
+	parts := strings.SplitN(key, "/", 3)
+	if len(parts) < 3 {
+		return false, nil
+	}
+	if _, err := uuid.Parse(parts[0]); err != nil {
+		return false, nil
+	}
+	videoID, err := uuid.Parse(parts[1])
+	if err != nil {
+		return false, nil
+	}
+	exists, ok := videos[videoID]
+	if !ok {
+		if exists, err = cfg.db.VideoExists(videoID); err != nil {
+			return false, err
+		}
+		videos[videoID] = exists
+	}
+	return !exists, nil
+}
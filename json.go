@@ -2,27 +2,58 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/i18n"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
 )
 
+// languageMiddleware negotiates the language of error messages from the
+// Accept-Language header. The choice is recorded as the Content-Language of
+// the response, which is where respondWithError reads it from.
+func languageMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if accept := r.Header.Get("Accept-Language"); accept != "" {
+			w.Header().Add("Vary", "Accept-Language")
+			w.Header().Set("Content-Language", i18n.Negotiate(accept))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// respondWithError responds with msg and its code. Errors that come of the
+// S3 circuit breaker being open are reported as such instead, see
+// respondWithStorageError, so every endpoint answers them with a 503.
 func respondWithError(w http.ResponseWriter, code int, msg string, err error) {
+	var open *storage.CircuitOpenError
+	if errors.As(err, &open) {
+		respondWithStorageError(w, code, msg, err)
+		return
+	}
 	if err != nil {
 		log.Println(err)
 	}
 	if code > 499 {
 		log.Printf("Responding with 5XX error: %s", msg)
 	}
+	lang := w.Header().Get("Content-Language")
+	if lang == "" {
+		lang = i18n.Default
+	}
 	type errorResponse struct {
 		Error string `json:"error"`
+		Code  string `json:"code"`
 	}
 	respondWithJSON(w, code, errorResponse{
-		Error: msg,
+		Error: i18n.Translate(lang, msg),
+		Code:  i18n.Code(msg, code),
 	})
 }
 
 func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
-	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	dat, err := json.Marshal(payload)
 	if err != nil {
 		log.Printf("Error marshalling JSON: %s", err)
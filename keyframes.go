@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ffmpeg"
+	"github.com/google/uuid"
+)
+
+// captureKeyframes indexes the keyframes of a video's processed file and
+// stores them in place of the previous file's, which are put back if
+// cleanup runs without being committed. If the file can't be indexed, the
+// previous file's index is still dropped, since it no longer applies.
+func (cfg *apiConfig) captureKeyframes(ctx context.Context, cleanup *cleanupStack, videoID uuid.UUID, path string) error {
+	previous, err := cfg.db.GetKeyframes(videoID)
+	if err != nil {
+		return err
+	}
+	restore := func() error {
+		if previous == nil {
+			return cfg.db.DeleteKeyframes(videoID)
+		}
+		return cfg.db.SaveKeyframes(*previous)
+	}
+	timestamps, indexErr := ffmpeg.Keyframes(ctx, path)
+	if indexErr != nil {
+		if previous == nil {
+			return indexErr
+		}
+		if err := cfg.db.DeleteKeyframes(videoID); err != nil {
+			return err
+		}
+		cleanup.onError("restore keyframe index", restore)
+		return indexErr
+	}
+	err = cfg.db.SaveKeyframes(database.Keyframes{VideoID: videoID, Timestamps: timestamps, CapturedAt: cfg.clock.Now().UTC()})
+	if err != nil {
+		return err
+	}
+	cleanup.onError("restore keyframe index", restore)
+	return nil
+}
+
+// keyframeSnap places a timestamp among a file's keyframes. A cut starting
+// OnKeyframe can copy the streams as they are; any other start has to be
+// re-encoded up to Next, or moved to Previous to avoid that.
+type keyframeSnap struct {
+	T          float64  `json:"t"`
+	Previous   *float64 `json:"previous"`
+	Next       *float64 `json:"next"`
+	OnKeyframe bool     `json:"on_keyframe"`
+}
+
+// keyframeTolerance is how close a timestamp has to be to a keyframe to
+// count as on it. Timestamps are kept to the millisecond.
+const keyframeTolerance = 0.0005
+
+// snapToKeyframe finds the keyframes around t in timestamps, which are
+// sorted. Previous is the keyframe at or before t.
+func snapToKeyframe(timestamps []float64, t float64) keyframeSnap {
+	snap := keyframeSnap{T: t}
+	i := sort.SearchFloat64s(timestamps, t-keyframeTolerance)
+	if i < len(timestamps) && math.Abs(timestamps[i]-t) <= keyframeTolerance {
+		snap.OnKeyframe = true
+		snap.Previous = &timestamps[i]
+		if i+1 < len(timestamps) {
+			snap.Next = &timestamps[i+1]
+		}
+		return snap
+	}
+	if i > 0 {
+		snap.Previous = &timestamps[i-1]
+	}
+	if i < len(timestamps) {
+		snap.Next = &timestamps[i]
+	}
+	return snap
+}
+
+// handlerVideoKeyframes returns the keyframe index of the video's file, for
+// trimming UIs to snap cuts to. With ?t=SS.mmm it also places t among the
+// keyframes. Only the owner and admins can see it.
+func (cfg *apiConfig) handlerVideoKeyframes(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	var t *float64
+	if v := r.URL.Query().Get("t"); v != "" {
+		seconds, err := strconv.ParseFloat(v, 64)
+		if err != nil || seconds < 0 || math.IsInf(seconds, 0) || math.IsNaN(seconds) {
+			respondWithError(w, http.StatusBadRequest, "t must be a non-negative timestamp in seconds", err)
+			return
+		}
+		seconds = math.Round(seconds*1000) / 1000
+		t = &seconds
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	if video.UserID != userID {
+		user, err := cfg.db.GetUser(userID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+			return
+		}
+		if !cfg.isAdmin(user) {
+			respondWithError(w, http.StatusUnauthorized, "Not authorized to access this video", nil)
+			return
+		}
+	}
+
+	keyframes, err := cfg.db.GetKeyframes(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get keyframes", err)
+		return
+	}
+	if keyframes == nil || video.VideoURL == nil {
+		respondWithError(w, http.StatusNotFound, "No keyframe index for this video", nil)
+		return
+	}
+
+	type response struct {
+		database.Keyframes
+		Count int           `json:"count"`
+		Snap  *keyframeSnap `json:"snap,omitempty"`
+	}
+	resp := response{Keyframes: *keyframes, Count: len(keyframes.Timestamps)}
+	if t != nil {
+		snap := snapToKeyframe(keyframes.Timestamps, *t)
+		resp.Snap = &snap
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
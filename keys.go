@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tenants"
+	"github.com/google/uuid"
+)
+
+// Object keys are namespaced as {userID}/{videoID}/{artifact} so that
+// everything belonging to a user or a video can be found by prefix.
+
+func userKeyPrefix(userID uuid.UUID) string {
+	return userID.String() + "/"
+}
+
+func videoKeyPrefix(userID, videoID uuid.UUID) string {
+	return fmt.Sprintf("%s%s/", userKeyPrefix(userID), videoID)
+}
+
+func videoObjectKey(userID, videoID uuid.UUID, artifact string) string {
+	return videoKeyPrefix(userID, videoID) + artifact
+}
+
+// isNamespacedKey reports whether key already lives under the owning user's
+// and video's prefix.
+func isNamespacedKey(key string, userID, videoID uuid.UUID) bool {
+	return strings.HasPrefix(key, videoKeyPrefix(userID, videoID))
+}
+
+// storedObjectKey returns the key of a video file recorded in the database.
+// Files are recorded by key, so the bucket can stay private and be swapped
+// without rewriting rows; ones uploaded before that are recorded by their
+// URL in target, which is accepted too.
+func storedObjectKey(target tenants.Target, stored string) (string, bool) {
+	if !strings.Contains(stored, "://") {
+		return stored, stored != ""
+	}
+	prefix := target.ObjectURL("")
+	if !strings.HasPrefix(stored, prefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(stored, prefix), true
+}
+
+// s3CopySource formats bucket and key for CopyObjectInput.CopySource, which
+// must be URL-encoded.
+func s3CopySource(bucket, key string) string {
+	segments := strings.Split(bucket+"/"+key, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
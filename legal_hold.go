@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tenants"
+	"github.com/google/uuid"
+)
+
+const legalHoldMessage = "This video is under legal hold"
+
+// requireNoLegalHold rejects changes to a video under legal hold. If ok is
+// false, an error response has been written.
+func requireNoLegalHold(w http.ResponseWriter, video database.Video) (ok bool) {
+	if video.LegalHold {
+		respondWithError(w, http.StatusConflict, legalHoldMessage, nil)
+		return false
+	}
+	return true
+}
+
+// handlerAdminLegalHold places or releases a video's legal hold. While it is
+// held, the video can't be deleted and its files can't be replaced. If the
+// bucket has S3 Object Lock enabled, the hold is also set on every object of
+// the video so it holds in S3 as well; object_lock in the response says
+// whether it was.
+func (cfg *apiConfig) handlerAdminLegalHold(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Held bool `json:"held"`
+	}
+	type response struct {
+		database.Video
+		ObjectLock    bool `json:"object_lock"`
+		LockedObjects int  `json:"locked_objects"`
+	}
+
+	adminID, ok := cfg.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+
+	owner, err := cfg.db.GetUser(video.UserID)
+	if err != nil || owner == nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't find video owner", err)
+		return
+	}
+	target, err := cfg.tenants.Target(r.Context(), owner.TenantID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't resolve storage for tenant", err)
+		return
+	}
+	locking, err := objectLockEnabled(r.Context(), target)
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Couldn't check bucket object lock", err)
+		return
+	}
+
+	// The flag goes on first and comes off last, so a failure part way
+	// through never leaves objects locked in S3 without the flag, or the
+	// video unprotected while some of its objects are still locked.
+	if params.Held {
+		if err := cfg.db.SetVideoLegalHold(videoID, true); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+			return
+		}
+	}
+	locked := 0
+	if locking {
+		locked, err = cfg.setObjectLegalHolds(r.Context(), target, video, params.Held)
+		if err != nil {
+			respondWithError(w, http.StatusBadGateway, "Couldn't set legal hold on video files", err)
+			return
+		}
+	}
+	if !params.Held {
+		if err := cfg.db.SetVideoLegalHold(videoID, false); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+			return
+		}
+	}
+	cfg.recordActivity(video.UserID, activityLegalHold, &videoID, &adminID, strconv.FormatBool(params.Held))
+
+	video.LegalHold = params.Held
+	video, err = cfg.dbVideoToSignedVideo(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URLs", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, response{
+		Video:         video,
+		ObjectLock:    locking,
+		LockedObjects: locked,
+	})
+}
+
+// objectLockEnabled reports whether the target bucket has S3 Object Lock
+// enabled, which object legal holds need. Other backends don't have it.
+func objectLockEnabled(ctx context.Context, target tenants.Target) (bool, error) {
+	if !target.IsS3() {
+		return false, nil
+	}
+	out, err := target.Client.GetObjectLockConfiguration(ctx, &s3.GetObjectLockConfigurationInput{
+		Bucket: aws.String(target.Bucket),
+	})
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "ObjectLockConfigurationNotFoundError" {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return out.ObjectLockConfiguration != nil &&
+		out.ObjectLockConfiguration.ObjectLockEnabled == types.ObjectLockEnabledEnabled, nil
+}
+
+// setObjectLegalHolds turns the S3 legal hold on or off for every object
+// under the video's prefix, and for its file if it predates namespaced
+// keys. A file shared with other videos by its content keeps its hold
+// while any of them is still under one. It returns how many objects it
+// changed.
+func (cfg *apiConfig) setObjectLegalHolds(ctx context.Context, target tenants.Target, video database.Video, held bool) (int, error) {
+	status := types.ObjectLockLegalHoldStatusOff
+	if held {
+		status = types.ObjectLockLegalHoldStatusOn
+	}
+
+	keys, err := videoPrefixKeys(ctx, target, video)
+	if err != nil {
+		return 0, err
+	}
+	if _, key, err := cfg.videoObject(ctx, video); err == nil && !isNamespacedKey(key, video.UserID, video.ID) {
+		shared := false
+		if !held && isContentKey(key) {
+			if shared, err = cfg.db.FileHeldByOtherVideo(key, video.ID); err != nil {
+				return 0, err
+			}
+		}
+		if !shared {
+			keys = append(keys, key)
+		}
+	}
+
+	for i, key := range keys {
+		_, err := target.Client.PutObjectLegalHold(ctx, &s3.PutObjectLegalHoldInput{
+			Bucket:    aws.String(target.Bucket),
+			Key:       aws.String(key),
+			LegalHold: &types.ObjectLockLegalHold{Status: status},
+		})
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(keys), nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/fakes"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func TestLegalHoldsOfSharedFiles(t *testing.T) {
+	type step struct {
+		video int
+		held  bool
+	}
+	tests := []struct {
+		name   string
+		videos int
+		steps  []step
+		// wantHeld is whether the file the videos share ends up under an
+		// S3 legal hold.
+		wantHeld bool
+	}{
+		{name: "only video held", videos: 1, steps: []step{{0, true}}, wantHeld: true},
+		{name: "only video released", videos: 1, steps: []step{{0, true}, {0, false}}},
+		{name: "released while another is held", videos: 2, steps: []step{{0, true}, {1, true}, {0, false}}, wantHeld: true},
+		{name: "released while another isn't held", videos: 2, steps: []step{{0, true}, {0, false}}},
+		{name: "both released", videos: 2, steps: []step{{0, true}, {1, true}, {0, false}, {1, false}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s3 := fakes.NewS3()
+			defer s3.Close()
+			s3.CreateBucket(testBucket, true)
+			cfg := newTestConfig(t, s3, newTestFFmpeg())
+			videos := make([]database.Video, tt.videos)
+			for i := range videos {
+				video, token := newTestVideo(t, cfg)
+				videos[i] = uploadTestVideo(t, cfg, video, token)
+			}
+
+			held := make([]bool, tt.videos)
+			admin := context.WithValue(context.Background(), oidcAdminKey{}, uuid.New())
+			for _, s := range tt.steps {
+				id := videos[s.video].ID.String()
+				req := httptest.NewRequestWithContext(admin, http.MethodPut, "/api/admin/videos/"+id+"/legal-hold", strings.NewReader(`{"held": `+strconv.FormatBool(s.held)+`}`))
+				req.SetPathValue("videoID", id)
+				rec := httptest.NewRecorder()
+				cfg.handlerAdminLegalHold(rec, req)
+				if rec.Code != http.StatusOK {
+					t.Fatalf("legal hold responded %d: %s", rec.Code, rec.Body)
+				}
+				held[s.video] = s.held
+			}
+
+			file := *videos[0].VideoURL
+			if !isContentKey(file) {
+				t.Fatalf("video file %s isn't stored by its content", file)
+			}
+			if got := s3.LegalHold(testBucket, file); got != tt.wantHeld {
+				t.Errorf("shared file held = %t, want %t", got, tt.wantHeld)
+			}
+			// The videos' own objects follow each video's hold.
+			for i, video := range videos {
+				if got := s3.LegalHold(testBucket, *video.PeaksURL); got != held[i] {
+					t.Errorf("peaks of video %d held = %t, want %t", i, got, held[i])
+				}
+			}
+		})
+	}
+}
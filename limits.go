@@ -0,0 +1,186 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/i18n"
+)
+
+const serverBusyMessage = "Server is busy, please try again shortly"
+
+// concurrencyLimit caps how many requests of a class of routes are handled
+// at once. A request over the cap waits up to maxWait for a slot and is
+// rejected with a 503 after that, so a burst queues briefly but overload
+// can't pile up requests that each hold a file or an ffmpeg slot.
+type concurrencyLimit struct {
+	class      string
+	slots      chan struct{}
+	waiting    atomic.Int64
+	maxWait    time.Duration
+	retryAfter time.Duration
+}
+
+// newConcurrencyLimit returns nil, which limits nothing, if limit is 0.
+func newConcurrencyLimit(class string, limit int, maxWait, retryAfter time.Duration) *concurrencyLimit {
+	if limit == 0 {
+		return nil
+	}
+	return &concurrencyLimit{
+		class:      class,
+		slots:      make(chan struct{}, limit),
+		maxWait:    maxWait,
+		retryAfter: retryAfter,
+	}
+}
+
+func (l *concurrencyLimit) acquire(r *http.Request) bool {
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+
+	l.waiting.Add(1)
+	defer l.waiting.Add(-1)
+	timer := time.NewTimer(l.maxWait)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+func (l *concurrencyLimit) release() {
+	<-l.slots
+}
+
+func (l *concurrencyLimit) middleware(next http.HandlerFunc) http.HandlerFunc {
+	if l == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !l.acquire(r) {
+			l.reject(w)
+			return
+		}
+		defer l.release()
+		next(w, r)
+	}
+}
+
+// concurrencyStatus is a snapshot of a concurrencyLimit.
+type concurrencyStatus struct {
+	RouteClass string `json:"route_class"`
+	Limit      int    `json:"limit"`
+	InFlight   int    `json:"in_flight"`
+	Queued     int    `json:"queued"`
+}
+
+func (l *concurrencyLimit) status() concurrencyStatus {
+	return concurrencyStatus{
+		RouteClass: l.class,
+		Limit:      cap(l.slots),
+		InFlight:   len(l.slots),
+		Queued:     int(l.waiting.Load()),
+	}
+}
+
+// concurrencyStatuses snapshots the limits that are enabled.
+func concurrencyStatuses(limits ...*concurrencyLimit) []concurrencyStatus {
+	statuses := []concurrencyStatus{}
+	for _, l := range limits {
+		if l != nil {
+			statuses = append(statuses, l.status())
+		}
+	}
+	return statuses
+}
+
+// reject responds with a 503 telling the client how busy the class is. The
+// suggested wait grows with the queue, so rejected clients spread out their
+// retries instead of all coming back at once.
+func (l *concurrencyLimit) reject(w http.ResponseWriter) {
+	type response struct {
+		Error string `json:"error"`
+		Code  string `json:"code"`
+		concurrencyStatus
+		RetryAfterSeconds int `json:"retry_after_seconds"`
+	}
+	status := l.status()
+	seconds := max(1, int(l.retryAfter.Seconds())*(1+status.Queued/status.Limit))
+
+	lang := w.Header().Get("Content-Language")
+	if lang == "" {
+		lang = i18n.Default
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	respondWithJSON(w, http.StatusServiceUnavailable, response{
+		Error:             i18n.Translate(lang, serverBusyMessage),
+		Code:              i18n.Code(serverBusyMessage, http.StatusServiceUnavailable),
+		concurrencyStatus: status,
+		RetryAfterSeconds: seconds,
+	})
+}
+
+const processingQueueFullMessage = "Too many uploads are waiting to be processed"
+
+// maxQueueRetryAfter caps the Retry-After of uploads turned away because
+// the processing queue is full, however long the estimate says it'll take
+// to drain.
+const maxQueueRetryAfter = 5 * time.Minute
+
+// processingQueueStatus is how full the processing queue is.
+type processingQueueStatus struct {
+	QueueDepth int `json:"queue_depth"`
+	Admitted   int `json:"admitted"`
+	AdmitLimit int `json:"admit_limit"`
+}
+
+func (cfg *apiConfig) processingQueueStatus() processingQueueStatus {
+	admitted, limit := cfg.jobs.Admitted()
+	return processingQueueStatus{
+		QueueDepth: cfg.jobs.Depth(),
+		Admitted:   admitted,
+		AdmitLimit: limit,
+	}
+}
+
+// admitUpload takes in an upload that will be processed, or responds with
+// a 429 if PROCESSING_QUEUE_LIMIT uploads are already waiting for a
+// worker. It's called before the upload is received, so a turned away
+// client hasn't sent the file for nothing. The returned release must be
+// called once the upload has been processed, or has failed.
+func (cfg *apiConfig) admitUpload(w http.ResponseWriter) (release func(), ok bool) {
+	release, ok = cfg.jobs.Admit()
+	if ok {
+		return release, true
+	}
+	type response struct {
+		Error string `json:"error"`
+		Code  string `json:"code"`
+		processingQueueStatus
+		RetryAfterSeconds int `json:"retry_after_seconds"`
+	}
+	wait := min(max(cfg.jobs.Wait(), time.Second), maxQueueRetryAfter)
+	seconds := int((wait + time.Second - 1) / time.Second)
+
+	lang := w.Header().Get("Content-Language")
+	if lang == "" {
+		lang = i18n.Default
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	respondWithJSON(w, http.StatusTooManyRequests, response{
+		Error:                 i18n.Translate(lang, processingQueueFullMessage),
+		Code:                  i18n.Code(processingQueueFullMessage, http.StatusTooManyRequests),
+		processingQueueStatus: cfg.processingQueueStatus(),
+		RetryAfterSeconds:     seconds,
+	})
+	return nil, false
+}
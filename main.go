@@ -2,109 +2,999 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/smtp"
+	"net/url"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/cloudfront"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/cdn"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/chaos"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/clock"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ffmpeg"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/fieldcrypt"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/fingerprint"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/flags"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/live"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/recommend"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/scan"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/settings"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/sqs"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tenants"
 
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 )
 
 type apiConfig struct {
-	db               database.Client
+	deps
 	jwtSecret        string
 	platform         string
 	filepathRoot     string
 	assetsRoot       string
-	s3Bucket         string
-	s3Region         string
 	s3CfDistribution string
 	port             string
-	s3Client         *s3.Client
+	adminEmails      map[string]bool
+	maintenance      *maintenanceMode
+	flags            *flags.Set
+	profiles         *ffmpeg.Profiles
+	// videoContainers are the containers videos can be uploaded in, by
+	// media type.
+	videoContainers   map[string]videoContainer
+	presignExpiry     time.Duration
+	shortsMaxDuration time.Duration
+	preserveFilenames bool
+	// publicURLExpiry and privateURLExpiry replace presignExpiry for the
+	// files of public and private videos.
+	publicURLExpiry  time.Duration
+	privateURLExpiry time.Duration
+	// hlsOutput adds HLS renditions to uploads that aren't shorts.
+	hlsOutput bool
+	// streamVideos hands out the stream endpoint as videos' file URLs, so
+	// clients never see a storage URL for them.
+	streamVideos bool
+	// metadataOnly turns away every request that would send a file's bytes
+	// through the server, see metadata_only.go.
+	metadataOnly bool
+	// socialCrops adds square and vertical crops of landscape uploads as
+	// renditions, for cross-posting to social networks.
+	socialCrops bool
+	// reportHoldThreshold is how many distinct users must report a video
+	// before it is held for review; 0 disables automatic holds.
+	reportHoldThreshold int
+	// fingerprints checks uploads against reference works; nil disables
+	// the check.
+	fingerprints fingerprint.Checker
+	// scanner checks uploaded files for malware or content to moderate;
+	// nil disables the check. Its verdicts are cached for scanCacheTTL
+	// under scannerName, so changing scanners scans files again.
+	scanner      scan.Scanner
+	scannerName  string
+	scanCacheTTL time.Duration
+	// ageGate is ageGateConfirm or ageGateVerified.
+	ageGate string
+	// thumbnails is the variant set generated for each thumbnail.
+	thumbnails      thumbnailPipeline
+	thumbnailRegens *thumbnailRegens
+	// gifExports are the GIFs of video time ranges being made.
+	gifExports *gifExports
+	// autoThumbnail picks the frame uploaded videos without a thumbnail
+	// get one from.
+	autoThumbnail autoThumbnail
+	// trendingWindows are the windows GET /api/videos/trending counts
+	// views over, the default first.
+	trendingWindows []trendingWindow
+	// recommender scores videos for GET /api/me/recommendations.
+	recommender recommend.Scorer
+	// siteURL is the public base URL of the server, used for the absolute
+	// URLs in the sitemap.
+	siteURL string
+	// sitemapPageURL is the URL of a video's page, with {id} standing in
+	// for the video's ID.
+	sitemapPageURL string
+	sitemap        *siteMap
+	// storageMigrations runs blue/green migrations of the default bucket.
+	storageMigrations *storageMigrations
+	// background tracks the background jobs and loops shutdown waits for.
+	background *backgroundJobs
+	// janitor sweeps up abandoned uploads, scratch files and orphaned
+	// objects.
+	janitor *janitor
+	// slo counts the events the service-level objectives are measured on.
+	slo *sloTracker
+	// s3Options are applied to the S3 clients created after startup, as
+	// they are to the default and tenant clients.
+	s3Options []func(*s3.Options)
+	// s3Resilience retries the calls of every S3 client and holds the
+	// breaker they share.
+	s3Resilience *storage.Resilience
+	// adminOIDC validates the identity provider's tokens for the /admin/api
+	// routes; nil leaves them out.
+	adminOIDC *auth.OIDCVerifier
+	// metricsToken, if set, must be sent to scrape /metrics.
+	metricsToken string
+	// fields encrypts personal data and secrets in the database and
+	// peppers API key hashes; nil stores them as they are.
+	fields *fieldcrypt.Keyring
+	// chaos injects faults for testing; nil disables it.
+	chaos *chaos.Injector
+	// maxThumbnailCandidates caps how many thumbnails a video can A/B test.
+	maxThumbnailCandidates int
+	// cdn purges replaced assets from edge caches; nil disables it.
+	cdn cdn.Invalidator
+	// cdnURL is the base URL of the distribution video files and
+	// thumbnails are handed out on; empty serves them from S3 and the
+	// server. deps.signer signs those URLs for private content.
+	// cdnCookieDomain, if set, has HLS playlists hand out signed cookies
+	// for that domain instead of signing each segment.
+	cdnURL          string
+	cdnCookieDomain string
+	// cdnPrefetch warms the edge cache with videos that finish
+	// processing; nil disables it.
+	cdnPrefetch *cdnPrefetch
+	// queueAlerts raises alerts when the processing queue falls behind;
+	// nil when no thresholds are set.
+	queueAlerts *queueAlerts
+	// uploadSessionTTL is how long an upload session lives without a
+	// heartbeat, and uploadSessionMaxAge how long heartbeats can keep it
+	// alive.
+	uploadSessionTTL    time.Duration
+	uploadSessionMaxAge time.Duration
+	// trashRetention is how long deleted videos stay in the trash before
+	// they're purged, or 0 to delete them at once.
+	trashRetention time.Duration
+	// uploadSpoolDir holds the chunks of append upload sessions and the
+	// video uploads waiting to be processed, and uploadAppends the
+	// sessions being appended to.
+	uploadSpoolDir string
+	uploadAppends  *uploadAppends
+	// uploadProgress tracks the uploads clients can follow.
+	uploadProgress *uploadProgresses
+	// minUploadRate is the slowest a client may send an upload before
+	// it's ended with a 408.
+	minUploadRate uploadRate
+	// resizeKey signs on-the-fly resize URLs; empty disables resizing.
+	resizeKey []byte
+	// uploadLimit and readLimit cap concurrent media uploads and metadata
+	// reads; nil disables a cap.
+	uploadLimit *concurrencyLimit
+	readLimit   *concurrencyLimit
+	// uploadRateLimit and readRateLimit cap how often each user and IP
+	// can upload and read; nil disables a limit.
+	uploadRateLimit *rateLimit
+	readRateLimit   *rateLimit
+	// passphraseRateLimit caps passphrase attempts per client IP and
+	// video; nil disables it. unlockTTL is how long an unlock lasts.
+	passphraseRateLimit *rateLimit
+	unlockTTL           time.Duration
+	// deviceTokenTTL is how long a device token lasts.
+	deviceTokenTTL time.Duration
+	// dailyUploadLimit caps each user's uploads a day; nil is no cap.
+	dailyUploadLimit *dailyUploadLimit
+	// trustForwardedFor takes client IPs from X-Forwarded-For.
+	trustForwardedFor bool
+
+	live      *live.Manager
+	multipart multipartConfig
+	// assets stores thumbnails, which are served from assetsRoot.
+	assets storage.Storage
+	// bucketThumbnails saves new thumbnails to the default target under
+	// thumbnails/ instead, see assetStore.
+	bucketThumbnails bool
+	// signedURLs caches the presigned URLs returned for video files.
+	signedURLs *signedURLCache
+	// contentObjects guards the references to shared video files.
+	contentObjects *contentObjects
+	// cacheWebhookSecret signs cache webhooks; nil disables them.
+	cacheWebhookSecret []byte
+	// webhooks delivers events to the webhooks users register.
+	webhooks *webhookDispatcher
+	// premieres starts premieres when they're due.
+	premieres *premiereScheduler
+	// hlsBackfill encodes the HLS renditions videos were published
+	// without.
+	hlsBackfill *hlsBackfill
+	// scheduler runs the recurring maintenance tasks.
+	scheduler *taskScheduler
+	// sftpIngest is set when SFTP drops are ingested.
+	sftpIngest *sftpIngestConfig
+	// emailIngest is set when mail to email-in addresses is ingested.
+	emailIngest *emailIngestConfig
+	// backupKey encrypts database backups; nil disables them.
+	// backupRetention is how many backups are kept.
+	backupKey       []byte
+	backupRetention int
+	// warehousePrefix is where daily warehouse exports are written; empty
+	// disables them.
+	warehousePrefix string
+	// defaultStorageQuota is how many bytes each user can store unless
+	// they have a quota of their own; 0 is no limit.
+	defaultStorageQuota int64
 }
 
-func newS3Client(ctx context.Context, region string) (*s3.Client, error) {
+func newS3Client(ctx context.Context, region string, optFns ...func(*s3.Options)) (*s3.Client, error) {
 	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
 	if err != nil {
 		return nil, err
 	}
-	return s3.NewFromConfig(cfg), nil
+	return s3.NewFromConfig(cfg, optFns...), nil
+}
+
+// newCloudFrontClient creates a client for CloudFront, whose API is only
+// served from us-east-1.
+func newCloudFrontClient(ctx context.Context) (*cloudfront.Client, error) {
+	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion("us-east-1"))
+	if err != nil {
+		return nil, err
+	}
+	return cloudfront.NewFromConfig(cfg), nil
 }
 
 func main() {
 	godotenv.Load(".env")
+	if err := loadConfig(); err != nil {
+		log.Fatal(err)
+	}
 
-	pathToDB := os.Getenv("DB_PATH")
-	if pathToDB == "" {
-		log.Fatal("DB_URL must be set")
+	logger, err := newLogger()
+	if err != nil {
+		log.Fatal(err)
 	}
+	slog.SetDefault(logger)
 
+	// loadConfig has checked the settings read here.
+	pathToDB := os.Getenv("DB_PATH")
 	db, err := database.NewClient(pathToDB)
 	if err != nil {
 		log.Fatalf("Couldn't connect to database: %v", err)
 	}
 
 	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		log.Fatal("JWT_SECRET environment variable is not set")
+	platform := os.Getenv("PLATFORM")
+	filepathRoot := os.Getenv("FILEPATH_ROOT")
+	assetsRoot := os.Getenv("ASSETS_ROOT")
+	s3Bucket := os.Getenv("S3_BUCKET")
+	s3Region := os.Getenv("S3_REGION")
+	s3CfDistribution := os.Getenv("S3_CF_DISTRO")
+	port := os.Getenv("PORT")
+
+	siteURL := "http://localhost:" + port
+	if v := os.Getenv("SITE_URL"); v != "" {
+		u, err := url.Parse(v)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			log.Fatal("SITE_URL must be an absolute http or https URL")
+		}
+		siteURL = strings.TrimSuffix(v, "/")
+	}
+	sitemapPageURL := siteURL + "/app/?video={id}"
+	if v := os.Getenv("SITEMAP_PAGE_URL"); v != "" {
+		if !strings.Contains(v, "{id}") {
+			log.Fatal("SITEMAP_PAGE_URL must contain {id}")
+		}
+		sitemapPageURL = v
 	}
 
-	platform := os.Getenv("PLATFORM")
-	if platform == "" {
-		log.Fatal("PLATFORM environment variable is not set")
+	processingWorkers := runtime.NumCPU()
+	if v := os.Getenv("PROCESSING_WORKERS"); v != "" {
+		processingWorkers, err = strconv.Atoi(v)
+		if err != nil || processingWorkers < 1 {
+			log.Fatal("PROCESSING_WORKERS must be a positive integer")
+		}
+	}
+	processingQueueLimit := 10
+	if v := os.Getenv("PROCESSING_QUEUE_LIMIT"); v != "" {
+		processingQueueLimit, err = strconv.Atoi(v)
+		if err != nil || processingQueueLimit < 0 {
+			log.Fatal("PROCESSING_QUEUE_LIMIT must be a non-negative integer")
+		}
 	}
 
-	filepathRoot := os.Getenv("FILEPATH_ROOT")
-	if filepathRoot == "" {
-		log.Fatal("FILEPATH_ROOT environment variable is not set")
+	presignExpiry := 15 * time.Minute
+	if v := os.Getenv("PRESIGN_EXPIRY"); v != "" {
+		presignExpiry, err = time.ParseDuration(v)
+		if err != nil || presignExpiry <= 0 {
+			log.Fatal("PRESIGN_EXPIRY must be a positive duration")
+		}
 	}
 
-	assetsRoot := os.Getenv("ASSETS_ROOT")
-	if assetsRoot == "" {
-		log.Fatal("ASSETS_ROOT environment variable is not set")
+	publicURLExpiry := 24 * time.Hour
+	if v := os.Getenv("PUBLIC_URL_EXPIRY"); v != "" {
+		publicURLExpiry, err = time.ParseDuration(v)
+		if err != nil || publicURLExpiry <= 0 || publicURLExpiry > maxDirectUploadURLExpiry {
+			log.Fatal("PUBLIC_URL_EXPIRY must be a positive duration of at most 168h")
+		}
 	}
 
-	s3Bucket := os.Getenv("S3_BUCKET")
-	if s3Bucket == "" {
-		log.Fatal("S3_BUCKET environment variable is not set")
+	privateURLExpiry := 5 * time.Minute
+	if v := os.Getenv("PRIVATE_URL_EXPIRY"); v != "" {
+		privateURLExpiry, err = time.ParseDuration(v)
+		if err != nil || privateURLExpiry <= 0 || privateURLExpiry > maxDirectUploadURLExpiry {
+			log.Fatal("PRIVATE_URL_EXPIRY must be a positive duration of at most 168h")
+		}
 	}
 
-	s3Region := os.Getenv("S3_REGION")
-	if s3Region == "" {
-		log.Fatal("S3_REGION environment variable is not set")
+	defaultAPIVersion := apiVersionLegacy
+	if v := os.Getenv("API_DEFAULT_VERSION"); v != "" {
+		defaultAPIVersion, err = parseAPIVersion(v)
+		if err != nil {
+			log.Fatalf("Invalid API_DEFAULT_VERSION: %v", err)
+		}
 	}
 
-	s3CfDistribution := os.Getenv("S3_CF_DISTRO")
-	if s3CfDistribution == "" {
-		log.Fatal("S3_CF_DISTRO environment variable is not set")
+	deadLinkSweepInterval := 24 * time.Hour
+	if v := os.Getenv("DEAD_LINK_SWEEP_INTERVAL"); v != "" {
+		deadLinkSweepInterval, err = time.ParseDuration(v)
+		if err != nil || deadLinkSweepInterval < 0 {
+			log.Fatal("DEAD_LINK_SWEEP_INTERVAL must be a duration, or 0 to disable")
+		}
 	}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		log.Fatal("PORT environment variable is not set")
+	janitorInterval := 6 * time.Hour
+	if v := os.Getenv("JANITOR_INTERVAL"); v != "" {
+		janitorInterval, err = time.ParseDuration(v)
+		if err != nil || janitorInterval < 0 {
+			log.Fatal("JANITOR_INTERVAL must be a duration, or 0 to disable")
+		}
+	}
+	janitorMaxAge := 24 * time.Hour
+	if v := os.Getenv("JANITOR_MAX_AGE"); v != "" {
+		janitorMaxAge, err = time.ParseDuration(v)
+		if err != nil || janitorMaxAge <= 0 {
+			log.Fatal("JANITOR_MAX_AGE must be a positive duration")
+		}
+	}
+	janitorDeleteOrphans := os.Getenv("JANITOR_DELETE_ORPHANS") == "true"
+
+	warmupEnabled := os.Getenv("WARMUP") == "true"
+	warmupTimeout := 30 * time.Second
+	if v := os.Getenv("WARMUP_TIMEOUT"); v != "" {
+		warmupTimeout, err = time.ParseDuration(v)
+		if err != nil || warmupTimeout <= 0 {
+			log.Fatal("WARMUP_TIMEOUT must be a positive duration")
+		}
+	}
+
+	shutdownTimeout := 30 * time.Second
+	if v := os.Getenv("SHUTDOWN_TIMEOUT"); v != "" {
+		shutdownTimeout, err = time.ParseDuration(v)
+		if err != nil || shutdownTimeout <= 0 {
+			log.Fatal("SHUTDOWN_TIMEOUT must be a positive duration")
+		}
+	}
+
+	slos := sloConfig{
+		period:                  7 * 24 * time.Hour,
+		uploadSuccessTarget:     0.99,
+		processingLatencyTarget: 0.95,
+		processingLatency:       10 * time.Minute,
+		playbackTarget:          0.999,
+		fastBurnRate:            14.4,
+		slowBurnRate:            6,
+	}
+	if v := os.Getenv("SLO_PERIOD"); v != "" {
+		slos.period, err = time.ParseDuration(v)
+		if err != nil || slos.period < 24*time.Hour || slos.period > 30*24*time.Hour {
+			log.Fatal("SLO_PERIOD must be a duration from 24h to 720h")
+		}
+	}
+	if v := os.Getenv("SLO_UPLOAD_SUCCESS_TARGET"); v != "" {
+		slos.uploadSuccessTarget, err = strconv.ParseFloat(v, 64)
+		if err != nil || slos.uploadSuccessTarget <= 0 || slos.uploadSuccessTarget >= 1 {
+			log.Fatal("SLO_UPLOAD_SUCCESS_TARGET must be a number between 0 and 1, such as 0.99")
+		}
+	}
+	if v := os.Getenv("SLO_PROCESSING_LATENCY_TARGET"); v != "" {
+		slos.processingLatencyTarget, err = strconv.ParseFloat(v, 64)
+		if err != nil || slos.processingLatencyTarget <= 0 || slos.processingLatencyTarget >= 1 {
+			log.Fatal("SLO_PROCESSING_LATENCY_TARGET must be a number between 0 and 1, such as 0.99")
+		}
+	}
+	if v := os.Getenv("SLO_PLAYBACK_AVAILABILITY_TARGET"); v != "" {
+		slos.playbackTarget, err = strconv.ParseFloat(v, 64)
+		if err != nil || slos.playbackTarget <= 0 || slos.playbackTarget >= 1 {
+			log.Fatal("SLO_PLAYBACK_AVAILABILITY_TARGET must be a number between 0 and 1, such as 0.99")
+		}
+	}
+	if v := os.Getenv("SLO_PROCESSING_LATENCY"); v != "" {
+		slos.processingLatency, err = time.ParseDuration(v)
+		if err != nil || slos.processingLatency <= 0 {
+			log.Fatal("SLO_PROCESSING_LATENCY must be a positive duration")
+		}
+	}
+	if v := os.Getenv("SLO_FAST_BURN_RATE"); v != "" {
+		slos.fastBurnRate, err = strconv.ParseFloat(v, 64)
+		if err != nil || slos.fastBurnRate <= 0 {
+			log.Fatal("SLO_FAST_BURN_RATE must be a positive number")
+		}
+	}
+	if v := os.Getenv("SLO_SLOW_BURN_RATE"); v != "" {
+		slos.slowBurnRate, err = strconv.ParseFloat(v, 64)
+		if err != nil || slos.slowBurnRate <= 0 {
+			log.Fatal("SLO_SLOW_BURN_RATE must be a positive number")
+		}
+	}
+
+	shortsMaxDuration := 60 * time.Second
+	if v := os.Getenv("SHORTS_MAX_DURATION"); v != "" {
+		shortsMaxDuration, err = time.ParseDuration(v)
+		if err != nil || shortsMaxDuration <= 0 {
+			log.Fatal("SHORTS_MAX_DURATION must be a positive duration")
+		}
+	}
+
+	preserveFilenames := os.Getenv("PRESERVE_FILENAMES") == "true"
+
+	hlsOutput := os.Getenv("HLS_OUTPUT") == "true"
+	streamVideos := os.Getenv("STREAM_VIDEOS") == "true"
+	metadataOnly := os.Getenv("METADATA_ONLY") == "true"
+	socialCrops := os.Getenv("SOCIAL_CROPS") == "true"
+
+	reportHoldThreshold := 5
+	if v := os.Getenv("REPORT_HOLD_THRESHOLD"); v != "" {
+		reportHoldThreshold, err = strconv.Atoi(v)
+		if err != nil || reportHoldThreshold < 0 {
+			log.Fatal("REPORT_HOLD_THRESHOLD must be a non-negative integer")
+		}
+	}
+
+	ageGate := ageGateConfirm
+	if v := os.Getenv("AGE_GATE_MODE"); v != "" {
+		if v != ageGateConfirm && v != ageGateVerified {
+			log.Fatal("AGE_GATE_MODE must be confirm or verified")
+		}
+		ageGate = v
 	}
 
-	ctx := context.TODO()
-	s3Client, err := newS3Client(ctx, s3Region)
+	thumbnails, err := parseThumbnailPipeline(os.Getenv("THUMBNAIL_WIDTHS"), os.Getenv("THUMBNAIL_FORMATS"))
 	if err != nil {
-		log.Fatalf("Couldn't create S3 client: %v", err)
+		log.Fatalf("Invalid thumbnail settings: %v", err)
+	}
+
+	autoThumbnail, err := parseAutoThumbnail(os.Getenv("AUTO_THUMBNAIL"))
+	if err != nil {
+		log.Fatalf("Invalid AUTO_THUMBNAIL: %v", err)
+	}
+
+	trendingWindows, err := parseTrendingWindows(os.Getenv("TRENDING_WINDOWS"))
+	if err != nil {
+		log.Fatalf("Invalid TRENDING_WINDOWS: %v", err)
+	}
+
+	maxThumbnailCandidates := 4
+	if v := os.Getenv("THUMBNAIL_MAX_CANDIDATES"); v != "" {
+		maxThumbnailCandidates, err = strconv.Atoi(v)
+		if err != nil || maxThumbnailCandidates < 2 {
+			log.Fatal("THUMBNAIL_MAX_CANDIDATES must be an integer of at least 2")
+		}
+	}
+
+	resizeKey := []byte(os.Getenv("IMAGE_RESIZE_KEY"))
+
+	uploadSessionTTL := 5 * time.Minute
+	if v := os.Getenv("UPLOAD_SESSION_TTL"); v != "" {
+		uploadSessionTTL, err = time.ParseDuration(v)
+		if err != nil || uploadSessionTTL <= 0 {
+			log.Fatal("UPLOAD_SESSION_TTL must be a positive duration")
+		}
+	}
+
+	uploadSessionMaxAge := 24 * time.Hour
+	if v := os.Getenv("UPLOAD_SESSION_MAX_AGE"); v != "" {
+		uploadSessionMaxAge, err = time.ParseDuration(v)
+		if err != nil || uploadSessionMaxAge < uploadSessionTTL {
+			log.Fatal("UPLOAD_SESSION_MAX_AGE must be a duration of at least UPLOAD_SESSION_TTL")
+		}
+	}
+
+	trashRetention := 30 * 24 * time.Hour
+	if v := os.Getenv("TRASH_RETENTION"); v != "" {
+		trashRetention, err = time.ParseDuration(v)
+		if err != nil || trashRetention < 0 {
+			log.Fatal("TRASH_RETENTION must be a duration, or 0 to delete videos at once")
+		}
+	}
+
+	uploadSpoolDir := filepath.Join(os.TempDir(), "tubely-uploads")
+	if v := os.Getenv("UPLOAD_SPOOL_DIR"); v != "" {
+		uploadSpoolDir = v
+	}
+
+	minUploadRate := uploadRate{bytesPerSecond: 4 << 10, window: 30 * time.Second}
+	if v := os.Getenv("UPLOAD_MIN_RATE_KBPS"); v != "" {
+		kbps, err := strconv.Atoi(v)
+		if err != nil || kbps < 0 {
+			log.Fatal("UPLOAD_MIN_RATE_KBPS must be a non-negative integer")
+		}
+		minUploadRate.bytesPerSecond = int64(kbps) << 10
+	}
+	if v := os.Getenv("UPLOAD_MIN_RATE_WINDOW"); v != "" {
+		minUploadRate.window, err = time.ParseDuration(v)
+		if err != nil || minUploadRate.window < time.Second {
+			log.Fatal("UPLOAD_MIN_RATE_WINDOW must be a duration of at least 1s")
+		}
+	}
+
+	uploadConcurrency := 20
+	if v := os.Getenv("UPLOAD_CONCURRENCY"); v != "" {
+		uploadConcurrency, err = strconv.Atoi(v)
+		if err != nil || uploadConcurrency < 0 {
+			log.Fatal("UPLOAD_CONCURRENCY must be a non-negative integer")
+		}
+	}
+
+	multipart := multipartConfig{partSize: defaultMultipartPartSize, concurrency: defaultMultipartConcurrency}
+	if v := os.Getenv("S3_PART_SIZE_MB"); v != "" {
+		mb, err := strconv.Atoi(v)
+		if err != nil || mb < minPartSize>>20 {
+			log.Fatalf("S3_PART_SIZE_MB must be an integer of at least %d", minPartSize>>20)
+		}
+		multipart.partSize = int64(mb) << 20
+	}
+	if v := os.Getenv("S3_UPLOAD_PART_CONCURRENCY"); v != "" {
+		multipart.concurrency, err = strconv.Atoi(v)
+		if err != nil || multipart.concurrency < 1 {
+			log.Fatal("S3_UPLOAD_PART_CONCURRENCY must be a positive integer")
+		}
+	}
+
+	var defaultStorageQuota int64
+	if v := os.Getenv("USER_STORAGE_QUOTA_MB"); v != "" {
+		mb, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || mb < 0 {
+			log.Fatal("USER_STORAGE_QUOTA_MB must be a non-negative integer")
+		}
+		defaultStorageQuota = mb << 20
+	}
+
+	readConcurrency := 200
+	if v := os.Getenv("READ_CONCURRENCY"); v != "" {
+		readConcurrency, err = strconv.Atoi(v)
+		if err != nil || readConcurrency < 0 {
+			log.Fatal("READ_CONCURRENCY must be a non-negative integer")
+		}
+	}
+
+	uploadRateLimit := 30
+	if v := os.Getenv("UPLOAD_RATE_LIMIT"); v != "" {
+		uploadRateLimit, err = strconv.Atoi(v)
+		if err != nil || uploadRateLimit < 0 {
+			log.Fatal("UPLOAD_RATE_LIMIT must be a non-negative integer")
+		}
+	}
+	uploadRateLimitIP := 60
+	if v := os.Getenv("UPLOAD_RATE_LIMIT_IP"); v != "" {
+		uploadRateLimitIP, err = strconv.Atoi(v)
+		if err != nil || uploadRateLimitIP < 0 {
+			log.Fatal("UPLOAD_RATE_LIMIT_IP must be a non-negative integer")
+		}
+	}
+	readRateLimit := 600
+	if v := os.Getenv("READ_RATE_LIMIT"); v != "" {
+		readRateLimit, err = strconv.Atoi(v)
+		if err != nil || readRateLimit < 0 {
+			log.Fatal("READ_RATE_LIMIT must be a non-negative integer")
+		}
+	}
+	readRateLimitIP := 1200
+	if v := os.Getenv("READ_RATE_LIMIT_IP"); v != "" {
+		readRateLimitIP, err = strconv.Atoi(v)
+		if err != nil || readRateLimitIP < 0 {
+			log.Fatal("READ_RATE_LIMIT_IP must be a non-negative integer")
+		}
+	}
+	passphraseAttempts := 5
+	if v := os.Getenv("PASSPHRASE_ATTEMPTS"); v != "" {
+		passphraseAttempts, err = strconv.Atoi(v)
+		if err != nil || passphraseAttempts < 0 {
+			log.Fatal("PASSPHRASE_ATTEMPTS must be a non-negative integer")
+		}
+	}
+	unlockTTL := 24 * time.Hour
+	if v := os.Getenv("UNLOCK_TOKEN_TTL"); v != "" {
+		unlockTTL, err = time.ParseDuration(v)
+		if err != nil || unlockTTL <= 0 {
+			log.Fatal("UNLOCK_TOKEN_TTL must be a positive duration")
+		}
+	}
+	deviceTokenTTL := 90 * 24 * time.Hour
+	if v := os.Getenv("DEVICE_TOKEN_TTL"); v != "" {
+		deviceTokenTTL, err = time.ParseDuration(v)
+		if err != nil || deviceTokenTTL <= 0 {
+			log.Fatal("DEVICE_TOKEN_TTL must be a positive duration")
+		}
+	}
+	dailyUploadLimit := 200
+	if v := os.Getenv("UPLOAD_DAILY_LIMIT"); v != "" {
+		dailyUploadLimit, err = strconv.Atoi(v)
+		if err != nil || dailyUploadLimit < 0 {
+			log.Fatal("UPLOAD_DAILY_LIMIT must be a non-negative integer")
+		}
+	}
+
+	var cacheWebhookSecret []byte
+	if v := os.Getenv("CACHE_WEBHOOK_SECRET"); v != "" {
+		if len(v) < 32 {
+			log.Fatal("CACHE_WEBHOOK_SECRET must be at least 32 characters")
+		}
+		cacheWebhookSecret = []byte(v)
+	}
+
+	webhookAllowPrivateHosts := os.Getenv("WEBHOOK_ALLOW_PRIVATE_HOSTS") == "true"
+
+	var backupKey []byte
+	if v := os.Getenv("DB_BACKUP_KEY"); v != "" {
+		backupKey, err = base64.StdEncoding.DecodeString(v)
+		if err != nil || len(backupKey) != 32 {
+			log.Fatal("DB_BACKUP_KEY must be 32 bytes, base64-encoded")
+		}
+	}
+
+	backupInterval := 24 * time.Hour
+	if v := os.Getenv("DB_BACKUP_INTERVAL"); v != "" {
+		backupInterval, err = time.ParseDuration(v)
+		if err != nil || backupInterval < 0 {
+			log.Fatal("DB_BACKUP_INTERVAL must be a duration, or 0 to disable")
+		}
+	}
+
+	backupRetention := 14
+	if v := os.Getenv("DB_BACKUP_RETENTION"); v != "" {
+		backupRetention, err = strconv.Atoi(v)
+		if err != nil || backupRetention < 1 {
+			log.Fatal("DB_BACKUP_RETENTION must be a positive integer")
+		}
+	}
+
+	warehousePrefix := os.Getenv("WAREHOUSE_EXPORT_PREFIX")
+	if warehousePrefix != "" && !strings.HasSuffix(warehousePrefix, "/") {
+		warehousePrefix += "/"
 	}
 
+	adminEmails := parseAdminEmails(os.Getenv("ADMIN_EMAILS"))
+	adminOIDC, err := adminOIDCFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	maintenanceEnabled := os.Getenv("MAINTENANCE_MODE") == "true"
+
+	var flagConfig []flags.Flag
+	if path := os.Getenv("FEATURE_FLAGS_PATH"); path != "" {
+		flagConfig, err = flags.LoadFile(path)
+		if err != nil {
+			log.Fatalf("Couldn't load feature flags: %v", err)
+		}
+	}
+	featureFlags, err := flags.NewSet(flagConfig)
+	if err != nil {
+		log.Fatalf("Invalid feature flags: %v", err)
+	}
+
+	profiles := ffmpeg.DefaultProfiles()
+	if path := os.Getenv("TRANSCODE_PROFILES_PATH"); path != "" {
+		profiles, err = ffmpeg.LoadProfiles(path)
+		if err != nil {
+			log.Fatalf("Couldn't load transcode profiles: %v", err)
+		}
+	}
+	containerNames := os.Getenv("VIDEO_CONTAINERS")
+	if containerNames == "" {
+		containerNames = defaultVideoContainers
+	}
+	videoContainers, err := parseVideoContainers(containerNames)
+	if err != nil {
+		log.Fatalf("Invalid VIDEO_CONTAINERS: %v", err)
+	}
+
+	// Chaos mode is for integration tests and staging only.
+	var chaosInjector *chaos.Injector
+	if os.Getenv("CHAOS_MODE") == "true" {
+		chaosConfig, err := chaosConfigFromEnv()
+		if err != nil {
+			log.Fatalf("Invalid chaos config: %v", err)
+		}
+		chaosInjector, err = chaos.New(chaosConfig)
+		if err != nil {
+			log.Fatalf("Invalid chaos config: %v", err)
+		}
+		db = db.WithWriteFault(chaosInjector.DBWriteFault)
+		ffmpeg.Fault = chaosInjector.FFmpegFault
+		log.Printf("Chaos mode is on: S3 errors %g, ffmpeg timeouts %g, DB write errors %g, disk delay %dms, clock skew %dms",
+			chaosConfig.S3ErrorRate, chaosConfig.FFmpegTimeoutRate, chaosConfig.DBWriteErrorRate, chaosConfig.DiskDelayMS, chaosConfig.ClockSkewMS)
+	}
+	var serverClock clock.Clock = clock.System
+	if chaosInjector != nil {
+		serverClock = chaosInjector
+	}
+	auth.Clock = serverClock
+	storage.Clock = serverClock
+
+	s3Resilience, err := s3ResilienceFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// ctx is cancelled on shutdown, stopping the background loops.
+	ctx, stopLoops := context.WithCancel(context.Background())
+	fields, err := fieldKeyringFromEnv(ctx, s3Region)
+	if err != nil {
+		log.Fatalf("Invalid field encryption keys: %v", err)
+	}
+	db = db.WithFieldEncryption(fields)
+	ffmpeg.Completed = telemetry.observeFFmpeg
+	s3Options := []func(*s3.Options){s3Resilience.Options, chaosInjector.S3Options, telemetry.S3Options}
+	var localStorage *storage.Local
+	switch backend := os.Getenv("STORAGE_BACKEND"); backend {
+	case "", "s3":
+	case "s3-compatible":
+		endpoint := os.Getenv("S3_ENDPOINT")
+		if endpoint == "" {
+			log.Fatal("S3_ENDPOINT must be set when STORAGE_BACKEND is s3-compatible")
+		}
+		s3Options = append(s3Options, storage.WithEndpoint(endpoint, os.Getenv("S3_PATH_STYLE") != "false"))
+	case "local":
+		dir := os.Getenv("STORAGE_LOCAL_DIR")
+		if dir == "" {
+			dir = "storage"
+		}
+		if os.Getenv("TENANTS_PATH") != "" || os.Getenv("S3_RETENTION_MODE") != "" || os.Getenv("S3_SSE_MODE") != "" || os.Getenv("DB_BACKUP_KEY") != "" {
+			log.Fatal("TENANTS_PATH, S3_RETENTION_MODE, S3_SSE_MODE and DB_BACKUP_KEY need STORAGE_BACKEND s3 or s3-compatible")
+		}
+		localStorage = storage.NewLocal(dir, "http://localhost:"+port+"/storage", []byte(jwtSecret))
+	default:
+		log.Fatalf("Unknown STORAGE_BACKEND %q, expected s3, s3-compatible or local", backend)
+	}
+	if os.Getenv("S3_REQUESTER_PAYS") == "true" {
+		s3Options = append(s3Options, storage.WithRequesterPays)
+	}
+	thumbnailStorage := os.Getenv("THUMBNAIL_STORAGE")
+	if thumbnailStorage == "" {
+		thumbnailStorage = "bucket"
+		if platform == "dev" {
+			thumbnailStorage = "local"
+		}
+	}
+	if thumbnailStorage != "local" && thumbnailStorage != "bucket" {
+		log.Fatalf("Unknown THUMBNAIL_STORAGE %q, expected local or bucket", thumbnailStorage)
+	}
+
+	var s3Client *s3.Client
+	if localStorage == nil {
+		s3Client, err = newS3Client(ctx, s3Region, s3Options...)
+		if err != nil {
+			log.Fatalf("Couldn't create S3 client: %v", err)
+		}
+	}
+
+	var tenantConfig []tenants.Tenant
+	if path := os.Getenv("TENANTS_PATH"); path != "" {
+		tenantConfig, err = tenants.LoadFile(path)
+		if err != nil {
+			log.Fatalf("Couldn't load tenants: %v", err)
+		}
+	}
+	var s3Retention *tenants.Retention
+	if mode := os.Getenv("S3_RETENTION_MODE"); mode != "" {
+		days, err := strconv.Atoi(os.Getenv("S3_RETENTION_DAYS"))
+		if err != nil {
+			log.Fatalf("Invalid S3_RETENTION_DAYS: %v", err)
+		}
+		s3Retention = &tenants.Retention{Mode: mode, Days: days}
+	}
+	var s3Encryption *tenants.Encryption
+	if mode := os.Getenv("S3_SSE_MODE"); mode != "" {
+		s3Encryption = &tenants.Encryption{Mode: mode, KMSKeyID: os.Getenv("S3_SSE_KMS_KEY_ID")}
+	}
+	hotlink := tenants.Hotlink{
+		BlockEmptyReferrer: os.Getenv("HOTLINK_BLOCK_EMPTY_REFERRER") == "true",
+		RequireToken:       os.Getenv("HOTLINK_REQUIRE_TOKEN") == "true",
+	}
+	for _, host := range strings.Split(os.Getenv("HOTLINK_ALLOWED_REFERRERS"), ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			hotlink.AllowedReferrers = append(hotlink.AllowedReferrers, host)
+		}
+	}
+	defaultTarget := tenants.Target{
+		Client:          s3Client,
+		Bucket:          s3Bucket,
+		Region:          s3Region,
+		Retention:       s3Retention,
+		Encryption:      s3Encryption,
+		NoIndexUnlisted: os.Getenv("NOINDEX_UNLISTED") == "true",
+	}
+	if hotlink.BlockEmptyReferrer || hotlink.RequireToken || len(hotlink.AllowedReferrers) > 0 {
+		defaultTarget.Hotlink = &hotlink
+	}
+	if localStorage != nil {
+		defaultTarget.Backend = localStorage
+	}
+	var sftpIngest *sftpIngestConfig
+	if bucket := os.Getenv("SFTP_INGEST_BUCKET"); bucket != "" {
+		secret := os.Getenv("SFTP_INGEST_SECRET")
+		if len(secret) < 32 {
+			log.Fatal("SFTP_INGEST_BUCKET needs SFTP_INGEST_SECRET, at least 32 characters")
+		}
+		if s3Client == nil {
+			log.Fatal("SFTP_INGEST_BUCKET needs S3 storage")
+		}
+		sftpIngest = &sftpIngestConfig{bucket: bucket, secret: []byte(secret), client: s3Client}
+	}
+	var emailIngest *emailIngestConfig
+	if queueURL := os.Getenv("EMAIL_INGEST_QUEUE_URL"); queueURL != "" {
+		domain := strings.ToLower(os.Getenv("EMAIL_INGEST_DOMAIN"))
+		bucket := os.Getenv("EMAIL_INGEST_BUCKET")
+		if domain == "" || bucket == "" {
+			log.Fatal("EMAIL_INGEST_QUEUE_URL needs EMAIL_INGEST_DOMAIN and EMAIL_INGEST_BUCKET")
+		}
+		if s3Client == nil {
+			log.Fatal("EMAIL_INGEST_QUEUE_URL needs S3 storage")
+		}
+		maxBytes := int64(defaultEmailMaxBytes)
+		if v := os.Getenv("EMAIL_INGEST_MAX_MB"); v != "" {
+			mb, err := strconv.Atoi(v)
+			if err != nil || mb <= 0 {
+				log.Fatalf("Invalid EMAIL_INGEST_MAX_MB %q", v)
+			}
+			maxBytes = int64(mb) << 20
+		}
+		queue, err := sqs.New(queueURL, os.Getenv("EMAIL_INGEST_QUEUE_REGION"), s3Client.Options().Credentials)
+		if err != nil {
+			log.Fatalf("Invalid EMAIL_INGEST_QUEUE_URL: %v", err)
+		}
+		emailIngest = &emailIngestConfig{
+			domain:   domain,
+			bucket:   bucket,
+			maxBytes: maxBytes,
+			queue:    queue,
+			client:   s3Client,
+			links:    newEmailLinkClient(webhookAllowPrivateHosts),
+		}
+	}
+	if metadataOnly && (streamVideos || sftpIngest != nil || emailIngest != nil) {
+		log.Fatal("METADATA_ONLY can't be combined with STREAM_VIDEOS, SFTP ingest or email ingest")
+	}
+	tenantPool, err := tenants.NewPool(defaultTarget, tenantConfig, s3Options...)
+	if err != nil {
+		log.Fatalf("Invalid tenants config: %v", err)
+	}
+	recommender := recommend.DefaultHeuristic()
+	recommender.Now = serverClock.Now
+
 	cfg := apiConfig{
-		db:               db,
-		jwtSecret:        jwtSecret,
-		platform:         platform,
-		filepathRoot:     filepathRoot,
-		assetsRoot:       assetsRoot,
-		s3Bucket:         s3Bucket,
-		s3Region:         s3Region,
-		s3CfDistribution: s3CfDistribution,
-		port:             port,
-		s3Client:         s3Client,
+		deps: deps{
+			db:      db,
+			tenants: tenantPool,
+			prober:  ffprobe{},
+			jobs:    jobs.NewQueue(processingWorkers, processingQueueLimit, serverClock),
+			clock:   serverClock,
+			logger:  logger,
+		},
+		jwtSecret:              jwtSecret,
+		platform:               platform,
+		filepathRoot:           filepathRoot,
+		assetsRoot:             assetsRoot,
+		assets:                 storage.NewLocal(assetsRoot, "http://localhost:"+port+"/assets", nil),
+		bucketThumbnails:       thumbnailStorage == "bucket",
+		s3CfDistribution:       s3CfDistribution,
+		port:                   port,
+		siteURL:                siteURL,
+		sitemapPageURL:         sitemapPageURL,
+		sitemap:                newSiteMap(),
+		adminEmails:            adminEmails,
+		adminOIDC:              adminOIDC,
+		fields:                 fields,
+		metricsToken:           os.Getenv("METRICS_TOKEN"),
+		maintenance:            newMaintenanceMode(maintenanceEnabled),
+		flags:                  featureFlags,
+		profiles:               profiles,
+		videoContainers:        videoContainers,
+		presignExpiry:          presignExpiry,
+		shortsMaxDuration:      shortsMaxDuration,
+		preserveFilenames:      preserveFilenames,
+		publicURLExpiry:        publicURLExpiry,
+		privateURLExpiry:       privateURLExpiry,
+		hlsOutput:              hlsOutput,
+		streamVideos:           streamVideos,
+		metadataOnly:           metadataOnly,
+		socialCrops:            socialCrops,
+		reportHoldThreshold:    reportHoldThreshold,
+		ageGate:                ageGate,
+		thumbnails:             thumbnails,
+		autoThumbnail:          autoThumbnail,
+		trendingWindows:        trendingWindows,
+		recommender:            recommender,
+		thumbnailRegens:        newThumbnailRegens(),
+		gifExports:             newGIFExports(),
+		storageMigrations:      &storageMigrations{},
+		background:             &backgroundJobs{},
+		janitor:                &janitor{maxAge: janitorMaxAge, deleteOrphans: janitorDeleteOrphans},
+		slo:                    newSLOTracker(slos, serverClock.Now()),
+		s3Options:              s3Options,
+		s3Resilience:           s3Resilience,
+		chaos:                  chaosInjector,
+		maxThumbnailCandidates: maxThumbnailCandidates,
+		uploadSessionTTL:       uploadSessionTTL,
+		uploadSessionMaxAge:    uploadSessionMaxAge,
+		trashRetention:         trashRetention,
+		uploadSpoolDir:         uploadSpoolDir,
+		uploadAppends:          newUploadAppends(),
+		uploadProgress:         newUploadProgresses(),
+		minUploadRate:          minUploadRate,
+		resizeKey:              resizeKey,
+		uploadLimit:            newConcurrencyLimit("upload", uploadConcurrency, 5*time.Second, 10*time.Second),
+		readLimit:              newConcurrencyLimit("read", readConcurrency, time.Second, time.Second),
+		uploadRateLimit:        newRateLimit("upload", uploadRateLimit, uploadRateLimitIP),
+		readRateLimit:          newRateLimit("read", readRateLimit, readRateLimitIP),
+		passphraseRateLimit:    newRateLimit("passphrase", 0, passphraseAttempts),
+		unlockTTL:              unlockTTL,
+		deviceTokenTTL:         deviceTokenTTL,
+		dailyUploadLimit:       newDailyUploadLimit(dailyUploadLimit),
+		trustForwardedFor:      os.Getenv("TRUST_FORWARDED_FOR") == "true",
+		multipart:              multipart,
+		signedURLs:             newSignedURLCache(),
+		contentObjects:         &contentObjects{},
+		cacheWebhookSecret:     cacheWebhookSecret,
+		webhooks:               newWebhookDispatcher(webhookAllowPrivateHosts),
+		premieres:              newPremiereScheduler(),
+		hlsBackfill:            newHLSBackfill(),
+		sftpIngest:             sftpIngest,
+		emailIngest:            emailIngest,
+		backupKey:              backupKey,
+		backupRetention:        backupRetention,
+		warehousePrefix:        warehousePrefix,
+		defaultStorageQuota:    defaultStorageQuota,
+	}
+	telemetry.watchQueue(cfg.jobs)
+	telemetry.watchSLOs(cfg.slo, cfg.clock.Now)
+
+	if err := cfg.applyStorageSwitches(ctx); err != nil {
+		log.Fatalf("Couldn't apply storage migrations: %v", err)
+	}
+
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "restore-db":
+			if err := cfg.runRestoreCommand(ctx, pathToDB, os.Args[2:]); err != nil {
+				log.Fatalf("Restore failed: %v", err)
+			}
+			return
+		case "dr-export":
+			if err := cfg.runDRExportCommand(ctx, os.Args[2:]); err != nil {
+				log.Fatalf("DR export failed: %v", err)
+			}
+			return
+		case "dr-import":
+			if err := cfg.runDRImportCommand(ctx, pathToDB, os.Args[2:]); err != nil {
+				log.Fatalf("DR import failed: %v", err)
+			}
+			return
+		case "verify-urls":
+			if err := cfg.runVerifyURLsCommand(ctx, os.Args[2:]); err != nil {
+				log.Fatalf("URL verification failed: %v", err)
+			}
+			return
+		case "reencrypt-fields":
+			if err := cfg.runReencryptCommand(os.Args[2:]); err != nil {
+				log.Fatalf("Re-encryption failed: %v", err)
+			}
+			return
+		default:
+			log.Fatalf("Unknown command %q, expected restore-db, dr-export, dr-import, verify-urls or reencrypt-fields", os.Args[1])
+		}
 	}
 
 	err = cfg.ensureAssetsDir()
@@ -112,12 +1002,295 @@ func main() {
 		log.Fatalf("Couldn't create assets directory: %v", err)
 	}
 
+	err = cfg.refreshFlagOverrides()
+	if err != nil {
+		log.Fatalf("Couldn't load feature flag overrides: %v", err)
+	}
+
+	liveConfig := live.Config{
+		PublicHost: os.Getenv("LIVE_PUBLIC_HOST"),
+		BasePort:   1935,
+		MaxStreams: 4,
+		LowLatency: os.Getenv("LIVE_LOW_LATENCY") == "true",
+		Root:       filepath.Join(os.TempDir(), "tubely-live"),
+	}
+	if liveConfig.PublicHost == "" {
+		liveConfig.PublicHost = "localhost"
+	}
+	if v := os.Getenv("LIVE_RTMP_BASE_PORT"); v != "" {
+		liveConfig.BasePort, err = strconv.Atoi(v)
+		if err != nil {
+			log.Fatal("LIVE_RTMP_BASE_PORT must be an integer")
+		}
+	}
+	if v := os.Getenv("LIVE_MAX_STREAMS"); v != "" {
+		liveConfig.MaxStreams, err = strconv.Atoi(v)
+		if err != nil {
+			log.Fatal("LIVE_MAX_STREAMS must be an integer")
+		}
+	}
+	fingerprintThreshold := fingerprint.DefaultThreshold
+	if v := os.Getenv("FINGERPRINT_THRESHOLD"); v != "" {
+		fingerprintThreshold, err = strconv.ParseFloat(v, 64)
+		if err != nil || fingerprintThreshold <= 0.5 || fingerprintThreshold > 1 {
+			log.Fatal("FINGERPRINT_THRESHOLD must be a number above 0.5 and at most 1")
+		}
+	}
+	switch checker := os.Getenv("FINGERPRINT_CHECKER"); checker {
+	case "":
+	case "catalog":
+		cfg.fingerprints = fingerprint.Catalog{References: cfg.catalogReferences, Threshold: fingerprintThreshold}
+	case "api":
+		apiURL := os.Getenv("FINGERPRINT_API_URL")
+		if apiURL == "" {
+			log.Fatal("FINGERPRINT_API_URL must be set when FINGERPRINT_CHECKER is api")
+		}
+		cfg.fingerprints = fingerprint.API{URL: apiURL, Token: os.Getenv("FINGERPRINT_API_TOKEN")}
+	default:
+		log.Fatalf("Unknown FINGERPRINT_CHECKER %q, expected catalog or api", checker)
+	}
+
+	switch scanner := os.Getenv("SCANNER"); scanner {
+	case "":
+	case "api":
+		apiURL := os.Getenv("SCAN_API_URL")
+		if apiURL == "" {
+			log.Fatal("SCAN_API_URL must be set when SCANNER is api")
+		}
+		cfg.scanner = scan.API{URL: apiURL, Token: os.Getenv("SCAN_API_TOKEN")}
+		cfg.scannerName = scanner
+	case "command":
+		command := strings.Fields(os.Getenv("SCAN_COMMAND"))
+		if len(command) == 0 {
+			log.Fatal("SCAN_COMMAND must be set when SCANNER is command")
+		}
+		path, err := settings.FindBinary(command[0])
+		if err != nil {
+			log.Fatalf("SCAN_COMMAND: %v", err)
+		}
+		cfg.scanner = scan.Command{Path: path, Args: command[1:]}
+		cfg.scannerName = scanner + ":" + filepath.Base(command[0])
+	default:
+		log.Fatalf("Unknown SCANNER %q, expected api or command", scanner)
+	}
+	cfg.scanCacheTTL = 720 * time.Hour
+	if v := os.Getenv("SCAN_CACHE_TTL"); v != "" {
+		cfg.scanCacheTTL, err = time.ParseDuration(v)
+		if err != nil || cfg.scanCacheTTL < 0 {
+			log.Fatal("SCAN_CACHE_TTL must be a duration, or 0 to scan every upload")
+		}
+	}
+
+	switch mode := os.Getenv("CDN_INVALIDATION"); mode {
+	case "":
+	case "cloudfront":
+		cloudFrontClient, err := newCloudFrontClient(ctx)
+		if err != nil {
+			log.Fatalf("Couldn't create CloudFront client: %v", err)
+		}
+		cfg.cdn = cdn.CloudFront{Client: cloudFrontClient, DistributionID: s3CfDistribution}
+	default:
+		log.Fatalf("Unknown CDN_INVALIDATION %q, expected cloudfront", mode)
+	}
+
+	if v := os.Getenv("CDN_DOMAIN"); v != "" {
+		if !strings.Contains(v, "://") {
+			v = "https://" + v
+		}
+		u, err := url.Parse(v)
+		if err != nil || u.Scheme != "https" || u.Host == "" || strings.Trim(u.Path, "/") != "" {
+			log.Fatal("CDN_DOMAIN must be a domain such as d111111abcdef8.cloudfront.net")
+		}
+		cfg.cdnURL = "https://" + u.Host
+	}
+	keyPairID, keyPath := os.Getenv("CDN_KEY_PAIR_ID"), os.Getenv("CDN_PRIVATE_KEY_PATH")
+	if (keyPairID == "") != (keyPath == "") {
+		log.Fatal("CDN_KEY_PAIR_ID and CDN_PRIVATE_KEY_PATH must be set together")
+	}
+	// URL_SIGNER picks how URLs on CDN_DOMAIN are signed. With s3 they
+	// aren't, and files not handed out on it are presigned by storage.
+	signerMode := os.Getenv("URL_SIGNER")
+	if signerMode == "" {
+		signerMode = "s3"
+		if keyPairID != "" {
+			signerMode = "cloudfront"
+		}
+	}
+	if signerMode != "s3" && cfg.cdnURL == "" {
+		log.Fatalf("URL_SIGNER=%s needs CDN_DOMAIN to be set", signerMode)
+	}
+	switch signerMode {
+	case "s3":
+	case "cloudfront":
+		if keyPairID == "" {
+			log.Fatal("URL_SIGNER=cloudfront needs CDN_KEY_PAIR_ID and CDN_PRIVATE_KEY_PATH")
+		}
+		signer, err := cdn.LoadCloudFrontSigner(keyPairID, keyPath)
+		if err != nil {
+			log.Fatalf("Couldn't load CDN signing key: %v", err)
+		}
+		cfg.signer = signer
+	case "hmac":
+		secret := os.Getenv("URL_SIGNER_SECRET")
+		if len(secret) < 32 {
+			log.Fatal("URL_SIGNER=hmac needs URL_SIGNER_SECRET, at least 32 characters")
+		}
+		cfg.signer = &cdn.HMACSigner{Secret: []byte(secret)}
+	default:
+		log.Fatalf("Unknown URL_SIGNER %q, expected s3, cloudfront or hmac", signerMode)
+	}
+	if keyPairID != "" && signerMode != "cloudfront" {
+		log.Fatalf("CDN_KEY_PAIR_ID can't be used with URL_SIGNER=%s", signerMode)
+	}
+	if v := os.Getenv("CDN_COOKIE_DOMAIN"); v != "" {
+		if cfg.signer == nil {
+			log.Fatal("CDN_COOKIE_DOMAIN needs URL_SIGNER to be cloudfront or hmac")
+		}
+		cfg.cdnCookieDomain = v
+	}
+	if os.Getenv("CDN_PREFETCH") == "true" {
+		if cfg.cdnURL == "" {
+			log.Fatal("CDN_PREFETCH needs CDN_DOMAIN to be set")
+		}
+		segments := 3
+		if v := os.Getenv("CDN_PREFETCH_SEGMENTS"); v != "" {
+			segments, err = strconv.Atoi(v)
+			if err != nil || segments < 0 {
+				log.Fatalf("Invalid CDN_PREFETCH_SEGMENTS %q", v)
+			}
+		}
+		pops := []string{}
+		for _, pop := range strings.Split(os.Getenv("CDN_PREFETCH_POPS"), ",") {
+			if pop = strings.TrimSpace(pop); pop != "" {
+				pops = append(pops, pop)
+			}
+		}
+		cfg.cdnPrefetch = newCDNPrefetch(pops, segments)
+	}
+
+	queueAlertConfig := queueAlertConfig{
+		webhookURL: os.Getenv("QUEUE_ALERT_WEBHOOK_URL"),
+		slackURL:   os.Getenv("QUEUE_ALERT_SLACK_URL"),
+	}
+	if v := os.Getenv("QUEUE_ALERT_DEPTH"); v != "" {
+		queueAlertConfig.depth, err = strconv.Atoi(v)
+		if err != nil || queueAlertConfig.depth < 0 {
+			log.Fatal("QUEUE_ALERT_DEPTH must be a non-negative integer")
+		}
+	}
+	if v := os.Getenv("QUEUE_ALERT_AGE"); v != "" {
+		queueAlertConfig.age, err = time.ParseDuration(v)
+		if err != nil || queueAlertConfig.age < 0 {
+			log.Fatal("QUEUE_ALERT_AGE must be a duration, or 0 to disable")
+		}
+	}
+	if v := os.Getenv("QUEUE_ALERT_EMAIL_TO"); v != "" {
+		mailer := &alertMailer{addr: os.Getenv("SMTP_ADDR"), from: os.Getenv("QUEUE_ALERT_EMAIL_FROM")}
+		for _, to := range strings.Split(v, ",") {
+			if to = strings.TrimSpace(to); to != "" {
+				mailer.to = append(mailer.to, to)
+			}
+		}
+		host, _, err := net.SplitHostPort(mailer.addr)
+		if err != nil {
+			log.Fatal("QUEUE_ALERT_EMAIL_TO needs SMTP_ADDR, the host:port of an SMTP server")
+		}
+		if mailer.from == "" {
+			log.Fatal("QUEUE_ALERT_EMAIL_TO needs QUEUE_ALERT_EMAIL_FROM")
+		}
+		if username := os.Getenv("SMTP_USERNAME"); username != "" {
+			mailer.auth = smtp.PlainAuth("", username, os.Getenv("SMTP_PASSWORD"), host)
+		}
+		queueAlertConfig.email = mailer
+	}
+	if queueAlertConfig.depth > 0 || queueAlertConfig.age > 0 {
+		cfg.queueAlerts = newQueueAlerts(queueAlertConfig)
+		telemetry.watchQueueAlerts(cfg.queueAlerts)
+	}
+
+	cfg.live, err = live.NewManager(liveConfig, cfg.finalizeLiveStream)
+	if err != nil {
+		log.Fatalf("Couldn't set up live ingest: %v", err)
+	}
+
+	if err := cfg.failInterruptedUploads(); err != nil {
+		log.Fatalf("Couldn't clean up interrupted uploads: %v", err)
+	}
+	tasks := []*scheduledTask{
+		{
+			name:        "upload_session_expiry",
+			description: "Expires upload sessions whose heartbeats have stopped and aborts their multipart uploads",
+			schedule:    intervalSchedule(uploadJanitorInterval),
+			run:         func(ctx context.Context) (any, error) { return cfg.expireUploadSessions(ctx) },
+		},
+		{
+			name:        "janitor",
+			description: "Aborts stale multipart uploads, removes old temporary files and finds orphaned objects",
+			schedule:    intervalSchedule(janitorInterval),
+			run:         cfg.janitorTask,
+		},
+		{
+			name:        "dead_link_sweep",
+			description: "Checks every video's file and thumbnail still exist",
+			schedule:    intervalSchedule(deadLinkSweepInterval),
+			run:         func(ctx context.Context) (any, error) { return cfg.sweepDeadLinks(ctx) },
+		},
+	}
+	if trashRetention > 0 {
+		tasks = append(tasks, &scheduledTask{
+			name:        "trash_purge",
+			description: "Purges videos that have been in the trash for longer than the retention period",
+			schedule:    intervalSchedule(trashReaperInterval),
+			run:         func(ctx context.Context) (any, error) { return cfg.reapTrash(ctx) },
+		})
+	}
+	if backupKey != nil {
+		tasks = append(tasks, &scheduledTask{
+			name:        "database_backup",
+			description: "Uploads an encrypted snapshot of the database and deletes the oldest backups",
+			schedule:    intervalSchedule(backupInterval),
+			run:         func(ctx context.Context) (any, error) { return cfg.backupDatabase(ctx) },
+		})
+	}
+	if warehousePrefix != "" {
+		tasks = append(tasks, &scheduledTask{
+			name:        "warehouse_export",
+			description: "Exports each day's videos, events and usage to the warehouse once it's over",
+			schedule:    intervalSchedule(warehouseExportCheckInterval),
+			run:         func(ctx context.Context) (any, error) { return cfg.exportDueWarehouseDays(ctx) },
+		})
+	}
+	cfg.scheduler, err = newTaskScheduler(tasks, os.Getenv("CRON_SCHEDULES"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	cfg.background.run(func() { cfg.runScheduler(ctx) })
+	cfg.background.run(func() { cfg.runWebhookDispatcher(ctx) })
+	cfg.background.run(func() { cfg.runPremiereScheduler(ctx) })
+	cfg.background.run(func() { cfg.runHLSBackfill(ctx) })
+	if cfg.queueAlerts != nil {
+		cfg.background.run(func() { cfg.runQueueAlerts(ctx) })
+	}
+	if emailIngest != nil {
+		cfg.background.run(func() { cfg.runEmailIngest(ctx) })
+	}
+	if err := cfg.resumeStorageMigrations(); err != nil {
+		log.Fatalf("Couldn't resume storage migration: %v", err)
+	}
+
 	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", cfg.handlerMetrics)
+	mux.HandleFunc("GET /readyz", cfg.handlerReadyz)
 	appHandler := http.StripPrefix("/app", http.FileServer(http.Dir(filepathRoot)))
 	mux.Handle("/app/", appHandler)
+	mux.HandleFunc("GET /sitemap.xml", cfg.rateLimitMiddleware(cfg.readRateLimit, cfg.readLimit.middleware(cfg.handlerSitemap)))
 
 	assetsHandler := http.StripPrefix("/assets", http.FileServer(http.Dir(assetsRoot)))
 	mux.Handle("/assets/", noCacheMiddleware(assetsHandler))
+	mux.Handle("GET /assets/{name}", cfg.resizeMiddleware(noCacheMiddleware(assetsHandler)))
+	if localStorage != nil {
+		mux.Handle("/storage/", http.StripPrefix("/storage", localStorage))
+	}
 
 	mux.HandleFunc("POST /api/login", cfg.handlerLogin)
 	mux.HandleFunc("POST /api/refresh", cfg.handlerRefresh)
@@ -126,19 +1299,225 @@ func main() {
 	mux.HandleFunc("POST /api/users", cfg.handlerUsersCreate)
 
 	mux.HandleFunc("POST /api/videos", cfg.handlerVideoMetaCreate)
-	mux.HandleFunc("POST /api/thumbnail_upload/{videoID}", cfg.handlerUploadThumbnail)
-	mux.HandleFunc("POST /api/video_upload/{videoID}", cfg.handlerUploadVideo)
-	mux.HandleFunc("GET /api/videos", cfg.handlerVideosRetrieve)
-	mux.HandleFunc("GET /api/videos/{videoID}", cfg.handlerVideoGet)
+	mux.HandleFunc("POST /api/uploads", cfg.metadataOnlyMiddleware(cfg.maintenanceMiddleware(cfg.suspensionMiddleware(cfg.handlerUploadSessionCreate))))
+	mux.HandleFunc("GET /api/uploads/{uploadID}", cfg.rateLimitMiddleware(cfg.readRateLimit, cfg.readLimit.middleware(cfg.handlerUploadSessionGet)))
+	mux.HandleFunc("HEAD /api/uploads/{uploadID}", cfg.handlerUploadSessionHead)
+	mux.HandleFunc("PATCH /api/uploads/{uploadID}", cfg.metadataOnlyMiddleware(cfg.maintenanceMiddleware(cfg.suspensionMiddleware(cfg.uploadLimit.middleware(cfg.handlerUploadSessionAppend)))))
+	mux.HandleFunc("POST /api/uploads/{uploadID}/heartbeat", cfg.handlerUploadSessionHeartbeat)
+	mux.HandleFunc("PUT /api/uploads/{uploadID}/parts/{partNumber}", cfg.metadataOnlyMiddleware(cfg.maintenanceMiddleware(cfg.suspensionMiddleware(cfg.uploadLimit.middleware(cfg.handlerUploadPartPut)))))
+	mux.HandleFunc("POST /api/uploads/{uploadID}/resume", cfg.suspensionMiddleware(cfg.handlerUploadSessionResume))
+	mux.HandleFunc("POST /api/uploads/{uploadID}/complete", cfg.metadataOnlyMiddleware(cfg.maintenanceMiddleware(cfg.suspensionMiddleware(cfg.rateLimitMiddleware(cfg.uploadRateLimit, cfg.dailyUploadMiddleware(cfg.uploadLimit.middleware(cfg.handlerUploadSessionComplete)))))))
+	mux.HandleFunc("DELETE /api/uploads/{uploadID}", cfg.handlerUploadSessionAbort)
+	mux.HandleFunc("POST /api/thumbnail_upload/{videoID}", cfg.metadataOnlyMiddleware(cfg.maintenanceMiddleware(cfg.suspensionMiddleware(cfg.rateLimitMiddleware(cfg.uploadRateLimit, cfg.dailyUploadMiddleware(cfg.uploadLimit.middleware(cfg.handlerUploadThumbnail)))))))
+	mux.HandleFunc("POST /api/video_upload/{videoID}", cfg.metadataOnlyMiddleware(cfg.maintenanceMiddleware(cfg.suspensionMiddleware(cfg.rateLimitMiddleware(cfg.uploadRateLimit, cfg.dailyUploadMiddleware(cfg.uploadLimit.middleware(cfg.handlerUploadVideo)))))))
+	mux.HandleFunc("POST /api/video_bundle_upload/{videoID}", cfg.metadataOnlyMiddleware(cfg.maintenanceMiddleware(cfg.suspensionMiddleware(cfg.rateLimitMiddleware(cfg.uploadRateLimit, cfg.dailyUploadMiddleware(cfg.uploadLimit.middleware(cfg.handlerUploadBundle)))))))
+	mux.HandleFunc("POST /api/videos/{videoID}/upload-url", cfg.metadataOnlyMiddleware(cfg.maintenanceMiddleware(cfg.suspensionMiddleware(cfg.handlerVideoUploadURL))))
+	mux.HandleFunc("POST /api/ingest/signing-key", cfg.handlerIngestKeyCreate)
+	mux.HandleFunc("DELETE /api/ingest/signing-key", cfg.handlerIngestKeyDelete)
+	mux.HandleFunc("POST /api/ingest/manifests", cfg.maintenanceMiddleware(cfg.suspensionMiddleware(cfg.rateLimitMiddleware(cfg.uploadRateLimit, cfg.handlerIngestManifestSubmit))))
+	mux.HandleFunc("GET /api/ingest/batches", cfg.rateLimitMiddleware(cfg.readRateLimit, cfg.readLimit.middleware(cfg.handlerIngestBatchesList)))
+	mux.HandleFunc("GET /api/ingest/batches/{batchID}", cfg.rateLimitMiddleware(cfg.readRateLimit, cfg.readLimit.middleware(cfg.handlerIngestBatchGet)))
+	mux.HandleFunc("POST /api/ingest/s3", cfg.metadataOnlyMiddleware(cfg.maintenanceMiddleware(cfg.suspensionMiddleware(cfg.rateLimitMiddleware(cfg.uploadRateLimit, cfg.handlerS3Import)))))
+	mux.HandleFunc("POST /api/videos/{videoID}/upload-complete", cfg.metadataOnlyMiddleware(cfg.maintenanceMiddleware(cfg.suspensionMiddleware(cfg.rateLimitMiddleware(cfg.uploadRateLimit, cfg.dailyUploadMiddleware(cfg.uploadLimit.middleware(cfg.handlerVideoUploadComplete)))))))
+	if cfg.metadataOnly {
+		mux.HandleFunc("POST /api/videos/{videoID}/object", cfg.maintenanceMiddleware(cfg.suspensionMiddleware(cfg.rateLimitMiddleware(cfg.uploadRateLimit, cfg.dailyUploadMiddleware(cfg.handlerVideoObjectRegister)))))
+	}
+	mux.HandleFunc("GET /api/videos", cfg.rateLimitMiddleware(cfg.readRateLimit, cfg.readLimit.middleware(cfg.handlerVideosRetrieve)))
+	mux.HandleFunc("GET /api/videos/{videoID}", cfg.rateLimitMiddleware(cfg.readRateLimit, cfg.readLimit.middleware(cfg.handlerVideoGet)))
+	mux.HandleFunc("POST /api/videos/presign-batch", cfg.rateLimitMiddleware(cfg.readRateLimit, cfg.readLimit.middleware(cfg.handlerPresignBatch)))
+	mux.HandleFunc("GET /api/videos/trending", cfg.rateLimitMiddleware(cfg.readRateLimit, cfg.readLimit.middleware(cfg.handlerTrending)))
+	mux.HandleFunc("GET /api/videos/most-viewed", cfg.rateLimitMiddleware(cfg.readRateLimit, cfg.readLimit.middleware(cfg.handlerMostViewed)))
+	mux.HandleFunc("GET /api/shorts", cfg.rateLimitMiddleware(cfg.readRateLimit, cfg.readLimit.middleware(cfg.handlerShortsList)))
+	mux.HandleFunc("GET /api/graphql", cfg.rateLimitMiddleware(cfg.readRateLimit, cfg.readLimit.middleware(cfg.handlerGraphQL)))
+	mux.HandleFunc("POST /api/graphql", cfg.rateLimitMiddleware(cfg.readRateLimit, cfg.readLimit.middleware(cfg.handlerGraphQL)))
 	mux.HandleFunc("DELETE /api/videos/{videoID}", cfg.handlerVideoMetaDelete)
+	mux.HandleFunc("GET /api/videos/trash", cfg.rateLimitMiddleware(cfg.readRateLimit, cfg.readLimit.middleware(cfg.handlerVideoTrash)))
+	mux.HandleFunc("POST /api/videos/{videoID}/restore", cfg.handlerVideoRestore)
+	mux.HandleFunc("DELETE /api/videos/{videoID}/video", cfg.handlerVideoFileDelete)
+	mux.HandleFunc("DELETE /api/videos/{videoID}/thumbnail", cfg.handlerThumbnailDelete)
+	mux.HandleFunc("GET /api/videos/{videoID}/access", cfg.rateLimitMiddleware(cfg.readRateLimit, cfg.readLimit.middleware(cfg.handlerVideoAccess)))
+	mux.HandleFunc("POST /api/videos/{videoID}/report", cfg.handlerVideoReport)
+	mux.HandleFunc("PUT /api/videos/{videoID}/rating", cfg.handlerVideoRatingSet)
+	mux.HandleFunc("PUT /api/videos/{videoID}/schedule", cfg.handlerVideoScheduleSet)
+	mux.HandleFunc("PATCH /api/videos/{videoID}/visibility", cfg.handlerVideoVisibilitySet)
+	mux.HandleFunc("PATCH /api/videos/{videoID}/custom-metadata", cfg.handlerVideoCustomMetadataPatch)
+	mux.HandleFunc("PUT /api/videos/{videoID}/passphrase", cfg.handlerVideoPassphraseSet)
+	mux.HandleFunc("DELETE /api/videos/{videoID}/passphrase", cfg.handlerVideoPassphraseDelete)
+	mux.HandleFunc("POST /api/videos/{videoID}/unlock", cfg.handlerVideoUnlock)
+	mux.HandleFunc("GET /api/videos/{videoID}/views", cfg.rateLimitMiddleware(cfg.readRateLimit, cfg.readLimit.middleware(cfg.handlerViewBadge)))
+	mux.HandleFunc("GET /api/videos/{videoID}/views.svg", cfg.rateLimitMiddleware(cfg.readRateLimit, cfg.readLimit.middleware(cfg.handlerViewBadgeSVG)))
+	mux.HandleFunc("GET /api/videos/{videoID}/status", cfg.rateLimitMiddleware(cfg.readRateLimit, cfg.readLimit.middleware(cfg.handlerVideoStatus)))
+	mux.HandleFunc("GET /api/videos/{videoID}/integrity", cfg.rateLimitMiddleware(cfg.readRateLimit, cfg.readLimit.middleware(cfg.handlerVideoIntegrity)))
+	mux.HandleFunc("GET /api/videos/{videoID}/playback", cfg.playbackSLOMiddleware(cfg.rateLimitMiddleware(cfg.readRateLimit, cfg.readLimit.middleware(cfg.handlerVideoPlayback))))
+	mux.HandleFunc("GET /api/videos/{videoID}/thumbnail", cfg.rateLimitMiddleware(cfg.readRateLimit, cfg.readLimit.middleware(cfg.handlerThumbnailRedirect)))
+	mux.HandleFunc("GET /api/tenants/{tenantID}/branding/logo", cfg.rateLimitMiddleware(cfg.readRateLimit, cfg.readLimit.middleware(cfg.handlerTenantBrandingLogo)))
+	mux.HandleFunc("GET /embed/{videoID}", cfg.rateLimitMiddleware(cfg.readRateLimit, cfg.readLimit.middleware(cfg.handlerEmbed)))
+	mux.HandleFunc("GET /api/videos/{videoID}/hls/{file...}", cfg.playbackSLOMiddleware(cfg.rateLimitMiddleware(cfg.readRateLimit, cfg.readLimit.middleware(cfg.handlerVideoHLS))))
+	mux.HandleFunc("POST /api/videos/{videoID}/playback/hints", cfg.rateLimitMiddleware(cfg.readRateLimit, cfg.readLimit.middleware(cfg.handlerPlaybackHints)))
+	mux.HandleFunc("POST /api/videos/{videoID}/progress", cfg.handlerWatchProgressReport)
+	mux.HandleFunc("GET /api/videos/{videoID}/progress", cfg.handlerVideoProgressGet)
+	mux.HandleFunc("GET /api/videos/{videoID}/stream", cfg.metadataOnlyMiddleware(cfg.playbackSLOMiddleware(cfg.rateLimitMiddleware(cfg.readRateLimit, cfg.readLimit.middleware(cfg.handlerVideoStream)))))
+	mux.HandleFunc("GET /api/videos/{videoID}/watermarked", cfg.metadataOnlyMiddleware(cfg.rateLimitMiddleware(cfg.readRateLimit, cfg.readLimit.middleware(cfg.handlerVideoWatermarked))))
+	mux.HandleFunc("GET /api/videos/{videoID}/audio-tracks", cfg.rateLimitMiddleware(cfg.readRateLimit, cfg.readLimit.middleware(cfg.handlerAudioTracksGet)))
+	mux.HandleFunc("PUT /api/videos/{videoID}/audio-tracks/{index}", cfg.handlerAudioTrackUpdate)
+	mux.HandleFunc("POST /api/videos/{videoID}/captions", cfg.metadataOnlyMiddleware(cfg.maintenanceMiddleware(cfg.suspensionMiddleware(cfg.rateLimitMiddleware(cfg.uploadRateLimit, cfg.uploadLimit.middleware(cfg.handlerCaptionsUpload))))))
+	mux.HandleFunc("DELETE /api/videos/{videoID}/captions/{language}", cfg.handlerCaptionsDelete)
+	mux.HandleFunc("GET /api/videos/{videoID}/renditions", cfg.rateLimitMiddleware(cfg.readRateLimit, cfg.readLimit.middleware(cfg.handlerRenditionsGet)))
+	mux.HandleFunc("POST /api/videos/{videoID}/renditions/captions/{language}", cfg.metadataOnlyMiddleware(cfg.maintenanceMiddleware(cfg.suspensionMiddleware(cfg.rateLimitMiddleware(cfg.uploadRateLimit, cfg.uploadLimit.middleware(cfg.handlerBurnedCaptionsCreate))))))
+	mux.HandleFunc("GET /api/videos/{videoID}/thumbnails", cfg.rateLimitMiddleware(cfg.readRateLimit, cfg.readLimit.middleware(cfg.handlerThumbnailVariantsGet)))
+	mux.HandleFunc("GET /api/videos/{videoID}/thumbnail-url", cfg.rateLimitMiddleware(cfg.readRateLimit, cfg.readLimit.middleware(cfg.handlerThumbnailResizeURL)))
+	mux.HandleFunc("GET /api/videos/{videoID}/thumbnail-candidates", cfg.rateLimitMiddleware(cfg.readRateLimit, cfg.readLimit.middleware(cfg.handlerThumbnailCandidatesList)))
+	mux.HandleFunc("POST /api/videos/{videoID}/thumbnail-candidates", cfg.metadataOnlyMiddleware(cfg.maintenanceMiddleware(cfg.suspensionMiddleware(cfg.rateLimitMiddleware(cfg.uploadRateLimit, cfg.uploadLimit.middleware(cfg.handlerThumbnailCandidateCreate))))))
+	mux.HandleFunc("DELETE /api/videos/{videoID}/thumbnail-candidates/{candidateID}", cfg.handlerThumbnailCandidateDelete)
+	mux.HandleFunc("PUT /api/videos/{videoID}/thumbnail-candidates/{candidateID}/promote", cfg.handlerThumbnailCandidatePromote)
+	mux.HandleFunc("GET /api/videos/{videoID}/thumbnail-candidates/pick", cfg.rateLimitMiddleware(cfg.readRateLimit, cfg.readLimit.middleware(cfg.handlerThumbnailCandidatePick)))
+	mux.HandleFunc("GET /api/me/recommendations", cfg.rateLimitMiddleware(cfg.readRateLimit, cfg.readLimit.middleware(cfg.handlerRecommendations)))
+	mux.HandleFunc("GET /api/me/history", cfg.rateLimitMiddleware(cfg.readRateLimit, cfg.readLimit.middleware(cfg.handlerWatchHistory)))
+	mux.HandleFunc("DELETE /api/me/history", cfg.handlerWatchHistoryClear)
+	mux.HandleFunc("DELETE /api/me/history/{videoID}", cfg.handlerWatchHistoryDelete)
+	mux.HandleFunc("GET /api/limits", cfg.rateLimitMiddleware(cfg.readRateLimit, cfg.readLimit.middleware(cfg.handlerLimits)))
+	mux.HandleFunc("GET /api/users/me/usage", cfg.rateLimitMiddleware(cfg.readRateLimit, cfg.readLimit.middleware(cfg.handlerStorageUsage)))
+	mux.HandleFunc("GET /api/me/settings", cfg.rateLimitMiddleware(cfg.readRateLimit, cfg.readLimit.middleware(cfg.handlerUserSettingsGet)))
+	mux.HandleFunc("PUT /api/me/settings", cfg.handlerUserSettingsUpdate)
+	mux.HandleFunc("GET /api/me/presets", cfg.rateLimitMiddleware(cfg.readRateLimit, cfg.readLimit.middleware(cfg.handlerUploadPresetsGet)))
+	mux.HandleFunc("POST /api/me/presets", cfg.handlerUploadPresetCreate)
+	mux.HandleFunc("PUT /api/me/presets/{presetID}", cfg.handlerUploadPresetUpdate)
+	mux.HandleFunc("DELETE /api/me/presets/{presetID}", cfg.handlerUploadPresetDelete)
+	mux.HandleFunc("GET /api/me/api_keys", cfg.rateLimitMiddleware(cfg.readRateLimit, cfg.readLimit.middleware(cfg.handlerAPIKeysGet)))
+	mux.HandleFunc("POST /api/me/api_keys", cfg.handlerAPIKeyCreate)
+	mux.HandleFunc("DELETE /api/me/api_keys/{keyID}", cfg.handlerAPIKeyRevoke)
+	mux.HandleFunc("GET /api/me/device_tokens", cfg.rateLimitMiddleware(cfg.readRateLimit, cfg.readLimit.middleware(cfg.handlerDeviceTokensGet)))
+	mux.HandleFunc("POST /api/me/device_tokens", cfg.handlerDeviceTokenCreate)
+	mux.HandleFunc("DELETE /api/me/device_tokens/{tokenID}", cfg.handlerDeviceTokenRevoke)
+	mux.HandleFunc("GET /api/me/webhooks", cfg.rateLimitMiddleware(cfg.readRateLimit, cfg.readLimit.middleware(cfg.handlerWebhooksGet)))
+	mux.HandleFunc("POST /api/me/webhooks", cfg.handlerWebhookCreate)
+	mux.HandleFunc("DELETE /api/me/webhooks/{webhookID}", cfg.handlerWebhookDelete)
+	mux.HandleFunc("GET /api/me/webhooks/{webhookID}/deliveries", cfg.rateLimitMiddleware(cfg.readRateLimit, cfg.readLimit.middleware(cfg.handlerWebhookDeliveriesGet)))
+	mux.HandleFunc("GET /api/events", cfg.rateLimitMiddleware(cfg.readRateLimit, cfg.readLimit.middleware(cfg.handlerEventsList)))
+	mux.HandleFunc("GET /api/me/notification-channels", cfg.rateLimitMiddleware(cfg.readRateLimit, cfg.readLimit.middleware(cfg.handlerNotificationChannelsGet)))
+	mux.HandleFunc("POST /api/me/notification-channels", cfg.handlerNotificationChannelCreate)
+	mux.HandleFunc("DELETE /api/me/notification-channels/{channelID}", cfg.handlerNotificationChannelDelete)
+	mux.HandleFunc("GET /api/me/email-in", cfg.rateLimitMiddleware(cfg.readRateLimit, cfg.readLimit.middleware(cfg.handlerEmailAddressGet)))
+	mux.HandleFunc("PUT /api/me/email-in", cfg.handlerEmailAddressUpdate)
+	mux.HandleFunc("DELETE /api/me/email-in", cfg.handlerEmailAddressDelete)
+	mux.HandleFunc("POST /api/me/email-in/rotate", cfg.handlerEmailAddressRotate)
+	mux.HandleFunc("GET /api/me/email-in/messages", cfg.rateLimitMiddleware(cfg.readRateLimit, cfg.readLimit.middleware(cfg.handlerEmailIngestsGet)))
+	mux.HandleFunc("GET /api/me/s3-source", cfg.rateLimitMiddleware(cfg.readRateLimit, cfg.readLimit.middleware(cfg.handlerS3SourceGet)))
+	mux.HandleFunc("PUT /api/me/s3-source", cfg.handlerS3SourceUpdate)
+	mux.HandleFunc("DELETE /api/me/s3-source", cfg.handlerS3SourceDelete)
+	mux.HandleFunc("POST /api/series", cfg.handlerSeriesCreate)
+	mux.HandleFunc("GET /api/series", cfg.rateLimitMiddleware(cfg.readRateLimit, cfg.readLimit.middleware(cfg.handlerSeriesList)))
+	mux.HandleFunc("GET /api/series/{seriesID}", cfg.rateLimitMiddleware(cfg.readRateLimit, cfg.readLimit.middleware(cfg.handlerSeriesGet)))
+	mux.HandleFunc("PUT /api/series/{seriesID}", cfg.handlerSeriesUpdate)
+	mux.HandleFunc("DELETE /api/series/{seriesID}", cfg.handlerSeriesDelete)
+	mux.HandleFunc("POST /api/series/{seriesID}/episodes", cfg.handlerSeriesEpisodeAdd)
+	mux.HandleFunc("DELETE /api/series/{seriesID}/episodes/{videoID}", cfg.handlerSeriesEpisodeRemove)
+	mux.HandleFunc("GET /api/series/{seriesID}/feed.xml", cfg.rateLimitMiddleware(cfg.readRateLimit, cfg.readLimit.middleware(cfg.handlerSeriesFeed)))
+	mux.HandleFunc("POST /api/thumbnail-beacon", cfg.handlerThumbnailBeacon)
+	mux.HandleFunc("POST /api/hooks/cache", cfg.handlerCacheWebhook)
+	mux.HandleFunc("POST /api/hooks/sftp", cfg.metadataOnlyMiddleware(cfg.maintenanceMiddleware(cfg.uploadLimit.middleware(cfg.handlerSFTPIngest))))
+	mux.HandleFunc("POST /api/diagnostics/upload", cfg.rateLimitMiddleware(cfg.uploadRateLimit, cfg.uploadLimit.middleware(cfg.handlerUploadDiagnostic)))
+	mux.HandleFunc("GET /api/videos/{videoID}/frame", cfg.metadataOnlyMiddleware(cfg.rateLimitMiddleware(cfg.readRateLimit, cfg.readLimit.middleware(cfg.handlerVideoFrame))))
+	mux.HandleFunc("POST /api/videos/{videoID}/gif", cfg.metadataOnlyMiddleware(cfg.maintenanceMiddleware(cfg.suspensionMiddleware(cfg.handlerGIFExportCreate))))
+	mux.HandleFunc("GET /api/videos/{videoID}/gif/{exportID}", cfg.rateLimitMiddleware(cfg.readRateLimit, cfg.readLimit.middleware(cfg.handlerGIFExportGet)))
+	mux.HandleFunc("GET /api/videos/{videoID}/mediainfo", cfg.rateLimitMiddleware(cfg.readRateLimit, cfg.readLimit.middleware(cfg.handlerVideoMediaInfo)))
+	mux.HandleFunc("GET /api/videos/{videoID}/keyframes", cfg.rateLimitMiddleware(cfg.readRateLimit, cfg.readLimit.middleware(cfg.handlerVideoKeyframes)))
+
+	// Admin routes take admins' access tokens under /api/admin and, with
+	// ADMIN_OIDC_ISSUER set, the identity provider's admin tokens, for an
+	// admin UI of its own, under /admin/api.
+	adminRoute := func(method, path string, handler http.HandlerFunc) {
+		mux.HandleFunc(method+" /api/admin"+path, handler)
+		if cfg.adminOIDC != nil {
+			mux.HandleFunc(method+" /admin/api"+path, cfg.oidcAdminMiddleware(handler))
+		}
+	}
+	adminRoute("GET", "/dashboard", cfg.handlerAdminDashboard)
+	adminRoute("GET", "/backups", cfg.handlerAdminBackupsList)
+	adminRoute("POST", "/backups", cfg.handlerAdminBackupCreate)
+	adminRoute("GET", "/warehouse-exports", cfg.handlerAdminWarehouseExportsList)
+	adminRoute("POST", "/warehouse-exports", cfg.handlerAdminWarehouseExportCreate)
+	adminRoute("GET", "/chaos", cfg.handlerChaosGet)
+	adminRoute("PUT", "/chaos", cfg.handlerChaosSet)
+	adminRoute("GET", "/maintenance", cfg.handlerMaintenanceGet)
+	adminRoute("PUT", "/maintenance", cfg.handlerMaintenanceSet)
+	adminRoute("GET", "/flags", cfg.handlerFlagsList)
+	adminRoute("PUT", "/flags/{name}", cfg.handlerFlagSet)
+	adminRoute("DELETE", "/flags/{name}", cfg.handlerFlagDelete)
+	adminRoute("POST", "/migrations/namespace-keys", cfg.handlerMigrateNamespacedKeys)
+	adminRoute("POST", "/migrations/storage", cfg.handlerStorageMigrationStart)
+	adminRoute("GET", "/migrations/storage", cfg.handlerStorageMigrationsList)
+	adminRoute("GET", "/migrations/storage/{migrationID}", cfg.handlerStorageMigrationGet)
+	adminRoute("POST", "/migrations/storage/{migrationID}/pause", cfg.handlerStorageMigrationPause)
+	adminRoute("POST", "/migrations/storage/{migrationID}/resume", cfg.handlerStorageMigrationResume)
+	adminRoute("GET", "/users/{userID}/activity", cfg.handlerAdminUserActivity)
+	adminRoute("POST", "/users/{userID}/impersonate", cfg.handlerAdminImpersonate)
+	adminRoute("PUT", "/users/{userID}/tenant", cfg.handlerAdminSetUserTenant)
+	adminRoute("PUT", "/users/{userID}/age-verification", cfg.handlerAdminSetUserAgeVerified)
+	adminRoute("PUT", "/users/{userID}/suspension", cfg.handlerAdminSetUserSuspension)
+	adminRoute("PUT", "/users/{userID}/quota", cfg.handlerAdminSetUserQuota)
+	adminRoute("PUT", "/users/{userID}/sftp", cfg.handlerAdminSetUserSFTPAccount)
+	adminRoute("GET", "/sftp-accounts", cfg.handlerAdminSFTPAccountsList)
+	adminRoute("GET", "/events", cfg.handlerAdminEventsList)
+	adminRoute("GET", "/tenants/{tenantID}/events", cfg.handlerAdminTenantEventsList)
+	adminRoute("GET", "/tenants/{tenantID}/notification-channels", cfg.handlerAdminTenantNotificationChannelsGet)
+	adminRoute("POST", "/tenants/{tenantID}/notification-channels", cfg.handlerAdminTenantNotificationChannelCreate)
+	adminRoute("DELETE", "/tenants/{tenantID}/notification-channels/{channelID}", cfg.handlerAdminTenantNotificationChannelDelete)
+	adminRoute("GET", "/tenants/{tenantID}/branding", cfg.handlerAdminTenantBrandingGet)
+	adminRoute("PUT", "/tenants/{tenantID}/branding", cfg.handlerAdminTenantBrandingSet)
+	adminRoute("DELETE", "/tenants/{tenantID}/branding", cfg.handlerAdminTenantBrandingDelete)
+	adminRoute("PUT", "/tenants/{tenantID}/branding/logo", cfg.handlerAdminTenantBrandingLogoSet)
+	adminRoute("DELETE", "/tenants/{tenantID}/branding/logo", cfg.handlerAdminTenantBrandingLogoDelete)
+	adminRoute("GET", "/videos/{videoID}/processing-logs", cfg.handlerAdminProcessingLogs)
+	adminRoute("GET", "/reports", cfg.handlerAdminReportsList)
+	adminRoute("PUT", "/reports/{reportID}", cfg.handlerAdminReportResolve)
+	adminRoute("PUT", "/videos/{videoID}/moderation-hold", cfg.handlerAdminModerationHold)
+	adminRoute("PUT", "/videos/{videoID}/legal-hold", cfg.handlerAdminLegalHold)
+	adminRoute("GET", "/fingerprint-references", cfg.handlerFingerprintReferencesList)
+	adminRoute("POST", "/fingerprint-references", cfg.handlerFingerprintReferenceCreate)
+	adminRoute("DELETE", "/fingerprint-references/{referenceID}", cfg.handlerFingerprintReferenceDelete)
+	adminRoute("GET", "/scan-verdicts", cfg.handlerAdminScanVerdictsList)
+	adminRoute("DELETE", "/scan-verdicts", cfg.handlerAdminScanVerdictsDelete)
+	adminRoute("DELETE", "/scan-verdicts/{sha256}", cfg.handlerAdminScanVerdictDelete)
+	adminRoute("POST", "/thumbnails/regenerate", cfg.handlerThumbnailRegenStart)
+	adminRoute("GET", "/thumbnails/regenerate/{jobID}", cfg.handlerThumbnailRegenGet)
+	adminRoute("GET", "/dead-links", cfg.handlerDeadLinksList)
+	adminRoute("POST", "/dead-links/sweep", cfg.handlerDeadLinksSweep)
+	adminRoute("POST", "/janitor/sweep", cfg.handlerJanitorSweep)
+	adminRoute("GET", "/scheduled-tasks", cfg.handlerScheduledTasksList)
+	adminRoute("GET", "/scheduled-tasks/{name}", cfg.handlerScheduledTaskGet)
+	adminRoute("GET", "/scheduled-tasks/{name}/runs", cfg.handlerScheduledTaskRuns)
+	adminRoute("POST", "/scheduled-tasks/{name}/run", cfg.handlerScheduledTaskRun)
+	adminRoute("POST", "/scheduled-tasks/{name}/pause", cfg.handlerScheduledTaskPause)
+	adminRoute("POST", "/scheduled-tasks/{name}/resume", cfg.handlerScheduledTaskResume)
+	adminRoute("GET", "/slo", cfg.handlerAdminSLO)
+
+	mux.HandleFunc("POST /api/live/streams", cfg.metadataOnlyMiddleware(cfg.maintenanceMiddleware(cfg.suspensionMiddleware(cfg.handlerLiveStreamCreate))))
+	mux.HandleFunc("GET /api/live/streams/{sessionID}", cfg.handlerLiveStreamGet)
+	mux.HandleFunc("DELETE /api/live/streams/{sessionID}", cfg.handlerLiveStreamStop)
+	mux.HandleFunc("GET /api/live/streams/{sessionID}/hls/{file}", cfg.handlerLiveStreamHLS)
 
 	mux.HandleFunc("POST /admin/reset", cfg.handlerReset)
 
+	// The external ID lookup has a mux of its own, since its pattern
+	// conflicts with the {videoID} ones, such as
+	// /api/videos/{videoID}/hls/{file...}.
+	externalIDMux := http.NewServeMux()
+	externalIDMux.HandleFunc("GET /api/videos/by-external-id/{source}/{id}", cfg.rateLimitMiddleware(cfg.readRateLimit, cfg.readLimit.middleware(cfg.handlerVideoByExternalID)))
+	rootMux := http.NewServeMux()
+	rootMux.Handle("/api/videos/by-external-id/", externalIDMux)
+	rootMux.Handle("/", mux)
+
 	srv := &http.Server{
 		Addr:    ":" + port,
-		Handler: mux,
+		Handler: requestIDMiddleware(cfg.requestLogMiddleware(languageMiddleware(apiVersionMiddleware(defaultAPIVersion, cfg.impersonationMiddleware(unlockMiddleware(rootMux)))))),
 	}
 
+	if warmupEnabled {
+		cfg.warmup(ctx, warmupTimeout)
+	}
 	log.Printf("Serving on: http://localhost:%s/app/\n", port)
-	log.Fatal(srv.ListenAndServe())
+	if err := cfg.serve(srv, stopLoops, shutdownTimeout); err != nil {
+		log.Fatal(err)
+	}
 }
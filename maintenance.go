@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const defaultMaintenanceMessage = "Tubely is undergoing maintenance. Uploads are temporarily disabled, please try again shortly."
+
+type maintenanceMode struct {
+	mu         sync.RWMutex
+	enabled    bool
+	message    string
+	retryAfter time.Duration
+}
+
+func newMaintenanceMode(enabled bool) *maintenanceMode {
+	return &maintenanceMode{
+		enabled:    enabled,
+		message:    defaultMaintenanceMessage,
+		retryAfter: 5 * time.Minute,
+	}
+}
+
+func (m *maintenanceMode) get() (enabled bool, message string, retryAfter time.Duration) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.enabled, m.message, m.retryAfter
+}
+
+func (m *maintenanceMode) set(enabled bool, message string, retryAfter time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.enabled = enabled
+	if message != "" {
+		m.message = message
+	}
+	if retryAfter > 0 {
+		m.retryAfter = retryAfter
+	}
+}
+
+// maintenanceMiddleware rejects requests with a 503 while maintenance mode is
+// enabled. It is only applied to routes that write media, so reads keep
+// working during maintenance.
+func (cfg *apiConfig) maintenanceMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		enabled, message, retryAfter := cfg.maintenance.get()
+		if !enabled {
+			next(w, r)
+			return
+		}
+
+		type response struct {
+			Error             string `json:"error"`
+			Code              string `json:"code"`
+			Maintenance       bool   `json:"maintenance"`
+			RetryAfterSeconds int    `json:"retry_after_seconds"`
+		}
+		seconds := int(retryAfter.Seconds())
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+		respondWithJSON(w, http.StatusServiceUnavailable, response{
+			Error:             message,
+			Code:              "maintenance",
+			Maintenance:       true,
+			RetryAfterSeconds: seconds,
+		})
+	}
+}
+
+type maintenanceStatus struct {
+	Enabled           bool   `json:"enabled"`
+	Message           string `json:"message"`
+	RetryAfterSeconds int    `json:"retry_after_seconds"`
+	JobsInFlight      int    `json:"jobs_in_flight"`
+}
+
+func (cfg *apiConfig) maintenanceStatus() maintenanceStatus {
+	enabled, message, retryAfter := cfg.maintenance.get()
+	return maintenanceStatus{
+		Enabled:           enabled,
+		Message:           message,
+		RetryAfterSeconds: int(retryAfter.Seconds()),
+		JobsInFlight:      cfg.jobs.InFlight(),
+	}
+}
+
+func (cfg *apiConfig) handlerMaintenanceGet(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+	respondWithJSON(w, http.StatusOK, cfg.maintenanceStatus())
+}
+
+func (cfg *apiConfig) handlerMaintenanceSet(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Enabled           bool   `json:"enabled"`
+		Message           string `json:"message"`
+		RetryAfterSeconds int    `json:"retry_after_seconds"`
+	}
+
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.RetryAfterSeconds < 0 {
+		respondWithError(w, http.StatusBadRequest, "retry_after_seconds can't be negative", nil)
+		return
+	}
+
+	cfg.maintenance.set(params.Enabled, params.Message, time.Duration(params.RetryAfterSeconds)*time.Second)
+	respondWithJSON(w, http.StatusOK, cfg.maintenanceStatus())
+}
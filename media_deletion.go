@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tenants"
+	"github.com/google/uuid"
+)
+
+// deleteVideoFiles deletes the objects that make up video's file from
+// target: the file itself, its preview, waveform peaks, SDR copy,
+// renditions and HLS output. Objects that are already gone are skipped. A
+// file shared with other videos is only released, and deleted with its
+// last reference.
+func (cfg *apiConfig) deleteVideoFiles(ctx context.Context, target tenants.Target, video database.Video, renditions []database.Rendition) error {
+	urls := []*string{video.VideoURL, video.PreviewURL, video.PeaksURL, video.SDRVideoURL}
+	for _, rendition := range renditions {
+		urls = append(urls, &rendition.URL)
+	}
+	keys := []string{}
+	for _, u := range urls {
+		if u == nil {
+			continue
+		}
+		if key, ok := storedObjectKey(target, *u); ok {
+			keys = append(keys, key)
+		}
+	}
+	if video.HLSURL != nil {
+		if masterKey, ok := storedObjectKey(target, *video.HLSURL); ok {
+			hlsKeys, err := hlsObjectKeys(ctx, target, masterKey)
+			if err != nil {
+				return fmt.Errorf("couldn't list HLS output: %w", err)
+			}
+			keys = append(keys, hlsKeys...)
+		}
+	}
+
+	slices.Sort(keys)
+	var errs []error
+	for _, key := range slices.Compact(keys) {
+		if isContentKey(key) {
+			if err := cfg.releaseContentObject(ctx, target, key); err != nil {
+				errs = append(errs, fmt.Errorf("release s3://%s/%s: %w", target.Bucket, key, err))
+			}
+			continue
+		}
+		if err := target.Storage().Delete(ctx, key); err != nil {
+			errs = append(errs, fmt.Errorf("delete s3://%s/%s: %w", target.Bucket, key, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// deleteVideoFilesOnCommit registers the deletion of the objects of video's
+// file for once the pipeline replacing or removing it succeeds.
+func (cfg *apiConfig) deleteVideoFilesOnCommit(cleanup *cleanupStack, target tenants.Target, video database.Video, renditions []database.Rendition) {
+	if video.VideoURL == nil {
+		return
+	}
+	cleanup.onCommit(fmt.Sprintf("delete previous files of video %s", video.ID), func() error {
+		return cfg.deleteVideoFiles(context.Background(), target, video, renditions)
+	})
+}
+
+// deleteThumbnailFile removes the asset thumbnailURL points at, along with
+// its resized copies. A thumbnail candidate of the video may still use the
+// file, in which case it is kept.
+func (cfg *apiConfig) deleteThumbnailFile(ctx context.Context, videoID uuid.UUID, thumbnailURL string) error {
+	name, ok := cfg.thumbnailAssetName(thumbnailURL)
+	if !ok {
+		return nil
+	}
+	candidates, err := cfg.db.GetThumbnailCandidates(videoID)
+	if err != nil {
+		return err
+	}
+	if slices.ContainsFunc(candidates, func(c database.ThumbnailCandidate) bool { return c.URL == thumbnailURL }) {
+		return nil
+	}
+	if err := cfg.deleteAsset(ctx, name); err != nil {
+		return err
+	}
+	base := strings.TrimSuffix(name, filepath.Ext(name))
+	resized, err := filepath.Glob(filepath.Join(cfg.assetsRoot, resizedDir, base+"-*"))
+	if err != nil {
+		return err
+	}
+	for _, path := range resized {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteThumbnailOnCommit registers the removal of video's current
+// thumbnail file for once the pipeline replacing or removing it succeeds.
+func (cfg *apiConfig) deleteThumbnailOnCommit(cleanup *cleanupStack, video database.Video) {
+	if video.ThumbnailURL == nil {
+		return
+	}
+	thumbnailURL := *video.ThumbnailURL
+	cleanup.onCommit("delete previous thumbnail "+thumbnailURL, func() error {
+		return cfg.deleteThumbnailFile(context.Background(), video.ID, thumbnailURL)
+	})
+}
+
+// removeAssetFiles deletes the assets at urls. URLs that aren't assets are
+// skipped.
+func (cfg *apiConfig) removeAssetFiles(ctx context.Context, urls []string) error {
+	for _, u := range urls {
+		if name, ok := cfg.thumbnailAssetName(u); ok {
+			if err := cfg.deleteAsset(ctx, name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// thumbnailAssetURLs returns the URLs of a video's thumbnail variants and
+// candidates, the assets that go with the video besides its thumbnail.
+func (cfg *apiConfig) thumbnailAssetURLs(videoID uuid.UUID) ([]string, error) {
+	variants, err := cfg.db.GetThumbnailVariants(videoID)
+	if err != nil {
+		return nil, err
+	}
+	candidates, err := cfg.db.GetThumbnailCandidates(videoID)
+	if err != nil {
+		return nil, err
+	}
+	urls := []string{}
+	for _, variant := range variants {
+		urls = append(urls, variant.URL)
+	}
+	for _, candidate := range candidates {
+		urls = append(urls, candidate.URL)
+	}
+	return urls, nil
+}
+
+// removeThumbnailVariants drops a video's thumbnail variants and deletes
+// their files.
+func (cfg *apiConfig) removeThumbnailVariants(ctx context.Context, videoID uuid.UUID) error {
+	variants, err := cfg.db.GetThumbnailVariants(videoID)
+	if err != nil {
+		return err
+	}
+	if err := cfg.db.ReplaceThumbnailVariants(videoID, nil); err != nil {
+		return err
+	}
+	urls := []string{}
+	for _, variant := range variants {
+		urls = append(urls, variant.URL)
+	}
+	return cfg.removeAssetFiles(ctx, urls)
+}
+
+// handlerVideoFileDelete removes a video's file, its renditions and
+// everything generated from it, keeping the video's metadata and
+// thumbnail. The objects are deleted once the video no longer points at
+// them.
+func (cfg *apiConfig) handlerVideoFileDelete(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.requireVideoOwner(w, r)
+	if !ok {
+		return
+	}
+	if video.VideoURL == nil {
+		respondWithError(w, http.StatusNotFound, "Video has no file", nil)
+		return
+	}
+	if !requireNoLegalHold(w, video) || !requireNotProcessing(w, video) || !cfg.requireUnlockedVideoFile(w, r, video) {
+		return
+	}
+	target, err := cfg.videoTarget(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't locate video file", err)
+		return
+	}
+	renditions, err := cfg.db.GetRenditions(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get renditions", err)
+		return
+	}
+	audioTracks, err := cfg.db.GetAudioTracks(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get audio tracks", err)
+		return
+	}
+
+	cleanup := &cleanupStack{}
+	defer cleanup.run()
+
+	original := video
+	video.VideoURL = nil
+	video.SourceSHA256 = ""
+	video.VideoSHA256 = ""
+	video.Encryption = ""
+	video.PreviewURL = nil
+	video.PeaksURL = nil
+	video.HLSURL = nil
+	video.OriginalFilename = ""
+	video.AspectRatio = ""
+	video.IsShort = false
+	video.ColorInfo = database.ColorInfo{}
+	video.SphericalInfo = database.SphericalInfo{}
+	video.Metadata = nil
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+	cleanup.restoreVideo(cfg.db, original)
+	if err := cfg.db.ReplaceRenditions(video.ID, nil); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to save renditions", err)
+		return
+	}
+	cleanup.onError("restore renditions", func() error { return cfg.db.ReplaceRenditions(video.ID, renditions) })
+	if err := cfg.db.ReplaceAudioTracks(video.ID, nil); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to save audio tracks", err)
+		return
+	}
+	cleanup.onError("restore audio tracks", func() error { return cfg.db.ReplaceAudioTracks(video.ID, audioTracks) })
+	cleanup.onCommit("delete keyframe index", func() error { return cfg.db.DeleteKeyframes(video.ID) })
+	cleanup.onCommit("delete HLS renditions", func() error { return cfg.db.ReplaceHLSRenditions(video.ID, nil) })
+	if !cfg.recordStorageUsage(w, cleanup, video.UserID, video.ID, database.StorageKindVideo, 0) {
+		return
+	}
+
+	cfg.invalidateOnCommit(cleanup, video.ID, "video", cfg.replacedVideoPaths(target, original, renditions))
+	cfg.deleteVideoFilesOnCommit(cleanup, target, original, renditions)
+	cleanup.commit()
+	cfg.emitEvent(eventVideoUpdated, video.ID, nil)
+
+	video, err = cfg.dbVideoToSignedVideo(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URLs", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+// handlerThumbnailDelete removes a video's thumbnail and its variants.
+func (cfg *apiConfig) handlerThumbnailDelete(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.requireVideoOwner(w, r)
+	if !ok {
+		return
+	}
+	if video.ThumbnailURL == nil {
+		respondWithError(w, http.StatusNotFound, "Video has no thumbnail", nil)
+		return
+	}
+	if !requireNoLegalHold(w, video) {
+		return
+	}
+
+	cleanup := &cleanupStack{}
+	defer cleanup.run()
+
+	original := video
+	video.ThumbnailURL = nil
+	video.ThumbnailSHA256 = ""
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+	if !cfg.recordStorageUsage(w, cleanup, video.UserID, video.ID, database.StorageKindThumbnail, 0) {
+		return
+	}
+	cfg.invalidateOnCommit(cleanup, video.ID, "thumbnail", cfg.replacedThumbnailPaths(original))
+	cfg.deleteThumbnailOnCommit(cleanup, original)
+	cleanup.commit()
+	cfg.emitEvent(eventVideoUpdated, video.ID, nil)
+	cfg.emitEvent(eventThumbnailUpdated, video.ID, map[string]any{"source": "deleted"})
+
+	// The thumbnail is already gone, so variants that can't be removed are
+	// only stale files.
+	if err := cfg.removeThumbnailVariants(r.Context(), video.ID); err != nil {
+		log.Printf("Couldn't remove thumbnail variants of video %s: %v", video.ID, err)
+	}
+	video, err := cfg.dbVideoToSignedVideo(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URLs", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, video)
+}
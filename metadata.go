@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"golang.org/x/text/unicode/norm"
+)
+
+// textLimit bounds a user-supplied text field. Runes bound what the UI has
+// to display; bytes bound what is stored and sent in headers, since a rune
+// can take up to four bytes.
+type textLimit struct {
+	field     string
+	maxRunes  int
+	maxBytes  int
+	required  bool
+	multiline bool
+}
+
+var (
+	titleLimit       = textLimit{field: "title", maxRunes: 200, maxBytes: 800, required: true}
+	descriptionLimit = textLimit{field: "description", maxRunes: 5000, maxBytes: 20000, multiline: true}
+	trackTitleLimit  = textLimit{field: "title", maxRunes: 100, maxBytes: 400}
+	tagLimit         = textLimit{field: "tag", maxRunes: 30, maxBytes: 120, required: true}
+)
+
+// maxVideoTags caps how many tags a video can have.
+const maxVideoTags = 10
+
+// normalizeText puts s into NFC form and strips characters that have no
+// business in metadata: control characters (other than newlines and tabs in
+// multiline fields) and the bidirectional overrides that can make text
+// render differently from how it is stored.
+func normalizeText(s string, multiline bool) string {
+	s = strings.ToValidUTF8(s, "")
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case multiline && (r == '\n' || r == '\t'):
+			return r
+		case r == '\n' || r == '\r' || r == '\t':
+			return ' '
+		case unicode.IsControl(r), isBidiControl(r):
+			return -1
+		}
+		return r
+	}, s)
+	return strings.TrimSpace(norm.NFC.String(s))
+}
+
+func isBidiControl(r rune) bool {
+	return (r >= '\u202a' && r <= '\u202e') || (r >= '\u2066' && r <= '\u2069')
+}
+
+// apply normalizes s and checks it against the limit.
+func (l textLimit) apply(s string) (string, error) {
+	s = normalizeText(s, l.multiline)
+	if l.required && s == "" {
+		return "", fmt.Errorf("%s is required", l.field)
+	}
+	if n := utf8.RuneCountInString(s); n > l.maxRunes {
+		return "", fmt.Errorf("%s must be at most %d characters, got %d", l.field, l.maxRunes, n)
+	}
+	if len(s) > l.maxBytes {
+		return "", fmt.Errorf("%s must be at most %d bytes when UTF-8 encoded, got %d", l.field, l.maxBytes, len(s))
+	}
+	return s, nil
+}
+
+// normalizeVideoParams normalizes and validates the user-editable text of a
+// video in place.
+func normalizeVideoParams(params *database.CreateVideoParams) error {
+	var err error
+	if params.Title, err = titleLimit.apply(params.Title); err != nil {
+		return err
+	}
+	if params.Description, err = descriptionLimit.apply(params.Description); err != nil {
+		return err
+	}
+	if params.Tags, err = normalizeTags(params.Tags); err != nil {
+		return err
+	}
+	return validateExternalIDs(params.ExternalIDs)
+}
+
+// normalizeTags lowercases tags and drops duplicates, so "Cooking" and
+// "cooking" match when recommending videos.
+func normalizeTags(tags []string) ([]string, error) {
+	normalized := []string{}
+	for _, tag := range tags {
+		tag, err := tagLimit.apply(strings.ToLower(tag))
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Contains(normalized, tag) {
+			normalized = append(normalized, tag)
+		}
+	}
+	if len(normalized) > maxVideoTags {
+		return nil, fmt.Errorf("a video can have at most %d tags, got %d", maxVideoTags, len(normalized))
+	}
+	return normalized, nil
+}
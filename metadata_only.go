@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+// With METADATA_ONLY=true the server never reads or writes the bytes of a
+// file, for teams whose own upload infrastructure already puts videos in
+// the bucket. Every route that receives, processes or serves a file is
+// turned away, and clients register the objects they stored instead, with
+// handlerVideoObjectRegister. The server checks the object is there, with
+// a HEAD, and records it; from then on the video is listed, presigned,
+// trashed and deleted like any other. Nothing is transcoded or scanned,
+// so each object is played back as it was stored.
+
+const metadataOnlyMessage = "This server only records metadata. Upload the file to storage and register it instead."
+
+// metadataOnlyMiddleware rejects requests with a 403 if the server is in
+// metadata-only mode. It is applied to routes that would handle a file's
+// bytes.
+func (cfg *apiConfig) metadataOnlyMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if cfg.metadataOnly {
+			respondWithError(w, http.StatusForbidden, metadataOnlyMessage, nil)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// handlerVideoObjectRegister records the object {"key", "content_type"} as
+// the video's file. The key has to be under the video's prefix,
+// {userID}/{videoID}/, so no one can register another user's objects, and
+// the video mustn't have a file yet; deleting its file makes room for
+// another. The object's size counts against the user's storage quota.
+func (cfg *apiConfig) handlerVideoObjectRegister(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Key         string `json:"key"`
+		ContentType string `json:"content_type"`
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+	userID, tenantID, ok := cfg.requireUploader(w, r)
+	if !ok {
+		return
+	}
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	target, err := cfg.tenants.Target(r.Context(), tenantID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't resolve storage for tenant", err)
+		return
+	}
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't find video", err)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusUnauthorized, "Not authorized to upload for this video", nil)
+		return
+	}
+	if !requireNoLegalHold(w, video) || !requireNotProcessing(w, video) {
+		return
+	}
+	if video.VideoURL != nil {
+		respondWithError(w, http.StatusConflict, "Video already has a file", nil)
+		return
+	}
+	if !isNamespacedKey(params.Key, userID, videoID) || params.Key == videoKeyPrefix(userID, videoID) {
+		respondWithError(w, http.StatusBadRequest, "Object key must be under the video's prefix", fmt.Errorf("key %q", params.Key))
+		return
+	}
+	if _, ok := cfg.requireVideoContainer(w, params.ContentType); !ok {
+		return
+	}
+
+	// Sending the request counts as the upload, whether or not the object
+	// turns out to be there.
+	plog := newProcessingLog(videoID, "metadata")
+	rec := &errorRecorder{ResponseWriter: w}
+	w = rec
+	r = r.WithContext(plog.context(r.Context()))
+	defer func() { cfg.saveProcessingLog(plog, rec.failure()) }()
+
+	size, err := target.Storage().Size(r.Context(), params.Key)
+	if errors.Is(err, storage.ErrNotFound) {
+		respondWithError(w, http.StatusBadRequest, "Object not found", fmt.Errorf("s3://%s/%s", target.Bucket, params.Key))
+		return
+	}
+	if err != nil {
+		respondWithStorageError(w, http.StatusBadGateway, "Couldn't check object", err)
+		return
+	}
+	if size == 0 {
+		respondWithError(w, http.StatusBadRequest, "Object is empty", nil)
+		return
+	}
+	if !cfg.requireStorageQuota(w, userID, videoID, database.StorageKindVideo, size) {
+		return
+	}
+
+	cleanup := &cleanupStack{}
+	defer cleanup.run()
+
+	original := video
+	video.VideoURL = &params.Key
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to update video metadata", err)
+		return
+	}
+	cleanup.restoreVideo(cfg.db, original)
+	if !cfg.recordStorageUsage(w, cleanup, userID, videoID, database.StorageKindVideo, size) {
+		return
+	}
+	cleanup.commit()
+	cfg.setVideoProcessing(videoID, database.VideoReady, "")
+	video.ProcessingStatus, video.ProcessingError = database.VideoReady, ""
+	cfg.emitEvent(eventVideoUploaded, videoID, map[string]any{"source": "metadata", "size": size})
+
+	video, err = cfg.dbVideoToSignedVideo(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URLs", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, video)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tenants"
+)
+
+// multipartConfig is how large video files are uploaded to S3: files bigger
+// than partSize are sent as a multipart upload of parts that size, with up
+// to concurrency parts in flight at once.
+type multipartConfig struct {
+	partSize    int64
+	concurrency int
+}
+
+const (
+	defaultMultipartPartSize    = 16 << 20
+	defaultMultipartConcurrency = 4
+)
+
+// uploadVideoFile is uploadFile for video files, which can be large enough
+// that a single PUT is slow and has to start over whenever the connection
+// drops.
+func (cfg *apiConfig) uploadVideoFile(ctx context.Context, target tenants.Target, key, path, contentType string, opts ...func(*s3.PutObjectInput)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	progress := uploadProgressFrom(ctx)
+	if !target.IsS3() || cfg.multipart.partSize <= 0 || info.Size() <= cfg.multipart.partSize {
+		return putObject(ctx, target, key, progress.reader(f), contentType, opts...)
+	}
+	return multipartUpload(ctx, target, key, progress.readerAt(f), info.Size(), contentType, cfg.multipart, opts...)
+}
+
+// multipartUpload uploads size bytes of body to key in parts. opts are
+// applied as for putObject, and carried over to the multipart upload. If any
+// part fails the upload is aborted, so its parts don't linger in the bucket.
+func multipartUpload(ctx context.Context, target tenants.Target, key string, body io.ReaderAt, size int64, contentType string, mc multipartConfig, opts ...func(*s3.PutObjectInput)) error {
+	put := &s3.PutObjectInput{
+		Bucket:      aws.String(target.Bucket),
+		Key:         aws.String(key),
+		ContentType: aws.String(contentType),
+	}
+	withRetention(target.Retention)(put)
+	withEncryption(target.Encryption)(put)
+	for _, opt := range opts {
+		opt(put)
+	}
+
+	// S3 allows at most maxSessionParts parts, which caps the file size
+	// at the configured part size; anything bigger gets bigger parts.
+	partSize := max(mc.partSize, minPartSize, (size+maxSessionParts-1)/maxSessionParts)
+	partCount := int((size + partSize - 1) / partSize)
+
+	start := time.Now()
+	created, err := target.Client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:                    put.Bucket,
+		Key:                       put.Key,
+		ContentType:               put.ContentType,
+		ContentDisposition:        put.ContentDisposition,
+		CacheControl:              put.CacheControl,
+		Metadata:                  put.Metadata,
+		ObjectLockMode:            put.ObjectLockMode,
+		ObjectLockRetainUntilDate: put.ObjectLockRetainUntilDate,
+		ObjectLockLegalHoldStatus: put.ObjectLockLegalHoldStatus,
+		ChecksumAlgorithm:         put.ChecksumAlgorithm,
+		ServerSideEncryption:      put.ServerSideEncryption,
+		SSEKMSKeyId:               put.SSEKMSKeyId,
+		StorageClass:              put.StorageClass,
+		Tagging:                   put.Tagging,
+	})
+	if err != nil {
+		logStep(ctx, "s3", fmt.Sprintf("PUT s3://%s/%s", target.Bucket, key), start, err)
+		return err
+	}
+	uploadID := created.UploadId
+
+	parts, err := uploadParts(ctx, target, put, uploadID, body, size, partSize, partCount, mc.concurrency)
+	if err == nil {
+		_, err = target.Client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+			Bucket:          put.Bucket,
+			Key:             put.Key,
+			UploadId:        uploadID,
+			MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+		})
+	}
+	logStep(ctx, "s3", fmt.Sprintf("PUT s3://%s/%s (%d parts)", target.Bucket, key, partCount), start, err)
+	if err != nil {
+		// The request's context may be what failed the upload, so the
+		// abort gets its own.
+		abortCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if _, abortErr := target.Client.AbortMultipartUpload(abortCtx, &s3.AbortMultipartUploadInput{
+			Bucket:   put.Bucket,
+			Key:      put.Key,
+			UploadId: uploadID,
+		}); abortErr != nil {
+			log.Printf("Couldn't abort multipart upload of s3://%s/%s: %v", target.Bucket, key, abortErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// uploadParts sends the parts of body with up to concurrency in flight, and
+// returns them in order. It stops at the first failure.
+func uploadParts(ctx context.Context, target tenants.Target, put *s3.PutObjectInput, uploadID *string, body io.ReaderAt, size, partSize int64, partCount, concurrency int) ([]types.CompletedPart, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	numbers := make(chan int32)
+	go func() {
+		defer close(numbers)
+		for n := int32(1); n <= int32(partCount); n++ {
+			select {
+			case numbers <- n:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		parts    = make([]types.CompletedPart, 0, partCount)
+		firstErr error
+	)
+	for range max(concurrency, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range numbers {
+				offset := int64(n-1) * partSize
+				length := min(partSize, size-offset)
+				out, err := target.Client.UploadPart(ctx, &s3.UploadPartInput{
+					Bucket:            put.Bucket,
+					Key:               put.Key,
+					UploadId:          uploadID,
+					PartNumber:        aws.Int32(n),
+					Body:              io.NewSectionReader(body, offset, length),
+					ContentLength:     aws.Int64(length),
+					ChecksumAlgorithm: put.ChecksumAlgorithm,
+				})
+
+				mu.Lock()
+				if err != nil {
+					if firstErr == nil {
+						firstErr = fmt.Errorf("part %d: %w", n, err)
+						cancel()
+					}
+				} else {
+					parts = append(parts, types.CompletedPart{
+						PartNumber:     aws.Int32(n),
+						ETag:           out.ETag,
+						ChecksumSHA256: out.ChecksumSHA256,
+					})
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(parts, func(i, j int) bool { return *parts[i].PartNumber < *parts[j].PartNumber })
+	return parts, nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+	"net/url"
+)
+
+// streamedFile is the file part of a form read by streamMultipartForm.
+type streamedFile struct {
+	filename string
+	header   textproto.MIMEHeader
+	size     int64
+}
+
+// formWriteError is a failure to write a form's file to where it was being
+// streamed, as opposed to a malformed or cut-off request.
+type formWriteError struct {
+	err error
+}
+
+func (e *formWriteError) Error() string { return "couldn't save form file: " + e.err.Error() }
+func (e *formWriteError) Unwrap() error { return e.err }
+
+// recordingWriter remembers the first error its writer returned, so it can
+// be told apart from an error reading what was copied to it.
+type recordingWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (rw *recordingWriter) Write(p []byte) (int, error) {
+	n, err := rw.w.Write(p)
+	if err != nil && rw.err == nil {
+		rw.err = err
+	}
+	return n, err
+}
+
+// streamMultipartForm reads r's multipart/form-data body one part at a time,
+// in whatever order the client sent them, copying the file named field to
+// dst as it arrives. Unlike ParseMultipartForm, the file is never buffered
+// in memory or spilled to a temporary file of its own first, and the other
+// fields may come before or after it. They're kept in memory, up to
+// uploadFormOverhead in all, and other files are skipped.
+//
+// The returned values are the body's fields followed by the query's, as in
+// r.Form. A failure to write to dst is a *formWriteError; a body over its
+// http.MaxBytesReader limit returns the *http.MaxBytesError.
+func streamMultipartForm(r *http.Request, field string, dst io.Writer) (streamedFile, url.Values, error) {
+	var file streamedFile
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return file, nil, err
+	}
+
+	values := url.Values{}
+	found := false
+	room := int64(uploadFormOverhead)
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return file, nil, err
+		}
+		name := part.FormName()
+		switch {
+		case name == field && part.FileName() != "":
+			if found {
+				part.Close()
+				return file, nil, fmt.Errorf("form has more than one %q file", field)
+			}
+			found = true
+			file.filename = part.FileName()
+			file.header = part.Header
+			rw := &recordingWriter{w: dst}
+			file.size, err = io.Copy(rw, part)
+			if rw.err != nil {
+				err = &formWriteError{err: rw.err}
+			}
+		case part.FileName() != "":
+			_, err = io.Copy(io.Discard, part)
+		default:
+			var value []byte
+			value, err = io.ReadAll(io.LimitReader(part, room+1))
+			room -= int64(len(value))
+			if err == nil && room < 0 {
+				err = errors.New("form fields are too large")
+			}
+			values.Add(name, string(value))
+		}
+		part.Close()
+		if err != nil {
+			return file, nil, err
+		}
+	}
+	if !found {
+		return file, nil, fmt.Errorf("form has no %q file", field)
+	}
+
+	for k, vs := range r.URL.Query() {
+		values[k] = append(values[k], vs...)
+	}
+	return file, values, nil
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Kinds of notification channels, by the format their incoming webhooks
+// take. Mattermost and Rocket.Chat take Slack's.
+const (
+	notificationKindSlack   = "slack"
+	notificationKindDiscord = "discord"
+)
+
+// notificationEvents are the events notification channels can be sent.
+var notificationEvents = map[string]bool{
+	eventVideoReady:            true,
+	eventVideoProcessingFailed: true,
+}
+
+var notificationMentionLimit = textLimit{field: "mention", maxRunes: 100, maxBytes: 400}
+
+const (
+	maxNotificationChannels = 10
+	maxNotificationRules    = 10
+	// A message that fails is tried notificationAttempts times in all,
+	// notificationRetryDelay apart and doubling, or after the Retry-After
+	// of a rate-limited attempt, up to notificationMaxRetryAfter.
+	notificationAttempts      = 3
+	notificationRetryDelay    = 2 * time.Second
+	notificationMaxRetryAfter = 30 * time.Second
+)
+
+// queueNotifications sends the event to the notification channels of the
+// video's owner and of their tenant whose rules it matches. Messages are
+// best effort: each is sent from the background, retried a couple of times
+// and, if it still fails, recorded as the channel's last_error.
+func (cfg *apiConfig) queueNotifications(e event) {
+	if !notificationEvents[e.Type] {
+		return
+	}
+	video, err := cfg.db.GetVideo(e.VideoID)
+	if err != nil || video.ID == uuid.Nil {
+		log.Printf("Couldn't get video %s for notifications of event %s: %v", e.VideoID, e.ID, err)
+		return
+	}
+	channels, err := cfg.db.GetNotificationChannels(video.UserID)
+	if err != nil {
+		log.Printf("Couldn't get notification channels of user %s: %v", video.UserID, err)
+		return
+	}
+	owner, err := cfg.db.GetUser(video.UserID)
+	if err != nil {
+		log.Printf("Couldn't get owner of video %s for notifications: %v", video.ID, err)
+		return
+	}
+	if owner != nil && owner.TenantID != "" {
+		tenantChannels, err := cfg.db.GetTenantNotificationChannels(owner.TenantID)
+		if err != nil {
+			log.Printf("Couldn't get notification channels of tenant %s: %v", owner.TenantID, err)
+			return
+		}
+		channels = append(channels, tenantChannels...)
+	}
+
+	brand := notificationBrand{}
+	if owner != nil && owner.TenantID != "" {
+		branding, err := cfg.db.GetTenantBranding(owner.TenantID)
+		if err != nil {
+			log.Printf("Couldn't get branding of tenant %s for notifications: %v", owner.TenantID, err)
+		}
+		brand = cfg.notificationBrand(branding)
+	}
+
+	data, _ := e.Data.(map[string]any)
+	source, _ := data["source"].(string)
+	failure, _ := data["error"].(string)
+	for _, channel := range channels {
+		rule, ok := channel.Match(e.Type, source)
+		if !ok {
+			continue
+		}
+		body, err := notificationMessage(channel.Kind, rule.Mention, e.Type, video.Title, cfg.videoPageURL(video.ID), failure, brand)
+		if err != nil {
+			log.Printf("Couldn't build notification of event %s: %v", e.ID, err)
+			continue
+		}
+		cfg.background.run(func() { cfg.sendNotification(channel, body) })
+	}
+}
+
+// videoPageURL is the URL of the video's page on the site.
+func (cfg *apiConfig) videoPageURL(videoID uuid.UUID) string {
+	return strings.ReplaceAll(cfg.sitemapPageURL, "{id}", videoID.String())
+}
+
+// notificationBrand is a tenant's branding as notifications show it: the
+// logo as the message's avatar, and the primary color for good news.
+type notificationBrand struct {
+	IconURL string
+	Color   int
+}
+
+func (cfg *apiConfig) notificationBrand(branding *database.TenantBranding) notificationBrand {
+	brand := notificationBrand{IconURL: cfg.brandingLogoURL(branding), Color: 0x2eb67d}
+	if branding != nil && branding.PrimaryColor != "" {
+		if color, err := strconv.ParseInt(branding.PrimaryColor[1:], 16, 32); err == nil {
+			brand.Color = int(color)
+		}
+	}
+	return brand
+}
+
+// notificationMessage is the incoming webhook body of a channel of kind
+// telling it about an event.
+func notificationMessage(kind, mention, eventType, title, link, failure string, brand notificationBrand) ([]byte, error) {
+	ready := eventType == eventVideoReady
+	switch kind {
+	case notificationKindSlack:
+		escape := strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace
+		text := fmt.Sprintf(":white_check_mark: *<%s|%s>* is ready", link, escape(title))
+		if !ready {
+			text = fmt.Sprintf(":x: Processing of *<%s|%s>* failed: %s", link, escape(title), escape(failure))
+		}
+		if mention != "" {
+			text = mention + " " + text
+		}
+		message := map[string]any{"text": text}
+		if brand.IconURL != "" {
+			message["icon_url"] = brand.IconURL
+		}
+		return json.Marshal(message)
+	case notificationKindDiscord:
+		embed := map[string]any{"title": title, "url": link, "description": "Ready to watch", "color": brand.Color}
+		if !ready {
+			embed["description"] = "Processing failed: " + failure
+			embed["color"] = 0xe01e5a
+		}
+		message := map[string]any{"content": mention, "embeds": []any{embed}}
+		if brand.IconURL != "" {
+			message["avatar_url"] = brand.IconURL
+		}
+		return json.Marshal(message)
+	}
+	return nil, fmt.Errorf("unknown notification channel kind %q", kind)
+}
+
+// sendNotification POSTs body to the channel, retrying failed attempts,
+// and records how it went.
+func (cfg *apiConfig) sendNotification(channel database.NotificationChannel, body []byte) {
+	var err error
+	delay := notificationRetryDelay
+	for attempt := 1; ; attempt++ {
+		var retryAfter time.Duration
+		retryAfter, err = cfg.postNotification(channel.URL, body)
+		if err == nil || attempt == notificationAttempts {
+			break
+		}
+		if retryAfter > 0 {
+			delay = min(retryAfter, notificationMaxRetryAfter)
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+
+	var sentAt *time.Time
+	errMsg := ""
+	if err == nil {
+		now := cfg.clock.Now().UTC()
+		sentAt = &now
+	} else {
+		errMsg = err.Error()
+		log.Printf("Giving up on notification to channel %s after %d attempts: %v", channel.ID, notificationAttempts, err)
+	}
+	if err := cfg.db.RecordNotificationSent(channel.ID, sentAt, errMsg); err != nil {
+		log.Printf("Couldn't record notification to channel %s: %v", channel.ID, err)
+	}
+}
+
+// postNotification makes one attempt at sending body to an incoming
+// webhook. For a rate-limited attempt, it returns how long the receiver
+// asked to wait, if it said.
+func (cfg *apiConfig) postNotification(webhookURL string, body []byte) (time.Duration, error) {
+	return postIncomingWebhook(cfg.webhooks.client, webhookURL, body)
+}
+
+// postIncomingWebhook is postNotification with client.
+func postIncomingWebhook(client *http.Client, webhookURL string, body []byte) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Tubely-Webhooks/1")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var retryAfter time.Duration
+		if secs, err := strconv.ParseFloat(resp.Header.Get("Retry-After"), 64); err == nil && secs > 0 {
+			retryAfter = time.Duration(secs * float64(time.Second))
+		}
+		return retryAfter, fmt.Errorf("receiver responded %s", resp.Status)
+	}
+	return 0, nil
+}
+
+// notificationChannelParams are the settings of a new notification
+// channel.
+type notificationChannelParams struct {
+	Kind  string                      `json:"kind"`
+	URL   string                      `json:"url"`
+	Rules []database.NotificationRule `json:"rules"`
+}
+
+// channel validates p as a channel, responding with an error if it
+// isn't one. If ok is false, an error response has been written.
+func (p notificationChannelParams) channel(w http.ResponseWriter) (channel database.NotificationChannel, ok bool) {
+	if p.Kind != notificationKindSlack && p.Kind != notificationKindDiscord {
+		respondWithError(w, http.StatusBadRequest, "Unknown notification channel kind", fmt.Errorf("kind must be %s or %s", notificationKindSlack, notificationKindDiscord))
+		return channel, false
+	}
+	webhookURL, err := parseWebhookURL(p.URL)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid webhook URL", err)
+		return channel, false
+	}
+	if len(p.Rules) > maxNotificationRules {
+		respondWithError(w, http.StatusBadRequest, "Too many notification rules", fmt.Errorf("at most %d rules", maxNotificationRules))
+		return channel, false
+	}
+	rules := make([]database.NotificationRule, 0, len(p.Rules))
+	for _, rule := range p.Rules {
+		for _, e := range rule.Events {
+			if !notificationEvents[e] {
+				respondWithError(w, http.StatusBadRequest, "Unknown notification event", fmt.Errorf("unknown event %q", e))
+				return channel, false
+			}
+		}
+		rule.Mention, err = notificationMentionLimit.apply(rule.Mention)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid mention", err)
+			return channel, false
+		}
+		rules = append(rules, rule)
+	}
+	return database.NotificationChannel{
+		ID:    uuid.New(),
+		Kind:  p.Kind,
+		URL:   webhookURL,
+		Rules: rules,
+	}, true
+}
+
+// handlerNotificationChannelCreate adds a notification channel for the
+// user's videos.
+func (cfg *apiConfig) handlerNotificationChannelCreate(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.requireUser(w, r)
+	if !ok {
+		return
+	}
+	params := notificationChannelParams{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	channel, ok := params.channel(w)
+	if !ok {
+		return
+	}
+	channel.UserID = &userID
+
+	existing, err := cfg.db.GetNotificationChannels(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get notification channels", err)
+		return
+	}
+	cfg.createNotificationChannel(w, channel, len(existing))
+}
+
+// createNotificationChannel saves channel, unless its owner has too many
+// channels already.
+func (cfg *apiConfig) createNotificationChannel(w http.ResponseWriter, channel database.NotificationChannel, existing int) {
+	if existing >= maxNotificationChannels {
+		respondWithError(w, http.StatusConflict, "Too many notification channels", fmt.Errorf("%d channels already", existing))
+		return
+	}
+	channel.CreatedAt = cfg.clock.Now().UTC()
+	if err := cfg.db.CreateNotificationChannel(channel); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save notification channel", err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, channel)
+}
+
+// handlerNotificationChannelsGet lists the user's notification channels.
+func (cfg *apiConfig) handlerNotificationChannelsGet(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.requireUser(w, r)
+	if !ok {
+		return
+	}
+	channels, err := cfg.db.GetNotificationChannels(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get notification channels", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, channels)
+}
+
+// handlerNotificationChannelDelete deletes one of the user's notification
+// channels.
+func (cfg *apiConfig) handlerNotificationChannelDelete(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.requireUser(w, r)
+	if !ok {
+		return
+	}
+	cfg.deleteNotificationChannel(w, r, func(channel *database.NotificationChannel) bool {
+		return channel.UserID != nil && *channel.UserID == userID
+	})
+}
+
+// deleteNotificationChannel deletes the channel in the path if owns says
+// the requester may.
+func (cfg *apiConfig) deleteNotificationChannel(w http.ResponseWriter, r *http.Request, owns func(*database.NotificationChannel) bool) {
+	channelID, err := uuid.Parse(r.PathValue("channelID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid notification channel ID", err)
+		return
+	}
+	channel, err := cfg.db.GetNotificationChannel(channelID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get notification channel", err)
+		return
+	}
+	if channel == nil || !owns(channel) {
+		respondWithError(w, http.StatusNotFound, "Notification channel not found", nil)
+		return
+	}
+	if err := cfg.db.DeleteNotificationChannel(channel.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete notification channel", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// requireTenantInPath returns the tenant in the path for an admin. If ok is
+// false, an error response has been written.
+func (cfg *apiConfig) requireTenantInPath(w http.ResponseWriter, r *http.Request) (tenantID string, ok bool) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return "", false
+	}
+	tenantID = r.PathValue("tenantID")
+	if !cfg.tenants.Exists(tenantID) {
+		respondWithError(w, http.StatusNotFound, "Unknown tenant", nil)
+		return "", false
+	}
+	return tenantID, true
+}
+
+// handlerAdminTenantNotificationChannelCreate adds a notification channel
+// for the videos of every user of a tenant.
+func (cfg *apiConfig) handlerAdminTenantNotificationChannelCreate(w http.ResponseWriter, r *http.Request) {
+	tenantID, ok := cfg.requireTenantInPath(w, r)
+	if !ok {
+		return
+	}
+	params := notificationChannelParams{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	channel, ok := params.channel(w)
+	if !ok {
+		return
+	}
+	channel.TenantID = tenantID
+
+	existing, err := cfg.db.GetTenantNotificationChannels(tenantID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get notification channels", err)
+		return
+	}
+	cfg.createNotificationChannel(w, channel, len(existing))
+}
+
+// handlerAdminTenantNotificationChannelsGet lists a tenant's notification
+// channels.
+func (cfg *apiConfig) handlerAdminTenantNotificationChannelsGet(w http.ResponseWriter, r *http.Request) {
+	tenantID, ok := cfg.requireTenantInPath(w, r)
+	if !ok {
+		return
+	}
+	channels, err := cfg.db.GetTenantNotificationChannels(tenantID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get notification channels", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, channels)
+}
+
+// handlerAdminTenantNotificationChannelDelete deletes one of a tenant's
+// notification channels.
+func (cfg *apiConfig) handlerAdminTenantNotificationChannelDelete(w http.ResponseWriter, r *http.Request) {
+	tenantID, ok := cfg.requireTenantInPath(w, r)
+	if !ok {
+		return
+	}
+	cfg.deleteNotificationChannel(w, r, func(channel *database.NotificationChannel) bool {
+		return channel.UserID == nil && channel.TenantID == tenantID
+	})
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/i18n"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tenants"
+)
+
+const objectLockedMessage = "The video's files are locked by S3 Object Lock"
+
+// objectLock is the S3 Object Lock state of a stored object. Buckets
+// without Object Lock, and credentials without s3:GetObjectRetention, report
+// no lock.
+type objectLock struct {
+	Mode        string     `json:"mode,omitempty"`
+	RetainUntil *time.Time `json:"retain_until,omitempty"`
+	LegalHold   bool       `json:"legal_hold,omitempty"`
+}
+
+func (l objectLock) locked(now time.Time) bool {
+	return l.LegalHold || (l.RetainUntil != nil && l.RetainUntil.After(now))
+}
+
+// headObjectLock reads the lock on key. A missing object isn't locked, and
+// neither is one outside S3.
+func headObjectLock(ctx context.Context, target tenants.Target, key string) (objectLock, error) {
+	if !target.IsS3() {
+		return objectLock{}, nil
+	}
+	out, err := target.Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(target.Bucket),
+		Key:    aws.String(key),
+	})
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		return objectLock{}, nil
+	}
+	if err != nil {
+		return objectLock{}, err
+	}
+	lock := objectLock{
+		Mode:      string(out.ObjectLockMode),
+		LegalHold: out.ObjectLockLegalHoldStatus == types.ObjectLockLegalHoldStatusOn,
+	}
+	if out.ObjectLockRetainUntilDate != nil {
+		retainUntil := out.ObjectLockRetainUntilDate.UTC()
+		lock.RetainUntil = &retainUntil
+	}
+	return lock, nil
+}
+
+// withRetention applies the target's retention to an upload. Object Lock
+// uploads need an integrity checksum, so one is requested as well.
+func withRetention(retention *tenants.Retention) func(*s3.PutObjectInput) {
+	return func(input *s3.PutObjectInput) {
+		if retention == nil {
+			return
+		}
+		input.ObjectLockMode = types.ObjectLockMode(retention.Mode)
+		input.ObjectLockRetainUntilDate = aws.Time(retention.RetainUntil(time.Now().UTC()))
+		input.ChecksumAlgorithm = types.ChecksumAlgorithmSha256
+	}
+}
+
+// withCopyRetention is withRetention for copies.
+func withCopyRetention(retention *tenants.Retention) func(*s3.CopyObjectInput) {
+	return func(input *s3.CopyObjectInput) {
+		if retention == nil {
+			return
+		}
+		input.ObjectLockMode = types.ObjectLockMode(retention.Mode)
+		input.ObjectLockRetainUntilDate = aws.Time(retention.RetainUntil(time.Now().UTC()))
+	}
+}
+
+// requireUnlockedVideoFile rejects deleting or replacing a video whose file
+// is locked in S3, saying until when. The locked file is the record the
+// lock protects, so the video keeps pointing at it rather than leaving it
+// behind where nobody can reach or remove it. If ok is false, an error
+// response has been written.
+func (cfg *apiConfig) requireUnlockedVideoFile(w http.ResponseWriter, r *http.Request, video database.Video) (ok bool) {
+	if video.VideoURL == nil {
+		return true
+	}
+	target, key, err := cfg.videoObject(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't locate video file", err)
+		return false
+	}
+	lock, err := headObjectLock(r.Context(), target, key)
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Couldn't check video file", err)
+		return false
+	}
+	if !lock.locked(time.Now()) {
+		return true
+	}
+
+	type response struct {
+		Error string `json:"error"`
+		Code  string `json:"code"`
+		objectLock
+	}
+	lang := w.Header().Get("Content-Language")
+	if lang == "" {
+		lang = i18n.Default
+	}
+	respondWithJSON(w, http.StatusConflict, response{
+		Error:      i18n.Translate(lang, objectLockedMessage),
+		Code:       i18n.Code(objectLockedMessage, http.StatusConflict),
+		objectLock: lock,
+	})
+	return false
+}
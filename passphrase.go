@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/clock"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// A passphrase-protected video's playback needs an unlock token, which
+// POST /api/videos/{videoID}/unlock gives out for the passphrase. Clients
+// send it back in the X-Unlock-Token header, the ?unlock= query parameter
+// or the cookie the unlock sets, and the URLs handed out to them carry it,
+// so players don't have to. Without one, the video's metadata is still
+// returned but its file URLs are left out. Owners never need a token.
+
+const (
+	unlockParam  = "unlock"
+	unlockHeader = "X-Unlock-Token"
+	// maxPassphraseLength is the most bcrypt hashes.
+	maxPassphraseLength = 72
+	minPassphraseLength = 4
+)
+
+func unlockCookieName(videoID uuid.UUID) string {
+	return "tubely_unlock_" + videoID.String()
+}
+
+// unlockRequestKey is the context key of the request being served, for
+// signVideo to find its unlock tokens.
+type unlockRequestKey struct{}
+
+// unlockMiddleware makes the request's unlock tokens available to
+// everything that signs video URLs while serving it.
+func unlockMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), unlockRequestKey{}, r)))
+	})
+}
+
+// unlockToken signs unlocking of video until expires. It covers the
+// passphrase's hash, so changing the passphrase invalidates the tokens
+// given out for the old one, and the viewer if the passphrase is only for
+// signed-in viewers, so the token can't be passed on to someone signed out.
+func (cfg *apiConfig) unlockToken(video database.Video, viewerID *uuid.UUID, expires time.Time) string {
+	viewer := ""
+	if video.PassphraseRequiresLogin && viewerID != nil {
+		viewer = viewerID.String()
+	}
+	mac := hmac.New(sha256.New, []byte(cfg.jwtSecret))
+	fmt.Fprintf(mac, "unlock|%s|%s|%s|%d", video.ID, viewer, video.PassphraseHash, expires.Unix())
+	return strconv.FormatInt(expires.Unix(), 10) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// requestUnlockToken returns the unlock token r carries for video, whether
+// it's valid or not.
+func requestUnlockToken(r *http.Request, video database.Video) string {
+	if token := r.Header.Get(unlockHeader); token != "" {
+		return token
+	}
+	if token := r.URL.Query().Get(unlockParam); token != "" {
+		return token
+	}
+	if c, err := r.Cookie(unlockCookieName(video.ID)); err == nil {
+		return c.Value
+	}
+	return ""
+}
+
+// unlocked reports whether r may play video as far as its passphrase is
+// concerned, and returns the unlock token the URLs handed out should carry,
+// if any.
+func (cfg *apiConfig) unlocked(r *http.Request, video database.Video) (token string, ok bool) {
+	if video.PassphraseHash == "" {
+		return "", true
+	}
+	viewerID := cfg.viewerID(r)
+	if viewerID != nil && *viewerID == video.UserID {
+		return "", true
+	}
+	if video.PassphraseRequiresLogin && viewerID == nil {
+		return "", false
+	}
+	token = requestUnlockToken(r, video)
+	expiresString, _, found := strings.Cut(token, ".")
+	if !found {
+		return "", false
+	}
+	expires, err := strconv.ParseInt(expiresString, 10, 64)
+	if err != nil || clock.Expired(cfg.clock, time.Unix(expires, 0)) {
+		return "", false
+	}
+	want := cfg.unlockToken(video, viewerID, time.Unix(expires, 0))
+	if !hmac.Equal([]byte(token), []byte(want)) {
+		return "", false
+	}
+	return token, true
+}
+
+// unlockedInContext is unlocked for the request ctx is serving. Outside of
+// one, nothing is unlocked.
+func (cfg *apiConfig) unlockedInContext(ctx context.Context, video database.Video) (string, bool) {
+	if video.PassphraseHash == "" {
+		return "", true
+	}
+	r, ok := ctx.Value(unlockRequestKey{}).(*http.Request)
+	if !ok {
+		return "", false
+	}
+	return cfg.unlocked(r, video)
+}
+
+// passesPassphrase reports whether the request may play the video, with
+// the message to refuse it with if not.
+func (cfg *apiConfig) passesPassphrase(r *http.Request, video database.Video) (msg string, ok bool) {
+	if _, ok := cfg.unlocked(r, video); ok {
+		return "", true
+	}
+	if video.PassphraseRequiresLogin && cfg.viewerID(r) == nil {
+		return "Sign in and enter the passphrase to watch this video", false
+	}
+	return "Enter the passphrase to watch this video", false
+}
+
+// handlerVideoPassphraseSet protects a video with a passphrase, replacing
+// any it had. With require_login, viewers also have to be signed in;
+// otherwise the passphrase is enough, whatever the video's visibility
+// lets through.
+func (cfg *apiConfig) handlerVideoPassphraseSet(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.requireVideoOwner(w, r)
+	if !ok {
+		return
+	}
+
+	type parameters struct {
+		Passphrase   string `json:"passphrase"`
+		RequireLogin bool   `json:"require_login"`
+	}
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if len(params.Passphrase) < minPassphraseLength || len(params.Passphrase) > maxPassphraseLength {
+		respondWithError(w, http.StatusBadRequest, "passphrase must be 4 to 72 bytes", nil)
+		return
+	}
+
+	hash, err := auth.HashPassword(params.Passphrase)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't hash passphrase", err)
+		return
+	}
+	if err := cfg.db.SetVideoPassphrase(video.ID, hash, params.RequireLogin); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+	video.PassphraseHash = hash
+	video.PassphraseProtected = true
+	video.PassphraseRequiresLogin = params.RequireLogin
+	cfg.passphraseChanged(w, r, video)
+}
+
+// handlerVideoPassphraseDelete removes a video's passphrase.
+func (cfg *apiConfig) handlerVideoPassphraseDelete(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.requireVideoOwner(w, r)
+	if !ok {
+		return
+	}
+	if video.PassphraseHash == "" {
+		respondWithError(w, http.StatusNotFound, "Video has no passphrase", nil)
+		return
+	}
+	if err := cfg.db.SetVideoPassphrase(video.ID, "", false); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+	video.PassphraseHash = ""
+	video.PassphraseProtected = false
+	video.PassphraseRequiresLogin = false
+	cfg.passphraseChanged(w, r, video)
+}
+
+// passphraseChanged drops the URLs signed for video before its passphrase
+// changed, takes it out of or puts it back in the sitemap, and responds
+// with it.
+func (cfg *apiConfig) passphraseChanged(w http.ResponseWriter, r *http.Request, video database.Video) {
+	cfg.signedURLs.invalidate(video.ID)
+	cfg.emitEvent(eventVideoUpdated, video.ID, map[string]any{"passphrase_protected": video.PassphraseProtected})
+	video, err := cfg.dbVideoToSignedVideo(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URLs", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+// handlerVideoUnlock checks a passphrase and gives out an unlock token for
+// the video, valid for cfg.unlockTTL, also setting it as a cookie for the
+// video's endpoints. Attempts are limited per client IP and video, so the
+// passphrase can't be guessed.
+func (cfg *apiConfig) handlerVideoUnlock(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil || !cfg.canView(r, video) {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	if video.PassphraseHash == "" {
+		respondWithError(w, http.StatusNotFound, "Video has no passphrase", nil)
+		return
+	}
+	viewerID := cfg.viewerID(r)
+	if video.PassphraseRequiresLogin && viewerID == nil {
+		respondWithError(w, http.StatusUnauthorized, "Sign in and enter the passphrase to watch this video", nil)
+		return
+	}
+
+	if l := cfg.passphraseRateLimit; l != nil {
+		key := "ip:" + cfg.clientIP(r) + "|video:" + video.ID.String()
+		state, ok := l.take(cfg.clock.Now(), []string{key}, []int{l.perIP})
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(max(1, ceilSeconds(state.retryAfter))))
+			respondWithError(w, http.StatusTooManyRequests, "Too many passphrase attempts, try again later", nil)
+			return
+		}
+	}
+
+	type parameters struct {
+		Passphrase string `json:"passphrase"`
+	}
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if err := auth.CheckPasswordHash(params.Passphrase, video.PassphraseHash); err != nil {
+		respondWithError(w, http.StatusForbidden, "Incorrect passphrase", err)
+		return
+	}
+
+	expires := cfg.clock.Now().Add(cfg.unlockTTL).Truncate(time.Second)
+	token := cfg.unlockToken(video, viewerID, expires)
+	http.SetCookie(w, &http.Cookie{
+		Name:     unlockCookieName(video.ID),
+		Value:    token,
+		Path:     "/api/videos/" + video.ID.String(),
+		Expires:  expires,
+		Secure:   strings.HasPrefix(cfg.siteURL, "https://"),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	type response struct {
+		UnlockToken string    `json:"unlock_token"`
+		ExpiresAt   time.Time `json:"expires_at"`
+	}
+	respondWithJSON(w, http.StatusOK, response{UnlockToken: token, ExpiresAt: expires.UTC()})
+}
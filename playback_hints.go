@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const eventPlaybackHint = "playback.hint"
+
+// playbackDeviceMaxSize is the largest rendition, by its shorter side, worth
+// sending to each kind of device. TVs take whatever is there.
+var playbackDeviceMaxSize = map[string]int{
+	"mobile":  720,
+	"tablet":  1080,
+	"desktop": 1080,
+	"tv":      0,
+}
+
+// renditionBitrateKbps estimates the bandwidth a rendition needs to play
+// without stalling, by its shorter side. Renditions are encoded at a
+// constant quality rather than bitrate, so these are generous.
+func renditionBitrateKbps(size int) int {
+	switch {
+	case size >= 1080:
+		return 5000
+	case size >= 720:
+		return 2800
+	default:
+		return 1200
+	}
+}
+
+// playbackBandwidthHeadroom is the share of the measured bandwidth a
+// rendition can use, leaving room for it to drop.
+const playbackBandwidthHeadroom = 0.8
+
+type playbackHint struct {
+	VideoID uuid.UUID `json:"video_id"`
+	// Variant is a rendition name, "source" or "sdr", and PlaybackURL the
+	// playback endpoint for it.
+	Variant     string `json:"variant"`
+	Width       int    `json:"width,omitempty"`
+	Height      int    `json:"height,omitempty"`
+	PlaybackURL string `json:"playback_url"`
+	Reason      string `json:"reason"`
+}
+
+// recommendPlayback picks what a player should play. Renditions are listed
+// largest first. Crops, which cut off part of the frame, and renditions
+// with burned-in captions are never picked; videos without any others play
+// the source, or its SDR copy on devices without HDR support.
+func recommendPlayback(video database.Video, renditions []database.Rendition, device string, bandwidthKbps int, hdr bool) playbackHint {
+	hint := playbackHint{VideoID: video.ID, Variant: "source"}
+	renditions = slices.DeleteFunc(slices.Clone(renditions), func(r database.Rendition) bool { return r.Crop || r.Captions != "" })
+	if len(renditions) == 0 {
+		hint.Reason = "the video has no renditions"
+		if !hdr && video.SDRVideoURL != nil {
+			hint.Variant = "sdr"
+			hint.Reason = "the device can't play HDR"
+		}
+		return hint
+	}
+
+	maxSize := playbackDeviceMaxSize[device]
+	budget := int(float64(bandwidthKbps) * playbackBandwidthHeadroom)
+	pick := renditions[len(renditions)-1]
+	hint.Reason = "no rendition fits the device and bandwidth, so the smallest is used"
+	for _, rendition := range renditions {
+		size := min(rendition.Width, rendition.Height)
+		if maxSize > 0 && size > maxSize {
+			continue
+		}
+		if bandwidthKbps > 0 && renditionBitrateKbps(size) > budget {
+			continue
+		}
+		pick = rendition
+		hint.Reason = "the largest rendition that fits the device and bandwidth"
+		break
+	}
+	hint.Variant = pick.Name
+	hint.Width = pick.Width
+	hint.Height = pick.Height
+	return hint
+}
+
+// handlerPlaybackHints recommends a rendition for a player from the
+// bandwidth it measured and the kind of device it runs on, and points it at
+// the playback URL for it. Each recommendation is emitted as a
+// playback.hint event for analytics. Players that don't report their
+// bandwidth get the largest rendition their device takes.
+func (cfg *apiConfig) handlerPlaybackHints(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		BandwidthKbps int    `json:"bandwidth_kbps"`
+		Device        string `json:"device"`
+		// HDR defaults to true, since most players can tone-map.
+		HDR *bool `json:"hdr"`
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Device == "" {
+		params.Device = "desktop"
+	}
+	if _, ok := playbackDeviceMaxSize[params.Device]; !ok {
+		respondWithError(w, http.StatusBadRequest, "Device must be mobile, tablet, desktop or tv", nil)
+		return
+	}
+	if params.BandwidthKbps < 0 {
+		respondWithError(w, http.StatusBadRequest, "Invalid bandwidth", nil)
+		return
+	}
+	hdr := params.HDR == nil || *params.HDR
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil || video.VideoURL == nil || !cfg.canView(r, video) {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	unlockToken, ok := cfg.unlocked(r, video)
+	if !ok {
+		msg, _ := cfg.passesPassphrase(r, video)
+		respondWithError(w, http.StatusUnauthorized, msg, nil)
+		return
+	}
+	renditions, err := cfg.db.GetRenditions(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get renditions", err)
+		return
+	}
+	target, err := cfg.videoTarget(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't locate video file", err)
+		return
+	}
+
+	hint := recommendPlayback(video, renditions, params.Device, params.BandwidthKbps, hdr)
+	q := url.Values{}
+	if hint.Variant != "source" {
+		q.Set("rendition", hint.Variant)
+	}
+	if unlockToken != "" {
+		q.Set(unlockParam, unlockToken)
+	}
+	hint.PlaybackURL = cfg.playbackPath(target, video, q)
+
+	cfg.emitEvent(eventPlaybackHint, video.ID, map[string]any{
+		"viewer_id":      cfg.viewerID(r),
+		"device":         params.Device,
+		"bandwidth_kbps": params.BandwidthKbps,
+		"hdr":            hdr,
+		"variant":        hint.Variant,
+		"reason":         hint.Reason,
+	})
+	respondWithJSON(w, http.StatusOK, hint)
+}
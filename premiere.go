@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// A premiere is a scheduled video that starts for everyone at once. Until
+// its publish_at, the embed player shows a countdown to it, timed by the
+// server's clock rather than each viewer's, and reloads into the player
+// when it's over, the moment the video becomes viewable. When it starts,
+// a video.premiere_started event is emitted, for integrations to open a
+// live chat alongside it.
+
+const (
+	// premiereCheckInterval is the longest the scheduler sleeps, so clock
+	// changes and premieres scheduled by other instances are picked up.
+	premiereCheckInterval = time.Minute
+	// premiereStartWindow is how late a premiere can still be announced,
+	// if the server was down when it was due. Later ones are only marked
+	// as started.
+	premiereStartWindow = 15 * time.Minute
+)
+
+const eventPremiereStarted = "video.premiere_started"
+
+// premiereScheduler starts premieres when they're due.
+type premiereScheduler struct {
+	wake chan struct{}
+}
+
+func newPremiereScheduler() *premiereScheduler {
+	return &premiereScheduler{wake: make(chan struct{}, 1)}
+}
+
+// notify wakes the scheduler after a schedule changed, if it isn't awake
+// already.
+func (p *premiereScheduler) notify() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// runPremiereScheduler starts premieres as they come due, until ctx is
+// cancelled.
+func (cfg *apiConfig) runPremiereScheduler(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		case <-cfg.premieres.wake:
+		}
+		timer.Reset(cfg.startPremieres())
+	}
+}
+
+// startPremieres starts the premieres that are due and returns how long
+// to wait before the next one.
+func (cfg *apiConfig) startPremieres() time.Duration {
+	videos, err := cfg.db.GetPendingPremieres()
+	if err != nil {
+		log.Printf("Couldn't get pending premieres: %v", err)
+		return premiereCheckInterval
+	}
+	now := cfg.clock.Now()
+	for _, video := range videos {
+		if !video.Published(now) {
+			return min(video.PublishAt.Sub(now), premiereCheckInterval)
+		}
+		started, err := cfg.db.StartPremiere(video.ID, now)
+		if err != nil {
+			log.Printf("Couldn't start premiere of video %s: %v", video.ID, err)
+			continue
+		}
+		if !started {
+			continue
+		}
+		if video.ModerationHold || video.Visibility == database.VisibilityPrivate {
+			// Only its owner can watch it.
+			continue
+		}
+		if late := now.Sub(*video.PublishAt); late > premiereStartWindow {
+			log.Printf("Premiere of video %s was due %v ago, not announcing it", video.ID, late.Round(time.Second))
+			continue
+		}
+		cfg.emitEvent(eventPremiereStarted, video.ID, map[string]any{
+			"publish_at": video.PublishAt,
+			"embed_url":  cfg.embedURL(video),
+		})
+	}
+	return premiereCheckInterval
+}
+
+// embedURL is the address of video's embed player, with its share key if
+// it's unlisted.
+func (cfg *apiConfig) embedURL(video database.Video) string {
+	u := cfg.siteURL + "/embed/" + video.ID.String()
+	if video.Visibility == database.VisibilityUnlisted {
+		u += "?" + url.Values{shareKeyParam: {video.ShareKey}}.Encode()
+	}
+	return u
+}
+
+// premiereCountdown reports whether the requester should get the countdown
+// to video's premiere: it hasn't started, and they'll be able to view the
+// video once it has.
+func (cfg *apiConfig) premiereCountdown(r *http.Request, video database.Video) bool {
+	if !video.Premiere || video.Published(cfg.clock.Now()) || video.ModerationHold {
+		return false
+	}
+	switch video.Visibility {
+	case database.VisibilityPublic:
+		return true
+	case database.VisibilityUnlisted:
+		return hasShareKey(r, video)
+	}
+	return false
+}
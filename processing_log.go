@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ffmpeg"
+	"github.com/google/uuid"
+)
+
+// maxLogOutput caps how much ffmpeg stderr is kept per command. The tail is
+// kept since that's where ffmpeg reports what went wrong.
+const maxLogOutput = 16 << 10
+
+// processingLog collects the steps of one processing attempt. It is carried
+// in the request context so that ffmpeg runs and S3 requests deep in the
+// pipeline can report to it.
+type processingLog struct {
+	mu    sync.Mutex
+	entry database.ProcessingLog
+
+	// release gives back the upload's place in the processing queue, from
+	// admitUpload, when the log is saved.
+	release func()
+}
+
+type processingLogKey struct{}
+
+// newProcessingLog starts the log of an upload from source, which counts
+// as in flight until the log is saved.
+func newProcessingLog(videoID uuid.UUID, source string) *processingLog {
+	telemetry.uploadsInFlight.Inc(source)
+	return &processingLog{entry: database.ProcessingLog{
+		ID:        uuid.New(),
+		VideoID:   videoID,
+		Source:    source,
+		StartedAt: time.Now().UTC(),
+		Entries:   []database.ProcessingLogEntry{},
+	}}
+}
+
+// context returns ctx with the log attached, including as the ffmpeg
+// command observer. The log takes the request ID of the first context it's
+// attached to.
+func (l *processingLog) context(ctx context.Context) context.Context {
+	l.mu.Lock()
+	if l.entry.RequestID == "" {
+		l.entry.RequestID = contextRequestID(ctx)
+	}
+	l.mu.Unlock()
+	ctx = context.WithValue(ctx, processingLogKey{}, l)
+	return ffmpeg.WithObserver(ctx, func(args []string, stderr []byte, elapsed time.Duration, err error) {
+		stage := "ffmpeg"
+		if len(args) > 0 && strings.HasSuffix(args[0], "ffprobe") {
+			stage = "ffprobe"
+		}
+		if len(stderr) > maxLogOutput {
+			stderr = stderr[len(stderr)-maxLogOutput:]
+		}
+		l.add(stage, strings.Join(args, " "), time.Now().Add(-elapsed), err, string(stderr))
+	})
+}
+
+func (l *processingLog) add(stage, detail string, start time.Time, err error, output string) {
+	entry := database.ProcessingLogEntry{
+		Stage:      stage,
+		Detail:     detail,
+		StartedAt:  start.UTC(),
+		DurationMS: time.Since(start).Milliseconds(),
+		Output:     output,
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	l.mu.Lock()
+	l.entry.Entries = append(l.entry.Entries, entry)
+	l.mu.Unlock()
+}
+
+// requestID is the ID of the request the upload came in with, or "" if it
+// didn't come in over HTTP.
+func (l *processingLog) requestID() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.entry.RequestID
+}
+
+// processingSource is the source of the upload whose processing log ctx
+// carries, or "unknown".
+func processingSource(ctx context.Context) string {
+	if l, ok := ctx.Value(processingLogKey{}).(*processingLog); ok {
+		return l.entry.Source
+	}
+	return "unknown"
+}
+
+// logStep records a step on the processing log in ctx, if there is one.
+func logStep(ctx context.Context, stage, detail string, start time.Time, err error) {
+	if l, ok := ctx.Value(processingLogKey{}).(*processingLog); ok {
+		l.add(stage, detail, start, err, "")
+	}
+}
+
+// saveProcessingLog finishes the log and stores it. failure is empty if the
+// attempt succeeded. Logs are diagnostics, so a failure to save one is only
+// logged.
+func (cfg *apiConfig) saveProcessingLog(l *processingLog, failure string) {
+	l.mu.Lock()
+	entry := l.entry
+	entry.Entries = append([]database.ProcessingLogEntry{}, l.entry.Entries...)
+	l.mu.Unlock()
+
+	entry.FinishedAt = time.Now().UTC()
+	entry.Status = "done"
+	outcome, level := "ok", slog.LevelInfo
+	if failure != "" {
+		entry.Status = "failed"
+		entry.Error = failure
+		outcome, level = "error", slog.LevelWarn
+	}
+	if l.release != nil {
+		l.release()
+	}
+	elapsed := entry.FinishedAt.Sub(entry.StartedAt)
+	telemetry.uploadsInFlight.Dec(entry.Source)
+	telemetry.uploads.Inc(entry.Source, outcome)
+	telemetry.processingDuration.Observe(elapsed.Seconds(), entry.Source, outcome)
+	cfg.slo.recordUpload(cfg.clock.Now(), failure == "", elapsed)
+	cfg.logger.Log(withRequestID(context.Background(), entry.RequestID), level, "processing finished",
+		slog.String("video_id", entry.VideoID.String()),
+		slog.String("source", entry.Source),
+		slog.String("status", entry.Status),
+		slog.String("error", entry.Error),
+		slog.Int64("duration_ms", elapsed.Milliseconds()),
+	)
+	if err := cfg.db.SaveProcessingLog(entry); err != nil {
+		log.Printf("Couldn't save processing log for video %s: %v", entry.VideoID, err)
+	}
+
+	video, err := cfg.db.GetVideo(entry.VideoID)
+	if err != nil || video.ID == uuid.Nil {
+		return
+	}
+	kind, detail := activityUploadDone, entry.Source
+	if failure != "" {
+		kind, detail = activityUploadFailed, entry.Source+": "+failure
+	}
+	cfg.recordActivity(video.UserID, kind, &video.ID, nil, detail)
+	cfg.ingestItemProcessed(video.ID, failure)
+	if failure == "" {
+		cfg.emitEvent(eventVideoUpdated, video.ID, map[string]any{"source": entry.Source})
+		cfg.emitEvent(eventVideoReady, video.ID, map[string]any{"source": entry.Source, "processing_log_id": entry.ID})
+		cfg.prefetchVideo(video)
+	} else {
+		cfg.emitEvent(eventVideoProcessingFailed, video.ID, map[string]any{"source": entry.Source, "processing_log_id": entry.ID, "error": failure})
+	}
+	cfg.notifyProcessing(video, failure)
+}
+
+// errorRecorder remembers the status and body of error responses so a
+// handler's processing log can record why it failed.
+type errorRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *errorRecorder) WriteHeader(code int) {
+	rec.status = code
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *errorRecorder) Write(b []byte) (int, error) {
+	if rec.status >= http.StatusBadRequest && rec.body.Len() < maxLogOutput {
+		rec.body.Write(b)
+	}
+	return rec.ResponseWriter.Write(b)
+}
+
+func (rec *errorRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// failure describes the error response written, or returns "" if there was
+// none.
+func (rec *errorRecorder) failure() string {
+	if rec.status < http.StatusBadRequest {
+		return ""
+	}
+	return fmt.Sprintf("%d %s: %s", rec.status, http.StatusText(rec.status), rec.message())
+}
+
+// message is the error message of the error response written, or "" if
+// there was none.
+func (rec *errorRecorder) message() string {
+	if rec.status < http.StatusBadRequest {
+		return ""
+	}
+	var body struct {
+		Error string `json:"error"`
+	}
+	msg := strings.TrimSpace(rec.body.String())
+	if json.Unmarshal(rec.body.Bytes(), &body) == nil && body.Error != "" {
+		msg = body.Error
+	}
+	return msg
+}
+
+func (cfg *apiConfig) handlerAdminProcessingLogs(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	logs, err := cfg.db.GetProcessingLogs(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get processing logs", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, logs)
+}
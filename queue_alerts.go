@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The processing queue raises an alert when QUEUE_ALERT_DEPTH jobs are
+// waiting for a worker, or the job that has been in the queue longest,
+// waiting or running, has been in it for QUEUE_ALERT_AGE: a pipeline
+// that's falling behind or stuck, before uploaders notice. The alert is
+// sent when it starts and again when it clears, to a webhook, Slack and
+// by email, whichever are configured, and /readyz reports it while it
+// lasts.
+
+// queueAlertCheckInterval is how often the queue is checked.
+const queueAlertCheckInterval = 15 * time.Second
+
+// queueAlertConfig is what raises a queue alert, and where it's sent. A
+// zero threshold is off.
+type queueAlertConfig struct {
+	depth      int
+	age        time.Duration
+	webhookURL string
+	slackURL   string
+	email      *alertMailer
+}
+
+// alertMailer sends alerts by email through an SMTP server.
+type alertMailer struct {
+	addr string
+	auth smtp.Auth
+	from string
+	to   []string
+}
+
+// queueAlerts tracks whether the queue alert is firing.
+type queueAlerts struct {
+	config queueAlertConfig
+	client *http.Client
+
+	mu    sync.Mutex
+	state queueAlertState
+}
+
+// queueAlertState is the queue alert as of the latest check.
+type queueAlertState struct {
+	Firing bool `json:"firing"`
+	// Since is when the alert started firing, or last cleared.
+	Since   *time.Time `json:"since,omitempty"`
+	Reasons []string   `json:"reasons,omitempty"`
+}
+
+func newQueueAlerts(config queueAlertConfig) *queueAlerts {
+	return &queueAlerts{config: config, client: &http.Client{Timeout: webhookTimeout}}
+}
+
+// current returns the alert's state, which is never firing if there are no
+// thresholds.
+func (a *queueAlerts) current() queueAlertState {
+	if a == nil {
+		return queueAlertState{}
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.state
+}
+
+// queueAlert is the body of the alert sent to QUEUE_ALERT_WEBHOOK_URL.
+type queueAlert struct {
+	Alert               string    `json:"alert"`
+	Status              string    `json:"status"`
+	OccurredAt          time.Time `json:"occurred_at"`
+	Reasons             []string  `json:"reasons,omitempty"`
+	QueueDepth          int       `json:"queue_depth"`
+	OldestJobAgeSeconds int       `json:"oldest_job_age_seconds"`
+	DepthThreshold      int       `json:"depth_threshold,omitempty"`
+	AgeThresholdSeconds int       `json:"age_threshold_seconds,omitempty"`
+}
+
+func (cfg *apiConfig) runQueueAlerts(ctx context.Context) {
+	ticker := time.NewTicker(queueAlertCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			cfg.checkQueueAlerts()
+		}
+	}
+}
+
+// checkQueueAlerts compares the queue with the thresholds, sending the
+// alert from the background if it started or cleared.
+func (cfg *apiConfig) checkQueueAlerts() {
+	a := cfg.queueAlerts
+	depth, oldest := cfg.jobs.Depth(), cfg.jobs.Oldest()
+	reasons := []string{}
+	if a.config.depth > 0 && depth >= a.config.depth {
+		reasons = append(reasons, fmt.Sprintf("%d jobs are waiting for a worker (alert at %d)", depth, a.config.depth))
+	}
+	if a.config.age > 0 && oldest >= a.config.age {
+		reasons = append(reasons, fmt.Sprintf("the oldest job has been in the queue for %v (alert at %v)", oldest.Round(time.Second), a.config.age))
+	}
+
+	now := cfg.clock.Now().UTC()
+	firing := len(reasons) > 0
+	a.mu.Lock()
+	changed := firing != a.state.Firing
+	if changed {
+		a.state.Since = &now
+	}
+	a.state.Firing = firing
+	a.state.Reasons = reasons
+	a.mu.Unlock()
+	if !changed {
+		return
+	}
+
+	alert := queueAlert{
+		Alert:               "processing_queue",
+		Status:              "resolved",
+		OccurredAt:          now,
+		Reasons:             reasons,
+		QueueDepth:          depth,
+		OldestJobAgeSeconds: int(oldest.Seconds()),
+		DepthThreshold:      a.config.depth,
+		AgeThresholdSeconds: int(a.config.age.Seconds()),
+	}
+	if firing {
+		alert.Status = "firing"
+		log.Printf("Processing queue alert: %s", strings.Join(reasons, "; "))
+	} else {
+		log.Printf("Processing queue alert cleared: %d jobs waiting, oldest in the queue for %v", depth, oldest.Round(time.Second))
+	}
+	cfg.background.run(func() { a.send(alert) })
+}
+
+// send delivers the alert everywhere it's configured to go. Each delivery
+// is tried like a notification, and a failure is only logged.
+func (a *queueAlerts) send(alert queueAlert) {
+	summary := "Tubely processing queue is back to normal"
+	if alert.Status == "firing" {
+		summary = "Tubely processing queue is falling behind: " + strings.Join(alert.Reasons, "; ")
+	}
+	if a.config.webhookURL != "" {
+		body, err := json.Marshal(alert)
+		if err == nil {
+			err = a.post(a.config.webhookURL, body)
+		}
+		if err != nil {
+			log.Printf("Couldn't send processing queue alert to the webhook: %v", err)
+		}
+	}
+	if a.config.slackURL != "" {
+		icon := ":white_check_mark:"
+		if alert.Status == "firing" {
+			icon = ":rotating_light:"
+		}
+		body, err := json.Marshal(map[string]any{"text": icon + " " + summary})
+		if err == nil {
+			err = a.post(a.config.slackURL, body)
+		}
+		if err != nil {
+			log.Printf("Couldn't send processing queue alert to Slack: %v", err)
+		}
+	}
+	if m := a.config.email; m != nil {
+		if err := m.send(alert); err != nil {
+			log.Printf("Couldn't email processing queue alert: %v", err)
+		}
+	}
+}
+
+// post sends body to an incoming webhook, retrying like notifications.
+func (a *queueAlerts) post(webhookURL string, body []byte) error {
+	delay := notificationRetryDelay
+	for attempt := 1; ; attempt++ {
+		retryAfter, err := postIncomingWebhook(a.client, webhookURL, body)
+		if err == nil || attempt == notificationAttempts {
+			return err
+		}
+		if retryAfter > 0 {
+			delay = min(retryAfter, notificationMaxRetryAfter)
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// send emails the alert.
+func (m *alertMailer) send(alert queueAlert) error {
+	subject := "Tubely processing queue is back to normal"
+	if alert.Status == "firing" {
+		subject = "Tubely processing queue is falling behind"
+	}
+	var body strings.Builder
+	fmt.Fprintf(&body, "From: %s\r\n", m.from)
+	fmt.Fprintf(&body, "To: %s\r\n", strings.Join(m.to, ", "))
+	fmt.Fprintf(&body, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&body, "Date: %s\r\n", alert.OccurredAt.Format(time.RFC1123Z))
+	body.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&body, "Status: %s\r\n", alert.Status)
+	for _, reason := range alert.Reasons {
+		fmt.Fprintf(&body, "- %s\r\n", reason)
+	}
+	fmt.Fprintf(&body, "Jobs waiting for a worker: %d\r\n", alert.QueueDepth)
+	fmt.Fprintf(&body, "Oldest job in the queue: %v\r\n", time.Duration(alert.OldestJobAgeSeconds)*time.Second)
+	return smtp.SendMail(m.addr, m.auth, m.from, m.to, []byte(body.String()))
+}
+
+// watchQueueAlerts adds a gauge for whether the queue alert is firing.
+func (m *serverMetrics) watchQueueAlerts(a *queueAlerts) {
+	m.registry.GaugeFunc("tubely_processing_queue_alert", "Whether the processing queue depth or oldest job age alert is firing.", func() float64 {
+		return boolGauge(a.current().Firing)
+	})
+}
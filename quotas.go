@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/i18n"
+	"github.com/google/uuid"
+)
+
+const storageQuotaExceededMessage = "Storage quota exceeded"
+
+// storageQuota returns the most a user can store, or 0 if there's no
+// limit. A quota set for the user replaces the configured default.
+func (cfg *apiConfig) storageQuota(userID uuid.UUID) (int64, error) {
+	quota, ok, err := cfg.db.GetStorageQuota(userID)
+	if err != nil || ok {
+		return quota, err
+	}
+	return cfg.defaultStorageQuota, nil
+}
+
+// quotaUsage returns the user's quota, or 0 if there's no limit, how
+// much they use, and how much of that the files of the given kind for
+// videoID take up. Usage isn't looked up without a quota.
+func (cfg *apiConfig) quotaUsage(userID, videoID uuid.UUID, kind string) (quota, used, replaced int64, err error) {
+	quota, err = cfg.storageQuota(userID)
+	if err != nil || quota == 0 {
+		return quota, 0, 0, err
+	}
+	used, err = cfg.db.GetUserStorageUsed(userID)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	replaced, err = cfg.db.GetStorageUsage(videoID, kind)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	return quota, used, replaced, nil
+}
+
+// requireStorageQuota checks that storing incoming bytes as the files of
+// the given kind for videoID keeps the user within their quota. The files
+// they replace don't count against it. If it returns false, an error
+// response has been written.
+func (cfg *apiConfig) requireStorageQuota(w http.ResponseWriter, userID, videoID uuid.UUID, kind string, incoming int64) bool {
+	quota, used, replaced, err := cfg.quotaUsage(userID, videoID, kind)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get storage usage", err)
+		return false
+	}
+	if quota == 0 || used-replaced+incoming <= quota {
+		return true
+	}
+
+	type response struct {
+		Error      string `json:"error"`
+		Code       string `json:"code"`
+		UsedBytes  int64  `json:"used_bytes"`
+		QuotaBytes int64  `json:"quota_bytes"`
+	}
+	lang := w.Header().Get("Content-Language")
+	if lang == "" {
+		lang = i18n.Default
+	}
+	respondWithJSON(w, http.StatusRequestEntityTooLarge, response{
+		Error:      i18n.Translate(lang, storageQuotaExceededMessage),
+		Code:       i18n.Code(storageQuotaExceededMessage, http.StatusRequestEntityTooLarge),
+		UsedBytes:  used,
+		QuotaBytes: quota,
+	})
+	return false
+}
+
+// recordStorageUsage records that videoID's files of the given kind now
+// take up bytes, restoring what was recorded before if cleanup runs
+// without being committed. If ok is false, an error response has been
+// written.
+func (cfg *apiConfig) recordStorageUsage(w http.ResponseWriter, cleanup *cleanupStack, userID, videoID uuid.UUID, kind string, bytes int64) bool {
+	previous, err := cfg.db.GetStorageUsage(videoID, kind)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update storage usage", err)
+		return false
+	}
+	if err := cfg.db.SetStorageUsage(userID, videoID, kind, bytes); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update storage usage", err)
+		return false
+	}
+	cleanup.onError("restore storage usage", func() error {
+		return cfg.db.SetStorageUsage(userID, videoID, kind, previous)
+	})
+	return true
+}
+
+// storedSize adds up the sizes of the files and directory trees at paths,
+// skipping empty paths.
+func storedSize(paths ...string) (int64, error) {
+	var total int64
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() {
+				total += info.Size()
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return total, nil
+}
+
+// storageUsage is how much a user stores and how much more their quota
+// allows. QuotaBytes and RemainingBytes are nil when there's no quota.
+type storageUsage struct {
+	UsedBytes      int64  `json:"used_bytes"`
+	QuotaBytes     *int64 `json:"quota_bytes,omitempty"`
+	RemainingBytes *int64 `json:"remaining_bytes,omitempty"`
+}
+
+func (cfg *apiConfig) storageUsage(userID uuid.UUID) (storageUsage, error) {
+	used, err := cfg.db.GetUserStorageUsed(userID)
+	if err != nil {
+		return storageUsage{}, err
+	}
+	quota, err := cfg.storageQuota(userID)
+	if err != nil {
+		return storageUsage{}, err
+	}
+	usage := storageUsage{UsedBytes: used}
+	if quota > 0 {
+		remaining := max(quota-used, 0)
+		usage.QuotaBytes, usage.RemainingBytes = &quota, &remaining
+	}
+	return usage, nil
+}
+
+func (cfg *apiConfig) handlerStorageUsage(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.requireUser(w, r)
+	if !ok {
+		return
+	}
+	usage, err := cfg.storageUsage(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get storage usage", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, usage)
+}
+
+// handlerAdminSetUserQuota sets the storage quota of one user, overriding
+// the default. A null quota_bytes puts them back on the default, and 0
+// lifts the limit.
+func (cfg *apiConfig) handlerAdminSetUserQuota(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		QuotaBytes *int64 `json:"quota_bytes"`
+	}
+
+	adminID, ok := cfg.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.QuotaBytes != nil && *params.QuotaBytes < 0 {
+		respondWithError(w, http.StatusBadRequest, "Invalid storage quota", nil)
+		return
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+
+	if err := cfg.db.SetStorageQuota(userID, params.QuotaBytes); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update user", err)
+		return
+	}
+	detail := "default"
+	if params.QuotaBytes != nil {
+		detail = strconv.FormatInt(*params.QuotaBytes, 10)
+	}
+	cfg.recordActivity(userID, activityQuotaChanged, nil, &adminID, detail)
+
+	usage, err := cfg.storageUsage(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get storage usage", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, usage)
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/i18n"
+	"github.com/google/uuid"
+)
+
+const (
+	rateLimitedMessage      = "Too many requests, please slow down"
+	dailyUploadLimitMessage = "Daily upload limit reached"
+)
+
+// rateLimitWindow is the window request rates are given over: a limit of
+// 30 is 30 requests a minute, any of which may come at once.
+const rateLimitWindow = time.Minute
+
+// rateLimitPruneInterval is how often buckets that have refilled, and so
+// limit nothing, are dropped.
+const rateLimitPruneInterval = 10 * time.Minute
+
+// rateLimit is a token bucket per user and per client IP for a class of
+// routes. Each bucket holds up to the limit's requests and refills over
+// rateLimitWindow; a request needs a token from both of its buckets. A
+// limit of 0 leaves that side unlimited.
+type rateLimit struct {
+	class   string
+	perUser int
+	perIP   int
+
+	mu         sync.Mutex
+	buckets    map[string]*tokenBucket
+	lastPruned time.Time
+}
+
+type tokenBucket struct {
+	limit   int
+	tokens  float64
+	updated time.Time
+}
+
+// newRateLimit returns nil, which limits nothing, if both limits are 0.
+func newRateLimit(class string, perUser, perIP int) *rateLimit {
+	if perUser == 0 && perIP == 0 {
+		return nil
+	}
+	return &rateLimit{
+		class:   class,
+		perUser: perUser,
+		perIP:   perIP,
+		buckets: map[string]*tokenBucket{},
+	}
+}
+
+// refill tops the bucket up for the time since it was last updated.
+func (b *tokenBucket) refill(now time.Time) {
+	elapsed := now.Sub(b.updated)
+	b.updated = now
+	if elapsed > 0 {
+		b.tokens = min(float64(b.limit), b.tokens+float64(b.limit)*elapsed.Seconds()/rateLimitWindow.Seconds())
+	}
+}
+
+// wait is how long until the bucket has n tokens.
+func (b *tokenBucket) wait(n float64) time.Duration {
+	if b.tokens >= n {
+		return 0
+	}
+	return time.Duration((n - b.tokens) / float64(b.limit) * float64(rateLimitWindow))
+}
+
+// rateLimitState is what the RateLimit headers report: the bucket closest to
+// running out.
+type rateLimitState struct {
+	limit     int
+	remaining int
+	reset     time.Duration
+	// retryAfter is when the request could go through, if it was refused.
+	retryAfter time.Duration
+}
+
+// take takes a token from each of the buckets under keys, or from none if
+// any is empty. The keys' limits are given alongside them.
+func (l *rateLimit) take(now time.Time, keys []string, limits []int) (rateLimitState, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.prune(now)
+
+	buckets := make([]*tokenBucket, len(keys))
+	for i, key := range keys {
+		b := l.buckets[key]
+		if b == nil {
+			b = &tokenBucket{limit: limits[i], tokens: float64(limits[i]), updated: now}
+			l.buckets[key] = b
+		}
+		b.refill(now)
+		buckets[i] = b
+	}
+
+	var state rateLimitState
+	ok := true
+	for _, b := range buckets {
+		if b.tokens < 1 {
+			ok = false
+			state.retryAfter = max(state.retryAfter, b.wait(1))
+		}
+	}
+	for i, b := range buckets {
+		if ok {
+			b.tokens--
+		}
+		remaining := int(math.Floor(max(b.tokens, 0)))
+		if i == 0 || remaining < state.remaining {
+			state.limit = b.limit
+			state.remaining = remaining
+			state.reset = b.wait(float64(b.limit))
+		}
+	}
+	return state, ok
+}
+
+// prune drops the buckets that have refilled since they were last used, as
+// a new bucket would start out the same. l.mu must be held.
+func (l *rateLimit) prune(now time.Time) {
+	if now.Sub(l.lastPruned) < rateLimitPruneInterval {
+		return
+	}
+	l.lastPruned = now
+	for key, b := range l.buckets {
+		if now.Sub(b.updated) >= rateLimitWindow {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// rateLimitStatus is how a rate limit applies to one requester: the limits
+// on each side that has one, and how many requests they have left of them.
+type rateLimitStatus struct {
+	RouteClass    string           `json:"route_class"`
+	WindowSeconds int              `json:"window_seconds"`
+	PerUser       *rateLimitBucket `json:"per_user,omitempty"`
+	PerIP         *rateLimitBucket `json:"per_ip,omitempty"`
+}
+
+type rateLimitBucket struct {
+	Limit     int `json:"limit"`
+	Remaining int `json:"remaining"`
+}
+
+// status reports what's left of the buckets of userID and ip, without
+// taking from them. A nil userID leaves the per-user limit out.
+func (l *rateLimit) status(now time.Time, userID *uuid.UUID, ip string) rateLimitStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	remaining := func(key string, limit int) *rateLimitBucket {
+		bucket := rateLimitBucket{Limit: limit, Remaining: limit}
+		if b := l.buckets[key]; b != nil {
+			refilled := *b
+			refilled.refill(now)
+			bucket.Remaining = int(math.Floor(max(refilled.tokens, 0)))
+		}
+		return &bucket
+	}
+	status := rateLimitStatus{RouteClass: l.class, WindowSeconds: int(rateLimitWindow.Seconds())}
+	if l.perUser > 0 && userID != nil {
+		status.PerUser = remaining("user:"+userID.String(), l.perUser)
+	}
+	if l.perIP > 0 {
+		status.PerIP = remaining("ip:"+ip, l.perIP)
+	}
+	return status
+}
+
+// rateLimitMiddleware refuses requests over l's limits with a 429, before
+// they're read. Every response carries RateLimit-Limit, RateLimit-Remaining
+// and RateLimit-Reset for the bucket closest to running out, and refusals a
+// Retry-After.
+func (cfg *apiConfig) rateLimitMiddleware(l *rateLimit, next http.HandlerFunc) http.HandlerFunc {
+	if l == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		keys := []string{}
+		limits := []int{}
+		if l.perUser > 0 {
+			if userID, ok := cfg.requestUserID(r); ok {
+				keys = append(keys, "user:"+userID.String())
+				limits = append(limits, l.perUser)
+			}
+		}
+		if l.perIP > 0 {
+			keys = append(keys, "ip:"+cfg.clientIP(r))
+			limits = append(limits, l.perIP)
+		}
+		if len(keys) == 0 {
+			next(w, r)
+			return
+		}
+
+		state, ok := l.take(cfg.clock.Now(), keys, limits)
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(state.limit))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(state.remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(state.reset)))
+		w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", state.limit, int(rateLimitWindow.Seconds())))
+		if ok {
+			next(w, r)
+			return
+		}
+
+		type response struct {
+			Error             string `json:"error"`
+			Code              string `json:"code"`
+			RouteClass        string `json:"route_class"`
+			RetryAfterSeconds int    `json:"retry_after_seconds"`
+		}
+		seconds := max(1, ceilSeconds(state.retryAfter))
+		lang := w.Header().Get("Content-Language")
+		if lang == "" {
+			lang = i18n.Default
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+		respondWithJSON(w, http.StatusTooManyRequests, response{
+			Error:             i18n.Translate(lang, rateLimitedMessage),
+			Code:              i18n.Code(rateLimitedMessage, http.StatusTooManyRequests),
+			RouteClass:        l.class,
+			RetryAfterSeconds: seconds,
+		})
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
+
+// dailyUploadLimit caps how many uploads each user can make a day, UTC.
+// Failed uploads are given back, so retrying one doesn't use up the day.
+// Counts are kept in memory, per server.
+type dailyUploadLimit struct {
+	limit int
+
+	mu     sync.Mutex
+	day    string
+	counts map[uuid.UUID]int
+}
+
+// newDailyUploadLimit returns nil, which limits nothing, if limit is 0.
+func newDailyUploadLimit(limit int) *dailyUploadLimit {
+	if limit == 0 {
+		return nil
+	}
+	return &dailyUploadLimit{limit: limit, counts: map[uuid.UUID]int{}}
+}
+
+// reserve counts an upload for the user today, unless they've reached the
+// limit. It returns the day it was counted on, to give it back to.
+func (l *dailyUploadLimit) reserve(userID uuid.UUID, now time.Time) (day string, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	day = now.UTC().Format(time.DateOnly)
+	if day != l.day {
+		l.day = day
+		l.counts = map[uuid.UUID]int{}
+	}
+	if l.counts[userID] >= l.limit {
+		return day, false
+	}
+	l.counts[userID]++
+	return day, true
+}
+
+// used returns how many uploads the user has made today.
+func (l *dailyUploadLimit) used(userID uuid.UUID, now time.Time) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.UTC().Format(time.DateOnly) != l.day {
+		return 0
+	}
+	return l.counts[userID]
+}
+
+func (l *dailyUploadLimit) release(userID uuid.UUID, day string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if day == l.day && l.counts[userID] > 0 {
+		l.counts[userID]--
+	}
+}
+
+// dailyUploadMiddleware refuses an upload with a 429 once its user has
+// made the day's uploads, before it's read. Uploads without a user pass
+// through for the handler to reject.
+func (cfg *apiConfig) dailyUploadMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	l := cfg.dailyUploadLimit
+	if l == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := cfg.requestUserID(r)
+		if !ok {
+			next(w, r)
+			return
+		}
+		now := cfg.clock.Now()
+		day, ok := l.reserve(userID, now)
+		if !ok {
+			type response struct {
+				Error             string    `json:"error"`
+				Code              string    `json:"code"`
+				Limit             int       `json:"limit"`
+				ResetAt           time.Time `json:"reset_at"`
+				RetryAfterSeconds int       `json:"retry_after_seconds"`
+			}
+			resetAt := now.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+			seconds := max(1, ceilSeconds(resetAt.Sub(now)))
+			lang := w.Header().Get("Content-Language")
+			if lang == "" {
+				lang = i18n.Default
+			}
+			w.Header().Set("Retry-After", strconv.Itoa(seconds))
+			respondWithJSON(w, http.StatusTooManyRequests, response{
+				Error:             i18n.Translate(lang, dailyUploadLimitMessage),
+				Code:              i18n.Code(dailyUploadLimitMessage, http.StatusTooManyRequests),
+				Limit:             l.limit,
+				ResetAt:           resetAt,
+				RetryAfterSeconds: seconds,
+			})
+			return
+		}
+
+		sw := &statusWriter{ResponseWriter: w}
+		next(sw, r)
+		if sw.status >= http.StatusBadRequest {
+			l.release(userID, day)
+		}
+	}
+}
+
+// requestUserID returns the user a request is authenticated as, by JWT or
+// API key, without otherwise using the key. It's false if the request
+// carries neither, or they're invalid.
+func (cfg *apiConfig) requestUserID(r *http.Request) (uuid.UUID, bool) {
+	if auth.HasAPIKey(r.Header) {
+		key, err := auth.GetAPIKey(r.Header)
+		if err != nil {
+			return uuid.Nil, false
+		}
+		apiKey, err := cfg.db.GetAPIKeyByHash(cfg.apiKeyHash(key))
+		if err != nil || apiKey == nil || apiKey.RevokedAt != nil {
+			return uuid.Nil, false
+		}
+		return apiKey.UserID, true
+	}
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		return uuid.Nil, false
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		return uuid.Nil, false
+	}
+	return userID, true
+}
+
+// clientIP returns the address a request came from. With
+// TRUST_FORWARDED_FOR, it's the last address in X-Forwarded-For, the one
+// the proxy in front of the server saw, since the ones before it are
+// whatever the client sent.
+func (cfg *apiConfig) clientIP(r *http.Request) string {
+	if cfg.trustForwardedFor {
+		if values := r.Header.Values("X-Forwarded-For"); len(values) > 0 {
+			hops := strings.Split(values[len(values)-1], ",")
+			if ip := strings.TrimSpace(hops[len(hops)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
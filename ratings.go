@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// contentRatings maps each rating to whether it always restricts playback
+// by age. Owners can age-restrict any rating.
+var contentRatings = map[string]bool{
+	"general": false,
+	"teen":    false,
+	"mature":  true,
+	"adult":   true,
+}
+
+const (
+	// ageGateConfirm lets viewers in after they confirm their age on an
+	// interstitial, which the player signals with ?age_confirmed=true.
+	ageGateConfirm = "confirm"
+	// ageGateVerified requires the age_verified claim in the viewer's
+	// access token.
+	ageGateVerified = "verified"
+)
+
+// passesAgeGate reports whether the request may play the video. Owners can
+// always play their own videos.
+func (cfg *apiConfig) passesAgeGate(r *http.Request, video database.Video) (msg string, ok bool) {
+	if !video.AgeRestricted {
+		return "", true
+	}
+	var identity auth.Identity
+	if auth.HasDeviceToken(r.Header) {
+		// Device tokens don't carry claims, so it goes by the user record.
+		if user, err := cfg.deviceTokenUser(r); err == nil {
+			identity = auth.Identity{UserID: user.ID, AgeVerified: user.AgeVerified}
+		}
+	} else if token, err := auth.GetBearerToken(r.Header); err == nil {
+		identity, _ = auth.ValidateIdentityJWT(token, cfg.jwtSecret)
+	}
+	if identity.UserID == video.UserID || identity.AgeVerified {
+		return "", true
+	}
+	if cfg.ageGate == ageGateConfirm {
+		if r.URL.Query().Get("age_confirmed") == "true" {
+			return "", true
+		}
+		return "This video is age-restricted. Confirm your age to watch it", false
+	}
+	return "Age verification is required to watch this video", false
+}
+
+// handlerVideoRatingSet sets a video's content rating. Owners and admins can
+// set it; once an admin has, only admins can change it.
+func (cfg *apiConfig) handlerVideoRatingSet(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		ContentRating string `json:"content_rating"`
+		AgeRestricted bool   `json:"age_restricted"`
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	restricts, ok := contentRatings[params.ContentRating]
+	if !ok && params.ContentRating != "" {
+		respondWithError(w, http.StatusBadRequest, "Unknown content rating", nil)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	setBy := "owner"
+	if cfg.isAdmin(user) {
+		setBy = "moderator"
+	} else if video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't update this video", nil)
+		return
+	} else if video.RatingSetBy == "moderator" {
+		respondWithError(w, http.StatusForbidden, "This video's rating was set by a moderator", nil)
+		return
+	}
+
+	video.Rating = database.Rating{
+		ContentRating: params.ContentRating,
+		AgeRestricted: params.AgeRestricted || restricts,
+		RatingSetBy:   setBy,
+	}
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+	cfg.emitEvent(eventVideoUpdated, video.ID, nil)
+	video, err = cfg.dbVideoToSignedVideo(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URLs", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, video)
+}
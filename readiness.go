@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+)
+
+// readinessTimeout bounds the checks of a readiness probe.
+const readinessTimeout = 2 * time.Second
+
+// handlerReadyz is the readiness probe. It responds with a 503 if the
+// database can't be reached, so load balancers stop sending requests the
+// server couldn't serve. A processing queue alert doesn't take the server
+// out of rotation, since it can still serve everything else, but turns its
+// status to degraded.
+func (cfg *apiConfig) handlerReadyz(w http.ResponseWriter, r *http.Request) {
+	type queueStatus struct {
+		processingQueueStatus
+		OldestJobAgeSeconds int             `json:"oldest_job_age_seconds"`
+		Alert               queueAlertState `json:"alert"`
+	}
+	type response struct {
+		Status          string      `json:"status"`
+		Database        string      `json:"database"`
+		ProcessingQueue queueStatus `json:"processing_queue"`
+	}
+	resp := response{
+		Status:   "ok",
+		Database: "ok",
+		ProcessingQueue: queueStatus{
+			processingQueueStatus: cfg.processingQueueStatus(),
+			OldestJobAgeSeconds:   int(cfg.jobs.Oldest().Seconds()),
+			Alert:                 cfg.queueAlerts.current(),
+		},
+	}
+	if resp.ProcessingQueue.Alert.Firing {
+		resp.Status = "degraded"
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+	status := http.StatusOK
+	if err := cfg.db.Ping(ctx); err != nil {
+		log.Printf("Readiness check: couldn't reach the database: %v", err)
+		resp.Status = "unavailable"
+		resp.Database = "unavailable"
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, status, resp)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/recommend"
+	"github.com/google/uuid"
+)
+
+// minWatchWeight is how much a video the viewer only started counts toward
+// their taste, next to 1 for one they finished.
+const minWatchWeight = 0.25
+
+type recommendedVideo struct {
+	database.Video
+	Score float64 `json:"score"`
+}
+
+// watchProfile builds the viewer's taste from the tags of the videos they
+// watched, each weighted by how much of it they got through.
+func watchProfile(history []database.WatchProgress, videos map[uuid.UUID]database.Video) recommend.Profile {
+	tags := map[string]float64{}
+	top := 0.0
+	for _, p := range history {
+		video, ok := videos[p.VideoID]
+		if !ok {
+			continue
+		}
+		weight := 1.0
+		if resp := newWatchProgressResponse(p); !resp.Completed && p.DurationSeconds > 0 {
+			weight = max(minWatchWeight, p.PositionSeconds/p.DurationSeconds)
+		}
+		for _, tag := range video.Tags {
+			tags[tag] += weight
+			top = max(top, tags[tag])
+		}
+	}
+	for tag := range tags {
+		tags[tag] /= top
+	}
+	return recommend.Profile{Tags: tags}
+}
+
+// handlerRecommendations suggests videos for the viewer, best first. Only
+// videos they can see, haven't watched and don't own are suggested.
+// ?limit= caps how many are returned.
+func (cfg *apiConfig) handlerRecommendations(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.requireUser(w, r)
+	if !ok {
+		return
+	}
+	limit, err := listingLimit(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "limit must be between 1 and 100", err)
+		return
+	}
+
+	videos, err := cfg.catalogVideos(r)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get videos", err)
+		return
+	}
+	history, err := cfg.db.GetWatchHistory(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get watch history", err)
+		return
+	}
+	byID := map[uuid.UUID]database.Video{}
+	for _, video := range videos {
+		byID[video.ID] = video
+	}
+	profile := watchProfile(history, byID)
+
+	watched := map[uuid.UUID]bool{}
+	for _, p := range history {
+		watched[p.VideoID] = true
+	}
+	candidates := []recommend.Candidate{}
+	ids := []uuid.UUID{}
+	for _, video := range videos {
+		if video.VideoURL == nil || video.UserID == userID || watched[video.ID] {
+			continue
+		}
+		publishedAt := video.CreatedAt
+		if video.PublishAt != nil {
+			publishedAt = *video.PublishAt
+		}
+		candidates = append(candidates, recommend.Candidate{ID: video.ID, Tags: video.Tags, PublishedAt: publishedAt})
+		ids = append(ids, video.ID)
+	}
+	counts, err := cfg.db.GetAccessCounts(ids)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video stats", err)
+		return
+	}
+	plays := map[uuid.UUID]int{}
+	for _, count := range counts {
+		if count.Kind == accessKindPlayback {
+			plays[count.VideoID] = count.Count
+		}
+	}
+	for i := range candidates {
+		candidates[i].Plays = plays[candidates[i].ID]
+	}
+
+	ranked, err := recommend.Rank(r.Context(), cfg.recommender, profile, candidates, limit)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't rank recommendations", err)
+		return
+	}
+	picked := make([]database.Video, len(ranked))
+	for i, rec := range ranked {
+		picked[i] = byID[rec.ID]
+	}
+	picked, err = cfg.dbVideosToSignedVideos(r.Context(), picked)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URLs", err)
+		return
+	}
+	resp := make([]recommendedVideo, len(picked))
+	for i, video := range picked {
+		resp[i] = recommendedVideo{Video: video, Score: ranked[i].Score}
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
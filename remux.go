@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ffmpeg"
+)
+
+// mp4VideoCodecs and mp4AudioCodecs are the codecs, as ffprobe names them,
+// that can be copied from a QuickTime file into MP4 as they are.
+var (
+	mp4VideoCodecs = map[string]bool{"h264": true, "hevc": true, "mpeg4": true, "av1": true, "vp9": true}
+	mp4AudioCodecs = map[string]bool{"aac": true, "mp3": true, "alac": true, "ac3": true, "eac3": true, "opus": true, "flac": true}
+)
+
+// remuxOptionsFor decides which streams of a file in container have to be
+// re-encoded to fit in MP4. Containers that want browser codecs only keep
+// 8-bit 4:2:0 H.264 and AAC.
+func remuxOptionsFor(probe ffprobeOutput, container videoContainer) ffmpeg.RemuxOptions {
+	opts := ffmpeg.RemuxOptions{}
+	if stream, ok := probe.firstStream("video"); ok {
+		if container.browserCodecs {
+			opts.TranscodeVideo = stream.CodecName != "h264" || stream.PixFmt != "yuv420p"
+		} else {
+			opts.TranscodeVideo = !mp4VideoCodecs[stream.CodecName]
+			opts.HEVC = stream.CodecName == "hevc"
+		}
+	}
+	for _, stream := range probe.Streams {
+		if stream.CodecType != "audio" {
+			continue
+		}
+		if (container.browserCodecs && stream.CodecName != "aac") || !mp4AudioCodecs[stream.CodecName] {
+			opts.TranscodeAudio = true
+		}
+	}
+	return opts
+}
+
+// remuxToMP4 converts an upload in another container to an MP4 next to it,
+// so the rest of the pipeline only ever sees MP4. The caller removes the
+// returned file.
+func (cfg *apiConfig) remuxToMP4(ctx context.Context, filePath string, container videoContainer) (string, error) {
+	probe, err := cfg.probeVideo(ctx, filePath)
+	if err != nil {
+		return "", err
+	}
+	opts := remuxOptionsFor(probe, container)
+
+	outputFilePath := strings.TrimSuffix(filePath, container.tempExt()) + ".mp4"
+	start := time.Now()
+	_, err = ffmpeg.ToMP4Command(filePath, outputFilePath, opts).Run(ctx)
+	logStep(ctx, "remux", fmt.Sprintf("%s to MP4 (transcode video: %t, transcode audio: %t)", strings.ToUpper(container.name), opts.TranscodeVideo, opts.TranscodeAudio), start, err)
+	if err != nil {
+		os.Remove(outputFilePath)
+		return "", err
+	}
+	return outputFilePath, nil
+}
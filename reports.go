@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	eventVideoHeld     = "video.moderation_hold"
+	eventVideoReleased = "video.moderation_released"
+)
+
+// reportReasons are the reason codes viewers can report a video for.
+var reportReasons = map[string]bool{
+	"spam":           true,
+	"harassment":     true,
+	"hate":           true,
+	"violence":       true,
+	"sexual":         true,
+	"copyright":      true,
+	"misinformation": true,
+	"other":          true,
+}
+
+var reportDetailsLimit = textLimit{field: "details", maxRunes: 1000, maxBytes: 4000, multiline: true}
+
+func (cfg *apiConfig) handlerVideoReport(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Reason  string `json:"reason"`
+		Details string `json:"details"`
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if !reportReasons[params.Reason] {
+		respondWithError(w, http.StatusBadRequest, "Unknown report reason", nil)
+		return
+	}
+	params.Details, err = reportDetailsLimit.apply(params.Details)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	if params.Reason == "other" && params.Details == "" {
+		respondWithError(w, http.StatusBadRequest, "Details are required for reason other", nil)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil || !cfg.canView(r, video) {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	if video.UserID == userID {
+		respondWithError(w, http.StatusBadRequest, "You can't report your own video", nil)
+		return
+	}
+
+	report := database.Report{
+		ID:         uuid.New(),
+		VideoID:    videoID,
+		ReporterID: userID,
+		Source:     database.ReportSourceViewer,
+		Reason:     params.Reason,
+		Details:    params.Details,
+		Status:     database.ReportStatusOpen,
+		CreatedAt:  cfg.clock.Now().UTC(),
+	}
+	openReporters, err := cfg.db.CreateReport(report)
+	if errors.Is(err, database.ErrDuplicateReport) {
+		respondWithError(w, http.StatusConflict, "You have already reported this video", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save report", err)
+		return
+	}
+
+	if cfg.reportHoldThreshold > 0 && openReporters >= cfg.reportHoldThreshold && !video.ModerationHold {
+		video.ModerationHold = true
+		if err := cfg.db.UpdateVideo(video); err != nil {
+			log.Printf("Couldn't hold video %s for review: %v", video.ID, err)
+		} else {
+			cfg.emitEvent(eventVideoHeld, video.ID, map[string]any{"open_reports": openReporters})
+		}
+	}
+
+	respondWithJSON(w, http.StatusCreated, report)
+}
+
+// handlerAdminReportsList is the moderation queue. ?status= filters by
+// status and defaults to open reports; "all" returns every report.
+func (cfg *apiConfig) handlerAdminReportsList(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "":
+		status = database.ReportStatusOpen
+	case "all":
+		status = ""
+	case database.ReportStatusOpen, database.ReportStatusDismissed, database.ReportStatusActioned:
+	default:
+		respondWithError(w, http.StatusBadRequest, "Unknown report status", nil)
+		return
+	}
+
+	reports, err := cfg.db.GetReports(status)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get reports", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, reports)
+}
+
+// handlerAdminReportResolve closes a report. Actioning a report also holds
+// the video; dismissing one leaves any hold for the moderator to release.
+func (cfg *apiConfig) handlerAdminReportResolve(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Status string `json:"status"`
+		Note   string `json:"note"`
+	}
+
+	adminID, ok := cfg.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	reportID, err := uuid.Parse(r.PathValue("reportID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Status != database.ReportStatusDismissed && params.Status != database.ReportStatusActioned {
+		respondWithError(w, http.StatusBadRequest, "Unknown report status", nil)
+		return
+	}
+	params.Note, err = reportDetailsLimit.apply(params.Note)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	report, err := cfg.db.GetReport(reportID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get report", err)
+		return
+	}
+	if report == nil {
+		respondWithError(w, http.StatusNotFound, "Report not found", nil)
+		return
+	}
+
+	if params.Status == database.ReportStatusActioned {
+		if err := cfg.setModerationHold(report.VideoID, true); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+			return
+		}
+	}
+
+	err = cfg.db.ResolveReport(reportID, params.Status, adminID, params.Note, cfg.clock.Now().UTC())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update report", err)
+		return
+	}
+
+	report, err = cfg.db.GetReport(reportID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get report", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, report)
+}
+
+// handlerAdminModerationHold places or releases a video's moderation hold.
+func (cfg *apiConfig) handlerAdminModerationHold(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Held bool `json:"held"`
+	}
+
+	adminID, ok := cfg.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+
+	if err := cfg.setModerationHold(videoID, params.Held); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+	cfg.recordActivity(video.UserID, activityModerationHold, &videoID, &adminID, strconv.FormatBool(params.Held))
+	video.ModerationHold = params.Held
+	video, err = cfg.dbVideoToSignedVideo(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URLs", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+func (cfg *apiConfig) setModerationHold(videoID uuid.UUID, held bool) error {
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		return err
+	}
+	if video.ID == uuid.Nil || video.ModerationHold == held {
+		return nil
+	}
+	video.ModerationHold = held
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		return err
+	}
+	if held {
+		cfg.emitEvent(eventVideoHeld, videoID, nil)
+	} else {
+		cfg.emitEvent(eventVideoReleased, videoID, nil)
+	}
+	return nil
+}
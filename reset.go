@@ -14,6 +14,7 @@ func (cfg *apiConfig) handlerReset(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't reset database", err)
 		return
 	}
+	cfg.sitemap.reset()
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Database reset to initial state"))
 }
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ffmpeg"
+	"github.com/google/uuid"
+)
+
+// maxResizeDimension caps the width and height of on-the-fly resizes.
+const maxResizeDimension = 2048
+
+// resizedDir is the directory under the assets root that resized images are
+// cached in.
+const resizedDir = "resized"
+
+// resizableExtensions are the asset types that can be resized.
+var resizableExtensions = map[string]bool{
+	".jpg":  true,
+	".jpeg": true,
+	".png":  true,
+	".webp": true,
+}
+
+// resizeParams is an on-the-fly resize of an asset. Fit is empty when only
+// one of Width and Height is set, since the other follows from the aspect
+// ratio.
+type resizeParams struct {
+	Width  int
+	Height int
+	Fit    string
+	Format string
+}
+
+// isResizeRequest reports whether q asks for a resized asset rather than the
+// original.
+func isResizeRequest(q url.Values) bool {
+	return q.Has("w") || q.Has("h")
+}
+
+func parseResizeParams(q url.Values) (resizeParams, error) {
+	p := resizeParams{Fit: q.Get("fit"), Format: q.Get("format")}
+	for _, d := range []struct {
+		name string
+		dst  *int
+	}{{"w", &p.Width}, {"h", &p.Height}} {
+		v := q.Get(d.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxResizeDimension {
+			return resizeParams{}, fmt.Errorf("%s must be an integer between 1 and %d", d.name, maxResizeDimension)
+		}
+		*d.dst = n
+	}
+	if p.Width == 0 && p.Height == 0 {
+		return resizeParams{}, errors.New("w or h is required")
+	}
+
+	switch {
+	case p.Width == 0 || p.Height == 0:
+		p.Fit = ""
+	case p.Fit == "":
+		p.Fit = ffmpeg.FitCover
+	case p.Fit != ffmpeg.FitCover && p.Fit != ffmpeg.FitContain:
+		return resizeParams{}, fmt.Errorf("fit must be %s or %s", ffmpeg.FitCover, ffmpeg.FitContain)
+	}
+
+	if p.Format == "" {
+		p.Format = ffmpeg.ImageJPEG
+	}
+	if _, ok := ffmpeg.ImageExtensions[p.Format]; !ok {
+		return resizeParams{}, fmt.Errorf("unsupported format %q", p.Format)
+	}
+	return p, nil
+}
+
+// query encodes p the way parseResizeParams reads it.
+func (p resizeParams) query() url.Values {
+	q := url.Values{}
+	if p.Width > 0 {
+		q.Set("w", strconv.Itoa(p.Width))
+	}
+	if p.Height > 0 {
+		q.Set("h", strconv.Itoa(p.Height))
+	}
+	if p.Fit != "" {
+		q.Set("fit", p.Fit)
+	}
+	q.Set("format", p.Format)
+	return q
+}
+
+// resizeSignature signs a resize of the named asset, so only sizes the
+// server handed out can be requested. Without it, anyone could make the
+// server encode and cache every possible size of every thumbnail.
+func (cfg *apiConfig) resizeSignature(name string, p resizeParams) string {
+	mac := hmac.New(sha256.New, cfg.resizeKey)
+	fmt.Fprintf(mac, "%s|%d|%d|%s|%s", name, p.Width, p.Height, p.Fit, p.Format)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// resizedAssetURL is the signed URL of the named asset resized with p.
+func (cfg *apiConfig) resizedAssetURL(name string, p resizeParams) string {
+	q := p.query()
+	q.Set("sig", cfg.resizeSignature(name, p))
+	return fmt.Sprintf("http://localhost:%s/assets/%s?%s", cfg.port, url.PathEscape(name), q.Encode())
+}
+
+// resizeMiddleware serves resized copies of assets for requests with w or h
+// parameters and passes everything else to next. Resized copies are cached
+// on disk by size, so each is encoded once, even for assets in the bucket;
+// since asset names are random and never reused, a cached copy never goes
+// stale.
+func (cfg *apiConfig) resizeMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if !isResizeRequest(q) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(cfg.resizeKey) == 0 {
+			respondWithError(w, http.StatusNotFound, "Image resizing is not configured", nil)
+			return
+		}
+
+		name := r.PathValue("name")
+		p, err := parseResizeParams(q)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid resize parameters", err)
+			return
+		}
+		if !hmac.Equal([]byte(q.Get("sig")), []byte(cfg.resizeSignature(name, p))) {
+			respondWithError(w, http.StatusForbidden, "Invalid resize signature", nil)
+			return
+		}
+
+		ext := strings.ToLower(filepath.Ext(name))
+		if !resizableExtensions[ext] {
+			respondWithError(w, http.StatusNotFound, "Thumbnail not found", nil)
+			return
+		}
+
+		cached := filepath.Join(cfg.assetsRoot, resizedDir, fmt.Sprintf("%s-%dx%d%s%s",
+			strings.TrimSuffix(filepath.Base(name), filepath.Ext(name)),
+			p.Width, p.Height, fitSuffix(p.Fit), ffmpeg.ImageExtensions[p.Format]))
+		if _, err := os.Stat(cached); os.IsNotExist(err) {
+			source, remove, err := cfg.assetFile(r.Context(), filepath.Base(name))
+			if err != nil {
+				respondWithError(w, http.StatusNotFound, "Thumbnail not found", err)
+				return
+			}
+			err = cfg.resizeAsset(r, source, cached, p)
+			remove()
+			if err != nil {
+				respondWithError(w, http.StatusInternalServerError, "Couldn't resize image", err)
+				return
+			}
+		} else if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't resize image", err)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		http.ServeFile(w, r, cached)
+	})
+}
+
+func fitSuffix(fit string) string {
+	if fit == "" {
+		return ""
+	}
+	return "-" + fit
+}
+
+// resizeAsset encodes the resized copy next to cached and renames it into
+// place, so concurrent requests for the same size never serve a partial
+// file.
+func (cfg *apiConfig) resizeAsset(r *http.Request, source, cached string, p resizeParams) error {
+	if err := os.MkdirAll(filepath.Dir(cached), 0755); err != nil {
+		return err
+	}
+	tmp := fmt.Sprintf("%s.%s.tmp", cached, uuid.NewString())
+	defer os.Remove(tmp)
+	err := cfg.jobs.Run(uuid.New(), 0, func() error {
+		_, err := ffmpeg.ResizeCommand(source, tmp, p.Width, p.Height, p.Fit, p.Format).Run(r.Context())
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, cached)
+}
+
+// handlerThumbnailResizeURL hands out a signed URL for the video's thumbnail
+// at the size in the query, e.g. ?w=320&h=180&fit=cover.
+func (cfg *apiConfig) handlerThumbnailResizeURL(w http.ResponseWriter, r *http.Request) {
+	if len(cfg.resizeKey) == 0 {
+		respondWithError(w, http.StatusNotFound, "Image resizing is not configured", nil)
+		return
+	}
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+	p, err := parseResizeParams(r.URL.Query())
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid resize parameters", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil || !cfg.canView(r, video) {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	if video.ThumbnailURL == nil {
+		respondWithError(w, http.StatusNotFound, "Thumbnail not found", nil)
+		return
+	}
+	name, ok := cfg.thumbnailAssetName(*video.ThumbnailURL)
+	if !ok || !resizableExtensions[strings.ToLower(filepath.Ext(name))] {
+		respondWithError(w, http.StatusNotFound, "Thumbnail not found", nil)
+		return
+	}
+
+	type response struct {
+		URL string `json:"url"`
+	}
+	respondWithJSON(w, http.StatusOK, response{URL: cfg.cdnAssetURL(cfg.resizedAssetURL(name, p))})
+}
@@ -0,0 +1,509 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tenants"
+	"github.com/google/uuid"
+)
+
+// S3 imports take videos from buckets in customers' own AWS accounts,
+// without them handing over long-lived credentials. The customer creates a
+// role the server can assume, requiring the external ID the server picked
+// for them, that can read their bucket and put objects in the server's.
+// Each import assumes it, copies the object into the user's storage
+// target server-side, checks the copy's SHA-256 and processes it like an
+// upload. The customer's object is left as it is.
+
+// roleARNPattern is what IAM accepts as a role's ARN.
+var roleARNPattern = regexp.MustCompile(`^arn:aws[\w-]*:iam::\d{12}:role/[\w+=,.@/-]{1,512}$`)
+
+// s3BucketPattern is what S3 accepts as a bucket name.
+var s3BucketPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+
+// s3RegionPattern is the shape of an AWS region.
+var s3RegionPattern = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-\d$`)
+
+// handlerS3SourceGet returns the user's S3 source.
+func (cfg *apiConfig) handlerS3SourceGet(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.requireUser(w, r)
+	if !ok {
+		return
+	}
+	source, err := cfg.db.GetS3Source(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get S3 source", err)
+		return
+	}
+	if source == nil {
+		respondWithError(w, http.StatusNotFound, "S3 source not found", nil)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, source)
+}
+
+// handlerS3SourceUpdate links the role {"role_arn"} to the user, replacing
+// the one they had. Their external ID is picked the first time and kept
+// after that, so the role's trust policy doesn't have to change with it.
+func (cfg *apiConfig) handlerS3SourceUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		RoleARN string `json:"role_arn"`
+	}
+
+	userID, ok := cfg.requireUser(w, r)
+	if !ok {
+		return
+	}
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if !roleARNPattern.MatchString(params.RoleARN) {
+		respondWithError(w, http.StatusBadRequest, "Invalid role ARN", fmt.Errorf("role ARN %q", params.RoleARN))
+		return
+	}
+
+	source, err := cfg.db.GetS3Source(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get S3 source", err)
+		return
+	}
+	now := cfg.clock.Now().UTC()
+	if source == nil {
+		externalID, err := newExternalID()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update S3 source", err)
+			return
+		}
+		source = &database.S3Source{UserID: userID, ExternalID: externalID, CreatedAt: now}
+	}
+	source.RoleARN = params.RoleARN
+	source.UpdatedAt = now
+	if err := cfg.db.SetS3Source(*source); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update S3 source", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, source)
+}
+
+// handlerS3SourceDelete unlinks the user's role. Linking one again picks a
+// new external ID.
+func (cfg *apiConfig) handlerS3SourceDelete(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.requireUser(w, r)
+	if !ok {
+		return
+	}
+	deleted, err := cfg.db.DeleteS3Source(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete S3 source", err)
+		return
+	}
+	if !deleted {
+		respondWithError(w, http.StatusNotFound, "S3 source not found", nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func newExternalID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "tubely-" + hex.EncodeToString(b), nil
+}
+
+// s3ImportParams are the object to import and the video it becomes.
+// SHA256, if set, is what the object is expected to hash to.
+type s3ImportParams struct {
+	Bucket      string   `json:"bucket"`
+	Key         string   `json:"key"`
+	Region      string   `json:"region"`
+	SHA256      string   `json:"sha256"`
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	Tags        []string `json:"tags"`
+	Profile     string   `json:"profile"`
+	PresetID    string   `json:"preset_id"`
+	// ExternalIDs are the new video's, as when creating one.
+	ExternalIDs map[string]string `json:"external_ids"`
+}
+
+// handlerS3Import imports an object from the user's own bucket as a new
+// video, and queues it for processing. It responds 202 with the video's ID
+// once the import is queued, without waiting for the copy either. An
+// object imported again is only imported once, and responds 200 with the
+// same ID.
+func (cfg *apiConfig) handlerS3Import(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		VideoID   uuid.UUID `json:"video_id"`
+		Duplicate bool      `json:"duplicate,omitempty"`
+	}
+
+	userID, tenantID, ok := cfg.requireUploader(w, r)
+	if !ok {
+		return
+	}
+	params := s3ImportParams{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if !s3BucketPattern.MatchString(params.Bucket) || params.Key == "" || len(params.Key) > 1024 {
+		respondWithError(w, http.StatusBadRequest, "Invalid bucket or key", fmt.Errorf("s3://%s/%s", params.Bucket, params.Key))
+		return
+	}
+	if params.Region != "" && !s3RegionPattern.MatchString(params.Region) {
+		respondWithError(w, http.StatusBadRequest, "Invalid region", fmt.Errorf("region %q", params.Region))
+		return
+	}
+	if params.SHA256 != "" && !sha256Pattern.MatchString(params.SHA256) {
+		respondWithError(w, http.StatusBadRequest, "sha256 must be 64 lowercase hex digits", nil)
+		return
+	}
+	contentType := videoContentType(params.Key)
+	if contentType == "" {
+		respondWithError(w, http.StatusBadRequest, "Invalid file type. This kind of video isn't allowed.", fmt.Errorf("file %q", params.Key))
+		return
+	}
+
+	source, err := cfg.db.GetS3Source(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get S3 source", err)
+		return
+	}
+	if source == nil {
+		respondWithError(w, http.StatusForbidden, "Link an IAM role to import from S3", nil)
+		return
+	}
+	target, err := cfg.tenants.Target(r.Context(), tenantID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't resolve storage for tenant", err)
+		return
+	}
+	if !requireS3(w, target) {
+		return
+	}
+	region := params.Region
+	if region == "" {
+		region = target.Region
+	}
+	client, err := cfg.s3SourceClient(r.Context(), *source, region)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create S3 client", err)
+		return
+	}
+
+	head, err := client.HeadObject(r.Context(), &s3.HeadObjectInput{
+		Bucket:       aws.String(params.Bucket),
+		Key:          aws.String(params.Key),
+		ChecksumMode: types.ChecksumModeEnabled,
+	})
+	if err != nil {
+		respondWithSourceError(w, err)
+		return
+	}
+	etag := strings.Trim(aws.ToString(head.ETag), `"`)
+	size := aws.ToInt64(head.ContentLength)
+	if size <= 0 || size > maxUploadSize {
+		respondWithError(w, http.StatusBadRequest, "Each file needs a size of at most 1 GB", fmt.Errorf("s3://%s/%s is %d bytes", params.Bucket, params.Key, size))
+		return
+	}
+	want := params.SHA256
+	if sum, ok := fullObjectSHA256(head.ChecksumSHA256); ok {
+		if want != "" && want != sum {
+			respondWithError(w, http.StatusUnprocessableEntity, "Imported file doesn't match its checksum", fmt.Errorf("S3 has SHA-256 %s, not %s", sum, want))
+			return
+		}
+		want = sum
+	}
+	if existing, err := cfg.db.GetS3Import(userID, params.Bucket, params.Key, etag); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get S3 import", err)
+		return
+	} else if existing != nil {
+		respondWithJSON(w, http.StatusOK, response{VideoID: existing.VideoID, Duplicate: true})
+		return
+	}
+
+	profileName, ok := cfg.uploadProfile(w, userID, params.Profile, params.PresetID)
+	if !ok {
+		return
+	}
+	src := videoSource{
+		filename:    path.Base(params.Key),
+		contentType: contentType,
+		profileName: profileName,
+	}
+	if _, _, ok := cfg.checkVideoSource(w, src); !ok {
+		return
+	}
+	if !cfg.requireStorageQuota(w, userID, uuid.Nil, database.StorageKindVideo, size) {
+		return
+	}
+	release, ok := cfg.admitUpload(w)
+	if !ok {
+		return
+	}
+	started := false
+	defer func() {
+		if !started {
+			release()
+		}
+	}()
+
+	tags := params.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	videoParams := database.CreateVideoParams{
+		Title:       ingestTitle(params.Title, sftpTitle(params.Key)),
+		Description: params.Description,
+		Tags:        tags,
+		UserID:      userID,
+		ExternalIDs: params.ExternalIDs,
+	}
+	if err := normalizeVideoParams(&videoParams); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	video, err := cfg.db.CreateVideo(videoParams)
+	if errors.Is(err, database.ErrExternalIDTaken) {
+		respondWithError(w, http.StatusConflict, "External ID is already in use", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create video", err)
+		return
+	}
+	created, err := cfg.db.CreateS3Import(database.S3Import{
+		UserID:    userID,
+		Bucket:    params.Bucket,
+		Key:       params.Key,
+		ETag:      etag,
+		VideoID:   video.ID,
+		CreatedAt: cfg.clock.Now().UTC(),
+	})
+	if err != nil || !created {
+		if err := cfg.db.DeleteVideo(video.ID); err != nil {
+			log.Printf("Couldn't delete video %s of S3 import: %v", video.ID, err)
+		}
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save S3 import", err)
+		return
+	}
+	if !created {
+		// Another import of the same object got there first.
+		existing, err := cfg.db.GetS3Import(userID, params.Bucket, params.Key, etag)
+		if err != nil || existing == nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get S3 import", err)
+			return
+		}
+		respondWithJSON(w, http.StatusOK, response{VideoID: existing.VideoID, Duplicate: true})
+		return
+	}
+	if _, err := cfg.db.QueueVideoProcessing(video.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to update video metadata", err)
+		return
+	}
+	cfg.recordActivity(userID, activityVideoCreated, &video.ID, nil, video.Title)
+	cfg.emitEvent(eventVideoUploaded, video.ID, map[string]any{"source": "s3", "size": size})
+
+	started = true
+	imp := s3Import{
+		client: client,
+		bucket: params.Bucket,
+		key:    params.Key,
+		etag:   etag,
+		sha256: want,
+	}
+	cfg.background.run(func() { cfg.runS3Import(r, video, target, src, imp, release) })
+	respondWithJSON(w, http.StatusAccepted, response{VideoID: video.ID})
+}
+
+// s3SourceClient returns an S3 client with the credentials of the
+// source's role, assumed with its external ID.
+func (cfg *apiConfig) s3SourceClient(ctx context.Context, source database.S3Source, region string) (*s3.Client, error) {
+	awsCfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
+	if err != nil {
+		return nil, err
+	}
+	provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(awsCfg), source.RoleARN, func(o *stscreds.AssumeRoleOptions) {
+		o.RoleSessionName = "tubely-import-" + source.UserID.String()
+		o.ExternalID = aws.String(source.ExternalID)
+	})
+	awsCfg.Credentials = aws.NewCredentialsCache(provider)
+	return s3.NewFromConfig(awsCfg, cfg.s3Options...), nil
+}
+
+// fullObjectSHA256 returns the hex SHA-256 of a whole object from the
+// checksum S3 reports for it. Objects uploaded in parts have a checksum
+// of their parts' checksums instead, which isn't one.
+func fullObjectSHA256(checksum *string) (string, bool) {
+	c := aws.ToString(checksum)
+	if c == "" || strings.Contains(c, "-") {
+		return "", false
+	}
+	sum, err := base64.StdEncoding.DecodeString(c)
+	if err != nil || len(sum) != sha256.Size {
+		return "", false
+	}
+	return hex.EncodeToString(sum), true
+}
+
+// respondWithSourceError responds to an error reading a customer's object.
+// Those are the customer's to fix, so they get a 4xx of their own rather
+// than a storage failure of the server.
+func respondWithSourceError(w http.ResponseWriter, err error) {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "NotFound", "NoSuchKey", "NoSuchBucket":
+			respondWithError(w, http.StatusNotFound, "S3 object not found", err)
+			return
+		case "AccessDenied", "Forbidden":
+			respondWithError(w, http.StatusForbidden, "The linked IAM role can't read the S3 object", err)
+			return
+		}
+	}
+	respondWithStorageError(w, http.StatusBadGateway, "Couldn't get S3 object", err)
+}
+
+// s3Import is an object being imported, with the client of the role that
+// can read it. sha256 is what it has to hash to, if known.
+type s3Import struct {
+	client *s3.Client
+	bucket string
+	key    string
+	etag   string
+	sha256 string
+}
+
+// runS3Import copies an imported object into the video's storage, checks
+// and downloads the copy to the upload spool, and processes it like an
+// upload. r is the request that asked for the import; the job outlives it.
+// release is the import's place in the processing queue.
+func (cfg *apiConfig) runS3Import(r *http.Request, video database.Video, target tenants.Target, src videoSource, imp s3Import, release func()) {
+	plog := newProcessingLog(video.ID, "s3")
+	plog.release = release
+	ctx := plog.context(context.Background())
+	stagedKey := videoObjectKey(video.UserID, video.ID, "imports/"+uuid.NewString())
+	rawPath, err := cfg.fetchS3Import(ctx, video.ID, target, imp, stagedKey)
+	if err := target.Storage().Delete(context.Background(), stagedKey); err != nil {
+		log.Printf("Couldn't delete imported copy %s: %v", stagedKey, err)
+	}
+	if err != nil {
+		rec := &errorRecorder{ResponseWriter: &discardResponseWriter{}}
+		if errors.Is(err, errImportChecksum) {
+			respondWithError(rec, http.StatusUnprocessableEntity, "Imported file doesn't match its checksum", err)
+		} else {
+			respondWithStorageError(rec, http.StatusBadGateway, "Couldn't copy S3 object", err)
+		}
+		cfg.setVideoProcessing(video.ID, database.VideoFailed, rec.message())
+		cfg.saveProcessingLog(plog, rec.failure())
+		return
+	}
+	cfg.runUploadJob(r, uploadJob{
+		videoID: video.ID,
+		target:  target,
+		src:     src,
+		rawPath: rawPath,
+		plog:    plog,
+	})
+}
+
+var errImportChecksum = errors.New("imported file doesn't match its checksum")
+
+// fetchS3Import copies the object to stagedKey in target and downloads the
+// copy into the upload spool, returning its path. S3 computes the copy's
+// SHA-256, which the download has to hash to, as must the object, if its
+// hash is known.
+func (cfg *apiConfig) fetchS3Import(ctx context.Context, videoID uuid.UUID, target tenants.Target, imp s3Import, stagedKey string) (string, error) {
+	start := time.Now()
+	input := &s3.CopyObjectInput{
+		Bucket:            aws.String(target.Bucket),
+		Key:               aws.String(stagedKey),
+		CopySource:        aws.String(s3CopySource(imp.bucket, imp.key)),
+		CopySourceIfMatch: aws.String(`"` + imp.etag + `"`),
+		ChecksumAlgorithm: types.ChecksumAlgorithmSha256,
+	}
+	withCopyEncryption(target.Encryption)(input)
+	out, err := imp.client.CopyObject(ctx, input)
+	logStep(ctx, "copy", fmt.Sprintf("s3://%s/%s", imp.bucket, imp.key), start, err)
+	if err != nil {
+		return "", err
+	}
+	want := imp.sha256
+	if out.CopyObjectResult != nil {
+		if sum, ok := fullObjectSHA256(out.CopyObjectResult.ChecksumSHA256); ok {
+			if want != "" && want != sum {
+				return "", fmt.Errorf("%w: the copy has SHA-256 %s, not %s", errImportChecksum, sum, want)
+			}
+			want = sum
+		}
+	}
+
+	start = time.Now()
+	rawPath, sum, err := cfg.downloadS3Import(ctx, videoID, target, stagedKey)
+	logStep(ctx, "download", stagedKey, start, err)
+	if err != nil {
+		return "", err
+	}
+	if want != "" && sum != want {
+		os.Remove(rawPath)
+		return "", fmt.Errorf("%w: downloaded SHA-256 %s, not %s", errImportChecksum, sum, want)
+	}
+	return rawPath, nil
+}
+
+// downloadS3Import copies the staged object into the upload spool, and
+// returns the copy's path and SHA-256.
+func (cfg *apiConfig) downloadS3Import(ctx context.Context, videoID uuid.UUID, target tenants.Target, key string) (string, string, error) {
+	body, err := target.Storage().Get(ctx, key)
+	if err != nil {
+		return "", "", err
+	}
+	defer body.Close()
+
+	if err := os.MkdirAll(cfg.uploadSpoolDir, 0700); err != nil {
+		return "", "", err
+	}
+	raw, err := os.CreateTemp(cfg.uploadSpoolDir, videoID.String()+"-"+rawUploadPattern)
+	if err != nil {
+		return "", "", err
+	}
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(raw, hash), body)
+	if closeErr := raw.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(raw.Name())
+		return "", "", err
+	}
+	return raw.Name(), hex.EncodeToString(hash.Sum(nil)), nil
+}
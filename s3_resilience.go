@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// s3ResilienceFromEnv reads how S3 clients retry and when they stop calling
+// a failing S3. The defaults wait 30 seconds for S3 to respond, make 5
+// attempts backing off up to 20 seconds, and open the circuit for 30
+// seconds after 5 calls in a row fail.
+func s3ResilienceFromEnv() (*storage.Resilience, error) {
+	r := &storage.Resilience{
+		Timeout:          30 * time.Second,
+		MaxAttempts:      5,
+		MaxBackoff:       20 * time.Second,
+		BreakerThreshold: 5,
+		BreakerCooldown:  30 * time.Second,
+	}
+	durations := []struct {
+		name string
+		d    *time.Duration
+	}{
+		{"S3_TIMEOUT", &r.Timeout},
+		{"S3_MAX_BACKOFF", &r.MaxBackoff},
+		{"S3_BREAKER_COOLDOWN", &r.BreakerCooldown},
+	}
+	for _, d := range durations {
+		v := os.Getenv(d.name)
+		if v == "" {
+			continue
+		}
+		dur, err := time.ParseDuration(v)
+		if err != nil || dur <= 0 {
+			return nil, fmt.Errorf("%s must be a positive duration", d.name)
+		}
+		*d.d = dur
+	}
+	if v := os.Getenv("S3_MAX_ATTEMPTS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("S3_MAX_ATTEMPTS must be a positive integer")
+		}
+		r.MaxAttempts = n
+	}
+	if v := os.Getenv("S3_BREAKER_THRESHOLD"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("S3_BREAKER_THRESHOLD must be a non-negative integer, 0 to disable")
+		}
+		r.BreakerThreshold = n
+	}
+	return r, nil
+}
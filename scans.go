@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/scan"
+	"github.com/google/uuid"
+)
+
+const eventScanFlagged = "video.scan_flagged"
+
+var sha256Pattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// scanUpload returns the configured scanner's verdict on the uploaded file
+// at path, whose SHA-256 is sum. Verdicts are cached by content hash for
+// scanCacheTTL, so the same file uploaded again isn't sent to the scanner
+// again. A failing scanner doesn't block processing, as with fingerprint
+// checks: the failure is recorded on the processing log, nothing is cached,
+// and the upload goes through unchecked.
+func (cfg *apiConfig) scanUpload(ctx context.Context, path, sum string) scan.Verdict {
+	clean := scan.Verdict{Status: scan.StatusClean}
+	if cfg.scanner == nil {
+		return clean
+	}
+
+	start := time.Now()
+	cached, ok, err := cfg.db.GetScanVerdict(sum, cfg.scannerName, cfg.clock.Now())
+	if err != nil {
+		log.Printf("Couldn't look up cached scan verdict for %s: %v", sum, err)
+	}
+	if ok {
+		logStep(ctx, "scan", cached.Status+" (cached)", start, nil)
+		return scan.Verdict{Status: cached.Status, Reason: cached.Reason}
+	}
+
+	verdict, err := cfg.scanner.Scan(ctx, path)
+	logStep(ctx, "scan", verdict.Status, start, err)
+	if err != nil {
+		log.Printf("Scan of %s failed: %v", path, err)
+		return clean
+	}
+	if cfg.scanCacheTTL > 0 {
+		now := cfg.clock.Now().UTC()
+		err := cfg.db.SaveScanVerdict(database.ScanVerdict{
+			SHA256:    sum,
+			Scanner:   cfg.scannerName,
+			Status:    verdict.Status,
+			Reason:    verdict.Reason,
+			ScannedAt: now,
+			ExpiresAt: now.Add(cfg.scanCacheTTL),
+		})
+		if err != nil {
+			log.Printf("Couldn't cache scan verdict for %s: %v", sum, err)
+		}
+	}
+	return verdict
+}
+
+// flagScanVerdict files a report for moderators to review a video whose
+// file the scanner flagged. The caller holds the video until then.
+func (cfg *apiConfig) flagScanVerdict(videoID uuid.UUID, verdict scan.Verdict) {
+	details := "Flagged by file scan"
+	if verdict.Reason != "" {
+		details += ": " + verdict.Reason
+	}
+	_, err := cfg.db.CreateReport(database.Report{
+		ID:        uuid.New(),
+		VideoID:   videoID,
+		Source:    database.ReportSourceScan,
+		Reason:    "other",
+		Details:   details,
+		Status:    database.ReportStatusOpen,
+		CreatedAt: cfg.clock.Now().UTC(),
+	})
+	if err != nil {
+		log.Printf("Couldn't file scan report for video %s: %v", videoID, err)
+	}
+	cfg.emitEvent(eventScanFlagged, videoID, map[string]any{"reason": verdict.Reason})
+}
+
+// scanVerdictFilter reads the ?status= the scan verdict endpoints filter
+// by, which is empty for every status.
+func scanVerdictFilter(w http.ResponseWriter, r *http.Request) (string, bool) {
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", scan.StatusClean, scan.StatusFlagged, scan.StatusBlocked:
+		return status, true
+	default:
+		respondWithError(w, http.StatusBadRequest, "status must be clean, flagged or blocked", fmt.Errorf("unknown scan status %q", status))
+		return "", false
+	}
+}
+
+// handlerAdminScanVerdictsList lists the cached scan verdicts, most recent
+// first, optionally for one file with ?sha256= or one ?status=.
+func (cfg *apiConfig) handlerAdminScanVerdictsList(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+
+	sum := r.URL.Query().Get("sha256")
+	if sum != "" && !sha256Pattern.MatchString(sum) {
+		respondWithError(w, http.StatusBadRequest, "sha256 must be 64 lowercase hex digits", nil)
+		return
+	}
+	status, ok := scanVerdictFilter(w, r)
+	if !ok {
+		return
+	}
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		var err error
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > 500 {
+			respondWithError(w, http.StatusBadRequest, "limit must be between 1 and 500", err)
+			return
+		}
+	}
+
+	verdicts, err := cfg.db.GetScanVerdicts(sum, status, limit)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get scan verdicts", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, verdicts)
+}
+
+// handlerAdminScanVerdictDelete drops the cached verdicts on one file, so
+// the next upload of it is scanned again.
+func (cfg *apiConfig) handlerAdminScanVerdictDelete(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+
+	sum := r.PathValue("sha256")
+	if !sha256Pattern.MatchString(sum) {
+		respondWithError(w, http.StatusBadRequest, "sha256 must be 64 lowercase hex digits", nil)
+		return
+	}
+	deleted, err := cfg.db.DeleteScanVerdicts(sum, "")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete scan verdicts", err)
+		return
+	}
+	if deleted == 0 {
+		respondWithError(w, http.StatusNotFound, "Scan verdict not found", nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerAdminScanVerdictsDelete drops every cached verdict, or those with
+// one ?status=, for instance after a scanner's signatures are updated.
+func (cfg *apiConfig) handlerAdminScanVerdictsDelete(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+
+	status, ok := scanVerdictFilter(w, r)
+	if !ok {
+		return
+	}
+	deleted, err := cfg.db.DeleteScanVerdicts("", status)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete scan verdicts", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, map[string]int64{"deleted": deleted})
+}
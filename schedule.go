@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// localTimeLayouts are accepted for publish_at when it has no zone offset,
+// in which case time_zone says which zone the wall-clock time is in.
+var localTimeLayouts = []string{
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+}
+
+// scheduleParams is how clients set a publish time. publish_at is either an
+// RFC 3339 timestamp with a zone offset, or a wall-clock time with
+// time_zone naming an IANA zone such as "Europe/Madrid". The second form
+// lets the server apply the zone's DST rules for the scheduled date. With
+// premiere, the video premieres at publish_at.
+type scheduleParams struct {
+	PublishAt *string `json:"publish_at"`
+	TimeZone  string  `json:"time_zone"`
+	Premiere  bool    `json:"premiere"`
+}
+
+// schedule resolves the params to a UTC publish time. A nil publish_at
+// clears the schedule.
+func (p scheduleParams) schedule() (database.Schedule, error) {
+	if p.PublishAt == nil {
+		if p.Premiere {
+			return database.Schedule{}, errors.New("premiere needs a publish_at")
+		}
+		return database.Schedule{}, nil
+	}
+
+	var loc *time.Location
+	if p.TimeZone != "" {
+		var err error
+		loc, err = time.LoadLocation(p.TimeZone)
+		if err != nil || p.TimeZone == "Local" {
+			return database.Schedule{}, fmt.Errorf("unknown time_zone %q", p.TimeZone)
+		}
+	}
+
+	if t, err := time.Parse(time.RFC3339, *p.PublishAt); err == nil {
+		publishAt := t.UTC()
+		return database.Schedule{PublishAt: &publishAt, TimeZone: p.TimeZone, Premiere: p.Premiere}, nil
+	}
+	if loc == nil {
+		return database.Schedule{}, errors.New("publish_at must be RFC 3339 with a zone offset, or time_zone must be set")
+	}
+	for _, layout := range localTimeLayouts {
+		if t, err := time.ParseInLocation(layout, *p.PublishAt, loc); err == nil {
+			publishAt := t.UTC()
+			return database.Schedule{PublishAt: &publishAt, TimeZone: p.TimeZone, Premiere: p.Premiere}, nil
+		}
+	}
+	return database.Schedule{}, fmt.Errorf("couldn't parse publish_at %q", *p.PublishAt)
+}
+
+// canView reports whether the requester may see a video. Before it is
+// published, while it is held for moderation, or if it is private, only its
+// owner can. An unlisted video can also be seen with its share key.
+func (cfg *apiConfig) canView(r *http.Request, video database.Video) bool {
+	if video.Published(cfg.clock.Now()) && !video.ModerationHold {
+		switch video.Visibility {
+		case database.VisibilityPublic:
+			return true
+		case database.VisibilityUnlisted:
+			if hasShareKey(r, video) {
+				return true
+			}
+		}
+	}
+	viewerID := cfg.viewerID(r)
+	return viewerID != nil && *viewerID == video.UserID
+}
+
+func (cfg *apiConfig) handlerVideoScheduleSet(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	params := scheduleParams{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	schedule, err := params.schedule()
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't update this video", nil)
+		return
+	}
+
+	video.Schedule = schedule
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+	// A rescheduled premiere starts again.
+	if err := cfg.db.ResetPremiere(videoID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+	cfg.premieres.notify()
+	cfg.emitEvent(eventVideoUpdated, videoID, nil)
+
+	video, err = cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	video, err = cfg.dbVideoToSignedVideo(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URLs", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, video)
+}
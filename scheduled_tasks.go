@@ -0,0 +1,442 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/cron"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// The recurring maintenance tasks run on one scheduler: the janitor,
+// expiring upload sessions, purging the trash, database backups, the dead
+// link sweep and warehouse exports. Each runs every interval its own
+// setting gives, unless CRON_SCHEDULES gives it a cron expression:
+//
+//	CRON_SCHEDULES="janitor=0 3 * * *;database_backup=30 2 * * *"
+//
+// When each task is due next, and whether an admin paused it, is kept in
+// the database, so a task that came due while the server was down runs
+// once when it starts again. Every run, and the result it reported, is
+// recorded, and admins can run a task at once.
+
+// How a task's run was started.
+const (
+	taskTriggerSchedule = "schedule"
+	taskTriggerManual   = "manual"
+)
+
+// scheduledTask is a recurring task. A run's result is recorded with it,
+// so it should marshal to a summary of what the run did.
+type scheduledTask struct {
+	name        string
+	description string
+	schedule    cron.Schedule
+	run         func(ctx context.Context) (any, error)
+}
+
+// taskScheduler runs the scheduled tasks as they come due.
+type taskScheduler struct {
+	tasks map[string]*scheduledTask
+	// names are the tasks' names in the order they were added.
+	names []string
+	wake  chan struct{}
+
+	mu      sync.Mutex
+	ctx     context.Context
+	paused  map[string]bool
+	next    map[string]time.Time
+	running map[string]bool
+}
+
+// newTaskScheduler schedules tasks, with the schedules in overrides, a
+// CRON_SCHEDULES value, replacing theirs. Tasks without a schedule are
+// off.
+func newTaskScheduler(tasks []*scheduledTask, overrides string) (*taskScheduler, error) {
+	byName := map[string]*scheduledTask{}
+	for _, task := range tasks {
+		byName[task.name] = task
+	}
+	for _, entry := range strings.Split(overrides, ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		name, spec, ok := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		if !ok {
+			return nil, fmt.Errorf("invalid CRON_SCHEDULES entry %q: expected name=schedule", entry)
+		}
+		task, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("CRON_SCHEDULES: no task %q, or it isn't configured", name)
+		}
+		schedule, err := cron.Parse(spec)
+		if err != nil {
+			return nil, fmt.Errorf("CRON_SCHEDULES: %w", err)
+		}
+		task.schedule = schedule
+	}
+
+	s := &taskScheduler{
+		tasks:   map[string]*scheduledTask{},
+		wake:    make(chan struct{}, 1),
+		paused:  map[string]bool{},
+		next:    map[string]time.Time{},
+		running: map[string]bool{},
+	}
+	for _, task := range tasks {
+		if task.schedule == nil {
+			continue
+		}
+		s.tasks[task.name] = task
+		s.names = append(s.names, task.name)
+	}
+	return s, nil
+}
+
+// intervalSchedule is the schedule of a task run every interval, or none
+// if interval is 0.
+func intervalSchedule(interval time.Duration) cron.Schedule {
+	if interval <= 0 {
+		return nil
+	}
+	return cron.Every(interval)
+}
+
+// notify wakes the scheduler after a task was paused or resumed, if it
+// isn't awake already.
+func (s *taskScheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// runScheduler runs the scheduled tasks as they come due until ctx is
+// done. Runs a restart interrupted are recorded as such.
+func (cfg *apiConfig) runScheduler(ctx context.Context) {
+	s := cfg.scheduler
+	cfg.loadScheduledTasks(ctx)
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		case <-s.wake:
+		}
+		for _, task := range cfg.dueScheduledTasks(cfg.clock.Now().UTC()) {
+			if _, ok := cfg.startScheduledTask(task, taskTriggerSchedule); !ok {
+				log.Printf("Skipped scheduled task %s: its last run hasn't finished", task.name)
+			}
+		}
+		timer.Reset(s.untilNext(cfg.clock.Now()))
+	}
+}
+
+// loadScheduledTasks restores each task's state. A task whose schedule
+// changed since it was saved, or that hasn't been saved, is due next by
+// its schedule from now, as is every task if the states can't be read.
+func (cfg *apiConfig) loadScheduledTasks(ctx context.Context) {
+	s := cfg.scheduler
+	now := cfg.clock.Now().UTC()
+	if err := cfg.db.InterruptTaskRuns(now); err != nil {
+		log.Printf("Couldn't record interrupted task runs: %v", err)
+	}
+	states, err := cfg.db.GetScheduledTaskStates()
+	if err != nil {
+		log.Printf("Couldn't load scheduled tasks: %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ctx = ctx
+	for _, name := range s.names {
+		task := s.tasks[name]
+		state, ok := states[name]
+		s.paused[name] = state.Paused
+		if ok && state.Schedule == task.schedule.String() && state.NextRunAt != nil {
+			s.next[name] = state.NextRunAt.UTC()
+			continue
+		}
+		s.next[name] = task.schedule.Next(now)
+		if err := cfg.saveScheduledTaskLocked(name); err != nil {
+			log.Printf("Couldn't save scheduled task %s: %v", name, err)
+		}
+	}
+}
+
+// dueScheduledTasks returns the tasks due at now that aren't paused, and
+// moves each on to its next time. A task that came due more than once
+// while the server was down only runs once.
+func (cfg *apiConfig) dueScheduledTasks(now time.Time) []*scheduledTask {
+	s := cfg.scheduler
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	due := []*scheduledTask{}
+	for _, name := range s.names {
+		next, ok := s.next[name]
+		if !ok || s.paused[name] || next.After(now) {
+			continue
+		}
+		task := s.tasks[name]
+		s.next[name] = task.schedule.Next(now)
+		if err := cfg.saveScheduledTaskLocked(name); err != nil {
+			log.Printf("Couldn't save scheduled task %s: %v", name, err)
+		}
+		due = append(due, task)
+	}
+	return due
+}
+
+// untilNext returns how long until the next task that isn't paused is due.
+func (s *taskScheduler) untilNext(now time.Time) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	wait := time.Hour
+	for _, name := range s.names {
+		if next, ok := s.next[name]; ok && !s.paused[name] {
+			wait = min(wait, next.Sub(now))
+		}
+	}
+	return max(wait, 0)
+}
+
+// saveScheduledTaskLocked saves a task's state. s.mu must be held.
+func (cfg *apiConfig) saveScheduledTaskLocked(name string) error {
+	s := cfg.scheduler
+	state := database.ScheduledTaskState{
+		Name:     name,
+		Schedule: s.tasks[name].schedule.String(),
+		Paused:   s.paused[name],
+	}
+	if next, ok := s.next[name]; ok {
+		state.NextRunAt = &next
+	}
+	return cfg.db.SetScheduledTaskState(state)
+}
+
+// startScheduledTask runs task in the background and returns the run it
+// recorded. ok is false if the task is already running.
+func (cfg *apiConfig) startScheduledTask(task *scheduledTask, trigger string) (run database.TaskRun, ok bool) {
+	s := cfg.scheduler
+	s.mu.Lock()
+	if s.running[task.name] || s.ctx == nil {
+		s.mu.Unlock()
+		return run, false
+	}
+	s.running[task.name] = true
+	ctx := s.ctx
+	s.mu.Unlock()
+
+	run = database.TaskRun{
+		ID:        uuid.New(),
+		Task:      task.name,
+		Trigger:   trigger,
+		Status:    database.TaskRunRunning,
+		StartedAt: cfg.clock.Now().UTC(),
+	}
+	if err := cfg.db.CreateTaskRun(run); err != nil {
+		log.Printf("Couldn't record run of scheduled task %s: %v", task.name, err)
+	}
+	cfg.background.run(func() {
+		defer func() {
+			s.mu.Lock()
+			delete(s.running, task.name)
+			s.mu.Unlock()
+		}()
+		cfg.finishScheduledTask(ctx, task, run)
+	})
+	return run, true
+}
+
+// finishScheduledTask runs task and records how the run went.
+func (cfg *apiConfig) finishScheduledTask(ctx context.Context, task *scheduledTask, run database.TaskRun) {
+	result, err := task.run(ctx)
+	finished := cfg.clock.Now().UTC()
+	run.FinishedAt = &finished
+	run.DurationMS = finished.Sub(run.StartedAt).Milliseconds()
+	run.Status = database.TaskRunSucceeded
+	if err != nil {
+		run.Status = database.TaskRunFailed
+		run.Error = err.Error()
+		log.Printf("Scheduled task %s failed: %v", task.name, err)
+	}
+	if result != nil {
+		raw, err := json.Marshal(result)
+		if err != nil {
+			log.Printf("Couldn't encode result of scheduled task %s: %v", task.name, err)
+		} else {
+			run.Result = raw
+		}
+	}
+	if err := cfg.db.FinishTaskRun(run); err != nil {
+		log.Printf("Couldn't record run of scheduled task %s: %v", task.name, err)
+	}
+}
+
+// scheduledTaskStatus is a task as the admin API lists it. NextRunAt is
+// when it's due next, paused or not.
+type scheduledTaskStatus struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Schedule    string            `json:"schedule"`
+	Paused      bool              `json:"paused"`
+	Running     bool              `json:"running"`
+	NextRunAt   *time.Time        `json:"next_run_at,omitempty"`
+	LastRun     *database.TaskRun `json:"last_run"`
+}
+
+func (cfg *apiConfig) scheduledTaskStatus(task *scheduledTask) (scheduledTaskStatus, error) {
+	s := cfg.scheduler
+	status := scheduledTaskStatus{
+		Name:        task.name,
+		Description: task.description,
+		Schedule:    task.schedule.String(),
+	}
+	s.mu.Lock()
+	status.Paused = s.paused[task.name]
+	status.Running = s.running[task.name]
+	if next, ok := s.next[task.name]; ok {
+		status.NextRunAt = &next
+	}
+	s.mu.Unlock()
+
+	last, err := cfg.db.GetLastTaskRun(task.name)
+	if err != nil {
+		return status, err
+	}
+	status.LastRun = last
+	return status, nil
+}
+
+// getScheduledTask returns the task named in the path, responding with a
+// 404 if there isn't one.
+func (cfg *apiConfig) getScheduledTask(w http.ResponseWriter, r *http.Request) (*scheduledTask, bool) {
+	task, ok := cfg.scheduler.tasks[r.PathValue("name")]
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Scheduled task not found", nil)
+		return nil, false
+	}
+	return task, true
+}
+
+// handlerScheduledTasksList lists the scheduled tasks, each with its last
+// run.
+func (cfg *apiConfig) handlerScheduledTasksList(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+	statuses := []scheduledTaskStatus{}
+	for _, name := range cfg.scheduler.names {
+		status, err := cfg.scheduledTaskStatus(cfg.scheduler.tasks[name])
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get scheduled tasks", err)
+			return
+		}
+		statuses = append(statuses, status)
+	}
+	respondWithJSON(w, http.StatusOK, statuses)
+}
+
+// handlerScheduledTaskGet returns a scheduled task and its last run.
+func (cfg *apiConfig) handlerScheduledTaskGet(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+	cfg.respondWithScheduledTask(w, r)
+}
+
+// handlerScheduledTaskRuns returns a task's recorded runs, newest first.
+func (cfg *apiConfig) handlerScheduledTaskRuns(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+	task, ok := cfg.getScheduledTask(w, r)
+	if !ok {
+		return
+	}
+	runs, err := cfg.db.GetTaskRuns(task.name, 50)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get scheduled task runs", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, runs)
+}
+
+// handlerScheduledTaskRun runs a task now, paused or not, responding with
+// the run it started. It doesn't change when the task is due next.
+func (cfg *apiConfig) handlerScheduledTaskRun(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+	task, ok := cfg.getScheduledTask(w, r)
+	if !ok {
+		return
+	}
+	run, ok := cfg.startScheduledTask(task, taskTriggerManual)
+	if !ok {
+		respondWithError(w, http.StatusConflict, "Scheduled task is already running", nil)
+		return
+	}
+	respondWithJSON(w, http.StatusAccepted, run)
+}
+
+// handlerScheduledTaskPause stops a task from running on its schedule
+// until it's resumed. A run in progress finishes.
+func (cfg *apiConfig) handlerScheduledTaskPause(w http.ResponseWriter, r *http.Request) {
+	cfg.setScheduledTaskPaused(w, r, true)
+}
+
+// handlerScheduledTaskResume puts a paused task back on its schedule. If
+// it came due while paused, it's due next by its schedule from now rather
+// than at once.
+func (cfg *apiConfig) handlerScheduledTaskResume(w http.ResponseWriter, r *http.Request) {
+	cfg.setScheduledTaskPaused(w, r, false)
+}
+
+func (cfg *apiConfig) setScheduledTaskPaused(w http.ResponseWriter, r *http.Request, paused bool) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+	task, ok := cfg.getScheduledTask(w, r)
+	if !ok {
+		return
+	}
+	s := cfg.scheduler
+	s.mu.Lock()
+	s.paused[task.name] = paused
+	now := cfg.clock.Now().UTC()
+	if next, ok := s.next[task.name]; !paused && (!ok || !next.After(now)) {
+		s.next[task.name] = task.schedule.Next(now)
+	}
+	err := cfg.saveScheduledTaskLocked(task.name)
+	s.mu.Unlock()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save scheduled task", err)
+		return
+	}
+	s.notify()
+	cfg.respondWithScheduledTask(w, r)
+}
+
+func (cfg *apiConfig) respondWithScheduledTask(w http.ResponseWriter, r *http.Request) {
+	task, ok := cfg.getScheduledTask(w, r)
+	if !ok {
+		return
+	}
+	status, err := cfg.scheduledTaskStatus(task)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get scheduled task", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, status)
+}
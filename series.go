@@ -0,0 +1,459 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+type seriesParams struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+}
+
+// applySeriesParams validates params and copies them onto s. If it returns
+// false, an error response has been written.
+func applySeriesParams(w http.ResponseWriter, params seriesParams, s *database.Series) bool {
+	title, err := titleLimit.apply(params.Title)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return false
+	}
+	description, err := descriptionLimit.apply(params.Description)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return false
+	}
+	s.Title, s.Description = title, description
+	return true
+}
+
+// getSeries returns the series with the given ID. If ok is false, an error
+// response has been written.
+func (cfg *apiConfig) getSeries(w http.ResponseWriter, id string) (*database.Series, bool) {
+	seriesID, err := uuid.Parse(id)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid series ID", err)
+		return nil, false
+	}
+	series, err := cfg.db.GetSeries(seriesID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get series", err)
+		return nil, false
+	}
+	if series == nil {
+		respondWithError(w, http.StatusNotFound, "Series not found", nil)
+		return nil, false
+	}
+	return series, true
+}
+
+// userSeries returns userID's series with the given ID, for adding videos
+// to or changing. If ok is false, an error response has been written.
+func (cfg *apiConfig) userSeries(w http.ResponseWriter, userID uuid.UUID, id string) (*database.Series, bool) {
+	series, ok := cfg.getSeries(w, id)
+	if !ok {
+		return nil, false
+	}
+	if series.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't change this series", nil)
+		return nil, false
+	}
+	return series, true
+}
+
+func (cfg *apiConfig) handlerSeriesCreate(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.requireUser(w, r)
+	if !ok {
+		return
+	}
+	params := seriesParams{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	now := cfg.clock.Now().UTC()
+	series := database.Series{ID: uuid.New(), UserID: userID, CreatedAt: now, UpdatedAt: now}
+	if !applySeriesParams(w, params, &series) {
+		return
+	}
+	if err := cfg.db.CreateSeries(series); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save series", err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, series)
+}
+
+// handlerSeriesList lists the requesting user's series.
+func (cfg *apiConfig) handlerSeriesList(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.requireUser(w, r)
+	if !ok {
+		return
+	}
+	series, err := cfg.db.GetUserSeries(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get series", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, series)
+}
+
+// seriesEpisode is an episode as the series endpoint returns it.
+type seriesEpisode struct {
+	EpisodeNumber int `json:"episode_number"`
+	database.Video
+}
+
+// handlerSeriesGet returns a series and, in order, the episodes the viewer
+// can see.
+func (cfg *apiConfig) handlerSeriesGet(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		database.Series
+		Episodes []seriesEpisode `json:"episodes"`
+	}
+
+	series, ok := cfg.getSeries(w, r.PathValue("seriesID"))
+	if !ok {
+		return
+	}
+	episodes, err := cfg.db.GetEpisodes(series.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get episodes", err)
+		return
+	}
+
+	resp := response{Series: *series, Episodes: []seriesEpisode{}}
+	for _, episode := range episodes {
+		video, err := cfg.db.GetVideo(episode.VideoID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+			return
+		}
+		if video.ID == uuid.Nil || !cfg.canList(r, video) {
+			continue
+		}
+		video, err = cfg.dbVideoToSignedVideo(r.Context(), video)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URLs", err)
+			return
+		}
+		resp.Episodes = append(resp.Episodes, seriesEpisode{EpisodeNumber: episode.EpisodeNumber, Video: video})
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+func (cfg *apiConfig) handlerSeriesUpdate(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.requireUser(w, r)
+	if !ok {
+		return
+	}
+	series, ok := cfg.userSeries(w, userID, r.PathValue("seriesID"))
+	if !ok {
+		return
+	}
+	params := seriesParams{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if !applySeriesParams(w, params, series) {
+		return
+	}
+	series.UpdatedAt = cfg.clock.Now().UTC()
+	if err := cfg.db.UpdateSeries(*series); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save series", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, series)
+}
+
+// handlerSeriesDelete deletes a series, keeping its videos.
+func (cfg *apiConfig) handlerSeriesDelete(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.requireUser(w, r)
+	if !ok {
+		return
+	}
+	series, ok := cfg.userSeries(w, userID, r.PathValue("seriesID"))
+	if !ok {
+		return
+	}
+	if err := cfg.db.DeleteSeries(series.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete series", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// addEpisode adds video to series as the given episode, or the next one if
+// number is 0. If ok is false, an error response has been written.
+func (cfg *apiConfig) addEpisode(w http.ResponseWriter, series *database.Series, video database.Video, number int) (database.Episode, bool) {
+	if number < 0 {
+		respondWithError(w, http.StatusBadRequest, "Invalid episode number", nil)
+		return database.Episode{}, false
+	}
+	current, err := cfg.db.GetVideoEpisode(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get episodes", err)
+		return database.Episode{}, false
+	}
+	if current != nil {
+		respondWithError(w, http.StatusConflict, "Video is already in a series", nil)
+		return database.Episode{}, false
+	}
+	if number > 0 {
+		episodes, err := cfg.db.GetEpisodes(series.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get episodes", err)
+			return database.Episode{}, false
+		}
+		if slices.ContainsFunc(episodes, func(e database.Episode) bool { return e.EpisodeNumber == number }) {
+			respondWithError(w, http.StatusConflict, "Episode number is taken", nil)
+			return database.Episode{}, false
+		}
+	}
+	number, err = cfg.db.AddEpisode(series.ID, video.ID, number)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't add episode", err)
+		return database.Episode{}, false
+	}
+	return database.Episode{SeriesID: series.ID, VideoID: video.ID, EpisodeNumber: number}, true
+}
+
+// handlerSeriesEpisodeAdd adds one of the user's videos to their series.
+// Without an episode_number, it becomes the episode after the last.
+func (cfg *apiConfig) handlerSeriesEpisodeAdd(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		VideoID       uuid.UUID `json:"video_id"`
+		EpisodeNumber int       `json:"episode_number"`
+	}
+
+	userID, ok := cfg.requireUser(w, r)
+	if !ok {
+		return
+	}
+	series, ok := cfg.userSeries(w, userID, r.PathValue("seriesID"))
+	if !ok {
+		return
+	}
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	video, err := cfg.db.GetVideo(params.VideoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't update this video", nil)
+		return
+	}
+	episode, ok := cfg.addEpisode(w, series, video, params.EpisodeNumber)
+	if !ok {
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, episode)
+}
+
+// handlerSeriesEpisodeRemove takes a video out of a series. The other
+// episodes keep their numbers.
+func (cfg *apiConfig) handlerSeriesEpisodeRemove(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.requireUser(w, r)
+	if !ok {
+		return
+	}
+	series, ok := cfg.userSeries(w, userID, r.PathValue("seriesID"))
+	if !ok {
+		return
+	}
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+	episode, err := cfg.db.GetVideoEpisode(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get episodes", err)
+		return
+	}
+	if episode == nil || episode.SeriesID != series.ID {
+		respondWithError(w, http.StatusNotFound, "Episode not found", nil)
+		return
+	}
+	if err := cfg.db.RemoveEpisode(videoID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't remove episode", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type seriesFeed struct {
+	XMLName     xml.Name          `xml:"rss"`
+	Version     string            `xml:"version,attr"`
+	XmlnsMedia  string            `xml:"xmlns:media,attr"`
+	XmlnsItunes string            `xml:"xmlns:itunes,attr"`
+	Channel     seriesFeedChannel `xml:"channel"`
+}
+
+type seriesFeedChannel struct {
+	Title         string             `xml:"title"`
+	Link          string             `xml:"link"`
+	Description   string             `xml:"description"`
+	LastBuildDate string             `xml:"lastBuildDate"`
+	Image         *seriesFeedImage   `xml:"image,omitempty"`
+	ItunesImage   *seriesFeedHrefURL `xml:"itunes:image,omitempty"`
+	Items         []seriesFeedItem   `xml:"item"`
+}
+
+type seriesFeedImage struct {
+	URL   string `xml:"url"`
+	Title string `xml:"title"`
+	Link  string `xml:"link"`
+}
+
+type seriesFeedHrefURL struct {
+	Href string `xml:"href,attr"`
+}
+
+type seriesFeedItem struct {
+	Title       string              `xml:"title"`
+	Link        string              `xml:"link"`
+	GUID        seriesFeedGUID      `xml:"guid"`
+	PubDate     string              `xml:"pubDate"`
+	Description string              `xml:"description"`
+	Episode     int                 `xml:"itunes:episode"`
+	Content     *seriesFeedContent  `xml:"media:content,omitempty"`
+	Thumbnail   *seriesFeedMediaURL `xml:"media:thumbnail,omitempty"`
+	Keywords    string              `xml:"media:keywords,omitempty"`
+	Rating      *seriesFeedRating   `xml:"media:rating,omitempty"`
+}
+
+type seriesFeedGUID struct {
+	IsPermaLink string `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type seriesFeedContent struct {
+	URL      string `xml:"url,attr"`
+	Type     string `xml:"type,attr"`
+	Medium   string `xml:"medium,attr"`
+	Duration int    `xml:"duration,attr,omitempty"`
+}
+
+type seriesFeedMediaURL struct {
+	URL string `xml:"url,attr"`
+}
+
+type seriesFeedRating struct {
+	Scheme string `xml:"scheme,attr"`
+	Value  string `xml:",chardata"`
+}
+
+// seriesFeedItem returns an episode as the feed lists it, with the same
+// stable links the sitemap uses.
+func (cfg *apiConfig) seriesFeedItem(entry sitemapVideo, number int) seriesFeedItem {
+	u := cfg.sitemapURL(entry)
+	publishedAt := entry.CreatedAt
+	if entry.PublishAt != nil {
+		publishedAt = *entry.PublishAt
+	}
+	item := seriesFeedItem{
+		Title:       entry.Title,
+		Link:        u.Loc,
+		GUID:        seriesFeedGUID{IsPermaLink: "false", Value: entry.ID.String()},
+		PubDate:     publishedAt.UTC().Format(time.RFC1123Z),
+		Description: entry.Description,
+		Episode:     number,
+		Keywords:    strings.Join(entry.Tags, ", "),
+	}
+	if !entry.TokenRequired {
+		item.Content = &seriesFeedContent{
+			URL:      cfg.siteURL + "/api/videos/" + entry.ID.String() + "/playback",
+			Type:     "video/mp4",
+			Medium:   "video",
+			Duration: int(entry.DurationSeconds + 0.5),
+		}
+	}
+	if u.Video != nil {
+		item.Thumbnail = &seriesFeedMediaURL{URL: u.Video.ThumbnailLoc}
+	}
+	if entry.AgeRestricted {
+		item.Rating = &seriesFeedRating{Scheme: "urn:simple", Value: "adult"}
+	}
+	return item
+}
+
+// handlerSeriesFeed serves a series as an RSS feed with Media RSS, latest
+// episode first. Like the sitemap, it only lists public episodes with a
+// file.
+func (cfg *apiConfig) handlerSeriesFeed(w http.ResponseWriter, r *http.Request) {
+	series, ok := cfg.getSeries(w, r.PathValue("seriesID"))
+	if !ok {
+		return
+	}
+	episodes, err := cfg.db.GetEpisodes(series.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get episodes", err)
+		return
+	}
+
+	branding, err := cfg.userBranding(series.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get branding", err)
+		return
+	}
+
+	now := cfg.clock.Now()
+	channel := seriesFeedChannel{
+		Title:         feedTitle(branding, series.Title),
+		Link:          cfg.siteURL + "/api/series/" + series.ID.String(),
+		Description:   series.Description,
+		LastBuildDate: now.UTC().Format(time.RFC1123Z),
+		Items:         []seriesFeedItem{},
+	}
+	if logo := cfg.brandingLogoURL(branding); logo != "" {
+		channel.Image = &seriesFeedImage{URL: logo, Title: channel.Title, Link: channel.Link}
+		channel.ItunesImage = &seriesFeedHrefURL{Href: logo}
+	}
+	for _, episode := range slices.Backward(episodes) {
+		video, err := cfg.db.GetVideo(episode.VideoID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+			return
+		}
+		entry, ok, err := cfg.sitemapVideo(video)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't build feed", err)
+			return
+		}
+		if !ok || !entry.Published(now) {
+			continue
+		}
+		channel.Items = append(channel.Items, cfg.seriesFeedItem(entry, episode.EpisodeNumber))
+	}
+
+	out, err := xml.MarshalIndent(seriesFeed{
+		Version:     "2.0",
+		XmlnsMedia:  "http://search.yahoo.com/mrss/",
+		XmlnsItunes: "http://www.itunes.com/dtds/podcast-1.0.dtd",
+		Channel:     channel,
+	}, "", "  ")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't build feed", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+	w.Write(append([]byte(xml.Header), out...))
+}
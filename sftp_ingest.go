@@ -0,0 +1,394 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tenants"
+	"github.com/google/uuid"
+)
+
+// SFTP ingest lets legacy broadcast workflows, which deliver files over
+// SFTP, feed the processing pipeline. The SFTP server is AWS Transfer
+// Family, storing drops in SFTP_INGEST_BUCKET. Transfer Family reports
+// each finished upload to EventBridge, and a rule with an API destination
+// forwards the event to POST /api/hooks/sftp, authenticated with the
+// SFTP_INGEST_SECRET in X-Tubely-Ingest-Key. The SFTP user name, which an
+// admin links to a Tubely user, decides whose video the drop becomes.
+
+// sftpIngestConfig is set when SFTP ingest is enabled.
+type sftpIngestConfig struct {
+	bucket string
+	secret []byte
+	// client reads and deletes drops; it's the default target's.
+	client *s3.Client
+}
+
+const sftpEventMaxBody = 64 << 10
+
+// sftpUsernamePattern is what Transfer Family accepts as a user name.
+var sftpUsernamePattern = regexp.MustCompile(`^[\w][\w@.-]{2,99}$`)
+
+// transferEvent is the part of a Transfer Family "File Upload Completed"
+// EventBridge event that ingest needs. FilePath is /bucket/key.
+type transferEvent struct {
+	Source     string `json:"source"`
+	DetailType string `json:"detail-type"`
+	Detail     struct {
+		Username   string `json:"username"`
+		FilePath   string `json:"file-path"`
+		StatusCode string `json:"status-code"`
+	} `json:"detail"`
+}
+
+// handlerSFTPIngest turns a finished SFTP upload into a video of the user
+// the SFTP user is linked to, and queues it for processing. It responds
+// 202 with the video's ID once the drop is queued, without waiting for it
+// to be processed; the drop is deleted once it has been. A drop reported
+// again is only ingested once, and responds 200 with the same ID.
+//
+// EventBridge retries 5xx responses but not 4xx ones, so unusable drops
+// are rejected with 4xx.
+func (cfg *apiConfig) handlerSFTPIngest(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		VideoID   uuid.UUID `json:"video_id"`
+		Duplicate bool      `json:"duplicate,omitempty"`
+	}
+
+	if cfg.sftpIngest == nil {
+		respondWithError(w, http.StatusNotFound, "SFTP ingest is not configured", nil)
+		return
+	}
+	if !hmac.Equal([]byte(r.Header.Get("X-Tubely-Ingest-Key")), cfg.sftpIngest.secret) {
+		respondWithError(w, http.StatusUnauthorized, "Invalid ingest key", nil)
+		return
+	}
+	e := transferEvent{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, sftpEventMaxBody)).Decode(&e); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if e.Source != "aws.transfer" {
+		respondWithError(w, http.StatusBadRequest, "Unsupported event", fmt.Errorf("event from %q", e.Source))
+		return
+	}
+	if !strings.HasSuffix(e.DetailType, "File Upload Completed") || e.Detail.StatusCode != "COMPLETED" {
+		// Failed uploads, downloads and the like; nothing to ingest.
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(e.Detail.FilePath, "/"), "/")
+	if bucket != cfg.sftpIngest.bucket || key == "" {
+		respondWithError(w, http.StatusBadRequest, "File isn't in the SFTP ingest bucket", fmt.Errorf("file path %q", e.Detail.FilePath))
+		return
+	}
+	contentType := videoContentType(key)
+	if contentType == "" {
+		respondWithError(w, http.StatusBadRequest, "Invalid file type. This kind of video isn't allowed.", fmt.Errorf("file %q", key))
+		return
+	}
+
+	account, err := cfg.db.GetSFTPAccount(e.Detail.Username)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get SFTP account", err)
+		return
+	}
+	if account == nil {
+		respondWithError(w, http.StatusNotFound, "SFTP user isn't linked to an account", fmt.Errorf("user %q", e.Detail.Username))
+		return
+	}
+	user, err := cfg.db.GetUser(account.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+	if user.Suspended() {
+		respondWithError(w, http.StatusForbidden, accountSuspendedMessage, nil)
+		return
+	}
+
+	head, err := cfg.sftpIngest.client.HeadObject(r.Context(), &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		respondWithStorageError(w, http.StatusBadGateway, "Couldn't get SFTP upload", err)
+		return
+	}
+	etag := strings.Trim(aws.ToString(head.ETag), `"`)
+	size := aws.ToInt64(head.ContentLength)
+	if ingest, err := cfg.db.GetSFTPIngest(bucket, key, etag); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get SFTP ingest", err)
+		return
+	} else if ingest != nil {
+		respondWithJSON(w, http.StatusOK, response{VideoID: ingest.VideoID, Duplicate: true})
+		return
+	}
+
+	target, err := cfg.tenants.Target(r.Context(), user.TenantID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't resolve storage for tenant", err)
+		return
+	}
+	profileName, ok := cfg.uploadProfile(w, user.ID, "", "")
+	if !ok {
+		return
+	}
+	src := videoSource{
+		filename:    path.Base(key),
+		contentType: contentType,
+		profileName: profileName,
+	}
+	if _, _, ok := cfg.checkVideoSource(w, src); !ok {
+		return
+	}
+	if !cfg.requireStorageQuota(w, user.ID, uuid.Nil, database.StorageKindVideo, size) {
+		return
+	}
+	// A drop turned away is reported again by the SFTP server later.
+	release, ok := cfg.admitUpload(w)
+	if !ok {
+		return
+	}
+	started := false
+	defer func() {
+		if !started {
+			release()
+		}
+	}()
+
+	params := database.CreateVideoParams{
+		Title:  sftpTitle(key),
+		Tags:   []string{},
+		UserID: user.ID,
+	}
+	if err := normalizeVideoParams(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	video, err := cfg.db.CreateVideo(params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create video", err)
+		return
+	}
+	created, err := cfg.db.CreateSFTPIngest(database.SFTPIngest{
+		Bucket:    bucket,
+		Key:       key,
+		ETag:      etag,
+		UserID:    user.ID,
+		VideoID:   video.ID,
+		CreatedAt: cfg.clock.Now().UTC(),
+	})
+	if err != nil || !created {
+		if err := cfg.db.DeleteVideo(video.ID); err != nil {
+			log.Printf("Couldn't delete video %s of SFTP ingest: %v", video.ID, err)
+		}
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save SFTP ingest", err)
+		return
+	}
+	if !created {
+		// Another report of the same drop got there first.
+		ingest, err := cfg.db.GetSFTPIngest(bucket, key, etag)
+		if err != nil || ingest == nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get SFTP ingest", err)
+			return
+		}
+		respondWithJSON(w, http.StatusOK, response{VideoID: ingest.VideoID, Duplicate: true})
+		return
+	}
+	if _, err := cfg.db.QueueVideoProcessing(video.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to update video metadata", err)
+		return
+	}
+	cfg.recordActivity(user.ID, activityVideoCreated, &video.ID, nil, video.Title)
+	cfg.emitEvent(eventVideoUploaded, video.ID, map[string]any{"source": "sftp", "size": size})
+
+	started = true
+	cfg.background.run(func() { cfg.runSFTPIngest(r, video.ID, target, src, bucket, key, release) })
+	respondWithJSON(w, http.StatusAccepted, response{VideoID: video.ID})
+}
+
+// sftpTitle titles a drop after its file name.
+func sftpTitle(key string) string {
+	return ingestTitle(strings.TrimSuffix(path.Base(key), path.Ext(key)), "SFTP upload")
+}
+
+// ingestTitle cuts title to fit, or returns fallback if it's empty.
+func ingestTitle(title, fallback string) string {
+	runes := []rune(strings.TrimSpace(title))
+	if len(runes) > titleLimit.maxRunes {
+		runes = runes[:titleLimit.maxRunes]
+	}
+	if len(runes) == 0 {
+		return fallback
+	}
+	return string(runes)
+}
+
+// runSFTPIngest downloads a drop to the upload spool and processes it like
+// an upload. r is the request that reported the drop; the job outlives it.
+// release is the drop's place in the processing queue.
+func (cfg *apiConfig) runSFTPIngest(r *http.Request, videoID uuid.UUID, target tenants.Target, src videoSource, bucket, key string, release func()) {
+	plog := newProcessingLog(videoID, "sftp")
+	plog.release = release
+	rawPath, err := cfg.downloadSFTPDrop(plog.context(context.Background()), videoID, bucket, key)
+	if err != nil {
+		rec := &errorRecorder{ResponseWriter: &discardResponseWriter{}}
+		respondWithStorageError(rec, http.StatusBadGateway, "Couldn't download SFTP upload", err)
+		cfg.setVideoProcessing(videoID, database.VideoFailed, rec.message())
+		cfg.saveProcessingLog(plog, rec.failure())
+		return
+	}
+	cfg.runUploadJob(r, uploadJob{
+		videoID: videoID,
+		target:  target,
+		src:     src,
+		rawPath: rawPath,
+		plog:    plog,
+	})
+
+	// A drop that failed is kept, for the workflow's operators to look at.
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil || video.ProcessingStatus != database.VideoReady {
+		return
+	}
+	_, err = cfg.sftpIngest.client.DeleteObject(context.Background(), &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		log.Printf("Couldn't delete SFTP upload s3://%s/%s: %v", bucket, key, err)
+	}
+}
+
+// downloadSFTPDrop copies a drop into the upload spool and returns the
+// copy's path.
+func (cfg *apiConfig) downloadSFTPDrop(ctx context.Context, videoID uuid.UUID, bucket, key string) (string, error) {
+	start := time.Now()
+	path, err := cfg.copySFTPDrop(ctx, videoID, bucket, key)
+	logStep(ctx, "download", fmt.Sprintf("s3://%s/%s", bucket, key), start, err)
+	return path, err
+}
+
+func (cfg *apiConfig) copySFTPDrop(ctx context.Context, videoID uuid.UUID, bucket, key string) (string, error) {
+	obj, err := cfg.sftpIngest.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return "", err
+	}
+	defer obj.Body.Close()
+
+	if err := os.MkdirAll(cfg.uploadSpoolDir, 0700); err != nil {
+		return "", err
+	}
+	raw, err := os.CreateTemp(cfg.uploadSpoolDir, videoID.String()+"-"+rawUploadPattern)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(raw, obj.Body)
+	if closeErr := raw.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(raw.Name())
+		return "", err
+	}
+	return raw.Name(), nil
+}
+
+// handlerAdminSFTPAccountsList lists the linked SFTP user names.
+func (cfg *apiConfig) handlerAdminSFTPAccountsList(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+	accounts, err := cfg.db.GetSFTPAccounts()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get SFTP accounts", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, accounts)
+}
+
+// handlerAdminSetUserSFTPAccount links a user to the SFTP user name whose
+// drops become their videos, replacing any they had. An empty username
+// unlinks them.
+func (cfg *apiConfig) handlerAdminSetUserSFTPAccount(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Username string `json:"username"`
+	}
+
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Username != "" && !sftpUsernamePattern.MatchString(params.Username) {
+		respondWithError(w, http.StatusBadRequest, "Invalid SFTP username", nil)
+		return
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+
+	if params.Username == "" {
+		if err := cfg.db.DeleteSFTPAccount(userID); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update SFTP account", err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	existing, err := cfg.db.GetSFTPAccount(params.Username)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get SFTP account", err)
+		return
+	}
+	if existing != nil && existing.UserID != userID {
+		respondWithError(w, http.StatusConflict, "SFTP username is linked to another user", nil)
+		return
+	}
+	account := database.SFTPAccount{Username: params.Username, UserID: userID, CreatedAt: cfg.clock.Now().UTC()}
+	if existing != nil {
+		account = *existing
+	} else if err := cfg.db.SetSFTPAccount(account); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update SFTP account", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, account)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ffmpeg"
+)
+
+// shortsPreviewSeconds is the length of the looping preview clip.
+const shortsPreviewSeconds = 3.0
+
+// shortOutput is one encoded file produced by processShort.
+type shortOutput struct {
+	rung ffmpeg.Rung
+	path string
+}
+
+// shortOutputs holds the files produced for a short: its ladder, largest
+// rung first, and the looping preview.
+type shortOutputs struct {
+	ladder  []shortOutput
+	preview string
+}
+
+func (o shortOutputs) remove() {
+	for _, r := range o.ladder {
+		os.Remove(r.path)
+	}
+	if o.preview != "" {
+		os.Remove(o.preview)
+	}
+}
+
+// isShortEligible reports whether a video with the given aspect ratio and
+// duration can go through the shorts pipeline.
+func (cfg *apiConfig) isShortEligible(aspectRatio string, duration float64) bool {
+	return aspectRatio == "portrait" && duration > 0 && duration <= cfg.shortsMaxDuration.Seconds()
+}
+
+// processShort encodes every rung of the shorts ladder that fits the source
+// and cuts the looping preview from the largest one. On error, any files
+// already written are removed.
+func (cfg *apiConfig) processShort(ctx context.Context, filePath string) (shortOutputs, error) {
+	probeOutput, err := cfg.probeVideo(ctx, filePath)
+	if err != nil {
+		return shortOutputs{}, err
+	}
+	stream, _ := probeOutput.firstStream("video")
+
+	var out shortOutputs
+	for _, rung := range ffmpeg.LadderFor(stream.Height) {
+		outputPath := fmt.Sprintf("%s.%s", filePath, rung.Name)
+		if _, err := ffmpeg.PortraitCommand(filePath, outputPath, rung).Run(ctx); err != nil {
+			out.remove()
+			return shortOutputs{}, err
+		}
+		out.ladder = append(out.ladder, shortOutput{rung: rung, path: outputPath})
+	}
+
+	out.preview = filePath + ".preview"
+	if _, err := ffmpeg.LoopPreviewCommand(out.ladder[0].path, out.preview, shortsPreviewSeconds).Run(ctx); err != nil {
+		out.remove()
+		return shortOutputs{}, err
+	}
+	return out, nil
+}
+
+// handlerShortsList lists the authenticated user's portrait videos.
+func (cfg *apiConfig) handlerShortsList(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	videos, err := cfg.db.GetVideosByAspectRatio(userID, "portrait")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve shorts", err)
+		return
+	}
+
+	videos, err = cfg.dbVideosToSignedVideos(r.Context(), videos)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URLs", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, videos)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// backgroundJobs tracks the work that outlives the request or loop that
+// started it, such as processing an upload, so shutdown can wait for it.
+type backgroundJobs struct {
+	wg      sync.WaitGroup
+	running atomic.Int64
+}
+
+// run runs fn in its own goroutine and tracks it until it returns.
+func (b *backgroundJobs) run(fn func()) {
+	b.wg.Add(1)
+	b.running.Add(1)
+	go func() {
+		defer b.wg.Done()
+		defer b.running.Add(-1)
+		fn()
+	}()
+}
+
+// wait waits for every job to return. If ctx is done first, it returns
+// ctx's error and how many jobs are still running.
+func (b *backgroundJobs) wait(ctx context.Context) (int64, error) {
+	done := make(chan struct{})
+	go func() {
+		b.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return 0, nil
+	case <-ctx.Done():
+		return b.running.Load(), ctx.Err()
+	}
+}
+
+// serve runs srv until it fails or the process gets SIGINT or SIGTERM, and
+// then shuts down gracefully, see shutdown. A second signal kills the
+// process outright.
+func (cfg *apiConfig) serve(srv *http.Server, stopLoops context.CancelFunc, timeout time.Duration) error {
+	signals, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+
+	served := make(chan error, 1)
+	go func() { served <- srv.ListenAndServe() }()
+	select {
+	case err := <-served:
+		return err
+	case <-signals.Done():
+	}
+	stopSignals()
+	cfg.shutdown(srv, stopLoops, timeout)
+	return nil
+}
+
+// shutdown stops taking requests and waits for those in flight, uploads
+// included, to finish, since they may start background jobs. It then stops
+// the background loops, such as the janitor, and waits for the jobs still
+// running, such as processing uploads. It gives up after timeout: uploads
+// it cuts off are marked failed and their spooled files removed on the next
+// start.
+func (cfg *apiConfig) shutdown(srv *http.Server, stopLoops context.CancelFunc, timeout time.Duration) {
+	log.Printf("Shutting down, draining requests and background jobs for up to %v", timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("Requests were still in flight after %v: %v", timeout, err)
+	}
+	stopLoops()
+	if left, err := cfg.background.wait(ctx); err != nil {
+		log.Printf("%d background jobs were still running after %v: %v", left, timeout, err)
+		return
+	}
+	if err := cfg.db.Close(); err != nil {
+		log.Printf("Couldn't close database: %v", err)
+	}
+	log.Print("Shutdown complete")
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tenants"
+	"github.com/google/uuid"
+)
+
+// dbVideoToSignedVideo replaces the stored keys of video's files with
+// presigned GET URLs that expire after cfg.videoURLExpiry, so clients can
+// fetch them while the bucket stays private, and does the same for its
+// thumbnail, see thumbnailDeliveryURL. Every handler that returns a
+// video passes it through here first.
+func (cfg *apiConfig) dbVideoToSignedVideo(ctx context.Context, video database.Video) (database.Video, error) {
+	if video.VideoURL == nil && video.PreviewURL == nil && video.PeaksURL == nil && video.SDRVideoURL == nil && video.HLSURL == nil {
+		return cfg.signThumbnail(ctx, video)
+	}
+	target, err := cfg.videoTarget(ctx, video)
+	if err != nil {
+		return video, err
+	}
+	return cfg.signVideo(ctx, target, video)
+}
+
+// dbVideosToSignedVideos signs a list of videos, resolving each owner's
+// target once.
+func (cfg *apiConfig) dbVideosToSignedVideos(ctx context.Context, videos []database.Video) ([]database.Video, error) {
+	targets := map[uuid.UUID]tenants.Target{}
+	signed := make([]database.Video, 0, len(videos))
+	for _, video := range videos {
+		target, ok := targets[video.UserID]
+		if !ok {
+			var err error
+			target, err = cfg.videoTarget(ctx, video)
+			if err != nil {
+				return nil, err
+			}
+			targets[video.UserID] = target
+		}
+		video, err := cfg.signVideo(ctx, target, video)
+		if err != nil {
+			return nil, err
+		}
+		signed = append(signed, video)
+	}
+	return signed, nil
+}
+
+func (cfg *apiConfig) signVideo(ctx context.Context, target tenants.Target, video database.Video) (database.Video, error) {
+	video, err := cfg.signThumbnail(ctx, video)
+	if err != nil {
+		return video, err
+	}
+	// Viewers who haven't unlocked a passphrase-protected video only get
+	// its thumbnail.
+	unlockToken, ok := cfg.unlockedInContext(ctx, video)
+	if !ok {
+		video.VideoURL = nil
+		video.PreviewURL = nil
+		video.PeaksURL = nil
+		video.SDRVideoURL = nil
+		video.HLSURL = nil
+		return video, nil
+	}
+	video, err = cfg.signCaptions(ctx, target, video)
+	if err != nil {
+		return video, err
+	}
+	// URLs of the API's own endpoints carry the unlock token, so players
+	// needn't.
+	unlock := func() url.Values {
+		if unlockToken == "" {
+			return url.Values{}
+		}
+		return url.Values{unlockParam: {unlockToken}}
+	}
+	// Segments are signed when their playlist is loaded.
+	if video.HLSURL != nil {
+		playlistURL := hlsPlaylistURL(video.ID)
+		if q := cfg.playbackQuery(target, video, unlock()); len(q) > 0 {
+			playlistURL += "?" + q.Encode()
+		}
+		video.HLSURL = &playlistURL
+	}
+	stored := []**string{&video.VideoURL, &video.PreviewURL, &video.PeaksURL, &video.SDRVideoURL}
+	if cfg.streamVideos && video.VideoURL != nil {
+		streamURL := cfg.streamPath(target, video, unlock())
+		video.VideoURL = &streamURL
+		if video.SDRVideoURL != nil {
+			q := unlock()
+			q.Set("rendition", "sdr")
+			sdrURL := cfg.streamPath(target, video, q)
+			video.SDRVideoURL = &sdrURL
+		}
+		stored = stored[1:3]
+	}
+	for _, u := range stored {
+		if *u == nil {
+			continue
+		}
+		signed, err := cfg.signStoredURL(ctx, target, video, **u)
+		if err != nil {
+			return video, err
+		}
+		*u = &signed
+	}
+	return video, nil
+}
+
+// signThumbnail replaces video's thumbnail with the URL to fetch it from,
+// see thumbnailDeliveryURL, and fills in the srcset of its variants.
+func (cfg *apiConfig) signThumbnail(ctx context.Context, video database.Video) (database.Video, error) {
+	if video.ThumbnailURL == nil {
+		return video, nil
+	}
+	thumbnailURL, err := cfg.thumbnailDeliveryURL(ctx, video, *video.ThumbnailURL)
+	if err != nil {
+		return video, err
+	}
+	video.ThumbnailURL = &thumbnailURL
+
+	variants, err := cfg.db.GetThumbnailVariants(video.ID)
+	if err != nil {
+		return video, err
+	}
+	// Variants come widest first; srcset reads better narrowest first.
+	slices.Reverse(variants)
+	for _, v := range variants {
+		variantURL, err := cfg.thumbnailDeliveryURL(ctx, video, v.URL)
+		if err != nil {
+			return video, err
+		}
+		if video.ThumbnailSrcset == nil {
+			video.ThumbnailSrcset = map[string]string{}
+		}
+		candidate := fmt.Sprintf("%s %dw", variantURL, v.Width)
+		if set := video.ThumbnailSrcset[v.Format]; set != "" {
+			candidate = set + ", " + candidate
+		}
+		video.ThumbnailSrcset[v.Format] = candidate
+	}
+	return video, nil
+}
+
+// signStoredURL presigns a file of video recorded in the database, see
+// storedObjectKey and videoDeliveryURL. Anything that isn't in target is
+// returned as is.
+func (cfg *apiConfig) signStoredURL(ctx context.Context, target tenants.Target, video database.Video, stored string) (string, error) {
+	key, ok := storedObjectKey(target, stored)
+	if !ok {
+		return stored, nil
+	}
+	cacheKey := target.Bucket + "/" + key
+	now := cfg.clock.Now()
+	if signed, ok := cfg.signedURLs.get(video.ID, cacheKey, now); ok {
+		return signed, nil
+	}
+	signed, err := cfg.videoDeliveryURL(ctx, target, video, key)
+	if err != nil {
+		return "", err
+	}
+	cfg.signedURLs.put(video.ID, cacheKey, signedURL{url: signed, reuseUntil: now.Add(cfg.videoURLExpiry(video) / 2)}, now)
+	return signed, nil
+}
+
+// signedURLCache keeps the presigned URLs handed out for each video for
+// half their lifetime, so a video fetched repeatedly keeps the same URLs and
+// clients and proxies can cache its files. A nil cache keeps nothing.
+type signedURLCache struct {
+	mu     sync.Mutex
+	videos map[uuid.UUID]map[string]signedURL
+	puts   int
+}
+
+type signedURL struct {
+	url        string
+	reuseUntil time.Time
+}
+
+// signedURLCachePruneEvery is how many URLs are cached between sweeps for
+// expired ones, which drops the URLs of videos nobody fetches anymore.
+const signedURLCachePruneEvery = 1000
+
+func newSignedURLCache() *signedURLCache {
+	return &signedURLCache{videos: map[uuid.UUID]map[string]signedURL{}}
+}
+
+func (c *signedURLCache) get(videoID uuid.UUID, key string, now time.Time) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	u, ok := c.videos[videoID][key]
+	if !ok || !now.Before(u.reuseUntil) {
+		return "", false
+	}
+	return u.url, true
+}
+
+func (c *signedURLCache) put(videoID uuid.UUID, key string, u signedURL, now time.Time) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.puts++
+	if c.puts%signedURLCachePruneEvery == 0 {
+		for id, urls := range c.videos {
+			for k, cached := range urls {
+				if !now.Before(cached.reuseUntil) {
+					delete(urls, k)
+				}
+			}
+			if len(urls) == 0 {
+				delete(c.videos, id)
+			}
+		}
+	}
+	if c.videos[videoID] == nil {
+		c.videos[videoID] = map[string]signedURL{}
+	}
+	c.videos[videoID][key] = u
+}
+
+// invalidate drops the URLs cached for a video, so its next response is
+// signed afresh. It reports whether there were any.
+func (c *signedURLCache) invalidate(videoID uuid.UUID) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.videos[videoID]
+	delete(c.videos, videoID)
+	return ok
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// maxSitemapURLs is the most URLs the sitemap protocol allows in a file.
+// The most recently updated videos are listed first.
+const maxSitemapURLs = 50000
+
+// sitemapEvents are the events after which a video's sitemap entry is
+// reloaded.
+var sitemapEvents = map[string]bool{
+	eventVideoUpdated:  true,
+	eventVideoDeleted:  true,
+	eventVideoTrashed:  true,
+	eventVideoRestored: true,
+	eventVideoHeld:     true,
+	eventVideoReleased: true,
+}
+
+// siteMap is the sitemap of public videos. It is built from the database
+// the first time it is served. After that, events mark the videos they are
+// about, and only those are reloaded before the sitemap is served again.
+type siteMap struct {
+	mu       sync.Mutex
+	loaded   bool
+	videos   map[uuid.UUID]sitemapVideo
+	dirty    map[uuid.UUID]bool
+	rendered []byte
+	// staleAt is when the next scheduled video is published, changing the
+	// rendered sitemap. It is zero if no video is scheduled.
+	staleAt time.Time
+}
+
+// sitemapVideo is a video that goes in the sitemap once it is published.
+// TokenRequired is set if playing it needs a playback token, which crawlers
+// wouldn't have.
+type sitemapVideo struct {
+	database.Video
+	DurationSeconds float64
+	TokenRequired   bool
+}
+
+func newSiteMap() *siteMap {
+	return &siteMap{videos: map[uuid.UUID]sitemapVideo{}, dirty: map[uuid.UUID]bool{}}
+}
+
+// changed marks videoID for reloading.
+func (s *siteMap) changed(videoID uuid.UUID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.loaded {
+		s.dirty[videoID] = true
+		s.rendered = nil
+	}
+}
+
+// reset drops everything, so the sitemap is built from scratch again.
+func (s *siteMap) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.loaded = false
+	s.videos = map[uuid.UUID]sitemapVideo{}
+	s.dirty = map[uuid.UUID]bool{}
+	s.rendered = nil
+}
+
+// sitemapVideo returns video as the sitemap lists it, or false if it has no
+// file to index, is held for review, isn't public or needs a passphrase.
+func (cfg *apiConfig) sitemapVideo(video database.Video) (sitemapVideo, bool, error) {
+	if video.ID == uuid.Nil || video.VideoURL == nil || video.ModerationHold || video.Visibility != database.VisibilityPublic || video.PassphraseHash != "" {
+		return sitemapVideo{}, false, nil
+	}
+	target, err := cfg.videoTarget(context.Background(), video)
+	if err != nil {
+		return sitemapVideo{}, false, err
+	}
+	entry := sitemapVideo{Video: video, TokenRequired: target.Hotlink != nil && target.Hotlink.RequireToken}
+	info, err := cfg.db.GetMediaInfo(video.ID)
+	if err != nil {
+		return sitemapVideo{}, false, err
+	}
+	if info != nil {
+		var probe struct {
+			Format struct {
+				Duration string `json:"duration"`
+			} `json:"format"`
+		}
+		if json.Unmarshal(info.Output, &probe) == nil {
+			entry.DurationSeconds, _ = strconv.ParseFloat(probe.Format.Duration, 64)
+		}
+	}
+	return entry, true, nil
+}
+
+// sitemapXML returns the sitemap as of now, reloading the videos that
+// changed since it was last served.
+func (cfg *apiConfig) sitemapXML(now time.Time) ([]byte, error) {
+	s := cfg.sitemap
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.loaded {
+		videos, err := cfg.db.GetAllVideos()
+		if err != nil {
+			return nil, err
+		}
+		for _, video := range videos {
+			entry, ok, err := cfg.sitemapVideo(video)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				s.videos[video.ID] = entry
+			}
+		}
+		s.loaded = true
+	}
+	for videoID := range s.dirty {
+		video, err := cfg.db.GetVideo(videoID)
+		if err != nil {
+			return nil, err
+		}
+		entry, ok, err := cfg.sitemapVideo(video)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			s.videos[videoID] = entry
+		} else {
+			delete(s.videos, videoID)
+		}
+		delete(s.dirty, videoID)
+	}
+	if s.rendered != nil && (s.staleAt.IsZero() || now.Before(s.staleAt)) {
+		return s.rendered, nil
+	}
+
+	published := []sitemapVideo{}
+	s.staleAt = time.Time{}
+	for _, entry := range s.videos {
+		if entry.Published(now) {
+			published = append(published, entry)
+		} else if s.staleAt.IsZero() || entry.PublishAt.Before(s.staleAt) {
+			s.staleAt = *entry.PublishAt
+		}
+	}
+	slices.SortFunc(published, func(a, b sitemapVideo) int { return b.UpdatedAt.Compare(a.UpdatedAt) })
+	published = published[:min(maxSitemapURLs, len(published))]
+
+	set := sitemapURLSet{
+		Xmlns:      "http://www.sitemaps.org/schemas/sitemap/0.9",
+		XmlnsVideo: "http://www.google.com/schemas/sitemap-video/1.1",
+		URLs:       make([]sitemapURL, len(published)),
+	}
+	for i, entry := range published {
+		set.URLs[i] = cfg.sitemapURL(entry)
+	}
+	out, err := xml.MarshalIndent(set, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	s.rendered = append([]byte(xml.Header), out...)
+	return s.rendered, nil
+}
+
+type sitemapURLSet struct {
+	XMLName    xml.Name     `xml:"urlset"`
+	Xmlns      string       `xml:"xmlns,attr"`
+	XmlnsVideo string       `xml:"xmlns:video,attr"`
+	URLs       []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc     string             `xml:"loc"`
+	LastMod string             `xml:"lastmod"`
+	Video   *sitemapVideoEntry `xml:"video:video,omitempty"`
+}
+
+// sitemapVideoEntry is a video sitemap extension. It is only added for
+// videos with a thumbnail, which the extension requires, and that crawlers
+// can play.
+type sitemapVideoEntry struct {
+	ThumbnailLoc    string   `xml:"video:thumbnail_loc"`
+	Title           string   `xml:"video:title"`
+	Description     string   `xml:"video:description"`
+	ContentLoc      string   `xml:"video:content_loc"`
+	Duration        int      `xml:"video:duration,omitempty"`
+	PublicationDate string   `xml:"video:publication_date"`
+	FamilyFriendly  string   `xml:"video:family_friendly,omitempty"`
+	Tags            []string `xml:"video:tag"`
+}
+
+// maxSitemapDescription is the longest description the video extension
+// allows, in characters.
+const maxSitemapDescription = 2048
+
+func (cfg *apiConfig) sitemapURL(entry sitemapVideo) sitemapURL {
+	u := sitemapURL{
+		Loc:     strings.ReplaceAll(cfg.sitemapPageURL, "{id}", entry.ID.String()),
+		LastMod: entry.UpdatedAt.UTC().Format(time.RFC3339),
+	}
+	if entry.ThumbnailURL == nil || entry.TokenRequired {
+		return u
+	}
+	// Thumbnails in the bucket get the stable redirect, since the sitemap
+	// outlives presigned URLs.
+	thumbnail := cfg.cdnAssetURL(*entry.ThumbnailURL)
+	if strings.HasPrefix(thumbnail, thumbnailPrefix) {
+		thumbnail = fmt.Sprintf("/api/videos/%s/thumbnail", entry.ID)
+	}
+	if !strings.Contains(thumbnail, "://") {
+		thumbnail = cfg.siteURL + "/" + strings.TrimPrefix(thumbnail, "/")
+	}
+	publishedAt := entry.CreatedAt
+	if entry.PublishAt != nil {
+		publishedAt = *entry.PublishAt
+	}
+	description := entry.Description
+	if description == "" {
+		description = entry.Title
+	}
+	if runes := []rune(description); len(runes) > maxSitemapDescription {
+		description = string(runes[:maxSitemapDescription])
+	}
+	u.Video = &sitemapVideoEntry{
+		ThumbnailLoc: thumbnail,
+		Title:        entry.Title,
+		Description:  description,
+		// The playback endpoint redirects to a fresh presigned URL, so
+		// crawlers can fetch the file after the sitemap's URLs would have
+		// expired.
+		ContentLoc:      cfg.siteURL + "/api/videos/" + entry.ID.String() + "/playback",
+		Duration:        int(entry.DurationSeconds + 0.5),
+		PublicationDate: publishedAt.UTC().Format(time.RFC3339),
+		Tags:            entry.Tags,
+	}
+	if entry.AgeRestricted {
+		u.Video.FamilyFriendly = "no"
+	}
+	return u
+}
+
+// handlerSitemap serves sitemap.xml, listing every public video.
+func (cfg *apiConfig) handlerSitemap(w http.ResponseWriter, r *http.Request) {
+	dat, err := cfg.sitemapXML(cfg.clock.Now())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't build sitemap", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Write(dat)
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	sloUploadSuccess        = "upload_success"
+	sloProcessingLatency    = "processing_latency"
+	sloPlaybackAvailability = "playback_availability"
+)
+
+// sloWindows are the rolling windows SLOs are reported over, besides the
+// SLO period. The short ones pair up with the long ones for burn-rate
+// alerts: 5m with 1h for fast burns, 30m with 6h for slow ones.
+var sloWindows = []struct {
+	name     string
+	duration time.Duration
+}{
+	{"5m", 5 * time.Minute},
+	{"30m", 30 * time.Minute},
+	{"1h", time.Hour},
+	{"6h", 6 * time.Hour},
+	{"24h", 24 * time.Hour},
+}
+
+// sloLatencyBuckets are the upper bounds, in seconds, processing times are
+// counted into for percentiles. Anything slower goes in one more bucket.
+var sloLatencyBuckets = []float64{1, 5, 10, 30, 60, 120, 300, 600, 900, 1800, 3600}
+
+// sloQuantiles are the processing latency percentiles reported.
+var sloQuantiles = []struct {
+	name string
+	q    float64
+}{
+	{"p50", 0.5},
+	{"p90", 0.9},
+	{"p99", 0.99},
+}
+
+// sloConfig is what the SLOs are held to: the share of events that must be
+// good over the period, and the burn rates that raise an alert.
+type sloConfig struct {
+	period                  time.Duration
+	uploadSuccessTarget     float64
+	processingLatencyTarget float64
+	processingLatency       time.Duration
+	playbackTarget          float64
+	fastBurnRate            float64
+	slowBurnRate            float64
+}
+
+// sloTracker counts the events SLOs are measured on, a minute at a time,
+// for as long as the period. Counts are kept in memory, so they begin
+// again when the server restarts.
+type sloTracker struct {
+	config  sloConfig
+	started time.Time
+
+	mu      sync.Mutex
+	minutes []sloMinute
+}
+
+// sloMinute is what happened in one minute.
+type sloMinute struct {
+	minute int64
+
+	uploads   int64
+	uploadsOK int64
+	// processed counts successful uploads, and processedFast those that
+	// finished within the latency objective.
+	processed     int64
+	processedFast int64
+	latency       []int64
+	playback      int64
+	playbackOK    int64
+}
+
+func newSLOTracker(config sloConfig, now time.Time) *sloTracker {
+	return &sloTracker{
+		config:  config,
+		started: now,
+		minutes: make([]sloMinute, int(config.period/time.Minute)),
+	}
+}
+
+// at returns the counts of now's minute, starting them over if its slot
+// was last used for a minute the period has passed. t.mu must be held.
+func (t *sloTracker) at(now time.Time) *sloMinute {
+	minute := now.Unix() / 60
+	m := &t.minutes[minute%int64(len(t.minutes))]
+	if m.minute != minute {
+		*m = sloMinute{minute: minute, latency: make([]int64, len(sloLatencyBuckets)+1)}
+	}
+	return m
+}
+
+// recordUpload counts an upload whose processing ended, and how long it
+// took if it succeeded.
+func (t *sloTracker) recordUpload(now time.Time, ok bool, elapsed time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	m := t.at(now)
+	m.uploads++
+	if !ok {
+		return
+	}
+	m.uploadsOK++
+	m.processed++
+	if elapsed <= t.config.processingLatency {
+		m.processedFast++
+	}
+	seconds := elapsed.Seconds()
+	i := 0
+	for i < len(sloLatencyBuckets) && seconds > sloLatencyBuckets[i] {
+		i++
+	}
+	m.latency[i]++
+}
+
+// recordPlayback counts a request for a video's playback URLs or media,
+// which is good unless the server failed it.
+func (t *sloTracker) recordPlayback(now time.Time, status int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	m := t.at(now)
+	m.playback++
+	if status < http.StatusInternalServerError {
+		m.playbackOK++
+	}
+}
+
+// sloWindowTotals adds up the minutes of a window.
+type sloWindowTotals struct {
+	name     string
+	duration time.Duration
+	sloMinute
+}
+
+// totals adds up the minutes of each window, and of the period last.
+func (t *sloTracker) totals(now time.Time) []sloWindowTotals {
+	windows := make([]sloWindowTotals, 0, len(sloWindows)+1)
+	for _, w := range sloWindows {
+		if w.duration < t.config.period {
+			windows = append(windows, sloWindowTotals{name: w.name, duration: w.duration})
+		}
+	}
+	windows = append(windows, sloWindowTotals{name: "period", duration: t.config.period})
+	for i := range windows {
+		windows[i].latency = make([]int64, len(sloLatencyBuckets)+1)
+	}
+
+	current := now.Unix() / 60
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, m := range t.minutes {
+		age := current - m.minute
+		if m.latency == nil || age < 0 {
+			continue
+		}
+		for i := range windows {
+			if age >= int64(windows[i].duration/time.Minute) {
+				continue
+			}
+			w := &windows[i]
+			w.uploads += m.uploads
+			w.uploadsOK += m.uploadsOK
+			w.processed += m.processed
+			w.processedFast += m.processedFast
+			w.playback += m.playback
+			w.playbackOK += m.playbackOK
+			for b, n := range m.latency {
+				w.latency[b] += n
+			}
+		}
+	}
+	return windows
+}
+
+// quantile estimates the q-th quantile of the processing times counted in
+// latency, interpolating within the bucket it falls in. It's false if
+// nothing was counted.
+func quantile(latency []int64, q float64) (float64, bool) {
+	var total int64
+	for _, n := range latency {
+		total += n
+	}
+	if total == 0 {
+		return 0, false
+	}
+	rank := q * float64(total)
+	var seen int64
+	for i, n := range latency {
+		if n == 0 || float64(seen+n) < rank {
+			seen += n
+			continue
+		}
+		lower := 0.0
+		if i > 0 {
+			lower = sloLatencyBuckets[i-1]
+		}
+		if i == len(sloLatencyBuckets) {
+			// Slower than every bucket; the last bound is all that's known.
+			return lower, true
+		}
+		upper := sloLatencyBuckets[i]
+		return lower + (upper-lower)*(rank-float64(seen))/float64(n), true
+	}
+	return sloLatencyBuckets[len(sloLatencyBuckets)-1], true
+}
+
+// sloWindow is an SLO over one window. Ratio and BurnRate are null when
+// there were no events.
+type sloWindow struct {
+	Window   string   `json:"window"`
+	Events   int64    `json:"events"`
+	Good     int64    `json:"good"`
+	Ratio    *float64 `json:"ratio"`
+	BurnRate *float64 `json:"burn_rate"`
+	// Percentiles are the processing latency SLO's, in seconds.
+	Percentiles map[string]float64 `json:"percentiles_seconds,omitempty"`
+}
+
+// sloAlerts are the multiwindow burn-rate alerts: an SLO burns fast when
+// both its 1h and 5m burn rates are over the fast rate, and slowly when
+// its 6h and 30m ones are over the slow rate.
+type sloAlerts struct {
+	FastBurn bool `json:"fast_burn"`
+	SlowBurn bool `json:"slow_burn"`
+}
+
+// sloStatus is an SLO's target and how it's doing. ErrorBudgetRemaining is
+// the share of the period's error budget left, negative once it's spent.
+type sloStatus struct {
+	Name                 string      `json:"name"`
+	Target               float64     `json:"target"`
+	ThresholdSeconds     float64     `json:"threshold_seconds,omitempty"`
+	Windows              []sloWindow `json:"windows"`
+	ErrorBudgetRemaining *float64    `json:"error_budget_remaining"`
+	Alerts               sloAlerts   `json:"alerts"`
+}
+
+// sloReport is every SLO as of Now. Since is when counting started, if
+// that's less than a period ago.
+type sloReport struct {
+	Now          time.Time   `json:"now"`
+	PeriodHours  float64     `json:"period_hours"`
+	Since        time.Time   `json:"since"`
+	FastBurnRate float64     `json:"fast_burn_rate"`
+	SlowBurnRate float64     `json:"slow_burn_rate"`
+	SLOs         []sloStatus `json:"slos"`
+}
+
+func (t *sloTracker) report(now time.Time) sloReport {
+	c := t.config
+	windows := t.totals(now)
+	rep := sloReport{
+		Now:          now.UTC(),
+		PeriodHours:  c.period.Hours(),
+		Since:        t.started.UTC(),
+		FastBurnRate: c.fastBurnRate,
+		SlowBurnRate: c.slowBurnRate,
+	}
+	if since := now.Add(-c.period); since.After(t.started) {
+		rep.Since = since.UTC()
+	}
+
+	type objective struct {
+		name      string
+		target    float64
+		threshold time.Duration
+		count     func(w sloWindowTotals) (events, good int64)
+	}
+	objectives := []objective{
+		{name: sloUploadSuccess, target: c.uploadSuccessTarget, count: func(w sloWindowTotals) (int64, int64) {
+			return w.uploads, w.uploadsOK
+		}},
+		{name: sloProcessingLatency, target: c.processingLatencyTarget, threshold: c.processingLatency, count: func(w sloWindowTotals) (int64, int64) {
+			return w.processed, w.processedFast
+		}},
+		{name: sloPlaybackAvailability, target: c.playbackTarget, count: func(w sloWindowTotals) (int64, int64) {
+			return w.playback, w.playbackOK
+		}},
+	}
+	for _, o := range objectives {
+		status := sloStatus{Name: o.name, Target: o.target, ThresholdSeconds: o.threshold.Seconds(), Windows: []sloWindow{}}
+		burn := map[string]float64{}
+		for _, w := range windows {
+			events, good := o.count(w)
+			sw := sloWindow{Window: w.name, Events: events, Good: good}
+			if events > 0 {
+				ratio := float64(good) / float64(events)
+				rate := burnRate(ratio, o.target)
+				sw.Ratio, sw.BurnRate = &ratio, &rate
+				burn[w.name] = rate
+				if w.name == "period" {
+					remaining := 1 - rate
+					status.ErrorBudgetRemaining = &remaining
+				}
+			}
+			if o.name == sloProcessingLatency {
+				sw.Percentiles = map[string]float64{}
+				for _, q := range sloQuantiles {
+					if v, ok := quantile(w.latency, q.q); ok {
+						sw.Percentiles[q.name] = v
+					}
+				}
+			}
+			status.Windows = append(status.Windows, sw)
+		}
+		status.Alerts = sloAlerts{
+			FastBurn: burn["1h"] > c.fastBurnRate && burn["5m"] > c.fastBurnRate,
+			SlowBurn: burn["6h"] > c.slowBurnRate && burn["30m"] > c.slowBurnRate,
+		}
+		rep.SLOs = append(rep.SLOs, status)
+	}
+	return rep
+}
+
+// burnRate is how fast the error budget is being spent: 1 spends it
+// exactly over the period, and 2 in half of it. Targets are below 1, so
+// there's always some budget.
+func burnRate(ratio, target float64) float64 {
+	return (1 - ratio) / (1 - target)
+}
+
+// handlerAdminSLO reports every SLO over its rolling windows.
+func (cfg *apiConfig) handlerAdminSLO(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+	respondWithJSON(w, http.StatusOK, cfg.slo.report(cfg.clock.Now()))
+}
+
+// playbackSLOMiddleware counts a playback route's responses towards the
+// playback availability SLO. It goes outside the route's limits, so
+// requests turned away as the server is busy count against it.
+func (cfg *apiConfig) playbackSLOMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w}
+		next(sw, r)
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		cfg.slo.recordPlayback(cfg.clock.Now(), sw.status)
+	}
+}
+
+// watchSLOs adds gauges for the SLOs, computed at each scrape.
+func (m *serverMetrics) watchSLOs(t *sloTracker, now func() time.Time) {
+	m.registry.GaugeVecFunc("tubely_slo_target", "The share of events each SLO must get right over the period.", []string{"slo"}, func(set func(float64, ...string)) {
+		for _, s := range t.report(now()).SLOs {
+			set(s.Target, s.Name)
+		}
+	})
+	m.registry.GaugeVecFunc("tubely_slo_ratio", "The share of events each SLO got right, by rolling window.", []string{"slo", "window"}, func(set func(float64, ...string)) {
+		for _, s := range t.report(now()).SLOs {
+			for _, w := range s.Windows {
+				if w.Ratio != nil {
+					set(*w.Ratio, s.Name, w.Window)
+				}
+			}
+		}
+	})
+	m.registry.GaugeVecFunc("tubely_slo_burn_rate", "How fast each SLO's error budget is being spent, by rolling window.", []string{"slo", "window"}, func(set func(float64, ...string)) {
+		for _, s := range t.report(now()).SLOs {
+			for _, w := range s.Windows {
+				if w.BurnRate != nil {
+					set(*w.BurnRate, s.Name, w.Window)
+				}
+			}
+		}
+	})
+	m.registry.GaugeVecFunc("tubely_slo_error_budget_remaining", "The share of each SLO's error budget left for the period.", []string{"slo"}, func(set func(float64, ...string)) {
+		for _, s := range t.report(now()).SLOs {
+			if s.ErrorBudgetRemaining != nil {
+				set(*s.ErrorBudgetRemaining, s.Name)
+			}
+		}
+	})
+	m.registry.GaugeVecFunc("tubely_slo_alert", "Whether each SLO's fast or slow burn-rate alert is firing.", []string{"slo", "alert"}, func(set func(float64, ...string)) {
+		for _, s := range t.report(now()).SLOs {
+			set(boolGauge(s.Alerts.FastBurn), s.Name, "fast_burn")
+			set(boolGauge(s.Alerts.SlowBurn), s.Name, "slow_burn")
+		}
+	})
+	m.registry.GaugeVecFunc("tubely_processing_latency_seconds", "Percentiles of how long successful uploads took to process, by rolling window.", []string{"quantile", "window"}, func(set func(float64, ...string)) {
+		for _, s := range t.report(now()).SLOs {
+			if s.Name != sloProcessingLatency {
+				continue
+			}
+			for _, w := range s.Windows {
+				for _, q := range sloQuantiles {
+					if v, ok := w.Percentiles[q.name]; ok {
+						set(v, formatQuantile(q.q), w.Window)
+					}
+				}
+			}
+		}
+	})
+}
+
+func boolGauge(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func formatQuantile(q float64) string {
+	return strconv.FormatFloat(q, 'g', -1, 64)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// errUploadTooSlow is what reading an upload's body returns once the
+// client has sent less than the minimum rate's worth of it over a whole
+// window.
+var errUploadTooSlow = errors.New("upload is slower than the minimum rate")
+
+// uploadRate is the slowest a client may send an upload: bytesPerSecond,
+// averaged over each window. A zero rate lets uploads take as long as they
+// like.
+type uploadRate struct {
+	bytesPerSecond int64
+	window         time.Duration
+}
+
+func (rate uploadRate) enabled() bool {
+	return rate.bytesPerSecond > 0 && rate.window > 0
+}
+
+// watchUploadRate makes r's body fail with errUploadTooSlow once the client
+// falls below cfg.minUploadRate for a whole window. A client that stops
+// sending altogether would leave the handler blocked in a read, so the
+// connection's read deadline is moved up to end it. The returned stop must
+// be called once the body has been read, before any slow work that
+// follows.
+func (cfg *apiConfig) watchUploadRate(w http.ResponseWriter, r *http.Request) (stop func()) {
+	rate := cfg.minUploadRate
+	if !rate.enabled() {
+		return func() {}
+	}
+	body := &rateWatchedBody{ReadCloser: r.Body}
+	r.Body = body
+	done := make(chan struct{})
+	var once sync.Once
+	stop = func() { once.Do(func() { close(done) }) }
+
+	rc := http.NewResponseController(w)
+	minBytes := int64(float64(rate.bytesPerSecond) * rate.window.Seconds())
+	go func() {
+		ticker := time.NewTicker(rate.window)
+		defer ticker.Stop()
+		last := int64(0)
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				n := body.n.Load()
+				if body.eof.Load() {
+					return
+				}
+				if n-last < minBytes {
+					body.slow.Store(true)
+					// Servers that can't set deadlines, such as HTTP/2
+					// ones behind some proxies, still fail the next read.
+					_ = rc.SetReadDeadline(time.Now())
+					return
+				}
+				last = n
+			}
+		}
+	}()
+	return stop
+}
+
+// rateWatchedBody counts what's read of a request body for watchUploadRate.
+type rateWatchedBody struct {
+	io.ReadCloser
+	n    atomic.Int64
+	eof  atomic.Bool
+	slow atomic.Bool
+}
+
+func (b *rateWatchedBody) Read(p []byte) (int, error) {
+	if b.slow.Load() {
+		return 0, errUploadTooSlow
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.n.Add(int64(n))
+	if errors.Is(err, io.EOF) {
+		b.eof.Store(true)
+	} else if err != nil && b.slow.Load() {
+		err = errUploadTooSlow
+	}
+	return n, err
+}
+
+// respondIfTooSlow responds with a 408 if err is from an upload that
+// watchUploadRate ended. It reports whether it did.
+func respondIfTooSlow(w http.ResponseWriter, err error) bool {
+	if !errors.Is(err, errUploadTooSlow) {
+		return false
+	}
+	w.Header().Set("Connection", "close")
+	respondWithError(w, http.StatusRequestTimeout, "Upload is too slow", err)
+	return true
+}
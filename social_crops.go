@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ffmpeg"
+)
+
+type socialCropOutput struct {
+	crop ffmpeg.SocialCrop
+	path string
+}
+
+type socialCropOutputs []socialCropOutput
+
+func (o socialCropOutputs) remove() {
+	for _, c := range o {
+		os.Remove(c.path)
+	}
+}
+
+// processSocialCrops cuts the square and vertical crops out of a landscape
+// video for cross-posting. Videos that aren't landscape get none. On error,
+// any files already written are removed.
+func (cfg *apiConfig) processSocialCrops(ctx context.Context, filePath string) (socialCropOutputs, error) {
+	probeOutput, err := cfg.probeVideo(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+	stream, ok := probeOutput.firstStream("video")
+	if !ok || stream.Width <= stream.Height {
+		return nil, nil
+	}
+
+	var out socialCropOutputs
+	for _, crop := range ffmpeg.SocialCrops {
+		crop = ffmpeg.SocialCropFor(crop, stream.Height)
+		outputPath := fmt.Sprintf("%s.%s", filePath, crop.Name)
+		if _, err := ffmpeg.CropCommand(filePath, outputPath, crop).Run(ctx); err != nil {
+			out.remove()
+			return nil, err
+		}
+		out = append(out, socialCropOutput{crop: crop, path: outputPath})
+	}
+	return out, nil
+}
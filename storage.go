@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tenants"
+)
+
+var errNoVideoObject = errors.New("video has no stored object")
+
+// errStorageNotS3 is returned by features built on S3 APIs when the server
+// stores objects on local disk.
+var errStorageNotS3 = errors.New("needs STORAGE_BACKEND s3 or s3-compatible")
+
+// requireS3 responds with an error if target isn't an S3 bucket. If it
+// returns false, an error response has been written.
+func requireS3(w http.ResponseWriter, target tenants.Target) bool {
+	if !target.IsS3() {
+		respondWithError(w, http.StatusNotImplemented, "This needs S3 storage", errStorageNotS3)
+		return false
+	}
+	return true
+}
+
+// videoObject resolves the storage target and object key holding a video's
+// uploaded file.
+func (cfg *apiConfig) videoObject(ctx context.Context, video database.Video) (tenants.Target, string, error) {
+	if video.VideoURL == nil {
+		return tenants.Target{}, "", errNoVideoObject
+	}
+	target, err := cfg.videoTarget(ctx, video)
+	if err != nil {
+		return tenants.Target{}, "", err
+	}
+	key, ok := storedObjectKey(target, *video.VideoURL)
+	if !ok {
+		return tenants.Target{}, "", fmt.Errorf("video URL %q is not in bucket %s", *video.VideoURL, target.Bucket)
+	}
+	return target, key, nil
+}
+
+// videoTarget returns the target video's files are stored in, which is
+// that of its owner's tenant.
+func (cfg *apiConfig) videoTarget(ctx context.Context, video database.Video) (tenants.Target, error) {
+	owner, err := cfg.db.GetUser(video.UserID)
+	if err != nil {
+		return tenants.Target{}, err
+	}
+	tenantID := ""
+	if owner != nil {
+		tenantID = owner.TenantID
+	}
+	return cfg.tenants.Target(ctx, tenantID)
+}
+
+// objectExists HEADs key and reports whether it is still in the bucket.
+// Backends other than S3 open the object instead.
+func objectExists(ctx context.Context, target tenants.Target, key string) (bool, error) {
+	if !target.IsS3() {
+		obj, err := target.Storage().Get(ctx, key)
+		if errors.Is(err, storage.ErrNotFound) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		obj.Close()
+		return true, nil
+	}
+
+	_, err := target.Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(target.Bucket),
+		Key:    aws.String(key),
+	})
+	if err == nil {
+		return true, nil
+	}
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		return false, nil
+	}
+	return false, err
+}
+
+func generatePresignedURL(ctx context.Context, target tenants.Target, key string, expireTime time.Duration) (string, error) {
+	return target.Storage().PresignGet(ctx, key, expireTime)
+}
+
+// uploadFile puts the file at path into the target bucket under key,
+// counting it towards the upload progress ctx carries.
+func uploadFile(ctx context.Context, target tenants.Target, key, path, contentType string, opts ...func(*s3.PutObjectInput)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return putObject(ctx, target, key, uploadProgressFrom(ctx).reader(f), contentType, opts...)
+}
+
+// putObject puts body into the target bucket under key, with the target's
+// retention and encryption if it has them.
+func putObject(ctx context.Context, target tenants.Target, key string, body io.Reader, contentType string, opts ...func(*s3.PutObjectInput)) error {
+	opts = append([]func(*s3.PutObjectInput){withRetention(target.Retention), withEncryption(target.Encryption)}, opts...)
+
+	start := time.Now()
+	err := target.Storage().Put(ctx, key, body, contentType, opts...)
+	logStep(ctx, "s3", fmt.Sprintf("PUT s3://%s/%s", target.Bucket, key), start, err)
+	return err
+}
+
+// downloadToTemp copies key into a new temporary file named after pattern
+// and returns its path. The caller removes the file.
+func downloadToTemp(ctx context.Context, target tenants.Target, key, pattern string) (string, error) {
+	f, err := os.CreateTemp("", pattern)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	start := time.Now()
+	obj, err := target.Storage().Get(ctx, key)
+	if err == nil {
+		_, err = io.Copy(f, obj)
+		obj.Close()
+	}
+	logStep(ctx, "s3", fmt.Sprintf("GET s3://%s/%s", target.Bucket, key), start, err)
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/aws/smithy-go"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/i18n"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// storageFailure is how an error code from the storage backend is reported
+// to clients: its own status and message, and a hint at what to do about
+// it.
+type storageFailure struct {
+	status int
+	msg    string
+	hint   string
+	// retryAfter is the Retry-After to send, in seconds; 0 sends none.
+	retryAfter int
+}
+
+var (
+	storageBucketMissing = storageFailure{
+		status: http.StatusBadGateway,
+		msg:    "Storage bucket doesn't exist",
+		hint:   "The server's storage is misconfigured. Contact the administrator.",
+	}
+	storageAccessDenied = storageFailure{
+		status: http.StatusBadGateway,
+		msg:    "Storage denied access",
+		hint:   "The server's storage credentials lack a permission. Contact the administrator.",
+	}
+	storageThrottled = storageFailure{
+		status:     http.StatusServiceUnavailable,
+		msg:        "Storage is throttling requests",
+		hint:       "Retry after the number of seconds in Retry-After.",
+		retryAfter: 5,
+	}
+	storageEntityTooLarge = storageFailure{
+		status: http.StatusRequestEntityTooLarge,
+		msg:    "File is too large for storage",
+		hint:   "Upload files over 5 GB with an upload session.",
+	}
+	storageTimeout = storageFailure{
+		status: http.StatusGatewayTimeout,
+		msg:    "Storage timed out",
+		hint:   "Retry the request. Over a slow connection, use an upload session so uploads can resume.",
+	}
+	// storageDown is sent while the S3 circuit breaker is open, with
+	// Retry-After set to when S3 is tried again.
+	storageDown = storageFailure{
+		status: http.StatusServiceUnavailable,
+		msg:    "Storage is unavailable",
+		hint:   "Retry after the number of seconds in Retry-After.",
+	}
+)
+
+// storageFailures maps the S3 error codes clients can act on to how they are
+// reported.
+var storageFailures = map[string]storageFailure{
+	"NoSuchBucket":         storageBucketMissing,
+	"AccessDenied":         storageAccessDenied,
+	"SlowDown":             storageThrottled,
+	"Throttling":           storageThrottled,
+	"ThrottlingException":  storageThrottled,
+	"RequestLimitExceeded": storageThrottled,
+	"EntityTooLarge":       storageEntityTooLarge,
+	"RequestTimeout":       storageTimeout,
+}
+
+// respondWithStorageError is respondWithError for an error from the storage
+// backend. S3 errors in storageFailures get their own status, message and
+// hint in place of code and msg, which are logged as context, and so do
+// errors from an open circuit breaker.
+func respondWithStorageError(w http.ResponseWriter, code int, msg string, err error) {
+	var open *storage.CircuitOpenError
+	if errors.As(err, &open) {
+		f := storageDown
+		f.retryAfter = max(1, int(math.Ceil(open.RetryAfter.Seconds())))
+		respondWithStorageFailure(w, f, msg, err)
+		return
+	}
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		respondWithError(w, code, msg, err)
+		return
+	}
+	f, ok := storageFailures[apiErr.ErrorCode()]
+	if !ok {
+		respondWithError(w, code, msg, err)
+		return
+	}
+	respondWithStorageFailure(w, f, msg, err)
+}
+
+// respondWithStorageFailure responds with f, logging msg and err as its
+// context.
+func respondWithStorageFailure(w http.ResponseWriter, f storageFailure, msg string, err error) {
+	log.Printf("%s: %v", msg, err)
+
+	type response struct {
+		Error string `json:"error"`
+		Code  string `json:"code"`
+		Hint  string `json:"hint"`
+	}
+	lang := w.Header().Get("Content-Language")
+	if lang == "" {
+		lang = i18n.Default
+	}
+	if f.retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(f.retryAfter))
+	}
+	respondWithJSON(w, f.status, response{
+		Error: i18n.Translate(lang, f.msg),
+		Code:  i18n.Code(f.msg, f.status),
+		Hint:  i18n.Translate(lang, f.hint),
+	})
+}
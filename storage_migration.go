@@ -0,0 +1,540 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tenants"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultMigrationObjectsPerSecond = 20
+	maxMigrationObjectsPerSecond     = 500
+	// maxCopyObjectSize is the largest object a single CopyObject can copy.
+	maxCopyObjectSize = 5 << 30
+)
+
+const storageCutoverMessage = "Tubely is moving to new storage. Uploads are temporarily disabled, please try again shortly."
+
+// storageMigrations tracks the migration running in this process. Only one
+// runs at a time, since two cutovers of the default bucket can't both win.
+type storageMigrations struct {
+	mu     sync.Mutex
+	id     uuid.UUID
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+var errStorageMigrationRunning = errors.New("a storage migration is already running")
+
+func (s *storageMigrations) start(cfg *apiConfig, m database.StorageMigration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cancel != nil {
+		return errStorageMigrationRunning
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.id, s.cancel, s.done = m.ID, cancel, make(chan struct{})
+	go func() {
+		cfg.runStorageMigration(ctx, m)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		close(s.done)
+		s.id, s.cancel, s.done = uuid.Nil, nil, nil
+		cancel()
+	}()
+	return nil
+}
+
+// pause stops migration id and waits until its progress is saved. It
+// reports false if it isn't running.
+func (s *storageMigrations) pause(id uuid.UUID) bool {
+	s.mu.Lock()
+	if s.id != id || s.cancel == nil {
+		s.mu.Unlock()
+		return false
+	}
+	cancel, done := s.cancel, s.done
+	s.mu.Unlock()
+	cancel()
+	<-done
+	return true
+}
+
+// resumeStorageMigrations carries on with a migration that was running when
+// the server last stopped.
+func (cfg *apiConfig) resumeStorageMigrations() error {
+	migrations, err := cfg.db.GetStorageMigrations()
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if m.Active() {
+			log.Printf("Resuming storage migration %s to %s", m.ID, m.DestBucket)
+			return cfg.storageMigrations.start(cfg, m)
+		}
+	}
+	return nil
+}
+
+// applyStorageSwitches points the default target at the bucket that storage
+// migrations have switched it to, so a restart with the old S3_BUCKET
+// doesn't switch back.
+func (cfg *apiConfig) applyStorageSwitches(ctx context.Context) error {
+	migrations, err := cfg.db.GetStorageMigrations()
+	if err != nil {
+		return err
+	}
+	defaults := cfg.tenants.Defaults()
+	// Oldest first, so a bucket that was later migrated again is followed
+	// to the newest one.
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if m.Status != database.StorageMigrationSwitched || m.SourceBucket != defaults.Bucket || m.SourceRegion != defaults.Region {
+			continue
+		}
+		client, err := newS3Client(ctx, m.DestRegion, cfg.s3Options...)
+		if err != nil {
+			return err
+		}
+		defaults = tenants.Target{Client: client, Bucket: m.DestBucket, Region: m.DestRegion, Retention: defaults.Retention, Encryption: defaults.Encryption, Hotlink: defaults.Hotlink, NoIndexUnlisted: defaults.NoIndexUnlisted}
+		log.Printf("Storage migration %s moved the default bucket to %s; set S3_BUCKET and S3_REGION to match", m.ID, m.DestBucket)
+	}
+	cfg.tenants.SetDefaults(defaults)
+	return nil
+}
+
+// runStorageMigration copies every object in the default bucket to the
+// migration's bucket and then cuts over to it, saving progress as it goes.
+// It stops when ctx is cancelled, leaving the migration paused.
+func (cfg *apiConfig) runStorageMigration(ctx context.Context, m database.StorageMigration) {
+	err := cfg.migrateStorage(ctx, &m)
+	switch {
+	case err == nil:
+		log.Printf("Storage migration %s switched the default bucket to %s: %d objects copied, %d URLs rewritten", m.ID, m.DestBucket, m.Copied, m.RewrittenURLs)
+	case ctx.Err() != nil:
+		m.Status = database.StorageMigrationPaused
+	default:
+		log.Printf("Storage migration %s failed: %v", m.ID, err)
+		m.Status = database.StorageMigrationFailed
+		m.Error = err.Error()
+	}
+	m.UpdatedAt = cfg.clock.Now().UTC()
+	if err := cfg.db.UpdateStorageMigration(m); err != nil {
+		log.Printf("Couldn't save storage migration %s: %v", m.ID, err)
+	}
+}
+
+func (cfg *apiConfig) migrateStorage(ctx context.Context, m *database.StorageMigration) error {
+	source := cfg.tenants.Defaults()
+	if source.Bucket != m.SourceBucket || source.Region != m.SourceRegion {
+		return fmt.Errorf("the default bucket is now %s, not %s", source.Bucket, m.SourceBucket)
+	}
+	client, err := newS3Client(ctx, m.DestRegion, cfg.s3Options...)
+	if err != nil {
+		return err
+	}
+	// The new bucket takes over the old one's role, including its
+	// retention and playback restrictions.
+	dest := tenants.Target{Client: client, Bucket: m.DestBucket, Region: m.DestRegion, Retention: source.Retention, Encryption: source.Encryption, Hotlink: source.Hotlink, NoIndexUnlisted: source.NoIndexUnlisted}
+	throttle := time.NewTicker(time.Second / time.Duration(m.ObjectsPerSecond))
+	defer throttle.Stop()
+
+	save := func() error {
+		m.UpdatedAt = cfg.clock.Now().UTC()
+		return cfg.db.UpdateStorageMigration(*m)
+	}
+
+	if m.Status != database.StorageMigrationCutover {
+		m.Status = database.StorageMigrationCopying
+		m.Error = ""
+		if err := save(); err != nil {
+			return err
+		}
+		if err := cfg.copyStorageObjects(ctx, m, source, dest, throttle.C, false, save); err != nil {
+			return err
+		}
+		m.Status = database.StorageMigrationCutover
+		if err := save(); err != nil {
+			return err
+		}
+	}
+
+	// Writes are stopped for the cutover so nothing lands in the old bucket
+	// after the final pass. Upload sessions still open in the old bucket
+	// can't be completed afterwards and have to be restarted.
+	enabled, message, retryAfter := cfg.maintenance.get()
+	if !enabled {
+		cfg.maintenance.set(true, storageCutoverMessage, time.Minute)
+		defer cfg.maintenance.set(false, message, retryAfter)
+	}
+	for cfg.jobs.InFlight() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+	if err := cfg.copyStorageObjects(ctx, m, source, dest, throttle.C, true, save); err != nil {
+		return err
+	}
+
+	rewritten, err := cfg.db.ReplaceURLPrefix(source.ObjectURL(""), dest.ObjectURL(""))
+	if err != nil {
+		return fmt.Errorf("couldn't rewrite URLs: %w", err)
+	}
+	if err := cfg.db.MoveContentObjects(source.Bucket, dest.Bucket); err != nil {
+		return fmt.Errorf("couldn't move shared video file references: %w", err)
+	}
+	now := cfg.clock.Now().UTC()
+	m.RewrittenURLs = rewritten
+	m.Status = database.StorageMigrationSwitched
+	m.SwitchedAt = &now
+	if err := save(); err != nil {
+		return err
+	}
+	cfg.tenants.SetDefaults(dest)
+	return nil
+}
+
+// copyStorageObjects copies and verifies the source bucket's objects, at
+// most one per tick. The first pass resumes after m.Cursor and skips
+// objects already copied. The final pass, run once writes have stopped,
+// compares listings of both buckets so it only has to wait on objects that
+// are missing or were written since the migration began.
+func (cfg *apiConfig) copyStorageObjects(ctx context.Context, m *database.StorageMigration, source, dest tenants.Target, tick <-chan time.Time, final bool, save func() error) error {
+	var copies map[string]int64
+	if final {
+		var err error
+		copies, err = listObjectSizes(ctx, dest)
+		if err != nil {
+			return fmt.Errorf("couldn't list %s: %w", dest.Bucket, err)
+		}
+	}
+
+	input := &s3.ListObjectsV2Input{Bucket: aws.String(source.Bucket)}
+	if !final && m.Cursor != "" {
+		input.StartAfter = aws.String(m.Cursor)
+	}
+	paginator := s3.NewListObjectsV2Paginator(source.Client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("couldn't list %s: %w", source.Bucket, err)
+		}
+		for _, obj := range page.Contents {
+			key := aws.ToString(obj.Key)
+			size := aws.ToInt64(obj.Size)
+			existing, ok := copies[key]
+			if final && ok && existing == size && !aws.ToTime(obj.LastModified).After(m.CreatedAt) {
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-tick:
+			}
+			if !final {
+				existing, ok, err = objectSize(ctx, dest, key)
+				if err != nil {
+					return err
+				}
+			}
+			if final || !ok || existing != size {
+				if err := copyStorageObject(ctx, source, dest, key, size); err != nil {
+					return err
+				}
+				m.Copied++
+				m.Bytes += size
+			}
+			if err := verifyStorageObject(ctx, source, dest, key); err != nil {
+				return err
+			}
+			m.Verified++
+			if !final {
+				m.Cursor = key
+			}
+			if err := save(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func listObjectSizes(ctx context.Context, target tenants.Target) (map[string]int64, error) {
+	sizes := map[string]int64{}
+	paginator := s3.NewListObjectsV2Paginator(target.Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(target.Bucket),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range page.Contents {
+			sizes[aws.ToString(obj.Key)] = aws.ToInt64(obj.Size)
+		}
+	}
+	return sizes, nil
+}
+
+func copyStorageObject(ctx context.Context, source, dest tenants.Target, key string, size int64) error {
+	if size > maxCopyObjectSize {
+		return fmt.Errorf("%s is larger than 5 GB, which CopyObject can't copy", key)
+	}
+	input := &s3.CopyObjectInput{
+		Bucket:            aws.String(dest.Bucket),
+		Key:               aws.String(key),
+		CopySource:        aws.String(s3CopySource(source.Bucket, key)),
+		ChecksumAlgorithm: types.ChecksumAlgorithmSha256,
+	}
+	withCopyRetention(dest.Retention)(input)
+	withCopyEncryption(dest.Encryption)(input)
+	if _, err := dest.Client.CopyObject(ctx, input); err != nil {
+		return fmt.Errorf("couldn't copy %s: %w", key, err)
+	}
+	return nil
+}
+
+// verifyStorageObject checks that the copy of key has the same contents as
+// the original. S3's full-object SHA-256 checksums, or ETags that are plain
+// MD5s, are compared when both sides have them; otherwise both objects are
+// read and hashed.
+func verifyStorageObject(ctx context.Context, source, dest tenants.Target, key string) error {
+	head := func(target tenants.Target) (*s3.HeadObjectOutput, error) {
+		return target.Client.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket:       aws.String(target.Bucket),
+			Key:          aws.String(key),
+			ChecksumMode: types.ChecksumModeEnabled,
+		})
+	}
+	want, err := head(source)
+	if err != nil {
+		return fmt.Errorf("couldn't check %s in %s: %w", key, source.Bucket, err)
+	}
+	got, err := head(dest)
+	if err != nil {
+		return fmt.Errorf("couldn't check %s in %s: %w", key, dest.Bucket, err)
+	}
+	if aws.ToInt64(want.ContentLength) != aws.ToInt64(got.ContentLength) {
+		return fmt.Errorf("copy of %s has the wrong size", key)
+	}
+
+	// Checksums and ETags of multipart uploads end in -{parts} and depend
+	// on the part sizes, so they can't be compared across copies.
+	wantSum, gotSum := aws.ToString(want.ChecksumSHA256), aws.ToString(got.ChecksumSHA256)
+	if wantSum != "" && gotSum != "" && !strings.Contains(wantSum, "-") && !strings.Contains(gotSum, "-") {
+		if wantSum != gotSum {
+			return fmt.Errorf("copy of %s has the wrong checksum", key)
+		}
+		return nil
+	}
+	wantETag, gotETag := aws.ToString(want.ETag), aws.ToString(got.ETag)
+	if wantETag == gotETag && !strings.Contains(wantETag, "-") {
+		return nil
+	}
+
+	wantHash, err := hashObject(ctx, source, key)
+	if err != nil {
+		return err
+	}
+	gotHash, err := hashObject(ctx, dest, key)
+	if err != nil {
+		return err
+	}
+	if wantHash != gotHash {
+		return fmt.Errorf("copy of %s has the wrong checksum", key)
+	}
+	return nil
+}
+
+func hashObject(ctx context.Context, target tenants.Target, key string) (string, error) {
+	obj, err := target.Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(target.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return "", fmt.Errorf("couldn't read %s in %s: %w", key, target.Bucket, err)
+	}
+	defer obj.Body.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, obj.Body); err != nil {
+		return "", fmt.Errorf("couldn't read %s in %s: %w", key, target.Bucket, err)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// handlerStorageMigrationStart starts moving the default bucket to another
+// bucket. Objects are copied server-side in the background, throttled to
+// objects_per_second, and each copy is verified. Once everything is copied,
+// uploads are paused briefly while a final pass catches up, the stored URLs
+// are rewritten and the default bucket is switched. Tenant buckets aren't
+// affected, and a CloudFront distribution in front of the old bucket has to
+// be pointed at the new one separately.
+func (cfg *apiConfig) handlerStorageMigrationStart(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+
+	type parameters struct {
+		Bucket           string `json:"bucket"`
+		Region           string `json:"region"`
+		ObjectsPerSecond int    `json:"objects_per_second"`
+	}
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Bucket == "" || params.Region == "" {
+		respondWithError(w, http.StatusBadRequest, "Bucket and region are required", nil)
+		return
+	}
+	if params.ObjectsPerSecond == 0 {
+		params.ObjectsPerSecond = defaultMigrationObjectsPerSecond
+	}
+	if params.ObjectsPerSecond < 1 || params.ObjectsPerSecond > maxMigrationObjectsPerSecond {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("objects_per_second must be between 1 and %d", maxMigrationObjectsPerSecond), nil)
+		return
+	}
+	source := cfg.tenants.Defaults()
+	if !requireS3(w, source) {
+		return
+	}
+	if params.Bucket == source.Bucket {
+		respondWithError(w, http.StatusBadRequest, "That bucket is already the default bucket", nil)
+		return
+	}
+
+	now := cfg.clock.Now().UTC()
+	m := database.StorageMigration{
+		ID:               uuid.New(),
+		Status:           database.StorageMigrationCopying,
+		SourceBucket:     source.Bucket,
+		SourceRegion:     source.Region,
+		DestBucket:       params.Bucket,
+		DestRegion:       params.Region,
+		ObjectsPerSecond: params.ObjectsPerSecond,
+		CreatedAt:        now,
+		UpdatedAt:        now,
+	}
+	if err := cfg.db.CreateStorageMigration(m); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create storage migration", err)
+		return
+	}
+	if err := cfg.storageMigrations.start(cfg, m); err != nil {
+		m.Status = database.StorageMigrationFailed
+		m.Error = err.Error()
+		cfg.db.UpdateStorageMigration(m)
+		respondWithError(w, http.StatusConflict, "A storage migration is already running", err)
+		return
+	}
+	respondWithJSON(w, http.StatusAccepted, m)
+}
+
+func (cfg *apiConfig) handlerStorageMigrationsList(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+	migrations, err := cfg.db.GetStorageMigrations()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get storage migrations", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, migrations)
+}
+
+// getStorageMigration loads the migration named in the path. If ok is
+// false, an error response has been written.
+func (cfg *apiConfig) getStorageMigration(w http.ResponseWriter, r *http.Request) (m database.StorageMigration, ok bool) {
+	id, err := uuid.Parse(r.PathValue("migrationID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return database.StorageMigration{}, false
+	}
+	migration, err := cfg.db.GetStorageMigration(id)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get storage migrations", err)
+		return database.StorageMigration{}, false
+	}
+	if migration == nil {
+		respondWithError(w, http.StatusNotFound, "Storage migration not found", nil)
+		return database.StorageMigration{}, false
+	}
+	return *migration, true
+}
+
+func (cfg *apiConfig) handlerStorageMigrationGet(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+	m, ok := cfg.getStorageMigration(w, r)
+	if !ok {
+		return
+	}
+	respondWithJSON(w, http.StatusOK, m)
+}
+
+// handlerStorageMigrationPause stops a running migration. Its progress is
+// kept, and resuming carries on where it stopped.
+func (cfg *apiConfig) handlerStorageMigrationPause(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+	m, ok := cfg.getStorageMigration(w, r)
+	if !ok {
+		return
+	}
+	if !cfg.storageMigrations.pause(m.ID) {
+		respondWithError(w, http.StatusConflict, "Storage migration isn't running", nil)
+		return
+	}
+	m, ok = cfg.getStorageMigration(w, r)
+	if !ok {
+		return
+	}
+	respondWithJSON(w, http.StatusOK, m)
+}
+
+// handlerStorageMigrationResume restarts a paused or failed migration.
+func (cfg *apiConfig) handlerStorageMigrationResume(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+	m, ok := cfg.getStorageMigration(w, r)
+	if !ok {
+		return
+	}
+	if m.Status == database.StorageMigrationSwitched {
+		respondWithError(w, http.StatusConflict, "Storage migration has already switched", nil)
+		return
+	}
+	if source := cfg.tenants.Defaults(); source.Bucket != m.SourceBucket || source.Region != m.SourceRegion {
+		respondWithError(w, http.StatusConflict, "The default bucket has changed since the storage migration started", nil)
+		return
+	}
+	if m.Status != database.StorageMigrationCutover {
+		m.Status = database.StorageMigrationCopying
+		m.Error = ""
+	}
+	if err := cfg.storageMigrations.start(cfg, m); err != nil {
+		respondWithError(w, http.StatusConflict, "A storage migration is already running", err)
+		return
+	}
+	respondWithJSON(w, http.StatusAccepted, m)
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tenants"
+	"github.com/google/uuid"
+)
+
+// streamPath is the path of the stream endpoint of video, with q and the
+// token and share key target and video need.
+func (cfg *apiConfig) streamPath(target tenants.Target, video database.Video, q url.Values) string {
+	p := fmt.Sprintf("/api/videos/%s/stream", video.ID)
+	if q = cfg.playbackQuery(target, video, q); len(q) > 0 {
+		p += "?" + q.Encode()
+	}
+	return p
+}
+
+// parseByteRange checks that header is a single byte range, such as
+// bytes=0-1023, bytes=1024- or bytes=-512, and returns it as S3 takes it
+// along with its first byte, or -1 for a suffix range. Anything else,
+// including several ranges, returns false and the whole file is sent, as
+// servers are allowed to.
+func parseByteRange(header string) (string, int64, bool) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok {
+		return "", 0, false
+	}
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return "", 0, false
+	}
+	if first == "" {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 {
+			return "", 0, false
+		}
+		return fmt.Sprintf("bytes=-%d", n), -1, true
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return "", 0, false
+	}
+	if last == "" {
+		return fmt.Sprintf("bytes=%d-", start), start, true
+	}
+	end, err := strconv.ParseInt(last, 10, 64)
+	if err != nil || end < start {
+		return "", 0, false
+	}
+	return fmt.Sprintf("bytes=%d-%d", start, end), start, true
+}
+
+// handlerVideoStream serves a video's file through the API, for
+// deployments that don't hand out storage URLs at all. A Range header is
+// passed on to S3 as a ranged GetObject, so players can seek, and an
+// If-Range with an ETag is checked with If-Match. The checks and
+// ?rendition= are those of handlerVideoPlayback; requests from the start
+// of the file count as a playback.
+func (cfg *apiConfig) handlerVideoStream(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.VideoURL == nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	target, ok := cfg.requirePlayback(w, r, video)
+	if !ok {
+		return
+	}
+	if err := cfg.pickRendition(&video, r.URL.Query().Get("rendition")); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get renditions", err)
+		return
+	}
+	key, ok := storedObjectKey(target, *video.VideoURL)
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't locate video file", fmt.Errorf("video URL %q is not in bucket %s", *video.VideoURL, target.Bucket))
+		return
+	}
+	cfg.setNoIndex(w, target, video)
+	w.Header().Set("Cache-Control", "no-store")
+
+	contentType := videoContentType(key)
+	if contentType == "" {
+		contentType = "video/mp4"
+	}
+
+	if !target.IsS3() {
+		obj, err := target.Storage().Get(r.Context(), key)
+		if errors.Is(err, storage.ErrNotFound) {
+			respondWithError(w, http.StatusGone, "Video file is no longer available", nil)
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusBadGateway, "Couldn't read video file", err)
+			return
+		}
+		defer obj.Close()
+		if _, start, ok := parseByteRange(r.Header.Get("Range")); !ok || start == 0 {
+			cfg.recordAccess(r, video, accessKindPlayback)
+		}
+		w.Header().Set("Content-Type", contentType)
+		// The local backend opens files, which ServeContent handles
+		// ranges and conditional requests of itself.
+		if f, ok := obj.(io.ReadSeeker); ok {
+			http.ServeContent(w, r, "", time.Time{}, f)
+			return
+		}
+		w.Header().Set("Accept-Ranges", "none")
+		if r.Method != http.MethodHead {
+			io.Copy(w, obj)
+		}
+		return
+	}
+
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(target.Bucket),
+		Key:    aws.String(key),
+	}
+	byteRange, start, ranged := parseByteRange(r.Header.Get("Range"))
+	if ifRange := r.Header.Get("If-Range"); ranged && ifRange != "" {
+		// If-Range dates can't be checked before fetching, so the whole
+		// file is sent for those.
+		if strings.HasPrefix(ifRange, `"`) {
+			input.IfMatch = aws.String(ifRange)
+		} else {
+			ranged = false
+		}
+	}
+	if ranged {
+		input.Range = aws.String(byteRange)
+	}
+
+	obj, err := target.Client.GetObject(r.Context(), input)
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "PreconditionFailed" {
+		// The file changed since the client fetched the start of it.
+		ranged = false
+		input.Range, input.IfMatch = nil, nil
+		obj, err = target.Client.GetObject(r.Context(), input)
+	}
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		respondWithError(w, http.StatusGone, "Video file is no longer available", nil)
+		return
+	}
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidRange" {
+		head, headErr := target.Client.HeadObject(r.Context(), &s3.HeadObjectInput{
+			Bucket: aws.String(target.Bucket),
+			Key:    aws.String(key),
+		})
+		if headErr == nil && head.ContentLength != nil {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", *head.ContentLength))
+		}
+		respondWithError(w, http.StatusRequestedRangeNotSatisfiable, "Requested range isn't in the video file", err)
+		return
+	}
+	if err != nil {
+		respondWithStorageError(w, http.StatusBadGateway, "Couldn't read video file", err)
+		return
+	}
+	defer obj.Body.Close()
+
+	if !ranged || start == 0 {
+		cfg.recordAccess(r, video, accessKindPlayback)
+	}
+	h := w.Header()
+	h.Set("Accept-Ranges", "bytes")
+	h.Set("Content-Type", contentType)
+	if obj.ContentType != nil && strings.HasPrefix(*obj.ContentType, "video/") {
+		h.Set("Content-Type", *obj.ContentType)
+	}
+	if obj.ContentLength != nil {
+		h.Set("Content-Length", strconv.FormatInt(*obj.ContentLength, 10))
+	}
+	if obj.ETag != nil {
+		h.Set("ETag", *obj.ETag)
+	}
+	if obj.LastModified != nil {
+		h.Set("Last-Modified", obj.LastModified.UTC().Format(http.TimeFormat))
+	}
+	status := http.StatusOK
+	if ranged && obj.ContentRange != nil {
+		h.Set("Content-Range", *obj.ContentRange)
+		status = http.StatusPartialContent
+	}
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		io.Copy(w, obj.Body)
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/metrics"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// telemetry holds the metrics served on /metrics. It's package-level, like
+// the ffmpeg hooks that feed it, since processing reports to it from places
+// that have no apiConfig.
+var telemetry = newServerMetrics()
+
+type serverMetrics struct {
+	registry *metrics.Registry
+
+	uploads            *metrics.Counter
+	uploadBytes        *metrics.Counter
+	uploadsInFlight    *metrics.Gauge
+	processingDuration *metrics.Histogram
+	ffmpegDuration     *metrics.Histogram
+	s3Requests         *metrics.Counter
+	s3Errors           *metrics.Counter
+	httpRequests       *metrics.Counter
+	httpDuration       *metrics.Histogram
+	cdnPrefetches      *metrics.Counter
+}
+
+func newServerMetrics() *serverMetrics {
+	r := metrics.NewRegistry()
+	return &serverMetrics{
+		registry:           r,
+		uploads:            r.Counter("tubely_uploads_total", "Uploads whose processing ended, by source and outcome.", "source", "outcome"),
+		uploadBytes:        r.Counter("tubely_upload_bytes_total", "Bytes of uploaded files received, by kind and source.", "kind", "source"),
+		uploadsInFlight:    r.Gauge("tubely_uploads_in_flight", "Uploads being received or processed, by source.", "source"),
+		processingDuration: r.Histogram("tubely_processing_duration_seconds", "How long uploads took from start to finish, by source and outcome.", metrics.DefaultBuckets, "source", "outcome"),
+		ffmpegDuration:     r.Histogram("tubely_ffmpeg_duration_seconds", "How long ffmpeg and ffprobe runs took, by binary and outcome.", metrics.DefaultBuckets, "binary", "outcome"),
+		s3Requests:         r.Counter("tubely_s3_requests_total", "S3 calls, after retries, by operation.", "operation"),
+		s3Errors:           r.Counter("tubely_s3_errors_total", "S3 calls that failed, after retries, by operation and error code.", "operation", "code"),
+		httpRequests:       r.Counter("tubely_http_requests_total", "HTTP requests served, by method and status code.", "method", "code"),
+		httpDuration:       r.Histogram("tubely_http_request_duration_seconds", "How long HTTP requests took to serve, by method.", metrics.DefaultBuckets, "method"),
+		cdnPrefetches:      r.Counter("tubely_cdn_prefetch_requests_total", "Requests warming the edge cache, by PoP and outcome.", "pop", "outcome"),
+	}
+}
+
+func outcomeLabel(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+// observeFFmpeg is the ffmpeg package's Completed hook.
+func (m *serverMetrics) observeFFmpeg(bin string, elapsed time.Duration, err error) {
+	binary := "ffmpeg"
+	if strings.HasSuffix(bin, "ffprobe") {
+		binary = "ffprobe"
+	}
+	m.ffmpegDuration.Observe(elapsed.Seconds(), binary, outcomeLabel(err))
+}
+
+// watchQueue adds gauges for how full the processing queue is.
+func (m *serverMetrics) watchQueue(q *jobs.Queue) {
+	m.registry.GaugeFunc("tubely_processing_queue_depth", "Processing jobs waiting for a worker.", func() float64 {
+		return float64(q.Depth())
+	})
+	m.registry.GaugeFunc("tubely_processing_jobs_running", "Processing jobs running on a worker.", func() float64 {
+		return float64(max(q.InFlight()-q.Depth(), 0))
+	})
+	m.registry.GaugeFunc("tubely_processing_oldest_job_age_seconds", "How long the job in the queue longest, waiting or running, has been in it.", func() float64 {
+		return q.Oldest().Seconds()
+	})
+	m.registry.GaugeFunc("tubely_processing_admitted_uploads", "Uploads admitted and not yet processed.", func() float64 {
+		admitted, _ := q.Admitted()
+		return float64(admitted)
+	})
+	m.registry.GaugeFunc("tubely_processing_admit_limit", "How many uploads can be admitted at once, or 0 for no limit.", func() float64 {
+		_, limit := q.Admitted()
+		return float64(limit)
+	})
+}
+
+// S3Options counts the calls of S3 clients and their errors. It goes after
+// the resilience options, so calls are counted once, after retries, and
+// calls the open circuit turns away count as errors.
+func (m *serverMetrics) S3Options(o *s3.Options) {
+	o.APIOptions = append(o.APIOptions, func(stack *middleware.Stack) error {
+		operation := stack.ID()
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("Metrics", func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+			out, md, err := next.HandleInitialize(ctx, in)
+			m.s3Requests.Inc(operation)
+			if err != nil {
+				m.s3Errors.Inc(operation, s3ErrorCode(ctx, err))
+			}
+			return out, md, err
+		}), middleware.Before)
+	})
+}
+
+// s3ErrorCode is the S3 error code of err, such as NoSuchKey or SlowDown,
+// or what kind of failure it was if S3 didn't answer.
+func s3ErrorCode(ctx context.Context, err error) string {
+	var apiErr smithy.APIError
+	var circuitErr *storage.CircuitOpenError
+	switch {
+	case errors.As(err, &circuitErr):
+		return "CircuitOpen"
+	case ctx.Err() != nil:
+		return "Canceled"
+	case errors.As(err, &apiErr):
+		return apiErr.ErrorCode()
+	}
+	return "NetworkError"
+}
+
+// handlerMetrics serves the metrics in the Prometheus text format. With
+// METRICS_TOKEN set, scrapers must send it as a bearer token.
+func (cfg *apiConfig) handlerMetrics(w http.ResponseWriter, r *http.Request) {
+	if cfg.metricsToken != "" {
+		token, err := auth.GetBearerToken(r.Header)
+		if err != nil || subtle.ConstantTimeCompare([]byte(token), []byte(cfg.metricsToken)) != 1 {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't validate metrics token", err)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	telemetry.registry.WriteText(w)
+}
+
+// newLogger returns the logger everything, log.Printf included, goes
+// through: JSON lines with LOG_FORMAT=json, key=value text otherwise. Log
+// lines written with a context carry the request_id in it.
+func newLogger() (*slog.Logger, error) {
+	var h slog.Handler
+	switch format := os.Getenv("LOG_FORMAT"); format {
+	case "", "text":
+		h = slog.NewTextHandler(os.Stderr, nil)
+	case "json":
+		h = slog.NewJSONHandler(os.Stderr, nil)
+	default:
+		return nil, errors.New("LOG_FORMAT must be text or json")
+	}
+	return slog.New(requestIDHandler{h}), nil
+}
+
+// requestIDHandler adds the request ID of the context a line is logged
+// with.
+type requestIDHandler struct {
+	slog.Handler
+}
+
+func (h requestIDHandler) Handle(ctx context.Context, rec slog.Record) error {
+	if id := contextRequestID(ctx); id != "" {
+		rec.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, rec)
+}
+
+func (h requestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestIDHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestIDHandler) WithGroup(name string) slog.Handler {
+	return requestIDHandler{h.Handler.WithGroup(name)}
+}
+
+// statusWriter remembers the status and size of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	if sw.status == 0 {
+		sw.status = status
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusWriter) Write(p []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	n, err := sw.ResponseWriter.Write(p)
+	sw.bytes += int64(n)
+	return n, err
+}
+
+func (sw *statusWriter) Flush() {
+	if f, ok := sw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+// requestLogMiddleware logs every request once it's served, with its
+// request ID, and counts it in the HTTP metrics. It goes inside
+// requestIDMiddleware.
+func (cfg *apiConfig) requestLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		elapsed := time.Since(start)
+		telemetry.httpRequests.Inc(r.Method, strconv.Itoa(sw.status))
+		telemetry.httpDuration.Observe(elapsed.Seconds(), r.Method)
+
+		level := slog.LevelInfo
+		if sw.status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		cfg.logger.Log(r.Context(), level, "request",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", sw.status),
+			slog.Int64("bytes", sw.bytes),
+			slog.Int64("duration_ms", elapsed.Milliseconds()),
+			slog.String("remote_addr", r.RemoteAddr),
+		)
+	})
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+// Thumbnails and their variants are assets, stored under random names.
+// With THUMBNAIL_STORAGE=bucket they go to the default target under
+// thumbnails/, so every instance behind a load balancer sees the same
+// files. The video then records the object key, which is handed out as a
+// presigned or CDN URL like a video file. THUMBNAIL_STORAGE=local, the
+// default in dev, keeps them in ASSETS_ROOT, served from /assets.
+//
+// Thumbnails saved before switching to the bucket stay in ASSETS_ROOT, so
+// assets are looked up by the form of their URL rather than the setting.
+
+// thumbnailPrefix is the key prefix of assets in the bucket.
+const thumbnailPrefix = "thumbnails/"
+
+// assetStore is where new assets are saved, keyed by name.
+func (cfg *apiConfig) assetStore() storage.Storage {
+	if !cfg.bucketThumbnails {
+		return cfg.assets
+	}
+	return storage.Prefixed{Storage: cfg.tenants.Defaults().Storage(), Prefix: thumbnailPrefix}
+}
+
+// putAsset saves body as the named asset. Assets in the bucket get the
+// default target's encryption, like video files.
+func (cfg *apiConfig) putAsset(ctx context.Context, store storage.Storage, name string, body io.Reader, contentType string) error {
+	return store.Put(ctx, name, body, contentType, withEncryption(cfg.tenants.Defaults().Encryption))
+}
+
+// assetURL is the URL recorded for a new asset with the given name.
+func (cfg *apiConfig) assetURL(name string) string {
+	if cfg.bucketThumbnails {
+		return thumbnailPrefix + name
+	}
+	return fmt.Sprintf("http://localhost:%s/assets/%s", cfg.port, name)
+}
+
+// thumbnailAssetName returns the name of the asset a recorded thumbnail URL
+// points at, wherever it is stored. ok is false for thumbnails that aren't
+// assets.
+func (cfg *apiConfig) thumbnailAssetName(thumbnailURL string) (name string, ok bool) {
+	if name, ok := strings.CutPrefix(thumbnailURL, fmt.Sprintf("http://localhost:%s/assets/", cfg.port)); ok {
+		return path.Base(name), true
+	}
+	if name, ok := strings.CutPrefix(thumbnailURL, thumbnailPrefix); ok && name != "" && !strings.Contains(name, "/") {
+		return name, true
+	}
+	return "", false
+}
+
+// assetFile returns a local file with the named asset for ffmpeg to read,
+// and a function that removes it again if it had to be downloaded from the
+// bucket. It returns storage.ErrNotFound if there is no such asset.
+func (cfg *apiConfig) assetFile(ctx context.Context, name string) (string, func(), error) {
+	local := filepath.Join(cfg.assetsRoot, filepath.Base(name))
+	if _, err := os.Stat(local); err == nil || !errors.Is(err, os.ErrNotExist) {
+		return local, func() {}, err
+	}
+	if !cfg.bucketThumbnails {
+		return "", nil, storage.ErrNotFound
+	}
+	downloaded, err := downloadToTemp(ctx, cfg.tenants.Defaults(), thumbnailPrefix+name, "tubely-asset-*"+filepath.Ext(name))
+	if err != nil {
+		return "", nil, err
+	}
+	return downloaded, func() { os.Remove(downloaded) }, nil
+}
+
+// putAssetFile saves the file at path as the named asset.
+func (cfg *apiConfig) putAssetFile(ctx context.Context, name, path, contentType string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return cfg.putAsset(ctx, cfg.assetStore(), name, f, contentType)
+}
+
+// deleteAsset removes the named asset from local disk and, if thumbnails
+// are kept there, the bucket.
+func (cfg *apiConfig) deleteAsset(ctx context.Context, name string) error {
+	if err := cfg.assets.Delete(ctx, name); err != nil {
+		return err
+	}
+	if cfg.bucketThumbnails {
+		return cfg.assetStore().Delete(ctx, name)
+	}
+	return nil
+}
+
+// thumbnailDeliveryURL returns the URL clients fetch a recorded thumbnail
+// URL from: a presigned or CDN URL for assets in the bucket, see
+// signStoredURL, or the local asset's URL on the CDN, see cdnAssetURL.
+func (cfg *apiConfig) thumbnailDeliveryURL(ctx context.Context, video database.Video, thumbnailURL string) (string, error) {
+	if strings.HasPrefix(thumbnailURL, thumbnailPrefix) {
+		return cfg.signStoredURL(ctx, cfg.tenants.Defaults(), video, thumbnailURL)
+	}
+	return cfg.cdnAssetURL(thumbnailURL), nil
+}
+
+// handlerThumbnailRedirect gives a video's thumbnail a stable URL, for
+// places such as the sitemap that can't be handed a URL that expires. It
+// redirects to a fresh one.
+func (cfg *apiConfig) handlerThumbnailRedirect(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil || !cfg.canView(r, video) {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	if video.ThumbnailURL == nil {
+		respondWithError(w, http.StatusNotFound, "Video has no thumbnail", nil)
+		return
+	}
+	thumbnailURL, err := cfg.thumbnailDeliveryURL(r.Context(), video, *video.ThumbnailURL)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URLs", err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, thumbnailURL, http.StatusFound)
+}
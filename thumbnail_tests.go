@@ -0,0 +1,358 @@
+package main
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"log"
+	"math"
+	"math/rand/v2"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	// winnerMinImpressions is how many impressions every candidate needs
+	// before a winner is declared.
+	winnerMinImpressions = 100
+	// winnerConfidence is the confidence the leader must beat the
+	// runner-up's click-through rate with.
+	winnerConfidence = 0.95
+)
+
+const (
+	beaconImpression = "impression"
+	beaconClick      = "click"
+)
+
+type thumbnailCandidateStats struct {
+	database.ThumbnailCandidate
+	ClickThroughRate float64 `json:"click_through_rate"`
+}
+
+// thumbnailTest is a video's candidates with their stats. Winner is set once
+// the leading candidate's click-through rate is ahead of the runner-up's
+// with winnerConfidence; Confidence is reported either way.
+type thumbnailTest struct {
+	Candidates []thumbnailCandidateStats `json:"candidates"`
+	Leader     *uuid.UUID                `json:"leader"`
+	Winner     *uuid.UUID                `json:"winner"`
+	Confidence float64                   `json:"confidence"`
+}
+
+// evaluateThumbnailTest ranks candidates by click-through rate and compares
+// the leader to the runner-up with a one-sided two-proportion z-test.
+func evaluateThumbnailTest(candidates []database.ThumbnailCandidate) thumbnailTest {
+	test := thumbnailTest{Candidates: []thumbnailCandidateStats{}}
+	var leader, runnerUp *thumbnailCandidateStats
+	for _, c := range candidates {
+		stats := thumbnailCandidateStats{ThumbnailCandidate: c}
+		if c.Impressions > 0 {
+			stats.ClickThroughRate = float64(c.Clicks) / float64(c.Impressions)
+		}
+		test.Candidates = append(test.Candidates, stats)
+	}
+	for i := range test.Candidates {
+		c := &test.Candidates[i]
+		switch {
+		case leader == nil || c.ClickThroughRate > leader.ClickThroughRate:
+			leader, runnerUp = c, leader
+		case runnerUp == nil || c.ClickThroughRate > runnerUp.ClickThroughRate:
+			runnerUp = c
+		}
+	}
+	if leader == nil || leader.Impressions == 0 {
+		return test
+	}
+	test.Leader = &leader.ID
+	if runnerUp == nil || runnerUp.Impressions == 0 {
+		return test
+	}
+
+	n1, n2 := float64(leader.Impressions), float64(runnerUp.Impressions)
+	pooled := float64(leader.Clicks+runnerUp.Clicks) / (n1 + n2)
+	se := math.Sqrt(pooled * (1 - pooled) * (1/n1 + 1/n2))
+	if se == 0 {
+		return test
+	}
+	z := (leader.ClickThroughRate - runnerUp.ClickThroughRate) / se
+	test.Confidence = 0.5 * math.Erfc(-z/math.Sqrt2)
+
+	for _, c := range test.Candidates {
+		if c.Impressions < winnerMinImpressions {
+			return test
+		}
+	}
+	if test.Confidence >= winnerConfidence {
+		test.Winner = &leader.ID
+	}
+	return test
+}
+
+// requireVideoOwner loads the video in the path and checks that the
+// requester owns it. If ok is false, an error response has been written.
+func (cfg *apiConfig) requireVideoOwner(w http.ResponseWriter, r *http.Request) (database.Video, bool) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return database.Video{}, false
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return database.Video{}, false
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return database.Video{}, false
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return database.Video{}, false
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return database.Video{}, false
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't update this video", nil)
+		return database.Video{}, false
+	}
+	return video, true
+}
+
+// videoCandidate loads the candidate in the path and checks that it belongs
+// to video. If ok is false, an error response has been written.
+func (cfg *apiConfig) videoCandidate(w http.ResponseWriter, r *http.Request, video database.Video) (*database.ThumbnailCandidate, bool) {
+	candidateID, err := uuid.Parse(r.PathValue("candidateID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return nil, false
+	}
+	candidate, err := cfg.db.GetThumbnailCandidate(candidateID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get thumbnail candidates", err)
+		return nil, false
+	}
+	if candidate == nil || candidate.VideoID != video.ID {
+		respondWithError(w, http.StatusNotFound, "Thumbnail candidate not found", nil)
+		return nil, false
+	}
+	return candidate, true
+}
+
+func (cfg *apiConfig) handlerThumbnailCandidateCreate(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.requireVideoOwner(w, r)
+	if !ok || !requireNoLegalHold(w, video) {
+		return
+	}
+
+	existing, err := cfg.db.GetThumbnailCandidates(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get thumbnail candidates", err)
+		return
+	}
+	if len(existing) >= cfg.maxThumbnailCandidates {
+		respondWithError(w, http.StatusConflict, "This video already has the maximum number of thumbnail candidates", nil)
+		return
+	}
+
+	cleanup := &cleanupStack{}
+	defer cleanup.run()
+
+	thumbnail, ok := cfg.saveThumbnailForm(w, r, cleanup)
+	if !ok {
+		return
+	}
+
+	candidate := database.ThumbnailCandidate{
+		ID:        uuid.New(),
+		VideoID:   video.ID,
+		URL:       thumbnail.URL,
+		CreatedAt: cfg.clock.Now().UTC(),
+	}
+	if err := cfg.db.CreateThumbnailCandidate(candidate); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save thumbnail candidate", err)
+		return
+	}
+
+	cleanup.commit()
+	respondWithJSON(w, http.StatusCreated, candidate)
+}
+
+func (cfg *apiConfig) handlerThumbnailCandidatesList(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.requireVideoOwner(w, r)
+	if !ok {
+		return
+	}
+	candidates, err := cfg.db.GetThumbnailCandidates(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get thumbnail candidates", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, evaluateThumbnailTest(candidates))
+}
+
+func (cfg *apiConfig) handlerThumbnailCandidateDelete(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.requireVideoOwner(w, r)
+	if !ok || !requireNoLegalHold(w, video) {
+		return
+	}
+	candidate, ok := cfg.videoCandidate(w, r, video)
+	if !ok {
+		return
+	}
+
+	if err := cfg.db.DeleteThumbnailCandidate(candidate.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete thumbnail candidate", err)
+		return
+	}
+	// The file stays if the candidate was promoted, since it is then the
+	// video's thumbnail.
+	if video.ThumbnailURL == nil || *video.ThumbnailURL != candidate.URL {
+		if name, ok := cfg.thumbnailAssetName(candidate.URL); ok {
+			if err := cfg.deleteAsset(r.Context(), name); err != nil {
+				log.Printf("Couldn't remove thumbnail candidate %s: %v", name, err)
+			}
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerThumbnailCandidatePromote makes a candidate the video's thumbnail,
+// typically once it has won the test. The test keeps running so its stats
+// stay available.
+func (cfg *apiConfig) handlerThumbnailCandidatePromote(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.requireVideoOwner(w, r)
+	if !ok || !requireNoLegalHold(w, video) {
+		return
+	}
+	candidate, ok := cfg.videoCandidate(w, r, video)
+	if !ok {
+		return
+	}
+
+	replaced := cfg.replacedThumbnailPaths(video)
+	previous := video.ThumbnailURL
+	// Candidates aren't hashed, so a later re-upload of the old thumbnail
+	// isn't mistaken for the current one.
+	video.ThumbnailURL = &candidate.URL
+	video.ThumbnailSHA256 = ""
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+	cfg.emitEvent(eventVideoUpdated, video.ID, nil)
+	cfg.emitEvent(eventThumbnailUpdated, video.ID, map[string]any{"source": "candidate", "candidate_id": candidate.ID})
+	if cfg.cdn != nil && len(replaced) > 0 {
+		cfg.background.run(func() { cfg.invalidate(video.ID, "thumbnail", replaced) })
+	}
+	if previous != nil && *previous != candidate.URL {
+		if err := cfg.deleteThumbnailFile(r.Context(), video.ID, *previous); err != nil {
+			log.Printf("Couldn't delete previous thumbnail of video %s: %v", video.ID, err)
+		}
+	}
+	if name, ok := cfg.thumbnailAssetName(candidate.URL); ok && cfg.thumbnails.enabled() {
+		if _, err := cfg.generateThumbnailVariants(r.Context(), video.ID, name); err != nil {
+			log.Printf("Couldn't generate thumbnail variants for video %s: %v", video.ID, err)
+		}
+	}
+	video, err := cfg.dbVideoToSignedVideo(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URLs", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+// handlerThumbnailCandidatePick chooses which candidate a viewer sees.
+// Signed-in viewers always get the same one so their clicks are attributed
+// consistently; anonymous viewers get one at random.
+func (cfg *apiConfig) handlerThumbnailCandidatePick(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil || !cfg.canView(r, video) {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+
+	candidates, err := cfg.db.GetThumbnailCandidates(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get thumbnail candidates", err)
+		return
+	}
+	if len(candidates) == 0 {
+		respondWithError(w, http.StatusNotFound, "Thumbnail candidate not found", nil)
+		return
+	}
+
+	i := rand.IntN(len(candidates))
+	if viewerID := cfg.viewerID(r); viewerID != nil {
+		h := fnv.New32a()
+		h.Write(viewerID[:])
+		h.Write(videoID[:])
+		i = int(h.Sum32() % uint32(len(candidates)))
+	}
+
+	type response struct {
+		CandidateID uuid.UUID `json:"candidate_id"`
+		URL         string    `json:"url"`
+	}
+	candidateURL, err := cfg.thumbnailDeliveryURL(r.Context(), video, candidates[i].URL)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URLs", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, response{CandidateID: candidates[i].ID, URL: candidateURL})
+}
+
+// handlerThumbnailBeacon records that a candidate was shown or clicked. It
+// accepts bodies sent with navigator.sendBeacon, which can't set a JSON
+// Content-Type, and needs no auth.
+func (cfg *apiConfig) handlerThumbnailBeacon(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		CandidateID uuid.UUID `json:"candidate_id"`
+		Event       string    `json:"event"`
+	}
+	params := parameters{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<10)).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	impressions, clicks := 0, 0
+	switch params.Event {
+	case beaconImpression:
+		impressions = 1
+	case beaconClick:
+		clicks = 1
+	default:
+		respondWithError(w, http.StatusBadRequest, "event must be impression or click", nil)
+		return
+	}
+
+	found, err := cfg.db.RecordThumbnailStats(params.CandidateID, impressions, clicks)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't record thumbnail stats", err)
+		return
+	}
+	if !found {
+		respondWithError(w, http.StatusNotFound, "Thumbnail candidate not found", nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
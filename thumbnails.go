@@ -0,0 +1,458 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ffmpeg"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/images"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultRegenConcurrency = 2
+	maxRegenConcurrency     = 8
+	// maxRegenErrors caps how many per-video failures a job keeps for its
+	// progress report.
+	maxRegenErrors = 100
+)
+
+// thumbnailPipeline is the set of variants generated for each uploaded
+// thumbnail: every width in every format. No widths means only the original
+// is kept.
+type thumbnailPipeline struct {
+	Widths  []int    `json:"widths"`
+	Formats []string `json:"formats"`
+}
+
+// Default thumbnail variants, for srcset: three widths, each as JPEG and
+// as the smaller WebP.
+const (
+	defaultThumbnailWidths  = "320,640,1280"
+	defaultThumbnailFormats = "jpeg,webp"
+)
+
+// parseThumbnailPipeline parses the comma-separated THUMBNAIL_WIDTHS and
+// THUMBNAIL_FORMATS settings. Widths "off" keeps only the original.
+func parseThumbnailPipeline(widths, formats string) (thumbnailPipeline, error) {
+	p := thumbnailPipeline{Widths: []int{}, Formats: []string{}}
+	switch strings.TrimSpace(widths) {
+	case "":
+		widths = defaultThumbnailWidths
+	case "off":
+		widths = ""
+	}
+	for _, s := range strings.Split(widths, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		width, err := strconv.Atoi(s)
+		if err != nil || width < 16 || width > 4096 {
+			return p, fmt.Errorf("thumbnail width %q must be an integer from 16 to 4096", s)
+		}
+		if !slices.Contains(p.Widths, width) {
+			p.Widths = append(p.Widths, width)
+		}
+	}
+	if strings.TrimSpace(formats) == "" {
+		formats = defaultThumbnailFormats
+	}
+	for _, s := range strings.Split(formats, ",") {
+		s = strings.ToLower(strings.TrimSpace(s))
+		if s == "" {
+			continue
+		}
+		if _, ok := ffmpeg.ImageExtensions[s]; !ok {
+			return p, fmt.Errorf("unknown thumbnail format %q, expected jpeg or webp", s)
+		}
+		if !slices.Contains(p.Formats, s) {
+			p.Formats = append(p.Formats, s)
+		}
+	}
+	slices.Sort(p.Widths)
+	slices.Reverse(p.Widths)
+	return p, nil
+}
+
+func (p thumbnailPipeline) enabled() bool {
+	return len(p.Widths) > 0 && len(p.Formats) > 0
+}
+
+// generateThumbnailVariants encodes the named thumbnail asset with the
+// current pipeline settings and replaces the video's stored variants.
+// Variants are named after the source, so regenerating overwrites them in
+// place; variants the pipeline no longer produces are deleted.
+func (cfg *apiConfig) generateThumbnailVariants(ctx context.Context, videoID uuid.UUID, name string) ([]database.ThumbnailVariant, error) {
+	previous, err := cfg.db.GetThumbnailVariants(videoID)
+	if err != nil {
+		return nil, err
+	}
+	source, remove, err := cfg.assetFile(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer remove()
+	dir, err := os.MkdirTemp("", "tubely-variants-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	// JPEG and PNG sources are scaled in Go, which also tells which widths
+	// would be upscales and can be left out. Anything else, and formats Go
+	// can't encode, go through ffmpeg.
+	img, _ := decodeImageFile(source)
+
+	base := strings.TrimSuffix(name, filepath.Ext(name))
+	variants := []database.ThumbnailVariant{}
+	for _, width := range cfg.thumbnails.Widths {
+		if img != nil && width > img.Bounds().Dx() {
+			continue
+		}
+		var resized image.Image
+		for _, format := range cfg.thumbnails.Formats {
+			variantName := fmt.Sprintf("%s-%d%s", base, width, ffmpeg.ImageExtensions[format])
+			output := filepath.Join(dir, variantName)
+			if img != nil && format == ffmpeg.ImageJPEG {
+				if resized == nil {
+					resized = images.Resize(img, width)
+				}
+				err = writeJPEGFile(output, resized)
+			} else {
+				_, err = ffmpeg.ThumbnailCommand(source, output, width, format).Run(ctx)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%dpx %s: %w", width, format, err)
+			}
+			if err := cfg.putAssetFile(ctx, variantName, output, "image/"+format); err != nil {
+				return nil, fmt.Errorf("%dpx %s: %w", width, format, err)
+			}
+			variants = append(variants, database.ThumbnailVariant{
+				Width:  width,
+				Format: format,
+				URL:    cfg.assetURL(variantName),
+			})
+		}
+	}
+	if err := cfg.db.ReplaceThumbnailVariants(videoID, variants); err != nil {
+		return nil, err
+	}
+
+	for _, old := range previous {
+		if slices.ContainsFunc(variants, func(v database.ThumbnailVariant) bool { return v.URL == old.URL }) {
+			continue
+		}
+		if oldName, ok := cfg.thumbnailAssetName(old.URL); ok {
+			if err := cfg.deleteAsset(ctx, oldName); err != nil {
+				log.Printf("Couldn't remove stale thumbnail variant %s: %v", oldName, err)
+			}
+		}
+	}
+	return variants, nil
+}
+
+func decodeImageFile(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, _, err := images.Decode(f)
+	return img, err
+}
+
+func writeJPEGFile(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := images.EncodeJPEG(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (cfg *apiConfig) handlerThumbnailVariantsGet(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil || !cfg.canView(r, video) {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+
+	variants, err := cfg.db.GetThumbnailVariants(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get thumbnail variants", err)
+		return
+	}
+	for i := range variants {
+		variants[i].URL, err = cfg.thumbnailDeliveryURL(r.Context(), video, variants[i].URL)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URLs", err)
+			return
+		}
+	}
+	respondWithJSON(w, http.StatusOK, variants)
+}
+
+// thumbnailRegenFilter narrows a regeneration job to some videos. Empty
+// fields match everything.
+type thumbnailRegenFilter struct {
+	UserID        *uuid.UUID  `json:"user_id,omitempty"`
+	VideoIDs      []uuid.UUID `json:"video_ids,omitempty"`
+	CreatedAfter  *time.Time  `json:"created_after,omitempty"`
+	CreatedBefore *time.Time  `json:"created_before,omitempty"`
+}
+
+func (f thumbnailRegenFilter) matches(video database.Video) bool {
+	if f.UserID != nil && video.UserID != *f.UserID {
+		return false
+	}
+	if len(f.VideoIDs) > 0 && !slices.Contains(f.VideoIDs, video.ID) {
+		return false
+	}
+	if f.CreatedAfter != nil && !video.CreatedAt.After(*f.CreatedAfter) {
+		return false
+	}
+	if f.CreatedBefore != nil && !video.CreatedAt.Before(*f.CreatedBefore) {
+		return false
+	}
+	return true
+}
+
+type thumbnailRegenError struct {
+	VideoID uuid.UUID `json:"video_id"`
+	Error   string    `json:"error"`
+}
+
+// thumbnailRegenJob is a bulk regeneration run. Its counters are updated by
+// the workers as they go, so polling it reports progress.
+type thumbnailRegenJob struct {
+	mu sync.Mutex
+
+	ID          uuid.UUID             `json:"id"`
+	Status      jobs.Status           `json:"status"`
+	Filter      thumbnailRegenFilter  `json:"filter"`
+	Pipeline    thumbnailPipeline     `json:"pipeline"`
+	Concurrency int                   `json:"concurrency"`
+	Total       int                   `json:"total"`
+	Done        int                   `json:"done"`
+	Failed      int                   `json:"failed"`
+	Skipped     int                   `json:"skipped"`
+	Errors      []thumbnailRegenError `json:"errors"`
+	StartedAt   time.Time             `json:"started_at"`
+	FinishedAt  *time.Time            `json:"finished_at,omitempty"`
+}
+
+// snapshot returns a copy of the job that is safe to encode while workers
+// keep updating the original.
+func (j *thumbnailRegenJob) snapshot() *thumbnailRegenJob {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return &thumbnailRegenJob{
+		ID:          j.ID,
+		Status:      j.Status,
+		Filter:      j.Filter,
+		Pipeline:    j.Pipeline,
+		Concurrency: j.Concurrency,
+		Total:       j.Total,
+		Done:        j.Done,
+		Failed:      j.Failed,
+		Skipped:     j.Skipped,
+		Errors:      slices.Clone(j.Errors),
+		StartedAt:   j.StartedAt,
+		FinishedAt:  j.FinishedAt,
+	}
+}
+
+func (j *thumbnailRegenJob) record(videoID uuid.UUID, skipped bool, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	switch {
+	case err != nil:
+		j.Failed++
+		if len(j.Errors) < maxRegenErrors {
+			j.Errors = append(j.Errors, thumbnailRegenError{VideoID: videoID, Error: err.Error()})
+		}
+	case skipped:
+		j.Skipped++
+	default:
+		j.Done++
+	}
+}
+
+// thumbnailRegens keeps regeneration jobs in memory. Only one runs at a
+// time so a bulk run can't starve thumbnail uploads of CPU.
+type thumbnailRegens struct {
+	mu   sync.Mutex
+	jobs map[uuid.UUID]*thumbnailRegenJob
+}
+
+func newThumbnailRegens() *thumbnailRegens {
+	return &thumbnailRegens{jobs: map[uuid.UUID]*thumbnailRegenJob{}}
+}
+
+var errRegenRunning = errors.New("a thumbnail regeneration job is already running")
+
+func (t *thumbnailRegens) start(job *thumbnailRegenJob) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, other := range t.jobs {
+		if other.snapshot().Status == jobs.StatusProcessing {
+			return errRegenRunning
+		}
+	}
+	t.jobs[job.ID] = job
+	return nil
+}
+
+func (t *thumbnailRegens) get(id uuid.UUID) *thumbnailRegenJob {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.jobs[id]
+}
+
+// runThumbnailRegen regenerates the thumbnails of videos on job.Concurrency
+// workers. Videos without a thumbnail, or whose thumbnail isn't stored in
+// the assets directory, are skipped.
+func (cfg *apiConfig) runThumbnailRegen(ctx context.Context, job *thumbnailRegenJob, videos []database.Video) {
+	queue := make(chan database.Video)
+	var wg sync.WaitGroup
+	for range job.Concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for video := range queue {
+				skipped, err := cfg.regenerateThumbnail(ctx, video)
+				job.record(video.ID, skipped, err)
+			}
+		}()
+	}
+	for _, video := range videos {
+		queue <- video
+	}
+	close(queue)
+	wg.Wait()
+
+	finishedAt := cfg.clock.Now().UTC()
+	job.mu.Lock()
+	job.Status = jobs.StatusDone
+	job.FinishedAt = &finishedAt
+	log.Printf("Thumbnail regeneration %s finished: %d done, %d failed, %d skipped", job.ID, job.Done, job.Failed, job.Skipped)
+	job.mu.Unlock()
+}
+
+func (cfg *apiConfig) regenerateThumbnail(ctx context.Context, video database.Video) (skipped bool, err error) {
+	if video.ThumbnailURL == nil || video.LegalHold {
+		return true, nil
+	}
+	name, ok := cfg.thumbnailAssetName(*video.ThumbnailURL)
+	if !ok {
+		return true, nil
+	}
+	_, err = cfg.generateThumbnailVariants(ctx, video.ID, name)
+	if errors.Is(err, storage.ErrNotFound) {
+		return false, errors.New("thumbnail file missing")
+	}
+	return false, err
+}
+
+func (cfg *apiConfig) handlerThumbnailRegenStart(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+
+	type parameters struct {
+		thumbnailRegenFilter
+		Concurrency int `json:"concurrency"`
+	}
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Concurrency == 0 {
+		params.Concurrency = defaultRegenConcurrency
+	}
+	if params.Concurrency < 1 || params.Concurrency > maxRegenConcurrency {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("concurrency must be between 1 and %d", maxRegenConcurrency), nil)
+		return
+	}
+	if !cfg.thumbnails.enabled() {
+		respondWithError(w, http.StatusConflict, "Thumbnail variants are not configured", nil)
+		return
+	}
+
+	all, err := cfg.db.GetAllVideos()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		return
+	}
+	videos := []database.Video{}
+	for _, video := range all {
+		if params.thumbnailRegenFilter.matches(video) {
+			videos = append(videos, video)
+		}
+	}
+
+	job := &thumbnailRegenJob{
+		ID:          uuid.New(),
+		Status:      jobs.StatusProcessing,
+		Filter:      params.thumbnailRegenFilter,
+		Pipeline:    cfg.thumbnails,
+		Concurrency: params.Concurrency,
+		Total:       len(videos),
+		Errors:      []thumbnailRegenError{},
+		StartedAt:   cfg.clock.Now().UTC(),
+	}
+	if err := cfg.thumbnailRegens.start(job); err != nil {
+		respondWithError(w, http.StatusConflict, "A thumbnail regeneration job is already running", err)
+		return
+	}
+	cfg.background.run(func() { cfg.runThumbnailRegen(context.Background(), job, videos) })
+
+	respondWithJSON(w, http.StatusAccepted, job.snapshot())
+}
+
+func (cfg *apiConfig) handlerThumbnailRegenGet(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+	jobID, err := uuid.Parse(r.PathValue("jobID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+	job := cfg.thumbnailRegens.get(jobID)
+	if job == nil {
+		respondWithError(w, http.StatusNotFound, "Thumbnail regeneration job not found", nil)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, job.snapshot())
+}
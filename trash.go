@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tenants"
+	"github.com/google/uuid"
+)
+
+// trashReaperInterval is how often videos that have been in the trash for
+// longer than the retention period are purged, by default.
+const trashReaperInterval = time.Hour
+
+// trashedTag is the S3 object tag put on the objects of trashed videos, so
+// bucket lifecycle rules and inventory reports can tell them apart.
+const trashedTag = "tubely-trashed"
+
+// trashedVideo is a video in the trash, and when it will be purged unless
+// it's restored first.
+type trashedVideo struct {
+	database.Video
+	PurgeAt time.Time `json:"purge_at"`
+}
+
+// trashVideo moves the owner's video to the trash, ending its uploads in
+// progress. It disappears from everywhere but the trash, and its objects
+// are tagged as trashed, until it's restored or the reaper purges it. A
+// failure to tag is only logged: the video's row is what decides whether
+// it's trashed.
+func (cfg *apiConfig) trashVideo(w http.ResponseWriter, r *http.Request, video database.Video) {
+	if err := cfg.endVideoUploadSessions(r.Context(), video.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete video", err)
+		return
+	}
+	if _, err := cfg.db.TrashVideo(video.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete video", err)
+		return
+	}
+	if n, err := cfg.setTrashedTags(r.Context(), video, true); err != nil {
+		log.Printf("Couldn't tag the objects of trashed video %s (%d tagged): %v", video.ID, n, err)
+	}
+
+	cfg.recordActivity(video.UserID, activityVideoTrashed, &video.ID, nil, video.Title)
+	cfg.emitOwnedEvent(eventVideoTrashed, video.ID, video.UserID, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerVideoTrash lists the user's trashed videos, most recently trashed
+// first.
+func (cfg *apiConfig) handlerVideoTrash(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	videos, err := cfg.db.GetTrashedVideos(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		return
+	}
+	videos, err = cfg.dbVideosToSignedVideos(r.Context(), videos)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URLs", err)
+		return
+	}
+	trashed := make([]trashedVideo, 0, len(videos))
+	for _, video := range videos {
+		trashed = append(trashed, trashedVideo{Video: video, PurgeAt: video.DeletedAt.Add(cfg.trashRetention)})
+	}
+	respondWithJSON(w, http.StatusOK, trashed)
+}
+
+// handlerVideoRestore takes the owner's video out of the trash, as it was
+// when it was trashed.
+func (cfg *apiConfig) handlerVideoRestore(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.db.GetTrashedVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil || video.UserID != userID {
+		respondWithError(w, http.StatusNotFound, "Video isn't in the trash", nil)
+		return
+	}
+	restored, err := cfg.db.RestoreVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't restore video", err)
+		return
+	}
+	if !restored {
+		respondWithError(w, http.StatusNotFound, "Video isn't in the trash", nil)
+		return
+	}
+	if n, err := cfg.setTrashedTags(r.Context(), video, false); err != nil {
+		log.Printf("Couldn't untag the objects of restored video %s (%d untagged): %v", video.ID, n, err)
+	}
+	cfg.recordActivity(userID, activityVideoRestored, &videoID, nil, video.Title)
+	cfg.emitEvent(eventVideoRestored, videoID, nil)
+
+	video, err = cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	video, err = cfg.dbVideoToSignedVideo(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URLs", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+// setTrashedTags tags every object under the video's prefix, and its file
+// if it predates namespaced keys, as trashed, or removes the tag. A file
+// shared with other videos by its content is only tagged while this video
+// is its last reference, so a lifecycle rule on the tag can't take it from
+// videos that are still live. Backends other than S3 have no tags. It
+// returns how many objects it changed.
+func (cfg *apiConfig) setTrashedTags(ctx context.Context, video database.Video, trashed bool) (int, error) {
+	target, err := cfg.videoTarget(ctx, video)
+	if err != nil {
+		return 0, err
+	}
+	if !target.IsS3() {
+		return 0, nil
+	}
+	keys, err := videoPrefixKeys(ctx, target, video)
+	if err != nil {
+		return 0, err
+	}
+	contentKey := ""
+	if _, key, err := cfg.videoObject(ctx, video); err == nil && !isNamespacedKey(key, video.UserID, video.ID) {
+		if isContentKey(key) {
+			contentKey = key
+		} else {
+			keys = append(keys, key)
+		}
+	}
+
+	for i, key := range keys {
+		if err := setObjectTrashed(ctx, target, key, trashed); err != nil {
+			return i, err
+		}
+	}
+	if contentKey == "" {
+		return len(keys), nil
+	}
+
+	// Held while tagging, so another video can't start sharing the file
+	// in between.
+	cfg.contentObjects.mu.Lock()
+	defer cfg.contentObjects.mu.Unlock()
+	refs, tracked, err := cfg.db.ContentObjectRefs(target.Bucket, contentKey)
+	if err != nil {
+		return len(keys), err
+	}
+	if trashed && tracked && refs > 1 {
+		return len(keys), nil
+	}
+	if err := setObjectTrashed(ctx, target, contentKey, trashed); err != nil {
+		return len(keys), err
+	}
+	return len(keys) + 1, nil
+}
+
+// setObjectTrashed tags the object under key in target as trashed, or
+// removes the tag.
+func setObjectTrashed(ctx context.Context, target tenants.Target, key string, trashed bool) error {
+	if trashed {
+		_, err := target.Client.PutObjectTagging(ctx, &s3.PutObjectTaggingInput{
+			Bucket: aws.String(target.Bucket),
+			Key:    aws.String(key),
+			Tagging: &types.Tagging{TagSet: []types.Tag{
+				{Key: aws.String(trashedTag), Value: aws.String("true")},
+			}},
+		})
+		return err
+	}
+	_, err := target.Client.DeleteObjectTagging(ctx, &s3.DeleteObjectTaggingInput{
+		Bucket: aws.String(target.Bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
+// videoPrefixKeys lists the keys of the objects under the video's prefix.
+func videoPrefixKeys(ctx context.Context, target tenants.Target, video database.Video) ([]string, error) {
+	keys := []string{}
+	paginator := s3.NewListObjectsV2Paginator(target.Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(target.Bucket),
+		Prefix: aws.String(videoKeyPrefix(video.UserID, video.ID)),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range page.Contents {
+			keys = append(keys, aws.ToString(obj.Key))
+		}
+	}
+	return keys, nil
+}
+
+// trashReapResult is what a sweep of the trash did. Kept videos are under
+// a legal hold or Object Lock.
+type trashReapResult struct {
+	Purged int `json:"purged"`
+	Kept   int `json:"kept"`
+	Failed int `json:"failed"`
+}
+
+// reapTrash purges the videos trashed longer than the retention period
+// ago. Videos under a legal hold, or whose file is locked by S3 Object
+// Lock, are kept and tried again on the next sweep. It fails if any video
+// couldn't be purged, after trying the rest.
+func (cfg *apiConfig) reapTrash(ctx context.Context) (trashReapResult, error) {
+	result := trashReapResult{}
+	videos, err := cfg.db.GetVideosTrashedBefore(cfg.clock.Now().Add(-cfg.trashRetention))
+	if err != nil {
+		return result, fmt.Errorf("couldn't get expired trash: %w", err)
+	}
+	for _, video := range videos {
+		if video.LegalHold {
+			result.Kept++
+			continue
+		}
+		if video.VideoURL != nil {
+			target, key, err := cfg.videoObject(ctx, video)
+			if err != nil {
+				log.Printf("Couldn't locate the file of trashed video %s: %v", video.ID, err)
+				result.Failed++
+				continue
+			}
+			lock, err := headObjectLock(ctx, target, key)
+			if err != nil {
+				log.Printf("Couldn't check the file of trashed video %s: %v", video.ID, err)
+				result.Failed++
+				continue
+			}
+			if lock.locked(cfg.clock.Now()) {
+				result.Kept++
+				continue
+			}
+		}
+		if err := cfg.purgeVideo(ctx, video); err != nil {
+			log.Printf("Couldn't purge trashed video %s: %v", video.ID, err)
+			result.Failed++
+			continue
+		}
+		result.Purged++
+	}
+	if result.Failed > 0 {
+		return result, fmt.Errorf("couldn't purge %d trashed videos", result.Failed)
+	}
+	return result, nil
+}
+
+// purgeVideo deletes a video for good: its row and everything recorded
+// with it, then its objects, caption files and thumbnail assets. The files
+// are deleted once the row is gone, so a failed deletion can't leave the
+// video pointing at missing objects.
+func (cfg *apiConfig) purgeVideo(ctx context.Context, video database.Video) error {
+	target, err := cfg.videoTarget(ctx, video)
+	if err != nil {
+		return fmt.Errorf("couldn't locate video file: %w", err)
+	}
+	renditions, err := cfg.db.GetRenditions(video.ID)
+	if err != nil {
+		return fmt.Errorf("couldn't get renditions: %w", err)
+	}
+	assetURLs, err := cfg.thumbnailAssetURLs(video.ID)
+	if err != nil {
+		return fmt.Errorf("couldn't get thumbnail variants: %w", err)
+	}
+	captions, err := cfg.db.GetCaptionTracks(video.ID)
+	if err != nil {
+		return fmt.Errorf("couldn't get caption tracks: %w", err)
+	}
+	if err := cfg.endVideoUploadSessions(ctx, video.ID); err != nil {
+		return err
+	}
+
+	cleanup := &cleanupStack{}
+	defer cleanup.run()
+	if err := cfg.db.DeleteVideo(video.ID); err != nil {
+		return err
+	}
+	cfg.deleteVideoFilesOnCommit(cleanup, target, video, renditions)
+	deleteCaptionFilesOnCommit(cleanup, target, captions...)
+	cfg.deleteThumbnailOnCommit(cleanup, video)
+	cleanup.onCommit("delete thumbnail assets of video "+video.ID.String(), func() error {
+		return cfg.removeAssetFiles(context.Background(), assetURLs)
+	})
+	cleanup.commit()
+
+	cfg.recordActivity(video.UserID, activityVideoDeleted, &video.ID, nil, video.Title)
+	cfg.emitOwnedEvent(eventVideoDeleted, video.ID, video.UserID, nil)
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/fakes"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestTrashedTagsOfSharedFiles(t *testing.T) {
+	type step struct {
+		action string // upload, trash or restore
+		video  int
+	}
+	tests := []struct {
+		name  string
+		steps []step
+		// wantTagged is whether the file the videos share ends up tagged
+		// as trashed.
+		wantTagged bool
+	}{
+		{name: "only reference trashed", steps: []step{{"upload", 0}, {"trash", 0}}, wantTagged: true},
+		{name: "only reference restored", steps: []step{{"upload", 0}, {"trash", 0}, {"restore", 0}}},
+		{name: "one of two trashed", steps: []step{{"upload", 0}, {"upload", 1}, {"trash", 0}}},
+		{name: "shared after trashing", steps: []step{{"upload", 0}, {"trash", 0}, {"upload", 1}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s3 := fakes.NewS3(testBucket)
+			defer s3.Close()
+			cfg := newTestConfig(t, s3, newTestFFmpeg())
+			videos := make([]database.Video, 2)
+			tokens := make([]string, 2)
+			for i := range videos {
+				videos[i], tokens[i] = newTestVideo(t, cfg)
+			}
+
+			for _, s := range tt.steps {
+				i := s.video
+				switch s.action {
+				case "upload":
+					videos[i] = uploadTestVideo(t, cfg, videos[i], tokens[i])
+				case "trash":
+					req := httptest.NewRequest(http.MethodDelete, "/api/videos/"+videos[i].ID.String(), nil)
+					rec := httptest.NewRecorder()
+					cfg.trashVideo(rec, req, videos[i])
+					if rec.Code != http.StatusNoContent {
+						t.Fatalf("trash responded %d: %s", rec.Code, rec.Body)
+					}
+				case "restore":
+					req := httptest.NewRequest(http.MethodPost, "/api/videos/"+videos[i].ID.String()+"/restore", nil)
+					req.SetPathValue("videoID", videos[i].ID.String())
+					req.Header.Set("Authorization", "Bearer "+tokens[i])
+					rec := httptest.NewRecorder()
+					cfg.handlerVideoRestore(rec, req)
+					if rec.Code != http.StatusOK {
+						t.Fatalf("restore responded %d: %s", rec.Code, rec.Body)
+					}
+				}
+			}
+
+			file := *videos[0].VideoURL
+			if !isContentKey(file) {
+				t.Fatalf("video file %s isn't stored by its content", file)
+			}
+			if tagged := s3.Tags(testBucket, file)[trashedTag] == "true"; tagged != tt.wantTagged {
+				t.Errorf("shared file tagged = %t, want %t", tagged, tt.wantTagged)
+			}
+			// The videos' own objects follow whether each is trashed.
+			for i, video := range videos {
+				if video.PeaksURL == nil {
+					continue
+				}
+				trashed, err := cfg.db.GetTrashedVideo(video.ID)
+				if err != nil {
+					t.Fatalf("GetTrashedVideo: %v", err)
+				}
+				want := trashed.ID == video.ID
+				if tagged := s3.Tags(testBucket, *video.PeaksURL)[trashedTag] == "true"; tagged != want {
+					t.Errorf("peaks of video %d tagged = %t, want %t", i, tagged, want)
+				}
+			}
+			if _, err := cfg.background.wait(context.Background()); err != nil {
+				t.Fatalf("waiting for background jobs: %v", err)
+			}
+		})
+	}
+}
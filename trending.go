@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	listingDefaultLimit = 20
+	listingMaxLimit     = 100
+	// trendingCategories is how many categories a listing breaks its videos
+	// down into, and trendingPerCategory how many videos each one lists.
+	trendingCategories  = 10
+	trendingPerCategory = 5
+)
+
+// trendingWindow is a period GET /api/videos/trending counts views over,
+// named as it was configured.
+type trendingWindow struct {
+	Name     string
+	Duration time.Duration
+}
+
+// parseTrendingWindows parses the TRENDING_WINDOWS setting, a
+// comma-separated list of durations such as "24h,7d". Views are counted by
+// the hour, so each must be a whole number of hours. The first is the
+// default window.
+func parseTrendingWindows(s string) ([]trendingWindow, error) {
+	if strings.TrimSpace(s) == "" {
+		s = "24h,7d"
+	}
+	windows := []trendingWindow{}
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		var d time.Duration
+		var err error
+		if days, ok := strings.CutSuffix(name, "d"); ok {
+			var n int
+			n, err = strconv.Atoi(days)
+			d = time.Duration(n) * 24 * time.Hour
+		} else {
+			d, err = time.ParseDuration(name)
+		}
+		if err != nil || d < time.Hour || d%time.Hour != 0 {
+			return nil, fmt.Errorf("%q must be a whole number of hours, e.g. 24h or 7d", name)
+		}
+		if slices.ContainsFunc(windows, func(w trendingWindow) bool { return w.Name == name }) {
+			return nil, fmt.Errorf("%q is listed twice", name)
+		}
+		windows = append(windows, trendingWindow{Name: name, Duration: d})
+	}
+	return windows, nil
+}
+
+// viewRetention is how long hourly view counts are kept: long enough for
+// the longest trending window.
+func (cfg *apiConfig) viewRetention() time.Duration {
+	var longest time.Duration
+	for _, w := range cfg.trendingWindows {
+		longest = max(longest, w.Duration)
+	}
+	return longest
+}
+
+type viewedVideo struct {
+	database.Video
+	Views int `json:"views"`
+}
+
+type categoryListing struct {
+	Category string        `json:"category"`
+	Views    int           `json:"views"`
+	Videos   []viewedVideo `json:"videos"`
+}
+
+type viewListing struct {
+	Window     string            `json:"window,omitempty"`
+	Videos     []viewedVideo     `json:"videos"`
+	Categories []categoryListing `json:"categories"`
+}
+
+// listingLimit parses the ?limit= of a listing, which defaults to 20 and is
+// at most 100.
+func listingLimit(r *http.Request) (int, error) {
+	v := r.URL.Query().Get("limit")
+	if v == "" {
+		return listingDefaultLimit, nil
+	}
+	limit, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, err
+	}
+	if limit < 1 || limit > listingMaxLimit {
+		return 0, fmt.Errorf("limit %d is out of range", limit)
+	}
+	return limit, nil
+}
+
+// handlerTrending lists the videos played most within a window, picked with
+// ?window= from TRENDING_WINDOWS.
+func (cfg *apiConfig) handlerTrending(w http.ResponseWriter, r *http.Request) {
+	window := cfg.trendingWindows[0]
+	if v := r.URL.Query().Get("window"); v != "" {
+		i := slices.IndexFunc(cfg.trendingWindows, func(w trendingWindow) bool { return w.Name == v })
+		if i < 0 {
+			respondWithError(w, http.StatusBadRequest, "Unknown trending window", fmt.Errorf("window %q is not configured", v))
+			return
+		}
+		window = cfg.trendingWindows[i]
+	}
+	limit, err := listingLimit(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "limit must be between 1 and 100", err)
+		return
+	}
+
+	counts, err := cfg.db.GetViewCounts(cfg.clock.Now().Add(-window.Duration))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get view counts", err)
+		return
+	}
+	cfg.respondWithViewListing(w, r, window.Name, counts, limit)
+}
+
+// handlerMostViewed lists the videos played most overall.
+func (cfg *apiConfig) handlerMostViewed(w http.ResponseWriter, r *http.Request) {
+	limit, err := listingLimit(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "limit must be between 1 and 100", err)
+		return
+	}
+	counts, err := cfg.db.GetViewTotals()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get view counts", err)
+		return
+	}
+	cfg.respondWithViewListing(w, r, "", counts, limit)
+}
+
+// respondWithViewListing lists the videos in counts, which are most viewed
+// first, that the viewer can see, optionally only those tagged with
+// ?category=. The videos are also broken down by tag, the categories with
+// the most views first.
+func (cfg *apiConfig) respondWithViewListing(w http.ResponseWriter, r *http.Request, window string, counts []database.ViewCount, limit int) {
+	category := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("category")))
+	videos, err := cfg.catalogVideos(r)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get videos", err)
+		return
+	}
+	byID := map[uuid.UUID]database.Video{}
+	for _, video := range videos {
+		if video.VideoURL != nil && (category == "" || slices.Contains(video.Tags, category)) {
+			byID[video.ID] = video
+		}
+	}
+
+	listed := []viewedVideo{}
+	categories := map[string]*categoryListing{}
+	for _, count := range counts {
+		video, ok := byID[count.VideoID]
+		if !ok {
+			continue
+		}
+		viewed := viewedVideo{Video: video, Views: count.Views}
+		listed = append(listed, viewed)
+		for _, tag := range video.Tags {
+			c, ok := categories[tag]
+			if !ok {
+				c = &categoryListing{Category: tag, Videos: []viewedVideo{}}
+				categories[tag] = c
+			}
+			c.Views += count.Views
+			if len(c.Videos) < trendingPerCategory {
+				c.Videos = append(c.Videos, viewed)
+			}
+		}
+	}
+
+	resp := viewListing{Window: window, Videos: listed[:min(limit, len(listed))], Categories: []categoryListing{}}
+	for _, c := range categories {
+		resp.Categories = append(resp.Categories, *c)
+	}
+	slices.SortFunc(resp.Categories, func(a, b categoryListing) int {
+		if a.Views != b.Views {
+			return b.Views - a.Views
+		}
+		return strings.Compare(a.Category, b.Category)
+	})
+	resp.Categories = resp.Categories[:min(trendingCategories, len(resp.Categories))]
+
+	if err := cfg.signViewedVideos(r, resp.Videos); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URLs", err)
+		return
+	}
+	for _, c := range resp.Categories {
+		if err := cfg.signViewedVideos(r, c.Videos); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URLs", err)
+			return
+		}
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// signViewedVideos signs the URLs of videos in place.
+func (cfg *apiConfig) signViewedVideos(r *http.Request, videos []viewedVideo) error {
+	for i := range videos {
+		signed, err := cfg.dbVideoToSignedVideo(r.Context(), videos[i].Video)
+		if err != nil {
+			return err
+		}
+		videos[i].Video = signed
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Sessions created with a size take the file as a stream of chunks, each
+// appended at the offset the server has stored so far, in the style of the
+// tus protocol: PATCH /api/uploads/{uploadID} with Upload-Offset appends a
+// chunk, and HEAD returns the offset to resume from. Unlike numbered parts,
+// a chunk that is cut off is kept up to where it stopped, so a client on a
+// flaky connection never sends the same bytes twice. The chunks are written
+// to a spool file on the server, which is processed when the session
+// completes.
+
+// appendContentType is the media type of appended chunks.
+const appendContentType = "application/offset+octet-stream"
+
+// uploadSpoolPath is the file an append session's chunks are written to.
+func (cfg *apiConfig) uploadSpoolPath(session database.UploadSession) string {
+	return filepath.Join(cfg.uploadSpoolDir, session.ID.String()+".upload")
+}
+
+func (cfg *apiConfig) removeUploadSpool(session database.UploadSession) error {
+	err := os.Remove(cfg.uploadSpoolPath(session))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// uploadAppends tracks the sessions a chunk is being appended to, so two
+// requests can't write to the same spool file at once.
+type uploadAppends struct {
+	mu     sync.Mutex
+	active map[uuid.UUID]bool
+}
+
+func newUploadAppends() *uploadAppends {
+	return &uploadAppends{active: map[uuid.UUID]bool{}}
+}
+
+func (a *uploadAppends) start(id uuid.UUID) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.active[id] {
+		return false
+	}
+	a.active[id] = true
+	return true
+}
+
+func (a *uploadAppends) finish(id uuid.UUID) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.active, id)
+}
+
+func setUploadOffsetHeaders(w http.ResponseWriter, session database.UploadSession) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(session.UploadOffset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(session.UploadLength, 10))
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+// requireAppendSession is requireActiveUploadSession for requests that only
+// apply to sessions created with a size.
+func (cfg *apiConfig) requireAppendSession(w http.ResponseWriter, r *http.Request) (database.UploadSession, bool) {
+	session, ok := cfg.requireActiveUploadSession(w, r)
+	if !ok {
+		return database.UploadSession{}, false
+	}
+	if session.DirectSize > 0 {
+		respondWithError(w, http.StatusConflict, "Upload session takes a direct upload", nil)
+		return database.UploadSession{}, false
+	}
+	if session.UploadLength == 0 {
+		respondWithError(w, http.StatusConflict, "Upload session takes numbered parts", nil)
+		return database.UploadSession{}, false
+	}
+	return session, true
+}
+
+// handlerUploadSessionHead returns the offset an append session resumes
+// from. It doesn't count as a heartbeat.
+func (cfg *apiConfig) handlerUploadSessionHead(w http.ResponseWriter, r *http.Request) {
+	session, ok := cfg.requireAppendSession(w, r)
+	if !ok {
+		return
+	}
+	setUploadOffsetHeaders(w, session)
+	w.WriteHeader(http.StatusOK)
+}
+
+// handlerUploadSessionAppend appends the body to an append session at
+// Upload-Offset, which has to be the session's current offset. The response
+// has the new offset; with a body that ends early, whatever arrived is kept
+// and the error response has the offset to resume from.
+func (cfg *apiConfig) handlerUploadSessionAppend(w http.ResponseWriter, r *http.Request) {
+	session, ok := cfg.requireAppendSession(w, r)
+	if !ok {
+		return
+	}
+	setUploadOffsetHeaders(w, session)
+
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != appendContentType {
+		respondWithError(w, http.StatusUnsupportedMediaType, "Chunks must be sent as application/offset+octet-stream", err)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid Upload-Offset", err)
+		return
+	}
+	if offset != session.UploadOffset {
+		respondWithError(w, http.StatusConflict, "Upload-Offset doesn't match the stored offset", fmt.Errorf("got %d, stored %d", offset, session.UploadOffset))
+		return
+	}
+	if r.ContentLength < 0 {
+		respondWithError(w, http.StatusLengthRequired, "Content-Length is required", nil)
+		return
+	}
+	if offset+r.ContentLength > session.UploadLength {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Chunk runs past the upload's size", nil)
+		return
+	}
+
+	if !cfg.uploadAppends.start(session.ID) {
+		respondWithError(w, http.StatusConflict, "A chunk is already being appended to this upload", nil)
+		return
+	}
+	defer cfg.uploadAppends.finish(session.ID)
+
+	if err := os.MkdirAll(cfg.uploadSpoolDir, 0700); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't store chunk", err)
+		return
+	}
+	f, err := os.OpenFile(cfg.uploadSpoolPath(session), os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't store chunk", err)
+		return
+	}
+	defer f.Close()
+	// Anything past the stored offset is from an append the server
+	// stopped in the middle of, and is sent again.
+	if err := f.Truncate(offset); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't store chunk", err)
+		return
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't store chunk", err)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, r.ContentLength)
+	stopRateWatch := cfg.watchUploadRate(w, r)
+	start := time.Now()
+	n, readErr := io.Copy(cfg.chaos.SlowWriter(f), r.Body)
+	elapsed := time.Since(start)
+	stopRateWatch()
+	if err := f.Sync(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't store chunk", err)
+		return
+	}
+	if n > 0 {
+		moved, err := cfg.db.SetUploadOffset(session.ID, offset, offset+n)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update upload session", err)
+			return
+		}
+		if !moved {
+			respondWithError(w, http.StatusConflict, "Upload session is no longer active", nil)
+			return
+		}
+		session.UploadOffset = offset + n
+		setUploadOffsetHeaders(w, session)
+		cfg.recordUploadTransfer(session, n, elapsed)
+	}
+	if respondIfTooSlow(w, readErr) {
+		return
+	}
+	if readErr != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't read chunk", readErr)
+		return
+	}
+	if !cfg.heartbeatUploadSession(w, &session) {
+		return
+	}
+	w.Header().Set("Upload-Expires", session.ExpiresAt.Format(http.TimeFormat))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// reconcileUploadOffset makes an append session's offset match its spool
+// file, which can be shorter if the server lost it, e.g. to a cleared
+// temporary directory. Bytes past the offset are dropped by the next append.
+func (cfg *apiConfig) reconcileUploadOffset(session *database.UploadSession) error {
+	size := int64(0)
+	info, err := os.Stat(cfg.uploadSpoolPath(*session))
+	if err == nil {
+		size = info.Size()
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if size >= session.UploadOffset {
+		return nil
+	}
+	if _, err := cfg.db.SetUploadOffset(session.ID, session.UploadOffset, size); err != nil {
+		return err
+	}
+	session.UploadOffset = size
+	return nil
+}
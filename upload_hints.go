@@ -0,0 +1,97 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// A new upload session comes with a recommended chunk size and
+// parallelism. Chunks are sized to take about uploadChunkDuration each at
+// the bandwidth of one connection, so a failed one is cheap to send again
+// without spending a slow link's time on request overhead.
+const (
+	uploadChunkDuration = 10 * time.Second
+	// defaultUploadBandwidth is the bytes per second of a connection
+	// assumed when the client didn't report its bandwidth and the user
+	// hasn't uploaded within uploadThroughputWindow.
+	defaultUploadBandwidth = 1 << 20
+	// uploadThroughputWindow is how far back the user's sessions count
+	// towards their observed throughput.
+	uploadThroughputWindow = 7 * 24 * time.Hour
+	maxUploadParallelism   = 4
+	// minAppendChunkSize is the smallest chunk recommended for an append
+	// session, which has no minimum part size.
+	minAppendChunkSize = 1 << 20
+)
+
+// observedThroughput is the bytes per second at which the session's parts
+// or chunks have arrived, or 0 if none have.
+func observedThroughput(s database.UploadSession) int64 {
+	if s.TransferMillis <= 0 {
+		return 0
+	}
+	return s.BytesTransferred * 1000 / s.TransferMillis
+}
+
+// recommendUploadChunks fills in resp's recommended chunk size and
+// parallelism. bandwidthKbps is what the client reported for its link, or
+// 0 if it didn't, in which case the user's throughput over their recent
+// sessions is used.
+func (cfg *apiConfig) recommendUploadChunks(resp *uploadSessionResponse, bandwidthKbps int) {
+	session := resp.UploadSession
+	// Append sessions take one chunk at a time.
+	parallelism := 1
+	if session.UploadLength == 0 {
+		parallelism = cfg.uploadParallelism()
+	}
+
+	perConnection := int64(defaultUploadBandwidth)
+	if bandwidthKbps > 0 {
+		perConnection = int64(bandwidthKbps) * 1000 / 8 / int64(parallelism)
+	} else {
+		since := cfg.clock.Now().Add(-uploadThroughputWindow)
+		bytes, millis, err := cfg.db.GetUploadThroughput(session.UserID, since)
+		if err != nil {
+			log.Printf("Couldn't get upload throughput of user %s: %v", session.UserID, err)
+		} else if millis > 0 {
+			perConnection = bytes * 1000 / millis
+		}
+	}
+
+	chunkSize := perConnection * int64(uploadChunkDuration/time.Second)
+	chunkSize = (chunkSize + 1<<20 - 1) &^ (1<<20 - 1)
+	if session.UploadLength > 0 {
+		chunkSize = min(max(chunkSize, minAppendChunkSize), maxPartSize, session.UploadLength)
+	} else {
+		chunkSize = min(max(chunkSize, minPartSize), maxPartSize)
+	}
+	resp.RecommendedChunkSize = chunkSize
+	resp.RecommendedParallelism = parallelism
+}
+
+// uploadParallelism is how many parts a new session should send at once:
+// a quarter of the upload slots that are free, so one session doesn't take
+// them all, down to 1 when the server is busy.
+func (cfg *apiConfig) uploadParallelism() int {
+	l := cfg.uploadLimit
+	if l == nil {
+		return maxUploadParallelism
+	}
+	st := l.status()
+	free := st.Limit - st.InFlight - st.Queued
+	return min(max(free/4, 1), maxUploadParallelism)
+}
+
+// recordUploadTransfer adds a part or chunk to the session's observed
+// throughput. It's only advice for later sessions, so failing to record it
+// doesn't fail the upload.
+func (cfg *apiConfig) recordUploadTransfer(session database.UploadSession, n int64, d time.Duration) {
+	if n <= 0 {
+		return
+	}
+	if err := cfg.db.RecordUploadTransfer(session.ID, n, d); err != nil {
+		log.Printf("Couldn't record throughput of upload session %s: %v", session.ID, err)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tenants"
+	"github.com/google/uuid"
+)
+
+// Video uploads are processed in the background: handlerUploadVideo spools
+// the raw file to disk, marks the video pending and responds, and a job
+// runs it through ingestVideo once the processing queue has room. The
+// video's processing status and GET /api/videos/{videoID}/status report how
+// it is going.
+
+// rawUploadPattern names spooled uploads waiting to be processed.
+const rawUploadPattern = "*.raw"
+
+// setVideoProcessing records how the latest upload of a video is going. The
+// status is informational, so failures are only logged.
+func (cfg *apiConfig) setVideoProcessing(videoID uuid.UUID, status, errMsg string) {
+	if err := cfg.db.SetVideoProcessing(videoID, status, errMsg); err != nil {
+		log.Printf("Couldn't set processing status of video %s to %s: %v", videoID, status, err)
+	}
+}
+
+// requireNotProcessing rejects changes to a video's file while an upload of
+// it is queued or being processed. If ok is false, an error response has
+// been written.
+func requireNotProcessing(w http.ResponseWriter, video database.Video) (ok bool) {
+	if video.ProcessingStatus == database.VideoPending || video.ProcessingStatus == database.VideoProcessing {
+		respondWithError(w, http.StatusConflict, "Video is already being processed", nil)
+		return false
+	}
+	return true
+}
+
+// uploadJob is an upload waiting to be processed.
+type uploadJob struct {
+	videoID uuid.UUID
+	target  tenants.Target
+	src     videoSource
+	// rawPath is the spooled upload, which the job removes.
+	rawPath  string
+	plog     *processingLog
+	progress *uploadProgress
+}
+
+// discardResponseWriter stands in for the response of a request that has
+// already been answered, so ingestVideo can run outside of it.
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (d *discardResponseWriter) Header() http.Header {
+	if d.header == nil {
+		d.header = http.Header{}
+	}
+	return d.header
+}
+
+func (d *discardResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
+
+func (d *discardResponseWriter) WriteHeader(int) {}
+
+// runUploadJob processes a spooled upload. r is the request that uploaded
+// it, whose context isn't used, but for its request ID: the job outlives
+// it.
+func (cfg *apiConfig) runUploadJob(r *http.Request, job uploadJob) {
+	rec := &errorRecorder{ResponseWriter: &discardResponseWriter{}}
+	ctx := withUploadProgress(job.plog.context(withRequestID(context.Background(), job.plog.requestID())), job.progress)
+	r = r.WithContext(ctx)
+	defer func() {
+		cfg.saveProcessingLog(job.plog, rec.failure())
+		job.progress.finish(rec.failure())
+	}()
+
+	cleanup := &cleanupStack{}
+	defer cleanup.run()
+	cleanup.removeFile(job.rawPath)
+
+	// The video may have changed while the upload was being spooled.
+	video, err := cfg.db.GetVideo(job.videoID)
+	if err != nil {
+		respondWithError(rec, http.StatusInternalServerError, "Couldn't find video", err)
+		cfg.setVideoProcessing(job.videoID, database.VideoFailed, rec.message())
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(rec, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+
+	f, err := os.Open(job.rawPath)
+	if err != nil {
+		respondWithError(rec, http.StatusInternalServerError, "Couldn't read uploaded file", err)
+		cfg.setVideoProcessing(job.videoID, database.VideoFailed, rec.message())
+		return
+	}
+	defer f.Close()
+	job.src.file = f
+
+	video, matches, ok := cfg.ingestVideo(rec, r, cleanup, video, job.target, job.src)
+	if !ok {
+		return
+	}
+	cleanup.commit()
+	if len(matches) > 0 {
+		cfg.flagFingerprintMatches(video.ID, matches)
+	}
+}
+
+// failInterruptedUploads marks the uploads a restart cut off as failed and
+// removes their spooled files, since the jobs that would have processed
+// them are gone.
+func (cfg *apiConfig) failInterruptedUploads() error {
+	n, err := cfg.db.FailInterruptedProcessing("Processing was interrupted by a server restart; upload the video again")
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		log.Printf("Marked %d interrupted video uploads as failed", n)
+	}
+	paths, err := filepath.Glob(filepath.Join(cfg.uploadSpoolDir, rawUploadPattern))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
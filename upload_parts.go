@@ -0,0 +1,509 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tenants"
+	"github.com/google/uuid"
+)
+
+// Part limits of the chunked upload protocol. S3 requires every part but
+// the last to be at least 5 MiB; the other limits keep a single request
+// short and a session no larger than a direct upload.
+const (
+	minPartSize          = 5 << 20
+	maxPartSize          = 64 << 20
+	maxSessionParts      = 10000
+	maxSessionUploadSize = 1 << 30 // 1 GB
+)
+
+// handlerUploadPartPut stores one numbered chunk of a session's file as the
+// part of the same number of its S3 multipart upload. Parts can be sent in
+// any order and in parallel; sending a part number again replaces it.
+func (cfg *apiConfig) handlerUploadPartPut(w http.ResponseWriter, r *http.Request) {
+	session, ok := cfg.requireActiveUploadSession(w, r)
+	if !ok {
+		return
+	}
+	if session.UploadLength > 0 {
+		respondWithError(w, http.StatusConflict, "Upload session takes appended chunks", nil)
+		return
+	}
+	if session.DirectSize > 0 {
+		respondWithError(w, http.StatusConflict, "Upload session takes a direct upload", nil)
+		return
+	}
+
+	lastPart := int32(maxSessionParts)
+	if session.PartCount > 0 {
+		lastPart = session.PartCount
+	}
+	partNumber, err := strconv.Atoi(r.PathValue("partNumber"))
+	if err != nil || partNumber < 1 || partNumber > int(lastPart) {
+		respondWithError(w, http.StatusBadRequest, "Invalid part number", fmt.Errorf("part number must be between 1 and %d", lastPart))
+		return
+	}
+	if r.ContentLength < 0 {
+		respondWithError(w, http.StatusLengthRequired, "Content-Length is required", nil)
+		return
+	}
+	if r.ContentLength == 0 {
+		respondWithError(w, http.StatusBadRequest, "Part is empty", nil)
+		return
+	}
+	if r.ContentLength > maxPartSize {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Part is too large", nil)
+		return
+	}
+
+	var wantMD5 []byte
+	if v := r.Header.Get("Content-MD5"); v != "" {
+		wantMD5, err = base64.StdEncoding.DecodeString(v)
+		if err != nil || len(wantMD5) != md5.Size {
+			respondWithError(w, http.StatusBadRequest, "Invalid part checksum", fmt.Errorf("invalid Content-MD5 %q", v))
+			return
+		}
+	}
+
+	parts, err := cfg.db.GetUploadParts(session.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get upload parts", err)
+		return
+	}
+	total := r.ContentLength
+	for _, part := range parts {
+		if part.PartNumber != int32(partNumber) {
+			total += part.Size
+		}
+	}
+	if total > maxSessionUploadSize {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Upload is too large", nil)
+		return
+	}
+
+	// The part is spooled to disk first so a client that disconnects
+	// halfway never leaves a truncated part in S3, and so its checksum is
+	// known before anything is sent.
+	tmp, err := os.CreateTemp("", "tubely-part-*")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to create temporary file", err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hash := md5.New()
+	r.Body = http.MaxBytesReader(w, r.Body, r.ContentLength)
+	stopRateWatch := cfg.watchUploadRate(w, r)
+	start := time.Now()
+	n, err := io.Copy(io.MultiWriter(cfg.chaos.SlowWriter(tmp), hash), r.Body)
+	elapsed := time.Since(start)
+	stopRateWatch()
+	if respondIfTooSlow(w, err) {
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't read part", err)
+		return
+	}
+	if n != r.ContentLength {
+		respondWithError(w, http.StatusBadRequest, "Couldn't read part", fmt.Errorf("got %d of %d bytes", n, r.ContentLength))
+		return
+	}
+	cfg.recordUploadTransfer(session, n, elapsed)
+	sum := hash.Sum(nil)
+	if wantMD5 != nil && !bytes.Equal(sum, wantMD5) {
+		respondWithError(w, http.StatusBadRequest, "Part checksum mismatch", nil)
+		return
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to reset file pointer", err)
+		return
+	}
+
+	target, err := cfg.tenants.Target(r.Context(), session.TenantID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't resolve storage for tenant", err)
+		return
+	}
+	out, err := target.Client.UploadPart(r.Context(), &s3.UploadPartInput{
+		Bucket:        aws.String(target.Bucket),
+		Key:           aws.String(session.ObjectKey),
+		UploadId:      aws.String(session.S3UploadID),
+		PartNumber:    aws.Int32(int32(partNumber)),
+		Body:          tmp,
+		ContentLength: aws.Int64(n),
+		ContentMD5:    aws.String(base64.StdEncoding.EncodeToString(sum)),
+	})
+	if err != nil {
+		respondWithStorageError(w, http.StatusBadGateway, "Failed to upload part to S3", err)
+		return
+	}
+
+	part := database.UploadPart{
+		PartNumber: int32(partNumber),
+		Size:       n,
+		ETag:       aws.ToString(out.ETag),
+		UploadedAt: cfg.clock.Now().UTC(),
+	}
+	if err := cfg.db.SaveUploadPart(session.ID, part); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save upload part", err)
+		return
+	}
+	if !cfg.heartbeatUploadSession(w, &session) {
+		return
+	}
+	respondWithJSON(w, http.StatusOK, part)
+}
+
+// missingUploadParts lists the part numbers a session still needs: those up
+// to its part count that haven't been stored, or, if the client didn't give
+// a count, the gaps below the highest part stored so far.
+func missingUploadParts(session database.UploadSession, parts []database.UploadPart) []int32 {
+	last := session.PartCount
+	stored := map[int32]bool{}
+	for _, part := range parts {
+		stored[part.PartNumber] = true
+		if session.PartCount == 0 {
+			last = max(last, part.PartNumber)
+		}
+	}
+	missing := []int32{}
+	for n := int32(1); n <= last; n++ {
+		if !stored[n] {
+			missing = append(missing, n)
+		}
+	}
+	return missing
+}
+
+// reconcileUploadParts makes the session's recorded parts match what S3
+// holds for its multipart upload. A part can be stored without being
+// recorded, e.g. when the server stopped before saving it, and a recorded
+// part that S3 doesn't have has to be sent again; either way S3 is right.
+func (cfg *apiConfig) reconcileUploadParts(ctx context.Context, target tenants.Target, session database.UploadSession) ([]database.UploadPart, error) {
+	parts := []database.UploadPart{}
+	input := &s3.ListPartsInput{
+		Bucket:   aws.String(target.Bucket),
+		Key:      aws.String(session.ObjectKey),
+		UploadId: aws.String(session.S3UploadID),
+	}
+	for {
+		out, err := target.Client.ListParts(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range out.Parts {
+			parts = append(parts, database.UploadPart{
+				PartNumber: aws.ToInt32(p.PartNumber),
+				Size:       aws.ToInt64(p.Size),
+				ETag:       aws.ToString(p.ETag),
+				UploadedAt: aws.ToTime(p.LastModified).UTC(),
+			})
+		}
+		if !aws.ToBool(out.IsTruncated) {
+			break
+		}
+		input.PartNumberMarker = out.NextPartNumberMarker
+	}
+	if err := cfg.db.ReplaceUploadParts(session.ID, parts); err != nil {
+		return nil, err
+	}
+	return parts, nil
+}
+
+// handlerUploadSessionResume is what a client calls after an interruption:
+// it reconciles the session's parts with S3 and keeps the session alive, and
+// the response's missing_parts are the only parts the client needs to send
+// again before completing. An append session resumes from the response's
+// upload_offset.
+func (cfg *apiConfig) handlerUploadSessionResume(w http.ResponseWriter, r *http.Request) {
+	session, ok := cfg.requireActiveUploadSession(w, r)
+	if !ok {
+		return
+	}
+	if session.UploadLength > 0 {
+		if err := cfg.reconcileUploadOffset(&session); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update upload session", err)
+			return
+		}
+		if !cfg.heartbeatUploadSession(w, &session) {
+			return
+		}
+		setUploadOffsetHeaders(w, session)
+		respondWithJSON(w, http.StatusOK, cfg.uploadSessionResponse(session))
+		return
+	}
+	if session.DirectSize > 0 {
+		// There are no parts to reconcile: the presigned URL is still good
+		// for as long as the session can live.
+		if !cfg.heartbeatUploadSession(w, &session) {
+			return
+		}
+		respondWithJSON(w, http.StatusOK, cfg.uploadSessionResponse(session))
+		return
+	}
+	target, err := cfg.tenants.Target(r.Context(), session.TenantID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't resolve storage for tenant", err)
+		return
+	}
+	parts, err := cfg.reconcileUploadParts(r.Context(), target, session)
+	if err != nil {
+		respondWithStorageError(w, http.StatusBadGateway, "Failed to list uploaded parts in S3", err)
+		return
+	}
+	if !cfg.heartbeatUploadSession(w, &session) {
+		return
+	}
+	resp := cfg.uploadSessionResponse(session)
+	resp.Parts = parts
+	resp.MissingParts = missingUploadParts(session, parts)
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// validateUploadParts checks that parts, sorted by number, are the whole
+// file: numbered 1 to n without gaps, n matching what the client expects
+// if it said, and all but the last at least minPartSize.
+func validateUploadParts(parts []database.UploadPart, expected int) error {
+	if len(parts) == 0 {
+		return errors.New("no parts have been uploaded")
+	}
+	if expected > 0 && len(parts) != expected {
+		return fmt.Errorf("%d of %d parts have been uploaded", len(parts), expected)
+	}
+	for i, part := range parts {
+		if part.PartNumber != int32(i+1) {
+			return fmt.Errorf("part %d is missing", i+1)
+		}
+		if i < len(parts)-1 && part.Size < minPartSize {
+			return fmt.Errorf("part %d is smaller than %d bytes", part.PartNumber, minPartSize)
+		}
+	}
+	return nil
+}
+
+// handlerUploadSessionComplete assembles a session's parts into one object
+// and runs it through the same pipeline as a direct upload. The optional
+// body {"parts": n} overrides the session's part count; with either, the
+// call fails rather than process a file with missing trailing parts. An
+// append session's spool file is processed once all of it has arrived, and
+// a direct upload once its object has the size the client declared.
+func (cfg *apiConfig) handlerUploadSessionComplete(w http.ResponseWriter, r *http.Request) {
+	session, ok := cfg.requireActiveUploadSession(w, r)
+	if !ok {
+		return
+	}
+
+	type parameters struct {
+		Parts int `json:"parts"`
+	}
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil && !errors.Is(err, io.EOF) {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	expected := params.Parts
+	if expected == 0 {
+		expected = int(session.PartCount)
+	}
+
+	target, err := cfg.tenants.Target(r.Context(), session.TenantID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't resolve storage for tenant", err)
+		return
+	}
+	appended, direct := session.UploadLength > 0, session.DirectSize > 0
+	var parts []database.UploadPart
+	switch {
+	case appended:
+		if err := cfg.reconcileUploadOffset(&session); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update upload session", err)
+			return
+		}
+		if session.UploadOffset != session.UploadLength {
+			respondWithError(w, http.StatusBadRequest, "Upload is incomplete", fmt.Errorf("%d of %d bytes have been uploaded", session.UploadOffset, session.UploadLength))
+			return
+		}
+	case direct:
+		if !cfg.requireDirectUpload(w, r, target, session) {
+			return
+		}
+	default:
+		// Assembling exactly what S3 holds means a part whose ETag was
+		// never recorded doesn't fail the whole upload.
+		parts, err = cfg.reconcileUploadParts(r.Context(), target, session)
+		if err != nil {
+			respondWithStorageError(w, http.StatusBadGateway, "Failed to list uploaded parts in S3", err)
+			return
+		}
+		if err := validateUploadParts(parts, expected); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Upload is incomplete", err)
+			return
+		}
+	}
+
+	// Checked before claiming the session, so the parts are kept until the
+	// video can be replaced.
+	current, err := cfg.db.GetVideo(session.VideoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if !requireNoLegalHold(w, current) || !requireNotProcessing(w, current) || !cfg.requireUnlockedVideoFile(w, r, current) {
+		return
+	}
+	// Admitted before claiming the session too, so a client turned away
+	// can complete it again later.
+	release, ok := cfg.admitUpload(w)
+	if !ok {
+		return
+	}
+	defer release()
+
+	// Claiming the session first means a concurrent completion, abort or
+	// expiry can't act on parts that are being assembled.
+	claimed, err := cfg.db.TransitionUploadSession(session.ID, database.UploadSessionActive, database.UploadSessionProcessing)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update upload session", err)
+		return
+	}
+	if !claimed {
+		respondWithError(w, http.StatusConflict, "Upload session is no longer active", nil)
+		return
+	}
+
+	if !appended && !direct && !cfg.completeUploadParts(w, r, target, session, parts) {
+		return
+	}
+
+	status := database.UploadSessionFailed
+	defer func() {
+		if _, err := cfg.db.TransitionUploadSession(session.ID, database.UploadSessionProcessing, status); err != nil {
+			log.Printf("Couldn't update upload session %s: %v", session.ID, err)
+		}
+		if err := cfg.db.DeleteUploadParts(session.ID); err != nil {
+			log.Printf("Couldn't delete parts of upload session %s: %v", session.ID, err)
+		}
+	}()
+
+	plog := newProcessingLog(session.VideoID, "upload")
+	rec := &errorRecorder{ResponseWriter: w}
+	w = rec
+	r = r.WithContext(plog.context(r.Context()))
+	defer func() { cfg.saveProcessingLog(plog, rec.failure()) }()
+
+	cleanup := &cleanupStack{}
+	defer cleanup.run()
+	// The assembled file is only an input; processing uploads its own copy.
+	if appended {
+		cleanup.always("delete "+cfg.uploadSpoolPath(session), func() error {
+			return cfg.removeUploadSpool(session)
+		})
+	} else {
+		cleanup.always(fmt.Sprintf("delete s3://%s/%s", target.Bucket, session.ObjectKey), func() error {
+			return target.Storage().Delete(context.Background(), session.ObjectKey)
+		})
+	}
+
+	video, err := cfg.db.GetVideo(session.VideoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	if video.UserID != session.UserID {
+		respondWithError(w, http.StatusForbidden, "Not authorized to upload for this video", nil)
+		return
+	}
+	cfg.emitEvent(eventVideoUploaded, video.ID, map[string]any{"upload_id": session.ID})
+
+	var file io.Reader
+	if appended {
+		f, err := os.Open(cfg.uploadSpoolPath(session))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't read uploaded file", err)
+			return
+		}
+		defer f.Close()
+		file = f
+	} else {
+		obj, err := target.Storage().Get(r.Context(), session.ObjectKey)
+		if err != nil {
+			respondWithStorageError(w, http.StatusBadGateway, "Failed to read assembled upload from S3", err)
+			return
+		}
+		defer obj.Close()
+		file = obj
+	}
+
+	video, matches, ok := cfg.ingestVideo(w, r, cleanup, video, target, videoSource{
+		file:        file,
+		filename:    session.Filename,
+		contentType: session.ContentType,
+		profileName: session.Profile,
+	})
+	if !ok {
+		return
+	}
+
+	cleanup.commit()
+	status = database.UploadSessionCompleted
+	if len(matches) > 0 {
+		cfg.flagFingerprintMatches(video.ID, matches)
+	}
+	video, err = cfg.dbVideoToSignedVideo(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URLs", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+// completeUploadParts assembles the session's parts into its object. If it
+// returns false, the session is active again so the client can retry, and
+// an error response has been written.
+func (cfg *apiConfig) completeUploadParts(w http.ResponseWriter, r *http.Request, target tenants.Target, session database.UploadSession, parts []database.UploadPart) bool {
+	completed := make([]types.CompletedPart, 0, len(parts))
+	for _, part := range parts {
+		completed = append(completed, types.CompletedPart{
+			ETag:       aws.String(part.ETag),
+			PartNumber: aws.Int32(part.PartNumber),
+		})
+	}
+	_, err := target.Client.CompleteMultipartUpload(r.Context(), &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(target.Bucket),
+		Key:             aws.String(session.ObjectKey),
+		UploadId:        aws.String(session.S3UploadID),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+	})
+	if err != nil {
+		// The parts are still there, so the client can retry.
+		if _, err := cfg.db.TransitionUploadSession(session.ID, database.UploadSessionProcessing, database.UploadSessionActive); err != nil {
+			log.Printf("Couldn't reactivate upload session %s: %v", session.ID, err)
+		}
+		respondWithStorageError(w, http.StatusBadGateway, "Failed to assemble upload in S3", err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ffmpeg"
+	"github.com/google/uuid"
+)
+
+var presetNameLimit = textLimit{field: "name", maxRunes: 100, maxBytes: 400, required: true}
+
+// expandTitlePattern fills in a preset's title pattern for the nth video
+// created with it: {n} becomes n and {date} becomes the date in UTC.
+func expandTitlePattern(pattern string, n int, now time.Time) string {
+	return strings.NewReplacer(
+		"{n}", strconv.Itoa(n),
+		"{date}", now.UTC().Format(time.DateOnly),
+	).Replace(pattern)
+}
+
+type uploadPresetParams struct {
+	Name         string   `json:"name"`
+	TitlePattern string   `json:"title_pattern"`
+	Description  string   `json:"description"`
+	Tags         []string `json:"tags"`
+	Profile      string   `json:"profile"`
+}
+
+// applyPresetParams validates params and copies them onto p. The title
+// pattern is checked as the title of a video with a long use count, so
+// every title it expands to fits. If it returns false, an error response
+// has been written.
+func (cfg *apiConfig) applyPresetParams(w http.ResponseWriter, params uploadPresetParams, p *database.UploadPreset) bool {
+	name, err := presetNameLimit.apply(params.Name)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return false
+	}
+	pattern := normalizeText(params.TitlePattern, false)
+	if pattern != "" {
+		if _, err := titleLimit.apply(expandTitlePattern(pattern, 1_000_000_000, cfg.clock.Now())); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error(), err)
+			return false
+		}
+	}
+	description, err := descriptionLimit.apply(params.Description)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return false
+	}
+	tags, err := normalizeTags(params.Tags)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return false
+	}
+	if _, ok := cfg.profiles.Get(params.Profile); !ok && params.Profile != ffmpeg.ShortsProfileName {
+		respondWithError(w, http.StatusBadRequest, "Unknown processing profile", nil)
+		return false
+	}
+	p.Name, p.TitlePattern, p.Description, p.Tags, p.Profile = name, pattern, description, tags, params.Profile
+	return true
+}
+
+// uploadPresetFor returns userID's preset with the given ID, or nil if id
+// is empty. If ok is false, an error response has been written.
+func (cfg *apiConfig) uploadPresetFor(w http.ResponseWriter, userID uuid.UUID, id string) (*database.UploadPreset, bool) {
+	if id == "" {
+		return nil, true
+	}
+	presetID, err := uuid.Parse(id)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid preset ID", err)
+		return nil, false
+	}
+	preset, err := cfg.db.GetUploadPreset(presetID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get preset", err)
+		return nil, false
+	}
+	if preset == nil || preset.UserID != userID {
+		respondWithError(w, http.StatusNotFound, "Preset not found", nil)
+		return nil, false
+	}
+	return preset, true
+}
+
+// uploadProfile returns the processing profile an upload asks for: the one
+// it names, or else the one of the preset it names. If ok is false, an
+// error response has been written.
+func (cfg *apiConfig) uploadProfile(w http.ResponseWriter, userID uuid.UUID, profile, presetID string) (string, bool) {
+	preset, ok := cfg.uploadPresetFor(w, userID, presetID)
+	if !ok {
+		return "", false
+	}
+	if profile == "" && preset != nil {
+		profile = preset.Profile
+	}
+	return profile, true
+}
+
+// applyUploadPreset fills in the metadata a new video leaves out from its
+// preset, titling it as the preset's next video, created at now.
+func applyUploadPreset(params *database.CreateVideoParams, preset *database.UploadPreset, now time.Time) {
+	if strings.TrimSpace(params.Title) == "" && preset.TitlePattern != "" {
+		params.Title = expandTitlePattern(preset.TitlePattern, preset.Uses+1, now)
+	}
+	if strings.TrimSpace(params.Description) == "" {
+		params.Description = preset.Description
+	}
+	if len(params.Tags) == 0 {
+		params.Tags = preset.Tags
+	}
+}
+
+func (cfg *apiConfig) handlerUploadPresetsGet(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.requireUser(w, r)
+	if !ok {
+		return
+	}
+	presets, err := cfg.db.GetUploadPresets(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get presets", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, presets)
+}
+
+func (cfg *apiConfig) handlerUploadPresetCreate(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.requireUser(w, r)
+	if !ok {
+		return
+	}
+	params := uploadPresetParams{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	now := cfg.clock.Now().UTC()
+	preset := database.UploadPreset{ID: uuid.New(), UserID: userID, CreatedAt: now, UpdatedAt: now}
+	if !cfg.applyPresetParams(w, params, &preset) {
+		return
+	}
+	if err := cfg.db.CreateUploadPreset(preset); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save preset", err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, preset)
+}
+
+// handlerUploadPresetUpdate replaces a preset's fields. Its use count, and
+// so the numbering of the titles it gives, carries on.
+func (cfg *apiConfig) handlerUploadPresetUpdate(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.requireUser(w, r)
+	if !ok {
+		return
+	}
+	preset, ok := cfg.uploadPresetFor(w, userID, r.PathValue("presetID"))
+	if !ok {
+		return
+	}
+	params := uploadPresetParams{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if !cfg.applyPresetParams(w, params, preset) {
+		return
+	}
+	preset.UpdatedAt = cfg.clock.Now().UTC()
+	if err := cfg.db.UpdateUploadPreset(*preset); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save preset", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, preset)
+}
+
+func (cfg *apiConfig) handlerUploadPresetDelete(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.requireUser(w, r)
+	if !ok {
+		return
+	}
+	preset, ok := cfg.uploadPresetFor(w, userID, r.PathValue("presetID"))
+	if !ok {
+		return
+	}
+	if err := cfg.db.DeleteUploadPreset(preset.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete preset", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
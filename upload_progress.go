@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/clock"
+	"github.com/google/uuid"
+)
+
+// Stages of an upload, in order. An upload ends in uploadDone or
+// uploadFailed.
+const (
+	uploadReceiving  = "receiving"
+	uploadQueued     = "queued"
+	uploadProcessing = "processing"
+	uploadStoring    = "storing"
+	uploadDone       = "done"
+	uploadFailed     = "failed"
+)
+
+const (
+	// uploadProgressRetention is how long the progress of a finished
+	// upload can still be read.
+	uploadProgressRetention = 10 * time.Minute
+	// progressKeepAlive is how often an idle progress stream repeats the
+	// current state, so proxies don't time it out.
+	progressKeepAlive = 15 * time.Second
+)
+
+// uploadProgressState is how far an upload has got. Percent is how far
+// along the current stage is, from 0 to 100. Bytes are counted while the
+// file is received and stored.
+type uploadProgressState struct {
+	UploadID   uuid.UUID `json:"upload_id"`
+	VideoID    uuid.UUID `json:"video_id"`
+	Stage      string    `json:"stage"`
+	Percent    int       `json:"percent"`
+	BytesDone  int64     `json:"bytes_done,omitempty"`
+	BytesTotal int64     `json:"bytes_total,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// uploadProgress tracks one upload for the clients following it. Every
+// method is a no-op on a nil *uploadProgress, so code that reports
+// progress doesn't have to check whether anyone asked for it.
+type uploadProgress struct {
+	userID, videoID uuid.UUID
+	// clock says when the upload finished.
+	clock clock.Clock
+
+	mu         sync.Mutex
+	state      uploadProgressState
+	changed    chan struct{}
+	finishedAt time.Time
+}
+
+func (p *uploadProgress) id() uuid.UUID {
+	return p.state.UploadID
+}
+
+// snapshot returns the current state, and a channel that is closed when it
+// next changes.
+func (p *uploadProgress) snapshot() (uploadProgressState, <-chan struct{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.state, p.changed
+}
+
+// update applies fn to the state, telling followers only if the stage or
+// the whole percent changed, so byte counts don't flood them.
+func (p *uploadProgress) update(fn func(s *uploadProgressState)) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	before := p.state
+	fn(&p.state)
+	if p.state.Stage == before.Stage && p.state.Percent == before.Percent && p.state.Error == before.Error {
+		return
+	}
+	if p.state.Stage == uploadDone || p.state.Stage == uploadFailed {
+		p.finishedAt = p.clock.Now()
+	}
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// stage moves the upload to a new stage. total is the number of bytes
+// the stage moves, or 0 if it doesn't count bytes.
+func (p *uploadProgress) stage(stage string, total int64) {
+	p.update(func(s *uploadProgressState) {
+		s.Stage, s.Percent, s.BytesDone, s.BytesTotal = stage, 0, 0, total
+	})
+}
+
+// fraction reports the current stage as done to the given fraction.
+func (p *uploadProgress) fraction(f float64) {
+	p.update(func(s *uploadProgressState) {
+		s.Percent = int(min(max(f, 0), 1) * 100)
+	})
+}
+
+// addBytes counts n more bytes, or fewer if n is negative, towards the
+// current stage.
+func (p *uploadProgress) addBytes(n int64) {
+	p.update(func(s *uploadProgressState) {
+		s.BytesDone += n
+		if s.BytesTotal > 0 {
+			s.Percent = int(min(max(s.BytesDone, 0), s.BytesTotal) * 100 / s.BytesTotal)
+		}
+	})
+}
+
+// finish ends the upload, as failed if failure is set.
+func (p *uploadProgress) finish(failure string) {
+	p.update(func(s *uploadProgressState) {
+		s.Stage, s.Percent, s.BytesDone, s.BytesTotal = uploadDone, 100, 0, 0
+		if failure != "" {
+			s.Stage, s.Percent, s.Error = uploadFailed, 0, failure
+		}
+	})
+}
+
+// reader counts what's read from r towards the current stage. If r can
+// seek, so can the reader, and it counts how far into r it is instead, so
+// a body that's read twice, such as to sign it, isn't counted twice.
+func (p *uploadProgress) reader(r io.Reader) io.Reader {
+	if p == nil {
+		return r
+	}
+	if rs, ok := r.(io.ReadSeeker); ok {
+		return &progressReadSeeker{rs: rs, p: p}
+	}
+	return progressReader{r: r, p: p}
+}
+
+// body counts a request body towards the current stage as it's read.
+func (p *uploadProgress) body(rc io.ReadCloser) io.ReadCloser {
+	return struct {
+		io.Reader
+		io.Closer
+	}{p.reader(rc), rc}
+}
+
+type progressReader struct {
+	r io.Reader
+	p *uploadProgress
+}
+
+func (pr progressReader) Read(b []byte) (int, error) {
+	n, err := pr.r.Read(b)
+	pr.p.addBytes(int64(n))
+	return n, err
+}
+
+type progressReadSeeker struct {
+	rs  io.ReadSeeker
+	p   *uploadProgress
+	pos int64
+}
+
+func (pr *progressReadSeeker) Read(b []byte) (int, error) {
+	n, err := pr.rs.Read(b)
+	pr.pos += int64(n)
+	pr.p.addBytes(int64(n))
+	return n, err
+}
+
+func (pr *progressReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	pos, err := pr.rs.Seek(offset, whence)
+	if err != nil {
+		return pos, err
+	}
+	pr.p.addBytes(pos - pr.pos)
+	pr.pos = pos
+	return pos, nil
+}
+
+// readerAt counts what's read from r towards the current stage, for
+// uploads that read their parts concurrently. A retried part is counted
+// again, which addBytes caps at the total.
+func (p *uploadProgress) readerAt(r io.ReaderAt) io.ReaderAt {
+	if p == nil {
+		return r
+	}
+	return progressReaderAt{r: r, p: p}
+}
+
+type progressReaderAt struct {
+	r io.ReaderAt
+	p *uploadProgress
+}
+
+func (pr progressReaderAt) ReadAt(b []byte, off int64) (int, error) {
+	n, err := pr.r.ReadAt(b, off)
+	pr.p.addBytes(int64(n))
+	return n, err
+}
+
+type uploadProgressKey struct{}
+
+// withUploadProgress returns a context that has the processing and storing
+// of an upload report to p.
+func withUploadProgress(ctx context.Context, p *uploadProgress) context.Context {
+	return context.WithValue(ctx, uploadProgressKey{}, p)
+}
+
+// uploadProgressFrom returns the progress ctx reports to, or nil.
+func uploadProgressFrom(ctx context.Context) *uploadProgress {
+	p, _ := ctx.Value(uploadProgressKey{}).(*uploadProgress)
+	return p
+}
+
+// uploadProgresses are the uploads clients can follow, by upload ID.
+type uploadProgresses struct {
+	mu   sync.Mutex
+	byID map[uuid.UUID]*uploadProgress
+}
+
+func newUploadProgresses() *uploadProgresses {
+	return &uploadProgresses{byID: map[uuid.UUID]*uploadProgress{}}
+}
+
+// start begins tracking an upload of videoID by userID under id, or a new
+// ID if id is uuid.Nil, forgetting the uploads that finished more than
+// uploadProgressRetention ago on c. It returns false if id is taken.
+func (u *uploadProgresses) start(videoID, userID, id uuid.UUID, c clock.Clock) (*uploadProgress, bool) {
+	if id == uuid.Nil {
+		id = uuid.New()
+	}
+	p := &uploadProgress{
+		userID:  userID,
+		videoID: videoID,
+		clock:   c,
+		state:   uploadProgressState{UploadID: id, VideoID: videoID, Stage: uploadReceiving},
+		changed: make(chan struct{}),
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for oldID, old := range u.byID {
+		old.mu.Lock()
+		expired := !old.finishedAt.IsZero() && c.Now().Sub(old.finishedAt) > uploadProgressRetention
+		old.mu.Unlock()
+		if expired {
+			delete(u.byID, oldID)
+		}
+	}
+	if _, taken := u.byID[id]; taken {
+		return nil, false
+	}
+	u.byID[id] = p
+	return p, true
+}
+
+func (u *uploadProgresses) get(id uuid.UUID) *uploadProgress {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.byID[id]
+}
+
+// handlerVideoProgressGet serves the upload progress stream when the
+// request names an upload, and the viewer's watch progress otherwise.
+func (cfg *apiConfig) handlerVideoProgressGet(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Has("upload_id") {
+		cfg.handlerUploadProgress(w, r)
+		return
+	}
+	cfg.readLimit.middleware(cfg.handlerWatchProgressGet)(w, r)
+}
+
+// handlerUploadProgress streams an upload's progress to its uploader as
+// server-sent events, one progress event per change, until it's done or
+// has failed. A stream opened after the upload finished gets its outcome.
+func (cfg *apiConfig) handlerUploadProgress(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+	uploadID, err := uuid.Parse(r.URL.Query().Get("upload_id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid upload ID", err)
+		return
+	}
+	userID, ok := cfg.requireUser(w, r)
+	if !ok {
+		return
+	}
+	p := cfg.uploadProgress.get(uploadID)
+	if p == nil || p.userID != userID || p.videoID != videoID {
+		respondWithError(w, http.StatusNotFound, "Upload not found", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	keepAlive := time.NewTicker(progressKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		state, changed := p.snapshot()
+		data, err := json.Marshal(state)
+		if err != nil {
+			return
+		}
+		if _, err := fmt.Fprintf(w, "event: progress\ndata: %s\n\n", data); err != nil {
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+		if state.Stage == uploadDone || state.Stage == uploadFailed {
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-keepAlive.C:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
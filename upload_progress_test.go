@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/clock"
+	"github.com/google/uuid"
+)
+
+func TestUploadProgressRetention(t *testing.T) {
+	tests := []struct {
+		name     string
+		failure  string
+		finished bool
+		after    time.Duration
+		wantKept bool
+	}{
+		{name: "running", after: 24 * time.Hour, wantKept: true},
+		{name: "done, within retention", finished: true, after: uploadProgressRetention, wantKept: true},
+		{name: "done, past retention", finished: true, after: uploadProgressRetention + time.Second},
+		{name: "failed, past retention", finished: true, failure: "ffmpeg failed", after: uploadProgressRetention + time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := clock.NewFake(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+			u := newUploadProgresses()
+			p, ok := u.start(uuid.New(), uuid.New(), uuid.Nil, c)
+			if !ok {
+				t.Fatal("start refused a new upload")
+			}
+			if tt.finished {
+				p.finish(tt.failure)
+			}
+
+			c.Advance(tt.after)
+			// Finished uploads are only swept when another one starts.
+			u.start(uuid.New(), uuid.New(), uuid.Nil, c)
+
+			if kept := u.get(p.id()) != nil; kept != tt.wantKept {
+				t.Errorf("upload kept = %v, want %v", kept, tt.wantKept)
+			}
+		})
+	}
+}
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/clock"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ffmpeg"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tenants"
+	"github.com/google/uuid"
+)
+
+// uploadJanitorInterval is how often expired upload sessions are cleaned
+// up, by default.
+const uploadJanitorInterval = time.Minute
+
+// uploadSessionResponse tells the client how often to send heartbeats: a
+// third of the TTL, so one missed heartbeat doesn't expire the session.
+// Parts are only listed when a session is fetched or resumed, along with the
+// part numbers still missing. Chunk size and parallelism are only
+// recommended when a session is created, see recommendUploadChunks.
+type uploadSessionResponse struct {
+	database.UploadSession
+	HeartbeatIntervalSeconds int                   `json:"heartbeat_interval_seconds"`
+	ObservedBytesPerSecond   int64                 `json:"observed_bytes_per_second,omitempty"`
+	RecommendedChunkSize     int64                 `json:"recommended_chunk_size,omitempty"`
+	RecommendedParallelism   int                   `json:"recommended_parallelism,omitempty"`
+	Parts                    []database.UploadPart `json:"parts,omitempty"`
+	MissingParts             []int32               `json:"missing_parts,omitempty"`
+}
+
+func (cfg *apiConfig) uploadSessionResponse(s database.UploadSession) uploadSessionResponse {
+	return uploadSessionResponse{
+		UploadSession:            s,
+		HeartbeatIntervalSeconds: max(1, int(cfg.uploadSessionTTL.Seconds()/3)),
+		ObservedBytesPerSecond:   observedThroughput(s),
+	}
+}
+
+// uploadSessionExpiry is when a session heartbeating at now expires: one
+// TTL later, but never past its maximum age.
+func (cfg *apiConfig) uploadSessionExpiry(s database.UploadSession, now time.Time) time.Time {
+	expiresAt := now.Add(cfg.uploadSessionTTL)
+	if limit := s.CreatedAt.Add(cfg.uploadSessionMaxAge); expiresAt.After(limit) {
+		return limit
+	}
+	return expiresAt
+}
+
+// uploadSessionRequest is what a client starting an upload session sends.
+type uploadSessionRequest struct {
+	VideoID     uuid.UUID `json:"video_id"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"content_type"`
+	Profile     string    `json:"profile"`
+	PresetID    string    `json:"preset_id"`
+	Parts       int32     `json:"parts"`
+	Size        int64     `json:"size"`
+	// BandwidthKbps is what the client measured its link at, for the
+	// recommended chunk size.
+	BandwidthKbps int `json:"bandwidth_kbps"`
+}
+
+// newUploadSession checks that userID may upload the file params describes
+// to its video, and returns an active session for it, not yet stored, and
+// the target it goes to. If ok is false, an error response has been
+// written.
+func (cfg *apiConfig) newUploadSession(w http.ResponseWriter, r *http.Request, userID uuid.UUID, tenantID string, params uploadSessionRequest) (session database.UploadSession, target tenants.Target, ok bool) {
+	video, err := cfg.db.GetVideo(params.VideoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return database.UploadSession{}, tenants.Target{}, false
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return database.UploadSession{}, tenants.Target{}, false
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "Not authorized to upload for this video", nil)
+		return database.UploadSession{}, tenants.Target{}, false
+	}
+	if !requireNoLegalHold(w, video) {
+		return database.UploadSession{}, tenants.Target{}, false
+	}
+	if !cfg.requireUnlockedVideoFile(w, r, video) {
+		return database.UploadSession{}, tenants.Target{}, false
+	}
+
+	// Checked up front so a client doesn't upload every part only to have
+	// the completion call reject the file.
+	container, ok := cfg.requireVideoContainer(w, params.ContentType)
+	if !ok {
+		return database.UploadSession{}, tenants.Target{}, false
+	}
+	mediaType := container.mediaTypes[0]
+	profileName, ok := cfg.uploadProfile(w, userID, params.Profile, params.PresetID)
+	if !ok {
+		return database.UploadSession{}, tenants.Target{}, false
+	}
+	params.Profile = profileName
+	if _, ok := cfg.profiles.Get(params.Profile); !ok && params.Profile != ffmpeg.ShortsProfileName {
+		respondWithError(w, http.StatusBadRequest, "Unknown processing profile", nil)
+		return database.UploadSession{}, tenants.Target{}, false
+	}
+	if params.Parts < 0 || params.Parts > maxSessionParts {
+		respondWithError(w, http.StatusBadRequest, "Invalid part count", fmt.Errorf("parts must be between 0 and %d", maxSessionParts))
+		return database.UploadSession{}, tenants.Target{}, false
+	}
+	if params.Size < 0 || (params.Size > 0 && params.Parts > 0) {
+		respondWithError(w, http.StatusBadRequest, "Send either a part count or a size", nil)
+		return database.UploadSession{}, tenants.Target{}, false
+	}
+	if params.BandwidthKbps < 0 {
+		respondWithError(w, http.StatusBadRequest, "Invalid bandwidth", nil)
+		return database.UploadSession{}, tenants.Target{}, false
+	}
+	if params.Size > maxSessionUploadSize {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Upload is too large", nil)
+		return database.UploadSession{}, tenants.Target{}, false
+	}
+	if !cfg.requireStorageQuota(w, video.UserID, video.ID, database.StorageKindVideo, params.Size) {
+		return database.UploadSession{}, tenants.Target{}, false
+	}
+
+	target, err = cfg.tenants.Target(r.Context(), tenantID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't resolve storage for tenant", err)
+		return database.UploadSession{}, tenants.Target{}, false
+	}
+
+	now := cfg.clock.Now().UTC()
+	session = database.UploadSession{
+		ID:              uuid.New(),
+		VideoID:         video.ID,
+		UserID:          userID,
+		TenantID:        tenantID,
+		Status:          database.UploadSessionActive,
+		Filename:        params.Filename,
+		ContentType:     mediaType,
+		Profile:         params.Profile,
+		PartCount:       params.Parts,
+		CreatedAt:       now,
+		LastHeartbeatAt: now,
+	}
+	session.ExpiresAt = cfg.uploadSessionExpiry(session, now)
+	return session, target, true
+}
+
+func (cfg *apiConfig) handlerUploadSessionCreate(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, tenantID, err := auth.ValidateTenantJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	params := uploadSessionRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	session, target, ok := cfg.newUploadSession(w, r, userID, tenantID, params)
+	if !ok {
+		return
+	}
+	session.UploadLength = params.Size
+	if session.UploadLength > 0 {
+		if err := cfg.db.CreateUploadSession(session); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't create upload session", err)
+			return
+		}
+		resp := cfg.uploadSessionResponse(session)
+		cfg.recommendUploadChunks(&resp, params.BandwidthKbps)
+		respondWithJSON(w, http.StatusCreated, resp)
+		return
+	}
+	if !requireS3(w, target) {
+		return
+	}
+	session.ObjectKey = videoObjectKey(userID, session.VideoID, "uploads/"+session.ID.String())
+
+	// The assembled object is deleted once processed, so unlike the
+	// processed files it doesn't get the target's retention. It is media
+	// all the same, so it gets the target's encryption.
+	input := &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(target.Bucket),
+		Key:         aws.String(session.ObjectKey),
+		ContentType: aws.String(session.ContentType),
+	}
+	if target.Encryption != nil {
+		input.ServerSideEncryption = types.ServerSideEncryption(target.Encryption.Mode)
+		if target.Encryption.KMSKeyID != "" {
+			input.SSEKMSKeyId = aws.String(target.Encryption.KMSKeyID)
+		}
+	}
+	out, err := target.Client.CreateMultipartUpload(r.Context(), input)
+	if err != nil {
+		respondWithStorageError(w, http.StatusInternalServerError, "Failed to start multipart upload in S3", err)
+		return
+	}
+	session.S3UploadID = aws.ToString(out.UploadId)
+
+	if err := cfg.db.CreateUploadSession(session); err != nil {
+		if err := abortMultipartUpload(context.Background(), target, session); err != nil {
+			log.Printf("Couldn't abort multipart upload for session %s: %v", session.ID, err)
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create upload session", err)
+		return
+	}
+	resp := cfg.uploadSessionResponse(session)
+	cfg.recommendUploadChunks(&resp, params.BandwidthKbps)
+	respondWithJSON(w, http.StatusCreated, resp)
+}
+
+// requireUploadSession loads the upload session in the path and checks that
+// the requester owns it and, under a video's path, that it's an upload of
+// that video. If ok is false, an error response has been written.
+func (cfg *apiConfig) requireUploadSession(w http.ResponseWriter, r *http.Request) (database.UploadSession, bool) {
+	uploadID, err := uuid.Parse(r.PathValue("uploadID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return database.UploadSession{}, false
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return database.UploadSession{}, false
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return database.UploadSession{}, false
+	}
+
+	session, err := cfg.db.GetUploadSession(uploadID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get upload session", err)
+		return database.UploadSession{}, false
+	}
+	if session == nil || session.UserID != userID || (r.PathValue("videoID") != "" && r.PathValue("videoID") != session.VideoID.String()) {
+		respondWithError(w, http.StatusNotFound, "Upload session not found", nil)
+		return database.UploadSession{}, false
+	}
+	return *session, true
+}
+
+// requireActiveUploadSession is requireUploadSession for requests that
+// continue the upload. A session past its expiry that the janitor hasn't
+// reached yet is expired on the spot.
+func (cfg *apiConfig) requireActiveUploadSession(w http.ResponseWriter, r *http.Request) (database.UploadSession, bool) {
+	session, ok := cfg.requireUploadSession(w, r)
+	if !ok {
+		return database.UploadSession{}, false
+	}
+	if session.Status == database.UploadSessionActive && clock.Expired(cfg.clock, session.ExpiresAt) {
+		if err := cfg.endUploadSession(r.Context(), session, database.UploadSessionExpired); err != nil {
+			log.Printf("Couldn't expire upload session %s: %v", session.ID, err)
+		}
+		session.Status = database.UploadSessionExpired
+	}
+	if session.Status != database.UploadSessionActive {
+		respondWithError(w, http.StatusConflict, "Upload session is no longer active", nil)
+		return database.UploadSession{}, false
+	}
+	return session, true
+}
+
+func (cfg *apiConfig) handlerUploadSessionGet(w http.ResponseWriter, r *http.Request) {
+	session, ok := cfg.requireUploadSession(w, r)
+	if !ok {
+		return
+	}
+	parts, err := cfg.db.GetUploadParts(session.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get upload parts", err)
+		return
+	}
+	resp := cfg.uploadSessionResponse(session)
+	resp.Parts = parts
+	resp.MissingParts = missingUploadParts(session, parts)
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// handlerUploadSessionHeartbeat keeps a session alive while its client is
+// still uploading, however slowly. Sessions that stop sending heartbeats
+// expire after the TTL.
+func (cfg *apiConfig) handlerUploadSessionHeartbeat(w http.ResponseWriter, r *http.Request) {
+	session, ok := cfg.requireActiveUploadSession(w, r)
+	if !ok {
+		return
+	}
+	if !cfg.heartbeatUploadSession(w, &session) {
+		return
+	}
+	respondWithJSON(w, http.StatusOK, cfg.uploadSessionResponse(session))
+}
+
+// heartbeatUploadSession extends session's expiry from now. If it returns
+// false, an error response has been written.
+func (cfg *apiConfig) heartbeatUploadSession(w http.ResponseWriter, session *database.UploadSession) bool {
+	now := cfg.clock.Now().UTC()
+	expiresAt := cfg.uploadSessionExpiry(*session, now)
+	active, err := cfg.db.HeartbeatUploadSession(session.ID, now, expiresAt)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update upload session", err)
+		return false
+	}
+	if !active {
+		respondWithError(w, http.StatusConflict, "Upload session is no longer active", nil)
+		return false
+	}
+	session.LastHeartbeatAt = now
+	session.ExpiresAt = expiresAt
+	return true
+}
+
+func (cfg *apiConfig) handlerUploadSessionAbort(w http.ResponseWriter, r *http.Request) {
+	session, ok := cfg.requireActiveUploadSession(w, r)
+	if !ok {
+		return
+	}
+	if err := cfg.endUploadSession(r.Context(), session, database.UploadSessionAborted); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update upload session", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// endUploadSession moves an active session to status and discards the parts
+// it uploaded. Ending a session that has already ended does nothing.
+func (cfg *apiConfig) endUploadSession(ctx context.Context, session database.UploadSession, status string) error {
+	ended, err := cfg.db.TransitionUploadSession(session.ID, database.UploadSessionActive, status)
+	if err != nil || !ended {
+		return err
+	}
+	log.Printf("Upload session %s for video %s %s", session.ID, session.VideoID, status)
+	return cfg.discardUploadParts(ctx, session)
+}
+
+// discardUploadParts aborts the session's multipart upload, which deletes
+// its parts from S3, and forgets them. The chunks of an append session are
+// deleted from disk, and whatever a direct upload stored is deleted.
+func (cfg *apiConfig) discardUploadParts(ctx context.Context, session database.UploadSession) error {
+	if session.UploadLength > 0 {
+		return cfg.removeUploadSpool(session)
+	}
+	target, err := cfg.tenants.Target(ctx, session.TenantID)
+	if err != nil {
+		return err
+	}
+	if session.DirectSize > 0 {
+		return target.Storage().Delete(ctx, session.ObjectKey)
+	}
+	if err := abortMultipartUpload(ctx, target, session); err != nil {
+		return err
+	}
+	return cfg.db.DeleteUploadParts(session.ID)
+}
+
+// endVideoUploadSessions aborts the video's active upload sessions because
+// the video is being deleted. A multipart upload that can't be aborted is
+// only logged, so S3 being unreachable doesn't block the deletion.
+func (cfg *apiConfig) endVideoUploadSessions(ctx context.Context, videoID uuid.UUID) error {
+	sessions, err := cfg.db.GetActiveUploadSessions(videoID)
+	if err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		if err := cfg.endUploadSession(ctx, session, database.UploadSessionAborted); err != nil {
+			log.Printf("Couldn't abort upload session %s: %v", session.ID, err)
+		}
+	}
+	return nil
+}
+
+func abortMultipartUpload(ctx context.Context, target tenants.Target, session database.UploadSession) error {
+	if session.S3UploadID == "" {
+		return nil
+	}
+	_, err := target.Client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(target.Bucket),
+		Key:      aws.String(session.ObjectKey),
+		UploadId: aws.String(session.S3UploadID),
+	})
+	var noUpload *types.NoSuchUpload
+	if errors.As(err, &noUpload) {
+		return nil
+	}
+	return err
+}
+
+// uploadSessionExpiryResult is what a sweep of upload sessions did.
+type uploadSessionExpiryResult struct {
+	Expired int `json:"expired"`
+	Failed  int `json:"failed"`
+}
+
+// expireUploadSessions expires the sessions whose heartbeats have stopped,
+// aborting their multipart uploads. It fails if any session couldn't be
+// expired, after trying the rest.
+func (cfg *apiConfig) expireUploadSessions(ctx context.Context) (uploadSessionExpiryResult, error) {
+	result := uploadSessionExpiryResult{}
+	sessions, err := cfg.db.GetExpiredUploadSessions(cfg.clock.Now().UTC().Add(-clock.MaxSkew))
+	if err != nil {
+		return result, fmt.Errorf("couldn't get expired upload sessions: %w", err)
+	}
+	for _, session := range sessions {
+		if err := cfg.endUploadSession(ctx, session, database.UploadSessionExpired); err != nil {
+			log.Printf("Couldn't expire upload session %s: %v", session.ID, err)
+			result.Failed++
+			continue
+		}
+		result.Expired++
+	}
+	if result.Failed > 0 {
+		return result, fmt.Errorf("couldn't expire %d upload sessions", result.Failed)
+	}
+	return result, nil
+}
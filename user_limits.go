@@ -0,0 +1,90 @@
+package main
+
+import (
+	"maps"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// thumbnailTypes are the media types thumbnails can be uploaded as, see
+// saveThumbnail.
+var thumbnailTypes = []string{"image/heic", "image/heif", "image/jpeg", "image/png"}
+
+// userLimits is everything that limits what a user can upload and how
+// fast, as it stands for them right now.
+type userLimits struct {
+	// VideoUploadBytes is the largest video file they can upload now:
+	// maxUploadSize, or what their storage quota has room for if that's
+	// less.
+	VideoUploadBytes  int64               `json:"video_upload_bytes"`
+	CaptionBytes      int64               `json:"caption_bytes"`
+	Uploads           uploadLimits        `json:"uploads"`
+	VideoTypes        []string            `json:"video_types"`
+	ThumbnailTypes    []string            `json:"thumbnail_types"`
+	CaptionExtensions []string            `json:"caption_extensions"`
+	Storage           storageUsage        `json:"storage"`
+	DailyUploads      *dailyUploadStatus  `json:"daily_uploads,omitempty"`
+	RateLimits        []rateLimitStatus   `json:"rate_limits"`
+	Concurrency       []concurrencyStatus `json:"concurrency"`
+	// MetadataOnly is set if the server doesn't take files, only objects
+	// registered with POST /api/videos/{videoID}/object.
+	MetadataOnly bool `json:"metadata_only"`
+}
+
+// dailyUploadStatus is how many of the day's uploads a user has made.
+type dailyUploadStatus struct {
+	Limit     int       `json:"limit"`
+	Used      int       `json:"used"`
+	Remaining int       `json:"remaining"`
+	ResetAt   time.Time `json:"reset_at"`
+}
+
+// handlerLimits responds with the requester's limits, by JWT or API key,
+// so clients can size their uploads and pace their requests from what the
+// server allows instead of hard-coding it. Rate limit counts are those of
+// the server that answers.
+func (cfg *apiConfig) handlerLimits(w http.ResponseWriter, r *http.Request) {
+	userID, _, ok := cfg.requireUploader(w, r)
+	if !ok {
+		return
+	}
+	usage, err := cfg.storageUsage(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get storage usage", err)
+		return
+	}
+
+	now := cfg.clock.Now()
+	limits := userLimits{
+		VideoUploadBytes:  maxUploadSize,
+		CaptionBytes:      maxCaptionSize,
+		Uploads:           serverUploadLimits,
+		VideoTypes:        slices.Sorted(maps.Keys(cfg.videoContainers)),
+		ThumbnailTypes:    thumbnailTypes,
+		CaptionExtensions: []string{".srt", ".vtt"},
+		Storage:           usage,
+		RateLimits:        []rateLimitStatus{},
+		Concurrency:       concurrencyStatuses(cfg.uploadLimit, cfg.readLimit),
+		MetadataOnly:      cfg.metadataOnly,
+	}
+	if usage.RemainingBytes != nil {
+		limits.VideoUploadBytes = min(limits.VideoUploadBytes, *usage.RemainingBytes)
+	}
+	if l := cfg.dailyUploadLimit; l != nil {
+		used := l.used(userID, now)
+		limits.DailyUploads = &dailyUploadStatus{
+			Limit:     l.limit,
+			Used:      used,
+			Remaining: max(l.limit-used, 0),
+			ResetAt:   now.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour),
+		}
+	}
+	ip := cfg.clientIP(r)
+	for _, l := range []*rateLimit{cfg.uploadRateLimit, cfg.readRateLimit} {
+		if l != nil {
+			limits.RateLimits = append(limits.RateLimits, l.status(now, &userID, ip))
+		}
+	}
+	respondWithJSON(w, http.StatusOK, limits)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ffmpeg"
+	"github.com/google/uuid"
+)
+
+// maxWatermarkText caps the text burned into a user's uploads, which has to
+// fit in a corner of the frame.
+const maxWatermarkText = 100
+
+// eventUserNotified is emitted for each notification a user gets, for
+// notification integrations to deliver.
+const eventUserNotified = "user.notified"
+
+// Kinds of user notification.
+const (
+	notificationProcessingDone   = "processing_done"
+	notificationProcessingFailed = "processing_failed"
+)
+
+func (cfg *apiConfig) handlerUserSettingsGet(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.requireUser(w, r)
+	if !ok {
+		return
+	}
+	settings, err := cfg.db.GetUserSettings(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get settings", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, settings)
+}
+
+// handlerUserSettingsUpdate changes the settings in the body, keeping the
+// ones it leaves out.
+func (cfg *apiConfig) handlerUserSettingsUpdate(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.requireUser(w, r)
+	if !ok {
+		return
+	}
+	settings, err := cfg.db.GetUserSettings(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get settings", err)
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	settings.UserID = userID
+	settings.WatermarkText = strings.TrimSpace(settings.WatermarkText)
+
+	if _, ok := cfg.profiles.Get(settings.DefaultProfile); !ok && settings.DefaultProfile != ffmpeg.ShortsProfileName {
+		respondWithError(w, http.StatusBadRequest, "Unknown processing profile", nil)
+		return
+	}
+	if settings.Watermark && settings.WatermarkText == "" {
+		respondWithError(w, http.StatusBadRequest, "Watermark text is required", nil)
+		return
+	}
+	if utf8.RuneCountInString(settings.WatermarkText) > maxWatermarkText {
+		respondWithError(w, http.StatusBadRequest, "Watermark text is too long", fmt.Errorf("watermark text is over %d characters", maxWatermarkText))
+		return
+	}
+
+	settings.UpdatedAt = cfg.clock.Now().UTC()
+	if err := cfg.db.SaveUserSettings(settings); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save settings", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, settings)
+}
+
+// parseWatermarkOverride reads an upload's "watermark" field, which
+// overrides the uploader's watermark setting when present.
+func parseWatermarkOverride(v string) (*bool, error) {
+	if v == "" {
+		return nil, nil
+	}
+	on, err := strconv.ParseBool(v)
+	if err != nil {
+		return nil, err
+	}
+	return &on, nil
+}
+
+// applyUploadSettings fills in what src leaves to userID's settings: the
+// processing profile and the watermark. A default profile that has since
+// been removed from the configuration is ignored.
+func (cfg *apiConfig) applyUploadSettings(userID uuid.UUID, src videoSource) (videoSource, error) {
+	settings, err := cfg.db.GetUserSettings(userID)
+	if err != nil {
+		return src, err
+	}
+	if src.profileName == "" && settings.DefaultProfile != "" {
+		if _, ok := cfg.profiles.Get(settings.DefaultProfile); ok || settings.DefaultProfile == ffmpeg.ShortsProfileName {
+			src.profileName = settings.DefaultProfile
+		}
+	}
+	watermark := settings.Watermark
+	if src.watermark != nil {
+		watermark = *src.watermark
+	}
+	if watermark {
+		src.watermarkText = settings.WatermarkText
+	}
+	return src, nil
+}
+
+// watermarkVideo burns text into a copy of the video at path and returns
+// the copy's path.
+func watermarkVideo(ctx context.Context, path, text string) (string, error) {
+	output := strings.TrimSuffix(path, ".mp4") + ".watermarked.mp4"
+	start := time.Now()
+	_, err := ffmpeg.WatermarkCommand(path, output, text).Run(ctx)
+	logStep(ctx, "watermark", "burn in uploader's watermark", start, err)
+	if err != nil {
+		os.Remove(output)
+		return "", err
+	}
+	return output, nil
+}
+
+// notifyProcessing notifies video's owner of how processing went, if their
+// settings ask for it.
+func (cfg *apiConfig) notifyProcessing(video database.Video, failure string) {
+	settings, err := cfg.db.GetUserSettings(video.UserID)
+	if err != nil {
+		log.Printf("Couldn't get settings of user %s: %v", video.UserID, err)
+		return
+	}
+	kind, notify := notificationProcessingDone, settings.NotifyProcessingDone
+	if failure != "" {
+		kind, notify = notificationProcessingFailed, settings.NotifyProcessingFailed
+	}
+	if !notify {
+		return
+	}
+	data := map[string]any{"user_id": video.UserID, "kind": kind}
+	if failure != "" {
+		data["error"] = failure
+	}
+	cfg.emitEvent(eventUserNotified, video.ID, data)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// urlCheck is the result of fetching one playback URL.
+type urlCheck struct {
+	VideoID   string
+	Rendition string
+	// Kind is "signature" when the URL's signature is rejected, "permission"
+	// when it is accepted but access is denied, "missing" when the object is
+	// gone and "error" for anything else. It is empty when the URL works.
+	Kind   string
+	Detail string
+}
+
+// runVerifyURLsCommand checks that every stored video can actually be
+// played through the URLs the server hands out:
+//
+//	tubely verify-urls [-concurrency 4]
+//
+// It signs a playback URL for each video, and its SDR copy if it has one,
+// exactly as the playback endpoint does, and fetches it. A URL signed for
+// GET can't be used for HEAD, so only the first byte is requested, which
+// exercises the same signature and permissions. Each failure is printed,
+// and the command fails if there are any, so it can gate a deploy that
+// changes keys or bucket policies.
+func (cfg *apiConfig) runVerifyURLsCommand(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("verify-urls", flag.ContinueOnError)
+	concurrency := fs.Int("concurrency", 4, "URLs to check at once")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *concurrency < 1 {
+		return errors.New("-concurrency must be at least 1")
+	}
+
+	videos, err := cfg.db.GetAllVideos()
+	if err != nil {
+		return fmt.Errorf("couldn't list videos: %w", err)
+	}
+
+	queue := make(chan database.Video)
+	results := make(chan urlCheck)
+	var wg sync.WaitGroup
+	for range *concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for video := range queue {
+				for _, check := range cfg.verifyPlaybackURLs(ctx, video) {
+					results <- check
+				}
+			}
+		}()
+	}
+	go func() {
+		for _, video := range videos {
+			if video.VideoURL != nil {
+				queue <- video
+			}
+		}
+		close(queue)
+		wg.Wait()
+		close(results)
+	}()
+
+	checked, failed := 0, map[string]int{}
+	for check := range results {
+		checked++
+		if check.Kind == "" {
+			continue
+		}
+		failed[check.Kind]++
+		fmt.Fprintf(os.Stdout, "%s\t%s\t%s\t%s\n", check.VideoID, check.Rendition, check.Kind, check.Detail)
+	}
+
+	total := 0
+	for _, n := range failed {
+		total += n
+	}
+	log.Printf("Checked %d playback URLs: %d signature, %d permission, %d missing, %d other failures",
+		checked, failed["signature"], failed["permission"], failed["missing"], failed["error"])
+	if total > 0 {
+		return fmt.Errorf("%d of %d playback URLs failed", total, checked)
+	}
+	return nil
+}
+
+// verifyPlaybackURLs checks the URLs of video's original and SDR files.
+func (cfg *apiConfig) verifyPlaybackURLs(ctx context.Context, video database.Video) []urlCheck {
+	renditions := []struct {
+		name string
+		url  *string
+	}{
+		{"original", video.VideoURL},
+		{"sdr", video.SDRVideoURL},
+	}
+	checks := []urlCheck{}
+	for _, rendition := range renditions {
+		if rendition.url == nil {
+			continue
+		}
+		check := urlCheck{VideoID: video.ID.String(), Rendition: rendition.name}
+		video.VideoURL = rendition.url
+		check.Kind, check.Detail = cfg.verifyPlaybackURL(ctx, video)
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+// signatureErrorCodes are the S3 errors for a URL whose signature or
+// credentials are rejected, as opposed to one that is denied by policy.
+var signatureErrorCodes = map[string]bool{
+	"SignatureDoesNotMatch":             true,
+	"InvalidAccessKeyId":                true,
+	"ExpiredToken":                      true,
+	"InvalidToken":                      true,
+	"AuthorizationQueryParametersError": true,
+}
+
+func (cfg *apiConfig) verifyPlaybackURL(ctx context.Context, video database.Video) (kind, detail string) {
+	target, key, err := cfg.videoObject(ctx, video)
+	if err != nil {
+		return "error", err.Error()
+	}
+	playbackURL, err := generatePresignedURL(ctx, target, key, cfg.presignExpiry)
+	if err != nil {
+		return "signature", err.Error()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, playbackURL, nil)
+	if err != nil {
+		return "error", err.Error()
+	}
+	req.Header.Set("Range", "bytes=0-0")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "error", err.Error()
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 400 {
+		return "", ""
+	}
+
+	var s3Err struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	xml.Unmarshal(body, &s3Err)
+	detail = resp.Status
+	if s3Err.Code != "" {
+		detail = fmt.Sprintf("%s: %s: %s", resp.Status, s3Err.Code, s3Err.Message)
+	}
+	switch {
+	case signatureErrorCodes[s3Err.Code]:
+		return "signature", detail
+	case resp.StatusCode == http.StatusForbidden:
+		return "permission", detail
+	case resp.StatusCode == http.StatusNotFound:
+		return "missing", detail
+	}
+	return "error", detail
+}
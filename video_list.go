@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// videoListResponse is a page of GET /api/videos. NextCursor is set when
+// the page is full, and fetches the page after it.
+type videoListResponse struct {
+	Videos     []database.Video `json:"videos"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+}
+
+// videoListCursor is what a next_cursor stands for: the key of the last
+// video of a page, and the order it was listed in.
+type videoListCursor struct {
+	Sort       string `json:"s"`
+	Descending bool   `json:"d"`
+	database.VideoListKey
+}
+
+func encodeVideoListCursor(c videoListCursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// parseVideoList reads the filters, order and page of GET /api/videos
+// from the query: aspect_ratio, processing_status, created_after and
+// created_before (RFC 3339), q (part of the title), custom_metadata.<key>
+// (a custom metadata value), sort (created_at, updated_at or title), order
+// (asc or desc; by default newest or A first), limit and cursor. paged is
+// false if neither limit nor cursor is given, for clients that expect
+// every video in one array. If ok is false, an error response has been
+// written.
+func parseVideoList(w http.ResponseWriter, r *http.Request, userID uuid.UUID) (params database.VideoListParams, paged, ok bool) {
+	q := r.URL.Query()
+	params = database.VideoListParams{
+		UserID:           userID,
+		AspectRatio:      q.Get("aspect_ratio"),
+		ProcessingStatus: q.Get("processing_status"),
+		Search:           q.Get("q"),
+		Sort:             database.VideoSortCreatedAt,
+	}
+
+	for name, t := range map[string]*time.Time{"created_after": &params.CreatedAfter, "created_before": &params.CreatedBefore} {
+		v := q.Get(name)
+		if v == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "created_after and created_before must be RFC 3339 times", err)
+			return params, false, false
+		}
+		*t = parsed
+	}
+	if !parseCustomMetadataFilter(w, r, &params) {
+		return params, false, false
+	}
+
+	if v := q.Get("sort"); v != "" {
+		switch v {
+		case database.VideoSortCreatedAt, database.VideoSortUpdatedAt, database.VideoSortTitle:
+			params.Sort = v
+		default:
+			respondWithError(w, http.StatusBadRequest, "sort must be created_at, updated_at or title", nil)
+			return params, false, false
+		}
+	}
+	params.Descending = params.Sort != database.VideoSortTitle
+	switch q.Get("order") {
+	case "":
+	case "asc":
+		params.Descending = false
+	case "desc":
+		params.Descending = true
+	default:
+		respondWithError(w, http.StatusBadRequest, "order must be asc or desc", nil)
+		return params, false, false
+	}
+
+	if !q.Has("limit") && !q.Has("cursor") {
+		return params, false, true
+	}
+	params.Limit = 50
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > 500 {
+			respondWithError(w, http.StatusBadRequest, "limit must be between 1 and 500", err)
+			return params, false, false
+		}
+		params.Limit = limit
+	}
+	if v := q.Get("cursor"); v != "" {
+		var cursor videoListCursor
+		b, err := base64.RawURLEncoding.DecodeString(v)
+		if err == nil {
+			err = json.Unmarshal(b, &cursor)
+		}
+		if err == nil && (cursor.Sort != params.Sort || cursor.Descending != params.Descending) {
+			err = errors.New("the cursor is for another order")
+		}
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid pagination cursor", err)
+			return params, false, false
+		}
+		params.After = &cursor.VideoListKey
+	}
+	return params, true, true
+}
+
+// nextVideoListCursor returns the cursor of the page after videos, or ""
+// if videos isn't a full page.
+func nextVideoListCursor(params database.VideoListParams, videos []database.Video) string {
+	if len(videos) == 0 || len(videos) < params.Limit {
+		return ""
+	}
+	return encodeVideoListCursor(videoListCursor{
+		Sort:         params.Sort,
+		Descending:   params.Descending,
+		VideoListKey: database.VideoListKeyOf(params.Sort, videos[len(videos)-1]),
+	})
+}
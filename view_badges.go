@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// View badges show a public video's view count on other sites, as JSON or
+// as an SVG badge to put in an <img>. They're fetched on every page view
+// of wherever they're embedded, so they're cached at the edge for
+// viewBadgeMaxAge and the count is at most that stale.
+const viewBadgeMaxAge = 5 * time.Minute
+
+// viewBadgeCacheControl lets browsers and CDNs keep a badge for
+// viewBadgeMaxAge, and serve it a little past that while they refetch it.
+var viewBadgeCacheControl = fmt.Sprintf("public, max-age=%d, s-maxage=%d, stale-while-revalidate=60", int(viewBadgeMaxAge.Seconds()), int(viewBadgeMaxAge.Seconds()))
+
+// viewBadge is what a badge shows: a video's views ever, or within Window,
+// one of TRENDING_WINDOWS.
+type viewBadge struct {
+	VideoID uuid.UUID `json:"video_id"`
+	Views   int       `json:"views"`
+	Window  string    `json:"window,omitempty"`
+}
+
+// handlerViewBadge responds with a video's view count as JSON.
+func (cfg *apiConfig) handlerViewBadge(w http.ResponseWriter, r *http.Request) {
+	badge, ok := cfg.viewBadge(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Cache-Control", viewBadgeCacheControl)
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	respondWithJSON(w, http.StatusOK, badge)
+}
+
+// handlerViewBadgeSVG responds with a video's view count as an SVG badge.
+func (cfg *apiConfig) handlerViewBadgeSVG(w http.ResponseWriter, r *http.Request) {
+	badge, ok := cfg.viewBadge(w, r)
+	if !ok {
+		return
+	}
+	label := "views"
+	if badge.Window != "" {
+		label += " (" + badge.Window + ")"
+	}
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Cache-Control", viewBadgeCacheControl)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(renderViewBadge(label, compactCount(badge.Views))))
+}
+
+// viewBadge looks up the counts of the video in the path, over ?window= if
+// it's given. Only public videos that have been published have badges;
+// others are reported not found, so a badge can't tell whether they
+// exist. If ok is false, an error response has been written.
+func (cfg *apiConfig) viewBadge(w http.ResponseWriter, r *http.Request) (viewBadge, bool) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return viewBadge{}, false
+	}
+	var since time.Time
+	badge := viewBadge{VideoID: videoID}
+	if v := r.URL.Query().Get("window"); v != "" {
+		i := slices.IndexFunc(cfg.trendingWindows, func(w trendingWindow) bool { return w.Name == v })
+		if i < 0 {
+			respondWithError(w, http.StatusBadRequest, "Unknown trending window", fmt.Errorf("window %q is not configured", v))
+			return viewBadge{}, false
+		}
+		badge.Window = v
+		since = cfg.clock.Now().Add(-cfg.trendingWindows[i].Duration)
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return viewBadge{}, false
+	}
+	if video.ID == uuid.Nil || video.Visibility != database.VisibilityPublic || !video.Published(cfg.clock.Now()) || video.ModerationHold {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return viewBadge{}, false
+	}
+	badge.Views, err = cfg.db.GetVideoViews(videoID, since)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get view counts", err)
+		return viewBadge{}, false
+	}
+	return badge, true
+}
+
+// compactCount shortens a count for a badge: 999, 1.2K, 34K, 5.6M.
+func compactCount(n int) string {
+	for _, unit := range []struct {
+		size   float64
+		suffix string
+	}{{1e9, "B"}, {1e6, "M"}, {1e3, "K"}} {
+		if f := float64(n); f >= unit.size {
+			v := f / unit.size
+			if v < 10 {
+				// Rounded down, so 1,999 doesn't show as 2.0K.
+				return strconv.FormatFloat(float64(int(v*10))/10, 'f', -1, 64) + unit.suffix
+			}
+			return strconv.Itoa(int(v)) + unit.suffix
+		}
+	}
+	return strconv.Itoa(n)
+}
+
+// renderViewBadge draws a flat badge with label on the left and value on
+// the right, sized for 11px Verdana.
+func renderViewBadge(label, value string) string {
+	const charWidth, padding = 7, 10
+	lw := utf8.RuneCountInString(label)*charWidth + padding
+	vw := utf8.RuneCountInString(value)*charWidth + padding
+	label, value = html.EscapeString(label), html.EscapeString(value)
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%[1]d" height="20" role="img" aria-label="%[4]s: %[5]s">`+
+		`<title>%[4]s: %[5]s</title>`+
+		`<rect width="%[2]d" height="20" fill="#555"/><rect x="%[2]d" width="%[3]d" height="20" fill="#007ec6"/>`+
+		`<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">`+
+		`<text x="%[6]d" y="14">%[4]s</text><text x="%[7]d" y="14">%[5]s</text></g></svg>`,
+		lw+vw, lw, vw, label, value, lw/2, lw+vw/2)
+}
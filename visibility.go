@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// shareKeyParam is the query parameter an unlisted video's links carry its
+// share key in.
+const shareKeyParam = "key"
+
+// newShareKey returns a random share key for an unlisted video.
+func newShareKey() (string, error) {
+	b := make([]byte, 18)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hasShareKey reports whether r carries the share key of video.
+func hasShareKey(r *http.Request, video database.Video) bool {
+	key := r.URL.Query().Get(shareKeyParam)
+	return video.ShareKey != "" && subtle.ConstantTimeCompare([]byte(key), []byte(video.ShareKey)) == 1
+}
+
+// canList reports whether a video belongs in listings shown to the
+// requester. Those are the videos canView lets them see, less other users'
+// unlisted ones: having the share key lets someone watch a video, not find
+// it.
+func (cfg *apiConfig) canList(r *http.Request, video database.Video) bool {
+	if video.Visibility == database.VisibilityPublic {
+		return cfg.canView(r, video)
+	}
+	viewerID := cfg.viewerID(r)
+	return viewerID != nil && *viewerID == video.UserID
+}
+
+// videoURLExpiry is how long the URLs handed out for video's files last:
+// long for public videos, so the CDN and players can keep them, and short
+// for private and passphrase-protected ones, so a leaked URL soon stops
+// working.
+func (cfg *apiConfig) videoURLExpiry(video database.Video) time.Duration {
+	if video.PassphraseHash != "" {
+		return cfg.privateURLExpiry
+	}
+	switch video.Visibility {
+	case database.VisibilityPublic:
+		return cfg.publicURLExpiry
+	case database.VisibilityPrivate:
+		return cfg.privateURLExpiry
+	default:
+		return cfg.presignExpiry
+	}
+}
+
+// handlerVideoVisibilitySet makes a video public, unlisted or private.
+// Making it unlisted gives it a share key, which its URLs carry from then
+// on; rotate_share_key replaces the key, which breaks the links handed out
+// with the old one. The key is dropped when the video stops being
+// unlisted.
+func (cfg *apiConfig) handlerVideoVisibilitySet(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.requireVideoOwner(w, r)
+	if !ok {
+		return
+	}
+
+	type parameters struct {
+		Visibility     string `json:"visibility"`
+		RotateShareKey bool   `json:"rotate_share_key"`
+	}
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	shareKey := ""
+	switch params.Visibility {
+	case database.VisibilityPublic, database.VisibilityPrivate:
+	case database.VisibilityUnlisted:
+		shareKey = video.ShareKey
+		if shareKey == "" || params.RotateShareKey {
+			var err error
+			shareKey, err = newShareKey()
+			if err != nil {
+				respondWithError(w, http.StatusInternalServerError, "Couldn't generate share key", err)
+				return
+			}
+		}
+	default:
+		respondWithError(w, http.StatusBadRequest, "visibility must be public, unlisted or private", fmt.Errorf("unknown visibility %q", params.Visibility))
+		return
+	}
+
+	previous := video.Visibility
+	if err := cfg.db.SetVideoVisibility(video.ID, params.Visibility, shareKey); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+	cfg.signedURLs.invalidate(video.ID)
+	cfg.emitEvent(eventVideoUpdated, video.ID, map[string]any{"visibility": params.Visibility})
+
+	// Edge copies of a video made private would otherwise stay reachable
+	// through the CDN URLs handed out while it was public.
+	if params.Visibility == database.VisibilityPrivate && previous != database.VisibilityPrivate && cfg.cdn != nil {
+		target, err := cfg.videoTarget(r.Context(), video)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't resolve storage for tenant", err)
+			return
+		}
+		renditions, err := cfg.db.GetRenditions(video.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get renditions", err)
+			return
+		}
+		if paths := cfg.replacedVideoPaths(target, video, renditions); len(paths) > 0 {
+			cfg.background.run(func() { cfg.invalidate(video.ID, "visibility", paths) })
+		}
+	}
+
+	video.Visibility = params.Visibility
+	video.ShareKey = shareKey
+	video, err := cfg.dbVideoToSignedVideo(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URLs", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, video)
+}
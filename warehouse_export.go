@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// With WAREHOUSE_EXPORT_PREFIX set, every day's videos, events and usage
+// are exported under it as gzipped CSV, one partition per UTC day in the
+// Hive layout Athena and BigQuery read:
+//
+//	<prefix>events/dt=2026-10-13/events.csv.gz
+//
+// Analytics teams query the files instead of the production database.
+// Events are those of the day; videos are a snapshot of the ones that
+// aren't in the trash when the day is exported, and usage is each of
+// those videos' views that day and the bytes stored for it.
+
+const (
+	// warehouseExportCheckInterval is how often the server checks for
+	// days to export, by default.
+	warehouseExportCheckInterval = time.Hour
+	// warehouseExportBackfill is how many days are caught up on at most,
+	// after the server was down.
+	warehouseExportBackfill = 31
+	warehouseDayFormat      = "2006-01-02"
+)
+
+// exportDueWarehouseDays exports the days since the last one exported,
+// up to yesterday, or just yesterday if none has been. It returns the
+// exports it wrote, stopping at the first day that fails.
+func (cfg *apiConfig) exportDueWarehouseDays(ctx context.Context) ([]database.WarehouseExport, error) {
+	exports := []database.WarehouseExport{}
+	yesterday := cfg.clock.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -1)
+	latest, err := cfg.db.GetLatestWarehouseExportDay()
+	if err != nil {
+		return exports, fmt.Errorf("couldn't get latest warehouse export: %w", err)
+	}
+	day := yesterday
+	if latest != "" {
+		last, err := time.Parse(warehouseDayFormat, latest)
+		if err != nil {
+			return exports, fmt.Errorf("couldn't parse latest warehouse export day %q: %w", latest, err)
+		}
+		day = last.AddDate(0, 0, 1)
+	}
+	if earliest := yesterday.AddDate(0, 0, 1-warehouseExportBackfill); day.Before(earliest) {
+		day = earliest
+	}
+	for ; !day.After(yesterday); day = day.AddDate(0, 0, 1) {
+		export, err := cfg.exportWarehouseDay(ctx, day)
+		if err != nil {
+			return exports, fmt.Errorf("warehouse export of %s failed: %w", day.Format(warehouseDayFormat), err)
+		}
+		log.Printf("Exported %s to the warehouse: %d videos, %d events, %d usage rows", export.Day, export.Videos, export.Events, export.Usage)
+		exports = append(exports, export)
+	}
+	return exports, nil
+}
+
+// exportWarehouseDay writes the partitions of the UTC day starting at day,
+// replacing any written before.
+func (cfg *apiConfig) exportWarehouseDay(ctx context.Context, day time.Time) (database.WarehouseExport, error) {
+	export := database.WarehouseExport{Day: day.Format(warehouseDayFormat)}
+	end := day.AddDate(0, 0, 1)
+
+	tenants, err := cfg.db.GetUserTenants()
+	if err != nil {
+		return export, fmt.Errorf("couldn't get tenants: %w", err)
+	}
+	videos, err := cfg.db.GetAllVideos()
+	if err != nil {
+		return export, fmt.Errorf("couldn't get videos: %w", err)
+	}
+
+	videoFile := newWarehouseFile("id", "user_id", "tenant_id", "title", "visibility", "processing_status", "is_short", "tags", "created_at", "updated_at", "publish_at", "duration_seconds", "size_bytes", "video_codec", "width", "height")
+	for _, video := range videos {
+		tags, err := json.Marshal(video.Tags)
+		if err != nil {
+			return export, err
+		}
+		row := []string{
+			video.ID.String(),
+			video.UserID.String(),
+			tenants[video.UserID],
+			video.Title,
+			video.Visibility,
+			video.ProcessingStatus,
+			strconv.FormatBool(video.IsShort),
+			string(tags),
+			warehouseTime(&video.CreatedAt),
+			warehouseTime(&video.UpdatedAt),
+			warehouseTime(video.PublishAt),
+			"", "", "", "", "",
+		}
+		if m := video.Metadata; m != nil {
+			row[11] = strconv.FormatFloat(m.DurationSeconds, 'f', -1, 64)
+			row[12] = strconv.FormatInt(m.SizeBytes, 10)
+			row[13] = m.VideoCodec
+			row[14] = strconv.Itoa(m.Width)
+			row[15] = strconv.Itoa(m.Height)
+		}
+		if err := videoFile.write(row); err != nil {
+			return export, err
+		}
+	}
+	export.Videos = len(videos)
+
+	eventFile := newWarehouseFile("seq", "id", "type", "video_id", "user_id", "tenant_id", "occurred_at", "data")
+	params := database.EventLogParams{OccurredFrom: day, OccurredBefore: end, Limit: maxEventLogLimit}
+	for {
+		events, err := cfg.db.GetEvents(params)
+		if err != nil {
+			return export, fmt.Errorf("couldn't get events: %w", err)
+		}
+		for _, e := range events {
+			row := []string{
+				strconv.FormatInt(e.Seq, 10),
+				e.ID.String(),
+				e.Type,
+				e.VideoID.String(),
+				e.UserID.String(),
+				e.TenantID,
+				warehouseTime(&e.OccurredAt),
+				string(e.Data),
+			}
+			if err := eventFile.write(row); err != nil {
+				return export, err
+			}
+			params.After = e.Seq
+		}
+		export.Events += len(events)
+		if len(events) < params.Limit {
+			break
+		}
+	}
+
+	counts, err := cfg.db.GetViewCountsBetween(day, end)
+	if err != nil {
+		return export, fmt.Errorf("couldn't get views: %w", err)
+	}
+	views := make(map[uuid.UUID]int, len(counts))
+	for _, c := range counts {
+		views[c.VideoID] = c.Views
+	}
+	stored, err := cfg.db.GetVideoStorageUsage()
+	if err != nil {
+		return export, fmt.Errorf("couldn't get storage usage: %w", err)
+	}
+	usageFile := newWarehouseFile("day", "video_id", "user_id", "tenant_id", "views", "storage_bytes")
+	for _, video := range videos {
+		row := []string{
+			export.Day,
+			video.ID.String(),
+			video.UserID.String(),
+			tenants[video.UserID],
+			strconv.Itoa(views[video.ID]),
+			strconv.FormatInt(stored[video.ID], 10),
+		}
+		if err := usageFile.write(row); err != nil {
+			return export, err
+		}
+	}
+	export.Usage = len(videos)
+
+	target, err := cfg.tenants.Target(ctx, "")
+	if err != nil {
+		return export, err
+	}
+	for dataset, file := range map[string]*warehouseFile{"videos": videoFile, "events": eventFile, "usage": usageFile} {
+		body, err := file.close()
+		if err != nil {
+			return export, err
+		}
+		key := cfg.warehousePrefix + dataset + "/dt=" + export.Day + "/" + dataset + ".csv.gz"
+		if err := putObject(ctx, target, key, bytes.NewReader(body), "application/gzip"); err != nil {
+			return export, fmt.Errorf("couldn't upload %s: %w", key, err)
+		}
+	}
+
+	export.ExportedAt = cfg.clock.Now().UTC()
+	if err := cfg.db.RecordWarehouseExport(export); err != nil {
+		return export, fmt.Errorf("couldn't record export: %w", err)
+	}
+	return export, nil
+}
+
+// warehouseFile is a gzipped CSV file being written in memory.
+type warehouseFile struct {
+	buf bytes.Buffer
+	gz  *gzip.Writer
+	csv *csv.Writer
+	err error
+}
+
+// newWarehouseFile starts a file with a header row of columns.
+func newWarehouseFile(columns ...string) *warehouseFile {
+	f := &warehouseFile{}
+	f.gz = gzip.NewWriter(&f.buf)
+	f.csv = csv.NewWriter(f.gz)
+	f.err = f.csv.Write(columns)
+	return f
+}
+
+func (f *warehouseFile) write(row []string) error {
+	if f.err == nil {
+		f.err = f.csv.Write(row)
+	}
+	return f.err
+}
+
+// close finishes the file and returns its contents.
+func (f *warehouseFile) close() ([]byte, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	f.csv.Flush()
+	if err := f.csv.Error(); err != nil {
+		return nil, err
+	}
+	if err := f.gz.Close(); err != nil {
+		return nil, err
+	}
+	return f.buf.Bytes(), nil
+}
+
+// warehouseTime formats t the way Athena and BigQuery parse timestamps,
+// or as an empty field if it's nil.
+func warehouseTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format("2006-01-02 15:04:05.000")
+}
+
+// handlerAdminWarehouseExportsList lists the latest days exported.
+func (cfg *apiConfig) handlerAdminWarehouseExportsList(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+	if cfg.warehousePrefix == "" {
+		respondWithError(w, http.StatusNotFound, "Warehouse exports are not configured", nil)
+		return
+	}
+	exports, err := cfg.db.GetWarehouseExports(warehouseExportBackfill)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get warehouse exports", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, exports)
+}
+
+// handlerAdminWarehouseExportCreate exports a past day again, such as one
+// older than the server caught up on.
+func (cfg *apiConfig) handlerAdminWarehouseExportCreate(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+	if cfg.warehousePrefix == "" {
+		respondWithError(w, http.StatusNotFound, "Warehouse exports are not configured", nil)
+		return
+	}
+	type parameters struct {
+		Day string `json:"day"`
+	}
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	day, err := time.Parse(warehouseDayFormat, params.Day)
+	if err != nil || !day.Before(cfg.clock.Now().UTC().Truncate(24*time.Hour)) {
+		respondWithError(w, http.StatusBadRequest, "day must be a past date, YYYY-MM-DD", err)
+		return
+	}
+	export, err := cfg.exportWarehouseDay(r.Context(), day)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Warehouse export failed", err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, export)
+}
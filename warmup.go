@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ffmpeg"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// warmupCanaryKey is the object the warmup presigns and looks up. It
+// doesn't need to exist: asking for it is what resolves credentials and
+// opens the connection to storage.
+const warmupCanaryKey = "tubely-warmup/canary"
+
+// warmupDBConns is how many database connections the warmup opens, as
+// many as database/sql keeps idle by default.
+const warmupDBConns = 2
+
+// warmup does ahead of time the slow work the first requests after a
+// deploy would otherwise pay for: running ffmpeg and ffprobe for the first
+// time, loading storage credentials and signing a URL with them, and
+// opening database and storage connections. The steps run at once, within
+// timeout. A step that fails is only logged, since the request that needs
+// it will fail the same way and say why.
+func (cfg *apiConfig) warmup(ctx context.Context, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	steps := []struct {
+		name string
+		run  func(ctx context.Context) error
+	}{
+		{"ffmpeg", ffmpeg.Warmup},
+		{"database", func(ctx context.Context) error {
+			return cfg.db.Warm(ctx, warmupDBConns)
+		}},
+		{"storage", cfg.warmStorage},
+	}
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for _, step := range steps {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stepStart := time.Now()
+			if err := step.run(ctx); err != nil {
+				log.Printf("Warmup: %s failed after %v: %v", step.name, time.Since(stepStart).Round(time.Millisecond), err)
+				return
+			}
+			log.Printf("Warmup: %s ready in %v", step.name, time.Since(stepStart).Round(time.Millisecond))
+		}()
+	}
+	wg.Wait()
+	log.Printf("Warmup finished in %v", time.Since(start).Round(time.Millisecond))
+}
+
+// warmStorage presigns the canary with the default target's credentials,
+// and through the CDN if videos are delivered from one, then looks it up
+// to open a connection to the bucket.
+func (cfg *apiConfig) warmStorage(ctx context.Context) error {
+	target := cfg.tenants.Defaults()
+	if _, err := generatePresignedURL(ctx, target, warmupCanaryKey, cfg.presignExpiry); err != nil {
+		return err
+	}
+	if _, err := cfg.deliveryURL(ctx, target, warmupCanaryKey); err != nil {
+		return err
+	}
+	if _, err := target.Storage().Size(ctx, warmupCanaryKey); err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return err
+	}
+	return nil
+}
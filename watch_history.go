@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Players report how far a viewer got through a video while it plays, so
+// they can pick up where they left off later. Each user sees and deletes
+// only their own history.
+
+// watchProgressInterval is how often a player may report its position for a
+// video. Players typically report every 10 to 30 seconds, plus once when the
+// page is hidden.
+const watchProgressInterval = 5 * time.Second
+
+// watchCompletedShare is how far through a video a viewer has to get for it
+// to count as watched, so it resumes from the start. Credits rarely get
+// watched to the end.
+const watchCompletedShare = 0.95
+
+type watchProgressResponse struct {
+	database.WatchProgress
+	Completed bool `json:"completed"`
+	// ResumeSeconds is where a player should start the video.
+	ResumeSeconds float64 `json:"resume_seconds"`
+}
+
+func newWatchProgressResponse(p database.WatchProgress) watchProgressResponse {
+	resp := watchProgressResponse{WatchProgress: p, ResumeSeconds: p.PositionSeconds}
+	if p.DurationSeconds > 0 && p.PositionSeconds >= p.DurationSeconds*watchCompletedShare {
+		resp.Completed = true
+		resp.ResumeSeconds = 0
+	}
+	return resp
+}
+
+// requireUser returns the user the request's JWT is for. If ok is
+// false, an error response has been written.
+func (cfg *apiConfig) requireUser(w http.ResponseWriter, r *http.Request) (userID uuid.UUID, ok bool) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return uuid.Nil, false
+	}
+	userID, err = auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return uuid.Nil, false
+	}
+	return userID, true
+}
+
+// handlerWatchProgressReport records the viewer's position in a video. It
+// accepts bodies without a JSON Content-Type, so players can report from
+// fetch with keepalive as the page closes. Reports that come too soon after
+// the last one get a 429 with a Retry-After.
+func (cfg *apiConfig) handlerWatchProgressReport(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		PositionSeconds float64 `json:"position_seconds"`
+		DurationSeconds float64 `json:"duration_seconds"`
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+	userID, ok := cfg.requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<10)).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.PositionSeconds < 0 || params.DurationSeconds < 0 {
+		respondWithError(w, http.StatusBadRequest, "Invalid playback position", nil)
+		return
+	}
+	if params.DurationSeconds > 0 {
+		params.PositionSeconds = min(params.PositionSeconds, params.DurationSeconds)
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil || !cfg.canView(r, video) {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+
+	saved, next, err := cfg.db.SaveWatchProgress(database.WatchProgress{
+		UserID:          userID,
+		VideoID:         videoID,
+		PositionSeconds: params.PositionSeconds,
+		DurationSeconds: params.DurationSeconds,
+		UpdatedAt:       cfg.clock.Now().UTC(),
+	}, watchProgressInterval)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save watch progress", err)
+		return
+	}
+	if !saved {
+		w.Header().Set("Retry-After", strconv.Itoa(max(1, int(math.Ceil(next.Sub(cfg.clock.Now()).Seconds())))))
+		respondWithError(w, http.StatusTooManyRequests, "Progress reported too often", nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerWatchProgressGet returns where the viewer left off in a video.
+func (cfg *apiConfig) handlerWatchProgressGet(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+	userID, ok := cfg.requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	progress, err := cfg.db.GetWatchProgress(userID, videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get watch progress", err)
+		return
+	}
+	if progress == nil {
+		respondWithError(w, http.StatusNotFound, "No watch progress for this video", nil)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, newWatchProgressResponse(*progress))
+}
+
+// handlerWatchHistory lists the videos the viewer has watched, most recently
+// watched first.
+func (cfg *apiConfig) handlerWatchHistory(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	history, err := cfg.db.GetWatchHistory(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get watch history", err)
+		return
+	}
+	resp := make([]watchProgressResponse, 0, len(history))
+	for _, p := range history {
+		resp = append(resp, newWatchProgressResponse(p))
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// handlerWatchHistoryClear deletes the viewer's whole watch history.
+func (cfg *apiConfig) handlerWatchHistoryClear(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := cfg.db.DeleteWatchHistory(userID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete watch history", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerWatchHistoryDelete removes one video from the viewer's watch
+// history.
+func (cfg *apiConfig) handlerWatchHistoryDelete(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+	userID, ok := cfg.requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	found, err := cfg.db.DeleteWatchProgress(userID, videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete watch history", err)
+		return
+	}
+	if !found {
+		respondWithError(w, http.StatusNotFound, "No watch progress for this video", nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
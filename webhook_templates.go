@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	// maxWebhookTemplateBytes is how long a payload template may be, and
+	// maxWebhookPayloadBytes how long what it renders may be.
+	maxWebhookTemplateBytes = 10 << 10
+	maxWebhookPayloadBytes  = 64 << 10
+
+	maxWebhookHeaders         = 10
+	maxWebhookHeaderValueSize = 1000
+)
+
+// headerNamePattern matches the token characters header names are made of.
+var headerNamePattern = regexp.MustCompile("^[A-Za-z0-9!#$%&'*+.^_`|~-]+$")
+
+// reservedWebhookHeaders are set by the server on every delivery, or by
+// the HTTP client, so webhooks can't set them; neither can they set any
+// X-Tubely- header.
+var reservedWebhookHeaders = map[string]bool{
+	"Host":              true,
+	"Content-Type":      true,
+	"Content-Length":    true,
+	"Content-Encoding":  true,
+	"Transfer-Encoding": true,
+	"Connection":        true,
+	"Keep-Alive":        true,
+	"Upgrade":           true,
+	"Te":                true,
+	"Trailer":           true,
+	"User-Agent":        true,
+}
+
+// webhookTemplateFuncs are the functions payload templates have besides
+// the built-in ones. json renders a value as JSON, so strings such as
+// titles can be put in a payload without breaking it.
+var webhookTemplateFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// webhookTemplateData is what payload templates are executed with: the
+// event's fields, its data as the JSON object delivered without a template
+// would have it, and the video it's about.
+type webhookTemplateData struct {
+	ID         string
+	Type       string
+	VideoID    string
+	OccurredAt string
+	Data       map[string]any
+	Video      webhookTemplateVideo
+}
+
+type webhookTemplateVideo struct {
+	ID          string
+	Title       string
+	Description string
+	URL         string
+}
+
+func (cfg *apiConfig) webhookTemplateData(e event, video database.Video) (webhookTemplateData, error) {
+	d := webhookTemplateData{
+		ID:         e.ID.String(),
+		Type:       e.Type,
+		VideoID:    e.VideoID.String(),
+		OccurredAt: e.OccurredAt.Format(time.RFC3339),
+		Data:       map[string]any{},
+		Video: webhookTemplateVideo{
+			ID:          video.ID.String(),
+			Title:       video.Title,
+			Description: video.Description,
+			URL:         cfg.videoPageURL(video.ID),
+		},
+	}
+	if e.Data != nil {
+		b, err := json.Marshal(e.Data)
+		if err != nil {
+			return webhookTemplateData{}, err
+		}
+		if err := json.Unmarshal(b, &d.Data); err != nil {
+			return webhookTemplateData{}, err
+		}
+	}
+	return d, nil
+}
+
+// renderWebhookPayload executes a payload template and checks it made a
+// JSON document of at most maxWebhookPayloadBytes.
+func renderWebhookPayload(tmpl string, data webhookTemplateData) ([]byte, error) {
+	t, err := template.New("payload").Funcs(webhookTemplateFuncs).Option("missingkey=zero").Parse(tmpl)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&limitedWriter{w: &buf, n: maxWebhookPayloadBytes}, data); err != nil {
+		return nil, err
+	}
+	if !json.Valid(buf.Bytes()) {
+		return nil, errors.New("payload template didn't render valid JSON")
+	}
+	return buf.Bytes(), nil
+}
+
+// limitedWriter fails writes past its first n bytes.
+type limitedWriter struct {
+	w *bytes.Buffer
+	n int
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if l.w.Len()+len(p) > l.n {
+		return 0, fmt.Errorf("payload is over %d bytes", l.n)
+	}
+	return l.w.Write(p)
+}
+
+// checkWebhookTemplate checks a payload template renders, by rendering it
+// for a made-up video.ready event.
+func (cfg *apiConfig) checkWebhookTemplate(tmpl string) error {
+	if len(tmpl) > maxWebhookTemplateBytes {
+		return fmt.Errorf("payload_template is over %d bytes", maxWebhookTemplateBytes)
+	}
+	id := uuid.New()
+	data, err := cfg.webhookTemplateData(event{
+		ID:         uuid.New(),
+		Type:       eventVideoReady,
+		VideoID:    id,
+		OccurredAt: cfg.clock.Now().UTC(),
+	}, database.Video{ID: id, CreateVideoParams: database.CreateVideoParams{Title: `Sample "video"`}})
+	if err != nil {
+		return err
+	}
+	_, err = renderWebhookPayload(tmpl, data)
+	return err
+}
+
+// parseWebhookHeaders checks the custom headers of a webhook and returns
+// them with canonical names.
+func parseWebhookHeaders(headers map[string]string) (map[string]string, error) {
+	if len(headers) > maxWebhookHeaders {
+		return nil, fmt.Errorf("a webhook can have at most %d headers", maxWebhookHeaders)
+	}
+	parsed := make(map[string]string, len(headers))
+	for name, value := range headers {
+		if !headerNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid header name %q", name)
+		}
+		name = http.CanonicalHeaderKey(name)
+		if reservedWebhookHeaders[name] || strings.HasPrefix(name, "X-Tubely-") {
+			return nil, fmt.Errorf("header %s is set by the server", name)
+		}
+		if _, ok := parsed[name]; ok {
+			return nil, fmt.Errorf("header %s is given twice", name)
+		}
+		if len(value) > maxWebhookHeaderValueSize {
+			return nil, fmt.Errorf("header %s is over %d bytes", name, maxWebhookHeaderValueSize)
+		}
+		if strings.ContainsAny(value, "\r\n\x00") {
+			return nil, fmt.Errorf("header %s has a line break in it", name)
+		}
+		parsed[name] = strings.TrimSpace(value)
+	}
+	return parsed, nil
+}
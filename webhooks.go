@@ -0,0 +1,471 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Lifecycle events users can have delivered to their webhooks.
+const (
+	// eventVideoUploaded is emitted once the server has the whole file of
+	// an upload, before it's processed.
+	eventVideoUploaded = "video.uploaded"
+	// eventVideoProcessingFailed and eventVideoReady are emitted when an
+	// upload's processing ends.
+	eventVideoProcessingFailed = "video.processing_failed"
+	eventVideoReady            = "video.ready"
+	eventThumbnailUpdated      = "thumbnail.updated"
+)
+
+// webhookEvents are the events webhooks can subscribe to.
+var webhookEvents = map[string]bool{
+	eventVideoUploaded:         true,
+	eventVideoProcessingFailed: true,
+	eventVideoReady:            true,
+	eventThumbnailUpdated:      true,
+	eventPremiereStarted:       true,
+	eventRenditionReady:        true,
+	eventRenditionFailed:       true,
+}
+
+var webhookURLLimit = textLimit{field: "url", maxRunes: 2000, maxBytes: 2000, required: true}
+
+const (
+	maxWebhooksPerUser = 10
+	// webhookTimeout is how long a receiver has to answer a delivery.
+	webhookTimeout = 10 * time.Second
+	// A failed delivery is retried after webhookRetryDelay, doubling after
+	// each attempt, until it has been tried webhookMaxAttempts times: a
+	// little over four hours in all.
+	webhookRetryDelay  = 30 * time.Second
+	webhookMaxAttempts = 10
+	// webhookDispatchInterval is how often the dispatcher looks for
+	// deliveries that are due to be retried. New events wake it at once.
+	webhookDispatchInterval = 5 * time.Second
+	webhookDispatchBatch    = 100
+	webhookDispatchParallel = 4
+	// webhookDeliveryHistory is how many deliveries of a webhook are listed.
+	webhookDeliveryHistory = 50
+)
+
+var errPrivateHost = errors.New("host is not a public address")
+
+// publicAddressControl is a net.Dialer Control that refuses to connect to
+// loopback, private or link-local addresses, for clients that fetch URLs
+// users give the server.
+func publicAddressControl(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	if ip = ip.Unmap(); !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return errPrivateHost
+	}
+	return nil
+}
+
+// webhookDispatcher sends webhook deliveries from the background. Its
+// client won't connect to loopback, private or link-local addresses, so a
+// webhook can't be pointed at the server's own network, unless
+// WEBHOOK_ALLOW_PRIVATE_HOSTS is set for local development.
+type webhookDispatcher struct {
+	client *http.Client
+	wake   chan struct{}
+}
+
+func newWebhookDispatcher(allowPrivateHosts bool) *webhookDispatcher {
+	dialer := &net.Dialer{Timeout: webhookTimeout}
+	if !allowPrivateHosts {
+		dialer.Control = publicAddressControl
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// A proxy would make the connection on our behalf, past the check.
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &webhookDispatcher{
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   webhookTimeout,
+			// A redirect counts as a failure rather than being followed
+			// somewhere the webhook's owner didn't register.
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		wake: make(chan struct{}, 1),
+	}
+}
+
+// notify wakes the dispatcher, if it isn't awake already.
+func (d *webhookDispatcher) notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// queueWebhooks queues the event, whose JSON is payload, for the webhooks
+// of the video's owner that are subscribed to it. Webhooks with a payload
+// template get what it renders instead, rendered now so retries send the
+// same body. Deliveries are best effort, so failing to queue them, or to
+// render a template, is only logged.
+func (cfg *apiConfig) queueWebhooks(e event, payload []byte) {
+	if !webhookEvents[e.Type] {
+		return
+	}
+	video, err := cfg.db.GetVideo(e.VideoID)
+	if err != nil || video.ID == uuid.Nil {
+		log.Printf("Couldn't get video %s for webhooks of event %s: %v", e.VideoID, e.ID, err)
+		return
+	}
+	webhooks, err := cfg.db.GetWebhooks(video.UserID)
+	if err != nil {
+		log.Printf("Couldn't get webhooks of user %s: %v", video.UserID, err)
+		return
+	}
+	deliveries := []database.WebhookDelivery{}
+	for _, webhook := range webhooks {
+		if !webhook.Wants(e.Type) {
+			continue
+		}
+		body := payload
+		if webhook.PayloadTemplate != "" {
+			data, err := cfg.webhookTemplateData(e, video)
+			if err == nil {
+				body, err = renderWebhookPayload(webhook.PayloadTemplate, data)
+			}
+			if err != nil {
+				log.Printf("Couldn't render payload of webhook %s for event %s: %v", webhook.ID, e.ID, err)
+				continue
+			}
+		}
+		deliveries = append(deliveries, database.WebhookDelivery{
+			ID:            uuid.New(),
+			WebhookID:     webhook.ID,
+			EventType:     e.Type,
+			Payload:       string(body),
+			Status:        database.WebhookDeliveryPending,
+			CreatedAt:     e.OccurredAt,
+			NextAttemptAt: e.OccurredAt,
+		})
+	}
+	if len(deliveries) == 0 {
+		return
+	}
+	if err := cfg.db.CreateWebhookDeliveries(deliveries); err != nil {
+		log.Printf("Couldn't queue webhook deliveries of event %s: %v", e.ID, err)
+		return
+	}
+	cfg.webhooks.notify()
+}
+
+// runWebhookDispatcher sends due webhook deliveries until ctx is done.
+func (cfg *apiConfig) runWebhookDispatcher(ctx context.Context) {
+	ticker := time.NewTicker(webhookDispatchInterval)
+	defer ticker.Stop()
+	for {
+		cfg.dispatchWebhooks(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-cfg.webhooks.wake:
+		}
+	}
+}
+
+// dispatchWebhooks sends the deliveries that are due, a few at a time.
+func (cfg *apiConfig) dispatchWebhooks(ctx context.Context) {
+	for ctx.Err() == nil {
+		deliveries, err := cfg.db.GetDueWebhookDeliveries(cfg.clock.Now().UTC(), webhookDispatchBatch)
+		if err != nil {
+			log.Printf("Couldn't get due webhook deliveries: %v", err)
+			return
+		}
+		webhooks := map[uuid.UUID]*database.Webhook{}
+		sem := make(chan struct{}, webhookDispatchParallel)
+		var wg sync.WaitGroup
+		for _, delivery := range deliveries {
+			webhook, ok := webhooks[delivery.WebhookID]
+			if !ok {
+				webhook, err = cfg.db.GetWebhook(delivery.WebhookID)
+				if err != nil {
+					log.Printf("Couldn't get webhook %s: %v", delivery.WebhookID, err)
+					continue
+				}
+				webhooks[delivery.WebhookID] = webhook
+			}
+			if webhook == nil {
+				// Deleted since the batch was read, deliveries and all.
+				continue
+			}
+			sem <- struct{}{}
+			wg.Add(1)
+			go func() {
+				defer func() { <-sem; wg.Done() }()
+				cfg.deliverWebhook(ctx, *webhook, delivery)
+			}()
+		}
+		wg.Wait()
+		// Whatever failed was rescheduled, so a full batch means there may
+		// be more that are due.
+		if len(deliveries) < webhookDispatchBatch {
+			return
+		}
+	}
+}
+
+// deliverWebhook makes one attempt at a delivery and records how it went.
+// A 2xx response is a success; anything else is retried with exponential
+// backoff until the delivery runs out of attempts.
+func (cfg *apiConfig) deliverWebhook(ctx context.Context, webhook database.Webhook, d database.WebhookDelivery) {
+	err := cfg.postWebhook(ctx, webhook, d)
+	if ctx.Err() != nil {
+		// Shutting down; the attempt doesn't count.
+		return
+	}
+	now := cfg.clock.Now().UTC()
+	d.Attempts++
+	switch {
+	case err == nil:
+		d.Status, d.LastError, d.DeliveredAt = database.WebhookDeliveryDelivered, "", &now
+	case d.Attempts >= webhookMaxAttempts:
+		d.Status, d.LastError = database.WebhookDeliveryFailed, err.Error()
+		log.Printf("Giving up on webhook delivery %s to %s after %d attempts: %v", d.ID, webhook.URL, d.Attempts, err)
+	default:
+		d.LastError = err.Error()
+		d.NextAttemptAt = now.Add(webhookRetryDelay << (d.Attempts - 1))
+	}
+	if err := cfg.db.UpdateWebhookDelivery(d); err != nil {
+		log.Printf("Couldn't update webhook delivery %s: %v", d.ID, err)
+	}
+}
+
+// postWebhook POSTs a delivery's payload, signed the same way as cache
+// webhooks: X-Tubely-Timestamp is the Unix time of the attempt and
+// X-Tubely-Signature is cacheWebhookSignature of it and the body, keyed
+// with the webhook's secret. X-Tubely-Delivery is the same on every attempt
+// of a delivery, so a receiver can drop the ones it has already handled.
+// The webhook's own headers go first, so they can't replace these.
+func (cfg *apiConfig) postWebhook(ctx context.Context, webhook database.Webhook, d database.WebhookDelivery) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, strings.NewReader(d.Payload))
+	if err != nil {
+		return err
+	}
+	for name, value := range webhook.Headers {
+		req.Header.Set(name, value)
+	}
+	timestamp := strconv.FormatInt(cfg.clock.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Tubely-Webhooks/1")
+	req.Header.Set("X-Tubely-Event", d.EventType)
+	req.Header.Set("X-Tubely-Delivery", d.ID.String())
+	req.Header.Set("X-Tubely-Timestamp", timestamp)
+	req.Header.Set("X-Tubely-Signature", cacheWebhookSignature([]byte(webhook.Secret), timestamp, []byte(d.Payload)))
+
+	resp, err := cfg.webhooks.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("receiver responded %s", resp.Status)
+	}
+	return nil
+}
+
+// parseWebhookURL checks that s is an absolute http or https URL without
+// credentials, which would be sent to whoever the host turns out to be.
+func parseWebhookURL(s string) (string, error) {
+	s, err := webhookURLLimit.apply(s)
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil {
+		return "", errors.New("url must be an absolute http or https URL without credentials")
+	}
+	return u.String(), nil
+}
+
+// handlerWebhookCreate registers a webhook for the user. Its secret is
+// only ever in this response.
+func (cfg *apiConfig) handlerWebhookCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		URL             string            `json:"url"`
+		Events          []string          `json:"events"`
+		PayloadTemplate string            `json:"payload_template"`
+		Headers         map[string]string `json:"headers"`
+	}
+	type response struct {
+		database.Webhook
+		Secret string `json:"secret"`
+	}
+
+	userID, ok := cfg.requireUser(w, r)
+	if !ok {
+		return
+	}
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	webhookURL, err := parseWebhookURL(params.URL)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid webhook URL", err)
+		return
+	}
+	events := []string{}
+	seen := map[string]bool{}
+	for _, e := range params.Events {
+		if !webhookEvents[e] {
+			respondWithError(w, http.StatusBadRequest, "Unknown webhook event", fmt.Errorf("unknown event %q", e))
+			return
+		}
+		if !seen[e] {
+			seen[e] = true
+			events = append(events, e)
+		}
+	}
+	if params.PayloadTemplate != "" {
+		if err := cfg.checkWebhookTemplate(params.PayloadTemplate); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid payload template", err)
+			return
+		}
+	}
+	headers, err := parseWebhookHeaders(params.Headers)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid webhook header", err)
+		return
+	}
+
+	existing, err := cfg.db.GetWebhooks(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get webhooks", err)
+		return
+	}
+	if len(existing) >= maxWebhooksPerUser {
+		respondWithError(w, http.StatusConflict, "Too many webhooks", fmt.Errorf("user has %d webhooks already", len(existing)))
+		return
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create webhook", err)
+		return
+	}
+	webhook := database.Webhook{
+		ID:              uuid.New(),
+		UserID:          userID,
+		URL:             webhookURL,
+		Secret:          "whsec_" + hex.EncodeToString(secret),
+		Events:          events,
+		PayloadTemplate: params.PayloadTemplate,
+		Headers:         headers,
+		CreatedAt:       cfg.clock.Now().UTC(),
+	}
+	for name := range headers {
+		webhook.HeaderNames = append(webhook.HeaderNames, name)
+	}
+	sort.Strings(webhook.HeaderNames)
+	if err := cfg.db.CreateWebhook(webhook); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save webhook", err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, response{Webhook: webhook, Secret: webhook.Secret})
+}
+
+// handlerWebhooksGet lists the user's webhooks.
+func (cfg *apiConfig) handlerWebhooksGet(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.requireUser(w, r)
+	if !ok {
+		return
+	}
+	webhooks, err := cfg.db.GetWebhooks(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get webhooks", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, webhooks)
+}
+
+// requireOwnWebhook returns the webhook in the path if it belongs to the
+// user. If ok is false, an error response has been written.
+func (cfg *apiConfig) requireOwnWebhook(w http.ResponseWriter, r *http.Request) (webhook *database.Webhook, ok bool) {
+	userID, ok := cfg.requireUser(w, r)
+	if !ok {
+		return nil, false
+	}
+	webhookID, err := uuid.Parse(r.PathValue("webhookID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid webhook ID", err)
+		return nil, false
+	}
+	webhook, err = cfg.db.GetWebhook(webhookID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get webhook", err)
+		return nil, false
+	}
+	if webhook == nil || webhook.UserID != userID {
+		respondWithError(w, http.StatusNotFound, "Webhook not found", nil)
+		return nil, false
+	}
+	return webhook, true
+}
+
+// handlerWebhookDeliveriesGet lists a webhook's latest deliveries and how
+// they went, for debugging a receiver.
+func (cfg *apiConfig) handlerWebhookDeliveriesGet(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := cfg.requireOwnWebhook(w, r)
+	if !ok {
+		return
+	}
+	deliveries, err := cfg.db.GetWebhookDeliveries(webhook.ID, webhookDeliveryHistory)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get webhook deliveries", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, deliveries)
+}
+
+// handlerWebhookDelete deletes one of the user's webhooks. Its pending
+// deliveries are dropped.
+func (cfg *apiConfig) handlerWebhookDelete(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := cfg.requireOwnWebhook(w, r)
+	if !ok {
+		return
+	}
+	if err := cfg.db.DeleteWebhook(webhook.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete webhook", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}